@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func adoptCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "adopt [VM_NAME]",
+		Short: "Prepare a VM created in Virtualization Station for qnap-vm",
+		Long: `Inspect a VM created outside qnap-vm, such as in the Virtualization Station
+web UI, and bring its definition in line with the VMs qnap-vm creates.
+
+Each disk image is read with qemu-img, so .img disks whose definition states
+the wrong format (or none) are declared with their real one, and a guest agent
+channel is added when the VM has none. Bridge interfaces are checked against
+the NAS's virtual switches. Disks and interfaces are otherwise left as they
+are. Changes take effect the next time the VM starts.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+			vmName := args[0]
+			asJSON, _ := cmd.Flags().GetBool("json")
+
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			storageManager := newStorageManager(sshClient, cfg)
+			adoption, err := virshClient.InspectForAdoption(vmName, func(path string) (string, error) {
+				info, err := storageManager.GetImageInfo(path)
+				if err != nil {
+					return "", err
+				}
+				return info.Format, nil
+			})
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(adoption)
+			}
+			printAdoption(adoption)
+
+			changes := adoption.Changes()
+			if len(changes) == 0 {
+				statusf("\nVM '%s' needs no changes.\n", vmName)
+				return nil
+			}
+			fmt.Println()
+			confirmed, err := confirm(fmt.Sprintf("Apply these changes to VM '%s' on %s? (y/N): ", vmName, cfg.Label()))
+			if err != nil {
+				return err
+			}
+			if !confirmed {
+				messages.Println(messages.Cancelled)
+				return nil
+			}
+
+			if err := virshClient.Adopt(adoption); err != nil {
+				return err
+			}
+			statusf("VM '%s' adopted; the changes take effect the next time it starts.\n", vmName)
+			if !adoption.GuestAgent {
+				statusln("Install qemu-guest-agent in the guest to use the new agent channel.")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("json", false, "Print the inspection as JSON without changing the VM")
+	return cmd
+}
+
+// printAdoption shows a VM's disks and interfaces with the changes adoption makes
+func printAdoption(adoption *virsh.Adoption) {
+	fmt.Printf("%-8s %-8s %-22s %s\n", "DISK", "BUS", "FORMAT", "SOURCE")
+	fmt.Printf("%-8s %-8s %-22s %s\n", "--------", "--------", "----------------------", "------")
+	for _, disk := range adoption.Disks {
+		format := disk.Actual
+		switch {
+		case disk.Block:
+			format = "block device"
+		case disk.FormatMismatch():
+			format = fmt.Sprintf("%s (declared %s)", disk.Actual, orDash(disk.Declared))
+		case format == "":
+			format = orDash(disk.Declared)
+		}
+		fmt.Printf("%-8s %-8s %-22s %s\n", disk.Target, orDash(disk.Bus), format, orDash(disk.Path))
+	}
+
+	if len(adoption.NICs) > 0 {
+		fmt.Println()
+		fmt.Printf("%-18s %-8s %-16s %s\n", "INTERFACE", "TYPE", "SOURCE", "MODEL")
+		fmt.Printf("%-18s %-8s %-16s %s\n", "------------------", "--------", "----------------", "-----")
+		for _, nic := range adoption.NICs {
+			source := nic.Source
+			if nic.Missing {
+				source += " (missing)"
+			}
+			fmt.Printf("%-18s %-8s %-16s %s\n", orDash(nic.MAC), nic.Type, source, orDash(nic.Model))
+		}
+	}
+
+	if changes := adoption.Changes(); len(changes) > 0 {
+		fmt.Println("\nChanges:")
+		for _, change := range changes {
+			fmt.Printf("  - %s\n", change)
+		}
+	}
+	if notes := adoption.Notes(); len(notes) > 0 {
+		fmt.Println("\nNotes:")
+		for _, note := range notes {
+			fmt.Printf("  - %s\n", note)
+		}
+	}
+}
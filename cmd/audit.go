@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/inventory"
+	"github.com/spf13/cobra"
+)
+
+func auditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Report drift between what qnap-vm recorded and the NAS",
+		Long: `Compare the VMs and snapshots qnap-vm last recorded for the host (by 'list',
+'status' and 'snapshot list') and the backup schedules in the config with what
+is actually on the NAS, and report the differences:
+
+  missing-vm           recorded VM no longer defined
+  unmanaged-vm         VM defined outside qnap-vm since it last looked
+  changed-vm           memory, vCPUs or tags differ from the record
+  missing-snapshot     recorded snapshot no longer present
+  unrecorded-snapshot  snapshot taken outside qnap-vm
+  orphaned-disk        disk image in a qnap-vm disk directory no VM uses
+  schedule-missing-vm  backup schedule for a VM that does not exist
+
+--accept records the NAS's current state as the new baseline once the drift has
+been reviewed. Nothing on the NAS is changed.`,
+		Example: `  qnap-vm audit
+  qnap-vm audit --json | jq '.[] | select(.kind == "unmanaged-vm")'
+  qnap-vm audit --accept`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+			accept, _ := cmd.Flags().GetBool("accept")
+
+			inv, err := loadInventory(cfg)
+			if err != nil {
+				return err
+			}
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			actual := inventory.Actual{Snapshots: make(map[string][]string)}
+			if actual.VMs, err = virshClient.ListVMsWithMetadata(); err != nil {
+				return fmt.Errorf("failed to list VMs: %w", err)
+			}
+
+			// Only VMs whose snapshots were recorded can be compared
+			storageManager := newStorageManager(sshClient, cfg)
+			defined := make(map[string]bool)
+			for _, vm := range actual.VMs {
+				defined[vm.Name] = true
+			}
+			listings := make(map[string]inventory.SnapshotList)
+			for vmName := range inv.Snapshots {
+				if !defined[vmName] {
+					continue
+				}
+				snapshots, err := virshClient.ListSnapshots(vmName)
+				if err != nil {
+					return fmt.Errorf("failed to list snapshots of VM '%s': %w", vmName, err)
+				}
+				listing := inventory.SnapshotList{Updated: time.Now(), Snapshots: snapshots}
+				if dataset := vmDataset(storageManager, virshClient, vmName); dataset != "" {
+					if listing.Dataset, err = storageManager.ListDatasetSnapshots(dataset); err != nil {
+						return err
+					}
+				}
+				listings[vmName] = listing
+
+				var names []string
+				for _, snapshot := range listing.Snapshots {
+					names = append(names, snapshot.Name)
+				}
+				for _, snapshot := range listing.Dataset {
+					names = append(names, snapshot.Name)
+				}
+				actual.Snapshots[vmName] = names
+			}
+
+			orphans, _, err := findGCCandidates(sshClient, virshClient, storageManager)
+			if err != nil {
+				return err
+			}
+			actual.Orphans = orphans
+
+			for _, schedule := range cfg.Backups {
+				actual.ScheduledVMs = append(actual.ScheduledVMs, schedule.VM)
+			}
+
+			if accept {
+				inv.SetVMs(actual.VMs, time.Now())
+				for vmName, listing := range listings {
+					inv.SetSnapshots(vmName, listing)
+				}
+				if err := inv.Save(); err != nil {
+					return err
+				}
+				statusf("Recorded %d VM(s) on %s as the audit baseline\n", len(actual.VMs), cfg.Label())
+				return nil
+			}
+
+			drift, err := inv.Audit(actual)
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				if drift == nil {
+					drift = []inventory.Drift{}
+				}
+				data, err := json.MarshalIndent(drift, "", "  ")
+				if err != nil {
+					return fmt.Errorf("failed to encode drift: %w", err)
+				}
+				fmt.Println(string(data))
+				return nil
+			}
+
+			fmt.Printf("Audit of %s against the state recorded %s\n\n", cfg.Label(), inventory.Age(inv.VMs.Updated, time.Now()))
+			if len(drift) == 0 {
+				fmt.Println("No drift found.")
+				return nil
+			}
+			fmt.Printf("%-20s %-30s %-20s %s\n", "KIND", "OBJECT", "EXPECTED", "ACTUAL")
+			fmt.Printf("%-20s %-30s %-20s %s\n", "----", "------", "--------", "------")
+			for _, d := range drift {
+				fmt.Printf("%-20s %-30s %-20s %s\n", d.Kind, d.Object, d.Expected, d.Actual)
+			}
+			fmt.Printf("\n%d difference(s) found\n", len(drift))
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("json", false, "Output the drift as JSON")
+	cmd.Flags().Bool("accept", false, "Record the NAS's current state as the new baseline")
+	return cmd
+}
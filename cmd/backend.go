@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/inventory"
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/qvs"
+	"github.com/scttfrdmn/qnap-vm/pkg/server"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+)
+
+// vmBackend is what the commands that work with every backend need from a host.
+// *virsh.Client provides it over SSH and *qvs.Client over the Virtualization Station API.
+type vmBackend interface {
+	server.Backend
+	ListSnapshots(vmName string) ([]virsh.SnapshotInfo, error)
+	CreateSnapshot(vmName, snapshotName, description string) error
+	RestoreSnapshot(vmName, snapshotName string) error
+	DeleteSnapshot(vmName, snapshotName string) error
+}
+
+// usesAPI reports whether the host is managed through the Virtualization Station API
+func usesAPI(cfg *config.Config) bool {
+	return cfg.Backend == config.BackendAPI
+}
+
+// errNeedsSSH is returned by commands that need a shell on a host with backend: api
+func errNeedsSSH(cfg config.Config) error {
+	return fmt.Errorf("host %s uses the Virtualization Station API (backend: api), which only supports list, start, stop, snapshot and serve; this command needs SSH", cfg.Label())
+}
+
+// connectBackend connects to the host with its configured backend. The returned
+// function closes the connection.
+func connectBackend(cfg config.Config) (vmBackend, func(), error) {
+	if usesAPI(&cfg) {
+		client, err := connectAPI(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return client, func() {
+			if err := client.Logout(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to log out of Virtualization Station: %v\n", err)
+			}
+		}, nil
+	}
+
+	sshClient, virshClient, err := connectToQNAP(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return virshClient, func() {
+		if err := sshClient.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+		}
+	}, nil
+}
+
+// connectAPI logs in to the host's Virtualization Station API, asking for the password
+// when none is configured and someone is at the terminal
+func connectAPI(cfg config.Config) (*qvs.Client, error) {
+	apiURL := cfg.APIURL
+	if apiURL == "" {
+		apiURL = "https://" + cfg.Host
+	}
+
+	var entered string
+	password := cfg.Password
+	if password == "" {
+		if prompt := passwordPrompt(cfg.Username, cfg.Host, &entered); prompt != nil {
+			var err error
+			if password, err = prompt(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sessionTranscript.Redact(password)
+	redactLog(password)
+
+	client, err := qvs.NewClient(qvs.Config{
+		URL:         apiURL,
+		Username:    cfg.Username,
+		Password:    password,
+		Fingerprint: cfg.APIFingerprint,
+		Timeout:     30 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w\n%s", err, cfg.Describe())
+	}
+	client.SetContext(commandCtx)
+
+	if err := client.Login(); err != nil {
+		var certErr *qvs.CertificateError
+		if errors.As(err, &certErr) && cfg.HostName != "" {
+			err = fmt.Errorf("%w\nCompare the fingerprint with the certificate in QTS (Control Panel > Security > SSL Certificate),\nthen trust it with 'qnap-vm config set --name %s --api-fingerprint %s'", err, cfg.HostName, certErr.Fingerprint)
+		}
+		return nil, messages.Errorf(messages.ConnectFailed, cfg.Label(), err, cfg.Describe())
+	}
+	offerToSavePassword(cfg, entered)
+	return client, nil
+}
+
+// listVMsAPI lists the VMs of a host with backend: api
+func listVMsAPI(cfg *config.Config) error {
+	backend, closeBackend, err := connectBackend(*cfg)
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	vms, err := backend.ListVMs()
+	if err != nil {
+		return err
+	}
+	updateInventory(cfg, func(inv *inventory.Inventory, now time.Time) {
+		inv.SetVMs(vms, now)
+	})
+	printVMList(vms)
+	return nil
+}
+
+// listSnapshotsAPI lists a VM's snapshots on a host with backend: api
+func listSnapshotsAPI(cfg *config.Config, vmName string) error {
+	backend, closeBackend, err := connectBackend(*cfg)
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	snapshots, err := backend.ListSnapshots(vmName)
+	if err != nil {
+		return err
+	}
+	listing := inventory.SnapshotList{Updated: time.Now(), Snapshots: snapshots}
+	for _, snapshot := range snapshots {
+		if snapshot.Current {
+			listing.Current = snapshot.Name
+		}
+	}
+	updateInventory(cfg, func(inv *inventory.Inventory, _ time.Time) {
+		inv.SetSnapshots(vmName, listing)
+	})
+	printSnapshotList(vmName, &listing)
+	return nil
+}
+
+// createSnapshotAPI snapshots a VM on a host with backend: api. Virtualization Station
+// chooses the snapshot type, so there is no ZFS or space check.
+func createSnapshotAPI(cfg *config.Config, vmName, snapshotName, description string) error {
+	backend, closeBackend, err := connectBackend(*cfg)
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	statusf("Creating snapshot '%s' for VM '%s'...\n", snapshotName, vmName)
+	if err := backend.CreateSnapshot(vmName, snapshotName, description); err != nil {
+		return err
+	}
+	messages.Println(messages.SnapshotCreated, snapshotName)
+	return nil
+}
+
+// restoreSnapshotAPI reverts a VM to a snapshot on a host with backend: api
+func restoreSnapshotAPI(cfg *config.Config, vmName, snapshotName string, force bool) error {
+	backend, closeBackend, err := connectBackend(*cfg)
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	if !force {
+		fmt.Printf("⚠️  WARNING: Restoring VM '%s' on %s to snapshot '%s' will lose all changes made after the snapshot.\n", vmName, cfg.Label(), snapshotName)
+		confirmed, err := confirm("Are you sure you want to continue? (y/N): ")
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			messages.Println(messages.Cancelled)
+			return nil
+		}
+	}
+
+	statusf("Restoring VM '%s' to snapshot '%s'...\n", vmName, snapshotName)
+	if err := backend.RestoreSnapshot(vmName, snapshotName); err != nil {
+		return err
+	}
+	statusf("VM '%s' restored to snapshot '%s' successfully\n", vmName, snapshotName)
+	return nil
+}
+
+// deleteSnapshotAPI deletes a VM's snapshot on a host with backend: api
+func deleteSnapshotAPI(cfg *config.Config, vmName, snapshotName string, force bool) error {
+	backend, closeBackend, err := connectBackend(*cfg)
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	if !force {
+		confirmed, err := confirm(fmt.Sprintf("Are you sure you want to delete snapshot '%s' from VM '%s' on %s? (y/N): ", snapshotName, vmName, cfg.Label()))
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			messages.Println(messages.Cancelled)
+			return nil
+		}
+	}
+
+	if err := backend.DeleteSnapshot(vmName, snapshotName); err != nil {
+		return err
+	}
+	messages.Println(messages.SnapshotDeleted, snapshotName)
+	return nil
+}
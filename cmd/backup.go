@@ -0,0 +1,973 @@
+package cmd
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/backup"
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/scttfrdmn/qnap-vm/pkg/storage"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func backupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Back up virtual machines",
+		Long: `Copy VM disk images and configuration into self-contained backup sets.
+
+Each set is a directory named VM-YYYYMMDD-HHMMSS holding the disk images,
+domain.xml and a manifest.json with checksums. The manifest is written last,
+so a set without one is incomplete.
+
+Sets can be kept on the NAS, on this machine (--local), or in S3-compatible
+object storage such as AWS S3, MinIO or Backblaze B2 (--dest s3://bucket/prefix).
+S3 credentials come from the host's config ('qnap-vm config set --s3-*') or the
+AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_REGION and AWS_ENDPOINT_URL
+environment variables.`,
+	}
+
+	createCmd := &cobra.Command{
+		Use:   "create [VM_NAME]",
+		Short: "Create a full backup of a VM",
+		Long: `Back up a VM's disks and configuration to a directory on the NAS, on
+this machine with --local, or to S3-compatible object storage with an s3:// --dest.
+
+S3 uploads stream each image from the NAS through this machine without a local
+copy, optionally gzip-compressed on the NAS (--compress). With --via-nas the NAS
+uploads the images itself with curl, using presigned URLs so the credentials
+stay on this machine.
+
+A running VM keeps running: its writes go to temporary overlay files while the
+disk images are copied, and are merged back afterwards. Filesystems are frozen
+through the guest agent when it is available. VMs on a ZFS dataset are copied
+from a ZFS snapshot instead. Use --pause to suspend the VM for the copy instead.
+
+With --incremental, a running VM is backed up by a libvirt backup job that
+records a checkpoint (a qcow2 dirty bitmap). The next --incremental backup to
+the same --dest then copies only the blocks changed since that checkpoint and
+records the earlier set as its parent. Only the newest checkpoint is kept, so
+each VM has one incremental chain; a full backup is taken whenever the chain
+cannot be continued. Requires libvirt 6.0 or later.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vmName := args[0]
+			dest, _ := cmd.Flags().GetString("dest")
+			local, _ := cmd.Flags().GetBool("local")
+			pause, _ := cmd.Flags().GetBool("pause")
+			estimate, _ := cmd.Flags().GetBool("estimate")
+			incremental, _ := cmd.Flags().GetBool("incremental")
+			compress, _ := cmd.Flags().GetBool("compress")
+			viaNAS, _ := cmd.Flags().GetBool("via-nas")
+			noProgress := progressDisabled(cmd)
+
+			if (compress || viaNAS) && !isS3URL(dest) {
+				return fmt.Errorf("--compress and --via-nas need an s3:// destination")
+			}
+			if compress && viaNAS {
+				return fmt.Errorf("--compress cannot be combined with --via-nas")
+			}
+
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			destination, err := newBackupDestination(sshClient, cfg, dest, local)
+			if err != nil {
+				return err
+			}
+			if s3Destination, ok := destination.(*backup.S3Destination); ok {
+				s3Destination.ViaNAS = viaNAS
+				if compress {
+					s3Destination.Compression = backup.CompressionGzip
+				}
+			}
+
+			options := backupOptions{dest: dest, pause: pause, estimate: estimate, incremental: incremental}
+			options.transfer = ssh.TransferOptions{Verify: true}
+			if !noProgress {
+				options.transfer.Progress = os.Stderr
+			}
+
+			_, err = runBackup(cmd, cfg, sshClient, virshClient, destination, vmName, options)
+			return err
+		},
+	}
+
+	createCmd.Flags().String("dest", "", "Backup directory on the NAS (e.g. /share/Backups), on this machine with --local, or s3://bucket/prefix (required)")
+	createCmd.Flags().Bool("local", false, "Write the backup to --dest on this machine, downloading disks over SFTP")
+	createCmd.Flags().Bool("compress", false, "Gzip disk images on the NAS while streaming them to S3")
+	createCmd.Flags().Bool("via-nas", false, "Upload disk images to S3 from the NAS with curl instead of through this machine")
+	createCmd.Flags().Bool("pause", false, "Suspend a running VM while its disks are copied instead of using overlays")
+	createCmd.Flags().Bool("incremental", false, "Copy only blocks changed since the last backup in --dest (running VMs; full backup when there is none)")
+	createCmd.Flags().Bool("estimate", false, "Estimate the backup's space and time and confirm before starting")
+	createCmd.Flags().Bool("no-progress", false, "Disable the progress bar for --local downloads and S3 uploads")
+	addSpaceCheckFlag(createCmd)
+	if err := createCmd.MarkFlagRequired("dest"); err != nil {
+		// Flag is registered above; marking cannot fail
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list [VM_NAME]",
+		Short: "List the backup sets at a destination",
+		Long: `List the complete backup sets at --dest, oldest first, optionally only those
+of one VM. Sets without a manifest are still being written, or failed, and are
+not shown.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dest, _ := cmd.Flags().GetString("dest")
+			local, _ := cmd.Flags().GetBool("local")
+			vmName := ""
+			if len(args) > 0 {
+				vmName = args[0]
+			}
+
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			// Only sets on the NAS need a connection to it
+			var sshClient *ssh.Client
+			if !local && !isS3URL(dest) {
+				sshClient, _, err = connectToQNAP(*cfg)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := sshClient.Close(); err != nil {
+						fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+					}
+				}()
+			}
+
+			destination, err := newBackupDestination(sshClient, cfg, dest, local)
+			if err != nil {
+				return err
+			}
+			manifests, err := backup.ReadManifests(destination, vmName)
+			if err != nil {
+				return err
+			}
+
+			if len(manifests) == 0 {
+				fmt.Printf("No backups found in %s\n", dest)
+				return nil
+			}
+
+			fmt.Printf("%-35s %-20s %-20s %-8s %-6s %-10s %s\n", "NAME", "VM", "CREATED", "METHOD", "DISKS", "SIZE", "PARENT")
+			fmt.Printf("%-35s %-20s %-20s %-8s %-6s %-10s %s\n", "----", "--", "-------", "------", "-----", "----", "------")
+			for _, manifest := range manifests {
+				parent := manifest.Parent
+				if parent == "" {
+					parent = "-"
+				}
+				fmt.Printf("%-35s %-20s %-20s %-8s %-6d %-10s %s\n",
+					manifest.Name, manifest.VM, manifest.Created.Local().Format("2006-01-02 15:04:05"),
+					manifest.Method, len(manifest.Disks), formatBytes(manifest.TotalSize()), parent)
+			}
+			return nil
+		},
+	}
+
+	listCmd.Flags().String("dest", "", "Backup directory on the NAS, on this machine with --local, or s3://bucket/prefix (required)")
+	listCmd.Flags().Bool("local", false, "List backups in --dest on this machine")
+	if err := listCmd.MarkFlagRequired("dest"); err != nil {
+		// Flag is registered above; marking cannot fail
+	}
+
+	restoreCmd := &cobra.Command{
+		Use:   "restore SET",
+		Short: "Restore a VM from a backup set",
+		Long: `Copy a backup set's disk images into a storage pool and define the VM from
+its saved configuration. An incremental set is restored together with the sets
+it builds on, and merged into standalone images.
+
+The VM keeps its original name unless --as gives a new one. If the original VM
+still exists on this NAS, the restored copy gets a new UUID and MAC addresses so
+both can run side by side. The restored VM is not started.`,
+		Example: `  qnap-vm backup restore web-20260304-050607 --dest /share/Backups
+  qnap-vm backup restore web-20260304-050607 --dest s3://backups/nas1 --as web-test --pool DataVol2`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			set := args[0]
+			dest, _ := cmd.Flags().GetString("dest")
+			local, _ := cmd.Flags().GetBool("local")
+			newName, _ := cmd.Flags().GetString("as")
+			poolName, _ := cmd.Flags().GetString("pool")
+			noProgress := progressDisabled(cmd)
+
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			destination, err := newBackupDestination(sshClient, cfg, dest, local)
+			if err != nil {
+				return err
+			}
+			chain, err := backup.ReadChain(destination, set)
+			if err != nil {
+				return err
+			}
+			manifest := chain[len(chain)-1]
+
+			vmName := manifest.VM
+			if newName != "" {
+				vmName = newName
+			}
+			if _, err := virshClient.GetVM(vmName); err == nil {
+				return fmt.Errorf("%w; restore it under another name with --as NEW_NAME", messages.Errorf(messages.VMExists, vmName))
+			}
+			// A copy next to the original must not share its identity
+			_, originalErr := virshClient.GetVM(manifest.VM)
+			fresh := originalErr == nil
+
+			domainData, err := destination.ReadFile(set, manifest.Domain)
+			if err != nil {
+				return err
+			}
+			var domain virsh.VMDomain
+			if err := xml.Unmarshal(domainData, &domain); err != nil {
+				return fmt.Errorf("failed to parse %s: %w", manifest.Domain, err)
+			}
+
+			storageManager := newStorageManager(sshClient, cfg)
+			pool, err := storageManager.SelectPool(poolName)
+			if err != nil {
+				return fmt.Errorf("failed to find storage pool: %w", err)
+			}
+
+			disks, err := planRestore(sshClient, storageManager, pool, chain, &domain, vmName)
+			if err != nil {
+				return err
+			}
+
+			var required int64
+			for _, disk := range disks {
+				required += disk.spaceNeeded()
+			}
+			if err := checkFreeSpace(cmd, []storage.SpaceRequirement{{Pool: pool, Bytes: required}}); err != nil {
+				return err
+			}
+
+			statusf("Restoring %s to VM '%s' in pool %s...\n", manifest.Name, vmName, pool.Name)
+			if len(chain) > 1 {
+				statusf("Applying %d incremental backups on top of %s\n", len(chain)-1, chain[0].Name)
+			}
+
+			opts := ssh.TransferOptions{Verify: true}
+			if !noProgress {
+				opts.Progress = os.Stderr
+			}
+
+			start := time.Now()
+			var restored []string
+			diskPaths := make(map[string]string)
+			for i, disk := range disks {
+				statusf("Restoring disk %d/%d: %s (%s)...\n", i+1, len(disks), disk.target, formatBytes(disk.layers[len(disk.layers)-1].disk.VirtualSize))
+				restored = append(restored, disk.path)
+				if err := restoreDisk(destination, storageManager, disk, opts); err != nil {
+					return removeDiskImages(storageManager, restored, err)
+				}
+				diskPaths[disk.source] = disk.path
+			}
+
+			domainXML, err := virsh.RestoreDomainXML(string(domainData), vmName, diskPaths, fresh)
+			if err != nil {
+				return removeDiskImages(storageManager, restored, err)
+			}
+			if err := virshClient.DefineDomainXML(vmName, domainXML); err != nil {
+				return removeDiskImages(storageManager, restored, err)
+			}
+
+			messages.Println(messages.BackupRestored, vmName, destination.Location(set))
+			statusf("%-15s: %s\n", "Backup", manifest.Created.Local().Format("2006-01-02 15:04:05"))
+			statusf("%-15s: %s\n", "Pool", pool.Name)
+			statusf("%-15s: %d\n", "Disks", len(disks))
+			statusf("%-15s: %s\n", "Duration", time.Since(start).Round(time.Second))
+			if fresh {
+				statusf("VM '%s' still exists, so '%s' was given a new UUID and MAC addresses\n", manifest.VM, vmName)
+			}
+			statusf("Start it with 'qnap-vm start %s'\n", vmName)
+			return nil
+		},
+	}
+
+	restoreCmd.Flags().String("dest", "", "Backup directory on the NAS, on this machine with --local, or s3://bucket/prefix (required)")
+	restoreCmd.Flags().Bool("local", false, "Restore from --dest on this machine, uploading disks over SFTP")
+	restoreCmd.Flags().String("as", "", "Name for the restored VM (default: the backed-up VM's name)")
+	restoreCmd.Flags().String("pool", "", "Storage pool to restore the disks to (default: the pool with the most free space)")
+	restoreCmd.Flags().Bool("no-progress", false, "Disable the progress bar for --local uploads and S3 downloads")
+	if err := restoreCmd.RegisterFlagCompletionFunc("pool", completePool); err != nil {
+		// Flag is registered above; registering its completion cannot fail
+	}
+
+	addSpaceCheckFlag(restoreCmd)
+	if err := restoreCmd.MarkFlagRequired("dest"); err != nil {
+		// Flag is registered above; marking cannot fail
+	}
+
+	cmd.AddCommand(createCmd, listCmd, restoreCmd, backupScheduleCmd(), backupStatusCmd(), backupRunScheduledCmd())
+	return cmd
+}
+
+// backupOptions are the settings of one backup run
+type backupOptions struct {
+	dest        string
+	pause       bool
+	estimate    bool
+	incremental bool
+	transfer    ssh.TransferOptions
+}
+
+// runBackup backs up a VM into a new set at destination and returns its manifest, or nil
+// if the user declined the estimate. Partial sets are removed on failure.
+func runBackup(cmd *cobra.Command, cfg *config.Config, sshClient *ssh.Client, virshClient *virsh.Client, destination backup.Destination, vmName string, options backupOptions) (*backup.Manifest, error) {
+	vm, err := virshClient.GetVM(vmName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VM '%s': %w", vmName, err)
+	}
+
+	disks, err := backupDisks(virshClient, vmName)
+	if err != nil {
+		return nil, err
+	}
+
+	storageManager := newStorageManager(sshClient, cfg)
+	images := make([]storage.ImageInfo, len(disks))
+	for i, disk := range disks {
+		info, err := storageManager.GetImageInfo(disk.Source.File)
+		if err != nil {
+			return nil, err
+		}
+		if info.BackingFile != "" {
+			return nil, fmt.Errorf("disk %s is a linked clone of %s; backing chains are not copied, so back up a full clone instead", disk.Target.Dev, info.BackingFile)
+		}
+		images[i] = *info
+	}
+
+	_, onNAS := destination.(*backup.NASDestination)
+	proceed, err := checkBackupSpace(cmd, storageManager, vmName, images, onNAS, options.dest, options.estimate)
+	if err != nil || !proceed {
+		if err == nil {
+			messages.Println(messages.Cancelled)
+		}
+		return nil, err
+	}
+
+	created := time.Now()
+	set := backup.SetName(vmName, created)
+	manifest := &backup.Manifest{
+		Version: backup.ManifestVersion,
+		Name:    set,
+		VM:      vmName,
+		Host:    cfg.Host,
+		Created: created.UTC(),
+		Domain:  backup.DomainFile,
+	}
+
+	domainXML, err := virshClient.ExportDomainXML(vmName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := destination.CreateSet(set); err != nil {
+		return nil, err
+	}
+	if err := destination.WriteFile(set, backup.DomainFile, []byte(domainXML)); err != nil {
+		return nil, removeFailedSet(destination, set, err)
+	}
+
+	var source *backupSource
+	if options.incremental && isActive(vm) {
+		source, err = prepareCheckpointBackup(sshClient, virshClient, destination, vm, disks, set, created)
+	} else {
+		if options.incremental {
+			statusln("VM is not running; taking a full backup (incremental backups need a running VM)")
+		}
+		source, err = prepareBackupSource(storageManager, virshClient, vm, disks, options.pause, created)
+	}
+	if err != nil {
+		return nil, removeFailedSet(destination, set, err)
+	}
+	manifest.Method = source.method
+	manifest.Parent = source.parent
+	manifest.Checkpoint = source.checkpoint
+
+	copyErr := copyBackupDisks(destination, set, manifest, disks, images, source, options.transfer)
+	releaseErr := source.release()
+	if copyErr != nil {
+		if releaseErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", releaseErr)
+		}
+		return nil, removeFailedSet(destination, set, copyErr)
+	}
+	if releaseErr != nil {
+		// The copy is complete; keep it but make sure the VM state problem is seen
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", releaseErr)
+	}
+
+	data, err := manifest.Marshal()
+	if err != nil {
+		return nil, removeFailedSet(destination, set, err)
+	}
+	if err := destination.WriteFile(set, backup.ManifestFile, data); err != nil {
+		return nil, removeFailedSet(destination, set, err)
+	}
+
+	messages.Println(messages.BackupCreated, vmName, destination.Location(set))
+	statusf("%-15s: %s\n", "Method", manifest.Method)
+	if manifest.Incremental() {
+		statusf("%-15s: %s\n", "Parent", manifest.Parent)
+	}
+	statusf("%-15s: %d (%s)\n", "Disks", len(manifest.Disks), formatBytes(manifest.TotalSize()))
+	statusf("%-15s: %s\n", "Duration", time.Since(created).Round(time.Second))
+
+	if manifest.Checkpoint != "" {
+		removeOldCheckpoints(virshClient, vmName, manifest.Checkpoint)
+	}
+	return manifest, releaseErr
+}
+
+// isS3URL reports whether a --dest names S3 object storage
+func isS3URL(dest string) bool {
+	return strings.HasPrefix(dest, "s3://")
+}
+
+// newBackupDestination returns the destination for --dest: an s3:// URL, a directory on
+// this machine with --local, or otherwise a directory on the NAS
+func newBackupDestination(sshClient *ssh.Client, cfg *config.Config, dest string, local bool) (backup.Destination, error) {
+	switch {
+	case isS3URL(dest):
+		if local {
+			return nil, fmt.Errorf("--local cannot be used with an s3:// destination")
+		}
+		client, err := newS3Client(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return backup.NewS3Destination(sshClient, client, dest)
+	case local:
+		return backup.NewLocalDestination(sshClient, dest), nil
+	default:
+		return backup.NewNASDestination(sshClient, dest), nil
+	}
+}
+
+// newS3Client creates an S3 client from the host's s3 config, falling back to the
+// standard AWS environment variables for unset values
+func newS3Client(cfg *config.Config) (*backup.S3Client, error) {
+	s3 := cfg.S3
+	for _, setting := range []struct {
+		value *string
+		env   string
+	}{
+		{&s3.Endpoint, "AWS_ENDPOINT_URL"},
+		{&s3.Region, "AWS_REGION"},
+		{&s3.AccessKey, "AWS_ACCESS_KEY_ID"},
+		{&s3.SecretKey, "AWS_SECRET_ACCESS_KEY"},
+	} {
+		if *setting.value == "" {
+			*setting.value = os.Getenv(setting.env)
+		}
+	}
+
+	if s3.AccessKey == "" || s3.SecretKey == "" {
+		return nil, fmt.Errorf("S3 credentials are not configured; use 'qnap-vm config set --s3-access-key KEY --s3-secret-key SECRET' or set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return backup.NewS3Client(s3.Endpoint, s3.Region, s3.AccessKey, s3.SecretKey, s3.PathStyle), nil
+}
+
+// applyS3Settings updates a host's S3 settings from the 'config set' flags
+func applyS3Settings(cmd *cobra.Command, s3 *config.S3Config) {
+	for flag, value := range map[string]*string{
+		"s3-endpoint":   &s3.Endpoint,
+		"s3-region":     &s3.Region,
+		"s3-access-key": &s3.AccessKey,
+		"s3-secret-key": &s3.SecretKey,
+	} {
+		if cmd.Flags().Changed(flag) {
+			*value, _ = cmd.Flags().GetString(flag)
+		}
+	}
+	if cmd.Flags().Changed("s3-path-style") {
+		s3.PathStyle, _ = cmd.Flags().GetBool("s3-path-style")
+	}
+}
+
+// backupDisks returns a VM's file-backed disks. Block devices such as iSCSI LUNs are
+// skipped with a warning; back them up with the QNAP's LUN snapshot tools.
+func backupDisks(virshClient *virsh.Client, vmName string) ([]virsh.DomainDisk, error) {
+	disks, err := virshClient.ListDisks(vmName)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileDisks []virsh.DomainDisk
+	for _, disk := range disks {
+		if disk.Source.File == "" {
+			fmt.Fprintf(os.Stderr, "Warning: skipping block device %s (%s); it is not included in the backup\n", disk.Target.Dev, disk.Source.Dev)
+			continue
+		}
+		fileDisks = append(fileDisks, disk)
+	}
+
+	if len(fileDisks) == 0 {
+		return nil, fmt.Errorf("VM '%s' has no disk images to back up", vmName)
+	}
+	return fileDisks, nil
+}
+
+// checkBackupSpace verifies a NAS destination's pool can hold the backup. With --estimate
+// it prints the estimate and asks before proceeding; it returns false if the user declines.
+func checkBackupSpace(cmd *cobra.Command, storageManager *storage.Manager, vmName string, images []storage.ImageInfo, onNAS bool, dest string, estimate bool) (bool, error) {
+	est := storage.EstimateBackup(images)
+
+	// Local and S3 free space is not checked; the copy fails cleanly if space runs out
+	var pool *storage.Pool
+	if onNAS {
+		pools, err := storageManager.DetectPools()
+		if err != nil {
+			return false, fmt.Errorf("failed to detect storage pools: %w", err)
+		}
+		pool = storage.PoolForPath(pools, storageManager.ResolvePath(dest))
+	}
+
+	if estimate {
+		fmt.Printf("Backup estimate for VM '%s':\n", vmName)
+		fmt.Printf("  %-15s: %s\n", "Data to copy", formatBytes(est.Bytes))
+		if pool != nil {
+			fmt.Printf("  %-15s: %d GB (%s)\n", "Pool free space", pool.FreeSpace, pool.Name)
+		}
+		fmt.Printf("  %-15s: ~%s\n", "Estimated time", est.Duration.Round(time.Second))
+	}
+
+	if err := checkFreeSpace(cmd, []storage.SpaceRequirement{{Pool: pool, Bytes: est.Bytes}}); err != nil {
+		return false, err
+	}
+	if !estimate {
+		return true, nil
+	}
+
+	return confirm("Start the backup? (y/N): ")
+}
+
+// backupSource is where a backup reads each disk from, and how to undo what made those
+// images consistent
+type backupSource struct {
+	method     string
+	format     string            // Format of the images to copy when it differs from the disks'
+	paths      map[string]string // Disk target -> image path to copy
+	release    func() error
+	parent     string // Set an incremental backup applies on top of
+	checkpoint string // Checkpoint taken for the next incremental backup
+}
+
+// isActive reports whether a VM has a running QEMU process, even if paused
+func isActive(vm *virsh.VMInfo) bool {
+	return strings.Contains(vm.State, "running") || strings.Contains(vm.State, "paused")
+}
+
+// prepareBackupSource makes the VM's disk images safe to copy: directly when the VM is
+// off, from a ZFS snapshot on a VM dataset, by suspending the VM with pause, and
+// otherwise by diverting writes into temporary overlays
+func prepareBackupSource(storageManager *storage.Manager, virshClient *virsh.Client, vm *virsh.VMInfo, disks []virsh.DomainDisk, pause bool, created time.Time) (*backupSource, error) {
+	source := &backupSource{
+		method:  backup.MethodOffline,
+		paths:   make(map[string]string),
+		release: func() error { return nil },
+	}
+	for _, disk := range disks {
+		source.paths[disk.Target.Dev] = disk.Source.File
+	}
+
+	if !isActive(vm) {
+		return source, nil
+	}
+
+	snapshotName := "backup-" + created.Format("20060102-150405")
+
+	if dataset := vmDataset(storageManager, virshClient, vm.Name); dataset != "" && !pause {
+		mountpoint, err := storageManager.DatasetMountpoint(dataset)
+		if err != nil {
+			return nil, err
+		}
+		if err := snapshotVMDataset(storageManager, virshClient, vm, dataset, snapshotName, "qnap-vm backup"); err != nil {
+			return nil, fmt.Errorf("failed to snapshot %s: %w", dataset, err)
+		}
+
+		// Snapshots are readable under the dataset's hidden .zfs directory
+		snapshotDir := path.Join(mountpoint, ".zfs", "snapshot", snapshotName)
+		for target, file := range source.paths {
+			rel, ok := pathWithin(mountpoint, file)
+			if !ok {
+				return nil, fmt.Errorf("disk %s is outside dataset %s", file, dataset)
+			}
+			source.paths[target] = path.Join(snapshotDir, rel)
+		}
+
+		source.method = backup.MethodZFS
+		source.release = func() error {
+			return storageManager.DestroyDatasetSnapshot(dataset, snapshotName)
+		}
+		return source, nil
+	}
+
+	if pause {
+		if strings.Contains(vm.State, "paused") {
+			// Already paused by someone else; leave it that way
+			source.method = backup.MethodPause
+			return source, nil
+		}
+
+		statusf("Suspending VM '%s' for the copy...\n", vm.Name)
+		if err := virshClient.SuspendVM(vm.Name); err != nil {
+			return nil, err
+		}
+		source.method = backup.MethodPause
+		source.release = func() error {
+			statusf("Resuming VM '%s'...\n", vm.Name)
+			return virshClient.ResumeVM(vm.Name)
+		}
+		return source, nil
+	}
+
+	overlays, err := virshClient.CreateDiskOverlays(vm.Name, snapshotName, disks, true)
+	if err != nil {
+		statusln("Guest agent unavailable; the backup will be crash-consistent")
+		overlays, err = virshClient.CreateDiskOverlays(vm.Name, snapshotName, disks, false)
+		if err != nil {
+			return nil, fmt.Errorf("%w\nUse --pause to suspend the VM for the backup instead", err)
+		}
+	}
+
+	source.method = backup.MethodOverlay
+	source.release = func() error {
+		var failed []string
+		for _, overlay := range overlays {
+			if err := virshClient.CommitDiskOverlay(vm.Name, overlay); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				failed = append(failed, overlay.Path)
+			}
+		}
+		if len(failed) > 0 {
+			return fmt.Errorf("VM '%s' is still writing to overlays %s; merge them with 'virsh blockcommit --active --pivot'", vm.Name, strings.Join(failed, ", "))
+		}
+		return nil
+	}
+	return source, nil
+}
+
+// prepareCheckpointBackup runs a libvirt backup job of a running VM, incremental from the
+// newest set in destination whose checkpoint the VM still has. Images are written straight
+// into a NAS set, or staged next to the VM's disks for a local destination.
+func prepareCheckpointBackup(sshClient *ssh.Client, virshClient *virsh.Client, destination backup.Destination, vm *virsh.VMInfo, disks []virsh.DomainDisk, set string, created time.Time) (*backupSource, error) {
+	checkpoints, err := virshClient.ListCheckpoints(vm.Name)
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := backup.ReadManifests(destination, vm.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &backupSource{
+		method:     backup.MethodBackup,
+		format:     "qcow2",
+		paths:      make(map[string]string),
+		release:    func() error { return nil },
+		checkpoint: virsh.CheckpointPrefix + created.Format("20060102-150405"),
+	}
+
+	incrementalFrom := ""
+	if parent := backup.LatestWithCheckpoint(manifests, checkpoints); parent != nil {
+		source.parent = parent.Name
+		incrementalFrom = parent.Checkpoint
+		statusf("Backing up changes since %s...\n", parent.Name)
+	} else {
+		statusln("No earlier backup to continue from; taking a full backup with a checkpoint")
+	}
+
+	stagingDir := destination.Location(set)
+	if _, onNAS := destination.(*backup.NASDestination); !onNAS {
+		stagingDir = path.Join(path.Dir(disks[0].Source.File), ".qnap-vm-"+set)
+		if output, err := sshClient.Execute(fmt.Sprintf("mkdir -p %s", ssh.Quote(stagingDir))); err != nil {
+			return nil, fmt.Errorf("failed to create staging directory %s: %w\nOutput: %s", stagingDir, err, output)
+		}
+		source.release = func() error {
+			if output, err := sshClient.Execute(fmt.Sprintf("rm -rf %s", ssh.Quote(stagingDir))); err != nil {
+				return fmt.Errorf("failed to remove staging directory %s: %w\nOutput: %s", stagingDir, err, output)
+			}
+			return nil
+		}
+	}
+
+	var jobDisks []virsh.BackupDisk
+	for _, disk := range disks {
+		// Backup jobs always write qcow2, whatever the source format
+		file := path.Join(stagingDir, backup.DiskFileName(disk.Target.Dev, "qcow2"))
+		source.paths[disk.Target.Dev] = file
+		jobDisks = append(jobDisks, virsh.BackupDisk{Target: disk.Target.Dev, File: file})
+	}
+
+	// The job captures the disks as they are when it starts, so filesystems only need
+	// to stay frozen until it has begun
+	frozen := virshClient.GuestFSFreeze(vm.Name) == nil
+	if !frozen {
+		statusln("Guest agent unavailable; the backup will be crash-consistent")
+	}
+	beginErr := virshClient.BeginBackup(vm.Name, incrementalFrom, source.checkpoint, jobDisks)
+	if frozen {
+		if err := virshClient.GuestFSThaw(vm.Name); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to thaw guest filesystems: %v\n", err)
+		}
+	}
+	if beginErr != nil {
+		return nil, errors.Join(beginErr, source.release())
+	}
+	if err := virshClient.WaitForBackup(vm.Name); err != nil {
+		return nil, errors.Join(err, source.release())
+	}
+	return source, nil
+}
+
+// removeOldCheckpoints deletes a VM's earlier backup checkpoints once a newer one is
+// recorded in a backup set, so dirty bitmaps do not accumulate in its disk images
+func removeOldCheckpoints(virshClient *virsh.Client, vmName, keep string) {
+	checkpoints, err := virshClient.ListCheckpoints(vmName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return
+	}
+	for _, name := range checkpoints {
+		if name == keep || !strings.HasPrefix(name, virsh.CheckpointPrefix) {
+			continue
+		}
+		if err := virshClient.DeleteCheckpoint(vmName, name); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+}
+
+// copyBackupDisks copies each disk into the set and records it in the manifest
+func copyBackupDisks(destination backup.Destination, set string, manifest *backup.Manifest, disks []virsh.DomainDisk, images []storage.ImageInfo, source *backupSource, opts ssh.TransferOptions) error {
+	compression := ""
+	if s3Destination, ok := destination.(*backup.S3Destination); ok {
+		compression = s3Destination.Compression
+	}
+
+	for i, disk := range disks {
+		format := images[i].Format
+		if source.format != "" {
+			format = source.format
+		}
+		name := backup.DiskFileName(disk.Target.Dev, format)
+		if compression == backup.CompressionGzip {
+			name += ".gz"
+		}
+		statusf("Copying disk %d/%d: %s (%s)...\n", i+1, len(disks), disk.Target.Dev, formatBytes(images[i].ActualSize))
+
+		result, err := destination.CopyDisk(set, name, source.paths[disk.Target.Dev], opts)
+		if err != nil {
+			return err
+		}
+
+		manifest.Disks = append(manifest.Disks, backup.Disk{
+			Target:      disk.Target.Dev,
+			Source:      disk.Source.File,
+			File:        name,
+			Format:      format,
+			VirtualSize: images[i].VirtualSize,
+			Size:        result.Bytes,
+			SHA256:      result.SHA256,
+			Compression: compression,
+		})
+	}
+	return nil
+}
+
+// removeFailedSet deletes a partial backup set and returns cause
+func removeFailedSet(destination backup.Destination, set string, cause error) error {
+	if err := destination.RemoveSet(set); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to remove incomplete backup %s: %v\n", destination.Location(set), err)
+	}
+	return cause
+}
+
+// restoreLayer is one backup set's copy of a disk, oldest first in a chain
+type restoreLayer struct {
+	set  string
+	disk backup.Disk
+}
+
+// restoredDisk is a disk being restored: the layers to fetch and where the result goes
+type restoredDisk struct {
+	target string
+	source string // Image path in the backed-up domain XML
+	path   string // Restored image path
+	format string // Restored image format, as the domain XML expects
+	layers []restoreLayer
+}
+
+// direct reports whether the stored image can be fetched straight into place
+func (d *restoredDisk) direct() bool {
+	return len(d.layers) == 1 && d.layers[0].disk.Format == d.format
+}
+
+// spaceNeeded returns the bytes the restore may use in the pool, counting fetched layers
+// and the merged image. Compressed layers are counted at their full virtual size.
+func (d *restoredDisk) spaceNeeded() int64 {
+	var total int64
+	for _, layer := range d.layers {
+		if layer.disk.Compression != "" {
+			total += layer.disk.VirtualSize
+		} else {
+			total += layer.disk.Size
+		}
+	}
+	if !d.direct() {
+		// The merged image holds at most the layers' data
+		total *= 2
+	}
+	return total
+}
+
+// planRestore matches each disk of the newest set with its copies in the earlier sets of
+// the chain, and picks an unused path for it in pool
+func planRestore(sshClient *ssh.Client, storageManager *storage.Manager, pool *storage.Pool, chain []*backup.Manifest, domain *virsh.VMDomain, vmName string) ([]*restoredDisk, error) {
+	formats := make(map[string]string)
+	for _, disk := range domain.Devices.Disk {
+		if disk.Source.File == "" {
+			if disk.Source.Dev != "" {
+				fmt.Fprintf(os.Stderr, "Warning: block device %s (%s) is not in the backup; the restored VM still uses it\n", disk.Target.Dev, disk.Source.Dev)
+			}
+			continue
+		}
+		formats[disk.Source.File] = disk.Driver.Type
+	}
+
+	manifest := chain[len(chain)-1]
+	var disks []*restoredDisk
+	for i, disk := range manifest.Disks {
+		restored := &restoredDisk{target: disk.Target, source: disk.Source, format: formats[disk.Source]}
+		if restored.format == "" {
+			restored.format = disk.Format
+		}
+
+		for _, layer := range chain {
+			found := false
+			for _, layerDisk := range layer.Disks {
+				if layerDisk.Target == disk.Target {
+					restored.layers = append(restored.layers, restoreLayer{set: layer.Name, disk: layerDisk})
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("backup %s has no copy of disk %s, which %s builds on", layer.Name, disk.Target, manifest.Name)
+			}
+		}
+
+		restored.path = storageManager.CreateVMDiskPathIndexed(pool, vmName, i)
+		if restored.format != "qcow2" {
+			restored.path = strings.TrimSuffix(restored.path, ".qcow2") + "." + restored.format
+		}
+		if _, err := sshClient.Execute(fmt.Sprintf("test -e %s", ssh.Quote(restored.path))); err == nil {
+			return nil, fmt.Errorf("%s already exists; restore under another name with --as NEW_NAME or remove it", restored.path)
+		}
+		disks = append(disks, restored)
+	}
+	return disks, nil
+}
+
+// restoreDisk fetches a disk's layers and verifies them against their manifests. A chain
+// of incremental layers is rebased onto each other and merged into the restored image.
+func restoreDisk(destination backup.Destination, storageManager *storage.Manager, disk *restoredDisk, opts ssh.TransferOptions) error {
+	if disk.direct() {
+		return fetchLayer(destination, disk.layers[0], disk.path, opts)
+	}
+
+	var layerPaths []string
+	defer func() {
+		for _, layerPath := range layerPaths {
+			if err := storageManager.RemoveDisk(layerPath); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}
+	}()
+
+	for i, layer := range disk.layers {
+		layerPath := fmt.Sprintf("%s.restore%d", disk.path, i)
+		layerPaths = append(layerPaths, layerPath)
+		if len(disk.layers) > 1 {
+			statusf("  %s from %s\n", layer.disk.File, layer.set)
+		}
+		if err := fetchLayer(destination, layer, layerPath, opts); err != nil {
+			return err
+		}
+		if i > 0 {
+			if err := storageManager.RebaseDisk(layerPath, layerPaths[i-1], disk.layers[i-1].disk.Format); err != nil {
+				return err
+			}
+		}
+	}
+
+	top := disk.layers[len(disk.layers)-1].disk
+	return storageManager.ConvertDiskTo(layerPaths[len(layerPaths)-1], top.Format, disk.path, disk.format)
+}
+
+// fetchLayer fetches one stored image to destPath and checks it against the manifest
+func fetchLayer(destination backup.Destination, layer restoreLayer, destPath string, opts ssh.TransferOptions) error {
+	result, err := destination.FetchDisk(layer.set, layer.disk, destPath, opts)
+	if err != nil {
+		return err
+	}
+	if layer.disk.SHA256 != "" && result.SHA256 != layer.disk.SHA256 {
+		return fmt.Errorf("checksum mismatch for %s in %s: manifest %s, restored %s", layer.disk.File, layer.set, layer.disk.SHA256, result.SHA256)
+	}
+	return nil
+}
+
+// removeDiskImages deletes the images of a failed restore or migration and returns cause
+func removeDiskImages(storageManager *storage.Manager, paths []string, cause error) error {
+	for _, diskPath := range paths {
+		if err := storageManager.RemoveDisk(diskPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+	return cause
+}
@@ -0,0 +1,275 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/bench"
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/storage"
+	"github.com/spf13/cobra"
+)
+
+// userNetworkGateway is the host address seen from a guest on QEMU user-mode networking
+const userNetworkGateway = "10.0.2.2"
+
+// benchNetSeconds is the duration of each iperf3 direction
+const benchNetSeconds = 10
+
+func benchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bench [VM_NAME]",
+		Short: "Benchmark disk and network throughput on the host and in a guest",
+		Long: `Run fio (or dd when fio is unavailable) and iperf3 on the QNAP device and
+inside a running Linux guest, and print a side-by-side report. Comparing
+the guest with the host shows how much a disk bus, cache mode or storage
+pool costs, and helps choose between them.
+
+Guest commands run through the QEMU guest agent, which must be installed
+and running. Tools missing on a target are copied from --tools, a local
+directory of static binaries named fio and iperf3.
+
+Without a VM name only the host is benchmarked. Without --disk or --net
+both tests are run.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			runDisk, _ := cmd.Flags().GetBool("disk")
+			runNet, _ := cmd.Flags().GetBool("net")
+			size, _ := cmd.Flags().GetString("size")
+			poolName, _ := cmd.Flags().GetString("pool")
+			guestDir, _ := cmd.Flags().GetString("guest-dir")
+			toolsDir, _ := cmd.Flags().GetString("tools")
+			server, _ := cmd.Flags().GetString("server")
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+
+			if !runDisk && !runNet {
+				runDisk, runNet = true, true
+			}
+			vmName := ""
+			if len(args) > 0 {
+				vmName = args[0]
+			}
+			if runNet && vmName == "" {
+				if !cmd.Flags().Changed("net") {
+					runNet = false
+				} else {
+					return fmt.Errorf("--net needs a VM to run the iperf3 client in")
+				}
+			}
+
+			sizeBytes, err := storage.SizeBytes(size)
+			if err != nil {
+				return err
+			}
+			sizeMB := int(sizeBytes >> 20)
+			if sizeMB < 1 {
+				return fmt.Errorf("--size must be at least 1M")
+			}
+
+			if toolsDir == "" {
+				toolsDir, err = defaultToolsDir()
+				if err != nil {
+					return err
+				}
+			}
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			host := bench.NewHostRunner(sshClient)
+			var guest *bench.GuestRunner
+			if vmName != "" {
+				vm, err := virshClient.GetVM(vmName)
+				if err != nil {
+					return err
+				}
+				if !strings.Contains(vm.State, "running") {
+					return fmt.Errorf("VM '%s' is not running", vmName)
+				}
+				if err := virshClient.GuestPing(vmName); err != nil {
+					return err
+				}
+				guest = bench.NewGuestRunner(virshClient, vmName)
+			}
+
+			var results []bench.Result
+
+			if runDisk {
+				pool, err := newStorageManager(sshClient, cfg).SelectPool(poolName)
+				if err != nil {
+					return fmt.Errorf("failed to find storage pool: %w", err)
+				}
+				if err := checkFreeSpace(cmd, []storage.SpaceRequirement{{Pool: pool, Bytes: sizeBytes}}); err != nil {
+					return err
+				}
+
+				hostOpts := bench.DiskOptions{Dir: pool.Path + "/.qnap-vm/bench", SizeMB: sizeMB}
+				fmt.Fprintf(os.Stderr, "Running disk benchmark on host (%s, pool %s)...\n", hostOpts.Dir, pool.Name)
+				hostResults, err := runDiskBench(host, toolsDir, hostOpts)
+				if err != nil {
+					return err
+				}
+				results = append(results, hostResults...)
+
+				if guest != nil {
+					guestOpts := bench.DiskOptions{Dir: guestDir, SizeMB: sizeMB}
+					fmt.Fprintf(os.Stderr, "Running disk benchmark in VM '%s' (%s)...\n", vmName, guestDir)
+					guestResults, err := runDiskBench(guest, toolsDir, guestOpts)
+					if err != nil {
+						return err
+					}
+					results = append(results, guestResults...)
+				}
+			}
+
+			if runNet {
+				hostIperf, err := requireTool(host, "iperf3", toolsDir)
+				if err != nil {
+					return err
+				}
+				guestIperf, err := requireTool(guest, "iperf3", toolsDir)
+				if err != nil {
+					return err
+				}
+
+				if server == "" {
+					server = cfg.Host
+					if domain, err := virshClient.GetDomain(vmName); err == nil {
+						for _, iface := range domain.Devices.Interface {
+							if iface.Type == "user" {
+								server = userNetworkGateway
+								break
+							}
+						}
+					}
+				}
+
+				fmt.Fprintf(os.Stderr, "Running network benchmark between VM '%s' and host (%s)...\n", vmName, server)
+				netResults, err := bench.RunNet(host, hostIperf, guest, guestIperf, bench.NetOptions{ServerAddr: server, Seconds: benchNetSeconds})
+				if err != nil {
+					return err
+				}
+				results = append(results, netResults...)
+			}
+
+			if jsonOutput {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(results)
+			}
+
+			printBenchReport(results)
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("disk", false, "Run the disk benchmark")
+	cmd.Flags().Bool("net", false, "Run the guest-to-host network benchmark")
+	cmd.Flags().String("size", "1G", "Size of the disk test file")
+	cmd.Flags().String("pool", "", "Storage pool for the host disk test (default: best pool)")
+	cmd.Flags().String("guest-dir", "/var/tmp", "Directory in the guest for the disk test file")
+	cmd.Flags().String("tools", "", "Local directory of static fio/iperf3 binaries to install when missing (default: ~/.qnap-vm/tools)")
+	cmd.Flags().String("server", "", "Host address the guest uses for iperf3 (default: configured host, or 10.0.2.2 for user networking)")
+	cmd.Flags().Bool("json", false, "Output results as JSON")
+	if err := cmd.RegisterFlagCompletionFunc("pool", completePool); err != nil {
+		// Flag is registered above; registering its completion cannot fail
+	}
+
+	addSpaceCheckFlag(cmd)
+
+	return cmd
+}
+
+// defaultToolsDir returns the tools directory in the data directory
+func defaultToolsDir() (string, error) {
+	dataDir, err := config.GetDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "tools"), nil
+}
+
+// runDiskBench runs the disk benchmark on r with fio, falling back to dd when fio is unavailable
+func runDiskBench(r bench.Runner, toolsDir string, opts bench.DiskOptions) ([]bench.Result, error) {
+	fioPath, err := bench.FindTool(r, "fio", toolsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to install fio on %s: %w", r.Name(), err)
+	}
+	if fioPath == "" {
+		fmt.Fprintf(os.Stderr, "Warning: fio not found on %s, using dd (sequential tests only)\n", r.Name())
+	}
+	return bench.RunDisk(r, fioPath, opts)
+}
+
+// requireTool returns the path of tool on r, or an error explaining how to provide it
+func requireTool(r bench.Runner, tool, toolsDir string) (string, error) {
+	toolPath, err := bench.FindTool(r, tool, toolsDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to install %s on %s: %w", tool, r.Name(), err)
+	}
+	if toolPath == "" {
+		return "", fmt.Errorf("%s not found on %s; install it or place a static binary at %s", tool, r.Name(), filepath.Join(toolsDir, tool))
+	}
+	return toolPath, nil
+}
+
+// printBenchReport prints host and guest results side by side
+func printBenchReport(results []bench.Result) {
+	type row struct {
+		unit        string
+		host, guest *bench.Result
+	}
+
+	var order []string
+	rows := make(map[string]*row)
+	for i := range results {
+		result := &results[i]
+		r, ok := rows[result.Test]
+		if !ok {
+			r = &row{unit: result.Unit}
+			rows[result.Test] = r
+			order = append(order, result.Test)
+		}
+		if result.Target == "host" {
+			r.host = result
+		} else {
+			r.guest = result
+		}
+	}
+
+	fmt.Printf("%-15s %-8s %-18s %-18s %s\n", "TEST", "UNIT", "HOST", "GUEST", "GUEST/HOST")
+	fmt.Printf("%-15s %-8s %-18s %-18s %s\n", "----", "----", "----", "-----", "----------")
+	for _, test := range order {
+		r := rows[test]
+		ratio := "-"
+		if r.host != nil && r.guest != nil && r.host.Value > 0 {
+			ratio = fmt.Sprintf("%.0f%%", r.guest.Value/r.host.Value*100)
+		}
+		fmt.Printf("%-15s %-8s %-18s %-18s %s\n", test, r.unit, formatBenchValue(r.host), formatBenchValue(r.guest), ratio)
+	}
+}
+
+// formatBenchValue formats a result value with the tool that measured it
+func formatBenchValue(result *bench.Result) string {
+	if result == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f (%s)", result.Value, result.Tool)
+}
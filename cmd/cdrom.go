@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+// cdromAction is what a cdrom subcommand does to a drive
+type cdromAction int
+
+const (
+	cdromAttach cdromAction = iota // Insert into an empty drive, adding one if needed
+	cdromChange                    // Replace the image in a drive
+	cdromEject                     // Remove the image from a drive
+)
+
+func cdromCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cdrom",
+		Short: "Manage a VM's CD-ROM drives",
+		Long: `Insert, swap or eject ISO images in a VM's CD-ROM drives, e.g. to remove the
+installer once the guest is set up. Running VMs see the change immediately, and
+it is kept in the VM's configuration for later starts.
+
+ISO images can be given as a path on the NAS or by name from the ISO library
+('qnap-vm iso list').`,
+	}
+
+	cmd.AddCommand(cdromAttachCmd(), cdromChangeCmd(), cdromEjectCmd())
+	return cmd
+}
+
+func cdromAttachCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "attach [VM_NAME] [ISO]",
+		Short: "Insert an ISO into an empty CD-ROM drive",
+		Long: `Insert an ISO into the VM's first empty CD-ROM drive, or the drive given with
+--drive. A VM with no empty drive gets a new one, which appears at its next start.`,
+		Example: `  qnap-vm cdrom attach web virtio-win.iso
+  qnap-vm cdrom attach web /share/CACHEDEV1_DATA/iso/tools.iso --drive hdd`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCDROM(cmd, args[0], args[1], cdromAttach)
+		},
+	}
+
+	addCDROMDriveFlag(cmd)
+	return cmd
+}
+
+func cdromChangeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "change [VM_NAME] [ISO]",
+		Short:             "Replace the ISO in a CD-ROM drive",
+		Long:              "Replace the image in the VM's first CD-ROM drive, or the drive given with --drive.",
+		Example:           `  qnap-vm cdrom change web ubuntu-24.04-live-server-amd64.iso`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCDROM(cmd, args[0], args[1], cdromChange)
+		},
+	}
+
+	addCDROMDriveFlag(cmd)
+	return cmd
+}
+
+func cdromEjectCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "eject [VM_NAME]",
+		Short:             "Eject the ISO from a CD-ROM drive",
+		Long:              "Eject the image from the VM's first loaded CD-ROM drive, or the drive given with --drive.",
+		Example:           `  qnap-vm cdrom eject web`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCDROM(cmd, args[0], "", cdromEject)
+		},
+	}
+
+	addCDROMDriveFlag(cmd)
+	return cmd
+}
+
+// addCDROMDriveFlag adds the flag selecting a CD-ROM drive by its target
+func addCDROMDriveFlag(cmd *cobra.Command) {
+	cmd.Flags().String("drive", "", "CD-ROM drive target, e.g. hdc or sdc (default: chosen by the command)")
+}
+
+// runCDROM performs a cdrom subcommand's action on a VM
+func runCDROM(cmd *cobra.Command, vmName, isoRef string, action cdromAction) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	drive, _ := cmd.Flags().GetString("drive")
+
+	// Connect to QNAP device
+	sshClient, virshClient, err := connectToQNAP(*cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := sshClient.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+		}
+	}()
+
+	vm, err := virshClient.GetVM(vmName)
+	if err != nil {
+		return err
+	}
+	domain, err := virshClient.GetDomain(vmName)
+	if err != nil {
+		return err
+	}
+
+	isoPath := ""
+	if isoRef != "" {
+		isoPath, err = newStorageManager(sshClient, cfg).ResolveISOPath(isoRef)
+		if err != nil {
+			return err
+		}
+	}
+
+	var match func(virsh.DomainDisk) bool
+	switch action {
+	case cdromAttach:
+		match = func(d virsh.DomainDisk) bool { return !virsh.HasMedia(d) }
+	case cdromEject:
+		match = virsh.HasMedia
+	}
+	target, err := domain.CDROM(drive, match)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case target == nil && action == cdromAttach:
+		added, err := virshClient.AddCDROM(vmName, isoPath)
+		if err != nil {
+			return err
+		}
+		statusf("Added CD-ROM drive %s with %s to VM '%s'\n", added, isoPath, vmName)
+		if isActive(vm) {
+			statusln("The drive appears when the VM is next started.")
+		}
+		return nil
+	case target == nil && action == cdromEject:
+		return fmt.Errorf("VM '%s' has no CD-ROM drive holding an image", vmName)
+	case target == nil:
+		return fmt.Errorf("VM '%s' has no CD-ROM drive; use 'qnap-vm cdrom attach' to add one", vmName)
+	case action == cdromAttach && virsh.HasMedia(*target):
+		return fmt.Errorf("drive '%s' of VM '%s' already holds %s; use 'qnap-vm cdrom change' to replace it",
+			target.Target.Dev, vmName, target.Source.File)
+	}
+
+	if err := virshClient.ChangeMedia(vmName, target.Target.Dev, isoPath, isActive(vm)); err != nil {
+		return err
+	}
+
+	if action == cdromEject {
+		statusf("Ejected %s from drive %s of VM '%s'\n", target.Source.File, target.Target.Dev, vmName)
+	} else {
+		statusf("Inserted %s into drive %s of VM '%s'\n", isoPath, target.Target.Dev, vmName)
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/storage"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func chaosTestCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "test",
+		Short: "Inject failures into lab VMs to test service resilience",
+		Long: `Deliberately break running VMs to check that the services they host recover:
+power a VM off, cut its network, or slow its disks for a while.
+
+Without a VM name, a random running VM tagged 'chaos' (see 'qnap-vm tag') is
+picked, so only VMs you have opted in are ever hit. Each command asks for
+confirmation unless --force is given. Network and disk faults are undone when
+--duration ends or on Ctrl+C.`,
+	}
+
+	cmd.AddCommand(testKillCmd(), testNetsplitCmd(), testIOThrottleCmd())
+	return cmd
+}
+
+// addChaosFlags adds the flags shared by the test commands
+func addChaosFlags(cmd *cobra.Command) {
+	cmd.Flags().String("tag", "chaos", "Tag of the VMs to pick from when no VM is named")
+	cmd.Flags().BoolP("force", "f", false, "Do not ask for confirmation")
+}
+
+func testKillCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kill [VM_NAME]",
+		Short: "Power a VM off without shutting it down",
+		Long: `Power a running VM off immediately, as a crash or power cut would, without
+giving the guest a chance to shut down. With --restart-after, start it again
+after that long.`,
+		Example: `  qnap-vm test kill homeassistant --restart-after 30s
+  qnap-vm test kill --force`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			restartAfter, _ := cmd.Flags().GetDuration("restart-after")
+
+			return withChaosTarget(cmd, args, "Power off VM '%s' on %s without shutting it down?", func(virshClient *virsh.Client, vmName string) error {
+				statusf("Killing VM '%s'...\n", vmName)
+				if err := virshClient.StopVM(vmName, true); err != nil {
+					return err
+				}
+				statusf("VM '%s' powered off at %s\n", vmName, time.Now().Format("15:04:05"))
+
+				if restartAfter <= 0 {
+					return nil
+				}
+				waitForFault(restartAfter, "starting it again")
+				if err := virshClient.StartVM(vmName); err != nil {
+					return err
+				}
+				messages.Println(messages.VMStarted, vmName)
+				return nil
+			})
+		},
+	}
+
+	cmd.Flags().Duration("restart-after", 0, "Start the VM again after this long (e.g. 30s)")
+	addChaosFlags(cmd)
+	return cmd
+}
+
+func testNetsplitCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "netsplit [VM_NAME]",
+		Short: "Disconnect a VM's network for a while",
+		Long: `Detach a running VM's network interfaces, wait for --duration, then attach them
+again with the same MAC and PCI addresses. The VM's saved configuration is not
+changed.
+
+Hot unplug needs guest support; with --link the interfaces stay attached and
+only their links go down, like pulling the cable.`,
+		Example: `  qnap-vm test netsplit homeassistant --duration 60s
+  qnap-vm test netsplit web --link --mac 52:54:00:12:34:56 --duration 5m`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			duration, _ := cmd.Flags().GetDuration("duration")
+			link, _ := cmd.Flags().GetBool("link")
+			mac, _ := cmd.Flags().GetString("mac")
+
+			return withChaosTarget(cmd, args, "Disconnect the network of VM '%s' on %s?", func(virshClient *virsh.Client, vmName string) error {
+				interfaces, err := virshClient.LiveInterfaces(vmName)
+				if err != nil {
+					return err
+				}
+				if mac != "" {
+					interfaces = slices.DeleteFunc(interfaces, func(iface virsh.LiveInterface) bool {
+						return !strings.EqualFold(iface.MAC, mac)
+					})
+				}
+				if len(interfaces) == 0 {
+					return fmt.Errorf("VM '%s' has no network interface to disconnect", vmName)
+				}
+
+				cut := func(iface virsh.LiveInterface) error {
+					if link {
+						return virshClient.SetLinkState(vmName, iface, false)
+					}
+					return virshClient.DetachInterface(vmName, iface)
+				}
+				restore := func(iface virsh.LiveInterface) error {
+					if link {
+						return virshClient.SetLinkState(vmName, iface, true)
+					}
+					return virshClient.AttachInterface(vmName, iface)
+				}
+
+				var disconnected []virsh.LiveInterface
+				var failed []error
+				for _, iface := range interfaces {
+					if err := cut(iface); err != nil {
+						failed = append(failed, err)
+						break
+					}
+					disconnected = append(disconnected, iface)
+					statusf("Disconnected %s (%s)\n", iface.MAC, iface.Target)
+				}
+
+				if len(failed) == 0 {
+					waitForFault(duration, "reconnecting")
+				}
+				for _, iface := range disconnected {
+					if err := restore(iface); err != nil {
+						failed = append(failed, err)
+						continue
+					}
+					statusf("Reconnected %s\n", iface.MAC)
+				}
+				return errors.Join(failed...)
+			})
+		},
+	}
+
+	cmd.Flags().Duration("duration", time.Minute, "How long to keep the network down; 0 waits for Ctrl+C")
+	cmd.Flags().Bool("link", false, "Set the links down instead of detaching the interfaces")
+	cmd.Flags().String("mac", "", "Only disconnect the interface with this MAC address")
+	addChaosFlags(cmd)
+	return cmd
+}
+
+func testIOThrottleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "io-throttle [VM_NAME]",
+		Short: "Slow a VM's disks down for a while",
+		Long: `Limit a running VM's disk throughput and/or operations per second for
+--duration, then restore the limits it had before. The VM's saved
+configuration is not changed.`,
+		Example: `  qnap-vm test io-throttle homeassistant --bps 1M --duration 2m
+  qnap-vm test io-throttle db --iops 20 --disk vdb`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			duration, _ := cmd.Flags().GetDuration("duration")
+			bps, _ := cmd.Flags().GetString("bps")
+			iops, _ := cmd.Flags().GetInt64("iops")
+			disk, _ := cmd.Flags().GetString("disk")
+
+			throttle := virsh.IOTune{TotalIOPSSec: iops}
+			if bps != "" {
+				bytes, err := storage.SizeBytes(bps)
+				if err != nil {
+					return fmt.Errorf("invalid --bps: %w", err)
+				}
+				throttle.TotalBytesSec = bytes
+			}
+			if throttle.TotalBytesSec <= 0 && throttle.TotalIOPSSec <= 0 {
+				return fmt.Errorf("give a limit with --bps and/or --iops")
+			}
+
+			return withChaosTarget(cmd, args, "Throttle the disks of VM '%s' on %s?", func(virshClient *virsh.Client, vmName string) error {
+				disks, err := virshClient.ListDisks(vmName)
+				if err != nil {
+					return err
+				}
+				var targets []string
+				for _, d := range disks {
+					if disk == "" || d.Target.Dev == disk {
+						targets = append(targets, d.Target.Dev)
+					}
+				}
+				if len(targets) == 0 {
+					return fmt.Errorf("VM '%s' has no disk %s", vmName, disk)
+				}
+
+				// Keep the current limits to put back afterwards
+				original := make(map[string]*virsh.IOTune)
+				var failed []error
+				for _, target := range targets {
+					tune, err := virshClient.GetIOTune(vmName, target)
+					if err == nil {
+						err = virshClient.SetIOTune(vmName, target, throttle)
+					}
+					if err != nil {
+						failed = append(failed, err)
+						break
+					}
+					original[target] = tune
+					statusf("Throttled %s\n", target)
+				}
+
+				if len(failed) == 0 {
+					waitForFault(duration, "restoring the previous limits")
+				}
+				for _, target := range targets {
+					tune, ok := original[target]
+					if !ok {
+						continue
+					}
+					if err := virshClient.SetIOTune(vmName, target, *tune); err != nil {
+						failed = append(failed, err)
+						continue
+					}
+					statusf("Restored %s\n", target)
+				}
+				return errors.Join(failed...)
+			})
+		},
+	}
+
+	cmd.Flags().Duration("duration", time.Minute, "How long to keep the limits; 0 waits for Ctrl+C")
+	cmd.Flags().String("bps", "", "Total bytes per second, e.g. 1M")
+	cmd.Flags().Int64("iops", 0, "Total I/O operations per second")
+	cmd.Flags().String("disk", "", "Only throttle this disk, e.g. vdb (default: all disks)")
+	addChaosFlags(cmd)
+	return cmd
+}
+
+// withChaosTarget connects, picks the running VM named in args or a random one with the
+// --tag tag, asks for confirmation unless --force, and runs fault on it
+func withChaosTarget(cmd *cobra.Command, args []string, confirmPrompt string, fault func(virshClient *virsh.Client, vmName string) error) error {
+	tag, _ := cmd.Flags().GetString("tag")
+	force, _ := cmd.Flags().GetBool("force")
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Connect to QNAP device
+	sshClient, virshClient, err := connectToQNAP(*cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := sshClient.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+		}
+	}()
+
+	var vmName string
+	if len(args) > 0 {
+		vmName = args[0]
+		vm, err := virshClient.GetVM(vmName)
+		if err != nil {
+			return err
+		}
+		if vm.State != "running" {
+			return fmt.Errorf("VM '%s' is not running (state: %s)", vmName, vm.State)
+		}
+	} else {
+		vms, err := virshClient.ListVMsWithMetadata()
+		if err != nil {
+			return fmt.Errorf("failed to list VMs: %w", err)
+		}
+		var candidates []string
+		for _, vm := range vms {
+			if vm.State == "running" && slices.Contains(vm.Tags, tag) {
+				candidates = append(candidates, vm.Name)
+			}
+		}
+		if len(candidates) == 0 {
+			return fmt.Errorf("no running VM is tagged '%s'; name a VM or opt one in with 'qnap-vm tag VM --add %s'", tag, tag)
+		}
+		vmName = candidates[rand.IntN(len(candidates))]
+		statusf("Picked VM '%s' from %d running VM(s) tagged '%s'\n", vmName, len(candidates), tag)
+	}
+
+	if !force {
+		confirmed, err := confirm(fmt.Sprintf(confirmPrompt+" (y/N): ", vmName, cfg.Label()))
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			messages.Println(messages.Cancelled)
+			return nil
+		}
+	}
+
+	return fault(virshClient, vmName)
+}
+
+// waitForFault keeps a fault in place for duration, or until Ctrl+C when duration is 0,
+// then says what happens next. Ctrl+C always ends the wait early.
+func waitForFault(duration time.Duration, next string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if duration > 0 {
+		statusf("Waiting %s before %s (press Ctrl+C to end early)\n", duration, next)
+		select {
+		case <-ctx.Done():
+		case <-time.After(duration):
+		}
+	} else {
+		statusf("Waiting for Ctrl+C before %s\n", next)
+		<-ctx.Done()
+	}
+	statusf("%s%s...\n", strings.ToUpper(next[:1]), next[1:])
+}
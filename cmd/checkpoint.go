@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/scttfrdmn/qnap-vm/pkg/storage"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+// checkpointPrefix starts the names of the snapshots kept by 'qnap-vm checkpoint', which
+// end in their creation time so the newest sorts last
+const checkpointPrefix = "checkpoint-"
+
+func checkpointCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "checkpoint [VM_NAME]",
+		Short: "Save a VM's last known good state",
+		Long: `Snapshot a VM as its "last known good" state, replacing its previous
+checkpoint, so 'qnap-vm rollback' can return to it without naming snapshots.
+
+The new checkpoint is taken before the old one is deleted, so a failure never
+leaves the VM without one. Checkpoints are ordinary snapshots named
+checkpoint-DATE-TIME and are listed by 'qnap-vm snapshot list'.`,
+		Example: `  qnap-vm checkpoint lab
+  # ... try something risky ...
+  qnap-vm rollback lab`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			vmName := args[0]
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			vm, err := virshClient.GetVM(vmName)
+			if err != nil {
+				return err
+			}
+
+			store := newCheckpointStore(sshClient, cfg, virshClient, vmName)
+			previous, err := store.list()
+			if err != nil {
+				return err
+			}
+
+			if store.dataset == "" {
+				if _, err := checkSnapshotSpace(cmd, store.storageManager, virshClient, vmName); err != nil {
+					return err
+				}
+			}
+
+			name := checkpointPrefix + time.Now().Format("20060102-150405")
+			statusf("Checkpointing VM '%s' as '%s'...\n", vmName, name)
+			if err := store.create(vm, name); err != nil {
+				return fmt.Errorf("failed to create checkpoint: %w", err)
+			}
+
+			for _, old := range previous {
+				if old == name {
+					continue
+				}
+				if err := store.remove(old); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to delete previous checkpoint '%s': %v\n", old, err)
+					continue
+				}
+				statusf("Replaced checkpoint '%s'\n", old)
+			}
+
+			statusf("VM '%s' checkpointed; 'qnap-vm rollback %s' returns to this state\n", vmName, vmName)
+			return nil
+		},
+	}
+
+	addSpaceCheckFlag(cmd)
+	return cmd
+}
+
+func rollbackCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollback [VM_NAME]",
+		Short: "Return a VM to its last checkpoint",
+		Long: `Restore a VM to the state saved by 'qnap-vm checkpoint', losing all changes
+made since. The checkpoint is kept, so the VM can be rolled back again.
+
+VMs with their own ZFS dataset must be shut off first; use --stop to power the
+VM off before rolling back.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			vmName := args[0]
+			force, _ := cmd.Flags().GetBool("force")
+			stop, _ := cmd.Flags().GetBool("stop")
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			vm, err := virshClient.GetVM(vmName)
+			if err != nil {
+				return err
+			}
+
+			store := newCheckpointStore(sshClient, cfg, virshClient, vmName)
+			checkpoints, err := store.list()
+			if err != nil {
+				return err
+			}
+			if len(checkpoints) == 0 {
+				return fmt.Errorf("VM '%s' has no checkpoint; create one with 'qnap-vm checkpoint %s'", vmName, vmName)
+			}
+			name := checkpoints[len(checkpoints)-1]
+
+			if store.dataset != "" {
+				if stop && !strings.Contains(vm.State, "shut off") {
+					statusf("Powering off VM '%s'...\n", vmName)
+					if err := virshClient.StopVM(vmName, true); err != nil {
+						return err
+					}
+					vm.State = "shut off"
+				}
+				return rollbackVMDataset(store.storageManager, cfg, vm, store.dataset, name, force)
+			}
+
+			if !force {
+				fmt.Printf("⚠️  WARNING: Rolling VM '%s' on %s back to checkpoint '%s' will lose all changes made since.\n", vmName, cfg.Label(), name)
+				confirmed, err := confirm("Are you sure you want to continue? (y/N): ")
+				if err != nil {
+					return err
+				}
+				if !confirmed {
+					messages.Println(messages.Cancelled)
+					return nil
+				}
+			}
+
+			statusf("Rolling VM '%s' back to checkpoint '%s'...\n", vmName, name)
+			if err := virshClient.RestoreSnapshot(vmName, name); err != nil {
+				return fmt.Errorf("failed to roll back: %w", err)
+			}
+
+			statusf("VM '%s' rolled back to checkpoint '%s'\n", vmName, name)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolP("force", "f", false, "Roll back without confirmation")
+	cmd.Flags().Bool("stop", false, "Power off a running VM with its own ZFS dataset before rolling back")
+	return cmd
+}
+
+// checkpointStore keeps a VM's checkpoints as ZFS snapshots of its dataset, or as
+// internal snapshots when it has none, like 'snapshot create'
+type checkpointStore struct {
+	virshClient    *virsh.Client
+	storageManager *storage.Manager
+	vmName         string
+	dataset        string
+}
+
+// newCheckpointStore returns the checkpoint store for a VM
+func newCheckpointStore(sshClient *ssh.Client, cfg *config.Config, virshClient *virsh.Client, vmName string) *checkpointStore {
+	storageManager := newStorageManager(sshClient, cfg)
+	return &checkpointStore{
+		virshClient:    virshClient,
+		storageManager: storageManager,
+		vmName:         vmName,
+		dataset:        vmDataset(storageManager, virshClient, vmName),
+	}
+}
+
+// list returns the names of the VM's checkpoints, oldest first
+func (s *checkpointStore) list() ([]string, error) {
+	var names []string
+	if s.dataset != "" {
+		snapshots, err := s.storageManager.ListDatasetSnapshots(s.dataset)
+		if err != nil {
+			return nil, err
+		}
+		for _, snapshot := range snapshots {
+			names = append(names, snapshot.Name)
+		}
+	} else {
+		snapshots, err := s.virshClient.ListSnapshots(s.vmName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list snapshots: %w", err)
+		}
+		for _, snapshot := range snapshots {
+			names = append(names, snapshot.Name)
+		}
+	}
+
+	names = slices.DeleteFunc(names, func(name string) bool {
+		return !strings.HasPrefix(name, checkpointPrefix)
+	})
+	slices.Sort(names)
+	return names, nil
+}
+
+// create takes a checkpoint
+func (s *checkpointStore) create(vm *virsh.VMInfo, name string) error {
+	description := "last known good state, taken by 'qnap-vm checkpoint'"
+	if s.dataset != "" {
+		return snapshotVMDataset(s.storageManager, s.virshClient, vm, s.dataset, name, description)
+	}
+	return s.virshClient.CreateSnapshot(s.vmName, name, description)
+}
+
+// remove deletes a checkpoint
+func (s *checkpointStore) remove(name string) error {
+	if s.dataset != "" {
+		return s.storageManager.DestroyDatasetSnapshot(s.dataset, name)
+	}
+	return s.virshClient.DeleteSnapshot(s.vmName, name)
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/cloudinit"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/scttfrdmn/qnap-vm/pkg/storage"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+// addCloudInitFlags adds the flags that configure a guest through a cloud-init seed
+func addCloudInitFlags(cmd *cobra.Command) {
+	cmd.Flags().String("user-data", "", "cloud-init user-data file for cloud images (attached on a seed CD-ROM)")
+	cmd.Flags().String("ip", "", "Static guest address via cloud-init, e.g. 192.168.1.50/24 (needs --network bridge= or network=)")
+	cmd.Flags().String("gateway", "", "Default gateway for --ip")
+	cmd.Flags().StringSlice("dns", nil, "DNS servers for --ip (comma-separated or repeatable)")
+}
+
+// cloudInitSeed returns the cloud-init seed the create flags describe, or nil when none
+// are given. mac is the MAC address of the VM's network card, which --ip configures.
+func cloudInitSeed(cmd *cobra.Command, vmName string, network virsh.VMNetwork, mac string) (*cloudinit.Seed, error) {
+	userDataFile, _ := cmd.Flags().GetString("user-data")
+	address, _ := cmd.Flags().GetString("ip")
+	gateway, _ := cmd.Flags().GetString("gateway")
+	dns, _ := cmd.Flags().GetStringSlice("dns")
+	if address == "" && (gateway != "" || len(dns) > 0) {
+		return nil, fmt.Errorf("--gateway and --dns apply to --ip")
+	}
+	if userDataFile == "" && address == "" {
+		return nil, nil
+	}
+
+	seed := &cloudinit.Seed{InstanceID: vmName, Hostname: cloudinit.Hostname(vmName)}
+	if userDataFile != "" {
+		userData, err := os.ReadFile(userDataFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read user-data: %w", err)
+		}
+		seed.UserData = userData
+	}
+	if address != "" {
+		if network.Mode != virsh.NetworkBridge && network.Mode != virsh.NetworkNAT {
+			return nil, fmt.Errorf("--ip needs --network bridge=NAME or network=NAME; user-mode networking assigns its own address")
+		}
+		var err error
+		if seed.Network, err = cloudinit.NewNetwork(mac, address, gateway, dns); err != nil {
+			return nil, err
+		}
+	}
+	return seed, nil
+}
+
+// uploadSeed writes a cloud-init seed ISO for a VM to pool and returns its path
+func uploadSeed(sshClient *ssh.Client, storageManager *storage.Manager, pool *storage.Pool, vmName string, seed *cloudinit.Seed) (string, error) {
+	image, err := seed.ISO()
+	if err != nil {
+		return "", err
+	}
+	seedPath := storageManager.DiskPathInPool(pool, vmName+"-seed.iso")
+	if output, err := sshClient.ExecuteWithInput(fmt.Sprintf("cat > %s", ssh.Quote(seedPath)), bytes.NewReader(image)); err != nil {
+		return "", fmt.Errorf("failed to write cloud-init seed: %w\nOutput: %s", err, output)
+	}
+	return seedPath, nil
+}
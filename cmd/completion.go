@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+// completionCacheAge is how long names fetched for completion are reused, so repeated
+// tab presses do not each open an SSH connection
+const completionCacheAge = time.Minute
+
+// completionTimeout limits the SSH connection made for completion; a NAS that does not
+// answer must not hang the shell
+const completionTimeout = 3 * time.Second
+
+// remoteNames returns the names of kind on the configured NAS, from the inventory cache
+// when it is fresh, else from fetch. When the NAS cannot be reached, older cached names
+// are better than none.
+func remoteNames(cmd *cobra.Command, kind string, fetch func(*ssh.Client, *virsh.Client, *config.Config) ([]string, error)) []string {
+	// Completion runs without the root command's hooks
+	useConfigFlag(cmd)
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return nil
+	}
+	inv, err := loadInventory(cfg)
+	if err != nil {
+		return nil
+	}
+	now := time.Now()
+	if names, ok := inv.CachedNames(kind, completionCacheAge, now); ok {
+		return names
+	}
+
+	names, err := fetchNames(cfg, fetch)
+	if err != nil {
+		names, _ = inv.CachedNames(kind, 0, now)
+		if names == nil && kind == "vms" && inv.VMs != nil {
+			names = vmNames(inv.VMs.VMs)
+		}
+		return names
+	}
+
+	inv.SetNames(kind, names, now)
+	if err := inv.Save(); err != nil {
+		// The cache only saves a connection next time
+	}
+	return names
+}
+
+// fetchNames connects to the NAS and lists names with fetch
+func fetchNames(cfg *config.Config, fetch func(*ssh.Client, *virsh.Client, *config.Config) ([]string, error)) ([]string, error) {
+	sshClient, virshClient, err := connectWithTimeout(*cfg, completionTimeout, false)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sshClient.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+		}
+	}()
+
+	names, err := fetch(sshClient, virshClient, cfg)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// vmNames returns the names of vms
+func vmNames(vms []virsh.VMInfo) []string {
+	names := make([]string, 0, len(vms))
+	for _, vm := range vms {
+		names = append(names, vm.Name)
+	}
+	return names
+}
+
+// matching returns the names starting with prefix
+func matching(names []string, prefix string) []string {
+	var matches []string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, name)
+		}
+	}
+	return matches
+}
+
+// completeVM completes a VM name as the first argument
+func completeVM(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	names := remoteNames(cmd, "vms", func(_ *ssh.Client, virshClient *virsh.Client, _ *config.Config) ([]string, error) {
+		vms, err := virshClient.ListVMs()
+		if err != nil {
+			return nil, err
+		}
+		return vmNames(vms), nil
+	})
+	return matching(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeVMSnapshot completes a VM name, then one of that VM's snapshots
+func completeVMSnapshot(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 1 {
+		return completeVM(cmd, args, toComplete)
+	}
+	vmName := args[0]
+	names := remoteNames(cmd, "snapshots/"+vmName, func(_ *ssh.Client, virshClient *virsh.Client, _ *config.Config) ([]string, error) {
+		snapshots, err := virshClient.ListSnapshots(vmName)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(snapshots))
+		for _, snapshot := range snapshots {
+			names = append(names, snapshot.Name)
+		}
+		return names, nil
+	})
+	return matching(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completePool completes a storage pool name
+func completePool(cmd *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	names := remoteNames(cmd, "pools", func(sshClient *ssh.Client, _ *virsh.Client, cfg *config.Config) ([]string, error) {
+		pools, err := newStorageManager(sshClient, cfg).DetectPools()
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(pools))
+		for _, pool := range pools {
+			names = append(names, pool.Name)
+		}
+		return names, nil
+	})
+	return matching(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeHostName completes a host entry name from the config file; it needs no NAS
+func completeHostName(cmd *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	useConfigFlag(cmd)
+	configFile, err := config.LoadConfig()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	names := configFile.ListHosts()
+	sort.Strings(names)
+	return matching(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
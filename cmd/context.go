@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// commandCtx is the context of the running command, cancelled by Ctrl+C and by
+	// --timeout. Remote commands are terminated on the NAS when it is done.
+	commandCtx = context.Background()
+	// commandTimeout is the --timeout in effect, for the error when it expires
+	commandTimeout time.Duration
+	// cancelTimeout releases the --timeout timer
+	cancelTimeout context.CancelFunc = func() {}
+)
+
+// interruptContext returns a context cancelled by the first Ctrl+C or SIGTERM. The
+// handler then steps aside, so a second Ctrl+C quits at once even where nothing
+// watches the context, such as a local file copy.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-signals:
+			signal.Stop(signals)
+			fmt.Fprintln(os.Stderr, "\nInterrupted; stopping remote commands (press Ctrl+C again to quit at once)")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// setupContext applies --timeout to the command's context and makes it the context of
+// the connections the command opens
+func setupContext(cmd *cobra.Command) error {
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	if timeout < 0 {
+		return messages.Errorf(messages.Usage, fmt.Errorf("invalid --timeout %s; it must not be negative", timeout))
+	}
+	if timeout > 0 {
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		commandTimeout = timeout
+	}
+
+	commandCtx = ctx
+	cmd.SetContext(ctx)
+	return nil
+}
+
+// explainCancel says why a command stopped early, for errors caused by Ctrl+C or --timeout
+func explainCancel(err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) && commandTimeout > 0:
+		return fmt.Errorf("timed out after %s (--timeout): %w", commandTimeout, err)
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("interrupted: %w", err)
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+// addDeviceFlags adds the video, tablet and sound flags for a new VM
+func addDeviceFlags(cmd *cobra.Command) {
+	cmd.Flags().String("video", "", "Video model: virtio, qxl, vga, cirrus, bochs or none (default: host's devices.video, else libvirt's)")
+	cmd.Flags().Bool("tablet", false, "Add a USB tablet for accurate mouse tracking over VNC (default: host's devices.tablet)")
+	cmd.Flags().String("sound", "", "Sound model: ich9, ich6 or ac97 (default: host's devices.sound, else none)")
+}
+
+// deviceOptions returns the video, tablet and sound devices for a new VM: the host's
+// defaults overridden by the flags
+func deviceOptions(cmd *cobra.Command, cfg *config.Config) (config.DeviceDefaults, error) {
+	devices := cfg.Devices
+	if cmd.Flags().Changed("video") {
+		devices.Video, _ = cmd.Flags().GetString("video")
+	}
+	if cmd.Flags().Changed("tablet") {
+		devices.Tablet, _ = cmd.Flags().GetBool("tablet")
+	}
+	if cmd.Flags().Changed("sound") {
+		devices.Sound, _ = cmd.Flags().GetString("sound")
+	}
+	return devices, virsh.ValidateDeviceModels(devices.Video, devices.Sound)
+}
+
+// applyDeviceDefaults updates a host's device defaults from the 'config set' flags
+func applyDeviceDefaults(cmd *cobra.Command, defaults *config.DeviceDefaults) error {
+	if cmd.Flags().Changed("device-video") {
+		defaults.Video, _ = cmd.Flags().GetString("device-video")
+	}
+	if cmd.Flags().Changed("device-tablet") {
+		defaults.Tablet, _ = cmd.Flags().GetBool("device-tablet")
+	}
+	if cmd.Flags().Changed("device-sound") {
+		defaults.Sound, _ = cmd.Flags().GetString("device-sound")
+	}
+	return virsh.ValidateDeviceModels(defaults.Video, defaults.Sound)
+}
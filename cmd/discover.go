@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/discover"
+	"github.com/spf13/cobra"
+)
+
+func discoverCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "discover",
+		Short: "Find QNAP devices on the local network",
+		Long: `Find QNAP NAS units on the local network with mDNS (the _qdiscover service QTS
+advertises for Qfinder) and SSDP, and list their model and address.
+
+On a terminal, qnap-vm offers to create a config entry for each device that is not
+configured yet; --yes adds them all. New entries use --username (default: admin) and
+port 22; set a password or key afterwards with 'qnap-vm config set'.
+
+Discovery uses multicast, so it only finds devices on the same network segment.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			wait, _ := cmd.Flags().GetDuration("wait")
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+			if wait <= 0 {
+				return fmt.Errorf("--wait must be positive")
+			}
+
+			configFile, err := config.LoadConfig()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+
+			if !jsonOutput {
+				statusf("Searching for QNAP devices for %s...\n", wait)
+			}
+			devices, err := discover.Discover(cmd.Context(), wait)
+			if err != nil {
+				return fmt.Errorf("discovery failed: %w", err)
+			}
+
+			if jsonOutput {
+				if devices == nil {
+					devices = []discover.Device{}
+				}
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(devices)
+			}
+
+			if len(devices) == 0 {
+				fmt.Println("No QNAP devices found. Check that the NAS is on this network segment and that")
+				fmt.Println("Bonjour (Control Panel > Network Services > Service Discovery) is enabled.")
+				return nil
+			}
+
+			fmt.Println()
+			fmt.Printf("%-20s %-15s %-16s %-22s %-15s\n", "NAME", "MODEL", "ADDRESS", "FIRMWARE", "CONFIG")
+			fmt.Printf("%-20s %-15s %-16s %-22s %-15s\n", "--------------------", "---------------", "----------------", "----------------------", "---------------")
+			var unconfigured []discover.Device
+			for _, d := range devices {
+				entry := configuredEntry(configFile, d)
+				if entry == "" {
+					unconfigured = append(unconfigured, d)
+					entry = "-"
+				}
+				fmt.Printf("%-20s %-15s %-16s %-22s %-15s\n",
+					orDash(d.Name), orDash(d.Model), d.Address, orDash(d.Firmware), entry)
+			}
+
+			if len(unconfigured) == 0 || !canOfferEntries() {
+				return nil
+			}
+			return addDiscoveredHosts(cmd, configFile, unconfigured)
+		},
+	}
+
+	cmd.Flags().Duration("wait", 3*time.Second, "How long to wait for answers")
+	cmd.Flags().Bool("json", false, "Output devices as JSON without offering config entries")
+
+	return cmd
+}
+
+// canOfferEntries reports whether discover may create config entries: --yes adds them,
+// and otherwise someone has to be at a terminal to be asked
+func canOfferEntries() bool {
+	if yes, _ := rootCmd.PersistentFlags().GetBool("yes"); yes {
+		return true
+	}
+	noInput, _ := rootCmd.PersistentFlags().GetBool("no-input")
+	return !noInput && stdinIsTerminal()
+}
+
+// addDiscoveredHosts offers a config entry for each device and saves the accepted ones
+func addDiscoveredHosts(cmd *cobra.Command, configFile *config.ConfigFile, devices []discover.Device) error {
+	username, _ := cmd.Flags().GetString("username")
+	if username == "" {
+		username = "admin"
+	}
+
+	fmt.Println()
+	var added []string
+	for _, d := range devices {
+		name := discoveredEntryName(configFile, d)
+		ok, err := confirm(fmt.Sprintf("Add %s (%s) to the config as '%s'? (y/N): ", discoveredLabel(d), d.Address, name))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		entry := config.Config{Host: d.Address, Username: username}
+		entry.SetDefaults()
+		configFile.SetHostConfig(name, entry)
+		if configFile.DefaultHost == "" {
+			configFile.SetDefaultHost(name)
+		}
+		added = append(added, name)
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	if err := config.SaveConfig(configFile); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	for _, name := range added {
+		statusf("Configuration saved for host '%s'\n", name)
+	}
+	statusf("Connect once with --host-name %s to be asked for the password, or set it with\n", added[0])
+	statusf("'qnap-vm config set --name %s --password -' (or --keyfile).\n", added[0])
+	return nil
+}
+
+// configuredEntry returns the config entry already pointing at d, or ""
+func configuredEntry(configFile *config.ConfigFile, d discover.Device) string {
+	hosts := configFile.ListHosts()
+	slices.Sort(hosts)
+	for _, name := range hosts {
+		host := configFile.Hosts[name].Host
+		if host == d.Address || (d.Name != "" && (strings.EqualFold(host, d.Name) || strings.EqualFold(host, d.Name+".local"))) {
+			return name
+		}
+	}
+	return ""
+}
+
+// discoveredEntryName derives an unused config entry name from the device's name,
+// e.g. "Vault NAS" becomes "vault-nas"
+func discoveredEntryName(configFile *config.ConfigFile, d discover.Device) string {
+	base := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, d.Name)
+	base = strings.Trim(base, "-")
+	for strings.Contains(base, "--") {
+		base = strings.ReplaceAll(base, "--", "-")
+	}
+	if base == "" {
+		base = "nas"
+	}
+
+	name := base
+	for i := 2; ; i++ {
+		if _, exists := configFile.Hosts[name]; !exists {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// discoveredLabel names a device in prompts: its name, or its address if it has none
+func discoveredLabel(d discover.Device) string {
+	if d.Name == "" {
+		return d.Address
+	}
+	return d.Name
+}
+
+// orDash returns s, or "-" for a table cell with no value
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,411 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/storage"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func diskCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "disk",
+		Short: "Manage VM disks",
+		Long:  "Create, attach and move virtual machine disks",
+	}
+
+	cmd.AddCommand(diskAttachCmd(), diskMoveCmd(), diskTrimCmd())
+	return cmd
+}
+
+func diskAttachCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "attach [VM_NAME]",
+		Short: "Create and attach a new disk to a VM",
+		Long: `Create a new qcow2 disk image and attach it to a VM's persistent
+configuration. The disk becomes visible to the guest after the next start.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			vmName := args[0]
+			size, _ := cmd.Flags().GetString("size")
+			poolName, _ := cmd.Flags().GetString("pool")
+			bus, _ := cmd.Flags().GetString("bus")
+			cache, _ := cmd.Flags().GetString("cache")
+			io, _ := cmd.Flags().GetString("io")
+			discard, _ := cmd.Flags().GetString("discard")
+			detectZeroes, _ := cmd.Flags().GetString("detect-zeroes")
+
+			spec, err := storage.ParseDiskSpec(fmt.Sprintf("size=%s,bus=%s,cache=%s,io=%s,discard=%s,detect_zeroes=%s",
+				size, bus, cache, io, discard, detectZeroes))
+			if err != nil {
+				return err
+			}
+			if err := virsh.ValidateDiskOptions(diskOptions(spec)); err != nil {
+				return err
+			}
+			qcow2Opts, err := qcow2Options(cmd, cfg)
+			if err != nil {
+				return err
+			}
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			target, err := virshClient.NextDiskTarget(vmName, spec.Bus)
+			if err != nil {
+				return err
+			}
+
+			storageManager := newStorageManager(sshClient, cfg)
+			pool, err := storageManager.SelectPool(poolName)
+			if err != nil {
+				return fmt.Errorf("failed to find storage pool: %w", err)
+			}
+
+			statusf("Using storage pool: %s (%s)\n", pool.Name, pool.Path)
+
+			sizeBytes, err := storage.SizeBytes(spec.Size)
+			if err != nil {
+				return err
+			}
+			if err := checkFreeSpace(cmd, []storage.SpaceRequirement{{Pool: pool, Bytes: sizeBytes}}); err != nil {
+				return err
+			}
+
+			diskPath := storageManager.NextVMDiskPath(pool, vmName)
+			statusf("Creating disk image: %s (%s)\n", diskPath, spec.Size)
+			if err := storageManager.CreateVMDiskWithOptions(diskPath, spec.Size, qcow2Opts); err != nil {
+				return fmt.Errorf("failed to create disk: %w", err)
+			}
+
+			opts := poolDiskOptions(storageManager, pool, diskOptions(spec))
+			if err := virshClient.AttachDisk(vmName, diskPath, target, spec.Bus, opts); err != nil {
+				return err
+			}
+
+			statusf("Disk attached to VM '%s' as %s (%s)\n", vmName, target, spec.Bus)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("size", "20G", "Disk size")
+	cmd.Flags().String("pool", "", "Storage pool for the disk (default: best available pool)")
+	cmd.Flags().String("bus", "virtio", "Disk bus (virtio, sata, scsi, ide)")
+	if err := cmd.RegisterFlagCompletionFunc("pool", completePool); err != nil {
+		// Flag is registered above; registering its completion cannot fail
+	}
+
+	addDiskOptionFlags(cmd)
+	addSpaceCheckFlag(cmd)
+	addQcow2Flags(cmd)
+
+	return cmd
+}
+
+// addDiskOptionFlags adds the disk cache and I/O mode flags
+func addDiskOptionFlags(cmd *cobra.Command) {
+	cmd.Flags().String("cache", "", "Disk cache mode: none (SSD pools), writeback or writethrough (default: QEMU's)")
+	cmd.Flags().String("io", "", "Disk I/O mode: native (needs --cache none) or threads (default: QEMU's)")
+	cmd.Flags().String("discard", "", "Guest TRIM handling: unmap frees space on the NAS, ignore drops it (default: unmap on SSD pools)")
+	cmd.Flags().String("detect-zeroes", "", "Zero write detection: off, on or unmap (default: unmap on SSD pools)")
+}
+
+// addQcow2Flags adds the qcow2 tuning flags to a command that creates disks
+func addQcow2Flags(cmd *cobra.Command) {
+	cmd.Flags().String("cluster-size", "", "qcow2 cluster size, a power of two from 512 to 2M (default: host config or 64K)")
+	cmd.Flags().String("compression-type", "", "qcow2 compression type (zlib, zstd)")
+	cmd.Flags().Bool("lazy-refcounts", false, "Defer qcow2 refcount updates for faster writes (image needs a check after a crash)")
+}
+
+// qcow2Options returns the qcow2 options for new disks: the host's config defaults, overridden by flags
+func qcow2Options(cmd *cobra.Command, cfg *config.Config) (storage.Qcow2Options, error) {
+	opts := storage.Qcow2Options{
+		ClusterSize:     cfg.Qcow2.ClusterSize,
+		CompressionType: cfg.Qcow2.CompressionType,
+		LazyRefcounts:   cfg.Qcow2.LazyRefcounts,
+	}
+
+	if cmd.Flags().Changed("cluster-size") {
+		opts.ClusterSize, _ = cmd.Flags().GetString("cluster-size")
+	}
+	if cmd.Flags().Changed("compression-type") {
+		opts.CompressionType, _ = cmd.Flags().GetString("compression-type")
+	}
+	if cmd.Flags().Changed("lazy-refcounts") {
+		opts.LazyRefcounts, _ = cmd.Flags().GetBool("lazy-refcounts")
+	}
+
+	if err := opts.Validate(); err != nil {
+		return storage.Qcow2Options{}, err
+	}
+	return opts, nil
+}
+
+// applyQcow2Defaults updates a host's qcow2 defaults from the 'config set' flags
+func applyQcow2Defaults(cmd *cobra.Command, defaults *config.Qcow2Defaults) error {
+	if cmd.Flags().Changed("qcow2-cluster-size") {
+		defaults.ClusterSize, _ = cmd.Flags().GetString("qcow2-cluster-size")
+	}
+	if cmd.Flags().Changed("qcow2-compression-type") {
+		defaults.CompressionType, _ = cmd.Flags().GetString("qcow2-compression-type")
+	}
+	if cmd.Flags().Changed("qcow2-lazy-refcounts") {
+		defaults.LazyRefcounts, _ = cmd.Flags().GetBool("qcow2-lazy-refcounts")
+	}
+
+	return storage.Qcow2Options{
+		ClusterSize:     defaults.ClusterSize,
+		CompressionType: defaults.CompressionType,
+		LazyRefcounts:   defaults.LazyRefcounts,
+	}.Validate()
+}
+
+func diskMoveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "migrate [VM_NAME]",
+		Aliases: []string{"move"},
+		Short:   "Move a VM's disks to another storage pool",
+		Long: `Move a VM's disk images to another storage pool and update the VM
+definition to use the new location, e.g. to rebalance VMs when a volume fills
+up. The old image is removed once the move succeeds.
+
+Stopped VMs are copied file-for-file, keeping internal snapshots. Running VMs
+need --live: their disks are copied with 'virsh blockcopy' and the VM is
+pivoted onto the copy without downtime; internal snapshots are not carried over
+in that case.`,
+		Example: `  qnap-vm disk migrate web --to-pool CACHEDEV2_DATA
+  qnap-vm disk migrate db --to-pool CACHEDEV2_DATA --disk vdb --live`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			vmName := args[0]
+			toPool, _ := cmd.Flags().GetString("to-pool")
+			target, _ := cmd.Flags().GetString("disk")
+			live, _ := cmd.Flags().GetBool("live")
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			vm, err := virshClient.GetVM(vmName)
+			if err != nil {
+				return err
+			}
+			running := strings.Contains(vm.State, "running")
+			if running && !live {
+				return fmt.Errorf("VM '%s' is running; pass --live to copy its disks while it runs, or stop it first", vmName)
+			}
+
+			storageManager := newStorageManager(sshClient, cfg)
+			pool, err := storageManager.GetPool(toPool)
+			if err != nil {
+				return err
+			}
+
+			disks, err := virshClient.ListDisks(vmName)
+			if err != nil {
+				return err
+			}
+
+			// Pick the disks to move and check they fit before copying any
+			var moves []virsh.DomainDisk
+			var oldPaths []string
+			for _, disk := range disks {
+				if target != "" && disk.Target.Dev != target {
+					continue
+				}
+				if disk.Source.File == "" {
+					statusf("Skipping %s: not a file-backed disk\n", disk.Target.Dev)
+					continue
+				}
+				if storageManager.DiskPathInPool(pool, path.Base(disk.Source.File)) == disk.Source.File {
+					statusf("Skipping %s: already in pool %s\n", disk.Target.Dev, pool.Name)
+					continue
+				}
+				moves = append(moves, disk)
+				oldPaths = append(oldPaths, disk.Source.File)
+			}
+
+			required, err := storageManager.AllocatedBytes(oldPaths)
+			if err != nil {
+				return err
+			}
+			if err := checkFreeSpace(cmd, []storage.SpaceRequirement{{Pool: pool, Bytes: required}}); err != nil {
+				return err
+			}
+
+			moved := 0
+			for _, disk := range moves {
+				newPath := storageManager.DiskPathInPool(pool, path.Base(disk.Source.File))
+				statusf("Moving %s: %s -> %s\n", disk.Target.Dev, disk.Source.File, newPath)
+				if err := moveDisk(storageManager, virshClient, vmName, disk, newPath, running); err != nil {
+					return err
+				}
+				moved++
+			}
+
+			if target != "" && moved == 0 {
+				return fmt.Errorf("disk '%s' was not moved (not found or already in pool %s)", target, pool.Name)
+			}
+
+			statusf("Moved %d disk(s) of VM '%s' to pool %s\n", moved, vmName, pool.Name)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("to-pool", "", "Destination storage pool (required)")
+	cmd.Flags().String("disk", "", "Only move the disk with this target (e.g. vdb)")
+	cmd.Flags().Bool("live", false, "Move a running VM's disks with blockcopy, without downtime")
+	if err := cmd.RegisterFlagCompletionFunc("to-pool", completePool); err != nil {
+		// Flag is registered above; registering its completion cannot fail
+	}
+
+	addSpaceCheckFlag(cmd)
+	if err := cmd.MarkFlagRequired("to-pool"); err != nil {
+		// Flag is registered above; marking cannot fail
+	}
+
+	return cmd
+}
+
+// moveDisk copies one disk to newPath, repoints the VM at it and removes the old image
+func moveDisk(storageManager *storage.Manager, virshClient *virsh.Client, vmName string, disk virsh.DomainDisk, newPath string, running bool) error {
+	oldPath := disk.Source.File
+
+	if running {
+		if err := virshClient.BlockCopy(vmName, disk.Target.Dev, newPath); err != nil {
+			return err
+		}
+	} else if err := storageManager.CopyDisk(oldPath, newPath); err != nil {
+		return err
+	}
+
+	if err := virshClient.SetDiskSource(vmName, oldPath, newPath); err != nil {
+		if !running {
+			if rmErr := storageManager.RemoveDisk(newPath); rmErr != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", rmErr)
+			}
+		}
+		return err
+	}
+
+	if err := storageManager.RemoveDisk(oldPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: disk moved but old image was not removed: %v\n", err)
+	}
+	return nil
+}
+
+func diskTrimCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trim [VM_NAME]",
+		Short: "Free space on the NAS from data deleted in a VM",
+		Long: `Run fstrim in every filesystem of a running VM with the QEMU guest agent.
+Blocks the guest has deleted are discarded from its disk images, so their space
+is returned to the storage pool.
+
+Only disks defined with discard=unmap pass the trim through; new disks on SSD
+pools get it by default, and --disk ...,discard=unmap or disk attach --discard
+unmap enable it elsewhere.`,
+		Example:           `  qnap-vm disk trim web`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			vmName := args[0]
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			domain, err := virshClient.GetDomain(vmName)
+			if err != nil {
+				return err
+			}
+			if len(domain.DiscardDisks()) == 0 {
+				fmt.Fprintf(os.Stderr, "Warning: no disk of VM '%s' has discard=unmap; the guest will trim but no space is freed on the NAS\n", vmName)
+			}
+
+			if err := virshClient.GuestPing(vmName); err != nil {
+				return err
+			}
+
+			results, err := virshClient.GuestFSTrim(vmName)
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				data, err := json.MarshalIndent(results, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(data))
+				return nil
+			}
+
+			if len(results) == 0 {
+				fmt.Printf("VM '%s' reported no filesystems to trim\n", vmName)
+				return nil
+			}
+			for _, result := range results {
+				switch {
+				case result.Error != "":
+					fmt.Printf("%s: %s\n", result.Path, result.Error)
+				case result.Trimmed > 0:
+					fmt.Printf("%s: %s trimmed\n", result.Path, formatBytes(result.Trimmed))
+				default:
+					fmt.Printf("%s: trimmed\n", result.Path)
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("json", false, "Output results in JSON format")
+
+	return cmd
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/doctor"
+	"github.com/scttfrdmn/qnap-vm/pkg/nas"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func doctorCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the NAS is ready for qnap-vm and explain how to fix what is not",
+		Long: `Run the checks behind most first-run failures and print a fix for each one
+that fails: the SSH login, the Virtualization Station installation and its
+/QVS or /KVM path, CPU virtualization and /dev/kvm, virsh reaching libvirt,
+qemu-img, whether each storage pool can be written to, and whether a bridge
+can put VMs on the LAN.
+
+Exits non-zero when a check fails; warnings only limit what VMs can do.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+
+			checks := runDoctorChecks(cfg)
+			if jsonOutput {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(checks); err != nil {
+					return err
+				}
+			} else {
+				displayDoctorChecks(cfg.Label(), checks)
+			}
+
+			if failed := doctor.Failed(checks); failed > 0 {
+				return fmt.Errorf("%d check(s) failed", failed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("json", false, "Output the checks as JSON")
+	return cmd
+}
+
+// runDoctorChecks checks the host in order; checks that need what failed are left out
+func runDoctorChecks(cfg *config.Config) []doctor.Check {
+	address := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	sshClient, err := connectSSH(*cfg, 15*time.Second, true)
+	checks := []doctor.Check{doctor.SSH(address, cfg.Username, err)}
+	if err != nil {
+		return checks
+	}
+	defer func() {
+		if err := sshClient.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+		}
+	}()
+
+	nasManager := nas.NewManager(sshClient)
+	info, err := nasManager.HostInfo()
+	if err != nil {
+		return append(checks, doctor.Check{Name: "Host information", Status: doctor.StatusFail, Detail: err.Error()})
+	}
+	checks = append(checks, doctor.Host(info)...)
+
+	storageManager := newStorageManager(sshClient, cfg)
+	if info.QVSPath == "" {
+		checks = append(checks, doctor.Skipped("virsh", "Virtualization Station not found"), doctor.Skipped("qemu-img", "Virtualization Station not found"))
+	} else {
+		checks = append(checks, doctor.Virsh(info.Libvirt, virsh.NewClient(sshClient).Initialize()))
+		checks = append(checks, doctor.QemuImg(storageManager.QemuImgVersion()))
+	}
+
+	pools, err := storageManager.DetectPools()
+	switch {
+	case err != nil:
+		checks = append(checks, doctor.Check{Name: "Storage pools", Status: doctor.StatusFail, Detail: err.Error()})
+	case len(pools) == 0:
+		checks = append(checks, doctor.NoPools())
+	}
+	for i := range pools {
+		checks = append(checks, doctor.Pool(pools[i].Name, pools[i].Path, storageManager.CheckWritable(&pools[i])))
+	}
+
+	bridges, err := nasManager.Bridges()
+	if err != nil {
+		return append(checks, doctor.Check{Name: "Bridges", Status: doctor.StatusWarn, Detail: err.Error()})
+	}
+	return append(checks, doctor.Bridges(bridges))
+}
+
+// displayDoctorChecks prints one line per check, with the fix under each that did not pass
+func displayDoctorChecks(label string, checks []doctor.Check) {
+	fmt.Printf("Checking %s\n\n", label)
+	// Continuation lines of a detail line up under its first line
+	indent := strings.Repeat(" ", len("[ OK ] ")+26)
+	warnings := 0
+	for _, check := range checks {
+		status := map[string]string{
+			doctor.StatusOK:   "[ OK ]",
+			doctor.StatusWarn: "[WARN]",
+			doctor.StatusFail: "[FAIL]",
+			doctor.StatusSkip: "[SKIP]",
+		}[check.Status]
+		if check.Status == doctor.StatusWarn {
+			warnings++
+		}
+		detail := strings.ReplaceAll(strings.TrimSpace(check.Detail), "\n", "\n"+indent)
+		fmt.Printf("%s %-25s %s\n", status, check.Name, detail)
+		if check.Fix != "" {
+			fmt.Printf("%-6s Fix: %s\n", "", check.Fix)
+		}
+	}
+
+	fmt.Println()
+	switch failed := doctor.Failed(checks); {
+	case failed > 0:
+		fmt.Printf("%d check(s) failed, %d warning(s).\n", failed, warnings)
+	case warnings > 0:
+		fmt.Printf("Ready for VMs, with %d warning(s).\n", warnings)
+	default:
+		fmt.Println("All checks passed; the NAS is ready for VMs.")
+	}
+}
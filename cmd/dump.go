@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func dumpCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dump [VM_NAME]",
+		Short: "Dump guest memory for crash analysis",
+		Long: `Take a core dump of a VM with 'virsh dump', copy it off the NAS and print
+hints for analysing it with crash (Linux) or WinDbg (Windows).
+
+The guest is paused while the dump is written unless --live is used. The
+dump is staged in the storage pool's .qnap-vm/dumps directory and removed
+after download unless --keep-remote is set.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			vmName := args[0]
+			memoryOnly, _ := cmd.Flags().GetBool("memory-only")
+			format, _ := cmd.Flags().GetString("format")
+			live, _ := cmd.Flags().GetBool("live")
+			output, _ := cmd.Flags().GetString("output")
+			keepRemote, _ := cmd.Flags().GetBool("keep-remote")
+
+			if memoryOnly && format == "" {
+				format = "elf"
+			}
+
+			stamp := time.Now().Format("20060102-150405")
+			fileName := fmt.Sprintf("%s-%s.core", vmName, stamp)
+			if output == "" {
+				output = fileName
+			}
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			if _, err := virshClient.GetVM(vmName); err != nil {
+				return err
+			}
+
+			pool, err := newStorageManager(sshClient, cfg).GetBestPool()
+			if err != nil {
+				return fmt.Errorf("failed to find storage pool: %w", err)
+			}
+
+			dumpDir := fmt.Sprintf("%s/.qnap-vm/dumps", pool.Path)
+			if _, err := sshClient.Execute(fmt.Sprintf("mkdir -p %s", ssh.Quote(dumpDir))); err != nil {
+				return fmt.Errorf("failed to create dump directory %s: %w", dumpDir, err)
+			}
+			remotePath := dumpDir + "/" + fileName
+
+			statusf("Dumping VM '%s' to %s...\n", vmName, remotePath)
+			opts := virsh.DumpOptions{MemoryOnly: memoryOnly, Format: format, Live: live}
+			if err := virshClient.DumpVM(vmName, remotePath, opts); err != nil {
+				return err
+			}
+
+			statusf("Downloading dump to %s...\n", output)
+			result, err := sshClient.Download(remotePath, output, transferOptions(cmd))
+			if err != nil {
+				return fmt.Errorf("failed to download dump (kept on device at %s): %w", remotePath, err)
+			}
+			printTransferResult(result)
+
+			if keepRemote {
+				statusf("Remote copy kept at %s\n", remotePath)
+			} else if _, err := sshClient.Execute(fmt.Sprintf("rm -f %s", ssh.Quote(remotePath))); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to remove remote dump %s: %v\n", remotePath, err)
+			}
+
+			printDumpHints(output, memoryOnly, format)
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("memory-only", false, "Dump guest memory only, in a format crash/WinDbg can read")
+	cmd.Flags().String("format", "", "Memory-only dump format (elf, kdump-zlib, kdump-lzo, kdump-snappy, win-dmp)")
+	cmd.Flags().Bool("live", false, "Keep the guest running while dumping (dump may be inconsistent)")
+	cmd.Flags().StringP("output", "o", "", "Local file for the dump (default: VM_NAME-TIMESTAMP.core)")
+	cmd.Flags().Bool("keep-remote", false, "Keep the dump on the device after download")
+	addTransferFlags(cmd)
+
+	return cmd
+}
+
+// printDumpHints prints how to open a dump with common crash analysis tools
+func printDumpHints(path string, memoryOnly bool, format string) {
+	fmt.Printf("\nAnalysis hints:\n")
+
+	if !memoryOnly {
+		fmt.Printf("  - This is a full QEMU save image, not a memory core. Re-run with --memory-only\n")
+		fmt.Printf("    for a dump that crash or WinDbg can open.\n")
+		return
+	}
+
+	switch format {
+	case "win-dmp":
+		fmt.Printf("  - Windows: open in WinDbg with 'File > Open Crash Dump' or 'windbg -z %s',\n", path)
+		fmt.Printf("    then run '!analyze -v'. Set the symbol path with '.symfix; .reload'.\n")
+	default:
+		fmt.Printf("  - Linux: install the guest kernel's debug symbols (vmlinux) and run\n")
+		fmt.Printf("    'crash /usr/lib/debug/lib/modules/<version>/vmlinux %s'.\n", path)
+		fmt.Printf("    Useful commands: 'bt -a', 'log', 'ps', 'kmem -i'.\n")
+		fmt.Printf("  - Windows guests: re-run with '--format win-dmp' to get a WinDbg-compatible dump.\n")
+	}
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes. Scripts branch on these, so a code never changes meaning once released.
+const (
+	ExitOK         = 0
+	ExitError      = 1 // Any failure without a more specific code
+	ExitUsage      = 2 // Invalid flags or arguments
+	ExitNotFound   = 3 // The VM does not exist
+	ExitConnection = 4 // The NAS could not be reached or refused the login
+	ExitExists     = 5 // The VM or network to create already exists
+	ExitNeedsYes   = 6 // A confirmation was needed but input is not interactive
+	ExitTimeout    = 7 // --timeout expired; remote commands were stopped
+
+	ExitInterrupted = 130 // Ctrl+C or SIGTERM, as shells report 128 + SIGINT
+)
+
+// exitCodes maps message IDs to the exit code of errors carrying them
+var exitCodes = map[messages.ID]int{
+	messages.Usage:         ExitUsage,
+	messages.VMNotFound:    ExitNotFound,
+	messages.ConnectFailed: ExitConnection,
+	messages.VMExists:      ExitExists,
+	messages.NetworkExists: ExitExists,
+	messages.NeedsYes:      ExitNeedsYes,
+}
+
+// ExitCode returns the process exit code for the error a command returned. The first
+// message error in the chain with a code decides, so wrapping keeps the code.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	for cause := err; cause != nil; cause = errors.Unwrap(cause) {
+		if code, ok := exitCodes[messages.IDOf(cause)]; ok {
+			return code
+		}
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ExitTimeout
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	}
+	return ExitError
+}
+
+// markUsageErrors tags flag and argument errors of root and its subcommands as usage
+// errors, so they exit with ExitUsage
+func markUsageErrors(root *cobra.Command) {
+	// Subcommands inherit the flag error function
+	root.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return messages.Errorf(messages.Usage, err)
+	})
+	markArgErrors(root)
+}
+
+// markArgErrors wraps the argument validation of cmd and its subcommands
+func markArgErrors(cmd *cobra.Command) {
+	if validate := cmd.Args; validate != nil {
+		cmd.Args = func(cmd *cobra.Command, args []string) error {
+			if err := validate(cmd, args); err != nil {
+				return messages.Errorf(messages.Usage, err)
+			}
+			return nil
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		markArgErrors(sub)
+	}
+}
+
+// progressDisabled reports whether progress bars are off, with --no-progress or --quiet
+func progressDisabled(cmd *cobra.Command) bool {
+	noProgress, _ := cmd.Flags().GetBool("no-progress")
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	return noProgress || quiet
+}
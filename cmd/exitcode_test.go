@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+)
+
+func TestExitCodeOfGetVMErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		// GetVM's errors are returned unchanged, so only a missing VM exits with ExitNotFound
+		{"missing VM", messages.Errorf(messages.VMNotFound, "web"), ExitNotFound},
+		{"timeout listing VMs", fmt.Errorf("failed to list VMs: %w", fmt.Errorf("command stopped: %w", context.DeadlineExceeded)), ExitTimeout},
+		{"interrupted", fmt.Errorf("failed to list VMs: %w", context.Canceled), ExitInterrupted},
+		{"virsh failure", fmt.Errorf("failed to list VMs: exit status 1"), ExitError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+func fileCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "file",
+		Short: "Transfer files to and from the QNAP device",
+		Long: `Copy files between this workstation and the QNAP device over SFTP with
+progress reporting and SHA-256 verification.`,
+	}
+
+	// File upload command
+	uploadCmd := &cobra.Command{
+		Use:   "upload [LOCAL_FILE] [REMOTE_PATH]",
+		Short: "Upload a file to the QNAP device",
+		Long: `Upload a local file to the QNAP device. If REMOTE_PATH ends with '/',
+the file keeps its local name inside that directory.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			localPath, remotePath := args[0], args[1]
+			if strings.HasSuffix(remotePath, "/") {
+				remotePath = path.Join(remotePath, filepath.Base(localPath))
+			}
+
+			// Connect to QNAP device
+			sshClient, _, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			statusf("Uploading %s to %s...\n", localPath, remotePath)
+			result, err := sshClient.Upload(localPath, remotePath, transferOptions(cmd))
+			if err != nil {
+				return err
+			}
+
+			printTransferResult(result)
+			return nil
+		},
+	}
+
+	// File download command
+	downloadCmd := &cobra.Command{
+		Use:   "download [REMOTE_PATH] [LOCAL_FILE]",
+		Short: "Download a file from the QNAP device",
+		Long: `Download a file from the QNAP device. If LOCAL_FILE is omitted or is an
+existing directory, the file keeps its remote name.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			remotePath := args[0]
+			localPath := path.Base(remotePath)
+			if len(args) > 1 {
+				localPath = args[1]
+				if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+					localPath = filepath.Join(localPath, path.Base(remotePath))
+				}
+			}
+
+			// Connect to QNAP device
+			sshClient, _, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			statusf("Downloading %s to %s...\n", remotePath, localPath)
+			result, err := sshClient.Download(remotePath, localPath, transferOptions(cmd))
+			if err != nil {
+				return err
+			}
+
+			printTransferResult(result)
+			return nil
+		},
+	}
+
+	addTransferFlags(uploadCmd)
+	addTransferFlags(downloadCmd)
+
+	cmd.AddCommand(uploadCmd, downloadCmd)
+	return cmd
+}
+
+// addTransferFlags adds the flags shared by commands that move files over SFTP
+func addTransferFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("no-progress", false, "Disable the progress bar")
+	cmd.Flags().Bool("no-verify", false, "Skip SHA-256 verification after the transfer")
+}
+
+// transferOptions builds SFTP transfer options from the shared transfer flags
+func transferOptions(cmd *cobra.Command) ssh.TransferOptions {
+	noProgress := progressDisabled(cmd)
+	noVerify, _ := cmd.Flags().GetBool("no-verify")
+
+	opts := ssh.TransferOptions{Verify: !noVerify}
+	if !noProgress {
+		opts.Progress = os.Stderr
+	}
+	return opts
+}
+
+// printTransferResult prints the size, duration, rate and checksum of a completed transfer
+func printTransferResult(result *ssh.TransferResult) {
+	statusf("Transferred %s in %s (%s/s)\n",
+		formatBytes(result.Bytes), result.Duration.Round(100*time.Millisecond), formatBytes(int64(result.Rate())))
+	statusf("SHA-256: %s\n", result.SHA256)
+}
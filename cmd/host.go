@@ -0,0 +1,811 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/capacity"
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/nas"
+	"github.com/scttfrdmn/qnap-vm/pkg/storage"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func hostCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "host",
+		Short: "Manage the QNAP host",
+		Long:  "Inspect the QNAP host and manage host-side integration such as update hooks",
+	}
+
+	cmd.AddCommand(hostInfoCmd(), hostCapacityCmd(), hostHookCmd(), hostRestoreCmd(), hostCleanupCmd(), hostPowerCmd())
+	return cmd
+}
+
+func hostHookCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "hook",
+		Short: "Manage the pre-update protection hook",
+		Long: `Install a NAS-side hook that checks every five minutes for a pending QTS
+firmware update or Virtualization Station upgrade. When one is detected, running
+VMs are snapshotted (or saved with managedsave) and their state is recorded so
+'qnap-vm host restore' can bring them back after the update.`,
+	}
+
+	// Hook install command
+	installCmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install the pre-update hook",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			mode, _ := cmd.Flags().GetString("mode")
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			pool, err := newStorageManager(sshClient, cfg).GetBestPool()
+			if err != nil {
+				return fmt.Errorf("failed to find storage pool: %w", err)
+			}
+
+			status, err := nas.NewManager(sshClient).InstallPreUpdateHook(virshClient.QVSPath(), pool.Path, mode)
+			if err != nil {
+				return err
+			}
+
+			statusf("Pre-update hook installed (%s mode)\n", mode)
+			statusf("%-15s: %s\n", "Script", status.ScriptPath)
+			statusf("%-15s: %s\n", "State File", status.StateFile)
+			return nil
+		},
+	}
+
+	installCmd.Flags().String("mode", nas.ModeSnapshot, "How to protect running VMs (snapshot, managedsave)")
+
+	// Hook uninstall command
+	uninstallCmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Remove the pre-update hook",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			// Connect to QNAP device
+			sshClient, _, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			if err := nas.NewManager(sshClient).UninstallPreUpdateHook(); err != nil {
+				return err
+			}
+
+			statusln("Pre-update hook removed")
+			return nil
+		},
+	}
+
+	// Hook status command
+	statusHookCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show pre-update hook status",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			// Connect to QNAP device
+			sshClient, _, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			status, err := nas.NewManager(sshClient).PreUpdateHookStatus()
+			if err != nil {
+				return err
+			}
+
+			if !status.Installed {
+				fmt.Println("Pre-update hook is not installed. Use 'qnap-vm host hook install' to add it.")
+				return nil
+			}
+
+			fmt.Printf("%-15s: %s\n", "Script", status.ScriptPath)
+			fmt.Printf("%-15s: %s\n", "State File", status.StateFile)
+			if len(status.States) == 0 {
+				fmt.Printf("%-15s: none\n", "Recorded State")
+				return nil
+			}
+
+			fmt.Printf("%-15s: %s\n\n", "Recorded At", status.RecordedAt.Local().Format("2006-01-02 15:04:05"))
+			displayPreUpdateStates(status.States)
+			return nil
+		},
+	}
+
+	cmd.AddCommand(installCmd, uninstallCmd, statusHookCmd)
+	return cmd
+}
+
+func hostRestoreCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore VMs protected by the pre-update hook",
+		Long: `Bring back VMs recorded by the pre-update hook after a firmware or
+Virtualization Station update. VMs that were running are started again (which
+resumes managedsave images). With --revert, VMs are first reverted to their
+pre-update snapshots.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			revert, _ := cmd.Flags().GetBool("revert")
+			force, _ := cmd.Flags().GetBool("force")
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			nasManager := nas.NewManager(sshClient)
+			status, err := nasManager.PreUpdateHookStatus()
+			if err != nil {
+				return err
+			}
+
+			if len(status.States) == 0 {
+				fmt.Println("No pre-update state recorded; nothing to restore.")
+				return nil
+			}
+
+			displayPreUpdateStates(status.States)
+			fmt.Println()
+
+			// Confirmation unless force is used
+			if revert && !force {
+				fmt.Printf("⚠️  WARNING: Reverting VMs on %s will discard all changes made since the pre-update snapshots.\n", cfg.Label())
+				confirmed, err := confirm("Are you sure you want to continue? (y/N): ")
+				if err != nil {
+					return err
+				}
+				if !confirmed {
+					messages.Println(messages.Cancelled)
+					return nil
+				}
+			}
+
+			failures := 0
+			for _, state := range status.States {
+				if revert && state.Snapshot != "" {
+					statusf("Reverting VM '%s' to snapshot '%s'...\n", state.Name, state.Snapshot)
+					if err := virshClient.RestoreSnapshot(state.Name, state.Snapshot); err != nil {
+						fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+						failures++
+						continue
+					}
+				}
+
+				vm, err := virshClient.GetVM(state.Name)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: VM '%s' not found\n", state.Name)
+					failures++
+					continue
+				}
+
+				if state.State == "running" && !strings.Contains(vm.State, "running") {
+					messages.Println(messages.VMStarting, state.Name)
+					if err := virshClient.StartVM(state.Name); err != nil {
+						fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+						failures++
+						continue
+					}
+				}
+			}
+
+			if failures > 0 {
+				return fmt.Errorf("%d VM(s) could not be restored; recorded state kept in %s", failures, status.StateFile)
+			}
+
+			if err := nasManager.ClearPreUpdateState(status.StateFile); err != nil {
+				return err
+			}
+
+			statusf("Restored %d VM(s)\n", len(status.States))
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("revert", false, "Revert VMs to their pre-update snapshots before starting them")
+	cmd.Flags().BoolP("force", "f", false, "Revert without confirmation")
+
+	return cmd
+}
+
+func hostCleanupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Remove qnap-vm leftovers from the NAS",
+		Long: `Remove files qnap-vm placed on the NAS that are no longer needed:
+interrupted uploads, temporary domain XML, hook scripts that are no longer
+scheduled and empty .qnap-vm directories. VM disks, ISOs and recorded
+pre-update state are never touched. Staged crash dumps are only removed
+with --dumps.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			includeDumps, _ := cmd.Flags().GetBool("dumps")
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			pools, err := newStorageManager(sshClient, cfg).DetectPools()
+			if err != nil {
+				return fmt.Errorf("failed to detect storage pools: %w", err)
+			}
+			var poolPaths []string
+			for _, pool := range pools {
+				poolPaths = append(poolPaths, pool.Path)
+			}
+
+			vms, err := virshClient.ListVMs()
+			if err != nil {
+				return fmt.Errorf("failed to list VMs: %w", err)
+			}
+			var vmNames []string
+			for _, vm := range vms {
+				vmNames = append(vmNames, vm.Name)
+			}
+
+			nasManager := nas.NewManager(sshClient)
+			items, err := nasManager.FindLeftovers(poolPaths, vmNames, includeDumps)
+			if err != nil {
+				return err
+			}
+
+			if dryRun {
+				if len(items) == 0 {
+					fmt.Println("Nothing to clean up.")
+					return nil
+				}
+				fmt.Println("Would remove:")
+				displayCleanupItems(items)
+				return nil
+			}
+
+			removed, err := nasManager.RemoveLeftovers(poolPaths, items)
+			if len(removed) > 0 {
+				fmt.Println("Removed:")
+				displayCleanupItems(removed)
+			} else if err == nil {
+				fmt.Println("Nothing to clean up.")
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().Bool("dry-run", false, "Show what would be removed without removing anything")
+	cmd.Flags().Bool("dumps", false, "Also remove crash dumps kept on the device with 'dump --keep-remote'")
+
+	return cmd
+}
+
+// vmPowerEntry is a running VM's share of host power as reported by 'host power'
+type vmPowerEntry struct {
+	Name        string  `json:"name"`
+	CPUPercent  float64 `json:"cpu_percent"`
+	Watts       float64 `json:"watts"`
+	MonthlyKWh  float64 `json:"monthly_kwh"`
+	MonthlyCost float64 `json:"monthly_cost,omitempty"`
+}
+
+// hostPowerReport is the output of 'host power --json'
+type hostPowerReport struct {
+	nas.PowerReading
+	MonthlyKWh  float64        `json:"monthly_kwh"`
+	MonthlyCost float64        `json:"monthly_cost,omitempty"`
+	VMs         []vmPowerEntry `json:"vms"`
+}
+
+func hostPowerCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "power",
+		Short: "Show host power draw and each running VM's share",
+		Long: `Measure the NAS's power draw, temperatures and CPU load over a short interval
+and attribute the power to running VMs in proportion to the CPU time they used.
+
+Power comes from the CPU package energy counters (Intel RAPL) where available,
+which cover the CPU and memory controller but not disks, fans or power supply
+losses, or from a hardware monitor power sensor. Temperatures come from the
+QTS getsysinfo tool. Use --price to show the monthly cost at a given price per
+kWh.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			interval, _ := cmd.Flags().GetInt("interval")
+			price, _ := cmd.Flags().GetFloat64("price")
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+			if interval < 1 {
+				return fmt.Errorf("--interval must be at least 1 second")
+			}
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			nasManager := nas.NewManager(sshClient)
+			before, err := nasManager.SamplePower()
+			if err != nil {
+				return err
+			}
+			cpuBefore, err := virshClient.ActiveCPUTimes()
+			if err != nil {
+				return err
+			}
+
+			if !jsonOutput {
+				statusf("Measuring for %d seconds...\n", interval)
+			}
+			time.Sleep(time.Duration(interval) * time.Second)
+
+			after, err := nasManager.SamplePower()
+			if err != nil {
+				return err
+			}
+			cpuAfter, err := virshClient.ActiveCPUTimes()
+			if err != nil {
+				return err
+			}
+
+			reading := nas.ComparePowerSamples(before, after)
+			report := hostPowerReport{
+				PowerReading: reading,
+				MonthlyKWh:   nas.MonthlyKWh(reading.Watts),
+				MonthlyCost:  nas.MonthlyKWh(reading.Watts) * price,
+			}
+			for name, cpuTime := range cpuAfter {
+				start, ok := cpuBefore[name]
+				if !ok {
+					// Started during the interval; nothing to compare against
+					continue
+				}
+				used := cpuTime - start
+				watts := reading.AttributeWatts(used)
+				report.VMs = append(report.VMs, vmPowerEntry{
+					Name:        name,
+					CPUPercent:  float64(used) / float64(reading.Interval.Nanoseconds()) * 100,
+					Watts:       watts,
+					MonthlyKWh:  nas.MonthlyKWh(watts),
+					MonthlyCost: nas.MonthlyKWh(watts) * price,
+				})
+			}
+			sort.Slice(report.VMs, func(i, j int) bool { return report.VMs[i].Watts > report.VMs[j].Watts })
+
+			if jsonOutput {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(report)
+			}
+
+			displayPowerReport(report, price > 0)
+			return nil
+		},
+	}
+
+	cmd.Flags().IntP("interval", "i", 5, "Measurement interval in seconds")
+	cmd.Flags().Float64("price", 0, "Electricity price per kWh, to show monthly cost")
+	cmd.Flags().Bool("json", false, "Output as JSON")
+
+	return cmd
+}
+
+// displayPowerReport prints host power and the per-VM breakdown
+func displayPowerReport(report hostPowerReport, showCost bool) {
+	fmt.Println()
+	switch report.Source {
+	case nas.PowerSourceRAPL:
+		fmt.Printf("%-15s: %.1f W (CPU package, RAPL)\n", "Power", report.Watts)
+	case nas.PowerSourceHwmon:
+		fmt.Printf("%-15s: %.1f W (hardware monitor)\n", "Power", report.Watts)
+	default:
+		fmt.Printf("%-15s: not available (no RAPL or hwmon power sensors on this NAS)\n", "Power")
+	}
+	fmt.Printf("%-15s: %.1f%%\n", "CPU busy", report.CPUBusy)
+	if report.CPUTemp > 0 {
+		fmt.Printf("%-15s: %.0f °C\n", "CPU temp", report.CPUTemp)
+	}
+	if report.SysTemp > 0 {
+		fmt.Printf("%-15s: %.0f °C\n", "System temp", report.SysTemp)
+	}
+	if report.Source != "" {
+		line := fmt.Sprintf("%.1f kWh", report.MonthlyKWh)
+		if showCost {
+			line += fmt.Sprintf(" (%.2f)", report.MonthlyCost)
+		}
+		fmt.Printf("%-15s: %s\n", "Per month", line)
+	}
+
+	if len(report.VMs) == 0 {
+		fmt.Println("\nNo running VMs.")
+		return
+	}
+
+	fmt.Println()
+	fmt.Printf("%-20s %-8s %-8s %-10s %s\n", "VM", "CPU%", "WATTS", "KWH/MONTH", "COST/MONTH")
+	fmt.Printf("%-20s %-8s %-8s %-10s %s\n", "--------------------", "--------", "--------", "----------", "----------")
+	for _, vm := range report.VMs {
+		watts, kwh, cost := "-", "-", "-"
+		if report.Source != "" {
+			watts = fmt.Sprintf("%.1f", vm.Watts)
+			kwh = fmt.Sprintf("%.1f", vm.MonthlyKWh)
+			if showCost {
+				cost = fmt.Sprintf("%.2f", vm.MonthlyCost)
+			}
+		}
+		fmt.Printf("%-20s %-8s %-8s %-10s %s\n", vm.Name, fmt.Sprintf("%.1f", vm.CPUPercent), watts, kwh, cost)
+	}
+}
+
+// displayCleanupItems prints cleanup items with the reason each is safe to remove
+func displayCleanupItems(items []nas.CleanupItem) {
+	for _, item := range items {
+		fmt.Printf("  %-60s %s\n", item.Path, item.Reason())
+	}
+}
+
+// displayPreUpdateStates prints the VM states recorded by the pre-update hook
+func displayPreUpdateStates(states []nas.VMState) {
+	fmt.Printf("%-20s %-12s %-12s %-30s\n", "NAME", "STATE", "ACTION", "SNAPSHOT")
+	fmt.Printf("%-20s %-12s %-12s %-30s\n", "--------------------", "------------", "------------", "------------------------------")
+	for _, state := range states {
+		fmt.Printf("%-20s %-12s %-12s %-30s\n", state.Name, state.State, state.Action, state.Snapshot)
+	}
+}
+
+func hostInfoCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "info",
+		Short: "Show the NAS's model, firmware, CPU, memory and virtualization support",
+		Long: `Show what matters before sizing a VM: the NAS model and QTS or QuTS hero
+version, the CPU model with its cores and threads, total and available memory,
+whether the kernel offers KVM (and nested virtualization, vhost-net and the
+IOMMU for PCI passthrough), and the Virtualization Station, QEMU and libvirt
+versions. Works without a working Virtualization Station, and says what is
+missing when VMs cannot run.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+
+			// Connect to QNAP device; Virtualization Station may be what is missing
+			sshClient, err := connectSSH(*cfg, 30*time.Second, true)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			info, err := nas.NewManager(sshClient).HostInfo()
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(info)
+			}
+
+			displayHostInfo(cfg.Label(), info)
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("json", false, "Output as JSON")
+	return cmd
+}
+
+// displayHostInfo prints host information by section, followed by what stops VMs
+// from running
+func displayHostInfo(label string, info *nas.HostInfo) {
+	fmt.Printf("Host: %s\n\n", label)
+	firmware := strings.TrimSpace(info.OS + " " + info.Version)
+	if info.Build != "" {
+		firmware += fmt.Sprintf(" (build %s)", info.Build)
+	}
+	fmt.Printf("%-18s: %s\n", "Model", orDash(info.Model))
+	fmt.Printf("%-18s: %s\n", "Hostname", orDash(info.Hostname))
+	fmt.Printf("%-18s: %s\n", "Firmware", firmware)
+	fmt.Printf("%-18s: %s (%s)\n", "Kernel", orDash(info.Kernel), orDash(info.Arch))
+
+	fmt.Println()
+	fmt.Printf("%-18s: %s\n", "CPU", orDash(info.CPUModel))
+	fmt.Printf("%-18s: %d cores, %d threads\n", "CPU cores", info.Cores, info.CPUs)
+	fmt.Printf("%-18s: %s\n", "Memory total", formatBytes(info.MemTotalKB*1024))
+	fmt.Printf("%-18s: %s\n", "Memory available", formatBytes(info.MemAvailableKB*1024))
+
+	yesNo := func(ok bool) string {
+		if ok {
+			return "yes"
+		}
+		return "no"
+	}
+	virt := "none"
+	switch info.CPUVirt {
+	case "vmx":
+		virt = "Intel VT-x"
+	case "svm":
+		virt = "AMD-V"
+	}
+	fmt.Println()
+	fmt.Printf("%-18s: %s\n", "CPU virtualization", virt)
+	fmt.Printf("%-18s: %s\n", "KVM", yesNo(info.KVMAvailable()))
+	if info.KVMModule != "" {
+		fmt.Printf("%-18s: %s\n", "KVM module", info.KVMModule)
+	}
+	fmt.Printf("%-18s: %s\n", "Nested virt", yesNo(info.Nested))
+	fmt.Printf("%-18s: %s\n", "vhost-net", yesNo(info.VhostNet))
+	fmt.Printf("%-18s: %s\n", "IOMMU", yesNo(info.IOMMU))
+
+	qvs := "not installed"
+	if info.QVSVersion != "" {
+		qvs = info.QVSVersion
+		if !info.QVSEnabled {
+			qvs += " (disabled)"
+		}
+	}
+	fmt.Println()
+	fmt.Printf("%-18s: %s\n", "Virt. Station", qvs)
+	fmt.Printf("%-18s: %s\n", "QVS path", orDash(info.QVSPath))
+	fmt.Printf("%-18s: %s\n", "QEMU", orDash(strings.TrimPrefix(info.QEMU, "QEMU emulator version ")))
+	fmt.Printf("%-18s: %s\n", "libvirt", orDash(info.Libvirt))
+
+	var problems []string
+	switch {
+	case info.CPUVirt == "":
+		problems = append(problems, "The CPU reports no VT-x/AMD-V; enable virtualization in the BIOS, if the CPU has it")
+	case !info.KVMAvailable():
+		problems = append(problems, "/dev/kvm or the KVM module is missing; start Virtualization Station in App Center")
+	}
+	if info.QVSVersion == "" {
+		problems = append(problems, "Install Virtualization Station from App Center")
+	} else if !info.QVSEnabled {
+		problems = append(problems, "Enable Virtualization Station in App Center")
+	}
+	if len(problems) > 0 {
+		fmt.Println("\nVMs cannot run:")
+		for _, problem := range problems {
+			fmt.Printf("  - %s\n", problem)
+		}
+	}
+}
+
+func hostCapacityCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "capacity",
+		Short: "Compare the VMs' vCPUs, memory and disks with the host's resources",
+		Long: `Sum the vCPUs, memory and disk space allocated to every VM defined on the
+host, running or not, and compare them with the NAS's CPU threads, memory and
+storage pool free space.
+
+Warnings are printed when running VMs are allocated more memory than the NAS
+has, when starting the stopped VMs would not fit in the available memory, when
+a VM has more vCPUs than the NAS has threads or running vCPUs exceed twice the
+threads, and when thin-provisioned disks could grow beyond a pool's free space.
+Memory matters most: QTS and the VMs share the RAM of the NAS, and it cannot be
+overcommitted the way CPU time can.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			info, err := nas.NewManager(sshClient).HostInfo()
+			if err != nil {
+				return err
+			}
+			vms, err := virshClient.ListVMsWithMetadata()
+			if err != nil {
+				return err
+			}
+			diskFiles, err := virshClient.ListVMDiskFiles()
+			if err != nil {
+				return err
+			}
+			storageManager := newStorageManager(sshClient, cfg)
+			pools, err := storageManager.DetectPools()
+			if err != nil {
+				return fmt.Errorf("failed to detect storage pools: %w", err)
+			}
+
+			allocations := vmAllocations(storageManager, vms, diskFiles, pools)
+			var poolSpace []capacity.Pool
+			for _, pool := range pools {
+				poolSpace = append(poolSpace, capacity.Pool{
+					Name:  pool.Name,
+					Path:  pool.Path,
+					Total: pool.TotalSpace << 30,
+					Free:  pool.FreeSpace << 30,
+				})
+			}
+
+			report := capacity.Build(capacity.Host{
+				CPUs:           info.CPUs,
+				MemTotalKB:     info.MemTotalKB,
+				MemAvailableKB: info.MemAvailableKB,
+			}, allocations, poolSpace)
+
+			if jsonOutput {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(report)
+			}
+
+			displayCapacityReport(cfg.Label(), report)
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("json", false, "Output as JSON")
+	return cmd
+}
+
+// vmAllocations measures each VM's disk images and returns what the VMs are allocated.
+// Images qemu-img cannot read are left out with a warning.
+func vmAllocations(storageManager *storage.Manager, vms []virsh.VMInfo, diskFiles map[string][]string, pools []storage.Pool) []capacity.VM {
+	var allocations []capacity.VM
+	for _, vm := range vms {
+		allocation := capacity.VM{
+			Name:     vm.Name,
+			Running:  strings.Contains(vm.State, "running"),
+			CPUs:     vm.CPUs,
+			MemoryMB: vm.Memory,
+		}
+		for _, file := range diskFiles[vm.Name] {
+			image, err := storageManager.GetImageInfo(file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: disk %s of VM '%s' is not counted: %v\n", file, vm.Name, err)
+				continue
+			}
+			disk := capacity.Disk{Path: file, VirtualSize: image.VirtualSize, ActualSize: image.ActualSize}
+			if pool := storage.PoolForPath(pools, file); pool != nil {
+				disk.Pool = pool.Name
+			}
+			allocation.Disks = append(allocation.Disks, disk)
+		}
+		allocations = append(allocations, allocation)
+	}
+	return allocations
+}
+
+// displayCapacityReport prints per-VM allocations, totals against host resources and
+// per-pool disk usage, then the overcommit warnings
+func displayCapacityReport(label string, report *capacity.Report) {
+	fmt.Printf("Host: %s\n\n", label)
+	fmt.Printf("%-20s %-8s %-6s %-10s %-10s %s\n", "VM", "STATE", "VCPUS", "MEMORY", "DISK", "DISK USED")
+	fmt.Printf("%-20s %-8s %-6s %-10s %-10s %s\n", "--------------------", "--------", "------", "----------", "----------", "---------")
+	for _, vm := range report.VMs {
+		state := "stopped"
+		if vm.Running {
+			state = "running"
+		}
+		var virtual, actual int64
+		for _, disk := range vm.Disks {
+			virtual += disk.VirtualSize
+			actual += disk.ActualSize
+		}
+		fmt.Printf("%-20s %-8s %-6d %-10s %-10s %s\n", vm.Name, state, vm.CPUs,
+			fmt.Sprintf("%d MB", vm.MemoryMB), formatBytes(virtual), formatBytes(actual))
+	}
+
+	fmt.Println()
+	fmt.Printf("%-15s: %d allocated, %d running, on %d threads (%.1f:1)\n", "vCPUs",
+		report.VCPUs, report.RunningVCPUs, report.Host.CPUs, report.CPURatio())
+	fmt.Printf("%-15s: %d MB allocated, %d MB running, %d MB total, %d MB available\n", "Memory",
+		report.MemoryMB, report.RunningMemory, report.Host.MemTotalKB/1024, report.Host.MemAvailableKB/1024)
+
+	if len(report.Pools) > 0 {
+		fmt.Println()
+		fmt.Printf("%-15s %-6s %-10s %-10s %-10s %s\n", "POOL", "DISKS", "DISK", "DISK USED", "GROWTH", "FREE")
+		fmt.Printf("%-15s %-6s %-10s %-10s %-10s %s\n", "---------------", "------", "----------", "----------", "----------", "----")
+		for _, pool := range report.Pools {
+			fmt.Printf("%-15s %-6d %-10s %-10s %-10s %s\n", pool.Name, pool.Disks,
+				formatBytes(pool.VirtualSize), formatBytes(pool.ActualSize), formatBytes(pool.Growth), formatBytes(pool.Free))
+		}
+	}
+	if report.UnpooledDisks > 0 {
+		fmt.Printf("\n%d disk(s) outside the storage pools can grow by %s\n", report.UnpooledDisks, formatBytes(report.UnpooledGrowth))
+	}
+
+	if len(report.Warnings) == 0 {
+		fmt.Println("\nNo overcommit.")
+		return
+	}
+	fmt.Println()
+	for _, warning := range report.Warnings {
+		prefix := "Warning"
+		if warning.Level == capacity.LevelError {
+			prefix = "Overcommitted"
+		}
+		fmt.Printf("%s: %s\n", prefix, warning.Message)
+	}
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/inventory"
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+// offlineInventory is a host's cached inventory, used by read-only commands when the
+// NAS is not contacted
+type offlineInventory struct {
+	*inventory.Inventory
+	label  string
+	reason error // Why the NAS was not contacted; nil with --offline
+}
+
+// inventoryDir returns the directory holding inventory caches, in the data directory
+func inventoryDir() (string, error) {
+	dataDir, err := config.GetDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "cache"), nil
+}
+
+// loadInventory reads the host's cached inventory
+func loadInventory(cfg *config.Config) (*inventory.Inventory, error) {
+	dir, err := inventoryDir()
+	if err != nil {
+		return nil, err
+	}
+	return inventory.Load(dir, cfg.Host)
+}
+
+// updateInventory applies update to the host's cached inventory and saves it. The cache
+// is a convenience, so failures only warn.
+func updateInventory(cfg *config.Config, update func(inv *inventory.Inventory, now time.Time)) {
+	inv, err := loadInventory(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return
+	}
+	update(inv, time.Now())
+	if err := inv.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// connectOrOffline connects to the NAS for a read-only command. With --offline, or when
+// the NAS cannot be reached and a cache exists, it returns the cached inventory instead.
+func connectOrOffline(cmd *cobra.Command, cfg *config.Config) (*ssh.Client, *virsh.Client, *offlineInventory, error) {
+	offline, _ := cmd.Flags().GetBool("offline")
+
+	var reason error
+	if !offline {
+		sshClient, virshClient, err := connectToQNAP(*cfg)
+		if err == nil {
+			return sshClient, virshClient, nil, nil
+		}
+		reason = err
+	}
+
+	inv, err := loadInventory(cfg)
+	if err != nil {
+		if reason != nil {
+			return nil, nil, nil, reason
+		}
+		return nil, nil, nil, err
+	}
+	return nil, nil, &offlineInventory{Inventory: inv, label: cfg.Label(), reason: reason}, nil
+}
+
+// show prints the stale-data notice for cached data refreshed at updated, or returns an
+// error when the cache does not hold it (a zero updated)
+func (o *offlineInventory) show(what string, updated time.Time) error {
+	if updated.IsZero() {
+		if o.reason != nil {
+			return fmt.Errorf("%w\nNo cached %s is available for offline use", o.reason, what)
+		}
+		return fmt.Errorf("no cached %s for %s; run the command once while the NAS is reachable", what, o.label)
+	}
+
+	if o.reason != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", o.reason)
+	}
+	messages.Println(messages.InventoryStale, o.label, updated.Local().Format("2006-01-02 15:04"), inventory.Age(updated, time.Now()))
+	fmt.Println()
+	return nil
+}
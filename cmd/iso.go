@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/storage"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func isoCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "iso",
+		Short: "Manage the ISO library",
+		Long: `Upload, download, list, and delete installation ISOs kept in each storage
+pool's .qnap-vm/isos directory. Library ISOs can be referenced by short name with
+'qnap-vm create --iso NAME'.`,
+	}
+
+	// ISO upload command
+	uploadCmd := &cobra.Command{
+		Use:   "upload [ISO_FILE]",
+		Short: "Upload an ISO to the library",
+		Long:  "Upload a local ISO file to the ISO library on the QNAP device over SFTP",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			localPath := args[0]
+			if !strings.HasSuffix(strings.ToLower(localPath), ".iso") {
+				return fmt.Errorf("'%s' does not look like an ISO file", localPath)
+			}
+
+			info, err := os.Stat(localPath)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", localPath, err)
+			}
+
+			// Connect to QNAP device
+			sshClient, _, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			storageManager := newStorageManager(sshClient, cfg)
+			pool, err := storageManager.GetBestPool()
+			if err != nil {
+				return fmt.Errorf("failed to find storage pool: %w", err)
+			}
+
+			statusf("Uploading %s (%s) to %s...\n", localPath, formatBytes(info.Size()), storage.ISODir(pool))
+			image, err := storageManager.UploadISO(pool, localPath, transferOptions(cmd))
+			if err != nil {
+				return fmt.Errorf("failed to upload ISO: %w", err)
+			}
+
+			statusf("ISO '%s' uploaded successfully\n", image.Name)
+			statusf("Use it with: qnap-vm create VM_NAME --iso %s\n", image.Name)
+			return nil
+		},
+	}
+
+	// ISO download command
+	downloadCmd := &cobra.Command{
+		Use:   "download [URL]",
+		Short: "Download an ISO into the library",
+		Long: `Download an ISO from an http(s) URL into the ISO library. The NAS fetches it
+directly with curl or wget, so it doesn't pass through this machine.`,
+		Example: `  qnap-vm iso download https://cdimage.debian.org/debian-cd/current/amd64/iso-cd/debian-12.7.0-amd64-netinst.iso
+  qnap-vm iso download https://example.com/download?id=42 --name tools.iso`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			rawURL := args[0]
+			name, _ := cmd.Flags().GetString("name")
+			if name == "" {
+				if name, err = storage.ISONameFromURL(rawURL); err != nil {
+					return err
+				}
+			} else if !strings.HasSuffix(strings.ToLower(name), ".iso") {
+				name += ".iso"
+			}
+
+			// Connect to QNAP device
+			sshClient, _, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			storageManager := newStorageManager(sshClient, cfg)
+			pool, err := storageManager.GetBestPool()
+			if err != nil {
+				return fmt.Errorf("failed to find storage pool: %w", err)
+			}
+
+			image, err := downloadISO(storageManager, pool, rawURL, name)
+			if err != nil {
+				return err
+			}
+
+			statusf("Use it with: qnap-vm create VM_NAME --iso %s\n", image.Name)
+			return nil
+		},
+	}
+
+	// ISO list command
+	listISOCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List ISOs in the library",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			// Connect to QNAP device
+			sshClient, _, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			images, err := newStorageManager(sshClient, cfg).ListISOs()
+			if err != nil {
+				return fmt.Errorf("failed to list ISOs: %w", err)
+			}
+
+			if len(images) == 0 {
+				fmt.Println("No ISOs found. Use 'qnap-vm iso upload' to add one.")
+				return nil
+			}
+
+			fmt.Printf("%-40s %-20s %-10s %-20s\n", "NAME", "POOL", "SIZE", "MODIFIED")
+			fmt.Printf("%-40s %-20s %-10s %-20s\n", "----------------------------------------", "--------------------", "----------", "--------------------")
+
+			for _, image := range images {
+				fmt.Printf("%-40s %-20s %-10s %-20s\n",
+					image.Name, image.Pool, formatBytes(image.Size), image.Modified.Format("2006-01-02 15:04:05"))
+			}
+
+			return nil
+		},
+	}
+
+	// ISO delete command
+	deleteISOCmd := &cobra.Command{
+		Use:   "delete [ISO_NAME]",
+		Short: "Delete an ISO from the library",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			name := args[0]
+			force, _ := cmd.Flags().GetBool("force")
+
+			// Connect to QNAP device
+			sshClient, _, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			// Confirmation unless force is used
+			if !force {
+				confirmed, err := confirm(fmt.Sprintf("Are you sure you want to delete ISO '%s' from %s? (y/N): ", name, cfg.Label()))
+				if err != nil {
+					return err
+				}
+				if !confirmed {
+					messages.Println(messages.Cancelled)
+					return nil
+				}
+			}
+
+			image, err := newStorageManager(sshClient, cfg).DeleteISO(name)
+			if err != nil {
+				return err
+			}
+
+			statusf("ISO '%s' deleted from %s\n", image.Name, image.Pool)
+			return nil
+		},
+	}
+
+	addTransferFlags(uploadCmd)
+	downloadCmd.Flags().String("name", "", "Library name for the ISO (default: the file name in the URL)")
+	deleteISOCmd.Flags().BoolP("force", "f", false, "Force delete without confirmation")
+
+	cmd.AddCommand(uploadCmd, downloadCmd, listISOCmd, deleteISOCmd)
+	return cmd
+}
+
+// downloadISO downloads an ISO into a pool's library, reporting progress
+func downloadISO(storageManager *storage.Manager, pool *storage.Pool, rawURL, name string) (*storage.ISOImage, error) {
+	statusf("Downloading %s to %s...\n", rawURL, storage.ISODir(pool))
+	image, err := storageManager.DownloadISO(pool, rawURL, name)
+	if err != nil {
+		return nil, err
+	}
+	statusf("ISO '%s' downloaded (%s)\n", image.Name, formatBytes(image.Size))
+	return image, nil
+}
+
+// virtioDriverISO returns the path of the virtio-win driver ISO for a Windows VM: the
+// --virtio-iso flag's, or the library's virtio-win.iso, downloaded into pool if missing
+func virtioDriverISO(cmd *cobra.Command, storageManager *storage.Manager, pool *storage.Pool) (string, error) {
+	if ref, _ := cmd.Flags().GetString("virtio-iso"); ref != "" {
+		return storageManager.ResolveISOPath(ref)
+	}
+	if image, err := storageManager.FindISO(virsh.VirtioWinISO); err == nil {
+		return image.Path, nil
+	}
+
+	image, err := downloadISO(storageManager, pool, virsh.VirtioWinURL, virsh.VirtioWinISO)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the virtio-win driver ISO (upload it with 'qnap-vm iso upload' or pass --virtio-iso): %w", err)
+	}
+	return image.Path, nil
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/keychain"
+	"github.com/spf13/cobra"
+)
+
+// applyPassword updates a host's SSH password from the 'config set' flags. The password
+// goes to the OS keychain and the config file only keeps the keychain account, unless
+// --store-password-in-file is given. An empty password removes it.
+func applyPassword(cmd *cobra.Command, cfg *config.Config) error {
+	if err := applySecret(cmd, "password", cfg.Username+"@"+cfg.Host, &cfg.Password, &cfg.PasswordKeychain); err != nil {
+		return err
+	}
+	// su asks for the password of the account it switches to, kept as "su:admin@host"
+	return applySecret(cmd, "elevate-password", "su:"+suAccount(cfg.ElevateUser)+"@"+cfg.Host, &cfg.ElevatePassword, &cfg.ElevatePasswordKeychain)
+}
+
+// suAccount is the account su switches to
+func suAccount(user string) string {
+	if user == "" {
+		return "admin"
+	}
+	return user
+}
+
+// applySecret updates a password from flag, kept in the config file in plain or in the
+// OS keychain under account, recorded in ref
+func applySecret(cmd *cobra.Command, flag, account string, plain, ref *string) error {
+	if !cmd.Flags().Changed(flag) {
+		return nil
+	}
+	password, _ := cmd.Flags().GetString(flag)
+	if password == "-" {
+		line, err := stdinReader.ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read --%s from stdin: %w", flag, err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	// Drop the previous password wherever it was kept
+	if previous := *ref; previous != "" {
+		if err := keychain.Delete(previous); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+	*plain, *ref = "", ""
+	if password == "" {
+		return nil
+	}
+
+	inFile, _ := cmd.Flags().GetBool("store-password-in-file")
+	return storeSecret(account, password, inFile, plain, ref)
+}
+
+// storeSecret keeps a password in the config file field plain, or in the OS keychain
+// under account, recording the account in ref
+func storeSecret(account, password string, inFile bool, plain, ref *string) error {
+	if inFile {
+		*plain = password
+		return nil
+	}
+
+	if err := keychain.Set(account, password); err != nil {
+		return fmt.Errorf("%w (use --store-password-in-file to keep the password in the config file instead)", err)
+	}
+	*ref = account
+	return nil
+}
+
+// offerToSavePassword asks whether to keep a password typed at the prompt in the OS
+// keychain for the host entry cfg was read from, so the next command does not ask again
+func offerToSavePassword(cfg config.Config, password string) {
+	if password == "" || cfg.HostName == "" {
+		return
+	}
+	save, err := confirm(fmt.Sprintf("Save the password for host '%s' in the keychain? (y/N): ", cfg.HostName))
+	if err != nil || !save {
+		return
+	}
+
+	configFile, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: password not saved: failed to load config: %v\n", err)
+		return
+	}
+	hostConfig, exists := configFile.GetHostConfig(cfg.HostName)
+	if !exists {
+		return
+	}
+	if err := storeSecret(hostConfig.Username+"@"+hostConfig.Host, password, false, &hostConfig.Password, &hostConfig.PasswordKeychain); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: password not saved: %v\n", err)
+		return
+	}
+	hostConfig.Password = ""
+	configFile.SetHostConfig(cfg.HostName, hostConfig)
+	if err := config.SaveConfig(configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: password not saved: failed to save config: %v\n", err)
+		return
+	}
+	statusf("Password saved in the keychain as %s\n", hostConfig.PasswordKeychain)
+}
+
+// keychainLayer reads the passwords a host entry keeps in the OS keychain. The SSH
+// password is skipped when QNAP_VM_PASSWORD overrides it anyway.
+func keychainLayer(hostConfig config.Config) ([]config.Layer, error) {
+	var secrets config.Config
+	if hostConfig.PasswordKeychain != "" && os.Getenv("QNAP_VM_PASSWORD") == "" {
+		password, err := keychain.Get(hostConfig.PasswordKeychain)
+		if err != nil {
+			return nil, err
+		}
+		secrets.Password = password
+	}
+	if hostConfig.ElevatePasswordKeychain != "" {
+		password, err := keychain.Get(hostConfig.ElevatePasswordKeychain)
+		if err != nil {
+			return nil, err
+		}
+		secrets.ElevatePassword = password
+	}
+	if secrets.Password == "" && secrets.ElevatePassword == "" {
+		return nil, nil
+	}
+	return []config.Layer{{Source: config.SourceKeychain, Config: secrets}}, nil
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/spf13/cobra"
+)
+
+// logLevels are the accepted --log-level values
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+}
+
+// logger receives connections, remote commands and their output. It discards
+// everything unless --verbose or --log-file is given.
+var logger = slog.New(slog.DiscardHandler)
+
+// logFile is the open --log-file, closed when the command finishes
+var logFile *os.File
+
+// logSecrets are masked wherever they appear in log messages
+var logSecrets []string
+
+// setupLogging creates the logger from --verbose, --log-file and --log-level. -v logs
+// to stderr; --log-file appends timestamped lines to a file, so a session against a
+// misbehaving QTS version can be replayed later.
+func setupLogging(cmd *cobra.Command) error {
+	verbose, _ := cmd.Flags().GetBool("verbose")
+	path, _ := cmd.Flags().GetString("log-file")
+	levelName, _ := cmd.Flags().GetString("log-level")
+
+	level, ok := logLevels[strings.ToLower(levelName)]
+	if !ok {
+		return messages.Errorf(messages.Usage, fmt.Errorf("invalid --log-level '%s'; use debug, info or warn", levelName))
+	}
+
+	var writers []io.Writer
+	if verbose {
+		writers = append(writers, os.Stderr)
+	}
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		logFile = file
+		writers = append(writers, file)
+	}
+	if len(writers) == 0 {
+		return nil
+	}
+
+	handler := slog.NewTextHandler(io.MultiWriter(writers...), &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactLogAttr,
+	})
+	logger = slog.New(handler)
+	logger.Info("starting", "args", strings.Join(os.Args[1:], " "), "version", version)
+	return nil
+}
+
+// redactLogAttr masks logSecrets in string attributes and errors
+func redactLogAttr(_ []string, attr slog.Attr) slog.Attr {
+	if len(logSecrets) == 0 {
+		return attr
+	}
+	var text string
+	switch value := attr.Value.Any().(type) {
+	case string:
+		text = value
+	case error:
+		text = value.Error()
+	default:
+		return attr
+	}
+	for _, secret := range logSecrets {
+		text = strings.ReplaceAll(text, secret, "********")
+	}
+	return slog.String(attr.Key, text)
+}
+
+// redactLog masks secret in every later log message
+func redactLog(secret string) {
+	if secret != "" {
+		logSecrets = append(logSecrets, secret)
+	}
+}
+
+// finishLogging records how the command ended and closes the log file
+func finishLogging(err error) {
+	if err != nil {
+		logger.Warn("finished", "error", err, "exit_code", ExitCode(err))
+	} else {
+		logger.Info("finished")
+	}
+	if logFile != nil {
+		if closeErr := logFile.Close(); closeErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close log file: %v\n", closeErr)
+		}
+	}
+}
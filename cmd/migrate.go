@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/migrate"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func migrateFromCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate-from [SOURCE]",
+		Short: "Migrate a VM from Proxmox, ESXi or an OVF export",
+		Long: `Import a virtual machine from another hypervisor onto the QNAP device.
+
+Supported sources:
+  proxmox://[user@]host[:port]/VMID     Proxmox VE host (reads 'qm config' over SSH)
+  esxi://[user@]host[:port]/VM_NAME     VMware ESXi host with SSH enabled
+  ovf:///path/to/vm.ovf                 Local OVF export (extract .ova files first)
+
+Disks are streamed through this workstation, converted to qcow2 on the QNAP
+and attached with a bus the guest can boot from; if a transfer fails, the
+disks converted so far are removed. Network cards keep their source MAC
+addresses. Driver guidance is printed when controllers are remapped.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			source, err := migrate.ParseSource(args[0])
+			if err != nil {
+				return err
+			}
+
+			targetName, _ := cmd.Flags().GetString("name")
+			planOnly, _ := cmd.Flags().GetBool("plan")
+			sourceKeyFile, _ := cmd.Flags().GetString("source-keyfile")
+			if sourceKeyFile == "" {
+				sourceKeyFile = cfg.KeyFile
+			}
+
+			strictHostKey, _ := cmd.Flags().GetBool("strict-host-key")
+
+			// Connect to the source host for SSH-based sources
+			var sourceClient *ssh.Client
+			if source.Kind != migrate.KindOVF {
+				sourceClient, err = ssh.NewClient(ssh.Config{
+					Host:     source.Host,
+					Port:     source.Port,
+					Username: source.Username,
+					KeyFile:  sourceKeyFile,
+					Timeout:  30 * time.Second,
+
+					PasswordPrompt: passwordPrompt(source.Username, source.Host, nil),
+					HostKeyPrompt:  trustHostKey,
+					StrictHostKey:  strictHostKey,
+				})
+				if err != nil {
+					return fmt.Errorf("failed to create SSH client for source: %w", err)
+				}
+				sourceClient.SetLogger(logger.With("host", source.Host))
+				sourceClient.SetContext(cmd.Context())
+				if err := sourceClient.Connect(); err != nil {
+					return fmt.Errorf("failed to connect to source host: %w", err)
+				}
+				defer func() {
+					if err := sourceClient.Close(); err != nil {
+						fmt.Fprintf(os.Stderr, "Warning: failed to close source SSH connection: %v\n", err)
+					}
+				}()
+			}
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			migrator := migrate.NewMigrator(source, sourceClient, sshClient)
+			plan, err := migrator.Inspect()
+			if err != nil {
+				return fmt.Errorf("failed to inspect source VM: %w", err)
+			}
+
+			if targetName == "" {
+				targetName = plan.Name
+			}
+			if targetName == "" {
+				return fmt.Errorf("source VM has no name; use --name to set one")
+			}
+
+			displayMigrationPlan(plan, targetName)
+			if planOnly {
+				return nil
+			}
+
+			if len(plan.Disks) == 0 {
+				return fmt.Errorf("source VM has no disks to migrate")
+			}
+
+			// Check if VM already exists
+			if _, err := virshClient.GetVM(targetName); err == nil {
+				return messages.Errorf(messages.VMExists, targetName)
+			}
+
+			storageManager := newStorageManager(sshClient, cfg)
+			pool, err := storageManager.GetBestPool()
+			if err != nil {
+				return fmt.Errorf("failed to find storage pool: %w", err)
+			}
+
+			statusf("Using storage pool: %s (%s)\n", pool.Name, pool.Path)
+
+			// Transfer and convert each disk
+			diskPaths := make([]string, len(plan.Disks))
+			for i, disk := range plan.Disks {
+				diskPaths[i] = storageManager.CreateVMDiskPathIndexed(pool, targetName, i)
+				statusf("Transferring disk %d/%d: %s -> %s\n", i+1, len(plan.Disks), disk.Source, diskPaths[i])
+				if err := migrator.TransferDisk(disk, diskPaths[i]); err != nil {
+					return removeDiskImages(storageManager, diskPaths[:i+1], err)
+				}
+			}
+
+			// The NICs keep their source MACs, so DHCP reservations and guest network
+			// configuration tied to them keep working
+			macs := migrationMACs(plan.NICs, targetName)
+			bus := plan.TargetBus()
+			vmConfig := virsh.VMConfig{
+				Memory:   plan.Memory,
+				CPUs:     plan.CPUs,
+				DiskPath: diskPaths[0],
+				DiskBus:  bus,
+			}
+			if len(macs) > 0 {
+				vmConfig.MAC = macs[0]
+			}
+
+			messages.Println(messages.VMCreating, targetName, plan.Memory, plan.CPUs)
+			if err := warnQVSRegistration(virshClient.CreateVM(targetName, vmConfig)); err != nil {
+				return removeDiskImages(storageManager, diskPaths, fmt.Errorf("failed to create VM: %w", err))
+			}
+
+			for i := 1; i < len(diskPaths); i++ {
+				if err := virshClient.AttachDisk(targetName, diskPaths[i], virsh.DiskTarget(bus, i), bus, virsh.DiskOptions{}); err != nil {
+					return err
+				}
+			}
+			for i := 1; i < len(macs); i++ {
+				if err := virshClient.AddInterface(targetName, virsh.NICConfig{MAC: macs[i]}, false); err != nil {
+					return err
+				}
+			}
+
+			statusf("VM '%s' migrated successfully!\n", targetName)
+
+			if notes := plan.Guidance(); len(notes) > 0 {
+				statusf("\nPost-migration notes:\n")
+				for _, note := range notes {
+					statusf("  - %s\n", note)
+				}
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("name", "", "Name for the migrated VM (default: source VM name)")
+	cmd.Flags().Bool("plan", false, "Show the migration plan without transferring anything")
+	cmd.Flags().String("source-keyfile", "", "SSH private key for the source host (default: --keyfile)")
+
+	return cmd
+}
+
+// migrationMACs returns the MAC address for each source NIC: its own, or the next
+// stable MAC for vmName when the source has none or an unusable one
+func migrationMACs(nics []migrate.NIC, vmName string) []string {
+	var macs []string
+	for i, nic := range nics {
+		mac, err := virsh.ParseMAC(nic.MAC)
+		if err != nil {
+			if nic.MAC != "" {
+				fmt.Fprintf(os.Stderr, "Warning: NIC %d: %v; using a new address\n", i, err)
+			}
+			mac = nextStableMAC(vmName, macs)
+		}
+		macs = append(macs, mac)
+	}
+	return macs
+}
+
+// displayMigrationPlan prints what will be created on the QNAP device
+func displayMigrationPlan(plan *migrate.Plan, targetName string) {
+	fmt.Printf("Migration plan for '%s':\n", targetName)
+	fmt.Printf("%-15s: %d MB\n", "Memory", plan.Memory)
+	fmt.Printf("%-15s: %d\n", "CPUs", plan.CPUs)
+	fmt.Printf("%-15s: %s\n", "OS Type", plan.OSType)
+
+	bus := plan.TargetBus()
+	for i, disk := range plan.Disks {
+		fmt.Printf("%-15s: %s (%s, %s -> %s as %s)\n", fmt.Sprintf("Disk %d", i), disk.Source, disk.Format, disk.Bus, bus, virsh.DiskTarget(bus, i))
+	}
+	for i, nic := range plan.NICs {
+		fmt.Printf("%-15s: %s %s\n", fmt.Sprintf("NIC %d", i), nic.Model, nic.MAC)
+	}
+	fmt.Println()
+}
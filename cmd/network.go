@@ -0,0 +1,471 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/nas"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func networkCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "network",
+		Short: "Manage the networks VMs can connect to",
+		Long: `Inspect the NAS's bridges, such as QNAP virtual switches, and manage libvirt
+NAT networks. Connect a VM with 'create --network bridge=NAME' or
+'create --network network=NAME'.`,
+	}
+
+	cmd.AddCommand(networkListCmd(), networkCreateCmd(), networkStartCmd(), networkDeleteCmd(), networkLeasesCmd())
+	return cmd
+}
+
+func networkListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the NAS's virtual switches, bridges and libvirt networks",
+		Long: `List the NAS's bridges with their physical uplinks and subnets, and its libvirt
+networks. QNAP virtual switches (qvs0, qvs1, ...) are created in Network &
+Virtual Switch; a VM on one gets an address on the uplink's LAN. Bridges without
+an uplink only reach the NAS and the VMs on them.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			bridges, err := nas.NewManager(sshClient).Bridges()
+			if err != nil {
+				return err
+			}
+
+			networks, err := virshClient.ListNetworks()
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				if bridges == nil {
+					bridges = []nas.Bridge{}
+				}
+				if networks == nil {
+					networks = []virsh.Network{}
+				}
+				data, err := json.MarshalIndent(map[string]interface{}{"bridges": bridges, "networks": networks}, "", "  ")
+				if err != nil {
+					return fmt.Errorf("failed to encode networks: %w", err)
+				}
+				fmt.Println(string(data))
+				return nil
+			}
+
+			printBridges(bridges)
+			fmt.Println()
+			printNetworks(networks)
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("json", false, "Output the bridges and networks as JSON")
+	return cmd
+}
+
+// printBridges prints the NAS's bridges as a table
+func printBridges(bridges []nas.Bridge) {
+	if len(bridges) == 0 {
+		fmt.Println("No bridges found; create a virtual switch in Network & Virtual Switch")
+		return
+	}
+
+	fmt.Printf("%-12s %-15s %-6s %-16s %-18s %s\n", "BRIDGE", "TYPE", "STATE", "UPLINKS", "SUBNETS", "PORTS")
+	fmt.Printf("%-12s %-15s %-6s %-16s %-18s %s\n", "------", "----", "-----", "-------", "-------", "-----")
+	for _, bridge := range bridges {
+		kind := "bridge"
+		if bridge.VirtualSwitch() {
+			kind = "virtual switch"
+		}
+		fmt.Printf("%-12s %-15s %-6s %-16s %-18s %d\n", bridge.Name, kind, bridge.State,
+			listOrDash(bridge.Uplinks), listOrDash(bridge.Subnets), len(bridge.Ports))
+	}
+	fmt.Println("\nConnect a VM with: qnap-vm create NAME --network bridge=<BRIDGE>")
+}
+
+// printNetworks prints the NAS's libvirt networks as a table
+func printNetworks(networks []virsh.Network) {
+	if len(networks) == 0 {
+		fmt.Println("No libvirt networks; create a NAT network with 'qnap-vm network create'")
+		return
+	}
+
+	fmt.Printf("%-12s %-9s %-9s %-8s %-18s %s\n", "NETWORK", "STATE", "AUTOSTART", "FORWARD", "SUBNET", "DHCP")
+	fmt.Printf("%-12s %-9s %-9s %-8s %-18s %s\n", "-------", "-----", "---------", "-------", "------", "----")
+	for _, network := range networks {
+		state := "inactive"
+		if network.Active {
+			state = "active"
+		}
+		autostart := "no"
+		if network.Autostart {
+			autostart = "yes"
+		}
+		forward := network.Forward
+		if forward == "" {
+			forward = "isolated"
+		}
+		dhcp := "-"
+		if network.DHCPStart != "" {
+			dhcp = network.DHCPStart + "-" + network.DHCPEnd
+		}
+		subnet := network.Subnet
+		if subnet == "" {
+			subnet = "-"
+		}
+		fmt.Printf("%-12s %-9s %-9s %-8s %-18s %s\n", network.Name, state, autostart, forward, subnet, dhcp)
+	}
+	fmt.Println("\nConnect a VM with: qnap-vm create NAME --network network=<NETWORK>")
+}
+
+func networkCreateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create NETWORK",
+		Short: "Create a NAT network",
+		Long: `Create a libvirt NAT network and start it. VMs on it get addresses from its
+DHCP range and reach the LAN and internet through the NAS, but are only
+reachable from the NAS and each other: an isolated per-project network between
+user-mode networking and bridging onto the LAN.
+
+--subnet is the NAS's address on the network in CIDR form. The DHCP range
+defaults to the addresses above it.`,
+		Example: `  qnap-vm network create lab --subnet 192.168.100.1/24
+  qnap-vm network create ci --subnet 10.10.0.1/24 --dhcp-range 10.10.0.100,10.10.0.199`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			name := args[0]
+			subnet, _ := cmd.Flags().GetString("subnet")
+			dhcpRange, _ := cmd.Flags().GetString("dhcp-range")
+			noAutostart, _ := cmd.Flags().GetBool("no-autostart")
+			def, err := virsh.NewNATNetwork(name, subnet, dhcpRange)
+			if err != nil {
+				return err
+			}
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			networks, err := virshClient.ListNetworks()
+			if err != nil {
+				return err
+			}
+			for _, network := range networks {
+				if network.Name == name {
+					return messages.Errorf(messages.NetworkExists, name)
+				}
+				if network.Subnet != "" && overlaps(network.Subnet, subnet) {
+					return fmt.Errorf("subnet %s overlaps network '%s' (%s)", subnet, network.Name, network.Subnet)
+				}
+			}
+
+			if err := virshClient.DefineNetwork(def); err != nil {
+				return err
+			}
+			if err := virshClient.StartNetwork(name); err != nil {
+				return err
+			}
+			if !noAutostart {
+				if err := virshClient.SetNetworkAutostart(name, true); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				}
+			}
+
+			dhcp := def.IP[0].DHCP.Range[0]
+			statusf("Created NAT network '%s' (%s, DHCP %s-%s)\n", name, subnet, dhcp.Start, dhcp.End)
+			statusf("Connect a VM with: qnap-vm create NAME --network network=%s\n", name)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("subnet", "", "The NAS's address on the network in CIDR form, e.g. 192.168.100.1/24")
+	cmd.Flags().String("dhcp-range", "", "DHCP range as START,END (default: the addresses above --subnet's)")
+	cmd.Flags().Bool("no-autostart", false, "Do not start the network when the NAS boots")
+	_ = cmd.MarkFlagRequired("subnet")
+	return cmd
+}
+
+// overlaps reports whether two CIDR subnets share addresses
+func overlaps(a, b string) bool {
+	_, netA, errA := net.ParseCIDR(a)
+	_, netB, errB := net.ParseCIDR(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return netA.Contains(netB.IP) || netB.Contains(netA.IP)
+}
+
+func networkStartCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "start NETWORK",
+		Short: "Start a libvirt network",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			network, err := virshClient.GetNetwork(args[0])
+			if err != nil {
+				return err
+			}
+			if network.Active {
+				statusf("Network '%s' is already active\n", network.Name)
+				return nil
+			}
+			if err := virshClient.StartNetwork(network.Name); err != nil {
+				return err
+			}
+			statusf("Started network '%s'\n", network.Name)
+			return nil
+		},
+	}
+}
+
+func networkDeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete NETWORK",
+		Short: "Stop and delete a libvirt network",
+		Long: `Stop and delete a libvirt network. VMs connected to it lose their network, so
+deleting a network VMs use requires --force.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			name := args[0]
+			force, _ := cmd.Flags().GetBool("force")
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			if _, err := virshClient.GetNetwork(name); err != nil {
+				return err
+			}
+			users, err := virshClient.NetworkUsers(name)
+			if err != nil {
+				return err
+			}
+			if len(users) > 0 {
+				if !force {
+					return fmt.Errorf("network '%s' is used by VMs %s; use --force to delete it anyway", name, strings.Join(users, ", "))
+				}
+				fmt.Fprintf(os.Stderr, "Warning: VMs %s lose their network\n", strings.Join(users, ", "))
+			}
+
+			if err := virshClient.DeleteNetwork(name); err != nil {
+				return err
+			}
+			statusf("Deleted network '%s'\n", name)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolP("force", "f", false, "Delete the network even if VMs use it")
+	return cmd
+}
+
+// listOrDash joins values with commas, or returns "-" when there are none
+func listOrDash(values []string) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	return strings.Join(values, ",")
+}
+
+func networkLeasesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "leases [NETWORK]",
+		Short: "List the DHCP leases of libvirt networks",
+		Long: `List the addresses libvirt networks have handed out, with the VM each MAC
+address belongs to: the quickest way to find the address of a new VM. Without
+NETWORK, the leases of all active networks are listed.`,
+		Example: `  qnap-vm network leases
+  qnap-vm network leases lab --json`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			var networks []virsh.Network
+			if len(args) == 1 {
+				network, err := virshClient.GetNetwork(args[0])
+				if err != nil {
+					return err
+				}
+				if !network.Active {
+					return fmt.Errorf("network '%s' is inactive", network.Name)
+				}
+				networks = append(networks, *network)
+			} else {
+				all, err := virshClient.ListNetworks()
+				if err != nil {
+					return err
+				}
+				for _, network := range all {
+					if network.Active {
+						networks = append(networks, network)
+					}
+				}
+			}
+
+			leases := []virsh.Lease{}
+			for i := range networks {
+				networkLeases, err := virshClient.Leases(&networks[i])
+				if err != nil {
+					return err
+				}
+				leases = append(leases, networkLeases...)
+			}
+
+			if vmsByMAC, err := virshClient.VMsByMAC(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v; not matching leases to VMs\n", err)
+			} else {
+				for i := range leases {
+					leases[i].VM = vmsByMAC[leases[i].MAC]
+				}
+			}
+
+			if jsonOutput {
+				data, err := json.MarshalIndent(leases, "", "  ")
+				if err != nil {
+					return fmt.Errorf("failed to encode leases: %w", err)
+				}
+				fmt.Println(string(data))
+				return nil
+			}
+
+			if len(leases) == 0 {
+				fmt.Println("No DHCP leases")
+				return nil
+			}
+
+			fmt.Printf("%-12s %-20s %-18s %-19s %-16s %s\n", "NETWORK", "VM", "IP", "MAC", "HOSTNAME", "EXPIRES")
+			fmt.Printf("%-12s %-20s %-18s %-19s %-16s %s\n", "-------", "--", "--", "---", "--------", "-------")
+			for _, lease := range leases {
+				vm, hostname := lease.VM, lease.Hostname
+				if vm == "" {
+					vm = "-"
+				}
+				if hostname == "" {
+					hostname = "-"
+				}
+				fmt.Printf("%-12s %-20s %-18s %-19s %-16s %s\n", lease.Network, vm, lease.IP, lease.MAC, hostname, lease.Expiry.Format("2006-01-02 15:04"))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("json", false, "Output the leases as JSON")
+	return cmd
+}
+
+// checkNetwork checks the bridge or libvirt network a VM is to be connected to exists
+// on the NAS
+func checkNetwork(sshClient *ssh.Client, virshClient *virsh.Client, network virsh.VMNetwork) error {
+	if network.Mode == virsh.NetworkNAT {
+		libvirtNetwork, err := virshClient.GetNetwork(network.Source)
+		if err != nil {
+			return err
+		}
+		if !libvirtNetwork.Active {
+			fmt.Fprintf(os.Stderr, "Warning: network %s is inactive; start it with 'qnap-vm network start %s' before the VM\n", network.Source, network.Source)
+		}
+		return nil
+	}
+	if network.Mode != virsh.NetworkBridge {
+		return nil
+	}
+
+	bridges, err := nas.NewManager(sshClient).Bridges()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v; not checking bridge %s\n", err, network.Source)
+		return nil
+	}
+	for _, bridge := range bridges {
+		if bridge.Name == network.Source {
+			if len(bridge.Uplinks) == 0 {
+				fmt.Fprintf(os.Stderr, "Warning: bridge %s has no physical uplink; the VM can only reach the NAS and other VMs on it\n", bridge.Name)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no bridge %s on the NAS (see 'qnap-vm network list')", network.Source)
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func nicCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "nic",
+		Short: "Manage a VM's network cards",
+		Long:  "List a VM's network cards or add one, e.g. a second card on a storage network",
+	}
+
+	cmd.AddCommand(nicListCmd(), nicAddCmd())
+	return cmd
+}
+
+func nicListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "list [VM_NAME]",
+		Short:             "List a VM's network cards",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			vmName := args[0]
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			domain, err := virshClient.GetDomain(vmName)
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				interfaces := domain.Devices.Interface
+				if interfaces == nil {
+					interfaces = []virsh.DomainInterface{}
+				}
+				data, err := json.MarshalIndent(interfaces, "", "  ")
+				if err != nil {
+					return fmt.Errorf("failed to encode network cards: %w", err)
+				}
+				fmt.Println(string(data))
+				return nil
+			}
+
+			if len(domain.Devices.Interface) == 0 {
+				fmt.Printf("VM '%s' has no network cards\n", vmName)
+				return nil
+			}
+
+			fmt.Printf("%-4s %-8s %-14s %-8s %-19s %s\n", "NIC", "TYPE", "SOURCE", "MODEL", "MAC", "MTU")
+			fmt.Printf("%-4s %-8s %-14s %-8s %-19s %s\n", "---", "----", "------", "-----", "---", "---")
+			for i, iface := range domain.Devices.Interface {
+				mac, mtu := "-", "default"
+				if iface.MAC != nil {
+					mac = iface.MAC.Address
+				}
+				if iface.MTU != nil {
+					mtu = fmt.Sprintf("%d", iface.MTU.Size)
+				}
+				fmt.Printf("%-4d %-8s %-14s %-8s %-19s %s\n", i, iface.Type, iface.SourceName(), iface.Model.Type, mac, mtu)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("json", false, "Output the network cards as JSON")
+	return cmd
+}
+
+func nicAddCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "add [VM_NAME]",
+		Short: "Add a network card to a VM",
+		Long: `Add a network card to a VM's configuration, from its next start. With --live
+it is also hot-plugged into the running VM.
+
+Like the first card, it gets a MAC address derived from the VM name unless
+--mac is given.`,
+		Example: `  qnap-vm nic add nas-client --network bridge=qvs1 --mtu 9000
+  qnap-vm nic add legacy --network network=lab --nic-model e1000 --live`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			vmName := args[0]
+			networkSpec, _ := cmd.Flags().GetString("network")
+			model, _ := cmd.Flags().GetString("nic-model")
+			mtu, _ := cmd.Flags().GetInt("mtu")
+			mac, _ := cmd.Flags().GetString("mac")
+			live, _ := cmd.Flags().GetBool("live")
+
+			network, err := virsh.ParseNetwork(networkSpec)
+			if err != nil {
+				return err
+			}
+			if err := virsh.ValidateNetConfig(model, 0, 1); err != nil {
+				return err
+			}
+			if err := virsh.ValidateMTU(mtu, model); err != nil {
+				return err
+			}
+			if mac != "" {
+				if mac, err = virsh.ParseMAC(mac); err != nil {
+					return err
+				}
+			}
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			vm, err := virshClient.GetVM(vmName)
+			if err != nil {
+				return err
+			}
+			if live && vm.State != "running" {
+				return fmt.Errorf("VM '%s' is not running (state: %s); omit --live to add the card from its next start", vmName, vm.State)
+			}
+			domain, err := virshClient.GetDomain(vmName)
+			if err != nil {
+				return err
+			}
+
+			macs := domain.MACs()
+			if mac == "" {
+				mac = nextStableMAC(vmName, macs)
+			} else if containsFold(macs, mac) {
+				return fmt.Errorf("VM '%s' already has a network card with MAC %s", vmName, mac)
+			}
+
+			if err := checkNetwork(sshClient, virshClient, network); err != nil {
+				return err
+			}
+
+			nic := virsh.NICConfig{Network: network, MAC: mac, Model: model, MTU: mtu}
+			if err := virshClient.AddInterface(vmName, nic, live); err != nil {
+				return err
+			}
+
+			statusf("Added network card %s to VM '%s'\n", mac, vmName)
+			if !live && vm.State == "running" {
+				statusln("The card is added from the VM's next start; use --live to hot-plug it now.")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("network", virsh.NetworkUser, "Network to connect to: user, bridge=NAME or network=NAME (see 'qnap-vm network list')")
+	cmd.Flags().String("nic-model", "virtio", "Network card model: virtio, or e1000/rtl8139 for guests without virtio drivers")
+	cmd.Flags().Int("mtu", 0, "MTU, e.g. 9000 for jumbo frames (virtio only; default: the network's)")
+	cmd.Flags().String("mac", "", "MAC address (default: derived from the VM name)")
+	cmd.Flags().Bool("live", false, "Also hot-plug the card into the running VM")
+	return cmd
+}
+
+// nextStableMAC returns the stable MAC of the first interface index whose address the
+// VM does not use yet
+func nextStableMAC(vmName string, macs []string) string {
+	for i := 0; ; i++ {
+		if mac := virsh.StableMAC(vmName, i); !containsFold(macs, mac) {
+			return mac
+		}
+	}
+}
+
+// containsFold reports whether values contains s, ignoring case
+func containsFold(values []string, s string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, s) {
+			return true
+		}
+	}
+	return false
+}
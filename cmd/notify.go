@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/notify"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+// newNotifier returns the notifier for the host's alert settings. sshClient, if not nil,
+// lets it write alerts to the QTS event log.
+func newNotifier(cfg *config.Config, sshClient *ssh.Client) *notify.Notifier {
+	notifier := notify.New(notify.Config{
+		QTS:          cfg.Notify.QTS,
+		Webhook:      cfg.Notify.Webhook,
+		Email:        cfg.Notify.Email,
+		SMTP:         cfg.Notify.SMTP,
+		SMTPUsername: cfg.Notify.SMTPUsername,
+		SMTPPassword: cfg.Notify.SMTPPassword,
+		From:         cfg.Notify.From,
+		Events:       cfg.Notify.Events,
+	}, cfg.Label())
+	if sshClient != nil {
+		notifier.UseNAS(sshClient)
+	}
+	return notifier
+}
+
+// sendNotification sends an alert, warning about destinations that could not be reached
+func sendNotification(notifier *notify.Notifier, event notify.Event) {
+	if err := notifier.Send(event); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: notification not delivered: %v\n", err)
+	}
+}
+
+// applyNotifySettings updates a host's alert settings from the 'config set' flags
+func applyNotifySettings(cmd *cobra.Command, settings *config.NotifyConfig) {
+	for flag, value := range map[string]*string{
+		"notify-webhook":       &settings.Webhook,
+		"notify-smtp":          &settings.SMTP,
+		"notify-smtp-username": &settings.SMTPUsername,
+		"notify-smtp-password": &settings.SMTPPassword,
+		"notify-from":          &settings.From,
+	} {
+		if cmd.Flags().Changed(flag) {
+			*value, _ = cmd.Flags().GetString(flag)
+		}
+	}
+	for flag, value := range map[string]*[]string{
+		"notify-email":  &settings.Email,
+		"notify-events": &settings.Events,
+	} {
+		if cmd.Flags().Changed(flag) {
+			list, _ := cmd.Flags().GetString(flag)
+			*value = splitList(list)
+		}
+	}
+	if cmd.Flags().Changed("notify-qts") {
+		settings.QTS, _ = cmd.Flags().GetBool("notify-qts")
+	}
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func notifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "notify",
+		Short: "Send alerts about VMs to QTS, a webhook or email",
+		Long: `Send alerts about a host's VMs where NAS owners already look.
+
+Configure the destinations per host with 'qnap-vm config set':
+  --notify-qts            write to the QTS system event log, which Notification
+                          Center forwards by its alert rules
+  --notify-webhook URL    POST each alert as JSON
+  --notify-email ADDRS    mail alerts through --notify-smtp HOST:PORT
+
+Alerts are sent for crashed VMs (by 'notify watch'), failed scheduled backups,
+and backup sets and replication snapshots deleted by retention. Limit them with
+--notify-events.`,
+	}
+
+	testCmd := &cobra.Command{
+		Use:   "test",
+		Short: "Send a test alert to every configured destination",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+			if !cfg.Notify.Enabled() {
+				return fmt.Errorf("no notification destinations are configured for %s; set them with 'qnap-vm config set --notify-qts', '--notify-webhook URL' or '--notify-email ADDRESS'", cfg.Label())
+			}
+
+			var sshClient *ssh.Client
+			if cfg.Notify.QTS {
+				client, _, err := connectToQNAP(*cfg)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := client.Close(); err != nil {
+						fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+					}
+				}()
+				sshClient = client
+			}
+
+			event := notify.Event{Type: notify.EventTest, Severity: notify.SeverityInfo, Message: "Test alert from qnap-vm"}
+			if err := newNotifier(cfg, sshClient).Send(event); err != nil {
+				return fmt.Errorf("test alert not delivered: %w", err)
+			}
+			statusf("Test alert sent for %s\n", cfg.Label())
+			return nil
+		},
+	}
+
+	watchCmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Watch the host's VMs and send an alert when one crashes",
+		Long: `Check the state of every VM on the host at each --interval and send a
+vm-crashed alert when a VM crashes: QEMU dies, or the guest panics and libvirt
+stops or pauses it. VMs that had already crashed when watching started are only
+listed. Runs until interrupted; lost connections are retried.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			interval, _ := cmd.Flags().GetDuration("interval")
+			if interval < time.Second {
+				return fmt.Errorf("invalid interval %s", interval)
+			}
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+			if !cfg.Notify.Enabled() {
+				fmt.Fprintf(os.Stderr, "Warning: no notification destinations are configured for %s; crashes are only printed\n", cfg.Label())
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			statusf("Watching the VMs of %s every %s (press Ctrl+C to stop)\n", cfg.Label(), interval)
+
+			watcher := &crashWatcher{cfg: cfg}
+			defer watcher.close()
+			for {
+				if err := watcher.check(); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					watcher.close()
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(interval):
+				}
+			}
+		},
+	}
+	watchCmd.Flags().Duration("interval", time.Minute, "How often to check the VMs")
+
+	cmd.AddCommand(testCmd, watchCmd)
+	return cmd
+}
+
+// crashWatcher remembers which VMs were crashed at the last check, so each crash is
+// reported once
+type crashWatcher struct {
+	cfg       *config.Config
+	sshClient *ssh.Client
+	virsh     *virsh.Client
+	notifier  *notify.Notifier
+	crashed   map[string]bool // nil until the first check
+}
+
+// check reads the VM states, connecting first if needed, and alerts about new crashes
+func (w *crashWatcher) check() error {
+	if w.sshClient == nil {
+		sshClient, virshClient, err := connectToQNAP(*w.cfg)
+		if err != nil {
+			return err
+		}
+		w.sshClient, w.virsh = sshClient, virshClient
+		w.notifier = newNotifier(w.cfg, sshClient)
+	}
+
+	states, err := w.virsh.ListStates()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	crashed := make(map[string]bool)
+	for _, name := range names {
+		state := states[name]
+		if !virsh.IsCrashState(state) {
+			continue
+		}
+		crashed[name] = true
+		switch {
+		case w.crashed == nil:
+			fmt.Printf("VM '%s' is already crashed (%s)\n", name, state)
+		case !w.crashed[name]:
+			fmt.Printf("[%s] VM '%s' crashed (%s)\n", time.Now().Format("2006-01-02 15:04:05"), name, state)
+			sendNotification(w.notifier, notify.Event{
+				Type:     notify.EventVMCrashed,
+				Severity: notify.SeverityError,
+				VM:       name,
+				Message:  fmt.Sprintf("VM '%s' on %s crashed (%s)", name, w.cfg.Label(), state),
+			})
+		}
+	}
+	w.crashed = crashed
+	return nil
+}
+
+// close drops the connection; the next check reconnects
+func (w *crashWatcher) close() {
+	if w.sshClient == nil {
+		return
+	}
+	if err := w.sshClient.Close(); err != nil {
+		// The connection is being replaced or the watch is ending
+	}
+	w.sshClient, w.virsh = nil, nil
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/nas"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+func pciCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pci",
+		Short: "Inspect the NAS's PCI devices for passthrough",
+		Long: `Inspect the NAS's PCI devices, such as GPUs for media transcoding or AI guests,
+before passing them to a VM with 'create --hostdev pci=ADDRESS'.`,
+	}
+
+	cmd.AddCommand(pciListCmd())
+	return cmd
+}
+
+func pciListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List PCI devices by IOMMU group",
+		Long: `List the NAS's PCI devices by IOMMU group. A device can only be passed through
+together with the other devices in its group (bridges excepted), unless those
+are bound to vfio-pci.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+
+			// Connect to QNAP device
+			sshClient, _, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			inventory, err := nas.NewManager(sshClient).PCIDevices()
+			if err != nil {
+				return err
+			}
+
+			devices := inventory.Devices
+			sort.SliceStable(devices, func(i, j int) bool {
+				return devices[i].IOMMUGroup < devices[j].IOMMUGroup
+			})
+
+			if jsonOutput {
+				if devices == nil {
+					devices = []nas.PCIDevice{}
+				}
+				data, err := json.MarshalIndent(devices, "", "  ")
+				if err != nil {
+					return fmt.Errorf("failed to encode PCI devices: %w", err)
+				}
+				fmt.Println(string(data))
+				return nil
+			}
+
+			iommu := "enabled"
+			if !inventory.IOMMU() {
+				iommu = "disabled (enable VT-d/AMD-Vi in the BIOS for passthrough)"
+			}
+			vfio := "available"
+			if !inventory.VFIO {
+				vfio = "not available"
+			}
+			fmt.Printf("IOMMU: %s\n%s: %s\n\n", iommu, nas.VFIODriver, vfio)
+
+			fmt.Printf("%-6s %-13s %-12s %-10s %-12s %s\n", "GROUP", "ADDRESS", "CLASS", "ID", "DRIVER", "DESCRIPTION")
+			fmt.Printf("%-6s %-13s %-12s %-10s %-12s %s\n", "-----", "-------", "-----", "--", "------", "-----------")
+			for _, device := range devices {
+				group := "-"
+				if device.IOMMUGroup >= 0 {
+					group = fmt.Sprintf("%d", device.IOMMUGroup)
+				}
+				driver := device.Driver
+				if driver == "" {
+					driver = "-"
+				}
+				fmt.Printf("%-6s %-13s %-12s %-10s %-12s %s\n", group, device.Address, device.ClassName(), device.Vendor+":"+device.Device, driver, device.Description)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("json", false, "Output the devices as JSON")
+	return cmd
+}
+
+// checkPassthrough checks PCI devices can be passed through to a VM, warning about
+// devices the NAS itself is using
+func checkPassthrough(sshClient *ssh.Client, addresses []string) error {
+	if len(addresses) == 0 {
+		return nil
+	}
+	inventory, err := nas.NewManager(sshClient).PCIDevices()
+	if err != nil {
+		return err
+	}
+	if err := inventory.CheckPassthrough(addresses); err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		device := inventory.Device(address)
+		if device.Driver != "" && device.Driver != nas.VFIODriver {
+			fmt.Fprintf(os.Stderr, "Warning: %s is in use by the NAS (driver %s); it is detached from the NAS while the VM runs\n", address, device.Driver)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// pickerRows is the number of choices the picker shows at once
+const pickerRows = 10
+
+// addArgPickers lets commands that complete their arguments ask for missing ones on
+// an interactive terminal: run without a VM name, 'qnap-vm start' offers a filtered list
+// of VMs instead of failing with a usage error. The choices are the command's
+// completions, so they come from the same short-lived cache.
+func addArgPickers(cmd *cobra.Command) {
+	if validate, run, complete := cmd.Args, cmd.RunE, cmd.ValidArgsFunction; validate != nil && run != nil && complete != nil {
+		var picked []string
+		cmd.Args = func(cmd *cobra.Command, args []string) error {
+			err := validate(cmd, args)
+			if err == nil || !canPick(cmd) {
+				return err
+			}
+
+			chosen := append([]string(nil), args...)
+			for validate(cmd, chosen) != nil {
+				choices, _ := complete(cmd, chosen, "")
+				if len(choices) == 0 {
+					return err
+				}
+				choice, pickErr := pick(argPlaceholder(cmd, len(chosen)), choices)
+				if pickErr != nil {
+					return pickErr
+				}
+				chosen = append(chosen, choice)
+			}
+			picked = chosen
+			return nil
+		}
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			if len(picked) > len(args) {
+				args = picked
+			}
+			return run(cmd, args)
+		}
+	}
+
+	for _, sub := range cmd.Commands() {
+		addArgPickers(sub)
+	}
+}
+
+// canPick reports whether missing arguments may be asked for: stdin and stderr are a
+// terminal and --no-input is not set
+func canPick(cmd *cobra.Command) bool {
+	if noInput, _ := cmd.Flags().GetBool("no-input"); noInput {
+		return false
+	}
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stderr.Fd()))
+}
+
+// argPlaceholder names argument i from the command's usage line, e.g. VM_NAME
+func argPlaceholder(cmd *cobra.Command, i int) string {
+	fields := strings.Fields(cmd.Use)
+	if i+1 < len(fields) {
+		if name := strings.Trim(fields[i+1], "[]."); name != "" {
+			return name
+		}
+	}
+	return "argument"
+}
+
+// pick shows choices on stderr and lets the user filter them by typing and choose one
+// with the arrow keys and Enter. Esc or Ctrl-C cancels.
+func pick(label string, choices []string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from terminal: %w", err)
+	}
+	defer func() {
+		if err := term.Restore(fd, state); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to restore terminal: %v\n", err)
+		}
+	}()
+
+	p := &picker{label: label, choices: choices, out: os.Stderr}
+	p.filter()
+	p.draw()
+	in := bufio.NewReader(os.Stdin)
+	for {
+		key, err := readKey(in)
+		if err != nil {
+			p.clear()
+			return "", fmt.Errorf("failed to read from terminal: %w", err)
+		}
+
+		switch key {
+		case keyEnter:
+			if len(p.matches) == 0 {
+				continue
+			}
+			p.clear()
+			return p.matches[p.selected], nil
+		case keyCancel:
+			p.clear()
+			return "", messages.Errorf(messages.Cancelled)
+		case keyUp:
+			if p.selected > 0 {
+				p.selected--
+			}
+		case keyDown:
+			if p.selected < len(p.matches)-1 {
+				p.selected++
+			}
+		case keyBackspace:
+			if runes := []rune(p.query); len(runes) > 0 {
+				p.query = string(runes[:len(runes)-1])
+				p.filter()
+			}
+		default:
+			if unicode.IsPrint(key) {
+				p.query += string(key)
+				p.filter()
+			}
+		}
+		p.draw()
+	}
+}
+
+// Keys with special meaning in the picker; printable keys are their own rune
+const (
+	keyEnter     rune = '\r'
+	keyBackspace rune = 0x7f
+	keyCancel    rune = -1
+	keyUp        rune = -2
+	keyDown      rune = -3
+	keyIgnored   rune = -4
+)
+
+// readKey reads one key press from a raw terminal
+func readKey(in *bufio.Reader) (rune, error) {
+	r, _, err := in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+
+	switch r {
+	case '\r', '\n':
+		return keyEnter, nil
+	case 0x7f, 0x08:
+		return keyBackspace, nil
+	case 0x03, 0x04: // Ctrl-C, Ctrl-D
+		return keyCancel, nil
+	case 0x10: // Ctrl-P
+		return keyUp, nil
+	case 0x0e: // Ctrl-N
+		return keyDown, nil
+	case 0x1b:
+		// A lone Esc cancels; arrow keys arrive as Esc [ A or Esc O A
+		if in.Buffered() == 0 {
+			return keyCancel, nil
+		}
+		if next, _ := in.ReadByte(); next != '[' && next != 'O' {
+			return keyIgnored, nil
+		}
+		switch code, _ := in.ReadByte(); code {
+		case 'A':
+			return keyUp, nil
+		case 'B':
+			return keyDown, nil
+		}
+		return keyIgnored, nil
+	}
+	return r, nil
+}
+
+// picker is the state of an interactive choice
+type picker struct {
+	label    string
+	choices  []string
+	query    string
+	matches  []string
+	selected int
+	drawn    int // Lines drawn below the prompt line, to erase on redraw
+	out      io.Writer
+}
+
+// filter recomputes the matches for the query and resets the selection
+func (p *picker) filter() {
+	p.matches = fuzzyFilter(p.choices, p.query)
+	p.selected = 0
+}
+
+// draw renders the prompt line and the visible matches below it
+func (p *picker) draw() {
+	var b strings.Builder
+	p.rewind(&b)
+
+	// Scroll so the selection stays visible
+	first := 0
+	if p.selected >= pickerRows {
+		first = p.selected - pickerRows + 1
+	}
+	last := min(first+pickerRows, len(p.matches))
+
+	p.drawn = 0
+	for i := first; i < last; i++ {
+		if i == p.selected {
+			fmt.Fprintf(&b, "\r\n\033[7m> %s\033[0m", p.matches[i])
+		} else {
+			fmt.Fprintf(&b, "\r\n  %s", p.matches[i])
+		}
+		p.drawn++
+	}
+	fmt.Fprintf(&b, "\r\n  %d/%d", len(p.matches), len(p.choices))
+	p.drawn++
+
+	// Leave the cursor after the query
+	fmt.Fprintf(&b, "\033[%dA\r%s> %s", p.drawn, p.label, p.query)
+	if _, err := io.WriteString(p.out, b.String()); err != nil {
+		// The terminal went away; reading the next key will fail too
+	}
+}
+
+// clear erases everything the picker drew
+func (p *picker) clear() {
+	var b strings.Builder
+	p.rewind(&b)
+	p.drawn = 0
+	if _, err := io.WriteString(p.out, b.String()); err != nil {
+		// Nothing left to clean up on a closed terminal
+	}
+}
+
+// rewind moves to the start of the prompt line and erases it and the lines below
+func (p *picker) rewind(b *strings.Builder) {
+	b.WriteString("\r\033[J")
+}
+
+// fuzzyFilter returns the choices containing the letters of query in order, ignoring
+// case. Prefix matches come first, then substring matches, then the rest, each in the
+// original order.
+func fuzzyFilter(choices []string, query string) []string {
+	query = strings.ToLower(query)
+	var prefix, substring, scattered []string
+	for _, choice := range choices {
+		lower := strings.ToLower(choice)
+		switch {
+		case strings.HasPrefix(lower, query):
+			prefix = append(prefix, choice)
+		case strings.Contains(lower, query):
+			substring = append(substring, choice)
+		case isSubsequence(query, lower):
+			scattered = append(scattered, choice)
+		}
+	}
+	return append(append(prefix, substring...), scattered...)
+}
+
+// isSubsequence reports whether the runes of sub appear in s in order
+func isSubsequence(sub, s string) bool {
+	runes := []rune(sub)
+	for _, r := range s {
+		if len(runes) == 0 {
+			break
+		}
+		if r == runes[0] {
+			runes = runes[1:]
+		}
+	}
+	return len(runes) == 0
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// portProbeTimeout bounds probing a host's alternate SSH ports
+const portProbeTimeout = 5 * time.Second
+
+// probeSSHPort looks for an SSH server on the host's probe_ports after nothing answered
+// on its port, and returns the first port that has one, or 0
+func probeSSHPort(cfg config.Config) int {
+	var candidates []int
+	for _, port := range cfg.ProbePorts {
+		if port != cfg.Port && !slices.Contains(candidates, port) {
+			candidates = append(candidates, port)
+		}
+	}
+	if len(candidates) == 0 {
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "Nothing answered on port %d of %s; trying %s\n", cfg.Port, cfg.Host, formatPorts(candidates))
+	open := ssh.ProbePorts(commandCtx, cfg.Host, candidates, portProbeTimeout)
+	if len(open) == 0 {
+		return 0
+	}
+	return open[0]
+}
+
+// suggestPort tells the user the host answered on another port and offers to record it
+// in the host entry. Ports given by --port or QNAP_VM_PORT are left to the user.
+func suggestPort(cfg config.Config, port int) {
+	fmt.Fprintf(os.Stderr, "Warning: SSH answered on port %d of %s instead of %d\n", port, cfg.Host, cfg.Port)
+	switch source := cfg.Sources["port"]; {
+	case source == config.SourceFlag:
+		fmt.Fprintf(os.Stderr, "Use --port %d next time\n", port)
+		return
+	case source == config.SourceEnv:
+		fmt.Fprintf(os.Stderr, "Set QNAP_VM_PORT=%d to use it directly\n", port)
+		return
+	case cfg.HostName == "":
+		fmt.Fprintf(os.Stderr, "Use --port %d next time\n", port)
+		return
+	}
+
+	suggestion := fmt.Sprintf("qnap-vm config set --name %s --port %d", cfg.HostName, port)
+	if noInput, _ := rootCmd.PersistentFlags().GetBool("no-input"); noInput || !stdinIsTerminal() {
+		fmt.Fprintf(os.Stderr, "Run '%s' to use it directly\n", suggestion)
+		return
+	}
+	update, err := confirm(fmt.Sprintf("Update host '%s' to use port %d? (y/N): ", cfg.HostName, port))
+	if err != nil || !update {
+		return
+	}
+
+	configFile, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: port not saved: failed to load config: %v\n", err)
+		return
+	}
+	hostConfig, exists := configFile.GetHostConfig(cfg.HostName)
+	if !exists {
+		return
+	}
+	hostConfig.Port = port
+	configFile.SetHostConfig(cfg.HostName, hostConfig)
+	if err := config.SaveConfig(configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: port not saved: failed to save config: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Host '%s' now uses port %d\n", cfg.HostName, port)
+}
+
+// parsePorts parses a comma-separated port list such as "2222,22"
+func parsePorts(value string) ([]int, error) {
+	var ports []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		port, err := strconv.Atoi(field)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port '%s'", field)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// formatPorts lists ports for messages, e.g. "ports 2222, 22"
+func formatPorts(ports []int) string {
+	fields := make([]string, len(ports))
+	for i, port := range ports {
+		fields[i] = strconv.Itoa(port)
+	}
+	if len(ports) == 1 {
+		return "port " + fields[0]
+	}
+	return "ports " + strings.Join(fields, ", ")
+}
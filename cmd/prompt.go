@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"golang.org/x/term"
+)
+
+// stdinReader is shared by everything reading lines from stdin, so answers piped in for
+// several prompts are not lost to another reader's buffer
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// confirm asks a yes/no question and reports whether the answer was yes. --yes answers
+// it without asking. Answers may be piped in (echo y | qnap-vm delete web); with
+// --no-input, or when stdin ends without an answer (cron, CI), it fails instead of
+// waiting for an answer that will never come.
+func confirm(prompt string) (bool, error) {
+	if yes, _ := rootCmd.PersistentFlags().GetBool("yes"); yes {
+		return true, nil
+	}
+	if noInput, _ := rootCmd.PersistentFlags().GetBool("no-input"); noInput {
+		return false, messages.Errorf(messages.NeedsYes)
+	}
+
+	fmt.Print(prompt)
+	terminal := stdinIsTerminal()
+	if !terminal {
+		// The answer is not echoed, so end the prompt's line
+		defer fmt.Println()
+	}
+	return readAnswer(stdinReader, terminal)
+}
+
+// readAnswer reads a yes/no answer line. Off a terminal, input that ends before any
+// answer fails with NeedsYes; on a terminal, as before, it counts as no.
+func readAnswer(r *bufio.Reader, terminal bool) (bool, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && line == "" {
+		if !terminal && errors.Is(err, io.EOF) {
+			return false, messages.Errorf(messages.NeedsYes)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: failed to read input: %v\n", err)
+	}
+	response := strings.ToLower(strings.TrimSpace(line))
+	return response == "y" || response == "yes", nil
+}
+
+// stdinIsTerminal reports whether stdin is an interactive terminal rather than a pipe,
+// file or /dev/null
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// passwordPrompt returns an SSH password prompt for user@host on the terminal, or nil
+// when nobody is there to answer it. Entered passwords are redacted from the session
+// transcript and log, and the last one is stored in entered if it is not nil.
+func passwordPrompt(user, host string, entered *string) func() (string, error) {
+	if noInput, _ := rootCmd.PersistentFlags().GetBool("no-input"); noInput || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	return func() (string, error) {
+		fmt.Fprintf(os.Stderr, "%s@%s's password: ", user, host)
+		password, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+
+		sessionTranscript.Redact(string(password))
+		redactLog(string(password))
+		if entered != nil {
+			*entered = string(password)
+		}
+		return string(password), nil
+	}
+}
+
+// trustHostKey asks whether to trust a host seen for the first time, showing its key's
+// fingerprint to compare with the NAS (ssh-keygen -lf /etc/ssh/ssh_host_ed25519_key.pub).
+// With --yes, --no-input or no terminal the key is trusted with a warning, as OpenSSH's
+// accept-new does; --strict-host-key refuses it before this is asked.
+func trustHostKey(host, fingerprint string) (bool, error) {
+	yes, _ := rootCmd.PersistentFlags().GetBool("yes")
+	noInput, _ := rootCmd.PersistentFlags().GetBool("no-input")
+	if yes || noInput || !stdinIsTerminal() {
+		fmt.Fprintf(os.Stderr, "Warning: permanently added %s (%s) to known_hosts\n", host, fingerprint)
+		return true, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "The authenticity of host '%s' can't be established.\nKey fingerprint is %s.\n", host, fingerprint)
+	return confirm("Trust this host and add it to known_hosts? (y/N): ")
+}
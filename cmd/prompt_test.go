@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+)
+
+func TestReadAnswer(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		terminal bool
+		want     bool
+		wantID   messages.ID
+	}{
+		{name: "piped yes", input: "y\n", want: true},
+		{name: "piped yes without newline", input: "yes", want: true},
+		{name: "piped no", input: "n\n"},
+		{name: "piped empty line", input: "\n"},
+		{name: "stdin ended", input: "", wantID: messages.NeedsYes},
+		{name: "terminal end of input", input: "", terminal: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readAnswer(bufio.NewReader(strings.NewReader(tt.input)), tt.terminal)
+			if tt.wantID != "" {
+				if id := messages.IDOf(err); id != tt.wantID {
+					t.Fatalf("readAnswer() error = %v, want %s", err, tt.wantID)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("readAnswer() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadAnswerKeepsLaterAnswers(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("y\nn\n"))
+	for i, want := range []bool{true, false} {
+		if got, err := readAnswer(r, false); err != nil || got != want {
+			t.Errorf("answer %d = %v, %v, want %v", i+1, got, err, want)
+		}
+	}
+	if _, err := readAnswer(r, false); err == nil {
+		t.Error("readAnswer() after the last answer should fail")
+	}
+}
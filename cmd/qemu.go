@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func qemuArgsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "qemu-args",
+		Short: "Manage raw QEMU command-line arguments",
+		Long: `Manage QEMU arguments passed through the libvirt qemu:commandline namespace.
+
+This is an escape hatch for options qnap-vm does not model. Arguments are
+passed to QEMU verbatim, one value per argument, and are kept in the VM's
+persistent definition. Options libvirt manages itself (such as -m or -smp)
+are rejected.`,
+	}
+
+	// QEMU args list command
+	listArgsCmd := &cobra.Command{
+		Use:               "list [VM_NAME]",
+		Short:             "Show QEMU passthrough arguments for a VM",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			vmName := args[0]
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			qemuArgs, err := virshClient.GetQemuArgs(vmName)
+			if err != nil {
+				return err
+			}
+
+			if len(qemuArgs) == 0 {
+				fmt.Printf("VM '%s' has no QEMU passthrough arguments\n", vmName)
+				return nil
+			}
+
+			for _, arg := range qemuArgs {
+				fmt.Println(arg)
+			}
+			return nil
+		},
+	}
+
+	// QEMU args set command
+	setArgsCmd := &cobra.Command{
+		Use:   "set [VM_NAME] -- [QEMU_ARG...]",
+		Short: "Replace QEMU passthrough arguments for a VM",
+		Long: `Replace the QEMU passthrough arguments for a VM. Use '--' before the
+arguments so they are not parsed as qnap-vm flags, e.g.
+
+  qnap-vm qemu-args set myvm -- -device virtio-balloon-pci
+
+Changes take effect the next time the VM starts.`,
+		Args:              cobra.MinimumNArgs(2),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return setQemuArgs(cmd, args[0], args[1:])
+		},
+	}
+
+	// QEMU args clear command
+	clearArgsCmd := &cobra.Command{
+		Use:               "clear [VM_NAME]",
+		Short:             "Remove all QEMU passthrough arguments from a VM",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return setQemuArgs(cmd, args[0], nil)
+		},
+	}
+
+	cmd.AddCommand(listArgsCmd, setArgsCmd, clearArgsCmd)
+	return cmd
+}
+
+// setQemuArgs replaces a VM's QEMU passthrough arguments
+func setQemuArgs(cmd *cobra.Command, vmName string, qemuArgs []string) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Connect to QNAP device
+	sshClient, virshClient, err := connectToQNAP(*cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := sshClient.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+		}
+	}()
+
+	if len(qemuArgs) > 0 {
+		printQemuArgsWarning()
+	}
+
+	if err := virshClient.SetQemuArgs(vmName, qemuArgs); err != nil {
+		return err
+	}
+
+	if len(qemuArgs) == 0 {
+		statusf("QEMU passthrough arguments removed from VM '%s'\n", vmName)
+	} else {
+		statusf("QEMU passthrough arguments for VM '%s' set to: %s\n", vmName, strings.Join(qemuArgs, " "))
+	}
+	statusln("Changes take effect the next time the VM starts.")
+	return nil
+}
+
+// printQemuArgsWarning warns that passthrough arguments bypass qnap-vm's checks
+func printQemuArgsWarning() {
+	fmt.Fprintln(os.Stderr, "⚠️  WARNING: QEMU arguments are passed through unchecked. Invalid or conflicting")
+	fmt.Fprintln(os.Stderr, "   options can stop the VM from starting and are not supported by Virtualization Station.")
+}
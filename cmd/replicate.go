@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/notify"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/scttfrdmn/qnap-vm/pkg/storage"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func replicateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "replicate VM_NAME --to HOST_NAME",
+		Short: "Replicate a ZFS-backed VM to another QuTS hero NAS",
+		Long: `Copy a VM's ZFS dataset to another QuTS hero NAS for disaster recovery.
+
+A 'repl-' snapshot of the VM dataset is taken and streamed with 'zfs send'
+through this machine to the host named by --to, which must be configured
+with 'qnap-vm config set --name HOST_NAME'. After the first run only the
+changes since the last replicated snapshot are sent.
+
+The copy is received unmounted into <pool>/qnap-vm/<name> on the target.
+Pass --define to also define the VM there (stopped), ready to start if the
+source NAS is lost. Do not run both copies at the same time: they share
+MAC addresses and UUID.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vmName := args[0]
+			targetHost, _ := cmd.Flags().GetString("to")
+			targetPool, _ := cmd.Flags().GetString("target-pool")
+			full, _ := cmd.Flags().GetBool("full")
+			keep, _ := cmd.Flags().GetInt("keep")
+			define, _ := cmd.Flags().GetBool("define")
+
+			if keep < 1 {
+				return fmt.Errorf("--keep must be at least 1 so the next run can send incrementally")
+			}
+
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+			targetCfg, err := loadHostConfig(targetHost)
+			if err != nil {
+				return err
+			}
+
+			// Connect to both QNAP devices
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			targetSSH, targetVirsh, err := connectToQNAP(*targetCfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := targetSSH.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close target SSH connection: %v\n", err)
+				}
+			}()
+
+			vm, err := virshClient.GetVM(vmName)
+			if err != nil {
+				return fmt.Errorf("failed to get VM '%s': %w", vmName, err)
+			}
+
+			sourceStorage := newStorageManager(sshClient, cfg)
+			targetStorage := newStorageManager(targetSSH, targetCfg)
+
+			dataset := vmDataset(sourceStorage, virshClient, vmName)
+			if dataset == "" {
+				return fmt.Errorf("VM '%s' is not stored in a qnap-vm ZFS dataset; replication requires a ZFS pool on QuTS hero", vmName)
+			}
+
+			if targetPool == "" {
+				targetPool = strings.SplitN(dataset, "/", 2)[0]
+			}
+			if !targetStorage.DatasetExists(targetPool) {
+				return fmt.Errorf("ZFS pool '%s' not found on %s (use --target-pool)", targetPool, targetHost)
+			}
+			targetDataset := path.Join(targetPool, "qnap-vm", path.Base(dataset))
+
+			// Check the target copy is not in use before overwriting it
+			if targetVM, err := targetVirsh.GetVM(vmName); err == nil && strings.Contains(targetVM.State, "running") {
+				return fmt.Errorf("VM '%s' is running on %s; stop it before replicating over it", vmName, targetHost)
+			}
+
+			base := ""
+			targetExists := targetStorage.DatasetExists(targetDataset)
+			if targetExists && !full {
+				sourceSnapshots, err := sourceStorage.ListDatasetSnapshots(dataset)
+				if err != nil {
+					return err
+				}
+				targetSnapshots, err := targetStorage.ListDatasetSnapshots(targetDataset)
+				if err != nil {
+					return err
+				}
+				base = storage.LatestCommonSnapshot(sourceSnapshots, targetSnapshots)
+				if base == "" {
+					return fmt.Errorf("%s already exists on %s without a common replication snapshot; use --full to overwrite it", targetDataset, targetHost)
+				}
+			}
+
+			// A full stream cannot be received onto the existing copy and its snapshots, so
+			// it is received next to it and replaces it once complete
+			snapshotName := storage.ReplicationPrefix + time.Now().Format("20060102-150405")
+			receiveDataset := targetDataset
+			if targetExists && base == "" {
+				confirmed, err := confirm(fmt.Sprintf("Replace %s and all its snapshots on %s with a full copy? (y/N): ", targetDataset, targetCfg.Label()))
+				if err != nil {
+					return err
+				}
+				if !confirmed {
+					messages.Println(messages.Cancelled)
+					return nil
+				}
+				receiveDataset = storage.ReplacementDataset(targetDataset, snapshotName)
+			}
+
+			statusf("Creating snapshot %s@%s...\n", dataset, snapshotName)
+			if err := snapshotVMDataset(sourceStorage, virshClient, vm, dataset, snapshotName, fmt.Sprintf("Replica to %s", targetHost)); err != nil {
+				return fmt.Errorf("failed to create snapshot: %w", err)
+			}
+
+			if !targetExists {
+				if err := targetStorage.CreateParentDataset(targetDataset); err != nil {
+					return err
+				}
+			}
+
+			if base == "" {
+				statusf("Sending full stream to %s:%s...\n", targetHost, targetDataset)
+			} else {
+				statusf("Sending changes since %s to %s:%s...\n", base, targetHost, targetDataset)
+			}
+
+			start := time.Now()
+			sent, err := storage.ReplicateDataset(sourceStorage, dataset, base, snapshotName, targetStorage, receiveDataset)
+			if err != nil {
+				return err
+			}
+			if receiveDataset != targetDataset {
+				if err := targetStorage.ReplaceDataset(targetDataset, receiveDataset); err != nil {
+					return fmt.Errorf("%w (the new copy is in %s)", err, receiveDataset)
+				}
+			}
+			statusf("Replicated %s in %s\n", formatBytes(sent), time.Since(start).Round(time.Second))
+
+			// Prune old replication snapshots; the newest is the base for the next run
+			for _, side := range []struct {
+				cfg     *config.Config
+				ssh     *ssh.Client
+				manager *storage.Manager
+				dataset string
+			}{{cfg, sshClient, sourceStorage, dataset}, {targetCfg, targetSSH, targetStorage, targetDataset}} {
+				pruned, err := side.manager.PruneReplicationSnapshots(side.dataset, keep)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to prune replication snapshots of %s: %v\n", side.dataset, err)
+				}
+				if len(pruned) > 0 {
+					sendNotification(newNotifier(side.cfg, side.ssh), notify.Event{
+						Type:     notify.EventSnapshotPruned,
+						Severity: notify.SeverityInfo,
+						VM:       vmName,
+						Message:  fmt.Sprintf("Pruned %d replication snapshot(s) of VM '%s' from %s: %s", len(pruned), vmName, side.dataset, strings.Join(pruned, ", ")),
+					})
+				}
+			}
+
+			if !define {
+				return nil
+			}
+
+			if _, err := targetVirsh.GetVM(vmName); err == nil {
+				statusf("VM '%s' is already defined on %s\n", vmName, targetHost)
+				return nil
+			}
+
+			return defineReplica(sourceStorage, targetStorage, virshClient, targetVirsh, vmName, dataset, targetDataset, targetHost)
+		},
+	}
+
+	cmd.Flags().String("to", "", "Configured host name of the target NAS (required)")
+	cmd.Flags().String("target-pool", "", "ZFS pool on the target (default: same name as the source pool)")
+	cmd.Flags().Bool("full", false, "Send a full stream, replacing the target dataset and its snapshots (asks first)")
+	cmd.Flags().Int("keep", 3, "Number of replication snapshots to keep on each side")
+	cmd.Flags().Bool("define", false, "Define the VM on the target after the first replication")
+	if err := cmd.RegisterFlagCompletionFunc("to", completeHostName); err != nil {
+		// Flag is registered above; registering its completion cannot fail
+	}
+
+	if err := cmd.MarkFlagRequired("to"); err != nil {
+		// Flag is registered above; marking cannot fail
+	}
+
+	return cmd
+}
+
+// defineReplica mounts the replicated dataset on the target and defines the VM there
+// with its disks pointing into it
+func defineReplica(sourceStorage, targetStorage *storage.Manager, sourceVirsh, targetVirsh *virsh.Client, vmName, dataset, targetDataset, targetHost string) error {
+	sourceMount, err := sourceStorage.DatasetMountpoint(dataset)
+	if err != nil {
+		return err
+	}
+	targetMount, err := targetStorage.MountDataset(targetDataset)
+	if err != nil {
+		return err
+	}
+
+	disks, err := sourceVirsh.ListDisks(vmName)
+	if err != nil {
+		return err
+	}
+	diskPaths := make(map[string]string)
+	for _, disk := range disks {
+		if disk.Source.File == "" {
+			continue
+		}
+		rel, ok := pathWithin(sourceMount, disk.Source.File)
+		if !ok {
+			return fmt.Errorf("disk %s is outside dataset %s", disk.Source.File, dataset)
+		}
+		diskPaths[disk.Source.File] = path.Join(targetMount, rel)
+	}
+
+	domainXML, err := sourceVirsh.ExportDomainXML(vmName, diskPaths)
+	if err != nil {
+		return err
+	}
+	if err := targetVirsh.DefineDomainXML(vmName, domainXML); err != nil {
+		return err
+	}
+
+	statusf("VM '%s' defined on %s (stopped)\n", vmName, targetHost)
+	statusln("Do not start both copies at the same time: they share MAC addresses and UUID")
+	return nil
+}
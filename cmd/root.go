@@ -2,13 +2,20 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/inventory"
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
 	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
 	"github.com/scttfrdmn/qnap-vm/pkg/storage"
 	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
@@ -21,6 +28,16 @@ var (
 	date    = "unknown"
 )
 
+// Self-update build settings, set with -ldflags "-X github.com/scttfrdmn/qnap-vm/cmd.<name>=<value>"
+var (
+	// updatePublicKey is the base64 Ed25519 key that signs release checksums; when set,
+	// self-update refuses releases without a valid signature
+	updatePublicKey = ""
+	// updateDisabled names the package format of builds updated by a package manager
+	// (e.g. "deb/rpm"); self-update refuses to run when it is set
+	updateDisabled = ""
+)
+
 var rootCmd = &cobra.Command{
 	Use:   "qnap-vm",
 	Short: "A CLI tool for managing virtual machines on QNAP devices",
@@ -28,15 +45,35 @@ var rootCmd = &cobra.Command{
 with Virtualization Station. It provides easy-to-use commands for VM lifecycle
 management, configuration, and monitoring.`,
 	Version: version,
+	PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+		useConfigFlag(cmd)
+		setupMessages(cmd)
+		if err := setupContext(cmd); err != nil {
+			return err
+		}
+		return setupLogging(cmd)
+	},
 }
 
 func init() {
 	// Add global flags
+	rootCmd.PersistentFlags().String("config", "", "Configuration file (default: $QNAP_VM_CONFIG or ~/.qnap-vm/config.yaml)")
 	rootCmd.PersistentFlags().StringP("host", "H", "", "QNAP host address")
+	rootCmd.PersistentFlags().String("host-name", "", "Use this named config entry instead of the default host (see 'qnap-vm config show')")
 	rootCmd.PersistentFlags().StringP("username", "u", "", "SSH username")
 	rootCmd.PersistentFlags().IntP("port", "p", 22, "SSH port")
 	rootCmd.PersistentFlags().StringP("keyfile", "k", "", "SSH private key file")
-	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "Enable verbose output")
+	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "Log connections and remote commands to stderr")
+	rootCmd.PersistentFlags().String("log-file", "", "Append a timestamped log of remote commands and their output to this file")
+	rootCmd.PersistentFlags().String("log-level", "debug", "Log level for --verbose and --log-file: debug, info or warn")
+	rootCmd.PersistentFlags().BoolP("quiet", "q", false, "Suppress status messages and progress bars; rely on the exit code")
+	rootCmd.PersistentFlags().Bool("offline", false, "Show cached data for list, status and snapshot list without contacting the NAS")
+	rootCmd.PersistentFlags().BoolP("yes", "y", false, "Answer yes to confirmation prompts, for scripts and cron")
+	rootCmd.PersistentFlags().Bool("no-input", false, "Fail instead of prompting when a confirmation is needed")
+	rootCmd.PersistentFlags().Bool("dry-run", false, "Print the commands that would change the NAS instead of running them")
+	rootCmd.PersistentFlags().Duration("timeout", 0, "Stop remote commands and fail after this long, e.g. 10m (default: no limit)")
+	rootCmd.PersistentFlags().Bool("strict-host-key", false, "Refuse hosts whose SSH key is not already in known_hosts")
+	rootCmd.PersistentFlags().Bool("message-ids", false, "Prefix messages with stable IDs for scripts (or set QNAP_VM_MESSAGE_IDS=1)")
 
 	// Add subcommands
 	rootCmd.AddCommand(
@@ -47,17 +84,58 @@ func init() {
 		deleteCmd(),
 		statusCmd(),
 		snapshotCmd(),
+		checkpointCmd(),
+		rollbackCmd(),
 		statsCmd(),
 		cloneCmd(),
+		adoptCmd(),
 		consoleCmd(),
 		configCmd(),
+		discoverCmd(),
+		notifyCmd(),
+		doctorCmd(),
+		isoCmd(),
+		fileCmd(),
+		qemuArgsCmd(),
+		dumpCmd(),
+		diskCmd(),
+		cdromCmd(),
+		tagCmd(),
+		tuneCmd(),
+		setCmd(),
+		storageCmd(),
+		hostCmd(),
+		pciCmd(),
+		networkCmd(),
+		nicCmd(),
+		auditCmd(),
+		migrateFromCmd(),
+		replicateCmd(),
+		backupCmd(),
+		benchCmd(),
+		supportBundleCmd(),
+		messagesCmd(),
 		versionCmd(),
+		selfUpdateCmd(),
+		serveCmd(),
+		chaosTestCmd(),
 	)
+	addArgPickers(rootCmd)
+	markUsageErrors(rootCmd)
+	if err := rootCmd.RegisterFlagCompletionFunc("host-name", completeHostName); err != nil {
+		// Flag is registered above; registering its completion cannot fail
+	}
 }
 
 // Execute runs the root command
 func Execute() error {
-	return rootCmd.Execute()
+	ctx, stop := interruptContext()
+	defer stop()
+	err := explainCancel(rootCmd.ExecuteContext(ctx))
+	cancelTimeout()
+	saveSessionTranscript(os.Args[1:], err)
+	finishLogging(err)
+	return err
 }
 
 // SetVersionInfo sets version information for the application
@@ -72,66 +150,94 @@ func listCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "list",
 		Short: "List all virtual machines",
-		Long:  "List all virtual machines on the QNAP device",
+		Long: `List all virtual machines on the QNAP device.
+
+When the NAS cannot be reached, or with --offline, the list last seen is shown
+from the local cache, marked with its age.`,
 		RunE: func(cmd *cobra.Command, _ []string) error {
 			cfg, err := loadConfig(cmd)
 			if err != nil {
 				return err
 			}
+			if offline, _ := cmd.Flags().GetBool("offline"); usesAPI(cfg) && !offline {
+				return listVMsAPI(cfg)
+			}
 
-			// Connect to QNAP device
-			sshClient, virshClient, err := connectToQNAP(*cfg)
+			// Connect to QNAP device, or fall back to the cached inventory
+			sshClient, virshClient, cached, err := connectOrOffline(cmd, cfg)
 			if err != nil {
 				return err
 			}
+			if cached != nil {
+				if cached.VMs == nil {
+					return cached.show("VM list", time.Time{})
+				}
+				if err := cached.show("VM list", cached.VMs.Updated); err != nil {
+					return err
+				}
+				printVMList(cached.VMs.VMs)
+				return nil
+			}
 			defer func() {
 				if err := sshClient.Close(); err != nil {
 					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
 				}
 			}()
 
-			// List VMs
-			vms, err := virshClient.ListVMs()
+			// List VMs with definitions read in one bulk pass
+			vms, err := virshClient.ListVMsWithMetadata()
 			if err != nil {
 				return fmt.Errorf("failed to list VMs: %w", err)
 			}
+			updateInventory(cfg, func(inv *inventory.Inventory, now time.Time) {
+				inv.SetVMs(vms, now)
+			})
 
-			if len(vms) == 0 {
-				fmt.Println("No virtual machines found.")
-				return nil
-			}
+			printVMList(vms)
+			return nil
+		},
+	}
+}
 
-			// Display VMs in a table format
-			fmt.Printf("%-5s %-20s %-12s %-8s %-8s\n", "ID", "NAME", "STATE", "MEMORY", "CPUS")
-			fmt.Printf("%-5s %-20s %-12s %-8s %-8s\n", "-----", "--------------------", "------------", "--------", "--------")
+// printVMList shows VMs as a table
+func printVMList(vms []virsh.VMInfo) {
+	if len(vms) == 0 {
+		fmt.Println("No virtual machines found.")
+		return
+	}
 
-			for _, vm := range vms {
-				// Get detailed info for each VM
-				if detailed, err := virshClient.GetVMDetails(vm.Name); err == nil {
-					vm = *detailed
-				}
+	// Display VMs in a table format
+	fmt.Printf("%-5s %-20s %-12s %-8s %-8s %-25s %-20s\n", "ID", "NAME", "STATE", "MEMORY", "CPUS", "TITLE", "TAGS")
+	fmt.Printf("%-5s %-20s %-12s %-8s %-8s %-25s %-20s\n", "-----", "--------------------", "------------", "--------", "--------", "-------------------------", "--------------------")
 
-				idStr := "-"
-				if vm.ID > 0 {
-					idStr = fmt.Sprintf("%d", vm.ID)
-				}
+	for _, vm := range vms {
+		idStr := "-"
+		if vm.ID > 0 {
+			idStr = fmt.Sprintf("%d", vm.ID)
+		}
 
-				memoryStr := "-"
-				if vm.Memory > 0 {
-					memoryStr = fmt.Sprintf("%dM", vm.Memory)
-				}
+		memoryStr := "-"
+		if vm.Memory > 0 {
+			memoryStr = fmt.Sprintf("%dM", vm.Memory)
+		}
 
-				cpusStr := "-"
-				if vm.CPUs > 0 {
-					cpusStr = fmt.Sprintf("%d", vm.CPUs)
-				}
+		cpusStr := "-"
+		if vm.CPUs > 0 {
+			cpusStr = fmt.Sprintf("%d", vm.CPUs)
+		}
 
-				fmt.Printf("%-5s %-20s %-12s %-8s %-8s\n",
-					idStr, vm.Name, vm.State, memoryStr, cpusStr)
-			}
+		titleStr := "-"
+		if vm.Title != "" {
+			titleStr = vm.Title
+		}
 
-			return nil
-		},
+		tagsStr := "-"
+		if len(vm.Tags) > 0 {
+			tagsStr = strings.Join(vm.Tags, ",")
+		}
+
+		fmt.Printf("%-5s %-20s %-12s %-8s %-8s %-25s %-20s\n",
+			idStr, vm.Name, vm.StateLabel(), memoryStr, cpusStr, titleStr, tagsStr)
 	}
 }
 
@@ -152,8 +258,61 @@ func createCmd() *cobra.Command {
 			// Get command line arguments
 			memoryStr, _ := cmd.Flags().GetString("memory")
 			cpusStr, _ := cmd.Flags().GetString("cpus")
-			diskSize, _ := cmd.Flags().GetString("disk")
+			diskFlags, _ := cmd.Flags().GetStringArray("disk")
 			isoPath, _ := cmd.Flags().GetString("iso")
+			graphics, _ := cmd.Flags().GetString("graphics")
+			noClipboard, _ := cmd.Flags().GetBool("no-clipboard")
+			noRNG, _ := cmd.Flags().GetBool("no-rng")
+			qemuArgs, _ := cmd.Flags().GetStringArray("qemu-arg")
+			networkSpec, _ := cmd.Flags().GetString("network")
+			network, err := virsh.ParseNetwork(networkSpec)
+			if err != nil {
+				return err
+			}
+			osName, _ := cmd.Flags().GetString("os")
+			osVariant, _ := cmd.Flags().GetString("os-variant")
+			preset, err := virsh.ResolveOSPreset(osName, osVariant)
+			if err != nil {
+				return err
+			}
+			netModel, _ := cmd.Flags().GetString("nic-model")
+			if cmd.Flags().Changed("net-model") {
+				netModel, _ = cmd.Flags().GetString("net-model")
+			} else if !cmd.Flags().Changed("nic-model") && preset.NICModel != "" {
+				netModel = preset.NICModel
+			}
+			mtu, _ := cmd.Flags().GetInt("mtu")
+			mac, _ := cmd.Flags().GetString("mac")
+			if mac != "" {
+				if mac, err = virsh.ParseMAC(mac); err != nil {
+					return err
+				}
+			}
+			netQueues, _ := cmd.Flags().GetInt("net-queues")
+			nicMAC := mac
+			if nicMAC == "" {
+				nicMAC = virsh.StableMAC(vmName, 0)
+			}
+			seed, err := cloudInitSeed(cmd, vmName, network, nicMAC)
+			if err != nil {
+				return err
+			}
+			poolName, _ := cmd.Flags().GetString("pool")
+			title, _ := cmd.Flags().GetString("title")
+			tags, _ := cmd.Flags().GetStringSlice("tag")
+
+			if err := virsh.ValidateQemuArgs(qemuArgs); err != nil {
+				return err
+			}
+
+			placement, err := placementStrategy(cmd, cfg)
+			if err != nil {
+				return err
+			}
+
+			if graphics != "vnc" && graphics != "spice" {
+				return fmt.Errorf("invalid graphics type: %s (use vnc or spice)", graphics)
+			}
 
 			// Parse memory and CPU values
 			memory, err := strconv.Atoi(memoryStr)
@@ -166,6 +325,152 @@ func createCmd() *cobra.Command {
 				return fmt.Errorf("invalid CPU value: %s", cpusStr)
 			}
 
+			// A topology sets the vCPU count unless --cpus is given too, when they must agree
+			var topology *virsh.CPUTopology
+			if topologySpec, _ := cmd.Flags().GetString("cpu-topology"); topologySpec != "" {
+				t, err := virsh.ParseCPUTopology(topologySpec)
+				if err != nil {
+					return err
+				}
+				if !cmd.Flags().Changed("cpus") {
+					cpus = t.VCPUs()
+				} else if t.VCPUs() != cpus {
+					return fmt.Errorf("CPU topology %s has %d vCPUs, but --cpus is %d", topologySpec, t.VCPUs(), cpus)
+				}
+				topology = &t
+			}
+
+			// Nested guests get the NAS's CPU unless a model is named
+			cpuModel, _ := cmd.Flags().GetString("cpu-model")
+			nested, _ := cmd.Flags().GetBool("nested")
+			if nested && cpuModel == "" {
+				cpuModel = virsh.CPUModeHostPassthrough
+			}
+			if cpuModel != "" {
+				if err := virsh.ValidateCPUModel(cpuModel); err != nil {
+					return err
+				}
+			}
+
+			if err := virsh.ValidateNetConfig(netModel, netQueues, cpus); err != nil {
+				return err
+			}
+			if err := virsh.ValidateMTU(mtu, netModel); err != nil {
+				return err
+			}
+
+			cpuPins, err := cpuPinsFromFlags(cmd, nil, cpus)
+			if err != nil {
+				return err
+			}
+
+			var numa *virsh.NUMAConfig
+			if numaSpec, _ := cmd.Flags().GetString("numa"); numaSpec != "" {
+				n, err := virsh.ParseNUMAConfig(numaSpec)
+				if err != nil {
+					return err
+				}
+				if err := virsh.ValidateNUMAConfig(n, cpus, memory, 0); err != nil {
+					return err
+				}
+				numa = &n
+			}
+
+			// Parse disk specifications; the first disk is the boot disk
+			var diskSpecs []storage.DiskSpec
+			for _, diskFlag := range diskFlags {
+				spec, err := storage.ParseDiskSpec(diskFlag)
+				if err != nil {
+					return err
+				}
+				if err := virsh.ValidateDiskOptions(diskOptions(spec)); err != nil {
+					return fmt.Errorf("disk '%s': %w", diskFlag, err)
+				}
+				diskSpecs = append(diskSpecs, spec)
+			}
+			if len(diskSpecs) == 0 {
+				return fmt.Errorf("at least one --disk is required")
+			}
+			if diskSpecs[0].Bus == "" {
+				diskSpecs[0].Bus = preset.DiskBus
+			}
+
+			// Secure Boot needs SMM, which only the q35 chipset has, and q35 has no IDE
+			uefi, _ := cmd.Flags().GetBool("uefi")
+			secureBoot, _ := cmd.Flags().GetBool("secure-boot")
+			machine, _ := cmd.Flags().GetString("machine")
+			if !cmd.Flags().Changed("uefi") {
+				uefi = preset.UEFI
+			}
+			if !cmd.Flags().Changed("secure-boot") {
+				secureBoot = preset.SecureBoot
+			}
+			if machine == "" {
+				machine = preset.Machine
+			}
+			if secureBoot {
+				uefi = true
+				if machine == "" {
+					machine = virsh.MachineQ35
+				} else if !virsh.IsQ35(machine) {
+					return fmt.Errorf("--secure-boot needs a q35 machine type (got %s)", machine)
+				}
+			}
+			if virsh.IsQ35(machine) {
+				for _, spec := range diskSpecs {
+					if spec.Bus == "ide" {
+						return fmt.Errorf("the q35 chipset has no IDE bus; use bus=sata or bus=virtio")
+					}
+				}
+			}
+
+			var watchdog *virsh.DomainWatchdog
+			if watchdogSpec, _ := cmd.Flags().GetString("watchdog"); watchdogSpec != "" {
+				if watchdog, err = virsh.ParseWatchdog(watchdogSpec); err != nil {
+					return err
+				}
+			}
+			var hostDevs []string
+			hostdevSpecs, _ := cmd.Flags().GetStringArray("hostdev")
+			for _, spec := range hostdevSpecs {
+				address, err := virsh.ParseHostdev(spec)
+				if err != nil {
+					return err
+				}
+				hostDevs = append(hostDevs, address)
+			}
+			onCrash, _ := cmd.Flags().GetString("on-crash")
+			onReboot, _ := cmd.Flags().GetString("on-reboot")
+			if err := virsh.ValidateLifecyclePolicies(onCrash, onReboot); err != nil {
+				return err
+			}
+
+			var bootOrder []string
+			if bootSpec, _ := cmd.Flags().GetString("boot"); bootSpec != "" {
+				if bootOrder, err = virsh.ParseBootOrder(bootSpec); err != nil {
+					return err
+				}
+				if slices.Contains(bootOrder, virsh.BootCDROM) && isoPath == "" {
+					return fmt.Errorf("--boot includes cdrom but no --iso is given")
+				}
+			}
+
+			qcow2Opts, err := qcow2Options(cmd, cfg)
+			if err != nil {
+				return err
+			}
+
+			devices, err := deviceOptions(cmd, cfg)
+			if err != nil {
+				return err
+			}
+			if preset.Tablet && !cmd.Flags().Changed("tablet") {
+				devices.Tablet = true
+			}
+			if devices.Video == "" {
+				devices.Video = preset.Video
+			}
+
 			// Connect to QNAP device
 			sshClient, virshClient, err := connectToQNAP(*cfg)
 			if err != nil {
@@ -179,46 +484,219 @@ func createCmd() *cobra.Command {
 
 			// Check if VM already exists
 			if _, err := virshClient.GetVM(vmName); err == nil {
-				return fmt.Errorf("VM '%s' already exists", vmName)
+				return messages.Errorf(messages.VMExists, vmName)
+			}
+
+			if err := validateHostCPUPins(virshClient, cpuPins, cpus); err != nil {
+				return err
+			}
+
+			if err := validateHostNUMA(virshClient, numa, cpus, memory); err != nil {
+				return err
+			}
+
+			hugePages, _ := cmd.Flags().GetBool("hugepages")
+			if hugePages {
+				reserve, _ := cmd.Flags().GetBool("reserve-hugepages")
+				if err := checkHugePages(virshClient, memory, reserve); err != nil {
+					return err
+				}
+			}
+
+			// Without the NAS's machine types, leave a family name for libvirt to expand
+			if machines, err := virshClient.MachineTypes(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v; not checking the machine type\n", err)
+			} else if machine, err = virsh.ResolveMachine(machines, machine); err != nil {
+				return err
+			}
+
+			if err := checkPassthrough(sshClient, hostDevs); err != nil {
+				return err
+			}
+			if err := checkNetwork(sshClient, virshClient, network); err != nil {
+				return err
+			}
+
+			var ovmf *virsh.OVMF
+			if uefi {
+				if ovmf, err = virshClient.FindOVMF(secureBoot); err != nil {
+					return err
+				}
+			}
+
+			tpm, _ := cmd.Flags().GetBool("tpm")
+			if !cmd.Flags().Changed("tpm") {
+				tpm = preset.TPM
+			}
+			if tpm {
+				if _, err := virshClient.FindSWTPM(); err != nil {
+					return err
+				}
+			}
+
+			var cpuFeatures []string
+			if nested {
+				support, err := enableNested(virshClient)
+				if err != nil {
+					return err
+				}
+				// host-passthrough already includes the virtualization extensions
+				if cpuModel != virsh.CPUModeHostPassthrough {
+					cpuFeatures = []string{support.Feature}
+				}
 			}
 
 			// Detect storage and create disk
-			storageManager := storage.NewManager(sshClient)
-			pool, err := storageManager.GetBestPool()
+			storageManager := newStorageManager(sshClient, cfg)
+			var pool *storage.Pool
+			if poolName != "" {
+				pool, err = storageManager.GetPool(poolName)
+			} else {
+				pool, err = placePool(storageManager, virshClient, placement, tags)
+			}
 			if err != nil {
 				return fmt.Errorf("failed to find storage pool: %w", err)
 			}
 
-			fmt.Printf("Using storage pool: %s (%s)\n", pool.Name, pool.Path)
+			statusf("Using storage pool: %s (%s)\n", pool.Name, pool.Path)
 
-			// Create disk path and image
-			diskPath := storageManager.CreateVMDiskPath(pool, vmName)
-			fmt.Printf("Creating disk image: %s (%s)\n", diskPath, diskSize)
+			// Resolve library ISO names to paths on the device
+			isoPath, err = storageManager.ResolveISOPath(isoPath)
+			if err != nil {
+				return err
+			}
+			var driverISO string
+			if preset.VirtioDrivers {
+				if driverISO, err = virtioDriverISO(cmd, storageManager, pool); err != nil {
+					return err
+				}
+			}
 
-			if err := storageManager.CreateVMDisk(diskPath, diskSize); err != nil {
-				return fmt.Errorf("failed to create disk: %w", err)
+			// Look up iSCSI LUNs before creating anything
+			luns, err := resolveDiskLUNs(storageManager, diskSpecs)
+			if err != nil {
+				return err
+			}
+
+			diskPools, err := resolveDiskPools(storageManager, pool, diskSpecs)
+			if err != nil {
+				return err
+			}
+
+			// Check the disks fit before writing anything
+			var spaceReqs []storage.SpaceRequirement
+			for i, spec := range diskSpecs {
+				if spec.LUN != "" {
+					continue
+				}
+				size, err := storage.SizeBytes(spec.Size)
+				if err != nil {
+					return err
+				}
+				spaceReqs = append(spaceReqs, storage.SpaceRequirement{Pool: diskPools[i], Bytes: size})
+			}
+			if err := checkFreeSpace(cmd, spaceReqs); err != nil {
+				return err
+			}
+
+			// Create disk images
+			disks, err := createVMDisks(storageManager, diskPools, luns, vmName, diskSpecs, qcow2Opts)
+			if err != nil {
+				return err
+			}
+
+			var seedPath string
+			if seed != nil {
+				if seedPath, err = uploadSeed(sshClient, storageManager, pool, vmName, seed); err != nil {
+					return err
+				}
 			}
 
 			// Create VM configuration
 			vmConfig := virsh.VMConfig{
-				Memory:   memory,
-				CPUs:     cpus,
-				DiskSize: diskSize,
-				DiskPath: diskPath,
-				ISOPath:  isoPath,
+				Memory:    memory,
+				CPUs:      cpus,
+				ISOPath:   isoPath,
+				SeedISO:   seedPath,
+				DriverISO: driverISO,
+				Graphics:  graphics,
+
+				Title:     title,
+				Network:   network,
+				NetModel:  netModel,
+				NetQueues: netQueues,
+				MTU:       mtu,
+				MAC:       mac,
+				LocalTime: preset.LocalTime,
+
+				CPUPins:     cpuPins,
+				CPUTopology: topology,
+				CPUModel:    cpuModel,
+				CPUFeatures: cpuFeatures,
+				NUMA:        numa,
+				HugePages:   hugePages,
+				OVMF:        ovmf,
+				TPM:         tpm,
+				Machine:     machine,
+				Boot:        bootOrder,
+
+				HostDevs: hostDevs,
+				Watchdog: watchdog,
+				OnCrash:  onCrash,
+				OnReboot: onReboot,
+
+				Video:  devices.Video,
+				Tablet: devices.Tablet,
+				Sound:  devices.Sound,
+
+				DisableClipboard: noClipboard,
+				DisableRNG:       noRNG,
+				QemuArgs:         qemuArgs,
+			}
+
+			// An image boot disk uses the primary disk fields; a LUN boot disk goes first in Disks
+			if disks[0].Type == virsh.DiskTypeImage {
+				vmConfig.DiskSize = diskSpecs[0].Size
+				vmConfig.DiskPath = disks[0].Path
+				vmConfig.DiskBus = disks[0].Bus
+				vmConfig.DiskOptions = disks[0].Options
+				vmConfig.Disks = disks[1:]
+			} else {
+				vmConfig.Disks = disks
+			}
+
+			if len(qemuArgs) > 0 {
+				printQemuArgsWarning()
 			}
 
-			fmt.Printf("Creating VM '%s' (Memory: %dMB, CPUs: %d)...\n", vmName, memory, cpus)
+			messages.Println(messages.VMCreating, vmName, memory, cpus)
 
 			// Create the VM
-			if err := virshClient.CreateVM(vmName, vmConfig); err != nil {
+			if err := warnQVSRegistration(virshClient.CreateVM(vmName, vmConfig)); err != nil {
 				return fmt.Errorf("failed to create VM: %w", err)
 			}
 
-			fmt.Printf("VM '%s' created successfully!\n", vmName)
-			fmt.Printf("Disk: %s\n", diskPath)
+			if len(tags) > 0 {
+				if err := virshClient.SetTags(vmName, tags); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				}
+			}
+
+			messages.Println(messages.VMCreated, vmName)
+			for _, disk := range disks {
+				statusf("Disk: %s\n", disk.Path)
+			}
 			if isoPath != "" {
-				fmt.Printf("ISO: %s\n", isoPath)
+				statusf("ISO: %s\n", isoPath)
+			}
+			if seedPath != "" {
+				statusf("Cloud-init seed: %s\n", seedPath)
+			}
+			if machine != "" {
+				statusf("Machine type: %s\n", machine)
+			}
+			if preset.GuestAgent != "" {
+				statusf("For shutdown, snapshots and 'qnap-vm file', %s in the guest.\n", preset.GuestAgent)
 			}
 
 			return nil
@@ -228,18 +706,147 @@ func createCmd() *cobra.Command {
 	cmd.Flags().StringP("template", "t", "", "VM template to use")
 	cmd.Flags().StringP("memory", "m", "2048", "Memory size in MB")
 	cmd.Flags().StringP("cpus", "c", "2", "Number of CPU cores")
-	cmd.Flags().StringP("disk", "d", "20G", "Disk size")
-	cmd.Flags().StringP("iso", "i", "", "ISO file path for installation")
+	cmd.Flags().String("cpu-topology", "", "Guest CPU layout, e.g. sockets=1,cores=4,threads=2 (sets --cpus to the product)")
+	cmd.Flags().String("cpu-model", "", "Guest CPU model: host-passthrough, host-model or a QEMU model such as Skylake-Client")
+	cmd.Flags().Bool("hugepages", false, "Back the VM's memory with huge pages for lower memory overhead")
+	cmd.Flags().Bool("reserve-hugepages", false, "With --hugepages, reserve the missing huge pages on the NAS")
+	cmd.Flags().String("numa", "", "Guest NUMA layout and host memory binding, e.g. cells=2,nodeset=0-1[,mode=strict|preferred|interleave]")
+	cmd.Flags().Bool("nested", false, "Let the guest run its own hypervisor, asking to enable nested KVM on the NAS if needed (implies --cpu-model host-passthrough)")
+	cmd.Flags().String("boot", "", "Boot order, e.g. cdrom,hd,network (default: hd)")
+	cmd.Flags().String("machine", "", "QEMU machine type: q35, pc or an exact type such as pc-q35-6.2 (default: newest pc type on the NAS)")
+	cmd.Flags().Bool("uefi", false, "Boot with UEFI firmware (OVMF) instead of BIOS")
+	cmd.Flags().Bool("secure-boot", false, "Boot with Secure Boot UEFI firmware on the q35 chipset (implies --uefi)")
+	cmd.Flags().Bool("tpm", false, "Add an emulated TPM 2.0 (needs swtpm in Virtualization Station), e.g. for Windows 11")
+	cmd.Flags().String("os", "", "Guest OS family for device defaults: linux, windows (SATA disk, e1000 NIC, tablet, local-time clock, virtio-win driver CD) or freebsd")
+	cmd.Flags().String("os-variant", "", "Guest OS release for machine, firmware and device defaults: "+strings.Join(virsh.OSVariantNames(), ", "))
+	cmd.Flags().String("virtio-iso", "", "virtio-win driver ISO for --os windows (library name or path; default: virtio-win.iso, downloaded if missing)")
+	addCPUPinFlags(cmd)
+	cmd.Flags().StringArrayP("disk", "d", []string{"20G"}, "Disk as size=20G[,pool=NAME,bus=virtio,cache=none,io=native,discard=unmap] or lun=NAME[,bus=virtio] (repeatable; first is the boot disk)")
+	cmd.Flags().StringP("iso", "i", "", "ISO for installation (library name or absolute path on the device)")
+	cmd.Flags().String("graphics", "vnc", "Graphics console type (vnc, spice)")
+	addDeviceFlags(cmd)
+	cmd.Flags().Bool("no-clipboard", false, "Disable SPICE clipboard sharing and file transfer")
+	cmd.Flags().Bool("no-rng", false, "Leave out the virtio-rng device that feeds the guest entropy from the NAS")
+	cmd.Flags().StringArray("hostdev", nil, "Pass a host PCI device through, e.g. pci=0000:01:00.0 (repeatable; see 'qnap-vm pci list')")
+	cmd.Flags().String("watchdog", "", "Watchdog device as MODEL[,action=ACTION], e.g. i6300esb,action=reset")
+	cmd.Flags().String("on-crash", "", "What to do when the guest crashes: destroy, restart, preserve, rename-restart, coredump-destroy or coredump-restart")
+	cmd.Flags().String("on-reboot", "", "What to do when the guest reboots: restart or destroy")
+	cmd.Flags().String("title", "", "Human-friendly title shown by list")
+	cmd.Flags().StringSlice("tag", nil, "Tag for grouping VMs (repeatable or comma-separated)")
+	cmd.Flags().String("pool", "", "Storage pool for disks without pool= (default: best available pool)")
+	if err := cmd.RegisterFlagCompletionFunc("pool", completePool); err != nil {
+		// Flag is registered above; registering its completion cannot fail
+	}
+
+	addPlacementFlag(cmd, "How to choose the pool when --pool is not given")
+	cmd.Flags().String("network", virsh.NetworkUser, "Network to connect to: user, bridge=NAME or network=NAME (see 'qnap-vm network list')")
+	cmd.Flags().String("nic-model", "virtio", "Network card model: virtio, or e1000/rtl8139 for guests without virtio drivers")
+	cmd.Flags().String("net-model", "virtio", "Network card model (virtio, e1000, rtl8139)")
+	_ = cmd.Flags().MarkDeprecated("net-model", "use --nic-model")
+	cmd.Flags().Int("mtu", 0, "Network card MTU, e.g. 9000 for jumbo frames (virtio only; default: the network's)")
+	cmd.Flags().Int("net-queues", 0, "virtio multiqueue count (up to the number of CPUs)")
+	cmd.Flags().String("mac", "", "Network card MAC address (default: derived from the VM name)")
+	addCloudInitFlags(cmd)
+	cmd.Flags().StringArray("qemu-arg", nil, "Raw QEMU argument passed through qemu:commandline (repeatable, advanced)")
+	addSpaceCheckFlag(cmd)
+	addQcow2Flags(cmd)
 
 	return cmd
 }
 
+// resolveDiskLUNs looks up the iSCSI LUN of each LUN disk spec, keyed by spec index
+func resolveDiskLUNs(storageManager *storage.Manager, specs []storage.DiskSpec) (map[int]*storage.LUN, error) {
+	luns := make(map[int]*storage.LUN)
+	for i, spec := range specs {
+		if spec.LUN == "" {
+			continue
+		}
+
+		lun, err := storageManager.FindLUN(spec.LUN)
+		if err != nil {
+			return nil, err
+		}
+		if len(lun.Targets) > 0 {
+			fmt.Printf("⚠️  iSCSI LUN '%s' is mapped to %s.\n", lun.Name, strings.Join(lun.Targets, ", "))
+			fmt.Printf("   Disconnect all initiators first; concurrent use will corrupt its data.\n")
+		}
+		luns[i] = lun
+	}
+
+	return luns, nil
+}
+
+// resolveDiskPools returns the pool for each disk spec, using the spec's pool or defaultPool.
+// LUN disks have no pool.
+func resolveDiskPools(storageManager *storage.Manager, defaultPool *storage.Pool, specs []storage.DiskSpec) ([]*storage.Pool, error) {
+	pools := make([]*storage.Pool, len(specs))
+	for i, spec := range specs {
+		if spec.LUN != "" {
+			continue
+		}
+		pools[i] = defaultPool
+		if spec.Pool != "" {
+			pool, err := storageManager.GetPool(spec.Pool)
+			if err != nil {
+				return nil, err
+			}
+			pools[i] = pool
+		}
+	}
+
+	return pools, nil
+}
+
+// diskOptions returns the driver options of a disk spec
+func diskOptions(spec storage.DiskSpec) virsh.DiskOptions {
+	return virsh.DiskOptions{Cache: spec.Cache, IO: spec.IO, Discard: spec.Discard, DetectZeroes: spec.DetectZeroes}
+}
+
+// poolDiskOptions returns opts with TRIM passed through by default when the pool is on
+// SSDs, where freeing unused blocks matters most. Detection failures keep opts as given.
+func poolDiskOptions(storageManager *storage.Manager, pool *storage.Pool, opts virsh.DiskOptions) virsh.DiskOptions {
+	if ssd, err := storageManager.IsSSD(pool); err == nil && ssd {
+		return opts.WithTrim()
+	}
+	return opts
+}
+
+// createVMDisks creates a disk image for each spec in the matching pool, or uses the spec's LUN
+func createVMDisks(storageManager *storage.Manager, pools []*storage.Pool, luns map[int]*storage.LUN, vmName string, specs []storage.DiskSpec, opts storage.Qcow2Options) ([]virsh.VMDisk, error) {
+	disks := make([]virsh.VMDisk, len(specs))
+	for i, spec := range specs {
+		disks[i].Bus = spec.Bus
+		disks[i].Options = diskOptions(spec)
+
+		if lun, ok := luns[i]; ok {
+			disks[i].Path = lun.Device
+			disks[i].Type = virsh.DiskTypeRawFile
+			if lun.Block {
+				disks[i].Type = virsh.DiskTypeBlock
+			}
+			statusf("Using iSCSI LUN: %s (%s)\n", lun.Name, lun.Device)
+			continue
+		}
+
+		disks[i].Path = storageManager.CreateVMDiskPathIndexed(pools[i], vmName, i)
+		disks[i].Options = poolDiskOptions(storageManager, pools[i], disks[i].Options)
+		statusf("Creating disk image: %s (%s)\n", disks[i].Path, spec.Size)
+
+		if err := storageManager.CreateVMDiskWithOptions(disks[i].Path, spec.Size, opts); err != nil {
+			return nil, fmt.Errorf("failed to create disk: %w", err)
+		}
+	}
+
+	return disks, nil
+}
+
 func startCmd() *cobra.Command {
 	return &cobra.Command{
-		Use:   "start [VM_NAME]",
-		Short: "Start a virtual machine",
-		Long:  "Start the specified virtual machine",
-		Args:  cobra.ExactArgs(1),
+		Use:               "start [VM_NAME]",
+		Short:             "Start a virtual machine",
+		Long:              "Start the specified virtual machine",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := loadConfig(cmd)
 			if err != nil {
@@ -249,33 +856,29 @@ func startCmd() *cobra.Command {
 			vmName := args[0]
 
 			// Connect to QNAP device
-			sshClient, virshClient, err := connectToQNAP(*cfg)
+			backend, closeBackend, err := connectBackend(*cfg)
 			if err != nil {
 				return err
 			}
-			defer func() {
-				if err := sshClient.Close(); err != nil {
-					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
-				}
-			}()
+			defer closeBackend()
 
 			// Check if VM exists
-			vm, err := virshClient.GetVM(vmName)
+			vm, err := backend.GetVM(vmName)
 			if err != nil {
-				return fmt.Errorf("VM '%s' not found", vmName)
+				return err
 			}
 
 			if strings.Contains(vm.State, "running") {
-				fmt.Printf("VM '%s' is already running\n", vmName)
+				messages.Println(messages.VMAlreadyRunning, vmName)
 				return nil
 			}
 
-			fmt.Printf("Starting VM '%s'...\n", vmName)
-			if err := virshClient.StartVM(vmName); err != nil {
+			messages.Println(messages.VMStarting, vmName)
+			if err := backend.StartVM(vmName); err != nil {
 				return fmt.Errorf("failed to start VM: %w", err)
 			}
 
-			fmt.Printf("VM '%s' started successfully\n", vmName)
+			messages.Println(messages.VMStarted, vmName)
 			return nil
 		},
 	}
@@ -283,10 +886,11 @@ func startCmd() *cobra.Command {
 
 func stopCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "stop [VM_NAME]",
-		Short: "Stop a virtual machine",
-		Long:  "Stop the specified virtual machine",
-		Args:  cobra.ExactArgs(1),
+		Use:               "stop [VM_NAME]",
+		Short:             "Stop a virtual machine",
+		Long:              "Stop the specified virtual machine",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := loadConfig(cmd)
 			if err != nil {
@@ -297,38 +901,33 @@ func stopCmd() *cobra.Command {
 			force, _ := cmd.Flags().GetBool("force")
 
 			// Connect to QNAP device
-			sshClient, virshClient, err := connectToQNAP(*cfg)
+			backend, closeBackend, err := connectBackend(*cfg)
 			if err != nil {
 				return err
 			}
-			defer func() {
-				if err := sshClient.Close(); err != nil {
-					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
-				}
-			}()
+			defer closeBackend()
 
 			// Check if VM exists
-			vm, err := virshClient.GetVM(vmName)
+			vm, err := backend.GetVM(vmName)
 			if err != nil {
-				return fmt.Errorf("VM '%s' not found", vmName)
+				return err
 			}
 
 			if strings.Contains(vm.State, "shut off") {
-				fmt.Printf("VM '%s' is already stopped\n", vmName)
+				messages.Println(messages.VMAlreadyStopped, vmName)
 				return nil
 			}
 
-			action := "Shutting down"
 			if force {
-				action = "Force stopping"
+				messages.Println(messages.VMForceStopping, vmName)
+			} else {
+				messages.Println(messages.VMShuttingDown, vmName)
 			}
-
-			fmt.Printf("%s VM '%s'...\n", action, vmName)
-			if err := virshClient.StopVM(vmName, force); err != nil {
+			if err := backend.StopVM(vmName, force); err != nil {
 				return fmt.Errorf("failed to stop VM: %w", err)
 			}
 
-			fmt.Printf("VM '%s' stopped successfully\n", vmName)
+			messages.Println(messages.VMStopped, vmName)
 			return nil
 		},
 	}
@@ -338,12 +937,24 @@ func stopCmd() *cobra.Command {
 	return cmd
 }
 
+// warnQVSRegistration prints a failure to update Virtualization Station's VM list as a
+// warning, since the VM itself was created or deleted, and returns any other error
+func warnQVSRegistration(err error) error {
+	var regErr *virsh.QVSRegistrationError
+	if errors.As(err, &regErr) {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", regErr)
+		return nil
+	}
+	return err
+}
+
 func deleteCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "delete [VM_NAME]",
-		Short: "Delete a virtual machine",
-		Long:  "Delete the specified virtual machine and its associated resources",
-		Args:  cobra.ExactArgs(1),
+		Use:               "delete [VM_NAME]",
+		Short:             "Delete a virtual machine",
+		Long:              "Delete the specified virtual machine and its associated resources",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := loadConfig(cmd)
 			if err != nil {
@@ -367,28 +978,28 @@ func deleteCmd() *cobra.Command {
 			// Check if VM exists
 			_, err = virshClient.GetVM(vmName)
 			if err != nil {
-				return fmt.Errorf("VM '%s' not found", vmName)
+				return err
 			}
 
 			// Confirmation unless force is used
 			if !force {
-				fmt.Printf("Are you sure you want to delete VM '%s'? This will permanently delete the VM and its disk. (y/N): ", vmName)
-				var response string
-				if _, err := fmt.Scanln(&response); err != nil {
-					fmt.Fprintf(os.Stderr, "Warning: failed to read input: %v\n", err)
+				confirmed, err := confirm(messages.Sprintf(messages.VMDeleteConfirm, vmName, cfg.Label()))
+				if err != nil {
+					return err
 				}
-				if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
-					fmt.Println("Operation cancelled")
+				if !confirmed {
+					messages.Println(messages.Cancelled)
 					return nil
 				}
 			}
 
-			fmt.Printf("Deleting VM '%s'...\n", vmName)
-			if err := virshClient.DeleteVM(vmName); err != nil {
+			messages.Println(messages.VMDeleting, vmName)
+			if err := warnQVSRegistration(virshClient.DeleteVM(vmName)); err != nil {
 				return fmt.Errorf("failed to delete VM: %w", err)
 			}
 
-			fmt.Printf("VM '%s' deleted successfully\n", vmName)
+			messages.Println(messages.VMDeleted, vmName)
+			statusln("Disk images are kept; run 'qnap-vm storage gc' to delete unused ones.")
 			return nil
 		},
 	}
@@ -402,8 +1013,12 @@ func statusCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "status [VM_NAME]",
 		Short: "Show VM status and resource usage",
-		Long:  "Show detailed status and resource usage for the specified virtual machine",
-		Args:  cobra.ExactArgs(1),
+		Long: `Show detailed status and resource usage for the specified virtual machine.
+
+When the NAS cannot be reached, or with --offline, the status last seen is shown
+from the local cache, marked with its age.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := loadConfig(cmd)
 			if err != nil {
@@ -412,11 +1027,22 @@ func statusCmd() *cobra.Command {
 
 			vmName := args[0]
 
-			// Connect to QNAP device
-			sshClient, virshClient, err := connectToQNAP(*cfg)
+			// Connect to QNAP device, or fall back to the cached inventory
+			sshClient, virshClient, cached, err := connectOrOffline(cmd, cfg)
 			if err != nil {
 				return err
 			}
+			if cached != nil {
+				details := cached.VMDetails(vmName)
+				if details == nil {
+					return cached.show(fmt.Sprintf("status of VM '%s'", vmName), time.Time{})
+				}
+				if err := cached.show(fmt.Sprintf("status of VM '%s'", vmName), details.Updated); err != nil {
+					return err
+				}
+				printVMStatus(&details.VM)
+				return nil
+			}
 			defer func() {
 				if err := sshClient.Close(); err != nil {
 					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
@@ -426,28 +1052,67 @@ func statusCmd() *cobra.Command {
 			// Get detailed VM information
 			vm, err := virshClient.GetVMDetails(vmName)
 			if err != nil {
-				return fmt.Errorf("VM '%s' not found", vmName)
+				return err
 			}
+			if !vm.Transient {
+				if domain, err := virshClient.GetDomain(vmName); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				} else {
+					vm.Recovery = domain.RecoveryPolicy()
+					vm.MACs = domain.MACs()
+				}
+			}
+			updateInventory(cfg, func(inv *inventory.Inventory, now time.Time) {
+				inv.SetDetails(*vm, now)
+			})
 
-			// Display VM status
-			fmt.Printf("VM Status: %s\n", vmName)
-			fmt.Printf("%-15s: %s\n", "State", vm.State)
-			fmt.Printf("%-15s: %s\n", "UUID", vm.UUID)
+			printVMStatus(vm)
+			return nil
+		},
+	}
+}
 
-			if vm.ID > 0 {
-				fmt.Printf("%-15s: %d\n", "ID", vm.ID)
-			}
+// printVMStatus shows a VM's details as key/value lines
+func printVMStatus(vm *virsh.VMInfo) {
+	// Display VM status
+	fmt.Printf("VM Status: %s\n", vm.Name)
+	fmt.Printf("%-15s: %s\n", "State", vm.State)
+	fmt.Printf("%-15s: %s\n", "UUID", vm.UUID)
 
-			if vm.Memory > 0 {
-				fmt.Printf("%-15s: %d MB\n", "Memory", vm.Memory)
-			}
+	persistence := "persistent"
+	if vm.Transient {
+		persistence = "transient (not defined; lost when stopped)"
+	}
+	fmt.Printf("%-15s: %s\n", "Definition", persistence)
 
-			if vm.CPUs > 0 {
-				fmt.Printf("%-15s: %d\n", "CPUs", vm.CPUs)
-			}
+	if vm.ManagedSave {
+		fmt.Printf("%-15s: %s\n", "Managed Save", "yes (memory image restored on next start)")
+	}
 
-			return nil
-		},
+	if vm.ID > 0 {
+		fmt.Printf("%-15s: %d\n", "ID", vm.ID)
+	}
+
+	if vm.Memory > 0 {
+		fmt.Printf("%-15s: %d MB\n", "Memory", vm.Memory)
+	}
+
+	if vm.CPUs > 0 {
+		fmt.Printf("%-15s: %d\n", "CPUs", vm.CPUs)
+	}
+
+	for i, mac := range vm.MACs {
+		fmt.Printf("%-15s: %s\n", fmt.Sprintf("MAC (NIC %d)", i), mac)
+	}
+
+	if recovery := vm.Recovery; recovery != nil {
+		watchdog := "none"
+		if recovery.Watchdog != nil {
+			watchdog = fmt.Sprintf("%s (action %s)", recovery.Watchdog.Model, recovery.Watchdog.Action)
+		}
+		fmt.Printf("%-15s: %s\n", "Watchdog", watchdog)
+		fmt.Printf("%-15s: %s\n", "On Crash", recovery.OnCrash)
+		fmt.Printf("%-15s: %s\n", "On Reboot", recovery.OnReboot)
 	}
 }
 
@@ -496,6 +1161,46 @@ func configCmd() *cobra.Command {
 			if keyfile != "" {
 				newConfig.KeyFile = keyfile
 			}
+			if cmd.Flags().Changed("fingerprint") {
+				newConfig.Fingerprint, _ = cmd.Flags().GetString("fingerprint")
+			}
+			if cmd.Flags().Changed("elevate") {
+				newConfig.Elevate, _ = cmd.Flags().GetString("elevate")
+				if newConfig.Elevate == "none" {
+					newConfig.Elevate = ""
+				}
+			}
+			if cmd.Flags().Changed("backend") {
+				newConfig.Backend, _ = cmd.Flags().GetString("backend")
+			}
+			if cmd.Flags().Changed("api-url") {
+				newConfig.APIURL, _ = cmd.Flags().GetString("api-url")
+			}
+			if cmd.Flags().Changed("api-fingerprint") {
+				newConfig.APIFingerprint, _ = cmd.Flags().GetString("api-fingerprint")
+			}
+			if cmd.Flags().Changed("probe-ports") {
+				value, _ := cmd.Flags().GetString("probe-ports")
+				ports, err := parsePorts(value)
+				if err != nil {
+					return fmt.Errorf("invalid configuration: %w", err)
+				}
+				newConfig.ProbePorts = ports
+			}
+			if cmd.Flags().Changed("elevate-user") {
+				newConfig.ElevateUser, _ = cmd.Flags().GetString("elevate-user")
+			}
+			if err := applyQcow2Defaults(cmd, &newConfig.Qcow2); err != nil {
+				return fmt.Errorf("invalid configuration: %w", err)
+			}
+			if err := applyDeviceDefaults(cmd, &newConfig.Devices); err != nil {
+				return fmt.Errorf("invalid configuration: %w", err)
+			}
+			applyS3Settings(cmd, &newConfig.S3)
+			applyNotifySettings(cmd, &newConfig.Notify)
+			if cmd.Flags().Changed("register-qvs") {
+				newConfig.RegisterQVS, _ = cmd.Flags().GetBool("register-qvs")
+			}
 
 			// Set defaults
 			newConfig.SetDefaults()
@@ -504,6 +1209,9 @@ func configCmd() *cobra.Command {
 			if err := newConfig.Validate(); err != nil {
 				return fmt.Errorf("invalid configuration: %w", err)
 			}
+			if err := applyPassword(cmd, &newConfig); err != nil {
+				return err
+			}
 
 			// Save configuration
 			configFile.SetHostConfig(hostName, newConfig)
@@ -515,7 +1223,7 @@ func configCmd() *cobra.Command {
 				return fmt.Errorf("failed to save config: %w", err)
 			}
 
-			fmt.Printf("Configuration saved for host '%s'\n", hostName)
+			statusf("Configuration saved for host '%s'\n", hostName)
 			return nil
 		},
 	}
@@ -524,7 +1232,40 @@ func configCmd() *cobra.Command {
 	setCmd.Flags().String("username", "", "SSH username")
 	setCmd.Flags().Int("port", 0, "SSH port")
 	setCmd.Flags().String("keyfile", "", "SSH private key file")
+	setCmd.Flags().String("backend", "", "How VMs are managed: ssh (virsh over SSH) or api (the Virtualization Station API)")
+	setCmd.Flags().String("api-url", "", "Virtualization Station address for --backend api (default: https://HOST)")
+	setCmd.Flags().String("api-fingerprint", "", "Expected TLS certificate fingerprint (SHA256:...) of the API ('' removes it)")
+	setCmd.Flags().String("probe-ports", "", "Ports to try when nothing answers on --port, e.g. 2222,22 ('' removes them)")
+	setCmd.Flags().String("fingerprint", "", "Expected SSH host key fingerprint (SHA256:...), checked instead of known_hosts ('' removes it)")
+	setCmd.Flags().String("password", "", "SSH password, stored in the OS keychain ('-' reads it from stdin, '' removes it)")
+	setCmd.Flags().String("elevate", "", "Run commands through sudo or su from an unprivileged SSH account (sudo, su, none)")
+	setCmd.Flags().String("elevate-user", "", "Account to become with --elevate (default: root for sudo, admin for su)")
+	setCmd.Flags().String("elevate-password", "", "Password su asks for, stored in the OS keychain ('-' reads it from stdin)")
+	setCmd.Flags().Bool("store-password-in-file", false, "Store --password and --elevate-password in the config file instead of the OS keychain")
 	setCmd.Flags().String("name", "", "Configuration name (default: 'default')")
+	setCmd.Flags().String("qcow2-cluster-size", "", "Default qcow2 cluster size for new disks (e.g. 2M)")
+	setCmd.Flags().String("qcow2-compression-type", "", "Default qcow2 compression type for new disks (zlib, zstd)")
+	setCmd.Flags().Bool("qcow2-lazy-refcounts", false, "Enable qcow2 lazy refcounts on new disks by default")
+	setCmd.Flags().String("device-video", "", "Default video model for new VMs (virtio, qxl, vga, cirrus, bochs, none)")
+	setCmd.Flags().Bool("device-tablet", false, "Give new VMs a USB tablet by default")
+	setCmd.Flags().String("device-sound", "", "Default sound model for new VMs (ich9, ich6, ac97)")
+	setCmd.Flags().String("s3-endpoint", "", "S3-compatible endpoint URL for backups (default: AWS)")
+	setCmd.Flags().String("s3-region", "", "S3 region for backups (default: us-east-1)")
+	setCmd.Flags().String("s3-access-key", "", "S3 access key ID for backups")
+	setCmd.Flags().String("s3-secret-key", "", "S3 secret access key for backups")
+	setCmd.Flags().Bool("s3-path-style", false, "Use path-style S3 bucket addressing, as MinIO needs")
+	setCmd.Flags().Bool("notify-qts", false, "Send alerts to the QTS system event log, for Notification Center")
+	setCmd.Flags().String("notify-webhook", "", "URL to POST alerts to as JSON ('' removes it)")
+	setCmd.Flags().String("notify-email", "", "Comma-separated addresses to mail alerts to ('' removes them)")
+	setCmd.Flags().String("notify-smtp", "", "SMTP server for --notify-email (host:port)")
+	setCmd.Flags().String("notify-smtp-username", "", "SMTP username, if the server needs one")
+	setCmd.Flags().String("notify-smtp-password", "", "SMTP password, stored in the config file")
+	setCmd.Flags().String("notify-from", "", "Sender address of alert mail (default: the first recipient)")
+	setCmd.Flags().String("notify-events", "", "Comma-separated events to alert on: "+strings.Join(config.NotifyEvents, ", ")+" (default: all)")
+	setCmd.Flags().Bool("register-qvs", false, "Add created VMs to Virtualization Station's database so its web UI lists them (writes to an undocumented database)")
+	if err := setCmd.RegisterFlagCompletionFunc("name", completeHostName); err != nil {
+		// Flag is registered above; registering its completion cannot fail
+	}
 
 	// Config show command
 	showCmd := &cobra.Command{
@@ -537,6 +1278,7 @@ func configCmd() *cobra.Command {
 			}
 
 			hosts := configFile.ListHosts()
+			slices.Sort(hosts)
 			if len(hosts) == 0 {
 				fmt.Println("No configurations found. Use 'qnap-vm config set' to create one.")
 				return nil
@@ -562,7 +1304,36 @@ func configCmd() *cobra.Command {
 		},
 	}
 
-	cmd.AddCommand(setCmd, showCmd)
+	// Config use-host command
+	useHostCmd := &cobra.Command{
+		Use:   "use-host NAME",
+		Short: "Make a named config entry the default host",
+		Long: `Make a named config entry the default host for later commands.
+Use --host-name NAME to select another entry for a single command instead.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeHostName,
+		RunE: func(_ *cobra.Command, args []string) error {
+			hostName := args[0]
+
+			configFile, err := config.LoadConfig()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			if _, exists := configFile.Hosts[hostName]; !exists {
+				return unknownHostError(configFile, hostName)
+			}
+
+			configFile.SetDefaultHost(hostName)
+			if err := config.SaveConfig(configFile); err != nil {
+				return fmt.Errorf("failed to save config: %w", err)
+			}
+
+			statusf("Default host is now '%s'\n", hostName)
+			return nil
+		},
+	}
+
+	cmd.AddCommand(setCmd, showCmd, useHostCmd)
 	return cmd
 }
 
@@ -582,6 +1353,13 @@ func versionCmd() *cobra.Command {
 	}
 }
 
+// useConfigFlag points the config package at the file given with --config, if any
+func useConfigFlag(cmd *cobra.Command) {
+	if path, _ := cmd.Flags().GetString("config"); path != "" {
+		config.SetConfigPath(path)
+	}
+}
+
 func loadConfig(cmd *cobra.Command) (*config.Config, error) {
 	// Load configuration file
 	configFile, err := config.LoadConfig()
@@ -590,10 +1368,28 @@ func loadConfig(cmd *cobra.Command) (*config.Config, error) {
 	}
 
 	// Get host configuration (default or specified)
-	var cfg config.Config
-	if hostConfig, exists := configFile.GetHostConfig(""); exists {
-		cfg = hostConfig
+	var layers []config.Layer
+	selected, _ := cmd.Flags().GetString("host-name")
+	hostName := configFile.ResolveHostName(selected)
+	if hostConfig, exists := configFile.GetHostConfig(hostName); exists {
+		layers = append(layers, config.Layer{Source: config.FileSource(hostName), Config: hostConfig})
+		secrets, err := keychainLayer(hostConfig)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, secrets...)
+	} else if selected != "" {
+		return nil, unknownHostError(configFile, selected)
+	} else {
+		hostName = ""
+	}
+
+	// Environment variables override the file, for CI without a config file
+	envCfg, err := config.FromEnv(os.Getenv)
+	if err != nil {
+		return nil, err
 	}
+	layers = append(layers, config.Layer{Source: config.SourceEnv, Config: envCfg})
 
 	// Override with command line flags
 	flagCfg := config.Config{}
@@ -603,57 +1399,77 @@ func loadConfig(cmd *cobra.Command) (*config.Config, error) {
 	if username, _ := cmd.Flags().GetString("username"); username != "" {
 		flagCfg.Username = username
 	}
-	if port, _ := cmd.Flags().GetInt("port"); port != 22 {
-		flagCfg.Port = port
+	if cmd.Flags().Changed("port") {
+		flagCfg.Port, _ = cmd.Flags().GetInt("port")
 	}
 	if keyfile, _ := cmd.Flags().GetString("keyfile"); keyfile != "" {
 		flagCfg.KeyFile = keyfile
 	}
+	layers = append(layers, config.Layer{Source: config.SourceFlag, Config: flagCfg})
 
-	// Merge configurations (flags override config file)
-	cfg = cfg.MergeWith(flagCfg)
+	// Merge configurations (flags override config file) and set defaults
+	cfg := config.Merge(layers...)
+	cfg.HostName = hostName
 
-	// Set defaults and validate
-	cfg.SetDefaults()
 	if err := cfg.Validate(); err != nil {
-		return nil, fmt.Errorf("configuration validation failed: %w", err)
+		return nil, fmt.Errorf("configuration validation failed: %w\n%s", err, cfg.Describe())
 	}
 
 	return &cfg, nil
 }
 
-// connectToQNAP establishes SSH connection and sets up virsh client
-func connectToQNAP(cfg config.Config) (*ssh.Client, *virsh.Client, error) {
-	// Create SSH client
-	sshCfg := ssh.Config{
-		Host:     cfg.Host,
-		Port:     cfg.Port,
-		Username: cfg.Username,
-		KeyFile:  cfg.KeyFile,
-		Password: cfg.Password,
-		Timeout:  30 * time.Second,
+// loadHostConfig loads a named host entry from the config file, for commands that talk
+// to a second NAS
+func loadHostConfig(hostName string) (*config.Config, error) {
+	configFile, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config file: %w", err)
 	}
 
-	sshClient, err := ssh.NewClient(sshCfg)
+	hostConfig, exists := configFile.GetHostConfig(hostName)
+	if !exists {
+		return nil, unknownHostError(configFile, hostName)
+	}
+
+	secrets, err := keychainLayer(hostConfig)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create SSH client: %w", err)
+		return nil, err
+	}
+	cfg := config.Merge(append([]config.Layer{{Source: config.FileSource(hostName), Config: hostConfig}}, secrets...)...)
+	cfg.HostName = hostName
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("configuration for host '%s' is invalid: %w\n%s", hostName, err, cfg.Describe())
 	}
+	return &cfg, nil
+}
 
-	// Connect to QNAP device
-	if err := sshClient.Connect(); err != nil {
-		return nil, nil, fmt.Errorf("failed to connect to QNAP device: %w", err)
+// unknownHostError reports a host entry name missing from the config file
+func unknownHostError(configFile *config.ConfigFile, hostName string) error {
+	hosts := configFile.ListHosts()
+	if len(hosts) == 0 {
+		return fmt.Errorf("host '%s' not found in config file (add it with 'qnap-vm config set --name %s')", hostName, hostName)
 	}
+	slices.Sort(hosts)
+	return fmt.Errorf("host '%s' not found in config file; configured hosts: %s (add it with 'qnap-vm config set --name %s')", hostName, strings.Join(hosts, ", "), hostName)
+}
 
-	// Test connection
-	if err := sshClient.TestConnection(); err != nil {
-		if closeErr := sshClient.Close(); closeErr != nil {
-			return nil, nil, fmt.Errorf("SSH connection test failed: %w (close error: %v)", err, closeErr)
-		}
-		return nil, nil, fmt.Errorf("SSH connection test failed: %w", err)
+// connectToQNAP establishes SSH connection and sets up virsh client
+func connectToQNAP(cfg config.Config) (*ssh.Client, *virsh.Client, error) {
+	return connectWithTimeout(cfg, 30*time.Second, true)
+}
+
+// connectWithTimeout is connectToQNAP with a limit on establishing the SSH connection.
+// When interactive, a password is asked for on the terminal if no other authentication
+// works, and may then be saved in the keychain.
+func connectWithTimeout(cfg config.Config, timeout time.Duration, interactive bool) (*ssh.Client, *virsh.Client, error) {
+	sshClient, err := connectSSH(cfg, timeout, interactive)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Create virsh client
 	virshClient := virsh.NewClient(sshClient)
+	virshClient.SetQVSRegistration(cfg.RegisterQVS)
 
 	// Initialize virsh environment
 	if err := virshClient.Initialize(); err != nil {
@@ -666,6 +1482,90 @@ func connectToQNAP(cfg config.Config) (*ssh.Client, *virsh.Client, error) {
 	return sshClient, virshClient, nil
 }
 
+// connectSSH opens and tests the SSH connection to the QNAP device without requiring a
+// working Virtualization Station, for commands that inspect the NAS itself
+func connectSSH(cfg config.Config, timeout time.Duration, interactive bool) (*ssh.Client, error) {
+	if usesAPI(&cfg) {
+		return nil, errNeedsSSH(cfg)
+	}
+
+	// Create SSH client
+	sshCfg := ssh.Config{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		Username: cfg.Username,
+		KeyFile:  cfg.KeyFile,
+		Password: cfg.Password,
+		Timeout:  timeout,
+
+		HostKeyFingerprint: cfg.Fingerprint,
+
+		Elevate:         cfg.Elevate,
+		ElevateUser:     cfg.ElevateUser,
+		ElevatePassword: cfg.ElevatePassword,
+	}
+	if cfg.Elevate == ssh.ElevateSudo {
+		// sudo asks for the SSH user's own password
+		sshCfg.ElevatePassword = cfg.Password
+	}
+	sshCfg.StrictHostKey, _ = rootCmd.PersistentFlags().GetBool("strict-host-key")
+	var entered string
+	if interactive {
+		sshCfg.PasswordPrompt = passwordPrompt(cfg.Username, cfg.Host, &entered)
+		sshCfg.HostKeyPrompt = trustHostKey
+	}
+
+	sessionTranscript.Redact(cfg.Password)
+	sessionTranscript.Redact(cfg.ElevatePassword)
+	redactLog(cfg.Password)
+	redactLog(cfg.ElevatePassword)
+	connect := func(port int) (*ssh.Client, error) {
+		sshCfg.Port = port
+		sshClient, err := ssh.NewClient(sshCfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create SSH client: %w\n%s", err, cfg.Describe())
+		}
+		sshClient.SetTranscript(sessionTranscript)
+		sshClient.SetLogger(logger)
+		sshClient.SetContext(commandCtx)
+		if dryRun, _ := rootCmd.PersistentFlags().GetBool("dry-run"); dryRun {
+			sshClient.SetDryRun(os.Stdout)
+		}
+		if err := sshClient.Connect(); err != nil {
+			return nil, messages.Errorf(messages.ConnectFailed, cfg.Label(), err, cfg.Describe())
+		}
+		return sshClient, nil
+	}
+
+	// Connect to QNAP device, trying the host's alternate ports if nothing answers.
+	// Completion skips the probe to stay quick.
+	sshClient, err := connect(cfg.Port)
+	if err != nil && interactive && ssh.IsUnreachable(err) {
+		if port := probeSSHPort(cfg); port != 0 {
+			if sshClient, err = connect(port); err == nil {
+				suggestPort(cfg, port)
+				cfg.Port = port
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Test connection
+	if err := sshClient.TestConnection(); err != nil {
+		if closeErr := sshClient.Close(); closeErr != nil {
+			err = fmt.Errorf("SSH connection test failed: %w (close error: %v)", err, closeErr)
+		} else {
+			err = fmt.Errorf("SSH connection test failed: %w", err)
+		}
+		return nil, messages.Errorf(messages.ConnectFailed, cfg.Label(), err, cfg.Describe())
+	}
+	offerToSavePassword(cfg, entered)
+
+	return sshClient, nil
+}
+
 func snapshotCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "snapshot",
@@ -677,8 +1577,14 @@ func snapshotCmd() *cobra.Command {
 	createSnapshotCmd := &cobra.Command{
 		Use:   "create [VM_NAME] [SNAPSHOT_NAME]",
 		Short: "Create a VM snapshot",
-		Long:  "Create a snapshot of the specified virtual machine",
-		Args:  cobra.ExactArgs(2),
+		Long: `Create a snapshot of the specified virtual machine.
+
+VMs whose disks live in their own ZFS dataset (created for VMs on ZFS pools)
+get a near-instant ZFS snapshot of the dataset instead of qcow2 internal
+snapshots; a running guest's filesystems are frozen through the guest agent
+while it is taken. Use --internal to create a qcow2 snapshot anyway.`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeVM,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := loadConfig(cmd)
 			if err != nil {
@@ -688,6 +1594,9 @@ func snapshotCmd() *cobra.Command {
 			vmName := args[0]
 			snapshotName := args[1]
 			description, _ := cmd.Flags().GetString("description")
+			if usesAPI(cfg) {
+				return createSnapshotAPI(cfg, vmName, snapshotName, description)
+			}
 
 			// Connect to QNAP device
 			sshClient, virshClient, err := connectToQNAP(*cfg)
@@ -701,18 +1610,41 @@ func snapshotCmd() *cobra.Command {
 			}()
 
 			// Check if VM exists
-			if _, err := virshClient.GetVM(vmName); err != nil {
-				return fmt.Errorf("VM '%s' not found", vmName)
+			vm, err := virshClient.GetVM(vmName)
+			if err != nil {
+				return err
+			}
+
+			// VMs with their own ZFS dataset get an instant ZFS snapshot
+			storageManager := newStorageManager(sshClient, cfg)
+			if internal, _ := cmd.Flags().GetBool("internal"); !internal {
+				if dataset := vmDataset(storageManager, virshClient, vmName); dataset != "" {
+					statusf("Creating ZFS snapshot '%s' of dataset %s for VM '%s'...\n", snapshotName, dataset, vmName)
+					if err := snapshotVMDataset(storageManager, virshClient, vm, dataset, snapshotName, description); err != nil {
+						return fmt.Errorf("failed to create snapshot: %w", err)
+					}
+					messages.Println(messages.SnapshotCreated, snapshotName)
+					return nil
+				}
 			}
 
-			fmt.Printf("Creating snapshot '%s' for VM '%s'...\n", snapshotName, vmName)
+			proceed, err := checkSnapshotSpace(cmd, storageManager, virshClient, vmName)
+			if err != nil {
+				return err
+			}
+			if !proceed {
+				messages.Println(messages.Cancelled)
+				return nil
+			}
+
+			statusf("Creating snapshot '%s' for VM '%s'...\n", snapshotName, vmName)
 			if err := virshClient.CreateSnapshot(vmName, snapshotName, description); err != nil {
 				return fmt.Errorf("failed to create snapshot: %w", err)
 			}
 
-			fmt.Printf("Snapshot '%s' created successfully\n", snapshotName)
+			messages.Println(messages.SnapshotCreated, snapshotName)
 			if description != "" {
-				fmt.Printf("Description: %s\n", description)
+				statusf("Description: %s\n", description)
 			}
 
 			return nil
@@ -720,13 +1652,20 @@ func snapshotCmd() *cobra.Command {
 	}
 
 	createSnapshotCmd.Flags().StringP("description", "d", "", "Snapshot description")
+	createSnapshotCmd.Flags().Bool("estimate", false, "Estimate the snapshot's space and time from the disk images and confirm before creating it")
+	createSnapshotCmd.Flags().Bool("internal", false, "Create a qcow2 internal snapshot even when the VM has its own ZFS dataset")
+	addSpaceCheckFlag(createSnapshotCmd)
 
 	// Snapshot list command
 	listSnapshotCmd := &cobra.Command{
 		Use:   "list [VM_NAME]",
 		Short: "List VM snapshots",
-		Long:  "List all snapshots for the specified virtual machine",
-		Args:  cobra.ExactArgs(1),
+		Long: `List all snapshots for the specified virtual machine.
+
+When the NAS cannot be reached, or with --offline, the snapshots last seen are
+shown from the local cache, marked with their age.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := loadConfig(cmd)
 			if err != nil {
@@ -734,12 +1673,26 @@ func snapshotCmd() *cobra.Command {
 			}
 
 			vmName := args[0]
+			if offline, _ := cmd.Flags().GetBool("offline"); usesAPI(cfg) && !offline {
+				return listSnapshotsAPI(cfg, vmName)
+			}
 
-			// Connect to QNAP device
-			sshClient, virshClient, err := connectToQNAP(*cfg)
+			// Connect to QNAP device, or fall back to the cached inventory
+			sshClient, virshClient, cached, err := connectOrOffline(cmd, cfg)
 			if err != nil {
 				return err
 			}
+			if cached != nil {
+				listing := cached.Snapshots[vmName]
+				if listing == nil {
+					return cached.show(fmt.Sprintf("snapshots of VM '%s'", vmName), time.Time{})
+				}
+				if err := cached.show(fmt.Sprintf("snapshots of VM '%s'", vmName), listing.Updated); err != nil {
+					return err
+				}
+				printSnapshotList(vmName, listing)
+				return nil
+			}
 			defer func() {
 				if err := sshClient.Close(); err != nil {
 					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
@@ -748,7 +1701,7 @@ func snapshotCmd() *cobra.Command {
 
 			// Check if VM exists
 			if _, err := virshClient.GetVM(vmName); err != nil {
-				return fmt.Errorf("VM '%s' not found", vmName)
+				return err
 			}
 
 			// List snapshots
@@ -757,49 +1710,43 @@ func snapshotCmd() *cobra.Command {
 				return fmt.Errorf("failed to list snapshots: %w", err)
 			}
 
-			if len(snapshots) == 0 {
-				fmt.Printf("No snapshots found for VM '%s'\n", vmName)
-				return nil
-			}
-
-			// Get current snapshot
-			currentSnapshot, _ := virshClient.GetCurrentSnapshot(vmName)
-
-			// Display snapshots in table format
-			fmt.Printf("Snapshots for VM '%s':\n\n", vmName)
-			fmt.Printf("%-20s %-25s %-12s %-8s %-50s\n", "NAME", "CREATION TIME", "STATE", "CURRENT", "DESCRIPTION")
-			fmt.Printf("%-20s %-25s %-12s %-8s %-50s\n", "--------------------", "-------------------------", "------------", "--------", "--------------------------------------------------")
-
-			for _, snapshot := range snapshots {
-				currentStr := ""
-				if snapshot.Name == currentSnapshot {
-					currentStr = "✓"
+			storageManager := newStorageManager(sshClient, cfg)
+			var datasetSnapshots []storage.DatasetSnapshot
+			if dataset := vmDataset(storageManager, virshClient, vmName); dataset != "" {
+				datasetSnapshots, err = storageManager.ListDatasetSnapshots(dataset)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
 				}
+			}
 
+			listing := inventory.SnapshotList{Updated: time.Now(), Dataset: datasetSnapshots}
+			if len(snapshots) > 0 {
+				// Get current snapshot
+				listing.Current, _ = virshClient.GetCurrentSnapshot(vmName)
+			}
+			for _, snapshot := range snapshots {
 				// Get detailed info for description
 				if detailed, err := virshClient.GetSnapshotInfo(vmName, snapshot.Name); err == nil {
 					snapshot = *detailed
 				}
-
-				description := snapshot.Description
-				if len(description) > 50 {
-					description = description[:47] + "..."
-				}
-
-				fmt.Printf("%-20s %-25s %-12s %-8s %-50s\n",
-					snapshot.Name, snapshot.CreationTime, snapshot.State, currentStr, description)
+				listing.Snapshots = append(listing.Snapshots, snapshot)
 			}
+			updateInventory(cfg, func(inv *inventory.Inventory, _ time.Time) {
+				inv.SetSnapshots(vmName, listing)
+			})
 
+			printSnapshotList(vmName, &listing)
 			return nil
 		},
 	}
 
 	// Snapshot restore command
 	restoreSnapshotCmd := &cobra.Command{
-		Use:   "restore [VM_NAME] [SNAPSHOT_NAME]",
-		Short: "Restore VM to snapshot",
-		Long:  "Restore the specified virtual machine to a snapshot state",
-		Args:  cobra.ExactArgs(2),
+		Use:               "restore [VM_NAME] [SNAPSHOT_NAME]",
+		Short:             "Restore VM to snapshot",
+		Long:              "Restore the specified virtual machine to a snapshot state",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeVMSnapshot,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := loadConfig(cmd)
 			if err != nil {
@@ -809,6 +1756,9 @@ func snapshotCmd() *cobra.Command {
 			vmName := args[0]
 			snapshotName := args[1]
 			force, _ := cmd.Flags().GetBool("force")
+			if usesAPI(cfg) {
+				return restoreSnapshotAPI(cfg, vmName, snapshotName, force)
+			}
 
 			// Connect to QNAP device
 			sshClient, virshClient, err := connectToQNAP(*cfg)
@@ -822,34 +1772,38 @@ func snapshotCmd() *cobra.Command {
 			}()
 
 			// Check if VM and snapshot exist
-			if _, err := virshClient.GetVM(vmName); err != nil {
-				return fmt.Errorf("VM '%s' not found", vmName)
+			vm, err := virshClient.GetVM(vmName)
+			if err != nil {
+				return err
 			}
 
 			if _, err := virshClient.GetSnapshotInfo(vmName, snapshotName); err != nil {
+				storageManager := newStorageManager(sshClient, cfg)
+				if dataset := vmDataset(storageManager, virshClient, vmName); dataset != "" {
+					return rollbackVMDataset(storageManager, cfg, vm, dataset, snapshotName, force)
+				}
 				return fmt.Errorf("snapshot '%s' not found for VM '%s'", snapshotName, vmName)
 			}
 
 			// Confirmation unless force is used
 			if !force {
-				fmt.Printf("⚠️  WARNING: Restoring VM '%s' to snapshot '%s' will lose all changes made after the snapshot.\n", vmName, snapshotName)
-				fmt.Print("Are you sure you want to continue? (y/N): ")
-				var response string
-				if _, err := fmt.Scanln(&response); err != nil {
-					fmt.Fprintf(os.Stderr, "Warning: failed to read input: %v\n", err)
-				}
-				if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
-					fmt.Println("Operation cancelled")
+				fmt.Printf("⚠️  WARNING: Restoring VM '%s' on %s to snapshot '%s' will lose all changes made after the snapshot.\n", vmName, cfg.Label(), snapshotName)
+				confirmed, err := confirm("Are you sure you want to continue? (y/N): ")
+				if err != nil {
+					return err
+				}
+				if !confirmed {
+					messages.Println(messages.Cancelled)
 					return nil
 				}
 			}
 
-			fmt.Printf("Restoring VM '%s' to snapshot '%s'...\n", vmName, snapshotName)
+			statusf("Restoring VM '%s' to snapshot '%s'...\n", vmName, snapshotName)
 			if err := virshClient.RestoreSnapshot(vmName, snapshotName); err != nil {
 				return fmt.Errorf("failed to restore snapshot: %w", err)
 			}
 
-			fmt.Printf("VM '%s' restored to snapshot '%s' successfully\n", vmName, snapshotName)
+			statusf("VM '%s' restored to snapshot '%s' successfully\n", vmName, snapshotName)
 			return nil
 		},
 	}
@@ -858,10 +1812,11 @@ func snapshotCmd() *cobra.Command {
 
 	// Snapshot delete command
 	deleteSnapshotCmd := &cobra.Command{
-		Use:   "delete [VM_NAME] [SNAPSHOT_NAME]",
-		Short: "Delete a VM snapshot",
-		Long:  "Delete the specified snapshot from a virtual machine",
-		Args:  cobra.ExactArgs(2),
+		Use:               "delete [VM_NAME] [SNAPSHOT_NAME]",
+		Short:             "Delete a VM snapshot",
+		Long:              "Delete the specified snapshot from a virtual machine",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeVMSnapshot,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := loadConfig(cmd)
 			if err != nil {
@@ -871,6 +1826,18 @@ func snapshotCmd() *cobra.Command {
 			vmName := args[0]
 			snapshotName := args[1]
 			force, _ := cmd.Flags().GetBool("force")
+			children, _ := cmd.Flags().GetBool("children")
+			childrenOnly, _ := cmd.Flags().GetBool("children-only")
+
+			if children && childrenOnly {
+				return fmt.Errorf("--children and --children-only cannot be used together")
+			}
+			if usesAPI(cfg) {
+				if children || childrenOnly {
+					return fmt.Errorf("--children and --children-only need the ssh backend")
+				}
+				return deleteSnapshotAPI(cfg, vmName, snapshotName, force)
+			}
 
 			// Connect to QNAP device
 			sshClient, virshClient, err := connectToQNAP(*cfg)
@@ -885,44 +1852,75 @@ func snapshotCmd() *cobra.Command {
 
 			// Check if VM and snapshot exist
 			if _, err := virshClient.GetVM(vmName); err != nil {
-				return fmt.Errorf("VM '%s' not found", vmName)
+				return err
 			}
 
 			if _, err := virshClient.GetSnapshotInfo(vmName, snapshotName); err != nil {
+				storageManager := newStorageManager(sshClient, cfg)
+				if dataset := vmDataset(storageManager, virshClient, vmName); dataset != "" {
+					if children || childrenOnly {
+						return fmt.Errorf("--children and --children-only do not apply to ZFS snapshots")
+					}
+					return destroyVMDatasetSnapshot(storageManager, cfg, vmName, dataset, snapshotName, force)
+				}
 				return fmt.Errorf("snapshot '%s' not found for VM '%s'", snapshotName, vmName)
 			}
 
+			// Warn about snapshots and linked clones that depend on this one
+			descendants, err := virshClient.SnapshotDescendants(vmName, snapshotName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+			if childrenOnly && len(descendants) == 0 {
+				statusf("Snapshot '%s' has no children; nothing to delete\n", snapshotName)
+				return nil
+			}
+			warnSnapshotDependents(newStorageManager(sshClient, cfg), virshClient, vmName, snapshotName, descendants, children || childrenOnly)
+
 			// Confirmation unless force is used
 			if !force {
-				fmt.Printf("Are you sure you want to delete snapshot '%s' from VM '%s'? (y/N): ", snapshotName, vmName)
-				var response string
-				if _, err := fmt.Scanln(&response); err != nil {
-					fmt.Fprintf(os.Stderr, "Warning: failed to read input: %v\n", err)
+				target := fmt.Sprintf("snapshot '%s'", snapshotName)
+				if children {
+					target = fmt.Sprintf("snapshot '%s' and its %d descendant(s)", snapshotName, len(descendants))
+				} else if childrenOnly {
+					target = fmt.Sprintf("the %d descendant(s) of snapshot '%s'", len(descendants), snapshotName)
 				}
-				if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
-					fmt.Println("Operation cancelled")
+				confirmed, err := confirm(fmt.Sprintf("Are you sure you want to delete %s from VM '%s' on %s? (y/N): ", target, vmName, cfg.Label()))
+				if err != nil {
+					return err
+				}
+				if !confirmed {
+					messages.Println(messages.Cancelled)
 					return nil
 				}
 			}
 
-			fmt.Printf("Deleting snapshot '%s' from VM '%s'...\n", snapshotName, vmName)
-			if err := virshClient.DeleteSnapshot(vmName, snapshotName); err != nil {
+			statusf("Deleting snapshot '%s' from VM '%s'...\n", snapshotName, vmName)
+			opts := virsh.SnapshotDeleteOptions{Children: children, ChildrenOnly: childrenOnly}
+			if err := virshClient.DeleteSnapshotTree(vmName, snapshotName, opts); err != nil {
 				return fmt.Errorf("failed to delete snapshot: %w", err)
 			}
 
-			fmt.Printf("Snapshot '%s' deleted successfully\n", snapshotName)
+			if childrenOnly {
+				statusf("Children of snapshot '%s' deleted successfully\n", snapshotName)
+			} else {
+				messages.Println(messages.SnapshotDeleted, snapshotName)
+			}
 			return nil
 		},
 	}
 
 	deleteSnapshotCmd.Flags().BoolP("force", "f", false, "Force delete without confirmation")
+	deleteSnapshotCmd.Flags().Bool("children", false, "Also delete all snapshots descending from this one")
+	deleteSnapshotCmd.Flags().Bool("children-only", false, "Delete only the snapshots descending from this one")
 
 	// Snapshot current command
 	currentSnapshotCmd := &cobra.Command{
-		Use:   "current [VM_NAME]",
-		Short: "Show current snapshot",
-		Long:  "Show the current snapshot for the specified virtual machine",
-		Args:  cobra.ExactArgs(1),
+		Use:               "current [VM_NAME]",
+		Short:             "Show current snapshot",
+		Long:              "Show the current snapshot for the specified virtual machine",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := loadConfig(cmd)
 			if err != nil {
@@ -944,7 +1942,7 @@ func snapshotCmd() *cobra.Command {
 
 			// Check if VM exists
 			if _, err := virshClient.GetVM(vmName); err != nil {
-				return fmt.Errorf("VM '%s' not found", vmName)
+				return err
 			}
 
 			// Get current snapshot
@@ -977,16 +1975,55 @@ func snapshotCmd() *cobra.Command {
 		},
 	}
 
-	cmd.AddCommand(createSnapshotCmd, listSnapshotCmd, restoreSnapshotCmd, deleteSnapshotCmd, currentSnapshotCmd)
+	cmd.AddCommand(createSnapshotCmd, createAllSnapshotCmd(), listSnapshotCmd, restoreSnapshotCmd, deleteSnapshotCmd, currentSnapshotCmd)
 	return cmd
 }
 
+// printSnapshotList shows a VM's libvirt and ZFS dataset snapshots as a table
+func printSnapshotList(vmName string, listing *inventory.SnapshotList) {
+	if len(listing.Snapshots) == 0 && len(listing.Dataset) == 0 {
+		fmt.Printf("No snapshots found for VM '%s'\n", vmName)
+		return
+	}
+
+	// Display snapshots in table format
+	fmt.Printf("Snapshots for VM '%s':\n\n", vmName)
+	fmt.Printf("%-20s %-25s %-12s %-8s %-50s\n", "NAME", "CREATION TIME", "STATE", "CURRENT", "DESCRIPTION")
+	fmt.Printf("%-20s %-25s %-12s %-8s %-50s\n", "--------------------", "-------------------------", "------------", "--------", "--------------------------------------------------")
+
+	for _, snapshot := range listing.Snapshots {
+		currentStr := ""
+		if snapshot.Name == listing.Current {
+			currentStr = "✓"
+		}
+
+		description := snapshot.Description
+		if len(description) > 50 {
+			description = description[:47] + "..."
+		}
+
+		fmt.Printf("%-20s %-25s %-12s %-8s %-50s\n",
+			snapshot.Name, snapshot.CreationTime, snapshot.State, currentStr, description)
+	}
+
+	for _, snapshot := range listing.Dataset {
+		description := snapshot.Description
+		if len(description) > 50 {
+			description = description[:47] + "..."
+		}
+
+		fmt.Printf("%-20s %-25s %-12s %-8s %-50s\n",
+			snapshot.Name, snapshot.Created.Format("2006-01-02 15:04:05 -0700"), "zfs", "", description)
+	}
+}
+
 func statsCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "stats [VM_NAME]",
-		Short: "Show VM resource statistics",
-		Long:  "Show detailed resource usage statistics for the specified virtual machine",
-		Args:  cobra.ExactArgs(1),
+		Use:               "stats [VM_NAME]",
+		Short:             "Show VM resource statistics",
+		Long:              "Show detailed resource usage statistics for the specified virtual machine",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := loadConfig(cmd)
 			if err != nil {
@@ -1011,7 +2048,7 @@ func statsCmd() *cobra.Command {
 			// Check if VM exists and is running
 			vm, err := virshClient.GetVM(vmName)
 			if err != nil {
-				return fmt.Errorf("VM '%s' not found", vmName)
+				return err
 			}
 
 			if !strings.Contains(vm.State, "running") {
@@ -1101,10 +2138,11 @@ func formatBytes(bytes int64) string {
 
 func cloneCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "clone [SOURCE_VM] [TARGET_VM]",
-		Short: "Clone a virtual machine",
-		Long:  "Clone an existing virtual machine to create a new VM with the same configuration",
-		Args:  cobra.ExactArgs(2),
+		Use:               "clone [SOURCE_VM] [TARGET_VM]",
+		Short:             "Clone a virtual machine",
+		Long:              "Clone an existing virtual machine to create a new VM with the same configuration",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeVM,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := loadConfig(cmd)
 			if err != nil {
@@ -1114,6 +2152,18 @@ func cloneCmd() *cobra.Command {
 			sourceVM := args[0]
 			targetVM := args[1]
 			linkedClone, _ := cmd.Flags().GetBool("linked")
+			poolName, _ := cmd.Flags().GetString("pool")
+
+			placement, _ := cmd.Flags().GetString("placement")
+
+			if linkedClone && (poolName != "" || placement != "") {
+				return fmt.Errorf("--pool and --placement cannot be used with --linked clones")
+			}
+			if placement != "" {
+				if err := storage.ValidatePlacement(placement); err != nil {
+					return err
+				}
+			}
 
 			// Connect to QNAP device
 			sshClient, virshClient, err := connectToQNAP(*cfg)
@@ -1134,7 +2184,41 @@ func cloneCmd() *cobra.Command {
 
 			// Check if target VM already exists
 			if _, err := virshClient.GetVM(targetVM); err == nil {
-				return fmt.Errorf("target VM '%s' already exists", targetVM)
+				return messages.Errorf(messages.VMExists, targetVM)
+			}
+
+			// A placement strategy picks the pool as for a new VM with the source's tags
+			storageManager := newStorageManager(sshClient, cfg)
+			if placement != "" && poolName == "" {
+				tags, err := virshClient.GetTags(sourceVM)
+				if err != nil {
+					return err
+				}
+				pool, err := placePool(storageManager, virshClient, placement, tags)
+				if err != nil {
+					return fmt.Errorf("failed to find storage pool: %w", err)
+				}
+				poolName = pool.Name
+				statusf("Placing clone on pool %s (%s placement)\n", pool.Name, placement)
+			}
+
+			// VMs with their own ZFS dataset are cloned with ZFS unless another pool is requested
+			if fullCopy, _ := cmd.Flags().GetBool("full-copy"); !fullCopy && poolName == "" {
+				if dataset := vmDataset(storageManager, virshClient, sourceVM); dataset != "" {
+					statusf("Cloning VM '%s' to '%s' (ZFS clone of %s)...\n", sourceVM, targetVM, dataset)
+					if err := cloneVMDataset(storageManager, virshClient, sourceVMInfo, dataset, targetVM); err != nil {
+						return err
+					}
+					statusf("VM '%s' cloned successfully to '%s'\n", sourceVM, targetVM)
+					return nil
+				}
+			}
+
+			// Linked clones only add a small overlay; full clones copy every disk
+			if !linkedClone {
+				if err := checkCloneSpace(cmd, storageManager, virshClient, sourceVM, poolName); err != nil {
+					return err
+				}
 			}
 
 			cloneType := "full"
@@ -1142,23 +2226,27 @@ func cloneCmd() *cobra.Command {
 				cloneType = "linked"
 			}
 
-			fmt.Printf("Cloning VM '%s' to '%s' (%s clone)...\n", sourceVM, targetVM, cloneType)
-			fmt.Printf("Source VM state: %s\n", sourceVMInfo.State)
+			statusf("Cloning VM '%s' to '%s' (%s clone)...\n", sourceVM, targetVM, cloneType)
+			statusf("Source VM state: %s\n", sourceVMInfo.State)
 
-			if err := virshClient.CloneVM(sourceVM, targetVM, linkedClone); err != nil {
+			if poolName != "" {
+				if err := cloneVMToPool(storageManager, virshClient, sourceVM, targetVM, poolName); err != nil {
+					return err
+				}
+			} else if err := warnQVSRegistration(virshClient.CloneVM(sourceVM, targetVM, linkedClone)); err != nil {
 				return fmt.Errorf("failed to clone VM: %w", err)
 			}
 
-			fmt.Printf("VM '%s' cloned successfully to '%s'\n", sourceVM, targetVM)
+			statusf("VM '%s' cloned successfully to '%s'\n", sourceVM, targetVM)
 
 			// Show the new VM info
 			if newVM, err := virshClient.GetVMDetails(targetVM); err == nil {
-				fmt.Printf("New VM details:\n")
-				fmt.Printf("  Name: %s\n", newVM.Name)
-				fmt.Printf("  State: %s\n", newVM.State)
-				fmt.Printf("  Memory: %d MB\n", newVM.Memory)
-				fmt.Printf("  CPUs: %d\n", newVM.CPUs)
-				fmt.Printf("  UUID: %s\n", newVM.UUID)
+				statusf("New VM details:\n")
+				statusf("  Name: %s\n", newVM.Name)
+				statusf("  State: %s\n", newVM.State)
+				statusf("  Memory: %d MB\n", newVM.Memory)
+				statusf("  CPUs: %d\n", newVM.CPUs)
+				statusf("  UUID: %s\n", newVM.UUID)
 			}
 
 			return nil
@@ -1166,16 +2254,373 @@ func cloneCmd() *cobra.Command {
 	}
 
 	cmd.Flags().BoolP("linked", "l", false, "Create a linked clone (space-efficient)")
+	cmd.Flags().String("pool", "", "Storage pool for the cloned disks (default: alongside the source disks)")
+	if err := cmd.RegisterFlagCompletionFunc("pool", completePool); err != nil {
+		// Flag is registered above; registering its completion cannot fail
+	}
+
+	addPlacementFlag(cmd, "Choose the pool for the cloned disks instead of keeping them alongside the source")
+	cmd.Flags().Bool("full-copy", false, "Copy the disks even when the source VM has its own ZFS dataset")
+	addSpaceCheckFlag(cmd)
 
 	return cmd
 }
 
+// warnSnapshotDependents prints what depends on a snapshot before it is deleted
+func warnSnapshotDependents(storageManager *storage.Manager, virshClient *virsh.Client, vmName, snapshotName string, descendants []string, deletingChildren bool) {
+	if len(descendants) > 0 && !deletingChildren {
+		fmt.Printf("⚠️  Snapshot '%s' has %d child snapshot(s): %s\n", snapshotName, len(descendants), strings.Join(descendants, ", "))
+		fmt.Printf("   They will be re-parented. Use --children to delete them too.\n")
+	}
+
+	disks, err := virshClient.ListDisks(vmName)
+	if err != nil {
+		return
+	}
+	var diskPaths []string
+	for _, disk := range disks {
+		diskPaths = append(diskPaths, disk.Source.File)
+	}
+
+	dependents, err := storageManager.FindBackingDependents(diskPaths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not check for linked clones: %v\n", err)
+		return
+	}
+	for base, images := range dependents {
+		fmt.Printf("⚠️  Linked clone disk(s) use %s as their backing file:\n", base)
+		for _, image := range images {
+			fmt.Printf("   - %s\n", image)
+		}
+		fmt.Printf("   Deleting snapshots rewrites this image; verify the clones afterwards.\n")
+	}
+}
+
+// checkCloneSpace verifies a full clone of sourceVM fits in the named pool, or next to the source disks
+func checkCloneSpace(cmd *cobra.Command, storageManager *storage.Manager, virshClient *virsh.Client, sourceVM, poolName string) error {
+	var pool *storage.Pool
+	if poolName != "" {
+		var err error
+		if pool, err = storageManager.GetPool(poolName); err != nil {
+			return err
+		}
+	}
+
+	disks, err := virshClient.ListDisks(sourceVM)
+	if err != nil {
+		return err
+	}
+	var diskPaths []string
+	for _, disk := range disks {
+		diskPaths = append(diskPaths, disk.Source.File)
+	}
+
+	reqs, err := diskSpaceRequirements(storageManager, diskPaths, pool)
+	if err != nil {
+		return err
+	}
+	return checkFreeSpace(cmd, reqs)
+}
+
+// snapshotMetadataBytes allows for the snapshot table and L1 table copies an internal snapshot writes
+const snapshotMetadataBytes = 64 << 20
+
+// checkSnapshotSpace verifies the boot disk's pool can hold an internal snapshot,
+// including the memory state saved for a running VM. With --estimate it measures the
+// disks, prints the estimate and asks before proceeding; it returns false if the user declines.
+func checkSnapshotSpace(cmd *cobra.Command, storageManager *storage.Manager, virshClient *virsh.Client, vmName string) (bool, error) {
+	estimate, _ := cmd.Flags().GetBool("estimate")
+
+	vm, err := virshClient.GetVMDetails(vmName)
+	if err != nil {
+		return false, err
+	}
+
+	disks, err := virshClient.ListDisks(vmName)
+	if err != nil || len(disks) == 0 {
+		// Nothing to measure; virsh reports the snapshot failure itself
+		return true, nil
+	}
+
+	pools, err := storageManager.DetectPools()
+	if err != nil {
+		return false, fmt.Errorf("failed to detect storage pools: %w", err)
+	}
+	pool := storage.PoolForPath(pools, disks[0].Source.File)
+
+	var memory int64
+	if strings.Contains(vm.State, "running") {
+		memory = int64(vm.Memory) << 20
+	}
+
+	required := snapshotMetadataBytes + memory
+	if estimate {
+		est, err := estimateSnapshot(storageManager, disks, memory)
+		if err != nil {
+			return false, err
+		}
+		printSnapshotEstimate(vmName, est, pool)
+		required = est.Immediate()
+	}
+
+	if err := checkFreeSpace(cmd, []storage.SpaceRequirement{{Pool: pool, Bytes: required}}); err != nil {
+		return false, err
+	}
+	if !estimate {
+		return true, nil
+	}
+
+	return confirm("Create the snapshot? (y/N): ")
+}
+
+// pathWithin returns file relative to dir, and false when file is not inside dir.
+// NAS paths are always POSIX, so this avoids filepath.
+func pathWithin(dir, file string) (string, bool) {
+	rel := strings.TrimPrefix(file, strings.TrimSuffix(dir, "/")+"/")
+	return rel, rel != file
+}
+
+// vmDataset returns the per-VM ZFS dataset holding all of a VM's disk images, or "" when
+// the VM should use qcow2 internal snapshots
+func vmDataset(storageManager *storage.Manager, virshClient *virsh.Client, vmName string) string {
+	disks, err := virshClient.ListDisks(vmName)
+	if err != nil || len(disks) == 0 {
+		return ""
+	}
+
+	var diskPaths []string
+	for _, disk := range disks {
+		if disk.Source.File == "" {
+			// Block devices such as LUNs are outside any dataset
+			return ""
+		}
+		diskPaths = append(diskPaths, disk.Source.File)
+	}
+
+	dataset, err := storageManager.VMDatasetForDisks(diskPaths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return ""
+	}
+	return dataset
+}
+
+// snapshotVMDataset takes a ZFS snapshot of a VM's dataset, freezing a running guest's
+// filesystems through the guest agent so the snapshot is consistent
+func snapshotVMDataset(storageManager *storage.Manager, virshClient *virsh.Client, vm *virsh.VMInfo, dataset, snapshotName, description string) error {
+	if !storage.ValidDatasetSnapshotName(snapshotName) {
+		return fmt.Errorf("invalid ZFS snapshot name '%s' (use letters, digits, '_', '-', '.' and ':')", snapshotName)
+	}
+
+	if strings.Contains(vm.State, "running") {
+		if err := virshClient.GuestFSFreeze(vm.Name); err != nil {
+			statusln("Guest agent unavailable; the snapshot will be crash-consistent")
+		} else {
+			defer func() {
+				if err := virshClient.GuestFSThaw(vm.Name); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to thaw guest filesystems: %v\n", err)
+				}
+			}()
+		}
+	}
+
+	return storageManager.SnapshotDataset(dataset, snapshotName, description)
+}
+
+// rollbackVMDataset restores a stopped VM's dataset to a ZFS snapshot
+func rollbackVMDataset(storageManager *storage.Manager, cfg *config.Config, vm *virsh.VMInfo, dataset, snapshotName string, force bool) error {
+	snapshot, err := storageManager.FindDatasetSnapshot(dataset, snapshotName)
+	if err != nil {
+		return err
+	}
+	if snapshot == nil {
+		return fmt.Errorf("snapshot '%s' not found for VM '%s'", snapshotName, vm.Name)
+	}
+	if !strings.Contains(vm.State, "shut off") {
+		return fmt.Errorf("VM '%s' must be shut off to restore ZFS snapshot '%s' (state: %s)", vm.Name, snapshotName, vm.State)
+	}
+
+	// zfs rollback destroys every snapshot taken after the target
+	snapshots, err := storageManager.ListDatasetSnapshots(dataset)
+	if err != nil {
+		return err
+	}
+	var later []string
+	for _, s := range snapshots {
+		if s.Created.After(snapshot.Created) {
+			later = append(later, s.Name)
+		}
+	}
+
+	if !force {
+		fmt.Printf("⚠️  WARNING: Restoring VM '%s' on %s to ZFS snapshot '%s' will lose all changes made after the snapshot.\n", vm.Name, cfg.Label(), snapshotName)
+		if len(later) > 0 {
+			fmt.Printf("The following later snapshots will also be destroyed: %s\n", strings.Join(later, ", "))
+		}
+		confirmed, err := confirm("Are you sure you want to continue? (y/N): ")
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			messages.Println(messages.Cancelled)
+			return nil
+		}
+	}
+
+	statusf("Rolling back dataset %s to '%s'...\n", dataset, snapshotName)
+	if err := storageManager.RollbackDataset(dataset, snapshotName); err != nil {
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+
+	statusf("VM '%s' restored to snapshot '%s' successfully\n", vm.Name, snapshotName)
+	return nil
+}
+
+// destroyVMDatasetSnapshot deletes a ZFS snapshot of a VM's dataset
+func destroyVMDatasetSnapshot(storageManager *storage.Manager, cfg *config.Config, vmName, dataset, snapshotName string, force bool) error {
+	snapshot, err := storageManager.FindDatasetSnapshot(dataset, snapshotName)
+	if err != nil {
+		return err
+	}
+	if snapshot == nil {
+		return fmt.Errorf("snapshot '%s' not found for VM '%s'", snapshotName, vmName)
+	}
+
+	if !force {
+		confirmed, err := confirm(fmt.Sprintf("Are you sure you want to delete ZFS snapshot '%s' from VM '%s' on %s? (y/N): ", snapshotName, vmName, cfg.Label()))
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			messages.Println(messages.Cancelled)
+			return nil
+		}
+	}
+
+	statusf("Deleting snapshot '%s' from VM '%s'...\n", snapshotName, vmName)
+	if err := storageManager.DestroyDatasetSnapshot(dataset, snapshotName); err != nil {
+		return fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+
+	messages.Println(messages.SnapshotDeleted, snapshotName)
+	return nil
+}
+
+// cloneVMDataset clones a VM through a ZFS snapshot and clone of its dataset, so the
+// new VM's disks share unchanged blocks with the source
+func cloneVMDataset(storageManager *storage.Manager, virshClient *virsh.Client, source *virsh.VMInfo, dataset, targetVM string) error {
+	disks, err := virshClient.ListDisks(source.Name)
+	if err != nil {
+		return err
+	}
+
+	snapshotName := "clone-" + storage.DatasetComponent(targetVM)
+	if err := snapshotVMDataset(storageManager, virshClient, source, dataset, snapshotName, fmt.Sprintf("origin of VM '%s'", targetVM)); err != nil {
+		return err
+	}
+
+	targetDataset, mountpoint, err := storageManager.CloneDataset(dataset, snapshotName, targetVM)
+	if err != nil {
+		return err
+	}
+	statusf("Created ZFS clone %s of %s@%s\n", targetDataset, dataset, snapshotName)
+
+	// Rename the cloned images after the new VM
+	diskPaths := make([]string, len(disks))
+	for i, disk := range disks {
+		name := path.Base(disk.Source.File)
+		if rest, ok := strings.CutPrefix(name, source.Name); ok {
+			name = targetVM + rest
+		}
+		diskPaths[i] = path.Join(mountpoint, name)
+
+		clonedPath := path.Join(mountpoint, path.Base(disk.Source.File))
+		if clonedPath != diskPaths[i] {
+			if err := storageManager.MoveFile(clonedPath, diskPaths[i]); err != nil {
+				return removeDatasetClone(storageManager, targetDataset, dataset, snapshotName, err)
+			}
+		}
+	}
+
+	if err := warnQVSRegistration(virshClient.CloneVMWithDisks(source.Name, targetVM, diskPaths)); err != nil {
+		return removeDatasetClone(storageManager, targetDataset, dataset, snapshotName, fmt.Errorf("failed to clone VM: %w", err))
+	}
+	return nil
+}
+
+// removeDatasetClone destroys a ZFS clone that no VM was defined on, and the snapshot
+// it was cloned from, and returns cause with any cleanup failure added
+func removeDatasetClone(storageManager *storage.Manager, targetDataset, dataset, snapshotName string, cause error) error {
+	if err := storageManager.DestroyDataset(targetDataset); err != nil {
+		return fmt.Errorf("%w (ZFS clone %s was kept: %v)", cause, targetDataset, err)
+	}
+	if err := storageManager.DestroyDatasetSnapshot(dataset, snapshotName); err != nil {
+		return fmt.Errorf("%w (snapshot %s@%s was kept: %v)", cause, dataset, snapshotName, err)
+	}
+	fmt.Fprintf(os.Stderr, "Removed ZFS clone %s\n", targetDataset)
+	return cause
+}
+
+// estimateSnapshot measures a VM's disk images and estimates the cost of an internal snapshot
+func estimateSnapshot(storageManager *storage.Manager, disks []virsh.DomainDisk, memory int64) (storage.SnapshotEstimate, error) {
+	var images []storage.ImageInfo
+	for _, disk := range disks {
+		info, err := storageManager.GetImageInfo(disk.Source.File)
+		if err != nil {
+			return storage.SnapshotEstimate{}, err
+		}
+		images = append(images, *info)
+	}
+	return storage.EstimateSnapshot(images, memory), nil
+}
+
+// printSnapshotEstimate shows the space and time a snapshot is expected to take
+func printSnapshotEstimate(vmName string, est storage.SnapshotEstimate, pool *storage.Pool) {
+	fmt.Printf("Snapshot estimate for VM '%s':\n", vmName)
+	fmt.Printf("  %-15s: %s\n", "Metadata", formatBytes(est.Metadata))
+	if est.VMState > 0 {
+		fmt.Printf("  %-15s: %s\n", "Memory state", formatBytes(est.VMState))
+	}
+	fmt.Printf("  %-15s: %s\n", "Needed now", formatBytes(est.Immediate()))
+	fmt.Printf("  %-15s: up to %s as existing data is rewritten\n", "Later growth", formatBytes(est.Growth))
+	if pool != nil {
+		fmt.Printf("  %-15s: %d GB (%s)\n", "Pool free space", pool.FreeSpace, pool.Name)
+	}
+	fmt.Printf("  %-15s: ~%s\n", "Estimated time", est.Duration.Round(time.Second))
+}
+
+// cloneVMToPool performs a full clone with all cloned disks placed in the named pool
+func cloneVMToPool(storageManager *storage.Manager, virshClient *virsh.Client, sourceVM, targetVM, poolName string) error {
+	pool, err := storageManager.GetPool(poolName)
+	if err != nil {
+		return err
+	}
+
+	disks, err := virshClient.ListDisks(sourceVM)
+	if err != nil {
+		return err
+	}
+
+	diskPaths := make([]string, len(disks))
+	for i := range disks {
+		diskPaths[i] = storageManager.CreateVMDiskPathIndexed(pool, targetVM, i)
+	}
+
+	statusf("Placing cloned disks in storage pool: %s (%s)\n", pool.Name, pool.Path)
+	if err := warnQVSRegistration(virshClient.CloneVMToPaths(sourceVM, targetVM, diskPaths)); err != nil {
+		return fmt.Errorf("failed to clone VM: %w", err)
+	}
+
+	return nil
+}
+
 func consoleCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "console [VM_NAME]",
-		Short: "Access VM console",
-		Long:  "Access virtual machine console via VNC or serial connection",
-		Args:  cobra.ExactArgs(1),
+		Use:               "console [VM_NAME]",
+		Short:             "Access VM console",
+		Long:              "Access virtual machine console via VNC or serial connection",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := loadConfig(cmd)
 			if err != nil {
@@ -1184,8 +2629,12 @@ func consoleCmd() *cobra.Command {
 
 			vmName := args[0]
 			vncOnly, _ := cmd.Flags().GetBool("vnc")
+			spiceOnly, _ := cmd.Flags().GetBool("spice")
 			serialOnly, _ := cmd.Flags().GetBool("serial")
 			force, _ := cmd.Flags().GetBool("force")
+			tunnel, _ := cmd.Flags().GetBool("tunnel")
+			sendFile, _ := cmd.Flags().GetString("send-file")
+			destPath, _ := cmd.Flags().GetString("dest")
 
 			// Connect to QNAP device
 			sshClient, virshClient, err := connectToQNAP(*cfg)
@@ -1201,19 +2650,52 @@ func consoleCmd() *cobra.Command {
 			// Check if VM exists and is running
 			vm, err := virshClient.GetVM(vmName)
 			if err != nil {
-				return fmt.Errorf("VM '%s' not found", vmName)
+				return err
 			}
 
 			if !strings.Contains(vm.State, "running") {
 				return fmt.Errorf("VM '%s' is not running (state: %s). Console access requires a running VM.", vmName, vm.State)
 			}
 
+			// Drop a file into the guest through the guest agent
+			if sendFile != "" {
+				return sendFileToGuest(virshClient, vmName, sendFile, destPath)
+			}
+
 			// Get console information
 			consoleInfo, err := virshClient.GetConsoleInfo(vmName)
 			if err != nil {
 				return fmt.Errorf("failed to get console information: %w", err)
 			}
 
+			// Handle SPICE access
+			if spiceOnly || (!serialOnly && !vncOnly && consoleInfo.Protocol == "SPICE") {
+				spiceConnection, err := virshClient.GetSPICEConnectionString(vmName)
+				if err != nil {
+					return fmt.Errorf("failed to get SPICE connection: %w", err)
+				}
+
+				fmt.Printf("SPICE Console Access for VM '%s':\n\n", vmName)
+				fmt.Printf("Connection Details:\n")
+				fmt.Printf("  Protocol: %s\n", consoleInfo.Protocol)
+				fmt.Printf("  Host: %s\n", consoleInfo.SPICEHost)
+				fmt.Printf("  Port: %d\n", consoleInfo.SPICEPort)
+				fmt.Printf("\nSPICE Connection String: %s\n\n", spiceConnection)
+
+				if tunnel {
+					return tunnelConsole(sshClient, consoleInfo.SPICEHost, consoleInfo.SPICEPort, "remote-viewer spice://localhost:%d")
+				}
+
+				fmt.Printf("To connect using a SPICE client:\n")
+				fmt.Printf("  remote-viewer %s\n", spiceConnection)
+				fmt.Printf("\nOr use SSH tunnel for secure access:\n")
+				fmt.Printf("  qnap-vm console %s --spice --tunnel\n", vmName)
+				fmt.Printf("  ssh -L %d:localhost:%d %s@%s\n", consoleInfo.SPICEPort, consoleInfo.SPICEPort, cfg.Username, cfg.Host)
+				fmt.Printf("  remote-viewer spice://localhost:%d\n", consoleInfo.SPICEPort)
+
+				return nil
+			}
+
 			// Handle VNC access
 			if vncOnly || (!serialOnly && consoleInfo.Protocol == "VNC") {
 				vncConnection, err := virshClient.GetVNCConnectionString(vmName)
@@ -1229,6 +2711,10 @@ func consoleCmd() *cobra.Command {
 				fmt.Printf("  Display: %s\n", consoleInfo.VNCDisplay)
 				fmt.Printf("\nVNC Connection String: %s\n\n", vncConnection)
 
+				if tunnel {
+					return tunnelConsole(sshClient, consoleInfo.VNCHost, consoleInfo.VNCPort, "vncviewer localhost:%d")
+				}
+
 				fmt.Printf("To connect using a VNC client:\n")
 				fmt.Printf("  vncviewer %s\n", vncConnection)
 				fmt.Printf("  open vnc://%s  # macOS Screen Sharing\n", vncConnection)
@@ -1249,19 +2735,18 @@ func consoleCmd() *cobra.Command {
 				fmt.Printf("  3. Appropriate permissions configured\n\n")
 
 				if !force {
-					fmt.Print("Attempt to connect to serial console? This may require guest OS setup. (y/N): ")
-					var response string
-					if _, err := fmt.Scanln(&response); err != nil {
-						fmt.Fprintf(os.Stderr, "Warning: failed to read input: %v\n", err)
+					confirmed, err := confirm("Attempt to connect to serial console? This may require guest OS setup. (y/N): ")
+					if err != nil {
+						return err
 					}
-					if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
+					if !confirmed {
 						fmt.Println("Console connection cancelled")
 						return nil
 					}
 				}
 
-				fmt.Printf("Connecting to serial console for VM '%s'...\n", vmName)
-				fmt.Printf("Use 'Ctrl+]' to exit the console session.\n\n")
+				statusf("Connecting to serial console for VM '%s'...\n", vmName)
+				statusf("Use 'Ctrl+]' to exit the console session.\n\n")
 
 				// This would normally connect to interactive console
 				// For CLI tool, we'll provide connection instructions instead
@@ -1277,8 +2762,61 @@ func consoleCmd() *cobra.Command {
 	}
 
 	cmd.Flags().BoolP("vnc", "", false, "Show VNC console information only")
+	cmd.Flags().Bool("spice", false, "Show SPICE console information only")
 	cmd.Flags().BoolP("serial", "s", false, "Connect to serial console only")
 	cmd.Flags().BoolP("force", "f", false, "Force console connection without confirmation")
+	cmd.Flags().BoolP("tunnel", "t", false, "Forward the VNC/SPICE port to localhost over SSH until interrupted")
+	cmd.Flags().String("send-file", "", "Copy a small local file into the guest via the guest agent")
+	cmd.Flags().String("dest", "", "Destination path in the guest for --send-file (default: /tmp/<file name>)")
 
 	return cmd
 }
+
+// sendFileToGuest copies a local file into a running guest using the QEMU guest agent
+func sendFileToGuest(virshClient *virsh.Client, vmName, localPath, destPath string) error {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", localPath, err)
+	}
+
+	if destPath == "" {
+		destPath = "/tmp/" + filepath.Base(localPath)
+	}
+
+	if err := virshClient.GuestPing(vmName); err != nil {
+		return err
+	}
+
+	statusf("Sending %s (%s) to %s:%s...\n", localPath, formatBytes(int64(len(data))), vmName, destPath)
+	if err := virshClient.GuestWriteFile(vmName, destPath, data); err != nil {
+		return fmt.Errorf("failed to send file: %w", err)
+	}
+
+	statusf("File delivered to %s in VM '%s'\n", destPath, vmName)
+	return nil
+}
+
+// tunnelConsole forwards a console port on the QNAP device to the same port on localhost
+func tunnelConsole(sshClient *ssh.Client, remoteHost string, port int, clientHint string) error {
+	if port <= 0 {
+		return fmt.Errorf("console port is not known, cannot open tunnel")
+	}
+	if remoteHost == "" || remoteHost == "0.0.0.0" {
+		remoteHost = "127.0.0.1"
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on local port %d: %w", port, err)
+	}
+	defer func() {
+		if err := listener.Close(); err != nil {
+			// Listener is already closed when forwarding stops
+		}
+	}()
+
+	fmt.Printf("Tunnel open on localhost:%d (press Ctrl+C to close)\n", port)
+	fmt.Printf("Connect with:\n  "+clientHint+"\n", port)
+
+	return sshClient.Forward(listener, net.JoinHostPort(remoteHost, strconv.Itoa(port)))
+}
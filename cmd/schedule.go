@@ -0,0 +1,469 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/backup"
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/inventory"
+	"github.com/scttfrdmn/qnap-vm/pkg/notify"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func backupScheduleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schedule [VM_NAME]",
+		Short: "Schedule recurring backups of a VM",
+		Long: `Schedule recurring backups of a VM to --dest, keeping the newest --keep sets.
+Schedules are stored in the host's config; without a VM name they are listed.
+
+Scheduled backups are run by 'qnap-vm backup run-scheduled', either from cron
+on this machine, e.g.
+
+  */15 * * * * qnap-vm backup run-scheduled --no-progress
+
+or continuously with 'qnap-vm backup run-scheduled --watch'. Each run takes the
+backups that are due, catching up on any that were missed, and then deletes
+expired sets. Sets that newer incremental sets build on are kept.`,
+		Example: `  qnap-vm backup schedule homeassistant --dest /share/Backups --daily --keep 7
+  qnap-vm backup schedule web --dest s3://backups/nas1 --weekly --at 03:30 --keep 4 --incremental
+  qnap-vm backup schedule web --remove`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				printBackupSchedules(cfg.Backups)
+				return nil
+			}
+			vmName := args[0]
+
+			remove, _ := cmd.Flags().GetBool("remove")
+			dest, _ := cmd.Flags().GetString("dest")
+			schedule := config.BackupSchedule{VM: vmName, Dest: dest}
+			schedule.Local, _ = cmd.Flags().GetBool("local")
+			schedule.At, _ = cmd.Flags().GetString("at")
+			schedule.Keep, _ = cmd.Flags().GetInt("keep")
+			schedule.Incremental, _ = cmd.Flags().GetBool("incremental")
+			for _, every := range []string{backup.EveryHourly, backup.EveryDaily, backup.EveryWeekly} {
+				if set, _ := cmd.Flags().GetBool(every); set {
+					if schedule.Every != "" {
+						return fmt.Errorf("use only one of --hourly, --daily and --weekly")
+					}
+					schedule.Every = every
+				}
+			}
+
+			if !remove && (schedule.Every == "" || dest == "") {
+				return fmt.Errorf("scheduling a backup needs --dest and one of --hourly, --daily or --weekly")
+			}
+			if schedule.Every == backup.EveryHourly && schedule.At == "" {
+				schedule.At = "00:00"
+			}
+
+			configFile, err := config.LoadConfig()
+			if err != nil {
+				return err
+			}
+			hostConfig, exists := configFile.GetHostConfig(cfg.HostName)
+			if cfg.HostName == "" || !exists {
+				return fmt.Errorf("backup schedules are stored with the host's config; add the host first with 'qnap-vm config set'")
+			}
+
+			// A VM has one schedule per destination
+			var schedules []config.BackupSchedule
+			removed := 0
+			for _, existing := range hostConfig.Backups {
+				if existing.VM == vmName && (dest == "" || existing.Dest == dest) {
+					removed++
+					continue
+				}
+				schedules = append(schedules, existing)
+			}
+			if remove {
+				if removed == 0 && dest != "" {
+					return fmt.Errorf("VM '%s' has no backup schedule to %s", vmName, dest)
+				}
+				if removed == 0 {
+					return fmt.Errorf("VM '%s' has no backup schedule", vmName)
+				}
+			} else {
+				schedules = append(schedules, schedule)
+			}
+
+			hostConfig.Backups = schedules
+			if err := hostConfig.Validate(); err != nil {
+				return err
+			}
+			configFile.SetHostConfig(cfg.HostName, hostConfig)
+			if err := config.SaveConfig(configFile); err != nil {
+				return err
+			}
+
+			if remove {
+				statusf("Removed %d backup schedule(s) for VM '%s'\n", removed, vmName)
+				return nil
+			}
+			next, err := backup.NextSlot(schedule.Every, schedule.At, time.Now())
+			if err != nil {
+				return err
+			}
+			statusf("VM '%s' is backed up %s to %s (%s)\n", vmName, schedule.Every, dest, describeRetention(schedule.Keep))
+			statusf("Next backup due %s; run 'qnap-vm backup run-scheduled' from cron or with --watch\n", next.Format("2006-01-02 15:04"))
+			return nil
+		},
+	}
+
+	cmd.Flags().String("dest", "", "Backup directory on the NAS, on this machine with --local, or s3://bucket/prefix")
+	cmd.Flags().Bool("local", false, "--dest is a directory on this machine")
+	cmd.Flags().Bool(backup.EveryHourly, false, "Back up every hour")
+	cmd.Flags().Bool(backup.EveryDaily, false, "Back up every day")
+	cmd.Flags().Bool(backup.EveryWeekly, false, "Back up every Sunday")
+	cmd.Flags().String("at", "", "Time of day as HH:MM (default 02:00; for --hourly only the minute is used)")
+	cmd.Flags().Int("keep", 0, "Number of newest sets to keep (default: keep all)")
+	cmd.Flags().Bool("incremental", false, "Take incremental backups of running VMs")
+	cmd.Flags().Bool("remove", false, "Remove the VM's schedules (only the one to --dest if given)")
+	return cmd
+}
+
+// printBackupSchedules lists a host's backup schedules
+func printBackupSchedules(schedules []config.BackupSchedule) {
+	if len(schedules) == 0 {
+		fmt.Println("No backup schedules. Add one with 'qnap-vm backup schedule VM --dest DEST --daily'")
+		return
+	}
+
+	fmt.Printf("%-20s %-8s %-6s %-6s %-11s %s\n", "VM", "EVERY", "AT", "KEEP", "INCREMENTAL", "DEST")
+	fmt.Printf("%-20s %-8s %-6s %-6s %-11s %s\n", "--", "-----", "--", "----", "-----------", "----")
+	for _, schedule := range schedules {
+		at := schedule.At
+		if at == "" {
+			at = backup.DefaultScheduleTime
+		}
+		keep := "all"
+		if schedule.Keep > 0 {
+			keep = fmt.Sprintf("%d", schedule.Keep)
+		}
+		incremental := "no"
+		if schedule.Incremental {
+			incremental = "yes"
+		}
+		dest := schedule.Dest
+		if schedule.Local {
+			dest += " (local)"
+		}
+		fmt.Printf("%-20s %-8s %-6s %-6s %-11s %s\n", schedule.VM, schedule.Every, at, keep, incremental, dest)
+	}
+}
+
+// describeRetention describes a schedule's keep count
+func describeRetention(keep int) string {
+	if keep <= 0 {
+		return "keeping all sets"
+	}
+	return fmt.Sprintf("keeping the newest %d", keep)
+}
+
+func backupStatusCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status [VM_NAME]",
+		Short: "Show the last successful backup of each VM",
+		Long: `Show the newest complete backup set of each scheduled VM, when the next one
+is due, and whether it is overdue. With --dest, show the newest set of every VM
+found at that destination instead.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dest, _ := cmd.Flags().GetString("dest")
+			local, _ := cmd.Flags().GetBool("local")
+			vmName := ""
+			if len(args) > 0 {
+				vmName = args[0]
+			}
+
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			schedules := cfg.Backups
+			if dest != "" {
+				schedules = []config.BackupSchedule{{VM: vmName, Dest: dest, Local: local}}
+			}
+			var selected []config.BackupSchedule
+			for _, schedule := range schedules {
+				if vmName == "" || schedule.VM == vmName {
+					selected = append(selected, schedule)
+				}
+			}
+			if len(selected) == 0 {
+				if vmName != "" {
+					return fmt.Errorf("VM '%s' has no backup schedule; pass --dest to look for its backups", vmName)
+				}
+				printBackupSchedules(nil)
+				return nil
+			}
+
+			// Only sets on the NAS need a connection to it
+			var sshClient *ssh.Client
+			for _, schedule := range selected {
+				if sshClient == nil && !schedule.Local && !isS3URL(schedule.Dest) {
+					sshClient, _, err = connectToQNAP(*cfg)
+					if err != nil {
+						return err
+					}
+					defer func() {
+						if err := sshClient.Close(); err != nil {
+							fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+						}
+					}()
+				}
+			}
+
+			now := time.Now()
+			fmt.Printf("%-20s %-20s %-12s %-8s %-17s %-8s %s\n", "VM", "LAST BACKUP", "AGE", "METHOD", "NEXT DUE", "STATUS", "DEST")
+			fmt.Printf("%-20s %-20s %-12s %-8s %-17s %-8s %s\n", "--", "-----------", "---", "------", "--------", "------", "----")
+			var failed []error
+			for _, schedule := range selected {
+				destination, err := newBackupDestination(sshClient, cfg, schedule.Dest, schedule.Local)
+				if err != nil {
+					return err
+				}
+				manifests, err := backup.ReadManifests(destination, schedule.VM)
+				if err != nil {
+					failed = append(failed, err)
+					fmt.Printf("%-20s %-20s %-12s %-8s %-17s %-8s %s\n", schedule.VM, "-", "-", "-", "-", "ERROR", schedule.Dest)
+					continue
+				}
+
+				// Without a schedule, report every VM at the destination
+				latest := make(map[string]*backup.Manifest)
+				var order []string
+				for _, manifest := range manifests {
+					if _, seen := latest[manifest.VM]; !seen {
+						order = append(order, manifest.VM)
+					}
+					latest[manifest.VM] = manifest
+				}
+				if schedule.VM != "" && latest[schedule.VM] == nil {
+					order = []string{schedule.VM}
+				}
+
+				for _, name := range order {
+					last, age, method := "never", "-", "-"
+					if manifest := latest[name]; manifest != nil {
+						last = manifest.Created.Local().Format("2006-01-02 15:04")
+						age = inventory.Age(manifest.Created, now)
+						method = manifest.Method
+					}
+					next, status := "-", "-"
+					if schedule.Every != "" {
+						next, status = scheduleState(schedule, latest[name], now)
+					}
+					fmt.Printf("%-20s %-20s %-12s %-8s %-17s %-8s %s\n", name, last, age, method, next, status, schedule.Dest)
+				}
+			}
+			return errors.Join(failed...)
+		},
+	}
+
+	cmd.Flags().String("dest", "", "Show the newest set of every VM at this destination instead of the scheduled ones")
+	cmd.Flags().Bool("local", false, "--dest is a directory on this machine")
+	return cmd
+}
+
+// scheduleState returns when a schedule's next backup is due and whether the newest
+// set is recent enough: OK, or OVERDUE once a due backup has not been taken
+func scheduleState(schedule config.BackupSchedule, latest *backup.Manifest, now time.Time) (next, status string) {
+	slot, err := backup.LastSlot(schedule.Every, schedule.At, now)
+	if err != nil {
+		return "-", "INVALID"
+	}
+	nextSlot, err := backup.NextSlot(schedule.Every, schedule.At, now)
+	if err != nil {
+		return "-", "INVALID"
+	}
+
+	next = nextSlot.Format("2006-01-02 15:04")
+	if latest == nil || latest.Created.Before(slot) {
+		return next, "OVERDUE"
+	}
+	return next, "OK"
+}
+
+func backupRunScheduledCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run-scheduled",
+		Short: "Take the scheduled backups that are due and prune expired sets",
+		Long: `Take each scheduled backup of the host whose latest set is older than its
+most recent due time, then delete sets beyond the schedule's --keep count.
+
+Run it from cron on this machine, or with --watch to keep running and check
+every minute. Failures of one schedule do not stop the others; the command
+exits non-zero if any failed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			watch, _ := cmd.Flags().GetBool("watch")
+			noProgress := progressDisabled(cmd)
+
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+			if len(cfg.Backups) == 0 {
+				printBackupSchedules(nil)
+				return nil
+			}
+
+			transfer := ssh.TransferOptions{Verify: true}
+			if !noProgress {
+				transfer.Progress = os.Stderr
+			}
+
+			if !watch {
+				return runScheduledBackups(cmd, cfg, transfer, nil)
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			statusf("Running %d backup schedule(s) for %s (press Ctrl+C to stop)\n", len(cfg.Backups), cfg.Label())
+
+			// Remembers which due time each schedule was last checked for, so the NAS is
+			// only contacted when something may be due
+			checked := make(map[string]time.Time)
+			for {
+				if err := runScheduledBackups(cmd, cfg, transfer, checked); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(time.Until(time.Now().Truncate(time.Minute).Add(time.Minute))):
+				}
+			}
+		},
+	}
+
+	cmd.Flags().Bool("watch", false, "Keep running, checking the schedules every minute")
+	cmd.Flags().Bool("no-progress", false, "Disable progress bars, e.g. when run from cron")
+	addSpaceCheckFlag(cmd)
+	return cmd
+}
+
+// runScheduledBackups takes the due backups and prunes expired sets. checked, if not nil,
+// records the due time each schedule was handled for, and schedules already handled
+// for their current due time are skipped.
+func runScheduledBackups(cmd *cobra.Command, cfg *config.Config, transfer ssh.TransferOptions, checked map[string]time.Time) error {
+	now := time.Now()
+	type dueSchedule struct {
+		config.BackupSchedule
+		slot time.Time
+	}
+	var pending []dueSchedule
+	for _, schedule := range cfg.Backups {
+		slot, err := backup.LastSlot(schedule.Every, schedule.At, now)
+		if err != nil {
+			return err
+		}
+		if checked != nil && !checked[scheduleKey(schedule)].Before(slot) {
+			continue
+		}
+		pending = append(pending, dueSchedule{schedule, slot})
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// Connect to QNAP device
+	sshClient, virshClient, err := connectToQNAP(*cfg)
+	if err != nil {
+		notifier := newNotifier(cfg, nil)
+		for _, schedule := range pending {
+			sendNotification(notifier, backupFailedEvent(cfg, schedule.BackupSchedule, err))
+		}
+		return err
+	}
+	defer func() {
+		if err := sshClient.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+		}
+	}()
+
+	notifier := newNotifier(cfg, sshClient)
+	var failed []error
+	for _, schedule := range pending {
+		destination, err := newBackupDestination(sshClient, cfg, schedule.Dest, schedule.Local)
+		if err == nil {
+			err = runSchedule(cmd, cfg, sshClient, virshClient, destination, schedule.BackupSchedule, schedule.slot, transfer, notifier)
+		}
+		if err != nil {
+			sendNotification(notifier, backupFailedEvent(cfg, schedule.BackupSchedule, err))
+			failed = append(failed, fmt.Errorf("scheduled backup of VM '%s' to %s failed: %w", schedule.VM, schedule.Dest, err))
+			continue
+		}
+		if checked != nil {
+			checked[scheduleKey(schedule.BackupSchedule)] = schedule.slot
+		}
+	}
+	return errors.Join(failed...)
+}
+
+// backupFailedEvent is the alert for a scheduled backup that failed with err
+func backupFailedEvent(cfg *config.Config, schedule config.BackupSchedule, err error) notify.Event {
+	return notify.Event{
+		Type:     notify.EventBackupFailed,
+		Severity: notify.SeverityError,
+		VM:       schedule.VM,
+		Message:  fmt.Sprintf("Scheduled backup of VM '%s' on %s to %s failed: %v", schedule.VM, cfg.Label(), schedule.Dest, err),
+	}
+}
+
+// scheduleKey identifies a schedule within a host's config
+func scheduleKey(schedule config.BackupSchedule) string {
+	return schedule.VM + "\x00" + schedule.Dest
+}
+
+// runSchedule backs up the schedule's VM if no set is newer than slot, then deletes the
+// sets beyond its keep count, alerting about each
+func runSchedule(cmd *cobra.Command, cfg *config.Config, sshClient *ssh.Client, virshClient *virsh.Client, destination backup.Destination, schedule config.BackupSchedule, slot time.Time, transfer ssh.TransferOptions, notifier *notify.Notifier) error {
+	manifests, err := backup.ReadManifests(destination, schedule.VM)
+	if err != nil {
+		return err
+	}
+
+	if len(manifests) == 0 || manifests[len(manifests)-1].Created.Before(slot) {
+		statusf("[%s] Backing up VM '%s' to %s (%s)\n", time.Now().Format("2006-01-02 15:04:05"), schedule.VM, schedule.Dest, schedule.Every)
+		options := backupOptions{dest: schedule.Dest, incremental: schedule.Incremental, transfer: transfer}
+		if _, err := runBackup(cmd, cfg, sshClient, virshClient, destination, schedule.VM, options); err != nil {
+			return err
+		}
+		if manifests, err = backup.ReadManifests(destination, schedule.VM); err != nil {
+			return err
+		}
+	}
+
+	for _, manifest := range backup.Expired(manifests, schedule.Keep) {
+		if err := destination.RemoveSet(manifest.Name); err != nil {
+			return err
+		}
+		statusf("Removed expired backup %s\n", destination.Location(manifest.Name))
+		sendNotification(notifier, notify.Event{
+			Type:     notify.EventBackupPruned,
+			Severity: notify.SeverityInfo,
+			VM:       schedule.VM,
+			Message:  fmt.Sprintf("Removed expired backup %s of VM '%s' (keeping %d)", destination.Location(manifest.Name), schedule.VM, schedule.Keep),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/server"
+	"github.com/spf13/cobra"
+)
+
+func serveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a REST API for VM management",
+		Long: `Serve a JSON REST API for the configured host, so dashboards and home
+automation can manage VMs without SSH access to the NAS.
+
+Every request needs an API token, sent as 'Authorization: Bearer TOKEN'. Each
+token has a role:
+  viewer    list VMs and read their status
+  operator  also start, stop, suspend and resume VMs
+  admin     also delete VMs
+
+Create tokens with 'qnap-vm serve token add NAME --role ROLE'.
+
+Endpoints:
+  GET    /api/v1/whoami                  Token name and role
+  GET    /api/v1/vms                     List VMs
+  GET    /api/v1/vms/{name}              VM status
+  GET    /api/v1/vms/{name}/stats        CPU, memory, disk and network counters
+  GET    /api/v1/vms/{name}/console      VNC or SPICE console link
+  POST   /api/v1/vms/{name}/start        Start a VM
+  POST   /api/v1/vms/{name}/stop         Shut down a VM (?force=true to power off)
+  POST   /api/v1/vms/{name}/suspend      Pause a VM
+  POST   /api/v1/vms/{name}/resume       Resume a paused VM
+  POST   /api/v1/vms/{name}/reboot       Reboot a running VM
+  DELETE /api/v1/vms/{name}              Delete a VM
+
+The OpenAPI 3 document is served without a token at /openapi.json, and printed
+by 'qnap-vm serve openapi', for generating typed clients.
+
+With --ui, a web dashboard at / lists the VMs with their state, live CPU and
+memory graphs, start/stop/reboot buttons and console links. It asks for a token
+once and remembers it in the browser; give household members an operator token.
+
+The API listens on localhost by default. Use --tls-cert and --tls-key before
+exposing it on the network: tokens are sent with every request.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			listen, _ := cmd.Flags().GetString("listen")
+			tlsCert, _ := cmd.Flags().GetString("tls-cert")
+			tlsKey, _ := cmd.Flags().GetString("tls-key")
+			ui, _ := cmd.Flags().GetBool("ui")
+			if (tlsCert == "") != (tlsKey == "") {
+				return fmt.Errorf("--tls-cert and --tls-key must be used together")
+			}
+
+			configFile, err := config.LoadConfig()
+			if err != nil {
+				return err
+			}
+			if len(configFile.Tokens) == 0 {
+				return fmt.Errorf("no API tokens are configured; create one with 'qnap-vm serve token add NAME --role viewer'")
+			}
+
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			// Connect to QNAP device
+			backend, closeBackend, err := connectBackend(*cfg)
+			if err != nil {
+				return err
+			}
+			defer closeBackend()
+
+			api, err := server.New(backend, configFile.Tokens)
+			if err != nil {
+				return err
+			}
+			api.Log = os.Stderr
+			api.Host = cfg.Host
+			if ui {
+				api.EnableUI()
+			}
+
+			httpServer := &http.Server{Addr: listen, Handler: api, ReadHeaderTimeout: 10 * time.Second}
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			go func() {
+				<-ctx.Done()
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				if err := httpServer.Shutdown(shutdownCtx); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				}
+			}()
+
+			scheme := "http"
+			if tlsCert != "" {
+				scheme = "https"
+			}
+			statusf("Serving the API for %s on %s://%s (%d tokens; press Ctrl+C to stop)\n", cfg.Label(), scheme, listen, len(configFile.Tokens))
+			if ui {
+				statusf("Dashboard: %s://%s/\n", scheme, listen)
+			}
+
+			if tlsCert != "" {
+				err = httpServer.ListenAndServeTLS(tlsCert, tlsKey)
+			} else {
+				err = httpServer.ListenAndServe()
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return fmt.Errorf("failed to serve on %s: %w", listen, err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("listen", "127.0.0.1:8080", "Address to listen on")
+	cmd.Flags().String("tls-cert", "", "TLS certificate file; serves HTTPS with --tls-key")
+	cmd.Flags().String("tls-key", "", "TLS private key file")
+	cmd.Flags().Bool("ui", false, "Serve the web dashboard at /")
+
+	openAPICmd := &cobra.Command{
+		Use:   "openapi",
+		Short: "Print the API's OpenAPI 3 document",
+		Long: `Print the OpenAPI 3 document describing the serve API, for generating typed
+clients, e.g. with openapi-generator.`,
+		Example: `  qnap-vm serve openapi > qnap-vm.json
+  openapi-generator-cli generate -i qnap-vm.json -g python -o qnap_vm_client`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, err := os.Stdout.Write(server.OpenAPISpec())
+			return err
+		},
+	}
+
+	cmd.AddCommand(serveTokenCmd(), openAPICmd)
+	return cmd
+}
+
+func serveTokenCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "Manage API tokens for serve",
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add NAME",
+		Short: "Create an API token",
+		Long: `Create an API token with a role (viewer, operator or admin) and print it.
+Only a hash of the token is stored, so it cannot be shown again.`,
+		Example: `  qnap-vm serve token add dashboard --role viewer`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			roleName, _ := cmd.Flags().GetString("role")
+			role, err := server.ParseRole(roleName)
+			if err != nil {
+				return err
+			}
+
+			configFile, err := config.LoadConfig()
+			if err != nil {
+				return err
+			}
+			for _, token := range configFile.Tokens {
+				if token.Name == name {
+					return fmt.Errorf("token '%s' already exists; remove it first to replace it", name)
+				}
+			}
+
+			token, hash, err := server.NewToken()
+			if err != nil {
+				return err
+			}
+			configFile.Tokens = append(configFile.Tokens, config.APIToken{
+				Name:    name,
+				Hash:    hash,
+				Role:    role.String(),
+				Created: time.Now().UTC().Truncate(time.Second),
+			})
+			if err := config.SaveConfig(configFile); err != nil {
+				return err
+			}
+
+			fmt.Printf("Token '%s' (%s) created:\n\n  %s\n\n", name, role, token)
+			fmt.Println("Store it now; it cannot be shown again.")
+			return nil
+		},
+	}
+	addCmd.Flags().String("role", "viewer", "Token role: viewer, operator or admin")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List API tokens",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			configFile, err := config.LoadConfig()
+			if err != nil {
+				return err
+			}
+			if len(configFile.Tokens) == 0 {
+				fmt.Println("No API tokens configured")
+				return nil
+			}
+
+			fmt.Printf("%-20s %-10s %s\n", "NAME", "ROLE", "CREATED")
+			fmt.Printf("%-20s %-10s %s\n", "----", "----", "-------")
+			for _, token := range configFile.Tokens {
+				fmt.Printf("%-20s %-10s %s\n", token.Name, token.Role, token.Created.Local().Format("2006-01-02 15:04"))
+			}
+			return nil
+		},
+	}
+
+	removeCmd := &cobra.Command{
+		Use:   "remove NAME",
+		Short: "Revoke an API token",
+		Long:  "Revoke an API token. A running 'qnap-vm serve' keeps accepting it until restarted.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			configFile, err := config.LoadConfig()
+			if err != nil {
+				return err
+			}
+
+			for i, token := range configFile.Tokens {
+				if token.Name == name {
+					configFile.Tokens = append(configFile.Tokens[:i], configFile.Tokens[i+1:]...)
+					if err := config.SaveConfig(configFile); err != nil {
+						return err
+					}
+					statusf("Token '%s' removed\n", name)
+					return nil
+				}
+			}
+			return fmt.Errorf("token '%s' not found", name)
+		},
+	}
+
+	cmd.AddCommand(addCmd, listCmd, removeCmd)
+	return cmd
+}
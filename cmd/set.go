@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func setCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set [VM_NAME]",
+		Short: "Change a VM's resources",
+		Long: `Change a VM's memory, boot from another device once, or switch it to virtio devices.
+
+--memory saves the new size in the VM's configuration; with --live it is also
+applied to the running VM through its balloon device, which asks the guest to
+give memory back or lets it use more, up to the maximum memory the VM was
+started with.
+
+--boot-once starts a stopped VM from another device, e.g. cdrom to reinstall it,
+and keeps its saved boot order for later starts.
+
+--virtio moves a stopped VM's SATA and IDE disks and e1000/rtl8139 network cards
+to virtio. Windows VMs created with --os windows start on those emulated devices;
+once Windows is installed, switch them over for much better disk and network
+performance:
+
+  1. In the guest, run virtio-win-guest-tools.exe from the virtio-win CD-ROM
+  2. Shut the VM down: qnap-vm stop VM
+  3. qnap-vm set VM --virtio
+  4. qnap-vm start VM, then remove the driver CD: qnap-vm cdrom eject VM`,
+		Example: `  qnap-vm set homeassistant --memory 4096 --live
+  qnap-vm set build --memory 2048
+  qnap-vm set build --boot-once cdrom
+  qnap-vm set win11 --virtio`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			vmName := args[0]
+			memory, _ := cmd.Flags().GetInt("memory")
+			live, _ := cmd.Flags().GetBool("live")
+			bootOnce, _ := cmd.Flags().GetString("boot-once")
+			toVirtio, _ := cmd.Flags().GetBool("virtio")
+			setMemory := cmd.Flags().Changed("memory")
+			if !setMemory && bootOnce == "" && !toVirtio {
+				return fmt.Errorf("nothing to change; give --memory, --boot-once or --virtio")
+			}
+			if setMemory && memory <= 0 {
+				return fmt.Errorf("invalid memory value: %d", memory)
+			}
+			if live && !setMemory {
+				return fmt.Errorf("--live applies to --memory")
+			}
+			if bootOnce != "" {
+				devices, err := virsh.ParseBootOrder(bootOnce)
+				if err != nil {
+					return err
+				}
+				if len(devices) != 1 {
+					return fmt.Errorf("--boot-once takes a single device")
+				}
+			}
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			vm, err := virshClient.GetVMDetails(vmName)
+			if err != nil {
+				return err
+			}
+
+			if toVirtio {
+				if err := switchVMToVirtio(virshClient, vm); err != nil {
+					return err
+				}
+			}
+			if setMemory {
+				if err := setVMMemory(virshClient, vm, memory, live); err != nil {
+					return err
+				}
+			}
+			if bootOnce != "" {
+				return bootVMOnce(virshClient, vm, bootOnce)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Int("memory", 0, "Memory in MB, up to the VM's maximum memory")
+	cmd.Flags().Bool("live", false, "Also apply the change to the running VM through its balloon device")
+	cmd.Flags().String("boot-once", "", "Start the stopped VM from this device once (cdrom, hd, network or fd)")
+	cmd.Flags().Bool("virtio", false, "Switch the stopped VM's SATA/IDE disks and emulated network cards to virtio")
+	return cmd
+}
+
+// switchVMToVirtio moves a stopped VM's emulated disks and network cards to virtio
+func switchVMToVirtio(virshClient *virsh.Client, vm *virsh.VMInfo) error {
+	if isActive(vm) {
+		return fmt.Errorf("VM '%s' is %s; stop it before switching its devices to virtio", vm.Name, vm.State)
+	}
+
+	changes, err := virshClient.SwitchToVirtio(vm.Name)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		statusf("  %s\n", change)
+	}
+	statusf("VM '%s' now uses virtio devices; its guest needs virtio drivers to boot.\n", vm.Name)
+	return nil
+}
+
+// setVMMemory sets a VM's memory in its configuration and, with live, through its balloon
+func setVMMemory(virshClient *virsh.Client, vm *virsh.VMInfo, memory int, live bool) error {
+	running := vm.State == "running"
+	if live && !running {
+		return fmt.Errorf("VM '%s' is not running (state: %s); omit --live to change its saved configuration", vm.Name, vm.State)
+	}
+
+	// The balloon can only hand the guest memory up to the maximum it started with
+	maxMemory := vm.Memory
+	if live {
+		balloon, err := virshClient.GetBalloon(vm.Name)
+		if err != nil {
+			return err
+		}
+		if balloon.MaximumMB > 0 {
+			maxMemory = balloon.MaximumMB
+		}
+	}
+	if memory > maxMemory {
+		return fmt.Errorf("%d MB is above the maximum memory of VM '%s' (%d MB)", memory, vm.Name, maxMemory)
+	}
+
+	if err := virshClient.SetMemory(vm.Name, memory, live); err != nil {
+		return err
+	}
+	statusf("Set memory of VM '%s' to %d MB\n", vm.Name, memory)
+
+	if !live {
+		if running {
+			statusln("The change applies from the VM's next start; use --live to apply it now.")
+		}
+		return nil
+	}
+	printBalloon(virshClient, vm.Name, memory)
+	return nil
+}
+
+// bootVMOnce starts a stopped VM from device, then restores its saved boot order. The
+// running VM keeps the boot order it started with, so only this start is affected.
+func bootVMOnce(virshClient *virsh.Client, vm *virsh.VMInfo, device string) error {
+	if vm.State == "running" || vm.State == "paused" {
+		return fmt.Errorf("VM '%s' is %s; stop it before booting it from another device", vm.Name, vm.State)
+	}
+
+	domain, err := virshClient.GetDomain(vm.Name)
+	if err != nil {
+		return err
+	}
+	if err := checkBootDevice(domain, device); err != nil {
+		return fmt.Errorf("VM '%s' %w", vm.Name, err)
+	}
+
+	saved, err := virshClient.BootOrder(vm.Name)
+	if err != nil {
+		return err
+	}
+	once := []string{device}
+	for _, dev := range saved {
+		if dev != device {
+			once = append(once, dev)
+		}
+	}
+
+	if err := virshClient.SetBootOrder(vm.Name, once); err != nil {
+		return err
+	}
+	messages.Println(messages.VMStarting, vm.Name)
+	startErr := virshClient.StartVM(vm.Name)
+	if err := virshClient.SetBootOrder(vm.Name, saved); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to restore the boot order of VM '%s' (%s): %v\n", vm.Name, strings.Join(saved, ","), err)
+	}
+	if startErr != nil {
+		return fmt.Errorf("failed to start VM: %w", startErr)
+	}
+
+	messages.Println(messages.VMStarted, vm.Name)
+	statusf("Booting from %s once; later starts boot from %s.\n", device, strings.Join(saved, ","))
+	return nil
+}
+
+// checkBootDevice checks a VM has a device to boot from
+func checkBootDevice(domain *virsh.VMDomain, device string) error {
+	switch device {
+	case virsh.BootCDROM, virsh.BootFloppy:
+		kind := "cdrom"
+		if device == virsh.BootFloppy {
+			kind = "floppy"
+		}
+		for _, disk := range domain.Devices.Disk {
+			if disk.Device == kind {
+				return nil
+			}
+		}
+		return fmt.Errorf("has no %s drive", kind)
+	case virsh.BootNetwork:
+		if len(domain.Devices.Interface) == 0 {
+			return fmt.Errorf("has no network interface")
+		}
+	}
+	return nil
+}
+
+// printBalloon reports a running VM's balloon size, waiting briefly for the guest to
+// reach target MB
+func printBalloon(virshClient *virsh.Client, vmName string, target int) {
+	var balloon *virsh.Balloon
+	for attempt := 0; attempt < 10; attempt++ {
+		var err error
+		if balloon, err = virshClient.GetBalloon(vmName); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			return
+		}
+		if balloon.CurrentMB == target {
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	statusf("Balloon size: %d MB of %d MB maximum\n", balloon.CurrentMB, balloon.MaximumMB)
+	if balloon.CurrentMB != target {
+		statusln("The guest is still adjusting; it needs a balloon driver to give memory back.")
+	}
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/storage"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+// bulkSnapshot is one VM's part of 'snapshot create-all'
+type bulkSnapshot struct {
+	vm       virsh.VMInfo
+	dataset  string // ZFS dataset to snapshot, or "" for an internal snapshot
+	err      error
+	duration time.Duration
+}
+
+// kind describes how the VM is snapshotted
+func (s *bulkSnapshot) kind() string {
+	if s.dataset != "" {
+		return "zfs"
+	}
+	return "internal"
+}
+
+func createAllSnapshotCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create-all",
+		Short: "Snapshot every running VM at once",
+		Long: `Snapshot every running VM, or only those with --tag, concurrently under the
+same name, then show each VM's result. A failure for one VM does not stop the
+others; the command fails if any snapshot failed.
+
+Each VM is snapshotted as 'snapshot create' would: a ZFS snapshot of its own
+dataset where it has one, otherwise a qcow2 internal snapshot. Internal
+snapshots are skipped for VMs whose storage pool looks too full.`,
+		Example: `  qnap-vm snapshot create-all --name nightly-$(date +%Y%m%d)
+  qnap-vm snapshot create-all --name pre-upgrade --tag prod --all`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			snapshotName, _ := cmd.Flags().GetString("name")
+			description, _ := cmd.Flags().GetString("description")
+			tags, _ := cmd.Flags().GetStringSlice("tag")
+			all, _ := cmd.Flags().GetBool("all")
+			internal, _ := cmd.Flags().GetBool("internal")
+			parallel, _ := cmd.Flags().GetInt("parallel")
+			if parallel < 1 {
+				return fmt.Errorf("--parallel must be at least 1")
+			}
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			vms, err := virshClient.ListVMsWithMetadata()
+			if err != nil {
+				return fmt.Errorf("failed to list VMs: %w", err)
+			}
+
+			var snapshots []*bulkSnapshot
+			for _, vm := range vms {
+				if !all && vm.State != "running" {
+					continue
+				}
+				if len(tags) > 0 && !slices.ContainsFunc(tags, func(tag string) bool { return slices.Contains(vm.Tags, tag) }) {
+					continue
+				}
+				snapshots = append(snapshots, &bulkSnapshot{vm: vm})
+			}
+			if len(snapshots) == 0 {
+				if len(tags) > 0 {
+					return fmt.Errorf("no VM to snapshot is tagged %s", strings.Join(tags, " or "))
+				}
+				return fmt.Errorf("no VM to snapshot; use --all to include VMs that are not running")
+			}
+
+			// Choose each VM's snapshot type and check space up front; the storage
+			// manager is not safe for concurrent pool detection
+			storageManager := newStorageManager(sshClient, cfg)
+			for _, s := range snapshots {
+				if !internal {
+					s.dataset = vmDataset(storageManager, virshClient, s.vm.Name)
+				}
+				if s.dataset != "" {
+					if !storage.ValidDatasetSnapshotName(snapshotName) {
+						s.err = fmt.Errorf("invalid ZFS snapshot name '%s' (use letters, digits, '_', '-', '.' and ':')", snapshotName)
+					}
+					continue
+				}
+				if _, err := checkSnapshotSpace(cmd, storageManager, virshClient, s.vm.Name); err != nil {
+					s.err = err
+				}
+			}
+
+			statusf("Creating snapshot '%s' of %d VM(s), %d at a time...\n", snapshotName, len(snapshots), parallel)
+			runBulkSnapshots(snapshots, parallel, func(s *bulkSnapshot) error {
+				if s.dataset != "" {
+					return snapshotVMDataset(storageManager, virshClient, &s.vm, s.dataset, snapshotName, description)
+				}
+				return virshClient.CreateSnapshot(s.vm.Name, snapshotName, description)
+			})
+
+			return printBulkSnapshots(snapshots)
+		},
+	}
+
+	cmd.Flags().String("name", "", "Snapshot name, e.g. nightly-$(date +%Y%m%d)")
+	cmd.Flags().StringP("description", "d", "", "Snapshot description")
+	cmd.Flags().StringSlice("tag", nil, "Only snapshot VMs with one of these tags (repeatable or comma-separated)")
+	cmd.Flags().Bool("all", false, "Include VMs that are not running")
+	cmd.Flags().Bool("internal", false, "Create qcow2 internal snapshots even for VMs with their own ZFS dataset")
+	cmd.Flags().Int("parallel", 4, "Number of VMs to snapshot at the same time")
+	addSpaceCheckFlag(cmd)
+	if err := cmd.MarkFlagRequired("name"); err != nil {
+		// Flag is registered above; marking cannot fail
+	}
+	return cmd
+}
+
+// runBulkSnapshots runs snapshot for each entry without an error, at most parallel at a
+// time, reporting each as it finishes
+func runBulkSnapshots(snapshots []*bulkSnapshot, parallel int, snapshot func(s *bulkSnapshot) error) {
+	slots := make(chan struct{}, parallel)
+	done := make(chan *bulkSnapshot)
+
+	pending := 0
+	for _, s := range snapshots {
+		if s.err != nil {
+			continue
+		}
+		pending++
+		go func() {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			start := time.Now()
+			s.err = snapshot(s)
+			s.duration = time.Since(start)
+			done <- s
+		}()
+	}
+
+	for ; pending > 0; pending-- {
+		s := <-done
+		if s.err != nil {
+			statusf("✗ %s failed after %s\n", s.vm.Name, s.duration.Round(time.Millisecond))
+		} else {
+			statusf("✓ %s done in %s\n", s.vm.Name, s.duration.Round(time.Millisecond))
+		}
+	}
+}
+
+// printBulkSnapshots shows the result for each VM and returns an error if any failed
+func printBulkSnapshots(snapshots []*bulkSnapshot) error {
+	fmt.Println()
+	fmt.Printf("%-20s %-10s %-8s %s\n", "VM", "TYPE", "RESULT", "DETAILS")
+	fmt.Printf("%-20s %-10s %-8s %s\n", "--", "----", "------", "-------")
+
+	failed := 0
+	for _, s := range snapshots {
+		result, details := "ok", s.duration.Round(time.Millisecond).String()
+		if s.err != nil {
+			failed++
+			result = "FAILED"
+			// virsh errors carry their output on further lines
+			details, _, _ = strings.Cut(s.err.Error(), "\n")
+		}
+		fmt.Printf("%-20s %-10s %-8s %s\n", s.vm.Name, s.kind(), result, details)
+	}
+
+	fmt.Printf("\n%d of %d snapshot(s) created\n", len(snapshots)-failed, len(snapshots))
+	if failed > 0 {
+		return fmt.Errorf("%d of %d snapshot(s) failed", failed, len(snapshots))
+	}
+	return nil
+}
@@ -0,0 +1,429 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/nas"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/scttfrdmn/qnap-vm/pkg/storage"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+// poolListEntry is a storage pool as reported by 'storage list --json'
+type poolListEntry struct {
+	storage.Pool
+	Selected bool `json:"selected"`
+}
+
+func storageCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "storage",
+		Short: "Inspect and clean up storage pools",
+		Long:  "Inspect the storage pools qnap-vm can place VM disks in and remove orphaned disk images",
+	}
+
+	cmd.AddCommand(storageListCmd(), storageLUNsCmd(), storageGCCmd())
+	return cmd
+}
+
+func storageListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List detected storage pools",
+		Long: `List the CACHEDEV, ZFS and USB storage pools detected on the QNAP device
+with their space usage. The pool marked with '*' is selected automatically
+when --pool is not given.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+
+			// Connect to QNAP device
+			sshClient, _, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			pools, err := newStorageManager(sshClient, cfg).DetectPools()
+			if err != nil {
+				return fmt.Errorf("failed to detect storage pools: %w", err)
+			}
+
+			best := storage.SelectBestPool(pools)
+			entries := make([]poolListEntry, len(pools))
+			for i, pool := range pools {
+				entries[i] = poolListEntry{Pool: pool, Selected: best != nil && pool.Path == best.Path}
+			}
+
+			if jsonOutput {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(entries)
+			}
+
+			if len(entries) == 0 {
+				fmt.Println("No storage pools found.")
+				return nil
+			}
+
+			fmt.Printf("  %-20s %-10s %-30s %-8s %-8s %-8s %-10s\n", "NAME", "TYPE", "PATH", "TOTAL", "USED", "FREE", "AVAILABLE")
+			fmt.Printf("  %-20s %-10s %-30s %-8s %-8s %-8s %-10s\n", "--------------------", "----------", "------------------------------", "--------", "--------", "--------", "----------")
+
+			for _, entry := range entries {
+				marker := " "
+				if entry.Selected {
+					marker = "*"
+				}
+
+				available := "yes"
+				if !entry.Available {
+					available = "no"
+				}
+
+				fmt.Printf("%s %-20s %-10s %-30s %-8s %-8s %-8s %-10s\n",
+					marker, entry.Name, entry.Type, entry.Path,
+					fmt.Sprintf("%dG", entry.TotalSpace), fmt.Sprintf("%dG", entry.UsedSpace), fmt.Sprintf("%dG", entry.FreeSpace),
+					available)
+			}
+
+			if best != nil {
+				fmt.Printf("\n* auto-selected pool: %s\n", best.Name)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("json", false, "Output as JSON")
+
+	return cmd
+}
+
+func storageLUNsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "luns",
+		Short: "List iSCSI LUNs usable as VM disks",
+		Long: `List the iSCSI LUNs configured on the QNAP device. Any of them can be
+attached to a new VM with 'create --disk lun=NAME'. LUNs still mapped to an
+iSCSI target must not be in use by an initiator at the same time.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+
+			// Connect to QNAP device
+			sshClient, _, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			luns, err := newStorageManager(sshClient, cfg).ListLUNs()
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(luns)
+			}
+
+			if len(luns) == 0 {
+				fmt.Println("No iSCSI LUNs found.")
+				return nil
+			}
+
+			fmt.Printf("%-20s %-6s %-45s %s\n", "NAME", "TYPE", "DEVICE", "MAPPED TO")
+			fmt.Printf("%-20s %-6s %-45s %s\n", "--------------------", "------", "---------------------------------------------", "---------")
+
+			for _, lun := range luns {
+				lunType := "file"
+				if lun.Block {
+					lunType = "block"
+				}
+				mapped := "-"
+				if len(lun.Targets) > 0 {
+					mapped = strings.Join(lun.Targets, ", ")
+				}
+				fmt.Printf("%-20s %-6s %-45s %s\n", lun.Name, lunType, lun.Device, mapped)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("json", false, "Output as JSON")
+
+	return cmd
+}
+
+func storageGCCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete orphaned disk images",
+		Long: `Find qcow2 images in each pool's .qnap-vm/disks directory that no defined
+VM uses, plus stale temporary domain XML files, and delete them.
+
+Images that back a disk in use (linked clone bases) are kept. Use --dry-run
+to only list what would be deleted.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			force, _ := cmd.Flags().GetBool("force")
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			storageManager := newStorageManager(sshClient, cfg)
+			orphans, staleXML, err := findGCCandidates(sshClient, virshClient, storageManager)
+			if err != nil {
+				return err
+			}
+
+			if len(orphans) == 0 && len(staleXML) == 0 {
+				fmt.Println("No orphaned disks or stale files found.")
+				return nil
+			}
+
+			var total int64
+			if len(orphans) > 0 {
+				fmt.Printf("%-70s %-20s %-10s %-20s\n", "ORPHANED DISK", "POOL", "SIZE", "MODIFIED")
+				fmt.Printf("%-70s %-20s %-10s %-20s\n", strings.Repeat("-", 70), "--------------------", "----------", "--------------------")
+				for _, image := range orphans {
+					total += image.Size
+					fmt.Printf("%-70s %-20s %-10s %-20s\n",
+						image.Path, image.Pool, formatBytes(image.Size), image.Modified.Format("2006-01-02 15:04:05"))
+				}
+				fmt.Println()
+			}
+			for _, xmlPath := range staleXML {
+				fmt.Printf("Stale temporary file: %s\n", xmlPath)
+			}
+
+			if dryRun {
+				fmt.Printf("\n%d orphaned disk(s) (%s) and %d stale file(s) would be deleted\n", len(orphans), formatBytes(total), len(staleXML))
+				return nil
+			}
+
+			// Confirmation unless force is used
+			if !force {
+				confirmed, err := confirm(fmt.Sprintf("Delete %d orphaned disk(s) (%s) and %d stale file(s) on %s? This cannot be undone. (y/N): ", len(orphans), formatBytes(total), len(staleXML), cfg.Label()))
+				if err != nil {
+					return err
+				}
+				if !confirmed {
+					messages.Println(messages.Cancelled)
+					return nil
+				}
+			}
+
+			for _, image := range orphans {
+				if err := storageManager.RemoveDisk(image.Path); err != nil {
+					return err
+				}
+			}
+			for _, xmlPath := range staleXML {
+				if err := storageManager.RemoveDisk(xmlPath); err != nil {
+					return err
+				}
+			}
+
+			statusf("Deleted %d orphaned disk(s), freeing %s, and %d stale file(s)\n", len(orphans), formatBytes(total), len(staleXML))
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("dry-run", false, "List orphans without deleting them")
+	cmd.Flags().BoolP("force", "f", false, "Delete without confirmation")
+
+	return cmd
+}
+
+// findGCCandidates returns disk images no VM uses and stale temporary domain XML files
+func findGCCandidates(sshClient *ssh.Client, virshClient *virsh.Client, storageManager *storage.Manager) ([]storage.DiskImage, []string, error) {
+	sources, err := virshClient.ListDiskSources()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inUse := make(map[string]bool)
+	seenVMs := make(map[string]bool)
+	var vmNames []string
+	for source, vmName := range sources {
+		inUse[source] = true
+		if !seenVMs[vmName] {
+			seenVMs[vmName] = true
+			vmNames = append(vmNames, vmName)
+		}
+	}
+
+	orphans, err := storageManager.FindOrphanedDisks(inUse)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pools, err := storageManager.DetectPools()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to detect storage pools: %w", err)
+	}
+	var poolPaths []string
+	for _, pool := range pools {
+		poolPaths = append(poolPaths, pool.Path)
+	}
+
+	leftovers, err := nas.NewManager(sshClient).FindLeftovers(poolPaths, vmNames, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	var staleXML []string
+	for _, item := range leftovers {
+		if item.Kind == nas.LeftoverTempXML {
+			staleXML = append(staleXML, item.Path)
+		}
+	}
+
+	return orphans, staleXML, nil
+}
+
+// addPlacementFlag adds the --placement strategy flag for choosing a new VM's pool
+func addPlacementFlag(cmd *cobra.Command, usage string) {
+	cmd.Flags().String("placement", "", usage+" (best, most-free, spread or affinity)")
+}
+
+// placementStrategy returns the --placement strategy, falling back to the host's
+// configured default and then to best
+func placementStrategy(cmd *cobra.Command, cfg *config.Config) (string, error) {
+	strategy, _ := cmd.Flags().GetString("placement")
+	if strategy == "" {
+		strategy = cfg.Placement
+	}
+	if strategy == "" {
+		strategy = storage.PlacementBest
+	}
+	return strategy, storage.ValidatePlacement(strategy)
+}
+
+// placePool chooses the pool for a new VM with tags using strategy. Only spread and
+// affinity need to know where the existing VMs are.
+func placePool(storageManager *storage.Manager, virshClient *virsh.Client, strategy string, tags []string) (*storage.Pool, error) {
+	var vms []storage.PlacedVM
+	if strategy == storage.PlacementSpread || strategy == storage.PlacementAffinity {
+		infos, err := virshClient.ListVMsWithMetadata()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list VMs: %w", err)
+		}
+		sources, err := virshClient.ListDiskSources()
+		if err != nil {
+			return nil, err
+		}
+
+		disks := make(map[string][]string)
+		for diskPath, vmName := range sources {
+			// CD-ROM images do not tie a VM to a pool
+			if !strings.HasSuffix(strings.ToLower(diskPath), ".iso") {
+				disks[vmName] = append(disks[vmName], diskPath)
+			}
+		}
+		for _, info := range infos {
+			vms = append(vms, storage.PlacedVM{Tags: info.Tags, Disks: disks[info.Name]})
+		}
+	}
+
+	return storageManager.PlacePool(strategy, vms, tags)
+}
+
+// addSpaceCheckFlag adds the --ignore-space-check escape hatch for the free-space pre-flight check
+func addSpaceCheckFlag(cmd *cobra.Command) {
+	cmd.Flags().Bool("ignore-space-check", false, "Proceed even if the storage pool looks too full")
+}
+
+// checkFreeSpace aborts when reqs do not fit in their pools, unless --ignore-space-check is set
+func checkFreeSpace(cmd *cobra.Command, reqs []storage.SpaceRequirement) error {
+	err := storage.CheckSpace(reqs)
+	if err == nil {
+		return nil
+	}
+
+	if ignore, _ := cmd.Flags().GetBool("ignore-space-check"); ignore {
+		fmt.Fprintf(os.Stderr, "Warning: %v (continuing because of --ignore-space-check)\n", err)
+		return nil
+	}
+
+	return fmt.Errorf("%w\nFree up space, pick another pool, or pass --ignore-space-check to try anyway", err)
+}
+
+// diskSpaceRequirements returns the space needed to copy each disk file into pool,
+// or next to the source file when pool is nil
+func diskSpaceRequirements(storageManager *storage.Manager, diskPaths []string, pool *storage.Pool) ([]storage.SpaceRequirement, error) {
+	pools, err := storageManager.DetectPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect storage pools: %w", err)
+	}
+
+	var reqs []storage.SpaceRequirement
+	for _, diskPath := range diskPaths {
+		if diskPath == "" {
+			// Block devices such as iSCSI LUNs are not copied into a pool
+			continue
+		}
+		target := pool
+		if target == nil {
+			target = storage.PoolForPath(pools, diskPath)
+		}
+
+		size, err := storageManager.AllocatedBytes([]string{diskPath})
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, storage.SpaceRequirement{Pool: target, Bytes: size})
+	}
+
+	return reqs, nil
+}
+
+// newStorageManager creates a storage manager that also knows the pools declared for the host
+func newStorageManager(sshClient *ssh.Client, cfg *config.Config) *storage.Manager {
+	storageManager := storage.NewManager(sshClient)
+
+	var remotePools []storage.RemotePool
+	for _, pool := range cfg.Pools {
+		remotePools = append(remotePools, storage.RemotePool{Name: pool.Name, Path: pool.Path, Type: pool.Type})
+	}
+	storageManager.SetRemotePools(remotePools)
+
+	return storageManager
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/scttfrdmn/qnap-vm/pkg/support"
+	"github.com/spf13/cobra"
+)
+
+// sessionTranscript records the remote commands of this invocation for support bundles
+var sessionTranscript = ssh.NewTranscript()
+
+// lastSessionFile holds the transcript of the previous invocation, in the data directory
+const lastSessionFile = "last-session.log"
+
+func supportBundleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "support-bundle",
+		Short: "Collect diagnostics for a bug report",
+		Long: `Gather versions (qnap-vm, QTS, Virtualization Station, libvirt, QEMU), host
+capabilities, recent libvirt and VM logs, the local configuration and the
+transcript of the previous qnap-vm command into a tarball to attach to an
+issue.
+
+Run it right after the command that failed: every command saves its remote
+command transcript to ~/.qnap-vm/last-session.log. Passwords, private keys
+and token-like values are masked, and the NAS address is replaced with
+<nas> unless --keep-host is set. Review the archive before sharing it.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			output, _ := cmd.Flags().GetString("output")
+			logLines, _ := cmd.Flags().GetInt("log-lines")
+			keepHost, _ := cmd.Flags().GetBool("keep-host")
+			offline, _ := cmd.Flags().GetBool("offline")
+
+			stamp := time.Now().Format("20060102-150405")
+			if output == "" {
+				output = fmt.Sprintf("qnap-vm-support-%s.tar.gz", stamp)
+			}
+
+			bundle := support.NewBundle()
+			bundle.Add("bundle-info.txt", fmt.Sprintf("qnap-vm %s (commit %s, built %s)\nGo: %s %s/%s\nCreated: %s\n",
+				version, commit, date, runtime.Version(), runtime.GOOS, runtime.GOARCH, time.Now().Format(time.RFC3339)))
+
+			configPath, err := config.GetConfigPath()
+			if err == nil {
+				if data, err := os.ReadFile(configPath); err == nil {
+					bundle.Add("local/config.yaml", string(data))
+				}
+			}
+			if dataDir, err := config.GetDataDir(); err == nil {
+				if data, err := os.ReadFile(filepath.Join(dataDir, lastSessionFile)); err == nil {
+					bundle.Add("local/last-session.log", string(data))
+				}
+			}
+
+			var password, host string
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				bundle.Add("local/config-error.txt", err.Error()+"\n")
+			} else {
+				password, host = cfg.Password, cfg.Host
+				bundle.Add("local/effective-config.txt", cfg.Describe()+"\n")
+
+				if !offline {
+					statusf("Collecting diagnostics from %s...\n", cfg.Label())
+					collectRemoteDiagnostics(bundle, cfg, logLines)
+				}
+			}
+
+			sanitizer := support.NewSanitizer(password)
+			if home, err := os.UserHomeDir(); err == nil {
+				sanitizer.Replace(home, "~")
+			}
+			if !keepHost {
+				sanitizer.Replace(host, "<nas>")
+			}
+
+			if err := bundle.WriteArchive(output, sanitizer); err != nil {
+				return err
+			}
+
+			statusf("Support bundle written to %s (%d files)\n", output, len(bundle.Files()))
+			statusln("Secrets are masked, but please review the contents before attaching it to an issue.")
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "", "Archive to write (default: qnap-vm-support-TIMESTAMP.tar.gz)")
+	cmd.Flags().Int("log-lines", 200, "Number of lines to include from each log")
+	cmd.Flags().Bool("keep-host", false, "Keep the NAS address instead of replacing it with <nas>")
+	cmd.Flags().Bool("offline", false, "Only include local information; do not connect to the NAS")
+
+	return cmd
+}
+
+// collectRemoteDiagnostics adds host, libvirt and storage details to bundle. A connection
+// failure is recorded in the bundle, since it is often the problem being reported.
+func collectRemoteDiagnostics(bundle *support.Bundle, cfg *config.Config, logLines int) {
+	sshClient, virshClient, err := connectToQNAP(*cfg)
+	if err != nil {
+		bundle.Add("host/connection-error.txt", err.Error()+"\n")
+		return
+	}
+	defer func() {
+		if err := sshClient.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+		}
+	}()
+
+	for _, file := range support.CollectHost(sshClient) {
+		bundle.Add("host/"+file.Name, file.Content)
+	}
+	for _, diagnostic := range virshClient.Diagnostics() {
+		bundle.Add("libvirt/"+diagnostic.Name, diagnostic.Output)
+	}
+
+	if logs, err := virshClient.Logs(logLines); err != nil {
+		bundle.Add("logs/error.txt", err.Error()+"\n")
+	} else {
+		for _, log := range logs {
+			bundle.Add("logs/"+log.Name, log.Output)
+		}
+	}
+
+	if pools, err := newStorageManager(sshClient, cfg).DetectPools(); err != nil {
+		bundle.Add("host/pools-error.txt", err.Error()+"\n")
+	} else if data, err := json.MarshalIndent(pools, "", "  "); err == nil {
+		bundle.Add("host/pools.json", string(data)+"\n")
+	}
+
+	var transcript strings.Builder
+	if _, err := sessionTranscript.WriteTo(&transcript); err == nil {
+		bundle.Add("local/support-bundle-session.log", transcript.String())
+	}
+}
+
+// saveSessionTranscript writes this invocation's command line, result and remote commands
+// to the last-session file so a later support bundle can include them
+func saveSessionTranscript(args []string, runErr error) {
+	if len(args) > 0 && (args[0] == "support-bundle" || args[0] == cobra.ShellCompRequestCmd || args[0] == cobra.ShellCompNoDescRequestCmd) {
+		// Keep the transcript of the command being reported, not of a tab completion
+		return
+	}
+	if runErr == nil && len(sessionTranscript.Entries()) == 0 {
+		return
+	}
+
+	dataDir, err := config.GetDataDir()
+	if err != nil {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# qnap-vm %s\n# time: %s\n# command: qnap-vm %s\n", version, time.Now().Format(time.RFC3339), strings.Join(args, " "))
+	if runErr != nil {
+		fmt.Fprintf(&b, "# error: %s\n", strings.ReplaceAll(runErr.Error(), "\n", "\n#   "))
+	}
+	b.WriteString("\n")
+	if _, err := sessionTranscript.WriteTo(&b); err != nil {
+		return
+	}
+
+	// The transcript is a debugging aid; failing to save it must not affect the command
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, lastSessionFile), []byte(b.String()), 0600); err != nil {
+		return
+	}
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func tagCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tag [VM_NAME]",
+		Short: "Set a VM's title and tags",
+		Long: `Set the title and tags shown in 'qnap-vm list'. Tags are stored in the
+VM definition's qnap-vm metadata; the title uses the libvirt <title> element.
+Without flags, the current title and tags are shown.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			vmName := args[0]
+			addTags, _ := cmd.Flags().GetStringSlice("add")
+			removeTags, _ := cmd.Flags().GetStringSlice("remove")
+			clearTags, _ := cmd.Flags().GetBool("clear")
+			setTitle := cmd.Flags().Changed("title")
+			title, _ := cmd.Flags().GetString("title")
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			tags, err := virshClient.GetTags(vmName)
+			if err != nil {
+				return err
+			}
+
+			if len(addTags) == 0 && len(removeTags) == 0 && !clearTags && !setTitle {
+				domain, err := virshClient.GetDomain(vmName)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%-15s: %s\n", "Title", domain.Title)
+				fmt.Printf("%-15s: %s\n", "Tags", strings.Join(tags, ","))
+				return nil
+			}
+
+			if setTitle {
+				if err := virshClient.SetTitle(vmName, title); err != nil {
+					return err
+				}
+				statusf("Title for VM '%s' set to '%s'\n", vmName, title)
+			}
+
+			if len(addTags) == 0 && len(removeTags) == 0 && !clearTags {
+				return nil
+			}
+
+			if clearTags {
+				tags = nil
+			}
+			tags = virsh.NormalizeTags(append(tags, addTags...))
+			tags = removeStrings(tags, removeTags)
+
+			if err := virshClient.SetTags(vmName, tags); err != nil {
+				return err
+			}
+
+			if len(tags) == 0 {
+				statusf("Tags removed from VM '%s'\n", vmName)
+			} else {
+				statusf("Tags for VM '%s': %s\n", vmName, strings.Join(tags, ","))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("title", "", "Set the VM title (empty string removes it)")
+	cmd.Flags().StringSlice("add", nil, "Tags to add (repeatable or comma-separated)")
+	cmd.Flags().StringSlice("remove", nil, "Tags to remove (repeatable or comma-separated)")
+	cmd.Flags().Bool("clear", false, "Remove all existing tags before adding")
+
+	return cmd
+}
+
+// removeStrings returns values without any entry in remove
+func removeStrings(values, remove []string) []string {
+	drop := make(map[string]bool)
+	for _, r := range remove {
+		drop[strings.TrimSpace(r)] = true
+	}
+
+	var kept []string
+	for _, v := range values {
+		if !drop[v] {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,464 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+	"github.com/spf13/cobra"
+)
+
+func tuneCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tune",
+		Short: "Tune VM performance settings",
+		Long:  "Tune how a VM uses the NAS's CPUs and memory.",
+	}
+
+	cmd.AddCommand(tuneCPUPinCmd())
+	cmd.AddCommand(tuneMemoryCmd())
+	cmd.AddCommand(tuneNetCmd())
+	return cmd
+}
+
+func tuneCPUPinCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cpupin VM_NAME [VCPU:CPUSET...]",
+		Short: "Pin a VM's vCPUs to host CPUs",
+		Long: `Pin vCPUs to host CPUs, so latency-sensitive guests don't compete with QTS
+services for the same cores. Each VCPU:CPUSET pins one vCPU, e.g. 0:2 or 1:4-5;
+--cpuset pins every vCPU to the same CPUs. Pins are saved in the VM's
+configuration and applied at once if it is running.
+
+Without pins, the current pinning is shown.`,
+		Example: `  qnap-vm tune cpupin homeassistant 0:2 1:3
+  qnap-vm tune cpupin plex --cpuset 4-7
+  qnap-vm tune cpupin plex`,
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			vmName := args[0]
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			vm, err := virshClient.GetVMDetails(vmName)
+			if err != nil {
+				return err
+			}
+			running := vm.State == "running"
+
+			pins, err := cpuPinsFromFlags(cmd, args[1:], vm.CPUs)
+			if err != nil {
+				return err
+			}
+
+			if len(pins) == 0 {
+				current, err := virshClient.VCPUPins(vmName, running)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%-6s %s\n", "VCPU", "HOST CPUS")
+				fmt.Printf("%-6s %s\n", "----", "---------")
+				for _, pin := range current {
+					fmt.Printf("%-6d %s\n", pin.VCPU, pin.CPUSet)
+				}
+				return nil
+			}
+
+			if err := validateHostCPUPins(virshClient, pins, vm.CPUs); err != nil {
+				return err
+			}
+			for _, pin := range pins {
+				if err := virshClient.PinVCPU(vmName, pin, running); err != nil {
+					return err
+				}
+				statusf("Pinned vCPU %d of VM '%s' to host CPUs %s\n", pin.VCPU, vmName, pin.CPUSet)
+			}
+			if !running {
+				statusln("The pinning applies from the VM's next start.")
+			}
+			return nil
+		},
+	}
+
+	addCPUPinFlags(cmd)
+	return cmd
+}
+
+func tuneMemoryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "memory VM_NAME",
+		Short: "Limit a VM's memory use on the NAS",
+		Long: `Set memtune limits so a runaway guest cannot exhaust the NAS's memory. With
+--hard-limit the NAS kills the VM rather than let QEMU use more; it must be above
+the VM's memory to leave room for QEMU's own overhead. With --soft-limit the NAS
+reclaims the VM's memory down to that size when it runs short. Limits take MB or
+"unlimited", are saved in the VM's configuration and applied at once if it is
+running.
+
+--autodeflate on lets the balloon device give memory back to the guest before it
+runs out, instead of the guest's own out-of-memory killer stepping in; it applies
+from the VM's next start.
+
+Without flags, the current settings are shown.`,
+		Example: `  qnap-vm tune memory plex --hard-limit 9216 --soft-limit 6144
+  qnap-vm tune memory homeassistant --autodeflate on
+  qnap-vm tune memory plex`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			vmName := args[0]
+			autodeflate, _ := cmd.Flags().GetString("autodeflate")
+			if autodeflate != "" && autodeflate != "on" && autodeflate != "off" {
+				return fmt.Errorf("invalid --autodeflate value %q (expected on or off)", autodeflate)
+			}
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			vm, err := virshClient.GetVMDetails(vmName)
+			if err != nil {
+				return err
+			}
+			running := vm.State == "running"
+
+			tune, err := virshClient.MemoryTune(vmName, false)
+			if err != nil {
+				return err
+			}
+			limitsChanged := false
+			for _, limit := range []struct {
+				flag string
+				mb   *int
+			}{{"hard-limit", &tune.HardLimitMB}, {"soft-limit", &tune.SoftLimitMB}} {
+				if !cmd.Flags().Changed(limit.flag) {
+					continue
+				}
+				value, _ := cmd.Flags().GetString(limit.flag)
+				if *limit.mb, err = virsh.ParseMemoryLimit(value); err != nil {
+					return err
+				}
+				limitsChanged = true
+			}
+
+			if !limitsChanged && autodeflate == "" {
+				deflate, err := virshClient.BalloonAutodeflate(vmName)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Memory:              %d MB\n", vm.Memory)
+				fmt.Printf("Hard limit:          %s\n", memoryLimit(tune.HardLimitMB))
+				fmt.Printf("Soft limit:          %s\n", memoryLimit(tune.SoftLimitMB))
+				state := "off"
+				if deflate {
+					state = "on"
+				}
+				fmt.Printf("Balloon autodeflate: %s\n", state)
+				return nil
+			}
+
+			if limitsChanged {
+				if err := virsh.ValidateMemTune(*tune, vm.Memory); err != nil {
+					return err
+				}
+				if err := virshClient.SetMemoryTune(vmName, *tune, running); err != nil {
+					return err
+				}
+				statusf("Set memory limits of VM '%s': hard %s, soft %s\n", vmName, memoryLimit(tune.HardLimitMB), memoryLimit(tune.SoftLimitMB))
+			}
+			if autodeflate != "" {
+				if err := virshClient.SetBalloonAutodeflate(vmName, autodeflate == "on"); err != nil {
+					return err
+				}
+				statusf("Turned balloon autodeflate %s for VM '%s'\n", autodeflate, vmName)
+				if running {
+					statusln("Autodeflate applies from the VM's next start.")
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("hard-limit", "", "Most memory QEMU may use, in MB or unlimited")
+	cmd.Flags().String("soft-limit", "", "Memory the NAS reclaims down to when it runs short, in MB or unlimited")
+	cmd.Flags().String("autodeflate", "", "Let the balloon give memory back to the guest before it runs out (on or off)")
+	return cmd
+}
+
+func tuneNetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "net VM_NAME INTERFACE",
+		Short: "Limit a VM network card's bandwidth",
+		Long: `Set the average bandwidth of a VM's network card in each direction, so a single
+chatty VM cannot saturate the NAS's uplink. Inbound is traffic to the guest,
+outbound traffic from it.
+
+INTERFACE is a MAC address, an index from 'qnap-vm nic list' or the card's vnet
+device while the VM runs. Rates take bit units (kbit, mbit, gbit), byte units
+(KB, MB, GB, per second), a number of KiB/s or "unlimited". Limits are saved in
+the VM's configuration and applied at once if it is running.
+
+Without flags, the current limits are shown.`,
+		Example: `  qnap-vm tune net torrent vnet3 --inbound 50mbit --outbound 50mbit
+  qnap-vm tune net backup 0 --outbound unlimited
+  qnap-vm tune net torrent 52:54:00:3c:9a:12`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeVM,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			vmName := args[0]
+			var inbound, outbound *int
+			for _, limit := range []struct {
+				flag string
+				kib  **int
+			}{{"inbound", &inbound}, {"outbound", &outbound}} {
+				if !cmd.Flags().Changed(limit.flag) {
+					continue
+				}
+				value, _ := cmd.Flags().GetString(limit.flag)
+				kib, err := virsh.ParseRate(value)
+				if err != nil {
+					return err
+				}
+				*limit.kib = &kib
+			}
+
+			// Connect to QNAP device
+			sshClient, virshClient, err := connectToQNAP(*cfg)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := sshClient.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close SSH connection: %v\n", err)
+				}
+			}()
+
+			vm, err := virshClient.GetVM(vmName)
+			if err != nil {
+				return err
+			}
+			running := vm.State == "running"
+
+			mac, err := virshClient.ResolveInterface(vmName, args[1])
+			if err != nil {
+				return err
+			}
+
+			if inbound == nil && outbound == nil {
+				bandwidth, err := virshClient.InterfaceBandwidth(vmName, mac, running)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Interface: %s\n", mac)
+				fmt.Printf("Inbound:   %s\n", virsh.FormatRate(bandwidth.InboundKiB))
+				fmt.Printf("Outbound:  %s\n", virsh.FormatRate(bandwidth.OutboundKiB))
+				return nil
+			}
+
+			if err := virshClient.SetInterfaceBandwidth(vmName, mac, inbound, outbound, running); err != nil {
+				return err
+			}
+			if inbound != nil {
+				statusf("Set inbound bandwidth of %s on VM '%s' to %s\n", mac, vmName, virsh.FormatRate(*inbound))
+			}
+			if outbound != nil {
+				statusf("Set outbound bandwidth of %s on VM '%s' to %s\n", mac, vmName, virsh.FormatRate(*outbound))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("inbound", "", "Average rate of traffic to the guest, e.g. 50mbit or unlimited")
+	cmd.Flags().String("outbound", "", "Average rate of traffic from the guest, e.g. 50mbit or unlimited")
+	return cmd
+}
+
+// memoryLimit formats a memtune limit in MB
+func memoryLimit(mb int) string {
+	if mb == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d MB", mb)
+}
+
+// addCPUPinFlags adds the vCPU pinning flags
+func addCPUPinFlags(cmd *cobra.Command) {
+	cmd.Flags().String("cpuset", "", "Pin every vCPU to these host CPUs, e.g. 2-5 or 0-7,^1")
+	cmd.Flags().StringArray("cpupin", nil, "Pin one vCPU as VCPU:CPUSET, e.g. 0:2 (repeatable; overrides --cpuset for that vCPU)")
+}
+
+// cpuPinsFromFlags returns the pins given by --cpuset, --cpupin and VCPU:CPUSET specs for
+// a VM with cpus vCPUs, ordered by vCPU. A vCPU pinned on its own overrides --cpuset.
+func cpuPinsFromFlags(cmd *cobra.Command, specs []string, cpus int) ([]virsh.CPUPin, error) {
+	cpuset, _ := cmd.Flags().GetString("cpuset")
+	cpupins, _ := cmd.Flags().GetStringArray("cpupin")
+
+	cpusets := make(map[int]string)
+	if cpuset != "" {
+		if _, err := virsh.ParseCPUSet(cpuset); err != nil {
+			return nil, err
+		}
+		for vcpu := 0; vcpu < cpus; vcpu++ {
+			cpusets[vcpu] = cpuset
+		}
+	}
+
+	var single []virsh.CPUPin
+	for _, spec := range append(cpupins, specs...) {
+		pin, err := virsh.ParseCPUPin(spec)
+		if err != nil {
+			return nil, err
+		}
+		single = append(single, pin)
+	}
+	if err := virsh.ValidateCPUPins(single, cpus, 0); err != nil {
+		return nil, err
+	}
+	for _, pin := range single {
+		cpusets[pin.VCPU] = pin.CPUSet
+	}
+
+	var pins []virsh.CPUPin
+	for vcpu := 0; vcpu < cpus; vcpu++ {
+		if set, ok := cpusets[vcpu]; ok {
+			pins = append(pins, virsh.CPUPin{VCPU: vcpu, CPUSet: set})
+		}
+	}
+	return pins, nil
+}
+
+// validateHostCPUPins checks pins against the NAS's CPUs
+func validateHostCPUPins(virshClient *virsh.Client, pins []virsh.CPUPin, cpus int) error {
+	if len(pins) == 0 {
+		return nil
+	}
+	hostCPUs, err := virshClient.HostCPUCount()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v; not checking the pinned CPUs exist\n", err)
+	}
+	return virsh.ValidateCPUPins(pins, cpus, hostCPUs)
+}
+
+// validateHostNUMA checks a NUMA layout's host nodes against the NAS's
+func validateHostNUMA(virshClient *virsh.Client, numa *virsh.NUMAConfig, cpus, memory int) error {
+	if numa == nil || numa.Nodeset == "" {
+		return nil
+	}
+	hostNodes, err := virshClient.HostNUMANodes()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v; not checking the NUMA nodes exist\n", err)
+	}
+	return virsh.ValidateNUMAConfig(*numa, cpus, memory, hostNodes)
+}
+
+// enableNested checks the NAS supports nested virtualization. Turning it on reloads the
+// KVM module for the whole NAS, so that is refused while any VM is active and otherwise
+// only done once confirmed (or with --yes).
+func enableNested(virshClient *virsh.Client) (*virsh.NestedSupport, error) {
+	support, err := virshClient.NestedVirtualization()
+	if err != nil {
+		return nil, err
+	}
+	if support.Enabled {
+		return support, nil
+	}
+
+	vms, err := virshClient.ListVMs()
+	if err != nil {
+		return nil, err
+	}
+	var active []string
+	for _, vm := range vms {
+		if vm.ID > 0 {
+			active = append(active, vm.Name)
+		}
+	}
+	if len(active) > 0 {
+		return nil, fmt.Errorf("nested virtualization is off in %s, and turning it on reloads the module, which needs every VM shut off (active: %s)", support.Module, strings.Join(active, ", "))
+	}
+
+	confirmed, err := confirm(fmt.Sprintf("Nested virtualization is off. Reload %s with nesting on until the NAS restarts? (y/N): ", support.Module))
+	if err != nil {
+		return nil, err
+	}
+	if !confirmed {
+		return nil, fmt.Errorf("nested virtualization is not enabled in %s", support.Module)
+	}
+
+	statusf("Enabling nested virtualization in %s...\n", support.Module)
+	if err := virshClient.EnableNestedVirtualization(support); err != nil {
+		return nil, err
+	}
+	statusln("Nested virtualization stays enabled until the NAS restarts.")
+	return support, nil
+}
+
+// checkHugePages checks the NAS has enough free huge pages for a VM with memory MB,
+// reserving the missing ones when reserve is set
+func checkHugePages(virshClient *virsh.Client, memory int, reserve bool) error {
+	pages, err := virshClient.HostHugePages()
+	if err != nil {
+		return err
+	}
+	if pages.SizeKiB == 0 {
+		return fmt.Errorf("the NAS kernel does not support huge pages")
+	}
+	if !pages.Mounted {
+		fmt.Fprintf(os.Stderr, "Warning: hugetlbfs is not mounted on the NAS; the VM may fail to start\n")
+	}
+
+	needed := pages.PagesFor(memory)
+	if pages.Free >= needed {
+		statusf("Using %d of %d free huge pages of %d KiB\n", needed, pages.Free, pages.SizeKiB)
+		return nil
+	}
+	if !reserve {
+		return fmt.Errorf("the NAS has %d free huge pages of %d KiB but the VM needs %d; pass --reserve-hugepages to reserve them", pages.Free, pages.SizeKiB, needed)
+	}
+
+	// The kernel may not find enough contiguous memory, so check what it reserved
+	if err := virshClient.ReserveHugePages(pages.Total + needed - pages.Free); err != nil {
+		return err
+	}
+	if pages, err = virshClient.HostHugePages(); err != nil {
+		return err
+	}
+	if pages.Free < needed {
+		return fmt.Errorf("only %d huge pages of %d KiB could be reserved but the VM needs %d; free some memory or reserve them at boot", pages.Free, pages.SizeKiB, needed)
+	}
+	statusf("Reserved huge pages on the NAS: %d of %d KiB (until the NAS restarts)\n", pages.Total, pages.SizeKiB)
+	return nil
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/update"
+	"github.com/spf13/cobra"
+)
+
+// releaseRepo is the GitHub repository self-update installs releases from
+const releaseRepo = "scttfrdmn/qnap-vm"
+
+func selfUpdateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "self-update",
+		Short: "Update qnap-vm to the latest release",
+		Long: `Download the latest qnap-vm release from GitHub and replace this binary.
+
+The archive is checked against the release's checksums.txt. Official builds
+also verify the Ed25519 signature of checksums.txt and refuse unsigned
+releases. Installations managed by Homebrew or another package manager should
+be updated with that package manager instead.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			checkOnly, _ := cmd.Flags().GetBool("check")
+			force, _ := cmd.Flags().GetBool("force")
+
+			if updateDisabled != "" {
+				return fmt.Errorf("self-update is disabled in this build (%s package); update qnap-vm with your package manager", updateDisabled)
+			}
+
+			exePath, err := executablePath()
+			if err != nil {
+				return err
+			}
+			if managed := packageManagerFor(exePath); managed != "" && !checkOnly {
+				return fmt.Errorf("qnap-vm was installed with %s; update it with %s", managed, packageManagerUpgrade[managed])
+			}
+
+			updater := update.NewUpdater(releaseRepo)
+			release, err := updater.LatestRelease()
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("%-15s: %s\n", "Current version", version)
+			fmt.Printf("%-15s: %s\n", "Latest release", release.Version())
+
+			if !update.IsRelease(version) && !force {
+				fmt.Println("This is a development build; use --force to replace it with the latest release")
+				return nil
+			}
+			if update.IsRelease(version) && update.CompareVersions(version, release.Version()) >= 0 && !force {
+				fmt.Println("qnap-vm is up to date")
+				return nil
+			}
+			if checkOnly {
+				fmt.Println("An update is available; run 'qnap-vm self-update' to install it")
+				return nil
+			}
+
+			data, err := downloadVerifiedRelease(updater, release)
+			if err != nil {
+				return err
+			}
+
+			if err := update.ReplaceExecutable(exePath, data); err != nil {
+				return err
+			}
+			statusf("Updated %s to %s\n", exePath, release.Version())
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("check", false, "Only report whether an update is available")
+	cmd.Flags().Bool("force", false, "Install the latest release even if it is not newer")
+
+	return cmd
+}
+
+// downloadVerifiedRelease downloads this platform's archive, verifies it and returns the binary
+func downloadVerifiedRelease(updater *update.Updater, release *update.Release) ([]byte, error) {
+	archiveName := update.CurrentArchiveName(release.Version())
+	archiveAsset := release.Asset(archiveName)
+	if archiveAsset == nil {
+		return nil, fmt.Errorf("release %s has no build for this platform (%s)", release.Version(), archiveName)
+	}
+	checksumsAsset := release.Asset(update.ChecksumsAsset)
+	if checksumsAsset == nil {
+		return nil, fmt.Errorf("release %s has no %s; refusing to install an unverified binary", release.Version(), update.ChecksumsAsset)
+	}
+
+	checksums, err := updater.Download(checksumsAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	if updatePublicKey != "" {
+		signatureAsset := release.Asset(update.SignatureAsset)
+		if signatureAsset == nil {
+			return nil, fmt.Errorf("release %s is not signed; refusing to install it", release.Version())
+		}
+		signature, err := updater.Download(signatureAsset)
+		if err != nil {
+			return nil, err
+		}
+		if err := update.VerifySignature(checksums, signature, updatePublicKey); err != nil {
+			return nil, fmt.Errorf("%s of release %s: %w", update.ChecksumsAsset, release.Version(), err)
+		}
+		statusln("Signature verified")
+	} else {
+		fmt.Fprintln(os.Stderr, "Warning: this build has no update signing key; verifying checksums only")
+	}
+
+	statusf("Downloading %s (%s)...\n", archiveName, formatBytes(archiveAsset.Size))
+	archive, err := updater.Download(archiveAsset)
+	if err != nil {
+		return nil, err
+	}
+	if err := update.VerifyChecksum(checksums, archiveName, archive); err != nil {
+		return nil, err
+	}
+	statusln("Checksum verified")
+
+	return update.ExtractBinary(archiveName, archive)
+}
+
+// executablePath returns the real path of the running binary
+func executablePath() (string, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the qnap-vm binary: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exePath); err == nil {
+		exePath = resolved
+	}
+	return exePath, nil
+}
+
+// packageManagerUpgrade is the update command for each detected package manager
+var packageManagerUpgrade = map[string]string{
+	"Homebrew": "'brew upgrade qnap-vm'",
+	"Scoop":    "'scoop update qnap-vm'",
+}
+
+// packageManagerFor returns the package manager that owns exePath, or "" if none does
+func packageManagerFor(exePath string) string {
+	slashed := filepath.ToSlash(exePath)
+	switch {
+	case strings.Contains(slashed, "/Cellar/") || strings.Contains(slashed, "/homebrew/") || strings.Contains(slashed, "/.linuxbrew/"):
+		return "Homebrew"
+	case strings.Contains(strings.ToLower(slashed), "/scoop/apps/"):
+		return "Scoop"
+	}
+	return ""
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/scttfrdmn/qnap-vm/cmd"
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
 )
 
 var (
@@ -18,7 +19,7 @@ func main() {
 	cmd.SetVersionInfo(version, commit, date)
 
 	if err := cmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, messages.FormatError(err))
+		os.Exit(cmd.ExitCode(err))
 	}
 }
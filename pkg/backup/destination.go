@@ -0,0 +1,619 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// Destination stores backup sets
+type Destination interface {
+	// Location describes where a set is stored, for messages
+	Location(set string) string
+	// CreateSet prepares an empty backup set
+	CreateSet(set string) error
+	// CopyDisk copies an image from the NAS into the set
+	CopyDisk(set, name, sourcePath string, opts ssh.TransferOptions) (*ssh.TransferResult, error)
+	// FetchDisk restores a disk image from the set to destPath on the NAS, decompressing
+	// it if needed. The result's checksum is of the stored file, to compare with the manifest.
+	FetchDisk(set string, disk Disk, destPath string, opts ssh.TransferOptions) (*ssh.TransferResult, error)
+	// WriteFile stores a small file such as the manifest in the set
+	WriteFile(set, name string, data []byte) error
+	// ReadFile reads a file from a set
+	ReadFile(set, name string) ([]byte, error)
+	// ListSets returns the names of the sets at the destination
+	ListSets() ([]string, error)
+	// RemoveSet deletes a set, used to clean up after a failed backup
+	RemoveSet(set string) error
+}
+
+// ReadManifests returns the manifests of vmName's complete sets, or of every VM's
+// when vmName is empty, oldest first. Sets without a readable manifest are
+// incomplete and skipped.
+func ReadManifests(destination Destination, vmName string) ([]*Manifest, error) {
+	sets, err := destination.ListSets()
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []*Manifest
+	for _, set := range sets {
+		if vmName != "" && !strings.HasPrefix(set, vmName+"-") {
+			continue
+		}
+		data, err := destination.ReadFile(set, ManifestFile)
+		if err != nil {
+			continue
+		}
+		manifest, err := ParseManifest(data)
+		if err != nil || (vmName != "" && manifest.VM != vmName) {
+			continue
+		}
+		if manifest.Name == "" {
+			manifest.Name = set
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	sort.SliceStable(manifests, func(i, j int) bool {
+		return manifests[i].Created.Before(manifests[j].Created)
+	})
+	return manifests, nil
+}
+
+// ReadChain returns the manifests needed to restore set: the full backup it builds on
+// followed by each incremental set up to and including set
+func ReadChain(destination Destination, set string) ([]*Manifest, error) {
+	var chain []*Manifest
+	seen := make(map[string]bool)
+	for name := set; name != ""; {
+		if seen[name] {
+			return nil, fmt.Errorf("backup %s has a parent loop at %s", set, name)
+		}
+		seen[name] = true
+
+		data, err := destination.ReadFile(name, ManifestFile)
+		if err != nil {
+			if name == set {
+				return nil, fmt.Errorf("backup %s not found or incomplete in %s: %w", set, destination.Location(set), err)
+			}
+			return nil, fmt.Errorf("backup %s needs its parent %s, which is missing or incomplete: %w", set, name, err)
+		}
+		manifest, err := ParseManifest(data)
+		if err != nil {
+			return nil, fmt.Errorf("backup %s: %w", name, err)
+		}
+		if manifest.Name == "" {
+			manifest.Name = name
+		}
+		chain = append([]*Manifest{manifest}, chain...)
+		name = manifest.Parent
+	}
+	return chain, nil
+}
+
+// NASDestination stores backup sets in a directory on the NAS, so images never leave the device
+type NASDestination struct {
+	sshClient *ssh.Client
+	dir       string
+}
+
+// NewNASDestination creates a destination under dir on the NAS, e.g. /share/Backups
+func NewNASDestination(sshClient *ssh.Client, dir string) *NASDestination {
+	return &NASDestination{sshClient: sshClient, dir: strings.TrimSuffix(dir, "/")}
+}
+
+// Location returns the set's directory on the NAS
+func (d *NASDestination) Location(set string) string {
+	return path.Join(d.dir, set)
+}
+
+// CreateSet creates the set's directory
+func (d *NASDestination) CreateSet(set string) error {
+	dir := d.Location(set)
+	if output, err := d.sshClient.Execute(fmt.Sprintf("mkdir -p %s", ssh.Quote(dir))); err != nil {
+		return fmt.Errorf("failed to create %s: %w\nOutput: %s", dir, err, output)
+	}
+	return nil
+}
+
+// CopyDisk copies the image on the NAS, keeping it sparse where cp supports it. An image
+// already written into the set, e.g. by a backup job, is only checksummed.
+// Progress is not reported for copies that stay on the device.
+func (d *NASDestination) CopyDisk(set, name, sourcePath string, opts ssh.TransferOptions) (*ssh.TransferResult, error) {
+	dest := path.Join(d.Location(set), name)
+	src, dst := ssh.Quote(sourcePath), ssh.Quote(dest)
+
+	start := time.Now()
+	if sourcePath != dest {
+		copyCmd := fmt.Sprintf("cp --sparse=always %s %s 2>/dev/null || cp %s %s", src, dst, src, dst)
+		if output, err := d.sshClient.Execute(copyCmd); err != nil {
+			return nil, fmt.Errorf("failed to copy %s to %s: %w\nOutput: %s", sourcePath, dest, err, output)
+		}
+	}
+	result := &ssh.TransferResult{Duration: time.Since(start)}
+
+	output, err := d.sshClient.Execute(fmt.Sprintf("stat -c %%s %s && sha256sum %s", dst, dst))
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum %s: %w\nOutput: %s", dest, err, output)
+	}
+	if err := parseSizeAndSum(output, result); err != nil {
+		return nil, fmt.Errorf("failed to checksum %s: %w", dest, err)
+	}
+	return result, nil
+}
+
+// FetchDisk copies the image from the set to destPath, keeping it sparse where cp supports it
+func (d *NASDestination) FetchDisk(set string, disk Disk, destPath string, opts ssh.TransferOptions) (*ssh.TransferResult, error) {
+	file := path.Join(d.Location(set), disk.File)
+	src, dst := ssh.Quote(file), ssh.Quote(destPath)
+
+	// A compressed image is checksummed as stored; a copy is checksummed where it landed
+	copyCmd := fmt.Sprintf("cp --sparse=always %s %s 2>/dev/null || cp %s %s", src, dst, src, dst)
+	sumFile := dst
+	if disk.Compression == CompressionGzip {
+		copyCmd = fmt.Sprintf("gzip -d -c %s > %s", src, dst)
+		sumFile = src
+	}
+
+	start := time.Now()
+	if output, err := d.sshClient.Execute(copyCmd); err != nil {
+		return nil, fmt.Errorf("failed to copy %s to %s: %w\nOutput: %s", file, destPath, err, output)
+	}
+	result := &ssh.TransferResult{Duration: time.Since(start)}
+
+	output, err := d.sshClient.Execute(fmt.Sprintf("stat -c %%s %s && sha256sum %s", sumFile, sumFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum %s: %w\nOutput: %s", file, err, output)
+	}
+	if err := parseSizeAndSum(output, result); err != nil {
+		return nil, fmt.Errorf("failed to checksum %s: %w", file, err)
+	}
+	return result, nil
+}
+
+// parseSizeAndSum reads 'stat -c %s' and 'sha256sum' output into result
+func parseSizeAndSum(output string, result *ssh.TransferResult) error {
+	fields := strings.Fields(output)
+	if len(fields) < 2 {
+		return fmt.Errorf("unexpected output: %s", strings.TrimSpace(output))
+	}
+	if _, err := fmt.Sscan(fields[0], &result.Bytes); err != nil {
+		return fmt.Errorf("unexpected file size '%s'", fields[0])
+	}
+	result.SHA256 = fields[1]
+	return nil
+}
+
+// WriteFile writes a file into the set on the NAS
+func (d *NASDestination) WriteFile(set, name string, data []byte) error {
+	dest := path.Join(d.Location(set), name)
+	if output, err := d.sshClient.ExecuteWithInput(fmt.Sprintf("cat > %s", ssh.Quote(dest)), strings.NewReader(string(data))); err != nil {
+		return fmt.Errorf("failed to write %s: %w\nOutput: %s", dest, err, output)
+	}
+	return nil
+}
+
+// ReadFile reads a file from the set on the NAS
+func (d *NASDestination) ReadFile(set, name string) ([]byte, error) {
+	file := path.Join(d.Location(set), name)
+	output, err := d.sshClient.Execute(fmt.Sprintf("cat %s", ssh.Quote(file)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	return []byte(output), nil
+}
+
+// ListSets lists the directories under the destination on the NAS
+func (d *NASDestination) ListSets() ([]string, error) {
+	output, err := d.sshClient.Execute(fmt.Sprintf("cd %s 2>/dev/null && for f in *; do [ -d \"$f\" ] && echo \"$f\"; done; true", ssh.Quote(d.dir)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups in %s: %w\nOutput: %s", d.dir, err, output)
+	}
+
+	var sets []string
+	for _, line := range strings.Split(output, "\n") {
+		if set := strings.TrimSpace(line); set != "" {
+			sets = append(sets, set)
+		}
+	}
+	return sets, nil
+}
+
+// RemoveSet deletes the set's directory on the NAS
+func (d *NASDestination) RemoveSet(set string) error {
+	dir := d.Location(set)
+	if output, err := d.sshClient.Execute(fmt.Sprintf("rm -rf %s", ssh.Quote(dir))); err != nil {
+		return fmt.Errorf("failed to remove %s: %w\nOutput: %s", dir, err, output)
+	}
+	return nil
+}
+
+// LocalDestination stores backup sets in a directory on this workstation, downloading images over SFTP
+type LocalDestination struct {
+	sshClient *ssh.Client
+	dir       string
+}
+
+// NewLocalDestination creates a destination under dir on this machine
+func NewLocalDestination(sshClient *ssh.Client, dir string) *LocalDestination {
+	return &LocalDestination{sshClient: sshClient, dir: dir}
+}
+
+// Location returns the set's local directory
+func (d *LocalDestination) Location(set string) string {
+	return filepath.Join(d.dir, set)
+}
+
+// CreateSet creates the set's local directory
+func (d *LocalDestination) CreateSet(set string) error {
+	if err := os.MkdirAll(d.Location(set), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", d.Location(set), err)
+	}
+	return nil
+}
+
+// CopyDisk downloads the image into the set
+func (d *LocalDestination) CopyDisk(set, name, sourcePath string, opts ssh.TransferOptions) (*ssh.TransferResult, error) {
+	return d.sshClient.Download(sourcePath, filepath.Join(d.Location(set), name), opts)
+}
+
+// FetchDisk uploads the image from the set to destPath on the NAS
+func (d *LocalDestination) FetchDisk(set string, disk Disk, destPath string, opts ssh.TransferOptions) (*ssh.TransferResult, error) {
+	if disk.Compression != "" {
+		return nil, fmt.Errorf("disk %s is %s-compressed, which local backups do not support", disk.File, disk.Compression)
+	}
+	return d.sshClient.Upload(filepath.Join(d.Location(set), disk.File), destPath, opts)
+}
+
+// WriteFile writes a file into the set's local directory
+func (d *LocalDestination) WriteFile(set, name string, data []byte) error {
+	if err := os.WriteFile(filepath.Join(d.Location(set), name), data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// ReadFile reads a file from the set's local directory
+func (d *LocalDestination) ReadFile(set, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(d.Location(set), name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// ListSets lists the directories under the local destination
+func (d *LocalDestination) ListSets() ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups in %s: %w", d.dir, err)
+	}
+
+	var sets []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			sets = append(sets, entry.Name())
+		}
+	}
+	return sets, nil
+}
+
+// RemoveSet deletes the set's local directory
+func (d *LocalDestination) RemoveSet(set string) error {
+	if err := os.RemoveAll(d.Location(set)); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", d.Location(set), err)
+	}
+	return nil
+}
+
+// nasPartMiB is the smallest part size, in MiB, of uploads run on the NAS
+const nasPartMiB = 64
+
+// S3Destination stores backup sets as objects under a prefix in an S3-compatible bucket
+type S3Destination struct {
+	sshClient *ssh.Client
+	client    *S3Client
+	bucket    string
+	prefix    string
+
+	// Compression is applied to disk images as they are streamed: CompressionGzip or empty
+	Compression string
+	// ViaNAS uploads disk images with curl on the NAS instead of streaming them through this machine
+	ViaNAS bool
+}
+
+// NewS3Destination creates a destination for an s3://bucket/prefix URL
+func NewS3Destination(sshClient *ssh.Client, client *S3Client, s3URL string) (*S3Destination, error) {
+	bucket, prefix, err := ParseS3URL(s3URL)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Destination{sshClient: sshClient, client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+// key returns the object key of a file in a set
+func (d *S3Destination) key(set, name string) string {
+	return path.Join(d.prefix, set, name)
+}
+
+// Location returns the set's s3:// URL
+func (d *S3Destination) Location(set string) string {
+	return "s3://" + path.Join(d.bucket, d.prefix, set)
+}
+
+// CreateSet does nothing; object storage has no directories to create
+func (d *S3Destination) CreateSet(set string) error {
+	return nil
+}
+
+// CopyDisk uploads the image from the NAS into the set
+func (d *S3Destination) CopyDisk(set, name, sourcePath string, opts ssh.TransferOptions) (*ssh.TransferResult, error) {
+	if d.ViaNAS {
+		if d.Compression != "" {
+			return nil, fmt.Errorf("compressed images cannot be uploaded from the NAS")
+		}
+		return d.uploadFromNAS(d.key(set, name), sourcePath, opts)
+	}
+	return d.streamDisk(d.key(set, name), sourcePath, opts)
+}
+
+// streamDisk reads the image over SSH, compressing it on the NAS if requested, and uploads
+// it from this machine without writing it to disk
+func (d *S3Destination) streamDisk(key, sourcePath string, opts ssh.TransferOptions) (*ssh.TransferResult, error) {
+	src := ssh.Quote(sourcePath)
+	location := "s3://" + path.Join(d.bucket, key)
+
+	var total int64
+	command := fmt.Sprintf("cat %s", src)
+	if d.Compression == CompressionGzip {
+		// Fastest level: NAS CPUs are slow, and most of the gain comes from unused blocks
+		command = fmt.Sprintf("gzip -1 -c %s", src)
+	} else {
+		size, err := d.remoteSize(sourcePath)
+		if err != nil {
+			return nil, err
+		}
+		total = size
+	}
+
+	nasOut, nasIn := io.Pipe()
+	go func() {
+		nasIn.CloseWithError(d.sshClient.ExecuteStream(command, nil, nasIn))
+	}()
+
+	uploadOut, uploadIn := io.Pipe()
+	uploaded := make(chan error, 1)
+	go func() {
+		_, err := d.client.Upload(d.bucket, key, uploadOut)
+		// Unblock the copy below if the upload gave up early
+		uploadOut.CloseWithError(err)
+		uploaded <- err
+	}()
+
+	result, copyErr := ssh.CopyWithProgress(uploadIn, nasOut, total, sha256.New(), opts.Progress)
+	uploadIn.CloseWithError(copyErr)
+	nasOut.CloseWithError(copyErr)
+
+	// A failed read also fails the upload, so its error covers both
+	if err := <-uploaded; err != nil {
+		return nil, fmt.Errorf("failed to upload %s to %s: %w", sourcePath, location, err)
+	}
+	if copyErr != nil {
+		return nil, fmt.Errorf("failed to upload %s to %s: %w", sourcePath, location, copyErr)
+	}
+
+	// Compressed output is checked by S3's per-part payload hashes only; reading the
+	// image again to recompress it would double the time on the NAS
+	if opts.Verify && d.Compression == "" {
+		sum, err := d.remoteSHA256(sourcePath)
+		if err != nil {
+			return nil, err
+		}
+		if sum != result.SHA256 {
+			return nil, fmt.Errorf("checksum mismatch after upload of %s: NAS %s, uploaded %s", sourcePath, sum, result.SHA256)
+		}
+	}
+	return result, nil
+}
+
+// uploadFromNAS uploads the image in parts with curl on the NAS, using presigned URLs so
+// credentials never leave this machine
+func (d *S3Destination) uploadFromNAS(key, sourcePath string, opts ssh.TransferOptions) (*ssh.TransferResult, error) {
+	if output, err := d.sshClient.Execute("command -v curl"); err != nil {
+		return nil, fmt.Errorf("uploading from the NAS needs curl, which was not found: %w\nOutput: %s", err, output)
+	}
+	size, err := d.remoteSize(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	partMiB := int64(nasPartMiB)
+	if minMiB := (size>>20)/maxParts + 1; minMiB > partMiB {
+		partMiB = minMiB
+	}
+	partCount := int((size + partMiB<<20 - 1) / (partMiB << 20))
+	if partCount == 0 {
+		partCount = 1
+	}
+
+	uploadID, err := d.client.CreateMultipartUpload(d.bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	var parts []CompletedPart
+	for partNumber := 1; partNumber <= partCount; partNumber++ {
+		var part CompletedPart
+		part, err = d.uploadPartFromNAS(key, sourcePath, uploadID, partNumber, partMiB)
+		if err != nil {
+			break
+		}
+		parts = append(parts, part)
+		if opts.Progress != nil {
+			fmt.Fprintf(opts.Progress, "\rUploaded part %d/%d", partNumber, partCount)
+		}
+	}
+	if opts.Progress != nil {
+		fmt.Fprintln(opts.Progress)
+	}
+	if err == nil {
+		err = d.client.CompleteMultipartUpload(d.bucket, key, uploadID, parts)
+	}
+	if err != nil {
+		if abortErr := d.client.AbortMultipartUpload(d.bucket, key, uploadID); abortErr != nil {
+			// Orphaned parts are removed by the bucket's lifecycle rules, if any
+		}
+		return nil, err
+	}
+
+	result := &ssh.TransferResult{Bytes: size, Duration: time.Since(start)}
+	if result.SHA256, err = d.remoteSHA256(sourcePath); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// uploadPartFromNAS sends one part of the image to a presigned URL with curl
+func (d *S3Destination) uploadPartFromNAS(key, sourcePath, uploadID string, partNumber int, partMiB int64) (CompletedPart, error) {
+	partURL, err := d.client.PresignUploadPart(d.bucket, key, uploadID, partNumber, time.Hour)
+	if err != nil {
+		return CompletedPart{}, err
+	}
+
+	// --data-binary buffers the part so curl sends a Content-Length, which S3 requires
+	command := fmt.Sprintf("dd if=%s bs=1M skip=%d count=%d 2>/dev/null | curl -sS -f -X PUT -H 'Content-Type:' --data-binary @- -D - -o /dev/null %s",
+		ssh.Quote(sourcePath), int64(partNumber-1)*partMiB, partMiB, ssh.Quote(partURL))
+	output, err := d.sshClient.Execute(command)
+	if err != nil {
+		return CompletedPart{}, fmt.Errorf("failed to upload part %d of %s: %w\nOutput: %s", partNumber, sourcePath, err, output)
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(name), "etag") {
+			return CompletedPart{PartNumber: partNumber, ETag: strings.TrimSpace(value)}, nil
+		}
+	}
+	return CompletedPart{}, fmt.Errorf("no ETag in the response to part %d of %s", partNumber, sourcePath)
+}
+
+// remoteSize returns the size of a file on the NAS
+func (d *S3Destination) remoteSize(file string) (int64, error) {
+	output, err := d.sshClient.Execute(fmt.Sprintf("stat -c %%s %s", ssh.Quote(file)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat %s: %w\nOutput: %s", file, err, output)
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected size of %s: %s", file, strings.TrimSpace(output))
+	}
+	return size, nil
+}
+
+// remoteSHA256 checksums a file on the NAS
+func (d *S3Destination) remoteSHA256(file string) (string, error) {
+	output, err := d.sshClient.Execute(fmt.Sprintf("sha256sum %s", ssh.Quote(file)))
+	fields := strings.Fields(output)
+	if err != nil || len(fields) == 0 {
+		return "", fmt.Errorf("failed to checksum %s: %v\nOutput: %s", file, err, output)
+	}
+	return fields[0], nil
+}
+
+// FetchDisk streams the image from the bucket through this machine into destPath on the
+// NAS, decompressing it on the NAS if needed
+func (d *S3Destination) FetchDisk(set string, disk Disk, destPath string, opts ssh.TransferOptions) (*ssh.TransferResult, error) {
+	key := d.key(set, disk.File)
+	body, size, err := d.client.OpenObject(d.bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := body.Close(); err != nil {
+			// The body was read to the end or the download already failed
+		}
+	}()
+
+	command := fmt.Sprintf("cat > %s", ssh.Quote(destPath))
+	if disk.Compression == CompressionGzip {
+		command = fmt.Sprintf("gzip -d -c > %s", ssh.Quote(destPath))
+	}
+
+	nasOut, nasIn := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		err := d.sshClient.ExecuteStream(command, nasOut, nil)
+		// Unblock the copy below if the NAS gave up early
+		nasOut.CloseWithError(err)
+		written <- err
+	}()
+
+	result, copyErr := ssh.CopyWithProgress(nasIn, body, size, sha256.New(), opts.Progress)
+	nasIn.CloseWithError(copyErr)
+
+	location := "s3://" + path.Join(d.bucket, key)
+	if err := <-written; err != nil {
+		return nil, fmt.Errorf("failed to restore %s to %s: %w", location, destPath, err)
+	}
+	if copyErr != nil {
+		return nil, fmt.Errorf("failed to restore %s to %s: %w", location, destPath, copyErr)
+	}
+	return result, nil
+}
+
+// WriteFile uploads a small file into the set
+func (d *S3Destination) WriteFile(set, name string, data []byte) error {
+	return d.client.PutObject(d.bucket, d.key(set, name), data)
+}
+
+// ReadFile downloads a small file from the set
+func (d *S3Destination) ReadFile(set, name string) ([]byte, error) {
+	return d.client.GetObject(d.bucket, d.key(set, name))
+}
+
+// ListSets lists the prefixes directly under the destination's prefix
+func (d *S3Destination) ListSets() ([]string, error) {
+	prefix := ""
+	if d.prefix != "" {
+		prefix = d.prefix + "/"
+	}
+	prefixes, err := d.client.ListPrefixes(d.bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var sets []string
+	for _, p := range prefixes {
+		if set := strings.TrimSuffix(strings.TrimPrefix(p, prefix), "/"); set != "" {
+			sets = append(sets, set)
+		}
+	}
+	return sets, nil
+}
+
+// RemoveSet deletes every object in the set
+func (d *S3Destination) RemoveSet(set string) error {
+	keys, err := d.client.ListKeys(d.bucket, d.key(set, "")+"/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := d.client.DeleteObject(d.bucket, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package backup
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadManifestsLocal(t *testing.T) {
+	dir := t.TempDir()
+	destination := NewLocalDestination(nil, dir)
+
+	write := func(set string, manifest *Manifest) {
+		if err := destination.CreateSet(set); err != nil {
+			t.Fatal(err)
+		}
+		if manifest == nil {
+			return
+		}
+		data, err := manifest.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := destination.WriteFile(set, ManifestFile, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	created := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	disks := []Disk{{Target: "vda", File: "vda.qcow2"}}
+	write("web-20260304-050607", &Manifest{Version: 1, VM: "web", Created: created, Disks: disks})
+	write("web-2-20260304-050607", &Manifest{Version: 1, VM: "web-2", Created: created, Disks: disks})
+	write("web-20260305-000000", nil) // Incomplete: no manifest
+	if err := os.WriteFile(filepath.Join(dir, "web-notes.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifests, err := ReadManifests(destination, "web")
+	if err != nil {
+		t.Fatalf("ReadManifests() error: %v", err)
+	}
+	if len(manifests) != 1 || manifests[0].VM != "web" || manifests[0].Name != "web-20260304-050607" {
+		t.Errorf("ReadManifests() = %+v", manifests)
+	}
+
+	if sets, err := NewLocalDestination(nil, filepath.Join(dir, "missing")).ListSets(); err != nil || len(sets) != 0 {
+		t.Errorf("ListSets() on a missing directory = %v, %v", sets, err)
+	}
+
+	all, err := ReadManifests(destination, "")
+	if err != nil || len(all) != 2 {
+		t.Errorf("ReadManifests(all) = %+v, %v", all, err)
+	}
+}
+
+func TestReadChain(t *testing.T) {
+	destination := NewLocalDestination(nil, t.TempDir())
+	disks := []Disk{{Target: "vda", File: "vda.qcow2"}}
+	for _, manifest := range []*Manifest{
+		{Version: 1, Name: "web-1", VM: "web", Disks: disks},
+		{Version: 1, Name: "web-2", VM: "web", Disks: disks, Parent: "web-1"},
+		{Version: 1, Name: "web-3", VM: "web", Disks: disks, Parent: "web-2"},
+		{Version: 1, Name: "web-5", VM: "web", Disks: disks, Parent: "web-4"},
+	} {
+		data, err := manifest.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := destination.CreateSet(manifest.Name); err != nil {
+			t.Fatal(err)
+		}
+		if err := destination.WriteFile(manifest.Name, ManifestFile, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chain, err := ReadChain(destination, "web-3")
+	if err != nil {
+		t.Fatalf("ReadChain() error: %v", err)
+	}
+	var names []string
+	for _, manifest := range chain {
+		names = append(names, manifest.Name)
+	}
+	if strings.Join(names, ",") != "web-1,web-2,web-3" {
+		t.Errorf("ReadChain(web-3) = %v, want web-1,web-2,web-3", names)
+	}
+
+	if chain, err := ReadChain(destination, "web-1"); err != nil || len(chain) != 1 {
+		t.Errorf("ReadChain(full) = %v, %v", chain, err)
+	}
+	if _, err := ReadChain(destination, "web-5"); err == nil || !strings.Contains(err.Error(), "parent web-4") {
+		t.Errorf("ReadChain() with a missing parent error = %v", err)
+	}
+	if _, err := ReadChain(destination, "missing"); err == nil {
+		t.Error("ReadChain(missing) should fail")
+	}
+}
+
+func TestS3DestinationSets(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	destination, err := NewS3Destination(nil, NewS3Client(server.URL, "", "key", "secret", true), "s3://bucket/nas1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := destination.Location("web-20260304-050607"); got != "s3://bucket/nas1/web-20260304-050607" {
+		t.Errorf("Location() = %s", got)
+	}
+
+	disks := []Disk{{Target: "vda", File: "vda.qcow2.gz", Compression: CompressionGzip}}
+	older := &Manifest{Version: 1, VM: "web", Created: time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC), Disks: disks}
+	newer := &Manifest{Version: 1, VM: "web", Created: older.Created.Add(24 * time.Hour), Disks: disks}
+	for set, manifest := range map[string]*Manifest{"web-20260305-050607": newer, "web-20260304-050607": older} {
+		data, err := manifest.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := destination.WriteFile(set, DomainFile, []byte("<domain/>")); err != nil {
+			t.Fatal(err)
+		}
+		if err := destination.WriteFile(set, ManifestFile, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.objects["other/web-20260101-000000/manifest.json"] = []byte("{}")
+
+	manifests, err := ReadManifests(destination, "web")
+	if err != nil {
+		t.Fatalf("ReadManifests() error: %v", err)
+	}
+	if len(manifests) != 2 || manifests[0].Name != "web-20260304-050607" || manifests[1].Name != "web-20260305-050607" {
+		t.Errorf("ReadManifests() = %+v", manifests)
+	}
+
+	if err := destination.RemoveSet("web-20260304-050607"); err != nil {
+		t.Fatalf("RemoveSet() error: %v", err)
+	}
+	sets, err := destination.ListSets()
+	if err != nil || len(sets) != 1 || sets[0] != "web-20260305-050607" {
+		t.Errorf("ListSets() after RemoveSet = %v, %v", sets, err)
+	}
+}
@@ -0,0 +1,132 @@
+// Package backup writes and reads VM backup sets: disk image copies, the domain XML and a manifest.
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ManifestVersion is the manifest format written by this version of qnap-vm
+const ManifestVersion = 1
+
+// File names inside a backup set
+const (
+	ManifestFile = "manifest.json"
+	DomainFile   = "domain.xml"
+)
+
+// Methods used to keep disk images consistent while they are copied
+const (
+	MethodOffline = "offline" // VM was shut off
+	MethodOverlay = "overlay" // Writes went to temporary external overlays
+	MethodPause   = "pause"   // VM was suspended for the copy
+	MethodZFS     = "zfs"     // Copied from a ZFS snapshot of the VM dataset
+	MethodBackup  = "backup"  // Written by a libvirt backup job, tracking changes with a checkpoint
+)
+
+// CompressionGzip marks disk images stored gzip-compressed
+const CompressionGzip = "gzip"
+
+// setTimeFormat is the timestamp suffix of backup set names
+const setTimeFormat = "20060102-150405"
+
+// Manifest describes a backup set. It is written last, so a set without one is incomplete.
+type Manifest struct {
+	Version int       `json:"version"`
+	Name    string    `json:"name"`
+	VM      string    `json:"vm"`
+	Host    string    `json:"host"`
+	Created time.Time `json:"created"`
+	Method  string    `json:"method"`
+	Domain  string    `json:"domain"`
+	Disks   []Disk    `json:"disks"`
+
+	// Parent is the set an incremental backup's disks apply on top of; empty for full backups
+	Parent string `json:"parent,omitempty"`
+	// Checkpoint is the VM checkpoint taken with this set, which the next incremental backup starts from
+	Checkpoint string `json:"checkpoint,omitempty"`
+}
+
+// Disk is one disk image in a backup set
+type Disk struct {
+	Target      string `json:"target"`                // Guest device name, e.g. vda
+	Source      string `json:"source"`                // Image path on the NAS when backed up
+	File        string `json:"file"`                  // File name within the set
+	Format      string `json:"format"`                // Image format, e.g. qcow2
+	VirtualSize int64  `json:"virtual_size"`          // Guest-visible size in bytes
+	Size        int64  `json:"size"`                  // Bytes copied
+	SHA256      string `json:"sha256"`                // Checksum of the stored file, after any compression
+	Compression string `json:"compression,omitempty"` // e.g. gzip; empty when stored as is
+}
+
+// SetName returns the name of a backup set of vmName taken at t
+func SetName(vmName string, t time.Time) string {
+	return fmt.Sprintf("%s-%s", vmName, t.Format(setTimeFormat))
+}
+
+// DiskFileName returns the file name a disk image is stored under in a set
+func DiskFileName(target, format string) string {
+	if format == "" {
+		format = "img"
+	}
+	return target + "." + format
+}
+
+// TotalSize returns the bytes of disk data in the set
+func (m *Manifest) TotalSize() int64 {
+	var total int64
+	for _, disk := range m.Disks {
+		total += disk.Size
+	}
+	return total
+}
+
+// Incremental reports whether the set only holds blocks changed since its parent
+func (m *Manifest) Incremental() bool {
+	return m.Parent != ""
+}
+
+// LatestWithCheckpoint returns the newest manifest whose checkpoint still exists on the
+// VM, which an incremental backup can start from, or nil when a full backup is needed
+func LatestWithCheckpoint(manifests []*Manifest, checkpoints []string) *Manifest {
+	exists := make(map[string]bool)
+	for _, name := range checkpoints {
+		exists[name] = true
+	}
+
+	var latest *Manifest
+	for _, manifest := range manifests {
+		if manifest.Checkpoint == "" || !exists[manifest.Checkpoint] {
+			continue
+		}
+		if latest == nil || manifest.Created.After(latest.Created) {
+			latest = manifest
+		}
+	}
+	return latest
+}
+
+// Marshal encodes the manifest as indented JSON
+func (m *Manifest) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode backup manifest: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// ParseManifest decodes a manifest, rejecting versions newer than this build understands
+func ParseManifest(data []byte) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse backup manifest: %w", err)
+	}
+	if manifest.Version > ManifestVersion {
+		return nil, fmt.Errorf("backup manifest version %d is newer than supported (%d); upgrade qnap-vm", manifest.Version, ManifestVersion)
+	}
+	if manifest.VM == "" || len(manifest.Disks) == 0 {
+		return nil, fmt.Errorf("backup manifest is incomplete")
+	}
+	return &manifest, nil
+}
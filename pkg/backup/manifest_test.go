@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+func TestSetName(t *testing.T) {
+	created := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	if got := SetName("web", created); got != "web-20260304-050607" {
+		t.Errorf("SetName() = %q, want %q", got, "web-20260304-050607")
+	}
+}
+
+func TestDiskFileName(t *testing.T) {
+	if got := DiskFileName("vda", "qcow2"); got != "vda.qcow2" {
+		t.Errorf("DiskFileName() = %q, want vda.qcow2", got)
+	}
+	if got := DiskFileName("sdb", ""); got != "sdb.img" {
+		t.Errorf("DiskFileName() = %q, want sdb.img", got)
+	}
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	manifest := &Manifest{
+		Version: ManifestVersion,
+		VM:      "web",
+		Host:    "qnap.local",
+		Created: time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC),
+		Method:  MethodOverlay,
+		Domain:  DomainFile,
+		Disks: []Disk{
+			{Target: "vda", File: "vda.qcow2", Format: "qcow2", Size: 3 << 30, SHA256: "abc"},
+			{Target: "vdb", File: "vdb.qcow2", Format: "qcow2", Size: 1 << 30, SHA256: "def"},
+		},
+	}
+
+	data, err := manifest.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() error: %v", err)
+	}
+
+	parsed, err := ParseManifest(data)
+	if err != nil {
+		t.Fatalf("ParseManifest() error: %v", err)
+	}
+	if parsed.VM != "web" || parsed.Method != MethodOverlay || len(parsed.Disks) != 2 || !parsed.Created.Equal(manifest.Created) {
+		t.Errorf("ParseManifest() = %+v", parsed)
+	}
+	if parsed.TotalSize() != 4<<30 {
+		t.Errorf("TotalSize() = %d, want %d", parsed.TotalSize(), int64(4<<30))
+	}
+}
+
+func TestParseManifestRejects(t *testing.T) {
+	tests := map[string]string{
+		"not JSON":   "{",
+		"newer":      `{"version": 99, "vm": "web", "disks": [{"target": "vda"}]}`,
+		"no disks":   `{"version": 1, "vm": "web"}`,
+		"no VM name": `{"version": 1, "disks": [{"target": "vda"}]}`,
+	}
+
+	for name, data := range tests {
+		if _, err := ParseManifest([]byte(data)); err == nil {
+			t.Errorf("ParseManifest(%s) should fail", name)
+		}
+	}
+}
+
+func TestParseSizeAndSum(t *testing.T) {
+	sum := strings.Repeat("a", 64)
+	var result ssh.TransferResult
+	if err := parseSizeAndSum("3221225472\n"+sum+"  /share/Backups/web/vda.qcow2\n", &result); err != nil {
+		t.Fatalf("parseSizeAndSum() error: %v", err)
+	}
+	if result.Bytes != 3<<30 || result.SHA256 != sum {
+		t.Errorf("parseSizeAndSum() = %+v", result)
+	}
+
+	if err := parseSizeAndSum("stat: cannot stat", &result); err == nil {
+		t.Error("parseSizeAndSum() should fail on error output")
+	}
+}
+
+func TestLatestWithCheckpoint(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 0, 0, 0, 0, time.UTC) }
+	manifests := []*Manifest{
+		{Name: "web-1", Created: day(1), Checkpoint: "qnap-vm-1"},
+		{Name: "web-3", Created: day(3), Checkpoint: "qnap-vm-3"},
+		{Name: "web-2", Created: day(2), Checkpoint: "qnap-vm-2"},
+		{Name: "web-4", Created: day(4)},
+	}
+
+	if got := LatestWithCheckpoint(manifests, []string{"qnap-vm-1", "qnap-vm-2", "qnap-vm-3"}); got == nil || got.Name != "web-3" {
+		t.Errorf("LatestWithCheckpoint() = %+v, want web-3", got)
+	}
+	// The newest set's checkpoint was deleted on the VM
+	if got := LatestWithCheckpoint(manifests, []string{"qnap-vm-2"}); got == nil || got.Name != "web-2" {
+		t.Errorf("LatestWithCheckpoint() = %+v, want web-2", got)
+	}
+	if got := LatestWithCheckpoint(manifests, nil); got != nil {
+		t.Errorf("LatestWithCheckpoint() = %+v, want nil", got)
+	}
+}
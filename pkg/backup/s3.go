@@ -0,0 +1,499 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3 multipart upload limits
+const (
+	minPartSize = 16 << 20 // Starting part size for streamed uploads; S3 requires at least 5 MiB
+	maxParts    = 10000
+)
+
+// emptySHA256 is the payload hash of requests without a body
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Client talks to S3-compatible object storage such as AWS S3, MinIO or Backblaze B2,
+// signing requests with AWS Signature Version 4
+type S3Client struct {
+	Endpoint   string // Service URL; https://s3.<region>.amazonaws.com when empty
+	Region     string // us-east-1 when empty
+	AccessKey  string
+	SecretKey  string
+	PathStyle  bool // Address buckets as endpoint/bucket instead of bucket.endpoint
+	HTTPClient *http.Client
+
+	now func() time.Time
+}
+
+// NewS3Client creates a client for endpoint with the given credentials
+func NewS3Client(endpoint, region, accessKey, secretKey string, pathStyle bool) *S3Client {
+	if region == "" {
+		region = "us-east-1"
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	return &S3Client{
+		Endpoint:   strings.TrimSuffix(endpoint, "/"),
+		Region:     region,
+		AccessKey:  accessKey,
+		SecretKey:  secretKey,
+		PathStyle:  pathStyle,
+		HTTPClient: &http.Client{Timeout: 30 * time.Minute},
+		now:        time.Now,
+	}
+}
+
+// ParseS3URL splits s3://bucket/prefix into the bucket and the key prefix without slashes at either end
+func ParseS3URL(s3URL string) (bucket, prefix string, err error) {
+	rest, ok := strings.CutPrefix(s3URL, "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("not an s3:// URL: %s", s3URL)
+	}
+	bucket, prefix, _ = strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("S3 URL has no bucket: %s", s3URL)
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// objectURL returns the URL of key in bucket, or of the bucket itself when key is empty
+func (c *S3Client) objectURL(bucket, key string) (*url.URL, error) {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %s: %w", c.Endpoint, err)
+	}
+
+	objectPath := "/" + key
+	if c.PathStyle {
+		objectPath = "/" + bucket + objectPath
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	basePath := strings.TrimSuffix(u.EscapedPath(), "/")
+	u.Path = strings.TrimSuffix(u.Path, "/") + objectPath
+	u.RawPath = basePath + uriEncode(objectPath, false)
+	return u, nil
+}
+
+// uriEncode percent-encodes s as SigV4 requires: everything but unreserved characters,
+// and slashes too when encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery returns the sorted, encoded query string used both in the URL and for signing
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signingKey derives the SigV4 signing key for a date (YYYYMMDD), region and service
+func signingKey(secretKey, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+// signature signs a canonical request made at t
+func (c *S3Client) signature(t time.Time, canonicalRequest string) string {
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		t.Format("20060102T150405Z"),
+		c.scope(t),
+		hex.EncodeToString(hashed[:]),
+	}, "\n")
+	return hex.EncodeToString(hmacSHA256(signingKey(c.SecretKey, t.Format("20060102"), c.Region, "s3"), stringToSign))
+}
+
+// scope returns the credential scope of a request made at t
+func (c *S3Client) scope(t time.Time) string {
+	return fmt.Sprintf("%s/%s/s3/aws4_request", t.Format("20060102"), c.Region)
+}
+
+// sign adds SigV4 authentication headers to req, whose body hashes to payloadHash
+func (c *S3Client) sign(req *http.Request, payloadHash string) {
+	t := c.now().UTC()
+	req.Header.Set("X-Amz-Date", t.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n",
+		req.URL.Host, payloadHash, t.Format("20060102T150405Z"))
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		signedHeaders = []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+		canonicalHeaders = "content-type:" + contentType + "\n" + canonicalHeaders
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKey, c.scope(t), strings.Join(signedHeaders, ";"), c.signature(t, canonicalRequest)))
+}
+
+// Presign returns a URL that allows method on key without further credentials until it expires.
+// The payload is not signed, so the URL can be used by tools like curl.
+func (c *S3Client) Presign(method, bucket, key string, query url.Values, expires time.Duration) (string, error) {
+	u, err := c.objectURL(bucket, key)
+	if err != nil {
+		return "", err
+	}
+
+	t := c.now().UTC()
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", c.AccessKey+"/"+c.scope(t))
+	query.Set("X-Amz-Date", t.Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	query.Set("X-Amz-Signature", c.signature(t, canonicalRequest))
+
+	u.RawQuery = canonicalQuery(query)
+	return u.String(), nil
+}
+
+// send sends a signed request and returns the response with its body unread
+func (c *S3Client) send(method, bucket, key string, query url.Values, body []byte, contentType string) (*http.Response, error) {
+	u, err := c.objectURL(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	payloadHash := emptySHA256
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	c.sign(req, payloadHash)
+
+	return c.HTTPClient.Do(req)
+}
+
+// do sends a signed request and returns the body of a 2xx response
+func (c *S3Client) do(method, bucket, key string, query url.Values, body []byte, contentType string) (*http.Response, []byte, error) {
+	resp, err := c.send(method, bucket, key, query, body, contentType)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			// Body was fully read; close errors do not affect the result
+		}
+	}()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, s3Error(resp, data)
+	}
+	return resp, data, nil
+}
+
+// s3Error turns an S3 error response into an error with its code and message
+func s3Error(resp *http.Response, data []byte) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.Unmarshal(data, &body); err == nil && body.Code != "" {
+		return fmt.Errorf("S3 %s: %s (%s)", resp.Status, body.Message, body.Code)
+	}
+	return fmt.Errorf("S3 %s", resp.Status)
+}
+
+// PutObject stores data under key
+func (c *S3Client) PutObject(bucket, key string, data []byte) error {
+	if _, _, err := c.do(http.MethodPut, bucket, key, nil, data, ""); err != nil {
+		return fmt.Errorf("failed to upload s3://%s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+// GetObject returns the contents of key
+func (c *S3Client) GetObject(bucket, key string) ([]byte, error) {
+	_, data, err := c.do(http.MethodGet, bucket, key, nil, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read s3://%s/%s: %w", bucket, key, err)
+	}
+	return data, nil
+}
+
+// OpenObject starts a download of key, returning its body for the caller to read and
+// close, and its size
+func (c *S3Client) OpenObject(bucket, key string) (io.ReadCloser, int64, error) {
+	resp, err := c.send(http.MethodGet, bucket, key, nil, nil, "")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read s3://%s/%s: %w", bucket, key, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(resp.Body)
+		if err := resp.Body.Close(); err != nil {
+			// The error response was read; close errors do not change it
+		}
+		return nil, 0, fmt.Errorf("failed to read s3://%s/%s: %w", bucket, key, s3Error(resp, data))
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// DeleteObject removes key
+func (c *S3Client) DeleteObject(bucket, key string) error {
+	if _, _, err := c.do(http.MethodDelete, bucket, key, nil, nil, ""); err != nil {
+		return fmt.Errorf("failed to delete s3://%s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+// listResult is a ListObjectsV2 response page
+type listResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// list returns the keys and, with a delimiter, the common prefixes under prefix
+func (c *S3Client) list(bucket, prefix, delimiter string) (keys, prefixes []string, err error) {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		_, data, err := c.do(http.MethodGet, bucket, "", query, nil, "")
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list s3://%s/%s: %w", bucket, prefix, err)
+		}
+		var page listResult
+		if err := xml.Unmarshal(data, &page); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse S3 listing: %w", err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, object.Key)
+		}
+		for _, common := range page.CommonPrefixes {
+			prefixes = append(prefixes, common.Prefix)
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return keys, prefixes, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// ListKeys returns every key under prefix
+func (c *S3Client) ListKeys(bucket, prefix string) ([]string, error) {
+	keys, _, err := c.list(bucket, prefix, "")
+	return keys, err
+}
+
+// ListPrefixes returns the "directories" directly under prefix, which should end in a slash
+func (c *S3Client) ListPrefixes(bucket, prefix string) ([]string, error) {
+	_, prefixes, err := c.list(bucket, prefix, "/")
+	return prefixes, err
+}
+
+// CompletedPart is an uploaded part of a multipart upload
+type CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// CreateMultipartUpload starts a multipart upload of key and returns its upload ID
+func (c *S3Client) CreateMultipartUpload(bucket, key string) (string, error) {
+	_, data, err := c.do(http.MethodPost, bucket, key, url.Values{"uploads": {""}}, nil, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to start upload of s3://%s/%s: %w", bucket, key, err)
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(data, &result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("failed to start upload of s3://%s/%s: no upload ID in response", bucket, key)
+	}
+	return result.UploadID, nil
+}
+
+// partQuery identifies a part of a multipart upload
+func partQuery(uploadID string, partNumber int) url.Values {
+	return url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+}
+
+// UploadPart uploads one part of a multipart upload
+func (c *S3Client) UploadPart(bucket, key, uploadID string, partNumber int, data []byte) (CompletedPart, error) {
+	resp, _, err := c.do(http.MethodPut, bucket, key, partQuery(uploadID, partNumber), data, "")
+	if err != nil {
+		return CompletedPart{}, fmt.Errorf("failed to upload part %d of s3://%s/%s: %w", partNumber, bucket, key, err)
+	}
+	return CompletedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")}, nil
+}
+
+// PresignUploadPart returns a URL that accepts a PUT of one part of a multipart upload
+func (c *S3Client) PresignUploadPart(bucket, key, uploadID string, partNumber int, expires time.Duration) (string, error) {
+	return c.Presign(http.MethodPut, bucket, key, partQuery(uploadID, partNumber), expires)
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into the object
+func (c *S3Client) CompleteMultipartUpload(bucket, key, uploadID string, parts []CompletedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	_, data, err := c.do(http.MethodPost, bucket, key, url.Values{"uploadId": {uploadID}}, body, "application/xml")
+	if err == nil && bytes.Contains(data, []byte("<Error>")) {
+		// S3 can report a failed completion in a 200 response
+		err = s3Error(&http.Response{Status: "200 OK"}, data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to complete upload of s3://%s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+// AbortMultipartUpload discards an unfinished multipart upload and its parts
+func (c *S3Client) AbortMultipartUpload(bucket, key, uploadID string) error {
+	if _, _, err := c.do(http.MethodDelete, bucket, key, url.Values{"uploadId": {uploadID}}, nil, ""); err != nil {
+		return fmt.Errorf("failed to abort upload of s3://%s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+// streamPartSize returns the size of a streamed upload's next part. Parts grow as the
+// upload proceeds so that objects of nearly 1 TiB fit in S3's 10,000 part limit
+// without buffering large parts for small images.
+func streamPartSize(partNumber int) int {
+	return minPartSize << ((partNumber - 1) / (maxParts / 5))
+}
+
+// Upload streams r to key as a multipart upload, buffering one part at a time, and
+// returns the number of bytes stored. A failed upload is aborted.
+func (c *S3Client) Upload(bucket, key string, r io.Reader) (int64, error) {
+	uploadID, err := c.CreateMultipartUpload(bucket, key)
+	if err != nil {
+		return 0, err
+	}
+
+	var parts []CompletedPart
+	var total int64
+	for partNumber := 1; ; partNumber++ {
+		if partNumber > maxParts {
+			err = fmt.Errorf("s3://%s/%s is too large for a multipart upload", bucket, key)
+			break
+		}
+
+		buf := make([]byte, streamPartSize(partNumber))
+		n, readErr := io.ReadFull(r, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			err = readErr
+			break
+		}
+		// An empty object still needs one (empty) part
+		if n > 0 || partNumber == 1 {
+			var part CompletedPart
+			part, err = c.UploadPart(bucket, key, uploadID, partNumber, buf[:n])
+			if err != nil {
+				break
+			}
+			parts = append(parts, part)
+			total += int64(n)
+		}
+		if readErr != nil {
+			err = c.CompleteMultipartUpload(bucket, key, uploadID, parts)
+			break
+		}
+	}
+
+	if err != nil {
+		if abortErr := c.AbortMultipartUpload(bucket, key, uploadID); abortErr != nil {
+			// Orphaned parts are removed by the bucket's lifecycle rules, if any
+		}
+		return 0, err
+	}
+	return total, nil
+}
@@ -0,0 +1,94 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+)
+
+// Backup schedule frequencies
+const (
+	EveryHourly = "hourly"
+	EveryDaily  = "daily"
+	EveryWeekly = "weekly" // On Sundays
+)
+
+// DefaultScheduleTime is when daily and weekly backups run unless told otherwise
+const DefaultScheduleTime = "02:00"
+
+// LastSlot returns the most recent time at or before now that a schedule running every
+// hour, day or week at the HH:MM time at was due. Hourly schedules use only the minute.
+func LastSlot(every, at string, now time.Time) (time.Time, error) {
+	if at == "" {
+		at = DefaultScheduleTime
+	}
+	clock, err := time.Parse("15:04", at)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid schedule time '%s' (use HH:MM)", at)
+	}
+
+	switch every {
+	case EveryHourly:
+		slot := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), clock.Minute(), 0, 0, now.Location())
+		if slot.After(now) {
+			slot = slot.Add(-time.Hour)
+		}
+		return slot, nil
+	case EveryDaily, EveryWeekly:
+		slot := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+		if slot.After(now) {
+			slot = slot.AddDate(0, 0, -1)
+		}
+		if every == EveryWeekly {
+			slot = slot.AddDate(0, 0, -int(slot.Weekday()))
+		}
+		return slot, nil
+	default:
+		return time.Time{}, fmt.Errorf("invalid schedule frequency '%s' (use hourly, daily or weekly)", every)
+	}
+}
+
+// NextSlot returns the first time after now that the schedule is due
+func NextSlot(every, at string, now time.Time) (time.Time, error) {
+	last, err := LastSlot(every, at, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch every {
+	case EveryHourly:
+		return last.Add(time.Hour), nil
+	case EveryDaily:
+		return last.AddDate(0, 0, 1), nil
+	default:
+		return last.AddDate(0, 0, 7), nil
+	}
+}
+
+// Expired returns the sets to delete so that only the newest keep sets remain, together
+// with the earlier sets they build on. manifests are one VM's sets, oldest first, as
+// returned by ReadManifests. A keep of 0 keeps everything.
+func Expired(manifests []*Manifest, keep int) []*Manifest {
+	if keep <= 0 || len(manifests) <= keep {
+		return nil
+	}
+
+	byName := make(map[string]*Manifest, len(manifests))
+	for _, manifest := range manifests {
+		byName[manifest.Name] = manifest
+	}
+
+	needed := make(map[string]bool)
+	for _, manifest := range manifests[len(manifests)-keep:] {
+		// Incremental sets cannot be restored without their parents
+		for m := manifest; m != nil && !needed[m.Name]; m = byName[m.Parent] {
+			needed[m.Name] = true
+		}
+	}
+
+	var expired []*Manifest
+	for _, manifest := range manifests {
+		if !needed[manifest.Name] {
+			expired = append(expired, manifest)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,78 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLastSlot(t *testing.T) {
+	// A Wednesday afternoon
+	now := time.Date(2026, 10, 14, 15, 20, 0, 0, time.UTC)
+
+	tests := []struct {
+		every string
+		at    string
+		want  time.Time
+	}{
+		{EveryHourly, "00:45", time.Date(2026, 10, 14, 14, 45, 0, 0, time.UTC)},
+		{EveryHourly, "00:15", time.Date(2026, 10, 14, 15, 15, 0, 0, time.UTC)},
+		{EveryDaily, "", time.Date(2026, 10, 14, 2, 0, 0, 0, time.UTC)},
+		{EveryDaily, "23:30", time.Date(2026, 10, 13, 23, 30, 0, 0, time.UTC)},
+		{EveryWeekly, "03:00", time.Date(2026, 10, 11, 3, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := LastSlot(tt.every, tt.at, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("LastSlot(%s, %q) = %v, %v; want %v", tt.every, tt.at, got, err, tt.want)
+		}
+	}
+
+	next, err := NextSlot(EveryWeekly, "03:00", now)
+	if err != nil || !next.Equal(time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC)) {
+		t.Errorf("NextSlot(weekly) = %v, %v", next, err)
+	}
+
+	if _, err := LastSlot("monthly", "", now); err == nil {
+		t.Error("LastSlot(monthly) should fail")
+	}
+	if _, err := LastSlot(EveryDaily, "2am", now); err == nil {
+		t.Error("LastSlot() with an invalid time should fail")
+	}
+}
+
+func TestExpired(t *testing.T) {
+	// A full backup followed by two incrementals, then a new full chain
+	manifests := []*Manifest{
+		{Name: "web-1"},
+		{Name: "web-2", Parent: "web-1"},
+		{Name: "web-3", Parent: "web-2"},
+		{Name: "web-4"},
+		{Name: "web-5", Parent: "web-4"},
+	}
+
+	names := func(ms []*Manifest) string {
+		var n []string
+		for _, m := range ms {
+			n = append(n, m.Name)
+		}
+		return strings.Join(n, ",")
+	}
+
+	tests := []struct {
+		keep int
+		want string
+	}{
+		{0, ""},
+		{5, ""},
+		{2, "web-1,web-2,web-3"},
+		{1, "web-1,web-2,web-3"},
+		// Keeping web-3 keeps the chain it builds on
+		{3, ""},
+	}
+	for _, tt := range tests {
+		if got := names(Expired(manifests, tt.keep)); got != tt.want {
+			t.Errorf("Expired(keep %d) = %q, want %q", tt.keep, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Package bench runs storage and network benchmarks on the QNAP host and inside guests.
+package bench
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+)
+
+// guestCommandTimeout bounds a single benchmark step run through the guest agent
+const guestCommandTimeout = 10 * time.Minute
+
+// Result is one benchmark measurement
+type Result struct {
+	Target string  `json:"target"` // "host" or "guest"
+	Test   string  `json:"test"`
+	Tool   string  `json:"tool"`
+	Value  float64 `json:"value"`
+	Unit   string  `json:"unit"`
+}
+
+// Runner runs shell scripts on a benchmark target
+type Runner interface {
+	// Name identifies the target in results ("host" or "guest")
+	Name() string
+	// Run executes a shell script and returns its combined output
+	Run(script string) (string, error)
+	// Install copies a local tool binary to the target and returns its path there
+	Install(tool, localPath string) (string, error)
+}
+
+// HostRunner runs benchmarks on the QNAP device over SSH
+type HostRunner struct {
+	sshClient *ssh.Client
+}
+
+// NewHostRunner creates a runner for the QNAP device
+func NewHostRunner(sshClient *ssh.Client) *HostRunner {
+	return &HostRunner{sshClient: sshClient}
+}
+
+// Name returns "host"
+func (r *HostRunner) Name() string {
+	return "host"
+}
+
+// Run executes script on the QNAP device
+func (r *HostRunner) Run(script string) (string, error) {
+	return r.sshClient.Execute(script)
+}
+
+// Install uploads a tool binary to /tmp on the QNAP device
+func (r *HostRunner) Install(tool, localPath string) (string, error) {
+	remotePath := installedToolPath(tool)
+	if _, err := r.sshClient.Upload(localPath, remotePath, ssh.TransferOptions{}); err != nil {
+		return "", err
+	}
+	if output, err := r.sshClient.Execute(fmt.Sprintf("chmod 755 %s", ssh.Quote(remotePath))); err != nil {
+		return "", fmt.Errorf("failed to make %s executable: %w\nOutput: %s", remotePath, err, output)
+	}
+	return remotePath, nil
+}
+
+// GuestRunner runs benchmarks inside a Linux guest through the QEMU guest agent
+type GuestRunner struct {
+	virshClient *virsh.Client
+	vmName      string
+}
+
+// NewGuestRunner creates a runner for a VM's guest
+func NewGuestRunner(virshClient *virsh.Client, vmName string) *GuestRunner {
+	return &GuestRunner{virshClient: virshClient, vmName: vmName}
+}
+
+// Name returns "guest"
+func (r *GuestRunner) Name() string {
+	return "guest"
+}
+
+// Run executes script with /bin/sh inside the guest
+func (r *GuestRunner) Run(script string) (string, error) {
+	result, err := r.virshClient.GuestExec(r.vmName, "/bin/sh", []string{"-c", script}, guestCommandTimeout)
+	if err != nil {
+		return "", err
+	}
+	if result.ExitCode != 0 {
+		return result.Stdout, fmt.Errorf("guest command exited with status %d: %s", result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	return result.Stdout + result.Stderr, nil
+}
+
+// Install writes a tool binary to /tmp in the guest with the guest agent
+func (r *GuestRunner) Install(tool, localPath string) (string, error) {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", localPath, err)
+	}
+
+	guestPath := installedToolPath(tool)
+	if err := r.virshClient.GuestWriteFile(r.vmName, guestPath, data); err != nil {
+		return "", err
+	}
+	if _, err := r.Run(fmt.Sprintf("chmod 755 %s", ssh.Quote(guestPath))); err != nil {
+		return "", err
+	}
+	return guestPath, nil
+}
+
+// installedToolPath is where portable tool binaries are installed on a target
+func installedToolPath(tool string) string {
+	return "/tmp/qnap-vm-bench-" + tool
+}
+
+// FindTool returns the path of tool on the target, installing the portable binary
+// from toolsDir when the target lacks it. It returns "" if the tool is unavailable.
+func FindTool(r Runner, tool, toolsDir string) (string, error) {
+	installed := installedToolPath(tool)
+	output, err := r.Run(fmt.Sprintf("command -v %s 2>/dev/null || { test -x %s && echo %s; } || true", tool, installed, installed))
+	if err == nil {
+		if toolPath := strings.TrimSpace(output); toolPath != "" {
+			return toolPath, nil
+		}
+	}
+
+	if toolsDir == "" {
+		return "", nil
+	}
+	localPath := filepath.Join(toolsDir, tool)
+	if _, err := os.Stat(localPath); err != nil {
+		return "", nil
+	}
+
+	return r.Install(tool, localPath)
+}
@@ -0,0 +1,100 @@
+package bench
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseFio(t *testing.T) {
+	output := `fio: note: both iodepth >= 1 and synchronous I/O engine are selected
+{
+  "fio version" : "fio-3.28",
+  "jobs" : [
+    {"jobname" : "seq-write", "read" : {"bw_bytes" : 0, "iops" : 0}, "write" : {"bw_bytes" : 209715200, "iops" : 200}},
+    {"jobname" : "seq-read", "read" : {"bw_bytes" : 314572800, "iops" : 300}, "write" : {"bw_bytes" : 0, "iops" : 0}},
+    {"jobname" : "rand-read-4k", "read" : {"bw_bytes" : 4096000, "iops" : 1000.5}, "write" : {"bw_bytes" : 0, "iops" : 0}},
+    {"jobname" : "rand-write-4k", "read" : {"bw_bytes" : 0, "iops" : 0}, "write" : {"bw_bytes" : 2048000, "iops" : 500}}
+  ]
+}`
+
+	results, err := parseFio(output)
+	if err != nil {
+		t.Fatalf("parseFio() error = %v", err)
+	}
+
+	want := []Result{
+		{Test: TestSeqWrite, Value: 200, Unit: "MB/s"},
+		{Test: TestSeqRead, Value: 300, Unit: "MB/s"},
+		{Test: TestRandRead, Value: 1000.5, Unit: "IOPS"},
+		{Test: TestRandWrite, Value: 500, Unit: "IOPS"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("parseFio() returned %d results, want %d", len(results), len(want))
+	}
+	for i, w := range want {
+		if results[i] != w {
+			t.Errorf("result %d = %+v, want %+v", i, results[i], w)
+		}
+	}
+}
+
+func TestParseFioNoJSON(t *testing.T) {
+	if _, err := parseFio("fio: failed to open file"); err == nil {
+		t.Error("parseFio() expected error for output without JSON")
+	}
+}
+
+func TestParseDD(t *testing.T) {
+	output := `## seq-write
+1024+0 records in
+1024+0 records out
+1073741824 bytes (1.1 GB, 1.0 GiB) copied, 5.12 s, 210 MB/s
+## seq-read
+1024+0 records in
+1024+0 records out
+1073741824 bytes (1.0GB) copied, 2.000000 seconds, 512.0MB/s
+`
+
+	results, err := parseDD(output)
+	if err != nil {
+		t.Fatalf("parseDD() error = %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("parseDD() returned %d results, want 2", len(results))
+	}
+	if results[0].Test != TestSeqWrite || math.Abs(results[0].Value-200) > 0.01 {
+		t.Errorf("write result = %+v, want seq-write 200 MB/s", results[0])
+	}
+	if results[1].Test != TestSeqRead || results[1].Value != 512 {
+		t.Errorf("read result = %+v, want seq-read 512 MB/s", results[1])
+	}
+}
+
+func TestParseIperf(t *testing.T) {
+	output := `{"start": {}, "end": {"sum_sent": {"bits_per_second": 950000000}, "sum_received": {"bits_per_second": 940000000}}}`
+	bps, err := parseIperf(output)
+	if err != nil {
+		t.Fatalf("parseIperf() error = %v", err)
+	}
+	if bps != 940000000 {
+		t.Errorf("parseIperf() = %v, want 940000000", bps)
+	}
+
+	_, err = parseIperf(`{"start": {}, "end": {}, "error": "unable to connect to server: Connection refused"}`)
+	if err == nil || !strings.Contains(err.Error(), "Connection refused") {
+		t.Errorf("parseIperf() error = %v, want iperf3 error", err)
+	}
+}
+
+func TestScriptsQuoteDirectory(t *testing.T) {
+	opts := DiskOptions{Dir: "/share/My Pool/bench", SizeMB: 64}
+	for name, script := range map[string]string{
+		"fio": fioScript("fio", opts),
+		"dd":  ddScript(opts),
+	} {
+		if !strings.Contains(script, "'/share/My Pool/bench'") {
+			t.Errorf("%s script does not quote the directory:\n%s", name, script)
+		}
+	}
+}
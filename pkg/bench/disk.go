@@ -0,0 +1,168 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// Disk benchmark tests
+const (
+	TestSeqWrite  = "seq-write"
+	TestSeqRead   = "seq-read"
+	TestRandRead  = "rand-read-4k"
+	TestRandWrite = "rand-write-4k"
+)
+
+// randomRuntime is how long each fio random I/O test runs, in seconds
+const randomRuntime = 15
+
+// benchFile is the test file written in the benchmark directory
+const benchFile = "qnap-vm-bench.tmp"
+
+// ddPattern extracts the byte count and seconds from GNU and BusyBox dd summaries
+var ddPattern = regexp.MustCompile(`(\d+) bytes .*copied, ([\d.]+) s`)
+
+// DiskOptions controls the disk benchmark
+type DiskOptions struct {
+	Dir    string // Directory on the target for the test file
+	SizeMB int    // Size of the test file in MiB
+}
+
+// RunDisk benchmarks the filesystem holding opts.Dir on the target. It uses fio when
+// fioPath is set and falls back to sequential dd tests otherwise.
+func RunDisk(r Runner, fioPath string, opts DiskOptions) ([]Result, error) {
+	script, tool := ddScript(opts), "dd"
+	if fioPath != "" {
+		script, tool = fioScript(fioPath, opts), "fio"
+	}
+
+	output, err := r.Run(script)
+	if err != nil {
+		return nil, fmt.Errorf("%s disk benchmark failed: %w\nOutput: %s", r.Name(), err, output)
+	}
+
+	var results []Result
+	if tool == "fio" {
+		results, err = parseFio(output)
+	} else {
+		results, err = parseDD(output)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s disk benchmark: %w", r.Name(), err)
+	}
+
+	for i := range results {
+		results[i].Target = r.Name()
+		results[i].Tool = tool
+	}
+	return results, nil
+}
+
+// fioScript runs sequential and 4K random tests with direct I/O, one after another
+func fioScript(fioPath string, opts DiskOptions) string {
+	file := path.Join(opts.Dir, benchFile)
+	return fmt.Sprintf(`mkdir -p %[1]s || exit 1
+%[2]s --output-format=json --directory=%[1]s --filename=%[3]s --size=%[4]dM --direct=1 --ioengine=psync \
+	--name=%[5]s --rw=write --bs=1M --stonewall \
+	--name=%[6]s --rw=read --bs=1M --stonewall \
+	--name=%[7]s --rw=randread --bs=4k --runtime=%[9]d --time_based --stonewall \
+	--name=%[8]s --rw=randwrite --bs=4k --runtime=%[9]d --time_based --stonewall
+status=$?
+rm -f %[10]s
+exit $status`,
+		ssh.Quote(opts.Dir), fioPath, benchFile, opts.SizeMB,
+		TestSeqWrite, TestSeqRead, TestRandRead, TestRandWrite, randomRuntime, ssh.Quote(file))
+}
+
+// ddScript runs sequential write and read tests with dd, dropping the page cache between them
+func ddScript(opts DiskOptions) string {
+	file := ssh.Quote(path.Join(opts.Dir, benchFile))
+	return fmt.Sprintf(`mkdir -p %s || exit 1
+echo '## %s'
+dd if=/dev/zero of=%s bs=1M count=%d conv=fsync 2>&1 || exit 1
+sync
+echo 3 > /proc/sys/vm/drop_caches 2>/dev/null
+echo '## %s'
+dd if=%s of=/dev/null bs=1M 2>&1
+status=$?
+rm -f %s
+exit $status`,
+		ssh.Quote(opts.Dir), TestSeqWrite, file, opts.SizeMB, TestSeqRead, file, file)
+}
+
+// fioOutput is the subset of fio's JSON output used for results
+type fioOutput struct {
+	Jobs []struct {
+		Name  string  `json:"jobname"`
+		Read  fioStat `json:"read"`
+		Write fioStat `json:"write"`
+	} `json:"jobs"`
+}
+
+type fioStat struct {
+	BWBytes float64 `json:"bw_bytes"`
+	IOPS    float64 `json:"iops"`
+}
+
+// parseFio parses fio JSON output; warnings printed around the JSON document are ignored
+func parseFio(output string) ([]Result, error) {
+	start, end := strings.Index(output, "{"), strings.LastIndex(output, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no fio JSON output found")
+	}
+
+	var parsed fioOutput
+	if err := json.Unmarshal([]byte(output[start:end+1]), &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse fio output: %w", err)
+	}
+
+	var results []Result
+	for _, job := range parsed.Jobs {
+		switch job.Name {
+		case TestSeqWrite:
+			results = append(results, Result{Test: job.Name, Value: job.Write.BWBytes / (1 << 20), Unit: "MB/s"})
+		case TestSeqRead:
+			results = append(results, Result{Test: job.Name, Value: job.Read.BWBytes / (1 << 20), Unit: "MB/s"})
+		case TestRandRead:
+			results = append(results, Result{Test: job.Name, Value: job.Read.IOPS, Unit: "IOPS"})
+		case TestRandWrite:
+			results = append(results, Result{Test: job.Name, Value: job.Write.IOPS, Unit: "IOPS"})
+		}
+	}
+	return results, nil
+}
+
+// parseDD parses the output of ddScript
+func parseDD(output string) ([]Result, error) {
+	var results []Result
+	test := ""
+	for _, line := range strings.Split(output, "\n") {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(line), "## "); ok {
+			test = name
+			continue
+		}
+
+		matches := ddPattern.FindStringSubmatch(line)
+		if matches == nil || test == "" {
+			continue
+		}
+		bytes, _ := strconv.ParseFloat(matches[1], 64)
+		seconds, _ := strconv.ParseFloat(matches[2], 64)
+		if seconds <= 0 {
+			continue
+		}
+		results = append(results, Result{Test: test, Value: bytes / seconds / (1 << 20), Unit: "MB/s"})
+		test = ""
+	}
+
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no dd results found")
+	}
+	return results, nil
+}
@@ -0,0 +1,76 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Network benchmark tests, named from the guest's point of view
+const (
+	TestNetSend    = "net-send"
+	TestNetReceive = "net-receive"
+)
+
+// iperfPort is the port the one-off iperf3 server listens on
+const iperfPort = 5201
+
+// NetOptions controls the network benchmark
+type NetOptions struct {
+	ServerAddr string // Address of the server as seen from the client
+	Seconds    int    // Duration of each direction
+}
+
+// RunNet measures throughput between the client and server targets with iperf3, in both directions
+func RunNet(server Runner, serverIperf string, client Runner, clientIperf string, opts NetOptions) ([]Result, error) {
+	var results []Result
+	for _, test := range []string{TestNetSend, TestNetReceive} {
+		// A one-off daemon serves exactly one client run
+		serverCmd := fmt.Sprintf("%s -s -1 -D -p %d && sleep 1", serverIperf, iperfPort)
+		if output, err := server.Run(serverCmd); err != nil {
+			return nil, fmt.Errorf("failed to start iperf3 server on %s: %w\nOutput: %s", server.Name(), err, output)
+		}
+
+		clientCmd := fmt.Sprintf("%s -c %s -p %d -t %d -J", clientIperf, opts.ServerAddr, iperfPort, opts.Seconds)
+		if test == TestNetReceive {
+			clientCmd += " -R"
+		}
+		output, err := client.Run(clientCmd)
+		if err != nil {
+			return nil, fmt.Errorf("iperf3 on %s failed: %w\nOutput: %s", client.Name(), err, output)
+		}
+
+		bps, err := parseIperf(output)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, Result{Target: client.Name(), Test: test, Tool: "iperf3", Value: bps / 1e6, Unit: "Mbit/s"})
+	}
+
+	return results, nil
+}
+
+// parseIperf returns the received bits per second from iperf3 JSON output
+func parseIperf(output string) (float64, error) {
+	start, end := strings.Index(output, "{"), strings.LastIndex(output, "}")
+	if start < 0 || end < start {
+		return 0, fmt.Errorf("no iperf3 JSON output found")
+	}
+
+	var parsed struct {
+		End struct {
+			SumReceived struct {
+				BitsPerSecond float64 `json:"bits_per_second"`
+			} `json:"sum_received"`
+		} `json:"end"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(output[start:end+1]), &parsed); err != nil {
+		return 0, fmt.Errorf("failed to parse iperf3 output: %w", err)
+	}
+	if parsed.Error != "" {
+		return 0, fmt.Errorf("iperf3: %s", parsed.Error)
+	}
+
+	return parsed.End.SumReceived.BitsPerSecond, nil
+}
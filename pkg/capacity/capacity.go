@@ -0,0 +1,190 @@
+// Package capacity compares the resources allocated to a host's VMs with what the NAS
+// has, to catch overcommit before it shows up as swapping, CPU contention or a full pool.
+package capacity
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Warning levels
+const (
+	LevelWarning = "warning" // Contention is possible, e.g. when every VM is busy or running
+	LevelError   = "error"   // The host is short of the resource now
+)
+
+// cpuOvercommitWarn is the ratio of running vCPUs to host threads above which CPU
+// contention is likely on the low-power CPUs of NAS units
+const cpuOvercommitWarn = 2.0
+
+// Host is the NAS's own resources
+type Host struct {
+	CPUs           int   `json:"cpus"` // Logical processors
+	MemTotalKB     int64 `json:"mem_total_kb"`
+	MemAvailableKB int64 `json:"mem_available_kb"`
+}
+
+// VM is one VM's allocation
+type VM struct {
+	Name     string `json:"name"`
+	Running  bool   `json:"running"`
+	CPUs     int    `json:"cpus"`
+	MemoryMB int    `json:"memory_mb"`
+	Disks    []Disk `json:"disks,omitempty"`
+}
+
+// Disk is a VM disk image; thin-provisioned images can grow to their virtual size
+type Disk struct {
+	Path        string `json:"path"`
+	Pool        string `json:"pool,omitempty"` // Empty when the image is outside the known pools
+	VirtualSize int64  `json:"virtual_size"`
+	ActualSize  int64  `json:"actual_size"`
+}
+
+// Growth returns how much more space the image can take on the pool
+func (d Disk) Growth() int64 {
+	if d.VirtualSize > d.ActualSize {
+		return d.VirtualSize - d.ActualSize
+	}
+	return 0
+}
+
+// Pool is a storage pool's space, in bytes
+type Pool struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Total int64  `json:"total"`
+	Free  int64  `json:"free"`
+}
+
+// PoolUsage is the disk space the VMs allocate in a pool
+type PoolUsage struct {
+	Pool
+	Disks       int   `json:"disks"`
+	VirtualSize int64 `json:"virtual_size"` // Sum of the images' virtual sizes
+	ActualSize  int64 `json:"actual_size"`  // Space the images occupy now
+	Growth      int64 `json:"growth"`       // Space the images can still take
+}
+
+// Overcommitted reports whether the pool cannot hold its images at full size
+func (p PoolUsage) Overcommitted() bool {
+	return p.Growth > p.Free
+}
+
+// Warning is an overcommitted resource
+type Warning struct {
+	Level    string `json:"level"`
+	Resource string `json:"resource"` // cpu, memory or the pool name
+	Message  string `json:"message"`
+}
+
+// Report compares the VMs' allocations with the host
+type Report struct {
+	Host Host `json:"host"`
+	VMs  []VM `json:"vms"`
+
+	VCPUs          int   `json:"vcpus"`         // Allocated to all VMs
+	RunningVCPUs   int   `json:"running_vcpus"` // Allocated to running VMs
+	MemoryMB       int   `json:"memory_mb"`
+	RunningMemory  int   `json:"running_memory_mb"`
+	StoppedMemory  int   `json:"stopped_memory_mb"`
+	UnpooledDisks  int   `json:"unpooled_disks,omitempty"` // Images outside the known pools
+	UnpooledGrowth int64 `json:"unpooled_growth,omitempty"`
+
+	Pools    []PoolUsage `json:"pools"`
+	Warnings []Warning   `json:"warnings"`
+}
+
+// CPURatio returns running vCPUs per host thread
+func (r *Report) CPURatio() float64 {
+	if r.Host.CPUs == 0 {
+		return 0
+	}
+	return float64(r.RunningVCPUs) / float64(r.Host.CPUs)
+}
+
+// Build sums the VMs' allocations per resource and pool and lists the overcommitted
+// resources. Pools no VM uses are included, so their free space can be compared.
+func Build(host Host, vms []VM, pools []Pool) *Report {
+	report := &Report{Host: host, VMs: vms}
+
+	usage := make(map[string]*PoolUsage)
+	for _, pool := range pools {
+		usage[pool.Name] = &PoolUsage{Pool: pool}
+	}
+	for _, vm := range vms {
+		report.VCPUs += vm.CPUs
+		report.MemoryMB += vm.MemoryMB
+		if vm.Running {
+			report.RunningVCPUs += vm.CPUs
+			report.RunningMemory += vm.MemoryMB
+		} else {
+			report.StoppedMemory += vm.MemoryMB
+		}
+		for _, disk := range vm.Disks {
+			pool, ok := usage[disk.Pool]
+			if !ok {
+				report.UnpooledDisks++
+				report.UnpooledGrowth += disk.Growth()
+				continue
+			}
+			pool.Disks++
+			pool.VirtualSize += disk.VirtualSize
+			pool.ActualSize += disk.ActualSize
+			pool.Growth += disk.Growth()
+		}
+	}
+	for _, pool := range pools {
+		report.Pools = append(report.Pools, *usage[pool.Name])
+	}
+	sort.Slice(report.Pools, func(i, j int) bool { return report.Pools[i].Name < report.Pools[j].Name })
+
+	report.Warnings = report.warnings()
+	return report
+}
+
+// warnings lists the overcommitted resources, most severe first
+func (r *Report) warnings() []Warning {
+	var warnings []Warning
+	add := func(level, resource, format string, args ...interface{}) {
+		warnings = append(warnings, Warning{Level: level, Resource: resource, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// MemAvailable already excludes what running VMs use, so starting the stopped
+	// ones must fit in it
+	totalMB := int(r.Host.MemTotalKB / 1024)
+	availableMB := int(r.Host.MemAvailableKB / 1024)
+	switch {
+	case totalMB > 0 && r.RunningMemory > totalMB:
+		add(LevelError, "memory", "running VMs are allocated %d MB but the NAS has %d MB; the guests are swapping or ballooned", r.RunningMemory, totalMB)
+	case r.Host.MemTotalKB > 0 && r.StoppedMemory > availableMB:
+		add(LevelWarning, "memory", "starting all stopped VMs needs %d MB but only %d MB is available", r.StoppedMemory, availableMB)
+	}
+
+	if r.Host.CPUs > 0 {
+		for _, vm := range r.VMs {
+			if vm.CPUs > r.Host.CPUs {
+				add(LevelWarning, "cpu", "VM '%s' has %d vCPUs but the NAS has %d threads; it runs slower than with %d", vm.Name, vm.CPUs, r.Host.CPUs, r.Host.CPUs)
+			}
+		}
+		if ratio := r.CPURatio(); ratio > cpuOvercommitWarn {
+			add(LevelWarning, "cpu", "running VMs have %d vCPUs on %d threads (%.1f:1); busy guests will contend for the CPU", r.RunningVCPUs, r.Host.CPUs, ratio)
+		}
+	}
+
+	for _, pool := range r.Pools {
+		if pool.Overcommitted() {
+			add(LevelWarning, pool.Name, "thin-provisioned disks in pool '%s' can grow by %s but only %s is free", pool.Name, formatGiB(pool.Growth), formatGiB(pool.Free))
+		}
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].Level == LevelError && warnings[j].Level != LevelError
+	})
+	return warnings
+}
+
+// formatGiB formats a byte count in GiB, matching the storage pool units
+func formatGiB(bytes int64) string {
+	return fmt.Sprintf("%.1f GB", float64(bytes)/(1<<30))
+}
@@ -0,0 +1,69 @@
+package capacity
+
+import (
+	"strings"
+	"testing"
+)
+
+const gib = int64(1) << 30
+
+func TestBuild(t *testing.T) {
+	host := Host{CPUs: 4, MemTotalKB: 8 << 20, MemAvailableKB: 3 << 20}
+	vms := []VM{
+		{Name: "web", Running: true, CPUs: 4, MemoryMB: 2048, Disks: []Disk{
+			{Path: "/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2", Pool: "DataVol1", VirtualSize: 100 * gib, ActualSize: 10 * gib},
+		}},
+		{Name: "db", Running: true, CPUs: 6, MemoryMB: 2048, Disks: []Disk{
+			{Path: "/share/CACHEDEV1_DATA/.qnap-vm/disks/db.qcow2", Pool: "DataVol1", VirtualSize: 50 * gib, ActualSize: 60 * gib},
+			{Path: "/mnt/other/db.img", VirtualSize: 20 * gib, ActualSize: 5 * gib},
+		}},
+		{Name: "lab", CPUs: 2, MemoryMB: 4096},
+	}
+	pools := []Pool{
+		{Name: "DataVol2", Path: "/share/CACHEDEV2_DATA", Total: 1000 * gib, Free: 900 * gib},
+		{Name: "DataVol1", Path: "/share/CACHEDEV1_DATA", Total: 500 * gib, Free: 50 * gib},
+	}
+
+	report := Build(host, vms, pools)
+	if report.VCPUs != 12 || report.RunningVCPUs != 10 || report.MemoryMB != 8192 || report.RunningMemory != 4096 || report.StoppedMemory != 4096 {
+		t.Errorf("totals = %+v", report)
+	}
+	if report.CPURatio() != 2.5 {
+		t.Errorf("CPURatio = %v", report.CPURatio())
+	}
+	if len(report.Pools) != 2 || report.Pools[0].Name != "DataVol1" || report.Pools[0].Disks != 2 ||
+		report.Pools[0].VirtualSize != 150*gib || report.Pools[0].Growth != 90*gib || !report.Pools[0].Overcommitted() {
+		t.Errorf("pools = %+v", report.Pools)
+	}
+	if report.Pools[1].Overcommitted() || report.UnpooledDisks != 1 || report.UnpooledGrowth != 15*gib {
+		t.Errorf("unused pool or unpooled disks = %+v / %d %d", report.Pools[1], report.UnpooledDisks, report.UnpooledGrowth)
+	}
+
+	var resources []string
+	for _, warning := range report.Warnings {
+		resources = append(resources, warning.Resource)
+	}
+	if got := strings.Join(resources, ","); got != "memory,cpu,cpu,DataVol1" {
+		t.Fatalf("warnings = %+v", report.Warnings)
+	}
+	if !strings.Contains(report.Warnings[0].Message, "needs 4096 MB but only 3072 MB") ||
+		!strings.Contains(report.Warnings[1].Message, "VM 'db' has 6 vCPUs") ||
+		!strings.Contains(report.Warnings[3].Message, "grow by 90.0 GB but only 50.0 GB") {
+		t.Errorf("warnings = %+v", report.Warnings)
+	}
+}
+
+func TestBuildMemoryError(t *testing.T) {
+	host := Host{CPUs: 8, MemTotalKB: 4 << 20, MemAvailableKB: 1 << 20}
+	report := Build(host, []VM{
+		{Name: "big", Running: true, CPUs: 2, MemoryMB: 6144},
+		{Name: "small", CPUs: 1, MemoryMB: 512},
+	}, nil)
+	if len(report.Warnings) != 1 || report.Warnings[0].Level != LevelError || report.Warnings[0].Resource != "memory" {
+		t.Errorf("warnings = %+v", report.Warnings)
+	}
+
+	if report := Build(Host{}, []VM{{Name: "web", CPUs: 2, MemoryMB: 1024}}, nil); len(report.Warnings) != 0 {
+		t.Errorf("warnings without host data = %+v", report.Warnings)
+	}
+}
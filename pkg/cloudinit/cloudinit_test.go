@@ -0,0 +1,131 @@
+package cloudinit
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// readISO returns the volume label and root directory files of an image from buildISO
+func readISO(t *testing.T, image []byte) (string, map[string]string) {
+	t.Helper()
+	pvd := image[pvdSector*sectorSize:]
+	if pvd[0] != 1 || string(pvd[1:6]) != "CD001" {
+		t.Fatalf("no primary volume descriptor")
+	}
+	if size := binary.LittleEndian.Uint32(pvd[80:]); int(size)*sectorSize != len(image) {
+		t.Fatalf("volume size %d sectors, image %d bytes", size, len(image))
+	}
+	label := strings.TrimRight(string(pvd[40:72]), " ")
+
+	root := pvd[156:]
+	dirStart := int(binary.LittleEndian.Uint32(root[2:])) * sectorSize
+	dirSize := int(binary.LittleEndian.Uint32(root[10:]))
+	dir := image[dirStart : dirStart+dirSize]
+
+	files := make(map[string]string)
+	for offset := 0; offset < len(dir) && dir[offset] != 0; offset += int(dir[offset]) {
+		record := dir[offset:]
+		name := string(record[33 : 33+int(record[32])])
+		if record[25]&2 != 0 {
+			continue // "." and ".."
+		}
+		extent := int(binary.LittleEndian.Uint32(record[2:])) * sectorSize
+		size := int(binary.LittleEndian.Uint32(record[10:]))
+		files[name] = string(image[extent : extent+size])
+	}
+	return label, files
+}
+
+func TestSeedISO(t *testing.T) {
+	network, err := NewNetwork("52:54:00:12:34:56", "192.168.1.50/24", "192.168.1.1", []string{"1.1.1.1", "9.9.9.9"})
+	if err != nil {
+		t.Fatalf("NewNetwork failed: %v", err)
+	}
+	seed := &Seed{InstanceID: "web", Hostname: "web", Network: network}
+	image, err := seed.ISO()
+	if err != nil {
+		t.Fatalf("ISO failed: %v", err)
+	}
+
+	label, files := readISO(t, image)
+	if label != "cidata" {
+		t.Errorf("label = %q", label)
+	}
+	if len(files) != 3 {
+		t.Fatalf("files = %v", files)
+	}
+	if files["META-DATA.;1"] != "instance-id: web\nlocal-hostname: web\n" {
+		t.Errorf("meta-data = %q", files["META-DATA.;1"])
+	}
+	if files["USER-DATA.;1"] != "#cloud-config\n" {
+		t.Errorf("user-data = %q", files["USER-DATA.;1"])
+	}
+	wantNetwork := `version: 2
+ethernets:
+    nic0:
+        match:
+            macaddress: "52:54:00:12:34:56"
+        addresses:
+            - 192.168.1.50/24
+        routes:
+            - to: 0.0.0.0/0
+              via: 192.168.1.1
+        nameservers:
+            addresses:
+                - 1.1.1.1
+                - 9.9.9.9
+`
+	if files["NETWORK-CONFIG.;1"] != wantNetwork {
+		t.Errorf("network-config = %q, want %q", files["NETWORK-CONFIG.;1"], wantNetwork)
+	}
+}
+
+func TestSeedWithoutNetwork(t *testing.T) {
+	seed := &Seed{InstanceID: "db", Hostname: "db", UserData: []byte("#cloud-config\npackages: [htop]\n")}
+	files, err := seed.Files()
+	if err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	if _, ok := files["network-config"]; ok {
+		t.Error("network-config without a static address")
+	}
+	if string(files["user-data"]) != "#cloud-config\npackages: [htop]\n" {
+		t.Errorf("user-data = %q", files["user-data"])
+	}
+}
+
+func TestNewNetwork(t *testing.T) {
+	for _, tt := range []struct {
+		address, gateway string
+		dns              []string
+		wantErr          bool
+	}{
+		{"192.168.1.50/24", "", nil, false},
+		{"fd00::50/64", "fd00::1", nil, false},
+		{"192.168.1.50", "", nil, true},
+		{"192.168.1.0/24", "", nil, true},
+		{"192.168.1.50/24", "192.168.2.1", nil, true},
+		{"192.168.1.50/24", "192.168.1.50", nil, true},
+		{"192.168.1.50/24", "", []string{"dns.example"}, true},
+	} {
+		_, err := NewNetwork("52:54:00:12:34:56", tt.address, tt.gateway, tt.dns)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewNetwork(%q, %q, %v) error = %v, want error %v", tt.address, tt.gateway, tt.dns, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHostname(t *testing.T) {
+	for name, want := range map[string]string{
+		"web":            "web",
+		"Build Server 2": "build-server-2",
+		"db_primary.":    "db-primary",
+		"ümlaut":         "mlaut",
+		"---":            "vm",
+	} {
+		if got := Hostname(name); got != want {
+			t.Errorf("Hostname(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package cloudinit
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// sectorSize is the ISO 9660 logical block size
+const sectorSize = 2048
+
+// Sectors of the image: the volume descriptors follow a 16-sector system area, then
+// come the two path tables, the root directory and the file data
+const (
+	pvdSector        = 16
+	terminatorSector = 17
+	lPathTableSector = 18
+	mPathTableSector = 19
+	rootDirSector    = 20
+	firstFileSector  = 21
+)
+
+// buildISO returns an ISO 9660 image with files in its root directory. Names are
+// recorded as "NAME.;1", which Linux presents as the lowercase name without Rock Ridge
+// extensions; that is all cloud-init's NoCloud datasource needs.
+func buildISO(label string, files map[string][]byte) []byte {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	// Directory records are sorted by identifier
+	sort.Slice(names, func(i, j int) bool { return isoName(names[i]) < isoName(names[j]) })
+
+	sector := uint32(firstFileSector)
+	extents := make([]uint32, len(names))
+	for i, name := range names {
+		extents[i] = sector
+		sector += sectorsFor(len(files[name]))
+	}
+	image := make([]byte, int(sector)*sectorSize)
+
+	// Root directory: ".", ".." and the files
+	dir := image[rootDirSector*sectorSize : (rootDirSector+1)*sectorSize]
+	offset := copy(dir, dirRecord([]byte{0}, rootDirSector, sectorSize, true))
+	offset += copy(dir[offset:], dirRecord([]byte{1}, rootDirSector, sectorSize, true))
+	for i, name := range names {
+		offset += copy(dir[offset:], dirRecord([]byte(isoName(name)), extents[i], len(files[name]), false))
+		copy(image[int(extents[i])*sectorSize:], files[name])
+	}
+
+	// Path tables with the root directory alone
+	lTable := image[lPathTableSector*sectorSize:]
+	lTable[0] = 1
+	binary.LittleEndian.PutUint32(lTable[2:], rootDirSector)
+	binary.LittleEndian.PutUint16(lTable[6:], 1)
+	mTable := image[mPathTableSector*sectorSize:]
+	mTable[0] = 1
+	binary.BigEndian.PutUint32(mTable[2:], rootDirSector)
+	binary.BigEndian.PutUint16(mTable[6:], 1)
+
+	// Primary volume descriptor
+	pvd := image[pvdSector*sectorSize : (pvdSector+1)*sectorSize]
+	pvd[0] = 1
+	copy(pvd[1:], "CD001")
+	pvd[6] = 1
+	fill(pvd[8:40], ' ')
+	fill(pvd[40:72], ' ')
+	copy(pvd[40:72], label)
+	bothEndian32(pvd[80:], sector)
+	bothEndian16(pvd[120:], 1)
+	bothEndian16(pvd[124:], 1)
+	bothEndian16(pvd[128:], sectorSize)
+	bothEndian32(pvd[132:], 10)
+	binary.LittleEndian.PutUint32(pvd[140:], lPathTableSector)
+	binary.BigEndian.PutUint32(pvd[148:], mPathTableSector)
+	copy(pvd[156:190], dirRecord([]byte{0}, rootDirSector, sectorSize, true))
+	fill(pvd[190:813], ' ')
+	// Unset creation, modification, expiration and effective dates
+	for _, date := range []int{813, 830, 847, 864} {
+		fill(pvd[date:date+16], '0')
+	}
+	pvd[881] = 1
+
+	// Volume descriptor set terminator
+	terminator := image[terminatorSector*sectorSize:]
+	terminator[0] = 255
+	copy(terminator[1:], "CD001")
+	terminator[6] = 1
+
+	return image
+}
+
+// isoName returns the ISO 9660 file identifier for a file name
+func isoName(name string) string {
+	upper := []byte(name)
+	for i, c := range upper {
+		if c >= 'a' && c <= 'z' {
+			upper[i] = c - 'a' + 'A'
+		}
+	}
+	return string(upper) + ".;1"
+}
+
+// dirRecord returns a directory record for an extent
+func dirRecord(identifier []byte, extent uint32, size int, dir bool) []byte {
+	length := 33 + len(identifier)
+	if length%2 != 0 {
+		length++
+	}
+	record := make([]byte, length)
+	record[0] = byte(length)
+	bothEndian32(record[2:], extent)
+	bothEndian32(record[10:], uint32(size))
+	if dir {
+		record[25] = 2
+	}
+	bothEndian16(record[28:], 1)
+	record[32] = byte(len(identifier))
+	copy(record[33:], identifier)
+	return record
+}
+
+// sectorsFor returns the sectors n bytes occupy
+func sectorsFor(n int) uint32 {
+	return uint32((n + sectorSize - 1) / sectorSize)
+}
+
+// bothEndian32 writes v little-endian then big-endian, as ISO 9660 numbers are stored
+func bothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+// bothEndian16 writes v little-endian then big-endian
+func bothEndian16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+// fill sets every byte of b to c
+func fill(b []byte, c byte) {
+	for i := range b {
+		b[i] = c
+	}
+}
@@ -0,0 +1,159 @@
+// Package cloudinit builds NoCloud seed images that configure a VM's guest on first
+// boot through cloud-init
+package cloudinit
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Label is the volume label cloud-init's NoCloud datasource looks for
+const Label = "cidata"
+
+// Network is a static address for a guest's network card
+type Network struct {
+	MAC     string   // Matches the guest interface to configure
+	Address string   // Address in CIDR form, e.g. 192.168.1.50/24
+	Gateway string   // Default gateway; "" for none
+	DNS     []string // Name servers
+}
+
+// NewNetwork validates a static address, gateway and name servers for the interface
+// with mac
+func NewNetwork(mac, address, gateway string, dns []string) (*Network, error) {
+	ip, subnet, err := net.ParseCIDR(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP address %q (expected CIDR form, e.g. 192.168.1.50/24)", address)
+	}
+	if ip.Equal(subnet.IP) {
+		return nil, fmt.Errorf("%s is the network address of %s", ip, subnet)
+	}
+	if gateway != "" {
+		gw := net.ParseIP(gateway)
+		if gw == nil {
+			return nil, fmt.Errorf("invalid gateway %q", gateway)
+		}
+		if !subnet.Contains(gw) {
+			return nil, fmt.Errorf("gateway %s is not in %s", gw, subnet)
+		}
+		if gw.Equal(ip) {
+			return nil, fmt.Errorf("gateway %s is the VM's own address", gw)
+		}
+	}
+	for _, server := range dns {
+		if net.ParseIP(server) == nil {
+			return nil, fmt.Errorf("invalid DNS server %q", server)
+		}
+	}
+	return &Network{MAC: mac, Address: address, Gateway: gateway, DNS: dns}, nil
+}
+
+// Seed is the configuration cloud-init reads from a NoCloud seed
+type Seed struct {
+	InstanceID string
+	Hostname   string
+	UserData   []byte   // nil gives an empty #cloud-config
+	Network    *Network // nil leaves networking to cloud-init's default, DHCP
+}
+
+// networkConfig is a version 2 (netplan-style) network-config document
+type networkConfig struct {
+	Version   int                          `yaml:"version"`
+	Ethernets map[string]ethernetInterface `yaml:"ethernets"`
+}
+
+type ethernetInterface struct {
+	Match struct {
+		MACAddress string `yaml:"macaddress"`
+	} `yaml:"match"`
+	Addresses   []string `yaml:"addresses"`
+	Routes      []route  `yaml:"routes,omitempty"`
+	Nameservers *struct {
+		Addresses []string `yaml:"addresses"`
+	} `yaml:"nameservers,omitempty"`
+}
+
+type route struct {
+	To  string `yaml:"to"`
+	Via string `yaml:"via"`
+}
+
+// Files returns the seed's meta-data, user-data and network-config files
+func (s *Seed) Files() (map[string][]byte, error) {
+	metaData, err := yaml.Marshal(map[string]string{"instance-id": s.InstanceID, "local-hostname": s.Hostname})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode meta-data: %w", err)
+	}
+	userData := s.UserData
+	if userData == nil {
+		userData = []byte("#cloud-config\n")
+	}
+	files := map[string][]byte{"meta-data": metaData, "user-data": userData}
+
+	if s.Network != nil {
+		networkData, err := s.Network.config()
+		if err != nil {
+			return nil, err
+		}
+		files["network-config"] = networkData
+	}
+	return files, nil
+}
+
+// config renders the network-config file
+func (n *Network) config() ([]byte, error) {
+	iface := ethernetInterface{Addresses: []string{n.Address}}
+	iface.Match.MACAddress = n.MAC
+	if n.Gateway != "" {
+		defaultRoute := "0.0.0.0/0"
+		if net.ParseIP(n.Gateway).To4() == nil {
+			defaultRoute = "::/0"
+		}
+		iface.Routes = []route{{To: defaultRoute, Via: n.Gateway}}
+	}
+	if len(n.DNS) > 0 {
+		iface.Nameservers = &struct {
+			Addresses []string `yaml:"addresses"`
+		}{Addresses: n.DNS}
+	}
+
+	data, err := yaml.Marshal(networkConfig{Version: 2, Ethernets: map[string]ethernetInterface{"nic0": iface}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode network-config: %w", err)
+	}
+	return data, nil
+}
+
+// ISO returns the seed as a "cidata" ISO 9660 image to attach as a CD-ROM
+func (s *Seed) ISO() ([]byte, error) {
+	files, err := s.Files()
+	if err != nil {
+		return nil, err
+	}
+	return buildISO(Label, files), nil
+}
+
+// Hostname returns a valid hostname for a VM name: lowercase letters, digits and
+// hyphens, at most 63 characters
+func Hostname(vmName string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(vmName) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('-')
+		}
+	}
+	hostname := strings.Trim(b.String(), "-")
+	if len(hostname) > 63 {
+		hostname = strings.TrimRight(hostname[:63], "-")
+	}
+	if hostname == "" {
+		return "vm"
+	}
+	return hostname
+}
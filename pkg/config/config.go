@@ -3,25 +3,154 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the configuration for connecting to a QNAP device
 type Config struct {
-	Host     string `yaml:"host" json:"host"`
-	Username string `yaml:"username" json:"username"`
-	Port     int    `yaml:"port" json:"port"`
-	KeyFile  string `yaml:"keyfile" json:"keyfile"`
-	Password string `yaml:"password,omitempty" json:"password,omitempty"`
+	Host      string           `yaml:"host" json:"host"`
+	Username  string           `yaml:"username" json:"username"`
+	Port      int              `yaml:"port" json:"port"`
+	KeyFile   string           `yaml:"keyfile" json:"keyfile"`
+	Password  string           `yaml:"password,omitempty" json:"password,omitempty"`
+	Qcow2     Qcow2Defaults    `yaml:"qcow2,omitempty" json:"qcow2,omitempty"`
+	Devices   DeviceDefaults   `yaml:"devices,omitempty" json:"devices,omitempty"`
+	Pools     []PoolConfig     `yaml:"pools,omitempty" json:"pools,omitempty"`
+	Placement string           `yaml:"placement,omitempty" json:"placement,omitempty"` // Default pool placement for new VMs
+	S3        S3Config         `yaml:"s3,omitempty" json:"s3,omitempty"`
+	Backups   []BackupSchedule `yaml:"backup_schedules,omitempty" json:"backup_schedules,omitempty"`
+	Notify    NotifyConfig     `yaml:"notify,omitempty" json:"notify,omitempty"`
+
+	// RegisterQVS adds the VMs qnap-vm creates to Virtualization Station's own database,
+	// so its web UI lists them. The database is undocumented, so this is opt-in.
+	RegisterQVS bool `yaml:"register_qvs,omitempty" json:"register_qvs,omitempty"`
+
+	// PasswordKeychain is the OS keychain account holding the password, instead of Password
+	PasswordKeychain string `yaml:"password_keychain,omitempty" json:"password_keychain,omitempty"`
+	// Fingerprint pins the host's SSH key (SHA256:...), checked instead of known_hosts
+	Fingerprint string `yaml:"fingerprint,omitempty" json:"fingerprint,omitempty"`
+	// Elevate runs commands through sudo or su, for hosts where admin SSH is disabled
+	Elevate string `yaml:"elevate,omitempty" json:"elevate,omitempty"`
+	// ElevateUser is the account to become: root for sudo and admin for su when empty
+	ElevateUser string `yaml:"elevate_user,omitempty" json:"elevate_user,omitempty"`
+	// ElevatePassword answers su's prompt; sudo is answered with Password
+	ElevatePassword string `yaml:"elevate_password,omitempty" json:"elevate_password,omitempty"`
+	// ElevatePasswordKeychain is the OS keychain account holding ElevatePassword
+	ElevatePasswordKeychain string `yaml:"elevate_password_keychain,omitempty" json:"elevate_password_keychain,omitempty"`
+	// ProbePorts are tried when nothing answers on Port, e.g. [2222, 22]
+	ProbePorts []int `yaml:"probe_ports,omitempty" json:"probe_ports,omitempty"`
+
+	// Backend is how VMs are managed: BackendSSH (virsh over SSH) when empty, or BackendAPI
+	Backend string `yaml:"backend,omitempty" json:"backend,omitempty"`
+	// APIURL is the Virtualization Station address for BackendAPI, https://Host when empty
+	APIURL string `yaml:"api_url,omitempty" json:"api_url,omitempty"`
+	// APIFingerprint pins the API's TLS certificate (SHA256:...) instead of verifying its CA
+	APIFingerprint string `yaml:"api_fingerprint,omitempty" json:"api_fingerprint,omitempty"`
+
+	// HostName is the config file entry the values were read from, if any
+	HostName string `yaml:"-" json:"-"`
+	// Sources records where each effective value came from, keyed by field name
+	Sources map[string]string `yaml:"-" json:"-"`
+}
+
+// Qcow2Defaults are the qcow2 tuning options applied to new disks on a host
+type Qcow2Defaults struct {
+	ClusterSize     string `yaml:"cluster_size,omitempty" json:"cluster_size,omitempty"`
+	CompressionType string `yaml:"compression_type,omitempty" json:"compression_type,omitempty"`
+	LazyRefcounts   bool   `yaml:"lazy_refcounts,omitempty" json:"lazy_refcounts,omitempty"`
+}
+
+// DeviceDefaults are the video, input and sound devices given to new VMs on a host
+type DeviceDefaults struct {
+	Video  string `yaml:"video,omitempty" json:"video,omitempty"`   // virtio, qxl, vga, ...; libvirt's default when empty
+	Tablet bool   `yaml:"tablet,omitempty" json:"tablet,omitempty"` // USB tablet for accurate VNC mouse tracking
+	Sound  string `yaml:"sound,omitempty" json:"sound,omitempty"`   // ich9, ich6 or ac97; no sound when empty
+}
+
+// PoolConfig declares a storage pool on an NFS or SMB share mounted on the host
+type PoolConfig struct {
+	Name string `yaml:"name" json:"name"`
+	Path string `yaml:"path" json:"path"`
+	Type string `yaml:"type,omitempty" json:"type,omitempty"` // nfs or smb; detected from the mount when empty
+}
+
+// S3Config holds the endpoint and credentials for backups to S3-compatible object storage
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint,omitempty" json:"endpoint,omitempty"` // e.g. https://s3.eu-central-1.wasabisys.com; AWS when empty
+	Region    string `yaml:"region,omitempty" json:"region,omitempty"`
+	AccessKey string `yaml:"access_key,omitempty" json:"access_key,omitempty"`
+	SecretKey string `yaml:"secret_key,omitempty" json:"secret_key,omitempty"`
+	PathStyle bool   `yaml:"path_style,omitempty" json:"path_style,omitempty"` // Address buckets as endpoint/bucket, as MinIO needs
+}
+
+// BackupSchedule is a recurring backup of one VM, run by 'qnap-vm backup run-scheduled'
+type BackupSchedule struct {
+	VM          string `yaml:"vm" json:"vm"`
+	Dest        string `yaml:"dest" json:"dest"`
+	Local       bool   `yaml:"local,omitempty" json:"local,omitempty"` // Dest is on the machine running qnap-vm
+	Every       string `yaml:"every" json:"every"`                     // hourly, daily or weekly (Sundays)
+	At          string `yaml:"at,omitempty" json:"at,omitempty"`       // HH:MM; only the minute counts for hourly
+	Keep        int    `yaml:"keep,omitempty" json:"keep,omitempty"`   // Newest sets to keep; 0 keeps all
+	Incremental bool   `yaml:"incremental,omitempty" json:"incremental,omitempty"`
+}
+
+// NotifyConfig says where alerts about a host's VMs are sent
+type NotifyConfig struct {
+	// QTS writes alerts to the NAS's system event log, which QTS Notification Center forwards
+	QTS     bool   `yaml:"qts,omitempty" json:"qts,omitempty"`
+	Webhook string `yaml:"webhook,omitempty" json:"webhook,omitempty"` // URL receiving a JSON POST per alert
+	// Email recipients, mailed through the SMTP server at SMTP (host:port)
+	Email        []string `yaml:"email,omitempty" json:"email,omitempty"`
+	SMTP         string   `yaml:"smtp,omitempty" json:"smtp,omitempty"`
+	SMTPUsername string   `yaml:"smtp_username,omitempty" json:"smtp_username,omitempty"`
+	SMTPPassword string   `yaml:"smtp_password,omitempty" json:"smtp_password,omitempty"`
+	From         string   `yaml:"from,omitempty" json:"from,omitempty"` // Sender address; the first recipient when empty
+	// Events limits alerts to these NotifyEvents; all are sent when empty
+	Events []string `yaml:"events,omitempty" json:"events,omitempty"`
+}
+
+// NotifyEvents are the events qnap-vm sends alerts for
+var NotifyEvents = []string{"vm-crashed", "backup-failed", "backup-pruned", "snapshot-pruned"}
+
+// Enabled reports whether alerts go anywhere
+func (n NotifyConfig) Enabled() bool {
+	return n.QTS || n.Webhook != "" || len(n.Email) > 0
+}
+
+// Backends for Config.Backend
+const (
+	BackendSSH = "ssh" // virsh and shell commands over SSH
+	BackendAPI = "api" // the Virtualization Station HTTP API, for hosts without SSH
+)
+
+// fingerprintPattern matches a SHA-256 fingerprint as ssh-keygen -l prints it
+var fingerprintPattern = regexp.MustCompile(`^SHA256:[A-Za-z0-9+/]{43}$`)
+
+// scheduleTimePattern matches a schedule's HH:MM time of day
+var scheduleTimePattern = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+
+// APIToken grants a client of 'qnap-vm serve' the access of its role. Only the
+// token's SHA-256 hash is stored.
+type APIToken struct {
+	Name    string    `yaml:"name" json:"name"`
+	Hash    string    `yaml:"hash" json:"-"`
+	Role    string    `yaml:"role" json:"role"` // viewer, operator or admin
+	Created time.Time `yaml:"created" json:"created"`
 }
 
 // ConfigFile represents the structure of the configuration file
 type ConfigFile struct {
 	DefaultHost string            `yaml:"default_host" json:"default_host"`
 	Hosts       map[string]Config `yaml:"hosts" json:"hosts"`
+	Tokens      []APIToken        `yaml:"tokens,omitempty" json:"tokens,omitempty"`
 }
 
 const (
@@ -29,15 +158,44 @@ const (
 	configFile = "config.yaml"
 )
 
-// GetConfigPath returns the path to the configuration file
+// ConfigPathEnv names an alternate configuration file, e.g. a shared team config
+const ConfigPathEnv = "QNAP_VM_CONFIG"
+
+// configPath is the configuration file set with SetConfigPath
+var configPath string
+
+// SetConfigPath makes LoadConfig and SaveConfig use path instead of the default file.
+// It takes precedence over QNAP_VM_CONFIG.
+func SetConfigPath(path string) {
+	configPath = path
+}
+
+// GetConfigPath returns the path to the configuration file: the one set with
+// SetConfigPath, else QNAP_VM_CONFIG, else ~/.qnap-vm/config.yaml
 func GetConfigPath() (string, error) {
+	if configPath != "" {
+		return configPath, nil
+	}
+	if path := os.Getenv(ConfigPathEnv); path != "" {
+		return path, nil
+	}
+
+	dataDir, err := GetDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, configFile), nil
+}
+
+// GetDataDir returns ~/.qnap-vm, which holds the user's caches, translations and tools.
+// It stays per user when the configuration file is elsewhere, since a shared config
+// may be read-only.
+func GetDataDir() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("failed to get home directory: %w", err)
 	}
-
-	configPath := filepath.Join(homeDir, configDir, configFile)
-	return configPath, nil
+	return filepath.Join(homeDir, configDir), nil
 }
 
 // LoadConfig loads configuration from file
@@ -98,19 +256,25 @@ func SaveConfig(cfg *ConfigFile) error {
 
 // GetHostConfig returns configuration for a specific host
 func (cf *ConfigFile) GetHostConfig(hostName string) (Config, bool) {
+	config, exists := cf.Hosts[cf.ResolveHostName(hostName)]
+	return config, exists
+}
+
+// ResolveHostName returns the host entry name used for hostName: the name itself,
+// the default host, or the only configured host
+func (cf *ConfigFile) ResolveHostName(hostName string) string {
 	if hostName == "" {
 		hostName = cf.DefaultHost
 	}
 
 	if hostName == "" && len(cf.Hosts) == 1 {
 		// If there's only one host configured, use it as default
-		for _, config := range cf.Hosts {
-			return config, true
+		for name := range cf.Hosts {
+			return name
 		}
 	}
 
-	config, exists := cf.Hosts[hostName]
-	return config, exists
+	return hostName
 }
 
 // SetHostConfig sets configuration for a specific host
@@ -146,6 +310,93 @@ func (c *Config) Validate() error {
 	if c.Port <= 0 || c.Port > 65535 {
 		return fmt.Errorf("invalid port number: %d", c.Port)
 	}
+	switch c.Elevate {
+	case "", "sudo", "su":
+	default:
+		return fmt.Errorf("invalid elevation method '%s' (use sudo or su)", c.Elevate)
+	}
+	switch c.Backend {
+	case "", BackendSSH, BackendAPI:
+	default:
+		return fmt.Errorf("invalid backend '%s' (use ssh or api)", c.Backend)
+	}
+	if c.APIURL != "" && !strings.HasPrefix(c.APIURL, "https://") && !strings.HasPrefix(c.APIURL, "http://") {
+		return fmt.Errorf("API URL must be an http:// or https:// URL: %s", c.APIURL)
+	}
+	if c.APIFingerprint != "" && !fingerprintPattern.MatchString(c.APIFingerprint) {
+		return fmt.Errorf("invalid API certificate fingerprint '%s' (use the SHA256:... form)", c.APIFingerprint)
+	}
+	for _, port := range c.ProbePorts {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid probe port number: %d", port)
+		}
+	}
+	if c.Fingerprint != "" && !fingerprintPattern.MatchString(c.Fingerprint) {
+		return fmt.Errorf("invalid host key fingerprint '%s' (use the SHA256:... form from ssh-keygen -l)", c.Fingerprint)
+	}
+	for _, pool := range c.Pools {
+		if pool.Name == "" || !strings.HasPrefix(pool.Path, "/") {
+			return fmt.Errorf("pool entries need a name and an absolute path")
+		}
+		switch strings.ToLower(pool.Type) {
+		case "", "nfs", "smb", "cifs":
+		default:
+			return fmt.Errorf("invalid type '%s' for pool '%s' (use nfs or smb)", pool.Type, pool.Name)
+		}
+	}
+	switch c.Placement {
+	case "", "best", "most-free", "spread", "affinity":
+	default:
+		return fmt.Errorf("invalid placement '%s' (use best, most-free, spread or affinity)", c.Placement)
+	}
+	if c.S3.Endpoint != "" && !strings.HasPrefix(c.S3.Endpoint, "https://") && !strings.HasPrefix(c.S3.Endpoint, "http://") {
+		return fmt.Errorf("S3 endpoint must be an http:// or https:// URL: %s", c.S3.Endpoint)
+	}
+	if err := c.Notify.validate(); err != nil {
+		return err
+	}
+	for _, schedule := range c.Backups {
+		if schedule.VM == "" || schedule.Dest == "" {
+			return fmt.Errorf("backup schedules need a VM and a destination")
+		}
+		switch schedule.Every {
+		case "hourly", "daily", "weekly":
+		default:
+			return fmt.Errorf("invalid frequency '%s' for the backup schedule of '%s' (use hourly, daily or weekly)", schedule.Every, schedule.VM)
+		}
+		if schedule.At != "" && !scheduleTimePattern.MatchString(schedule.At) {
+			return fmt.Errorf("invalid time '%s' for the backup schedule of '%s' (use HH:MM)", schedule.At, schedule.VM)
+		}
+		if schedule.Keep < 0 {
+			return fmt.Errorf("invalid keep count %d for the backup schedule of '%s'", schedule.Keep, schedule.VM)
+		}
+	}
+	return nil
+}
+
+// validate checks the alert destinations and event names
+func (n NotifyConfig) validate() error {
+	if n.Webhook != "" && !strings.HasPrefix(n.Webhook, "https://") && !strings.HasPrefix(n.Webhook, "http://") {
+		return fmt.Errorf("notification webhook must be an http:// or https:// URL: %s", n.Webhook)
+	}
+	for _, address := range n.Email {
+		if !strings.Contains(address, "@") {
+			return fmt.Errorf("invalid notification email address '%s'", address)
+		}
+	}
+	if len(n.Email) > 0 {
+		if n.SMTP == "" {
+			return fmt.Errorf("notification email needs an SMTP server (host:port)")
+		}
+		if _, port, err := net.SplitHostPort(n.SMTP); err != nil || port == "" {
+			return fmt.Errorf("invalid SMTP server '%s' (use host:port)", n.SMTP)
+		}
+	}
+	for _, event := range n.Events {
+		if !slices.Contains(NotifyEvents, event) {
+			return fmt.Errorf("invalid notification event '%s' (use %s)", event, strings.Join(NotifyEvents, ", "))
+		}
+	}
 	return nil
 }
 
@@ -153,6 +404,7 @@ func (c *Config) Validate() error {
 func (c *Config) SetDefaults() {
 	if c.Port == 0 {
 		c.Port = 22
+		c.setSource("port", SourceDefault)
 	}
 }
 
@@ -175,6 +427,72 @@ func (c *Config) MergeWith(other Config) Config {
 	if other.Password != "" {
 		result.Password = other.Password
 	}
+	if other.PasswordKeychain != "" {
+		result.PasswordKeychain = other.PasswordKeychain
+	}
+	if other.Fingerprint != "" {
+		result.Fingerprint = other.Fingerprint
+	}
+	if other.Elevate != "" {
+		result.Elevate = other.Elevate
+	}
+	if other.ElevateUser != "" {
+		result.ElevateUser = other.ElevateUser
+	}
+	if other.ElevatePassword != "" {
+		result.ElevatePassword = other.ElevatePassword
+	}
+	if other.ElevatePasswordKeychain != "" {
+		result.ElevatePasswordKeychain = other.ElevatePasswordKeychain
+	}
+	if other.Backend != "" {
+		result.Backend = other.Backend
+	}
+	if other.APIURL != "" {
+		result.APIURL = other.APIURL
+	}
+	if other.APIFingerprint != "" {
+		result.APIFingerprint = other.APIFingerprint
+	}
+	if len(other.ProbePorts) > 0 {
+		result.ProbePorts = other.ProbePorts
+	}
+	if other.Qcow2.ClusterSize != "" {
+		result.Qcow2.ClusterSize = other.Qcow2.ClusterSize
+	}
+	if other.Qcow2.CompressionType != "" {
+		result.Qcow2.CompressionType = other.Qcow2.CompressionType
+	}
+	if other.Qcow2.LazyRefcounts {
+		result.Qcow2.LazyRefcounts = true
+	}
+	if other.Devices.Video != "" {
+		result.Devices.Video = other.Devices.Video
+	}
+	if other.Devices.Tablet {
+		result.Devices.Tablet = true
+	}
+	if other.Devices.Sound != "" {
+		result.Devices.Sound = other.Devices.Sound
+	}
+	if len(other.Pools) > 0 {
+		result.Pools = other.Pools
+	}
+	if other.Placement != "" {
+		result.Placement = other.Placement
+	}
+	if other.S3 != (S3Config{}) {
+		result.S3 = other.S3
+	}
+	if len(other.Backups) > 0 {
+		result.Backups = other.Backups
+	}
+	if other.Notify.Enabled() {
+		result.Notify = other.Notify
+	}
+	if other.RegisterQVS {
+		result.RegisterQVS = true
+	}
 
 	return result
 }
@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -45,6 +47,179 @@ func TestConfigValidation(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "valid fingerprint",
+			config: Config{
+				Host:        "192.168.1.100",
+				Username:    "admin",
+				Port:        22,
+				Fingerprint: "SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid fingerprint",
+			config: Config{
+				Host:        "192.168.1.100",
+				Username:    "admin",
+				Port:        22,
+				Fingerprint: "MD5:16:27:ac:a5:76:28:2d:36:63:1b:56:4d:eb:df:a6:48",
+			},
+			wantErr: true,
+		},
+		{
+			name: "sudo elevation",
+			config: Config{
+				Host:     "192.168.1.100",
+				Username: "vmops",
+				Port:     22,
+				Elevate:  "sudo",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid elevation",
+			config: Config{
+				Host:     "192.168.1.100",
+				Username: "vmops",
+				Port:     22,
+				Elevate:  "doas",
+			},
+			wantErr: true,
+		},
+		{
+			name: "api backend",
+			config: Config{
+				Host:           "192.168.1.100",
+				Username:       "admin",
+				Port:           22,
+				Backend:        BackendAPI,
+				APIURL:         "https://192.168.1.100:8443",
+				APIFingerprint: "SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid backend",
+			config: Config{
+				Host:     "192.168.1.100",
+				Username: "admin",
+				Port:     22,
+				Backend:  "qvs",
+			},
+			wantErr: true,
+		},
+		{
+			name: "notifications",
+			config: Config{
+				Host:     "192.168.1.100",
+				Username: "admin",
+				Port:     22,
+				Notify: NotifyConfig{QTS: true, Webhook: "https://hooks.example.com/qnap",
+					Email: []string{"ops@example.com"}, SMTP: "mail.example.com:587", Events: []string{"vm-crashed"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "notification email without SMTP server",
+			config: Config{
+				Host:     "192.168.1.100",
+				Username: "admin",
+				Port:     22,
+				Notify:   NotifyConfig{Email: []string{"ops@example.com"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid notification event",
+			config: Config{
+				Host:     "192.168.1.100",
+				Username: "admin",
+				Port:     22,
+				Notify:   NotifyConfig{QTS: true, Events: []string{"vm-started"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "probe ports",
+			config: Config{
+				Host:       "192.168.1.100",
+				Username:   "admin",
+				Port:       22,
+				ProbePorts: []int{2222, 22},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid probe port",
+			config: Config{
+				Host:       "192.168.1.100",
+				Username:   "admin",
+				Port:       22,
+				ProbePorts: []int{2222, 70000},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid remote pool",
+			config: Config{
+				Host:     "192.168.1.100",
+				Username: "admin",
+				Port:     22,
+				Pools:    []PoolConfig{{Name: "nfs-vms", Path: "/share/nfs/vms", Type: "nfs"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "remote pool with relative path",
+			config: Config{
+				Host:     "192.168.1.100",
+				Username: "admin",
+				Port:     22,
+				Pools:    []PoolConfig{{Name: "nfs-vms", Path: "vms"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "remote pool with invalid type",
+			config: Config{
+				Host:     "192.168.1.100",
+				Username: "admin",
+				Port:     22,
+				Pools:    []PoolConfig{{Name: "vms", Path: "/share/vms", Type: "iscsi"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "S3 endpoint without scheme",
+			config: Config{
+				Host:     "192.168.1.100",
+				Username: "admin",
+				Port:     22,
+				S3:       S3Config{Endpoint: "minio.local:9000"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "daily backup schedule",
+			config: Config{
+				Host:     "192.168.1.100",
+				Username: "admin",
+				Port:     22,
+				Backups:  []BackupSchedule{{VM: "web", Dest: "/share/Backups", Every: "daily", At: "02:30", Keep: 7}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "backup schedule with invalid time",
+			config: Config{
+				Host:     "192.168.1.100",
+				Username: "admin",
+				Port:     22,
+				Backups:  []BackupSchedule{{VM: "web", Dest: "/share/Backups", Every: "daily", At: "25:00"}},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -158,3 +333,146 @@ func TestConfigFileOperations(t *testing.T) {
 		t.Errorf("Expected host 192.168.1.100, got %s", host.Host)
 	}
 }
+
+func TestGetConfigPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(ConfigPathEnv, "")
+
+	if path, err := GetConfigPath(); err != nil || path != filepath.Join(home, ".qnap-vm", "config.yaml") {
+		t.Errorf("GetConfigPath() = %q, %v; want the default", path, err)
+	}
+
+	t.Setenv(ConfigPathEnv, "/etc/qnap-vm/team.yaml")
+	if path, _ := GetConfigPath(); path != "/etc/qnap-vm/team.yaml" {
+		t.Errorf("GetConfigPath() = %q; want %s", path, ConfigPathEnv)
+	}
+
+	explicit := filepath.Join(t.TempDir(), "ci.yaml")
+	SetConfigPath(explicit)
+	defer SetConfigPath("")
+	if path, _ := GetConfigPath(); path != explicit {
+		t.Errorf("GetConfigPath() = %q; SetConfigPath should win", path)
+	}
+	if dir, _ := GetDataDir(); dir != filepath.Join(home, ".qnap-vm") {
+		t.Errorf("GetDataDir() = %q; it must not follow the config file", dir)
+	}
+
+	configFile := &ConfigFile{Hosts: map[string]Config{"ci": {Host: "ci-nas.local"}}}
+	if err := SaveConfig(configFile); err != nil {
+		t.Fatalf("SaveConfig() error: %v", err)
+	}
+	if _, err := os.Stat(explicit); err != nil {
+		t.Errorf("SaveConfig() did not write the explicit path: %v", err)
+	}
+	if loaded, err := LoadConfig(); err != nil || loaded.Hosts["ci"].Host != "ci-nas.local" {
+		t.Errorf("LoadConfig() = %+v, %v", loaded, err)
+	}
+}
+
+func TestMergeSources(t *testing.T) {
+	fileCfg := Config{Host: "192.168.1.100", Username: "admin", KeyFile: "~/.ssh/id_rsa"}
+	flagCfg := Config{Host: "192.168.1.200"}
+
+	cfg := Merge(
+		Layer{Source: FileSource("office"), Config: fileCfg},
+		Layer{Source: SourceFlag, Config: flagCfg},
+	)
+
+	expected := map[string]string{
+		"host":     SourceFlag,
+		"username": FileSource("office"),
+		"keyfile":  FileSource("office"),
+		"port":     SourceDefault,
+	}
+	for field, source := range expected {
+		if cfg.Sources[field] != source {
+			t.Errorf("Sources[%s] = %q, expected %q", field, cfg.Sources[field], source)
+		}
+	}
+	if cfg.Host != "192.168.1.200" || cfg.Port != 22 {
+		t.Errorf("Merge() = %+v", cfg)
+	}
+
+	cfg.HostName = "office"
+	description := cfg.Describe()
+	for _, want := range []string{
+		"host     = 192.168.1.200 (flag --host)",
+		"username = admin (config file, host 'office')",
+		"port     = 22 (default)",
+	} {
+		if !strings.Contains(description, want) {
+			t.Errorf("Describe() missing %q:\n%s", want, description)
+		}
+	}
+	if cfg.Label() != "office (192.168.1.200)" {
+		t.Errorf("Label() = %q", cfg.Label())
+	}
+}
+
+func TestDescribeUnset(t *testing.T) {
+	cfg := Merge(Layer{Source: SourceFlag, Config: Config{Password: "secret"}})
+
+	description := cfg.Describe()
+	if !strings.Contains(description, "host     = (not set)") {
+		t.Errorf("Describe() should report the missing host:\n%s", description)
+	}
+	if strings.Contains(description, "secret") {
+		t.Errorf("Describe() must not print the password:\n%s", description)
+	}
+}
+
+func TestDescribeKeychain(t *testing.T) {
+	cfg := Merge(
+		Layer{Source: FileSource("office"), Config: Config{Host: "192.168.1.100", Username: "admin", PasswordKeychain: "admin@192.168.1.100"}},
+		Layer{Source: SourceKeychain, Config: Config{Password: "secret"}},
+	)
+
+	if cfg.Password != "secret" || cfg.PasswordKeychain != "admin@192.168.1.100" {
+		t.Errorf("Merge() = %+v", cfg)
+	}
+	description := cfg.Describe()
+	if !strings.Contains(description, "password = ******** (keychain entry admin@192.168.1.100)") {
+		t.Errorf("Describe() should name the keychain entry:\n%s", description)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	env := map[string]string{
+		"QNAP_VM_HOST":     "ci-nas.local",
+		"QNAP_VM_PORT":     "2222",
+		"QNAP_VM_PASSWORD": "hunter2",
+	}
+	envCfg, err := FromEnv(func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatalf("FromEnv() error: %v", err)
+	}
+	if envCfg.Host != "ci-nas.local" || envCfg.Port != 2222 || envCfg.Password != "hunter2" || envCfg.Username != "" {
+		t.Errorf("FromEnv() = %+v", envCfg)
+	}
+
+	// The environment overrides the file and flags override the environment
+	cfg := Merge(
+		Layer{Source: FileSource("office"), Config: Config{Host: "192.168.1.100", Username: "admin", Port: 22}},
+		Layer{Source: SourceEnv, Config: envCfg},
+		Layer{Source: SourceFlag, Config: Config{Port: 22}},
+	)
+	if cfg.Host != "ci-nas.local" || cfg.Username != "admin" || cfg.Port != 22 {
+		t.Errorf("Merge() = %+v", cfg)
+	}
+	description := cfg.Describe()
+	for _, want := range []string{
+		"host     = ci-nas.local (environment QNAP_VM_HOST)",
+		"username = admin (config file, host 'office')",
+		"port     = 22 (flag --port)",
+	} {
+		if !strings.Contains(description, want) {
+			t.Errorf("Describe() missing %q:\n%s", want, description)
+		}
+	}
+
+	env["QNAP_VM_PORT"] = "ssh"
+	if _, err := FromEnv(func(key string) string { return env[key] }); err == nil {
+		t.Error("FromEnv() should reject a non-numeric port")
+	}
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Configuration sources reported by Config.Describe
+const (
+	SourceDefault  = "default"
+	SourceEnv      = "environment"
+	SourceFlag     = "flag"
+	SourceKeychain = "keychain"
+)
+
+// envVars are the environment variables that set each connection field, for CI systems
+// that have no config file. They override the config file and are overridden by flags.
+var envVars = map[string]string{
+	"host":     "QNAP_VM_HOST",
+	"username": "QNAP_VM_USERNAME",
+	"port":     "QNAP_VM_PORT",
+	"keyfile":  "QNAP_VM_KEYFILE",
+	"password": "QNAP_VM_PASSWORD",
+}
+
+// FromEnv reads the connection settings set in the environment through getenv
+func FromEnv(getenv func(string) string) (Config, error) {
+	cfg := Config{
+		Host:     getenv(envVars["host"]),
+		Username: getenv(envVars["username"]),
+		KeyFile:  getenv(envVars["keyfile"]),
+		Password: getenv(envVars["password"]),
+	}
+	if port := getenv(envVars["port"]); port != "" {
+		value, err := strconv.Atoi(port)
+		if err != nil || value < 1 || value > 65535 {
+			return Config{}, fmt.Errorf("invalid %s '%s': must be a port number", envVars["port"], port)
+		}
+		cfg.Port = value
+	}
+	return cfg, nil
+}
+
+// FileSource describes values read from the named host entry of the config file
+func FileSource(hostName string) string {
+	return fmt.Sprintf("config file, host '%s'", hostName)
+}
+
+// Layer is a set of configuration values from one source
+type Layer struct {
+	Source string
+	Config Config
+}
+
+// Merge combines layers in increasing order of precedence, applies defaults and
+// records in Sources where each effective value came from
+func Merge(layers ...Layer) Config {
+	var result Config
+	for _, layer := range layers {
+		for _, field := range layer.Config.setFields() {
+			result.setSource(field, layer.Source)
+		}
+		result = result.MergeWith(layer.Config)
+	}
+
+	result.SetDefaults()
+	return result
+}
+
+// setFields returns the names of the fields that hold a value
+func (c *Config) setFields() []string {
+	var fields []string
+	if c.Host != "" {
+		fields = append(fields, "host")
+	}
+	if c.Username != "" {
+		fields = append(fields, "username")
+	}
+	if c.Port != 0 {
+		fields = append(fields, "port")
+	}
+	if c.KeyFile != "" {
+		fields = append(fields, "keyfile")
+	}
+	if c.Password != "" {
+		fields = append(fields, "password")
+	}
+	return fields
+}
+
+// setSource records where a field's value came from
+func (c *Config) setSource(field, source string) {
+	if c.Sources == nil {
+		c.Sources = make(map[string]string)
+	}
+	c.Sources[field] = source
+}
+
+// Label names the target for prompts: the host entry name and address, or just the address
+func (c *Config) Label() string {
+	if c.HostName != "" && c.HostName != c.Host {
+		return fmt.Sprintf("%s (%s)", c.HostName, c.Host)
+	}
+	return c.Host
+}
+
+// Describe lists the effective connection settings and where each came from,
+// so errors show why the tool is talking to a particular NAS
+func (c *Config) Describe() string {
+	password := ""
+	if c.Password != "" {
+		password = "********"
+	}
+	port := ""
+	if c.Port != 0 {
+		port = strconv.Itoa(c.Port)
+	}
+
+	lines := []string{"Effective configuration:"}
+	for _, field := range []struct{ name, value string }{
+		{"host", c.Host},
+		{"username", c.Username},
+		{"port", port},
+		{"keyfile", c.KeyFile},
+		{"password", password},
+	} {
+		source := c.Sources[field.name]
+		switch source {
+		case SourceFlag:
+			source = "flag --" + field.name
+		case SourceEnv:
+			source = "environment " + envVars[field.name]
+		case SourceKeychain:
+			source = "keychain entry " + c.PasswordKeychain
+		}
+		if field.value == "" {
+			if field.name == "keyfile" || field.name == "password" {
+				continue
+			}
+			field.value, source = "(not set)", "no flag, environment or config file value"
+		}
+		lines = append(lines, fmt.Sprintf("  %-8s = %s (%s)", field.name, field.value, source))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,172 @@
+// Package discover finds QNAP NAS units on the local network with mDNS and SSDP.
+package discover
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How a device was found
+const (
+	SourceMDNS = "mdns"
+	SourceSSDP = "ssdp"
+)
+
+// Device is a QNAP NAS that answered a discovery query
+type Device struct {
+	Name     string   `json:"name"`
+	Model    string   `json:"model,omitempty"`
+	Address  string   `json:"address"`
+	Firmware string   `json:"firmware,omitempty"`
+	Sources  []string `json:"sources"`
+}
+
+// Discover queries the local network with mDNS and SSDP and collects the QNAP devices
+// that answer within wait. Queries go out on the default multicast interface. An error
+// is returned only when every method failed.
+func Discover(ctx context.Context, wait time.Duration) ([]Device, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	methods := []func(context.Context) ([]Device, error){queryMDNS, querySSDP}
+	results := make([][]Device, len(methods))
+	errs := make([]error, len(methods))
+
+	var wg sync.WaitGroup
+	for i, method := range methods {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = method(ctx)
+		}()
+	}
+	wg.Wait()
+
+	var found []Device
+	failed := 0
+	for i := range methods {
+		if errs[i] != nil {
+			failed++
+			continue
+		}
+		found = append(found, results[i]...)
+	}
+	if failed == len(methods) {
+		return nil, errors.Join(errs...)
+	}
+	if err := ctx.Err(); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return nil, err
+	}
+	return merge(found), nil
+}
+
+// merge combines answers for the same address, keeping the first non-empty value of
+// each field, and sorts the devices by address
+func merge(found []Device) []Device {
+	byAddress := make(map[string]*Device)
+	var order []string
+	for _, d := range found {
+		existing, ok := byAddress[d.Address]
+		if !ok {
+			d.Sources = slices.Clone(d.Sources)
+			byAddress[d.Address] = &d
+			order = append(order, d.Address)
+			continue
+		}
+		if existing.Name == "" {
+			existing.Name = d.Name
+		}
+		if existing.Model == "" {
+			existing.Model = d.Model
+		}
+		if existing.Firmware == "" {
+			existing.Firmware = d.Firmware
+		}
+		for _, source := range d.Sources {
+			if !slices.Contains(existing.Sources, source) {
+				existing.Sources = append(existing.Sources, source)
+			}
+		}
+	}
+
+	devices := make([]Device, 0, len(order))
+	for _, address := range order {
+		devices = append(devices, *byAddress[address])
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return compareAddress(devices[i].Address, devices[j].Address) < 0
+	})
+	return devices
+}
+
+// compareAddress orders IP addresses numerically and anything else after them
+func compareAddress(a, b string) int {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	switch {
+	case ipA != nil && ipB != nil:
+		return slices.Compare(ipA.To16(), ipB.To16())
+	case ipA != nil:
+		return -1
+	case ipB != nil:
+		return 1
+	}
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// collect sends query to group from an ephemeral UDP port and passes every datagram
+// received until ctx is done to handle
+func collect(ctx context.Context, group string, query []byte, handle func(data []byte, from *net.UDPAddr)) error {
+	addr, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			// Nothing is written after the query; close errors do not affect the result
+		}
+	}()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	stop := context.AfterFunc(ctx, func() {
+		if err := conn.SetReadDeadline(time.Now()); err != nil {
+			// The socket is already closed; the read loop has ended
+		}
+	})
+	defer stop()
+
+	if _, err := conn.WriteToUDP(query, addr); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil
+			}
+			return err
+		}
+		handle(buf[:n], from)
+	}
+}
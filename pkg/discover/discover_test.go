@@ -0,0 +1,163 @@
+package discover
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// rr appends a resource record whose owner is a pointer to offset ptr, or name when
+// ptr is 0
+func rr(msg []byte, name string, ptr int, rtype uint16, data []byte) []byte {
+	if ptr != 0 {
+		msg = binary.BigEndian.AppendUint16(msg, 0xC000|uint16(ptr))
+	} else {
+		msg = appendName(msg, name)
+	}
+	msg = binary.BigEndian.AppendUint16(msg, rtype)
+	msg = binary.BigEndian.AppendUint16(msg, 0x8001) // cache flush, IN
+	msg = binary.BigEndian.AppendUint32(msg, 120)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(data)))
+	return append(msg, data...)
+}
+
+func txt(values ...string) []byte {
+	var data []byte
+	for _, v := range values {
+		data = append(data, byte(len(v)))
+		data = append(data, v...)
+	}
+	return data
+}
+
+// qdiscoverResponse is an answer from a TS-453B named Vault with its records
+// compressed against the question, as QTS sends them
+func qdiscoverResponse() []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[2:], 0x8400)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	binary.BigEndian.PutUint16(msg[6:], 1)
+	binary.BigEndian.PutUint16(msg[10:], 3)
+	msg = appendName(msg, qdiscoverService)
+	msg = binary.BigEndian.AppendUint16(msg, typePTR)
+	msg = binary.BigEndian.AppendUint16(msg, 1)
+
+	instance := append([]byte{5}, "Vault"...)
+	instance = binary.BigEndian.AppendUint16(instance, 0xC00C)
+	instanceOffset := len(msg) + 12
+	msg = rr(msg, "", 12, typePTR, instance)
+
+	msg = rr(msg, "", instanceOffset, typeTXT, txt("accessType=https", "accessPort=443",
+		"model=TS-X53B", "displayModel=TS-453B", "fwVer=5.1.8", "fwBuildNum=20240620"))
+
+	srv := []byte{0, 0, 0, 0, 0x01, 0xBB}
+	srv = appendName(srv, "Vault.local.")
+	msg = rr(msg, "", instanceOffset, typeSRV, srv)
+	return rr(msg, "Vault.local.", 0, typeA, []byte{192, 168, 1, 20})
+}
+
+func TestParseMDNS(t *testing.T) {
+	devices := parseMDNS(qdiscoverResponse(), net.IPv4(192, 168, 1, 99))
+	want := []Device{{Name: "Vault", Model: "TS-453B", Address: "192.168.1.20",
+		Firmware: "5.1.8 build 20240620", Sources: []string{SourceMDNS}}}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("parseMDNS = %+v, want %+v", devices, want)
+	}
+}
+
+func TestParseMDNSFallsBackToResponder(t *testing.T) {
+	msg := qdiscoverResponse()
+	// The A record comes last, so lowering ARCOUNT drops it
+	binary.BigEndian.PutUint16(msg[10:], 2)
+	devices := parseMDNS(msg, net.IPv4(192, 168, 1, 99))
+	if len(devices) != 1 || devices[0].Address != "192.168.1.99" {
+		t.Errorf("parseMDNS = %+v, want the responder address", devices)
+	}
+}
+
+func TestParseMDNSMalformed(t *testing.T) {
+	msg := qdiscoverResponse()
+	for _, n := range []int{0, 11, 20, len(msg) - 3} {
+		if devices := parseMDNS(msg[:n], nil); devices != nil {
+			t.Errorf("parseMDNS(truncated to %d) = %+v", n, devices)
+		}
+	}
+
+	// A pointer to itself must not loop forever
+	loop := append(make([]byte, 12), 0xC0, 12)
+	if _, _, err := readName(loop, 12); err == nil {
+		t.Error("readName accepted a pointer loop")
+	}
+}
+
+func TestMDNSQuery(t *testing.T) {
+	records, err := parseRecords(mdnsQuery(qdiscoverService))
+	if err != nil || len(records) != 0 {
+		t.Fatalf("parseRecords(query) = %v, %v", records, err)
+	}
+	name, _, err := readName(mdnsQuery(qdiscoverService), 12)
+	if err != nil || name != qdiscoverService {
+		t.Errorf("query name = %q, %v", name, err)
+	}
+}
+
+func TestParseSSDPResponse(t *testing.T) {
+	resp := "HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=1800\r\nEXT:\r\n" +
+		"LOCATION: http://192.168.1.20:49152/description.xml\r\n" +
+		"SERVER: Linux/5.10 UPnP/1.0 Portable SDK for UPnP devices/1.14\r\n" +
+		"ST: upnp:rootdevice\r\nUSN: uuid:1234::upnp:rootdevice\r\n\r\n"
+	if got := parseSSDPResponse([]byte(resp)); got != "http://192.168.1.20:49152/description.xml" {
+		t.Errorf("parseSSDPResponse = %q", got)
+	}
+
+	for _, bad := range []string{
+		"NOTIFY * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nLOCATION: file:///etc/passwd\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nST: upnp:rootdevice\r\n\r\n",
+	} {
+		if got := parseSSDPResponse([]byte(bad)); got != "" {
+			t.Errorf("parseSSDPResponse(%q) = %q, want none", bad, got)
+		}
+	}
+}
+
+func TestParseDescription(t *testing.T) {
+	qnap := `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:Basic:1</deviceType>
+    <friendlyName>Vault</friendlyName>
+    <manufacturer>QNAP Systems, Inc.</manufacturer>
+    <modelName>TS-453B</modelName>
+    <modelNumber>5.1.8</modelNumber>
+  </device>
+</root>`
+	d, ok := parseDescription(strings.NewReader(qnap))
+	want := Device{Name: "Vault", Model: "TS-453B 5.1.8", Sources: []string{SourceSSDP}}
+	if !ok || !reflect.DeepEqual(d, want) {
+		t.Errorf("parseDescription = %+v, %v, want %+v", d, ok, want)
+	}
+
+	router := strings.Replace(qnap, "QNAP Systems, Inc.", "Example Networks", 1)
+	if _, ok := parseDescription(strings.NewReader(router)); ok {
+		t.Error("parseDescription accepted a non-QNAP device")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	devices := merge([]Device{
+		{Name: "Vault", Model: "TS-453B", Address: "192.168.1.20", Firmware: "5.1.8", Sources: []string{SourceMDNS}},
+		{Name: "Backup", Address: "192.168.1.3", Sources: []string{SourceSSDP}},
+		{Name: "Vault (SSDP)", Model: "TS-453B 5.1.8", Address: "192.168.1.20", Sources: []string{SourceSSDP}},
+		{Name: "Vault", Address: "192.168.1.20", Sources: []string{SourceMDNS}},
+	})
+	want := []Device{
+		{Name: "Backup", Address: "192.168.1.3", Sources: []string{SourceSSDP}},
+		{Name: "Vault", Model: "TS-453B", Address: "192.168.1.20", Firmware: "5.1.8", Sources: []string{SourceMDNS, SourceSSDP}},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("merge = %+v, want %+v", devices, want)
+	}
+}
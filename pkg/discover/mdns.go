@@ -0,0 +1,220 @@
+package discover
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// mdnsGroup is the IPv4 mDNS multicast address
+const mdnsGroup = "224.0.0.251:5353"
+
+// qdiscoverService is the DNS-SD service QTS advertises for Qfinder and Qmanager. Its
+// TXT record carries the model and firmware version.
+const qdiscoverService = "_qdiscover._tcp.local."
+
+// DNS record types used by DNS-SD
+const (
+	typeA   = 1
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+)
+
+var errMalformed = errors.New("malformed DNS message")
+
+// queryMDNS asks for qdiscover instances. The query comes from an ephemeral port, so
+// responders answer by unicast (RFC 6762 section 6.7) and port 5353 need not be free.
+func queryMDNS(ctx context.Context) ([]Device, error) {
+	var found []Device
+	err := collect(ctx, mdnsGroup, mdnsQuery(qdiscoverService), func(data []byte, from *net.UDPAddr) {
+		found = append(found, parseMDNS(data, from.IP)...)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("mDNS query failed: %w", err)
+	}
+	return found, nil
+}
+
+// mdnsQuery builds a DNS query for the PTR records of service
+func mdnsQuery(service string) []byte {
+	msg := make([]byte, 12, 64)
+	binary.BigEndian.PutUint16(msg[4:], 1) // QDCOUNT
+	msg = appendName(msg, service)
+	msg = binary.BigEndian.AppendUint16(msg, typePTR)
+	return binary.BigEndian.AppendUint16(msg, 1) // class IN
+}
+
+// appendName appends name in DNS wire format
+func appendName(msg []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+// record is a resource record from a DNS response
+type record struct {
+	name  string
+	rtype uint16
+	data  []byte
+	off   int // Offset of data in the message, for names compressed against it
+}
+
+// parseMDNS returns the qdiscover instances in a response. The address comes from the
+// instance's A record, or from the responder when there is none.
+func parseMDNS(msg []byte, from net.IP) []Device {
+	records, err := parseRecords(msg)
+	if err != nil {
+		return nil
+	}
+
+	addresses := make(map[string]string)
+	for _, r := range records {
+		if r.rtype == typeA && len(r.data) == 4 {
+			addresses[strings.ToLower(r.name)] = net.IP(r.data).String()
+		}
+	}
+
+	var devices []Device
+	for _, r := range records {
+		if r.rtype != typePTR || !strings.EqualFold(r.name, qdiscoverService) {
+			continue
+		}
+		instance, _, err := readName(msg, r.off)
+		if err != nil {
+			continue
+		}
+
+		d := Device{
+			Name:    strings.TrimSuffix(instance, "."+qdiscoverService),
+			Sources: []string{SourceMDNS},
+		}
+		if from != nil {
+			d.Address = from.String()
+		}
+		for _, rr := range records {
+			if !strings.EqualFold(rr.name, instance) {
+				continue
+			}
+			switch rr.rtype {
+			case typeTXT:
+				txt := parseTXT(rr.data)
+				d.Model = txt["displaymodel"]
+				if d.Model == "" {
+					d.Model = txt["model"]
+				}
+				d.Firmware = txt["fwver"]
+				if build := txt["fwbuildnum"]; d.Firmware != "" && build != "" {
+					d.Firmware += " build " + build
+				}
+			case typeSRV:
+				// Priority, weight and port come before the target
+				target, _, err := readName(msg, rr.off+6)
+				if err == nil && addresses[strings.ToLower(target)] != "" {
+					d.Address = addresses[strings.ToLower(target)]
+				}
+			}
+		}
+		if d.Address != "" {
+			devices = append(devices, d)
+		}
+	}
+	return devices
+}
+
+// parseTXT returns the key=value strings of a TXT record, keyed by lowercase key
+func parseTXT(data []byte) map[string]string {
+	values := make(map[string]string)
+	for len(data) > 0 {
+		n := int(data[0])
+		if n+1 > len(data) {
+			break
+		}
+		key, value, _ := strings.Cut(string(data[1:n+1]), "=")
+		values[strings.ToLower(key)] = value
+		data = data[n+1:]
+	}
+	return values
+}
+
+// parseRecords returns the answer, authority and additional records of a DNS message
+func parseRecords(msg []byte) ([]record, error) {
+	if len(msg) < 12 {
+		return nil, errMalformed
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	count := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for range questions {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next + 4 // type and class
+	}
+
+	records := make([]record, 0, count)
+	for range count {
+		name, next, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(msg) {
+			return nil, errMalformed
+		}
+		rtype := binary.BigEndian.Uint16(msg[next:])
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		start := next + 10
+		if start+length > len(msg) {
+			return nil, errMalformed
+		}
+		records = append(records, record{name: name, rtype: rtype, data: msg[start : start+length], off: start})
+		off = start + length
+	}
+	return records, nil
+}
+
+// readName reads a possibly compressed name at off, returning it with a trailing dot
+// and the offset just past it
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errMalformed
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errMalformed
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errMalformed
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		case n&0xC0 != 0:
+			return "", 0, errMalformed
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
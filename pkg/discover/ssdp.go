@@ -0,0 +1,159 @@
+package discover
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ssdpGroup is the IPv4 SSDP multicast address
+const ssdpGroup = "239.255.255.250:1900"
+
+// descriptionTimeout bounds fetching device descriptions after the search window
+const descriptionTimeout = 3 * time.Second
+
+// maxDescriptionSize bounds UPnP device descriptions, which are a few kilobytes
+const maxDescriptionSize = 256 << 10
+
+// ssdpSearch asks every UPnP root device to answer within two seconds
+var ssdpSearch = []byte("M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n" +
+	"ST: upnp:rootdevice\r\n\r\n")
+
+// querySSDP searches for UPnP root devices and keeps those whose device description
+// names QNAP as the manufacturer. Routers, TVs and printers answer the same search.
+func querySSDP(ctx context.Context) ([]Device, error) {
+	locations := make(map[string]bool)
+	err := collect(ctx, ssdpGroup, ssdpSearch, func(data []byte, _ *net.UDPAddr) {
+		if location := parseSSDPResponse(data); location != "" {
+			locations[location] = true
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("SSDP search failed: %w", err)
+	}
+
+	// Descriptions are fetched after the search window, so give them a moment of their own
+	fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), descriptionTimeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			cancel()
+		}
+	})
+	defer stop()
+
+	var (
+		mu    sync.Mutex
+		found []Device
+		wg    sync.WaitGroup
+	)
+	for location := range locations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, ok := fetchDescription(fetchCtx, location)
+			if !ok {
+				return
+			}
+			mu.Lock()
+			found = append(found, d)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return found, nil
+}
+
+// parseSSDPResponse returns the LOCATION of a search response, or "" if it is not one
+func parseSSDPResponse(data []byte) string {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+	if err != nil {
+		return ""
+	}
+	if err := resp.Body.Close(); err != nil {
+		// The body of a search response is empty
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	location := resp.Header.Get("Location")
+	if u, err := url.Parse(location); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return location
+}
+
+// fetchDescription downloads the device description at location and reports whether
+// it describes a QNAP device
+func fetchDescription(ctx context.Context, location string) (Device, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return Device{}, false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Device{}, false
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			// Body was read as far as needed; close errors do not affect the result
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return Device{}, false
+	}
+
+	d, ok := parseDescription(io.LimitReader(resp.Body, maxDescriptionSize))
+	if !ok {
+		return Device{}, false
+	}
+	if u, err := url.Parse(location); err == nil {
+		d.Address = u.Hostname()
+	}
+	return d, d.Address != ""
+}
+
+// upnpDescription is the part of a UPnP device description used here
+type upnpDescription struct {
+	Device struct {
+		FriendlyName string `xml:"friendlyName"`
+		Manufacturer string `xml:"manufacturer"`
+		ModelName    string `xml:"modelName"`
+		ModelNumber  string `xml:"modelNumber"`
+	} `xml:"device"`
+}
+
+// parseDescription reads a UPnP device description and reports whether its
+// manufacturer is QNAP
+func parseDescription(r io.Reader) (Device, bool) {
+	var desc upnpDescription
+	if err := xml.NewDecoder(r).Decode(&desc); err != nil {
+		return Device{}, false
+	}
+	if !strings.Contains(strings.ToUpper(desc.Device.Manufacturer), "QNAP") {
+		return Device{}, false
+	}
+
+	model := strings.TrimSpace(desc.Device.ModelName)
+	if number := strings.TrimSpace(desc.Device.ModelNumber); number != "" && !strings.Contains(model, number) {
+		model = strings.TrimSpace(model + " " + number)
+	}
+	return Device{
+		Name:    strings.TrimSpace(desc.Device.FriendlyName),
+		Model:   model,
+		Sources: []string{SourceSSDP},
+	}, true
+}
@@ -0,0 +1,177 @@
+// Package doctor turns what qnap-vm finds on a NAS into pass/fail checks with the fix
+// for each failure, for first-run problems that otherwise surface as opaque errors.
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/nas"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// Check statuses
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn" // VMs work, with limits
+	StatusFail = "fail" // VMs cannot be managed until fixed
+	StatusSkip = "skip" // Not checked because an earlier check failed
+)
+
+// Check is the result of one diagnostic
+type Check struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+// Failed returns the number of failed checks
+func Failed(checks []Check) int {
+	failed := 0
+	for _, check := range checks {
+		if check.Status == StatusFail {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Skipped returns a skipped check, for checks that depend on one that failed
+func Skipped(name, reason string) Check {
+	return Check{Name: name, Status: StatusSkip, Detail: reason}
+}
+
+// SSH checks the result of connecting to address as user
+func SSH(address, user string, err error) Check {
+	check := Check{Name: "SSH connection", Status: StatusOK, Detail: fmt.Sprintf("%s@%s", user, address)}
+	if err == nil {
+		return check
+	}
+
+	check.Status = StatusFail
+	check.Detail = err.Error()
+	switch {
+	case ssh.IsUnreachable(err):
+		check.Detail = fmt.Sprintf("nothing answers on %s", address)
+		check.Fix = "Enable SSH in QTS under Control Panel > Network & File Services > Telnet/SSH, " +
+			"and check the host and port ('qnap-vm config set --host HOST --port PORT')"
+	case errors.Is(err, ssh.ErrHostKeyRejected):
+		check.Detail = "the NAS's host key is not trusted"
+		check.Fix = "Compare the fingerprint with the one QTS shows and accept it, or remove the stale " +
+			"entry from ~/.ssh/known_hosts if the NAS was reinstalled"
+	case strings.Contains(err.Error(), "no authentication methods"):
+		check.Detail = "no SSH key, agent or password to log in with"
+		check.Fix = "Set a key with 'qnap-vm config set --keyfile PATH' or a password with '--password', " +
+			"or load a key into ssh-agent"
+	case strings.Contains(err.Error(), "unable to authenticate"):
+		check.Detail = fmt.Sprintf("%s was refused", user)
+		check.Fix = "QTS only allows administrators to log in over SSH. Add your public key to " +
+			"~/.ssh/authorized_keys of that account on the NAS, or set the key or password with " +
+			"'qnap-vm config set --keyfile PATH' or '--password'"
+	}
+	return check
+}
+
+// Host checks the CPU, kernel and Virtualization Station support reported by HostInfo
+func Host(info *nas.HostInfo) []Check {
+	var checks []Check
+
+	qvs := Check{Name: "Virtualization Station", Status: StatusOK}
+	switch {
+	case info.QVSVersion == "" && info.QVSPath == "":
+		qvs.Status, qvs.Detail = StatusFail, "not installed"
+		qvs.Fix = "Install Virtualization Station from App Center"
+	case !info.QVSEnabled && info.QVSVersion != "":
+		qvs.Status, qvs.Detail = StatusFail, fmt.Sprintf("%s is installed but disabled", info.QVSVersion)
+		qvs.Fix = "Enable Virtualization Station in App Center"
+	case info.QVSPath == "":
+		qvs.Status, qvs.Detail = StatusFail, fmt.Sprintf("%s is installed but neither /QVS nor /KVM exists", info.QVSVersion)
+		qvs.Fix = "Open Virtualization Station once so it finishes setting up, or reinstall it from App Center"
+	default:
+		qvs.Detail = fmt.Sprintf("%s in %s", info.QVSVersion, info.QVSPath)
+	}
+	checks = append(checks, qvs)
+
+	cpu := Check{Name: "CPU virtualization", Status: StatusOK}
+	switch info.CPUVirt {
+	case "vmx":
+		cpu.Detail = "Intel VT-x"
+	case "svm":
+		cpu.Detail = "AMD-V"
+	default:
+		cpu.Status, cpu.Detail = StatusFail, "the CPU reports neither VT-x nor AMD-V"
+		cpu.Fix = "Enable virtualization (VT-x/AMD-V) in the NAS's BIOS; models without it cannot run Virtualization Station"
+	}
+	checks = append(checks, cpu)
+
+	kvm := Check{Name: "/dev/kvm", Status: StatusOK, Detail: info.KVMModule}
+	switch {
+	case info.KVMAvailable():
+	case info.CPUVirt == "":
+		kvm = Skipped(kvm.Name, "no CPU virtualization")
+	case info.KVMModule == "":
+		kvm.Status, kvm.Detail = StatusFail, "the kvm_intel/kvm_amd module is not loaded"
+		kvm.Fix = "Start Virtualization Station, which loads the module, or run 'modprobe kvm_intel' (or kvm_amd) on the NAS"
+	default:
+		kvm.Status, kvm.Detail = StatusFail, fmt.Sprintf("%s is loaded but /dev/kvm is missing", info.KVMModule)
+		kvm.Fix = "Restart Virtualization Station in App Center"
+	}
+	checks = append(checks, kvm)
+
+	return checks
+}
+
+// Virsh checks that virsh could be set up and reached libvirt
+func Virsh(libvirtVersion string, err error) Check {
+	if err != nil {
+		return Check{Name: "virsh", Status: StatusFail, Detail: err.Error(),
+			Fix: "Restart Virtualization Station in App Center; if virsh still fails, check its log under Virtualization Station > Logs"}
+	}
+	detail := "libvirt responds"
+	if libvirtVersion != "" {
+		detail = "libvirt " + libvirtVersion
+	}
+	return Check{Name: "virsh", Status: StatusOK, Detail: detail}
+}
+
+// QemuImg checks that qemu-img runs
+func QemuImg(version string, err error) Check {
+	if err != nil {
+		return Check{Name: "qemu-img", Status: StatusFail, Detail: err.Error(),
+			Fix: "qemu-img ships with Virtualization Station; reinstall or update it from App Center"}
+	}
+	return Check{Name: "qemu-img", Status: StatusOK, Detail: version}
+}
+
+// Pool checks that a storage pool can hold VM disks; err is the result of writing to it
+func Pool(name, path string, err error) Check {
+	check := Check{Name: "Pool " + name, Status: StatusOK, Detail: path + " is writable"}
+	if err != nil {
+		check.Status, check.Detail = StatusFail, err.Error()
+		check.Fix = "Check the volume is not read-only in Storage & Snapshots and that the SSH user may write to " + path
+	}
+	return check
+}
+
+// NoPools is the check for a NAS without storage pools
+func NoPools() Check {
+	return Check{Name: "Storage pools", Status: StatusFail, Detail: "none found",
+		Fix: "Create a storage pool and volume in Storage & Snapshots"}
+}
+
+// Bridges checks that VMs can be put on the LAN through a bridge with a physical uplink
+func Bridges(bridges []nas.Bridge) Check {
+	var usable []string
+	for _, bridge := range bridges {
+		if len(bridge.Uplinks) > 0 {
+			usable = append(usable, bridge.Name)
+		}
+	}
+	if len(usable) == 0 {
+		return Check{Name: "Bridges", Status: StatusWarn, Detail: "no bridge with a physical uplink",
+			Fix: "VMs can only use user-mode networking; create a virtual switch in Network & Virtual Switch to put them on the LAN"}
+	}
+	return Check{Name: "Bridges", Status: StatusOK, Detail: strings.Join(usable, ", ")}
+}
@@ -0,0 +1,65 @@
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/nas"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+func TestSSH(t *testing.T) {
+	if check := SSH("qnap.local:22", "admin", nil); check.Status != StatusOK || check.Detail != "admin@qnap.local:22" {
+		t.Errorf("SSH(nil) = %+v", check)
+	}
+
+	for _, tt := range []struct {
+		name string
+		err  error
+		fix  string
+	}{
+		{"unreachable", fmt.Errorf("connect: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), "Telnet/SSH"},
+		{"host key", fmt.Errorf("handshake: %w", ssh.ErrHostKeyRejected), "known_hosts"},
+		{"auth", errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey]"), "authorized_keys"},
+		{"no credentials", errors.New("failed to create SSH client: failed to get authentication methods: no authentication methods available"), "--keyfile"},
+		{"other", errors.New("EOF"), ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			check := SSH("qnap.local:22", "admin", tt.err)
+			if check.Status != StatusFail || !strings.Contains(check.Fix, tt.fix) {
+				t.Errorf("SSH = %+v", check)
+			}
+		})
+	}
+}
+
+func TestHost(t *testing.T) {
+	ready := &nas.HostInfo{QVSVersion: "4.1.0", QVSEnabled: true, QVSPath: "/QVS", CPUVirt: "vmx", DevKVM: true, KVMModule: "kvm_intel"}
+	checks := Host(ready)
+	if len(checks) != 3 || Failed(checks) != 0 || checks[0].Detail != "4.1.0 in /QVS" || checks[1].Detail != "Intel VT-x" {
+		t.Errorf("Host(ready) = %+v", checks)
+	}
+
+	checks = Host(&nas.HostInfo{})
+	if Failed(checks) != 2 || !strings.Contains(checks[0].Fix, "Install") || checks[2].Status != StatusSkip {
+		t.Errorf("Host(bare) = %+v", checks)
+	}
+
+	checks = Host(&nas.HostInfo{QVSVersion: "4.1.0", QVSPath: "/QVS", CPUVirt: "svm", KVMModule: "kvm_amd"})
+	if Failed(checks) != 2 || !strings.Contains(checks[0].Fix, "Enable") || !strings.Contains(checks[2].Detail, "/dev/kvm is missing") {
+		t.Errorf("Host(disabled) = %+v", checks)
+	}
+}
+
+func TestBridges(t *testing.T) {
+	if check := Bridges([]nas.Bridge{{Name: "virbr0"}}); check.Status != StatusWarn {
+		t.Errorf("Bridges without uplinks = %+v", check)
+	}
+	check := Bridges([]nas.Bridge{{Name: "qvs0", Uplinks: []string{"eth0"}}, {Name: "virbr0"}, {Name: "qvs1", Uplinks: []string{"eth1"}}})
+	if check.Status != StatusOK || check.Detail != "qvs0, qvs1" {
+		t.Errorf("Bridges = %+v", check)
+	}
+}
@@ -0,0 +1,128 @@
+package inventory
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/storage"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+)
+
+// Drift kinds reported by Audit
+const (
+	DriftMissingVM          = "missing-vm"          // Recorded, but no longer on the NAS
+	DriftUnmanagedVM        = "unmanaged-vm"        // On the NAS, but never recorded
+	DriftChangedVM          = "changed-vm"          // Memory, vCPUs or tags differ from the record
+	DriftMissingSnapshot    = "missing-snapshot"    // Recorded, but no longer on the NAS
+	DriftUnrecordedSnapshot = "unrecorded-snapshot" // On the NAS, but never recorded
+	DriftOrphanedDisk       = "orphaned-disk"       // Disk image no VM uses
+	DriftScheduleVM         = "schedule-missing-vm" // Backup schedule for a VM that does not exist
+)
+
+// Drift is one difference between what qnap-vm recorded and what is on the NAS
+type Drift struct {
+	Kind     string `json:"kind"`
+	Object   string `json:"object"` // VM, VM/snapshot or disk path
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// Actual is the state of the NAS an inventory is audited against
+type Actual struct {
+	VMs []virsh.VMInfo
+	// Snapshots holds the snapshot names of the VMs whose snapshots are recorded
+	Snapshots map[string][]string
+	Orphans   []storage.DiskImage
+	// ScheduledVMs are the VMs with backup schedules in the config
+	ScheduledVMs []string
+}
+
+// Audit compares the recorded inventory with the NAS and returns the drift, ordered by
+// kind and object. It fails when no VM list has been recorded yet.
+func (inv *Inventory) Audit(actual Actual) ([]Drift, error) {
+	if inv.VMs == nil {
+		return nil, fmt.Errorf("nothing recorded for %s yet; run 'qnap-vm audit --accept' or 'qnap-vm list' first", inv.Host)
+	}
+
+	var drift []Drift
+	onNAS := make(map[string]virsh.VMInfo, len(actual.VMs))
+	for _, vm := range actual.VMs {
+		onNAS[vm.Name] = vm
+	}
+	recorded := make(map[string]bool, len(inv.VMs.VMs))
+
+	for _, vm := range inv.VMs.VMs {
+		recorded[vm.Name] = true
+		current, ok := onNAS[vm.Name]
+		if !ok {
+			drift = append(drift, Drift{Kind: DriftMissingVM, Object: vm.Name, Expected: "defined", Actual: "not found"})
+			continue
+		}
+		if vm.Memory != current.Memory {
+			drift = append(drift, Drift{Kind: DriftChangedVM, Object: vm.Name, Expected: fmt.Sprintf("memory %d MB", vm.Memory), Actual: fmt.Sprintf("memory %d MB", current.Memory)})
+		}
+		if vm.CPUs != current.CPUs {
+			drift = append(drift, Drift{Kind: DriftChangedVM, Object: vm.Name, Expected: fmt.Sprintf("%d vCPUs", vm.CPUs), Actual: fmt.Sprintf("%d vCPUs", current.CPUs)})
+		}
+		if !slices.Equal(vm.Tags, current.Tags) {
+			drift = append(drift, Drift{Kind: DriftChangedVM, Object: vm.Name, Expected: "tags " + tagList(vm.Tags), Actual: "tags " + tagList(current.Tags)})
+		}
+	}
+	for _, vm := range actual.VMs {
+		if !recorded[vm.Name] {
+			drift = append(drift, Drift{Kind: DriftUnmanagedVM, Object: vm.Name, Expected: "not recorded", Actual: vm.State})
+		}
+	}
+
+	for vmName, list := range inv.Snapshots {
+		current, ok := actual.Snapshots[vmName]
+		if !ok {
+			continue
+		}
+		var expected []string
+		for _, snapshot := range list.Snapshots {
+			expected = append(expected, snapshot.Name)
+		}
+		for _, snapshot := range list.Dataset {
+			expected = append(expected, snapshot.Name)
+		}
+		for _, name := range expected {
+			if !slices.Contains(current, name) {
+				drift = append(drift, Drift{Kind: DriftMissingSnapshot, Object: vmName + "/" + name, Expected: "present", Actual: "not found"})
+			}
+		}
+		for _, name := range current {
+			if !slices.Contains(expected, name) {
+				drift = append(drift, Drift{Kind: DriftUnrecordedSnapshot, Object: vmName + "/" + name, Expected: "not recorded", Actual: "present"})
+			}
+		}
+	}
+
+	for _, disk := range actual.Orphans {
+		drift = append(drift, Drift{Kind: DriftOrphanedDisk, Object: disk.Path, Expected: "used by a VM", Actual: "unused"})
+	}
+
+	for _, vmName := range actual.ScheduledVMs {
+		if _, ok := onNAS[vmName]; !ok {
+			drift = append(drift, Drift{Kind: DriftScheduleVM, Object: vmName, Expected: "defined", Actual: "not found"})
+		}
+	}
+
+	sort.SliceStable(drift, func(i, j int) bool {
+		if drift[i].Kind != drift[j].Kind {
+			return drift[i].Kind < drift[j].Kind
+		}
+		return drift[i].Object < drift[j].Object
+	})
+	return drift, nil
+}
+
+// tagList formats tags for a drift report
+func tagList(tags []string) string {
+	if len(tags) == 0 {
+		return "(none)"
+	}
+	return strings.Join(tags, ",")
+}
@@ -0,0 +1,55 @@
+package inventory
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/storage"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+)
+
+func TestAudit(t *testing.T) {
+	updated := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	inv := &Inventory{Host: "nas.local"}
+	inv.SetVMs([]virsh.VMInfo{
+		{Name: "web", Memory: 2048, CPUs: 2, Tags: []string{"prod"}},
+		{Name: "db", Memory: 4096, CPUs: 4},
+		{Name: "old", Memory: 1024, CPUs: 1},
+	}, updated)
+	inv.SetSnapshots("db", SnapshotList{Updated: updated, Snapshots: []virsh.SnapshotInfo{{Name: "before-upgrade"}, {Name: "nightly"}}})
+
+	actual := Actual{
+		VMs: []virsh.VMInfo{
+			{Name: "web", Memory: 2048, CPUs: 2, Tags: []string{"prod"}},
+			{Name: "db", Memory: 8192, CPUs: 4, Tags: []string{"prod"}},
+			{Name: "lab", State: "running"},
+		},
+		Snapshots:    map[string][]string{"db": {"nightly", "manual"}},
+		Orphans:      []storage.DiskImage{{Path: "/share/CACHEDEV1_DATA/.qnap-vm/disks/gone.qcow2"}},
+		ScheduledVMs: []string{"web", "old"},
+	}
+
+	drift, err := inv.Audit(actual)
+	if err != nil {
+		t.Fatalf("Audit() error: %v", err)
+	}
+
+	want := []Drift{
+		{Kind: DriftChangedVM, Object: "db", Expected: "memory 4096 MB", Actual: "memory 8192 MB"},
+		{Kind: DriftChangedVM, Object: "db", Expected: "tags (none)", Actual: "tags prod"},
+		{Kind: DriftMissingSnapshot, Object: "db/before-upgrade", Expected: "present", Actual: "not found"},
+		{Kind: DriftMissingVM, Object: "old", Expected: "defined", Actual: "not found"},
+		{Kind: DriftOrphanedDisk, Object: "/share/CACHEDEV1_DATA/.qnap-vm/disks/gone.qcow2", Expected: "used by a VM", Actual: "unused"},
+		{Kind: DriftScheduleVM, Object: "old", Expected: "defined", Actual: "not found"},
+		{Kind: DriftUnmanagedVM, Object: "lab", Expected: "not recorded", Actual: "running"},
+		{Kind: DriftUnrecordedSnapshot, Object: "db/manual", Expected: "not recorded", Actual: "present"},
+	}
+	if !reflect.DeepEqual(drift, want) {
+		t.Errorf("Audit() =\n%+v\nwant\n%+v", drift, want)
+	}
+
+	if _, err := (&Inventory{Host: "new.local"}).Audit(actual); err == nil {
+		t.Error("Audit() without a recorded VM list succeeded")
+	}
+}
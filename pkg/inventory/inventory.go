@@ -0,0 +1,190 @@
+// Package inventory caches what read-only commands last saw on each host, so they can
+// still answer, clearly marked as stale, when the NAS is unreachable.
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/storage"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+)
+
+// Inventory is the cached state of one host. Each part records when it was last refreshed.
+type Inventory struct {
+	Host      string                   `json:"host"`
+	VMs       *VMList                  `json:"vms,omitempty"`
+	Details   map[string]*VMDetails    `json:"details,omitempty"`
+	Snapshots map[string]*SnapshotList `json:"snapshots,omitempty"`
+	Names     map[string]*NameList     `json:"names,omitempty"`
+
+	path string
+}
+
+// VMList is the output of 'list'
+type VMList struct {
+	Updated time.Time      `json:"updated"`
+	VMs     []virsh.VMInfo `json:"vms"`
+}
+
+// VMDetails is the output of 'status' for one VM
+type VMDetails struct {
+	Updated time.Time    `json:"updated"`
+	VM      virsh.VMInfo `json:"vm"`
+}
+
+// SnapshotList is the output of 'snapshot list' for one VM
+type SnapshotList struct {
+	Updated   time.Time                 `json:"updated"`
+	Snapshots []virsh.SnapshotInfo      `json:"snapshots"`
+	Current   string                    `json:"current,omitempty"`
+	Dataset   []storage.DatasetSnapshot `json:"dataset_snapshots,omitempty"`
+}
+
+// NameList is a list of names offered by shell completion, such as pools or one VM's
+// snapshots
+type NameList struct {
+	Updated time.Time `json:"updated"`
+	Names   []string  `json:"names"`
+}
+
+// unsafeFileChars are replaced in host names used as cache file names
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Path returns the cache file for host under dir
+func Path(dir, host string) string {
+	return filepath.Join(dir, "inventory-"+unsafeFileChars.ReplaceAllString(host, "_")+".json")
+}
+
+// Load reads host's cached inventory from dir. A missing cache is returned empty.
+func Load(dir, host string) (*Inventory, error) {
+	inv := &Inventory{Host: host, path: Path(dir, host)}
+
+	data, err := os.ReadFile(inv.path)
+	if os.IsNotExist(err) {
+		return inv, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory cache: %w", err)
+	}
+	if err := json.Unmarshal(data, inv); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory cache %s: %w", inv.path, err)
+	}
+	return inv, nil
+}
+
+// Save writes the inventory back to its cache file, replacing it atomically
+func (inv *Inventory) Save() error {
+	data, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode inventory cache: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(inv.path), 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	tmpPath := inv.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write inventory cache: %w", err)
+	}
+	if err := os.Rename(tmpPath, inv.path); err != nil {
+		return fmt.Errorf("failed to write inventory cache: %w", err)
+	}
+	return nil
+}
+
+// SetVMs records the VM list
+func (inv *Inventory) SetVMs(vms []virsh.VMInfo, now time.Time) {
+	inv.VMs = &VMList{Updated: now, VMs: vms}
+
+	// Forget VMs that no longer exist
+	exists := make(map[string]bool, len(vms))
+	for _, vm := range vms {
+		exists[vm.Name] = true
+	}
+	for name := range inv.Details {
+		if !exists[name] {
+			delete(inv.Details, name)
+		}
+	}
+	for name := range inv.Snapshots {
+		if !exists[name] {
+			delete(inv.Snapshots, name)
+		}
+	}
+	for kind := range inv.Names {
+		if name, ok := strings.CutPrefix(kind, "snapshots/"); ok && !exists[name] {
+			delete(inv.Names, kind)
+		}
+	}
+}
+
+// SetDetails records one VM's status
+func (inv *Inventory) SetDetails(vm virsh.VMInfo, now time.Time) {
+	if inv.Details == nil {
+		inv.Details = make(map[string]*VMDetails)
+	}
+	inv.Details[vm.Name] = &VMDetails{Updated: now, VM: vm}
+}
+
+// SetSnapshots records one VM's snapshots
+func (inv *Inventory) SetSnapshots(vmName string, list SnapshotList) {
+	if inv.Snapshots == nil {
+		inv.Snapshots = make(map[string]*SnapshotList)
+	}
+	inv.Snapshots[vmName] = &list
+}
+
+// SetNames records the completion names of kind, e.g. "vms" or "snapshots/web"
+func (inv *Inventory) SetNames(kind string, names []string, now time.Time) {
+	if inv.Names == nil {
+		inv.Names = make(map[string]*NameList)
+	}
+	inv.Names[kind] = &NameList{Updated: now, Names: names}
+}
+
+// CachedNames returns the completion names of kind if they were recorded within maxAge
+// of now. A zero maxAge accepts names of any age.
+func (inv *Inventory) CachedNames(kind string, maxAge time.Duration, now time.Time) ([]string, bool) {
+	list := inv.Names[kind]
+	if list == nil || (maxAge > 0 && now.Sub(list.Updated) > maxAge) {
+		return nil, false
+	}
+	return list.Names, true
+}
+
+// VMDetails returns a VM's cached status from its last 'status', or else from the VM
+// list. It returns nil if the VM has not been seen.
+func (inv *Inventory) VMDetails(vmName string) *VMDetails {
+	if details := inv.Details[vmName]; details != nil {
+		return details
+	}
+	if inv.VMs != nil {
+		for _, vm := range inv.VMs.VMs {
+			if vm.Name == vmName {
+				return &VMDetails{Updated: inv.VMs.Updated, VM: vm}
+			}
+		}
+	}
+	return nil
+}
+
+// Age describes how long ago t was, e.g. "3h12m ago"
+func Age(t, now time.Time) string {
+	age := now.Sub(t)
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%dm ago", int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%dh%02dm ago", int(age.Hours()), int(age.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dd ago", int(age.Hours()/24))
+	}
+}
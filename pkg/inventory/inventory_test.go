@@ -0,0 +1,113 @@
+package inventory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+)
+
+func TestLoadSave(t *testing.T) {
+	dir := t.TempDir()
+
+	inv, err := Load(dir, "192.168.1.100")
+	if err != nil {
+		t.Fatalf("Load() on a missing cache error: %v", err)
+	}
+	if inv.VMs != nil || len(inv.Details) != 0 {
+		t.Errorf("Load() on a missing cache = %+v, want empty", inv)
+	}
+
+	updated := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	inv.SetVMs([]virsh.VMInfo{{Name: "web", State: "running"}, {Name: "db", State: "shut off"}}, updated)
+	inv.SetDetails(virsh.VMInfo{Name: "db", State: "shut off", UUID: "1234"}, updated)
+	inv.SetSnapshots("db", SnapshotList{Updated: updated, Current: "before-upgrade", Snapshots: []virsh.SnapshotInfo{{Name: "before-upgrade"}}})
+	if err := inv.Save(); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	loaded, err := Load(dir, "192.168.1.100")
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if loaded.VMs == nil || len(loaded.VMs.VMs) != 2 || !loaded.VMs.Updated.Equal(updated) {
+		t.Errorf("loaded VMs = %+v", loaded.VMs)
+	}
+	if got := loaded.Snapshots["db"]; got == nil || got.Current != "before-upgrade" {
+		t.Errorf("loaded snapshots = %+v", got)
+	}
+
+	if other, err := Load(dir, "nas2.local"); err != nil || other.VMs != nil {
+		t.Errorf("Load() for another host = %+v, %v", other, err)
+	}
+}
+
+func TestVMDetails(t *testing.T) {
+	inv := &Inventory{}
+	listed := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	inv.SetVMs([]virsh.VMInfo{{Name: "web", State: "running"}, {Name: "db", State: "shut off"}}, listed)
+	inv.SetDetails(virsh.VMInfo{Name: "db", State: "shut off", UUID: "1234"}, listed.Add(time.Hour))
+
+	if got := inv.VMDetails("db"); got == nil || got.VM.UUID != "1234" {
+		t.Errorf("VMDetails(db) = %+v, want the status details", got)
+	}
+	if got := inv.VMDetails("web"); got == nil || got.VM.State != "running" || !got.Updated.Equal(listed) {
+		t.Errorf("VMDetails(web) = %+v, want the list entry", got)
+	}
+	if got := inv.VMDetails("missing"); got != nil {
+		t.Errorf("VMDetails(missing) = %+v, want nil", got)
+	}
+
+	// A newer list without db forgets its details
+	inv.SetVMs([]virsh.VMInfo{{Name: "web", State: "running"}}, listed.Add(2*time.Hour))
+	if got := inv.VMDetails("db"); got != nil {
+		t.Errorf("VMDetails(db) after removal = %+v, want nil", got)
+	}
+}
+
+func TestCachedNames(t *testing.T) {
+	inv := &Inventory{}
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	inv.SetNames("pools", []string{"CACHEDEV1_DATA"}, now)
+	inv.SetNames("snapshots/db", []string{"before-upgrade"}, now)
+
+	if names, ok := inv.CachedNames("pools", time.Minute, now.Add(30*time.Second)); !ok || len(names) != 1 {
+		t.Errorf("CachedNames(pools) = %v, %v; want the fresh names", names, ok)
+	}
+	if _, ok := inv.CachedNames("pools", time.Minute, now.Add(2*time.Minute)); ok {
+		t.Error("CachedNames() should not return expired names")
+	}
+	if _, ok := inv.CachedNames("pools", 0, now.Add(24*time.Hour)); !ok {
+		t.Error("CachedNames() with no maximum age should return old names")
+	}
+	if _, ok := inv.CachedNames("vms", 0, now); ok {
+		t.Error("CachedNames() should report names never recorded")
+	}
+
+	// Listing VMs without db forgets its snapshot names
+	inv.SetVMs([]virsh.VMInfo{{Name: "web"}}, now)
+	if _, ok := inv.CachedNames("snapshots/db", 0, now); ok {
+		t.Error("snapshot names of a removed VM should be forgotten")
+	}
+	if _, ok := inv.CachedNames("pools", 0, now); !ok {
+		t.Error("SetVMs() should keep other names")
+	}
+}
+
+func TestAge(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		ago  time.Duration
+		want string
+	}{
+		{10 * time.Second, "just now"},
+		{42 * time.Minute, "42m ago"},
+		{3*time.Hour + 12*time.Minute, "3h12m ago"},
+		{5 * 24 * time.Hour, "5d ago"},
+	}
+	for _, tt := range tests {
+		if got := Age(now.Add(-tt.ago), now); got != tt.want {
+			t.Errorf("Age(%v) = %q, want %q", tt.ago, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+//go:build !windows
+
+package keychain
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// run executes a credential store tool with stdin and returns its standard output.
+// Tests replace it.
+var run = func(stdin, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", fmt.Errorf("%w: %s is not installed", ErrUnavailable, name)
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return stdout.String(), &toolError{code: exitErr.ExitCode(), message: strings.TrimSpace(stderr.String())}
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// toolError is a credential store tool exiting with an error
+type toolError struct {
+	code    int
+	message string
+}
+
+func (e *toolError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("exit status %d", e.code)
+	}
+	return fmt.Sprintf("exit status %d: %s", e.code, e.message)
+}
+
+// exitCode returns the exit status of a failed tool, or -1 for other errors
+func exitCode(err error) int {
+	var toolErr *toolError
+	if errors.As(err, &toolErr) {
+		return toolErr.code
+	}
+	return -1
+}
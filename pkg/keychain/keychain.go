@@ -0,0 +1,50 @@
+// Package keychain keeps secrets in the operating system's credential store: the macOS
+// Keychain, the Secret Service (GNOME Keyring, KWallet) on Linux and other Unix systems,
+// or the Windows Credential Manager. Configuration files then only hold a reference.
+package keychain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Service groups qnap-vm's entries in the credential store
+const Service = "qnap-vm"
+
+// ErrNotFound is returned when the credential store holds no secret for an account
+var ErrNotFound = errors.New("secret not found in the keychain")
+
+// ErrUnavailable is returned when the system has no usable credential store
+var ErrUnavailable = errors.New("no keychain available")
+
+// backend is one platform's credential store
+type backend interface {
+	set(account, secret string) error
+	get(account string) (string, error)
+	delete(account string) error
+}
+
+// Set stores secret for account, replacing any previous secret
+func Set(account, secret string) error {
+	if err := store.set(account, secret); err != nil {
+		return fmt.Errorf("failed to store secret for %s in the keychain: %w", account, err)
+	}
+	return nil
+}
+
+// Get returns the secret stored for account, or ErrNotFound
+func Get(account string) (string, error) {
+	secret, err := store.get(account)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret for %s from the keychain: %w", account, err)
+	}
+	return secret, nil
+}
+
+// Delete removes the secret stored for account. Deleting a missing secret is not an error.
+func Delete(account string) error {
+	if err := store.delete(account); err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to delete secret for %s from the keychain: %w", account, err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package keychain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// store is the macOS Keychain, through the security tool
+var store backend = securityTool{}
+
+// errSecItemNotFound is the exit status of security when no item matches
+const errSecItemNotFound = 44
+
+// securityTool stores generic passwords in the login keychain
+type securityTool struct{}
+
+// set adds or updates the item. The command goes through security's interactive
+// mode on stdin, hex-encoded, so the secret never appears in a process listing.
+func (securityTool) set(account, secret string) error {
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n", quote(Service), quote(account), hex.EncodeToString([]byte(secret)))
+	_, err := run(command, "security", "-i")
+	return err
+}
+
+func (securityTool) get(account string) (string, error) {
+	out, err := run("", "security", "find-generic-password", "-s", Service, "-a", account, "-w")
+	if exitCode(err) == errSecItemNotFound {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(out, "\n"), nil
+}
+
+func (securityTool) delete(account string) error {
+	_, err := run("", "security", "delete-generic-password", "-s", Service, "-a", account)
+	if exitCode(err) == errSecItemNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// quote quotes s for security's interactive mode, which splits words like a shell
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,37 @@
+//go:build !darwin && !windows
+
+package keychain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// store is the Secret Service (GNOME Keyring, KWallet), through secret-tool from libsecret
+var store backend = secretTool{}
+
+// secretTool stores secrets as items with service and account attributes
+type secretTool struct{}
+
+// set stores the item; secret-tool reads the secret from stdin
+func (secretTool) set(account, secret string) error {
+	_, err := run(secret, "secret-tool", "store", "--label", fmt.Sprintf("%s (%s)", Service, account), "service", Service, "account", account)
+	return err
+}
+
+// get looks the item up; secret-tool exits with status 1 and no output when there is none
+func (secretTool) get(account string) (string, error) {
+	out, err := run("", "secret-tool", "lookup", "service", Service, "account", account)
+	if exitCode(err) == 1 && out == "" {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(out, "\n"), nil
+}
+
+func (secretTool) delete(account string) error {
+	_, err := run("", "secret-tool", "clear", "service", Service, "account", account)
+	return err
+}
@@ -0,0 +1,67 @@
+//go:build !darwin && !windows
+
+package keychain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSecretTool(t *testing.T) {
+	type call struct {
+		stdin string
+		args  []string
+	}
+	saved := run
+	t.Cleanup(func() { run = saved })
+	var calls []call
+	items := map[string]string{}
+	run = func(stdin, name string, args ...string) (string, error) {
+		calls = append(calls, call{stdin, append([]string{name}, args...)})
+		account := args[len(args)-1]
+		switch args[0] {
+		case "store":
+			items[account] = stdin
+		case "lookup":
+			secret, ok := items[account]
+			if !ok {
+				return "", &toolError{code: 1}
+			}
+			return secret, nil
+		case "clear":
+			delete(items, account)
+		}
+		return "", nil
+	}
+
+	if err := Set("admin@nas", "s3cret"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	want := call{"s3cret", []string{"secret-tool", "store", "--label", "qnap-vm (admin@nas)", "service", "qnap-vm", "account", "admin@nas"}}
+	if !reflect.DeepEqual(calls[0], want) {
+		t.Errorf("Set() ran %v, want %v", calls[0], want)
+	}
+
+	if secret, err := Get("admin@nas"); err != nil || secret != "s3cret" {
+		t.Errorf("Get() = %q, %v, want s3cret", secret, err)
+	}
+	if err := Delete("admin@nas"); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+	if _, err := Get("admin@nas"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSecretToolUnavailable(t *testing.T) {
+	saved := run
+	t.Cleanup(func() { run = saved })
+	run = func(stdin, name string, args ...string) (string, error) {
+		return "", ErrUnavailable
+	}
+
+	if _, err := Get("admin@nas"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Get() error = %v, want ErrUnavailable", err)
+	}
+}
@@ -0,0 +1,281 @@
+// Package messages is the catalog of user-facing messages. Each message has a stable ID
+// that wrapper scripts can match instead of the English text, and may be translated.
+package messages
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ID identifies a message independently of its wording. IDs never change once released.
+type ID string
+
+// Message IDs
+const (
+	Error     ID = "error"
+	Cancelled ID = "op.cancelled"
+	NeedsYes  ID = "op.needs_yes"
+	Usage     ID = "op.usage"
+
+	ConnectFailed ID = "conn.failed"
+
+	VMNotFound       ID = "vm.not_found"
+	VMExists         ID = "vm.exists"
+	VMCreating       ID = "vm.creating"
+	VMCreated        ID = "vm.created"
+	VMStarting       ID = "vm.starting"
+	VMStarted        ID = "vm.started"
+	VMAlreadyRunning ID = "vm.already_running"
+	VMShuttingDown   ID = "vm.shutting_down"
+	VMForceStopping  ID = "vm.force_stopping"
+	VMStopped        ID = "vm.stopped"
+	VMAlreadyStopped ID = "vm.already_stopped"
+	VMDeleteConfirm  ID = "vm.delete_confirm"
+	VMDeleting       ID = "vm.deleting"
+	VMDeleted        ID = "vm.deleted"
+
+	SnapshotCreated ID = "snapshot.created"
+	SnapshotDeleted ID = "snapshot.deleted"
+
+	BackupCreated  ID = "backup.created"
+	BackupRestored ID = "backup.restored"
+
+	NetworkExists ID = "network.exists"
+
+	InventoryStale ID = "inventory.stale"
+)
+
+// english is the built-in catalog. Texts are fmt formats; translations must use the
+// same verbs and may reorder them with explicit indexes such as %[2]s.
+var english = map[ID]string{
+	Error:     "Error: %v",
+	Cancelled: "Operation cancelled",
+	NeedsYes:  "confirmation required but input is not interactive; pass --yes to proceed",
+	Usage:     "%w",
+
+	ConnectFailed: "failed to connect to QNAP device %s: %w\n%s",
+
+	VMNotFound:       "VM '%s' not found",
+	VMExists:         "VM '%s' already exists",
+	VMCreating:       "Creating VM '%s' (Memory: %dMB, CPUs: %d)...",
+	VMCreated:        "VM '%s' created successfully!",
+	VMStarting:       "Starting VM '%s'...",
+	VMStarted:        "VM '%s' started successfully",
+	VMAlreadyRunning: "VM '%s' is already running",
+	VMShuttingDown:   "Shutting down VM '%s'...",
+	VMForceStopping:  "Force stopping VM '%s'...",
+	VMStopped:        "VM '%s' stopped successfully",
+	VMAlreadyStopped: "VM '%s' is already stopped",
+	VMDeleteConfirm:  "Are you sure you want to delete VM '%s' on %s? This will permanently delete the VM definition. (y/N): ",
+	VMDeleting:       "Deleting VM '%s'...",
+	VMDeleted:        "VM '%s' deleted successfully",
+
+	SnapshotCreated: "Snapshot '%s' created successfully",
+	SnapshotDeleted: "Snapshot '%s' deleted successfully",
+
+	BackupCreated:  "Backup of VM '%s' created: %s",
+	BackupRestored: "VM '%s' restored from %s",
+
+	NetworkExists: "network '%s' already exists",
+
+	InventoryStale: "OFFLINE: cached data for %s from %s (%s); it may be out of date",
+}
+
+// Catalog resolves message IDs to text in one language
+type Catalog struct {
+	Lang    string
+	ShowIDs bool // Prefix messages with "[id] " for scripts
+	texts   map[ID]string
+}
+
+// current is the catalog used by the package-level functions
+var current = &Catalog{Lang: "en"}
+
+// status receives status messages: stdout, or io.Discard when quiet
+var status io.Writer = os.Stdout
+
+// SetQuiet drops status messages when quiet is set, for --quiet
+func SetQuiet(quiet bool) {
+	if quiet {
+		status = io.Discard
+	} else {
+		status = os.Stdout
+	}
+}
+
+// Status returns the writer for status messages. Output a command was asked for, such
+// as tables and JSON, goes to stdout regardless.
+func Status() io.Writer {
+	return status
+}
+
+// Default returns the catalog used by the package-level functions
+func Default() *Catalog {
+	return current
+}
+
+// SetDefault replaces the catalog used by the package-level functions
+func SetDefault(c *Catalog) {
+	current = c
+}
+
+// Load returns the catalog for lang, reading translations from dir/<lang>.yaml. Messages
+// missing from the file, and every message for "en", use the built-in English text.
+func Load(lang, dir string) (*Catalog, error) {
+	catalog := &Catalog{Lang: lang}
+	if lang == "" || lang == "en" {
+		catalog.Lang = "en"
+		return catalog, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, lang+".yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read translations for '%s': %w", lang, err)
+	}
+
+	texts, err := ParseTranslations(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid translations for '%s': %w", lang, err)
+	}
+	catalog.texts = texts
+	return catalog, nil
+}
+
+// ParseTranslations reads a YAML map of message ID to translated text. Unknown IDs are
+// rejected so typos are caught.
+func ParseTranslations(data []byte) (map[ID]string, error) {
+	var raw map[string]string
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	texts := make(map[ID]string, len(raw))
+	for key, text := range raw {
+		id := ID(key)
+		if _, known := english[id]; !known {
+			return nil, fmt.Errorf("unknown message ID '%s'", key)
+		}
+		texts[id] = text
+	}
+	return texts, nil
+}
+
+// LanguageFromEnv returns the language requested by QNAP_VM_LANG, falling back to the
+// POSIX locale variables, e.g. "de" for LANG=de_DE.UTF-8
+func LanguageFromEnv() string {
+	for _, name := range []string{"QNAP_VM_LANG", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			return normalizeLanguage(value)
+		}
+	}
+	return "en"
+}
+
+// normalizeLanguage reduces a locale such as de_DE.UTF-8 to its language code
+func normalizeLanguage(locale string) string {
+	lang := strings.ToLower(locale)
+	if i := strings.IndexAny(lang, "_.@-"); i >= 0 {
+		lang = lang[:i]
+	}
+	if lang == "" || lang == "c" || lang == "posix" {
+		return "en"
+	}
+	return lang
+}
+
+// Text returns the untranslated format for id in this catalog's language
+func (c *Catalog) Text(id ID) string {
+	if text, ok := c.texts[id]; ok {
+		return text
+	}
+	if text, ok := english[id]; ok {
+		return text
+	}
+	return string(id)
+}
+
+// Sprintf formats message id with args, prefixed with its ID when ShowIDs is set
+func (c *Catalog) Sprintf(id ID, args ...interface{}) string {
+	text := fmt.Sprintf(c.Text(id), args...)
+	if c.ShowIDs {
+		return fmt.Sprintf("[%s] %s", id, text)
+	}
+	return text
+}
+
+// Sprintf formats message id with the default catalog
+func Sprintf(id ID, args ...interface{}) string {
+	return current.Sprintf(id, args...)
+}
+
+// Printf prints message id without a trailing newline, for prompts
+func Printf(id ID, args ...interface{}) {
+	fmt.Print(current.Sprintf(id, args...))
+}
+
+// Println prints status message id on its own line to the status writer
+func Println(id ID, args ...interface{}) {
+	fmt.Fprintln(status, current.Sprintf(id, args...))
+}
+
+// IDError is an error carrying the ID of the message it was built from
+type IDError struct {
+	ID  ID
+	err error
+}
+
+func (e *IDError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error wrapped with %w, if any
+func (e *IDError) Unwrap() error {
+	return errors.Unwrap(e.err)
+}
+
+// Errorf builds an error from message id. The text may wrap another error with %w.
+// The ID is never shown in Error(), so wrapping the error keeps messages readable.
+func Errorf(id ID, args ...interface{}) error {
+	return &IDError{ID: id, err: fmt.Errorf(current.Text(id), args...)}
+}
+
+// IDOf returns the ID of the outermost message error in err's chain, or "" if it has none
+func IDOf(err error) ID {
+	var idErr *IDError
+	if errors.As(err, &idErr) {
+		return idErr.ID
+	}
+	return ""
+}
+
+// FormatError formats a command's final error. With ShowIDs the line starts with the
+// ID of the message error in the chain, or "error" when there is none.
+func FormatError(err error) string {
+	text := fmt.Sprintf(current.Text(Error), err)
+	if !current.ShowIDs {
+		return text
+	}
+
+	id := IDOf(err)
+	if id == "" {
+		id = Error
+	}
+	return fmt.Sprintf("[%s] %s", id, text)
+}
+
+// IDs returns every message ID in the catalog, sorted
+func IDs() []ID {
+	ids := make([]ID, 0, len(english))
+	for id := range english {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
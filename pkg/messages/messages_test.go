@@ -0,0 +1,113 @@
+package messages
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCatalogSprintf(t *testing.T) {
+	catalog := &Catalog{Lang: "en"}
+	if got := catalog.Sprintf(VMStarted, "web"); got != "VM 'web' started successfully" {
+		t.Errorf("Sprintf() = %q", got)
+	}
+
+	catalog.ShowIDs = true
+	if got := catalog.Sprintf(VMStarted, "web"); got != "[vm.started] VM 'web' started successfully" {
+		t.Errorf("Sprintf() with IDs = %q", got)
+	}
+}
+
+func TestLoadTranslations(t *testing.T) {
+	dir := t.TempDir()
+	data := "vm.created: \"VM '%s' wurde erstellt\"\nvm.delete_confirm: \"%[2]s: VM '%[1]s' löschen? (j/N): \"\n"
+	if err := os.WriteFile(filepath.Join(dir, "de.yaml"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	catalog, err := Load("de", dir)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if got := catalog.Sprintf(VMCreated, "web"); got != "VM 'web' wurde erstellt" {
+		t.Errorf("translated Sprintf() = %q", got)
+	}
+	if got := catalog.Sprintf(VMDeleteConfirm, "web", "nas"); got != "nas: VM 'web' löschen? (j/N): " {
+		t.Errorf("reordered Sprintf() = %q", got)
+	}
+	// Untranslated messages fall back to English
+	if got := catalog.Sprintf(VMStarted, "web"); got != "VM 'web' started successfully" {
+		t.Errorf("fallback Sprintf() = %q", got)
+	}
+
+	if _, err := Load("fr", dir); err == nil {
+		t.Error("Load() should fail for a missing translation file")
+	}
+	if catalog, err := Load("en", dir); err != nil || catalog.Lang != "en" {
+		t.Errorf("Load(en) = %+v, %v", catalog, err)
+	}
+}
+
+func TestParseTranslationsRejectsUnknownIDs(t *testing.T) {
+	if _, err := ParseTranslations([]byte("vm.startd: \"typo\"\n")); err == nil {
+		t.Error("ParseTranslations() should reject unknown IDs")
+	}
+}
+
+func TestNormalizeLanguage(t *testing.T) {
+	tests := map[string]string{
+		"de_DE.UTF-8": "de",
+		"fr":          "fr",
+		"pt-BR":       "pt",
+		"C":           "en",
+		"POSIX":       "en",
+		"C.UTF-8":     "en",
+	}
+	for locale, want := range tests {
+		if got := normalizeLanguage(locale); got != want {
+			t.Errorf("normalizeLanguage(%q) = %q, want %q", locale, got, want)
+		}
+	}
+}
+
+func TestErrorIDs(t *testing.T) {
+	cause := errors.New("connection reset")
+	err := fmt.Errorf("start failed: %w", Errorf(VMNotFound, "web"))
+
+	if got := IDOf(err); got != VMNotFound {
+		t.Errorf("IDOf() = %q, want %q", got, VMNotFound)
+	}
+	if IDOf(cause) != "" {
+		t.Error("IDOf() should be empty for errors without an ID")
+	}
+	if err.Error() != "start failed: VM 'web' not found" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+
+	saved := Default()
+	defer SetDefault(saved)
+	SetDefault(&Catalog{Lang: "en", ShowIDs: true})
+
+	if got := FormatError(err); got != "[vm.not_found] Error: start failed: VM 'web' not found" {
+		t.Errorf("FormatError() = %q", got)
+	}
+	if got := FormatError(cause); got != "[error] Error: connection reset" {
+		t.Errorf("FormatError() without ID = %q", got)
+	}
+}
+
+func TestSetQuiet(t *testing.T) {
+	defer SetQuiet(false)
+
+	SetQuiet(true)
+	if Status() != io.Discard {
+		t.Error("Status() should discard output when quiet")
+	}
+	SetQuiet(false)
+	if Status() != os.Stdout {
+		t.Error("Status() should be stdout when not quiet")
+	}
+}
@@ -0,0 +1,457 @@
+// Package migrate provides import of virtual machines from Proxmox VE, VMware ESXi, and OVF exports.
+package migrate
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Source kinds supported by the migration assistant
+const (
+	KindProxmox = "proxmox"
+	KindESXi    = "esxi"
+	KindOVF     = "ovf"
+)
+
+// Source identifies a VM on a foreign hypervisor
+type Source struct {
+	Kind     string // proxmox, esxi or ovf
+	Host     string // Source host (proxmox/esxi only)
+	Port     int    // SSH port on the source host
+	Username string // SSH username on the source host
+	VM       string // VMID (proxmox), VM name (esxi) or local .ovf path (ovf)
+}
+
+// Plan describes a source VM translated into qnap-vm terms
+type Plan struct {
+	Name   string `json:"name"`
+	Memory int    `json:"memory_mb"`
+	CPUs   int    `json:"cpus"`
+	OSType string `json:"os_type"` // linux, windows or other
+	Disks  []Disk `json:"disks"`
+	NICs   []NIC  `json:"nics"`
+}
+
+// Disk describes a source disk to be transferred and converted
+type Disk struct {
+	Source string `json:"source"` // Path or volume ID on the source
+	Format string `json:"format"` // raw, qcow2 or vmdk
+	Bus    string `json:"bus"`    // Original controller: virtio, scsi, sata or ide
+}
+
+// NIC describes a source network interface
+type NIC struct {
+	Model  string `json:"model"`
+	MAC    string `json:"mac,omitempty"`
+	Bridge string `json:"bridge,omitempty"`
+}
+
+// ParseSource parses a migration source URL such as proxmox://root@pve/100,
+// esxi://esxi.local/myvm or ovf:///path/to/vm.ovf
+func ParseSource(raw string) (*Source, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source URL '%s': %w", raw, err)
+	}
+
+	src := &Source{Kind: strings.ToLower(u.Scheme), Port: 22, Username: "root"}
+
+	switch src.Kind {
+	case KindProxmox, KindESXi:
+		src.Host = u.Hostname()
+		if p := u.Port(); p != "" {
+			port, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port in source URL '%s'", raw)
+			}
+			src.Port = port
+		}
+		if u.User != nil && u.User.Username() != "" {
+			src.Username = u.User.Username()
+		}
+		src.VM = strings.Trim(u.Path, "/")
+		if src.Host == "" || src.VM == "" {
+			return nil, fmt.Errorf("source URL must look like %s://[user@]host/vm", src.Kind)
+		}
+	case KindOVF:
+		src.VM = u.Host + u.Path
+		if src.VM == "" {
+			return nil, fmt.Errorf("source URL must look like ovf:///path/to/vm.ovf")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported source type '%s' (use proxmox://, esxi:// or ovf://)", u.Scheme)
+	}
+
+	return src, nil
+}
+
+// TargetBus returns the disk bus to use on the QNAP for this plan.
+// Windows guests keep an emulated controller because the installed OS
+// rarely has virtio drivers; everything else is moved to virtio.
+func (p *Plan) TargetBus() string {
+	if p.OSType == "windows" {
+		return "sata"
+	}
+	return "virtio"
+}
+
+// Guidance returns post-migration advice for the converted VM
+func (p *Plan) Guidance() []string {
+	var notes []string
+
+	bus := p.TargetBus()
+	for _, disk := range p.Disks {
+		if disk.Bus != bus && bus == "virtio" {
+			notes = append(notes, fmt.Sprintf("Disks were remapped from %s to virtio; make sure /etc/fstab and the bootloader use UUID= or LABEL= instead of /dev/sdX names.", disk.Bus))
+			break
+		}
+	}
+
+	if p.OSType == "windows" {
+		notes = append(notes,
+			"Windows guest: disks are attached on SATA so the existing installation can boot.",
+			"Install the virtio-win drivers (https://fedorapeople.org/groups/virt/virtio-win/) inside the guest, then switch the disk bus to virtio for better performance.")
+	}
+
+	for _, nic := range p.NICs {
+		if nic.Bridge != "" {
+			notes = append(notes, fmt.Sprintf("NIC %s was attached to bridge '%s' on the source; it has been recreated with the default qnap-vm network.", nicLabel(nic), nic.Bridge))
+		}
+	}
+
+	return notes
+}
+
+// nicLabel returns a short description of a NIC for user-facing messages
+func nicLabel(nic NIC) string {
+	if nic.MAC != "" {
+		return fmt.Sprintf("%s (%s)", nic.Model, nic.MAC)
+	}
+	return nic.Model
+}
+
+var (
+	proxmoxDiskKey = regexp.MustCompile(`^(scsi|sata|ide|virtio)(\d+)$`)
+	proxmoxNetKey  = regexp.MustCompile(`^net\d+$`)
+)
+
+// ParseProxmoxConfig parses the output of 'qm config VMID'
+func ParseProxmoxConfig(output string) (*Plan, error) {
+	plan := &Plan{OSType: "other"}
+	sockets, cores := 1, 0
+
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch {
+		case key == "name":
+			plan.Name = value
+		case key == "memory":
+			plan.Memory, _ = strconv.Atoi(value)
+		case key == "cores":
+			cores, _ = strconv.Atoi(value)
+		case key == "sockets":
+			sockets, _ = strconv.Atoi(value)
+		case key == "ostype":
+			plan.OSType = proxmoxOSType(value)
+		case proxmoxDiskKey.MatchString(key):
+			opts := strings.Split(value, ",")
+			volume := opts[0]
+			if volume == "none" || strings.Contains(value, "media=cdrom") || strings.Contains(volume, "cloudinit") {
+				continue
+			}
+			plan.Disks = append(plan.Disks, Disk{
+				Source: volume,
+				Format: diskFormatFromPath(volume),
+				Bus:    proxmoxDiskKey.FindStringSubmatch(key)[1],
+			})
+		case proxmoxNetKey.MatchString(key):
+			nic := NIC{}
+			for _, opt := range strings.Split(value, ",") {
+				k, v, _ := strings.Cut(opt, "=")
+				switch k {
+				case "virtio", "e1000", "rtl8139", "vmxnet3":
+					nic.Model = k
+					nic.MAC = v
+				case "bridge":
+					nic.Bridge = v
+				}
+			}
+			plan.NICs = append(plan.NICs, nic)
+		}
+	}
+
+	if cores > 0 {
+		plan.CPUs = cores * sockets
+	}
+
+	if plan.Memory == 0 || plan.CPUs == 0 {
+		return nil, fmt.Errorf("could not determine memory and CPU count from Proxmox config")
+	}
+
+	return plan, nil
+}
+
+// proxmoxOSType maps a Proxmox ostype value (l26, win10, ...) to an OS family
+func proxmoxOSType(ostype string) string {
+	switch {
+	case strings.HasPrefix(ostype, "w"): // wxp, w2k8, wvista, win10, win11, ...
+		return "windows"
+	case strings.HasPrefix(ostype, "l2"):
+		return "linux"
+	default:
+		return "other"
+	}
+}
+
+// ParseVMX parses the contents of a VMware .vmx file
+func ParseVMX(content string) (*Plan, error) {
+	plan := &Plan{OSType: "other", CPUs: 1}
+	values := make(map[string]string)
+
+	for _, line := range strings.Split(content, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		values[strings.ToLower(strings.TrimSpace(key))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+
+	plan.Name = values["displayname"]
+	if v, err := strconv.Atoi(values["memsize"]); err == nil {
+		plan.Memory = v
+	}
+	if v, err := strconv.Atoi(values["numvcpus"]); err == nil {
+		plan.CPUs = v
+	}
+	plan.OSType = vmwareOSType(values["guestos"])
+
+	diskKey := regexp.MustCompile(`^(scsi|sata|ide|nvme)(\d+):(\d+)\.filename$`)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := values[key]
+		matches := diskKey.FindStringSubmatch(key)
+		if matches == nil || !strings.HasSuffix(strings.ToLower(value), ".vmdk") {
+			continue
+		}
+		if values[fmt.Sprintf("%s%s:%s.devicetype", matches[1], matches[2], matches[3])] == "cdrom-image" {
+			continue
+		}
+		plan.Disks = append(plan.Disks, Disk{
+			// The -flat.vmdk extent holds the raw data of a monolithic flat disk
+			Source: strings.TrimSuffix(value, ".vmdk") + "-flat.vmdk",
+			Format: "raw",
+			Bus:    vmwareBus(matches[1]),
+		})
+	}
+
+	for i := 0; ; i++ {
+		prefix := fmt.Sprintf("ethernet%d.", i)
+		if values[prefix+"present"] != "TRUE" && values[prefix+"present"] != "true" {
+			break
+		}
+		nic := NIC{Model: values[prefix+"virtualdev"]}
+		if nic.Model == "" {
+			nic.Model = "e1000"
+		}
+		nic.MAC = values[prefix+"generatedaddress"]
+		if nic.MAC == "" {
+			nic.MAC = values[prefix+"address"]
+		}
+		nic.Bridge = values[prefix+"networkname"]
+		plan.NICs = append(plan.NICs, nic)
+	}
+
+	if plan.Memory == 0 {
+		return nil, fmt.Errorf("could not determine memory size from VMX file")
+	}
+
+	return plan, nil
+}
+
+// vmwareOSType maps a VMware guestOS identifier to an OS family
+func vmwareOSType(guestOS string) string {
+	guestOS = strings.ToLower(guestOS)
+	switch {
+	case strings.HasPrefix(guestOS, "win"):
+		return "windows"
+	case guestOS == "":
+		return "other"
+	case strings.Contains(guestOS, "linux") || strings.Contains(guestOS, "ubuntu") || strings.Contains(guestOS, "debian") ||
+		strings.Contains(guestOS, "centos") || strings.Contains(guestOS, "rhel") || strings.Contains(guestOS, "sles") ||
+		strings.Contains(guestOS, "fedora") || strings.Contains(guestOS, "oracle"):
+		return "linux"
+	default:
+		return "other"
+	}
+}
+
+// vmwareBus maps a VMware controller name to a libvirt disk bus
+func vmwareBus(controller string) string {
+	if controller == "nvme" {
+		return "virtio"
+	}
+	return controller
+}
+
+// ovfEnvelope is the subset of the OVF 1.x/2.x descriptor used for migration
+type ovfEnvelope struct {
+	References struct {
+		Files []struct {
+			ID   string `xml:"id,attr"`
+			Href string `xml:"href,attr"`
+		} `xml:"File"`
+	} `xml:"References"`
+	DiskSection struct {
+		Disks []struct {
+			DiskID  string `xml:"diskId,attr"`
+			FileRef string `xml:"fileRef,attr"`
+			Format  string `xml:"format,attr"`
+		} `xml:"Disk"`
+	} `xml:"DiskSection"`
+	VirtualSystem struct {
+		ID              string `xml:"id,attr"`
+		Name            string `xml:"Name"`
+		OperatingSystem struct {
+			OSType      string `xml:"osType,attr"`
+			Description string `xml:"Description"`
+		} `xml:"OperatingSystemSection"`
+		Hardware struct {
+			Items []ovfItem `xml:"Item"`
+		} `xml:"VirtualHardwareSection"`
+	} `xml:"VirtualSystem"`
+}
+
+type ovfItem struct {
+	InstanceID      string `xml:"InstanceID"`
+	Parent          string `xml:"Parent"`
+	ResourceType    int    `xml:"ResourceType"`
+	ResourceSubType string `xml:"ResourceSubType"`
+	VirtualQuantity int    `xml:"VirtualQuantity"`
+	AllocationUnits string `xml:"AllocationUnits"`
+	HostResource    string `xml:"HostResource"`
+	Address         string `xml:"Address"`
+	Connection      string `xml:"Connection"`
+}
+
+// OVF CIM resource types
+const (
+	ovfResourceCPU      = 3
+	ovfResourceMemory   = 4
+	ovfResourceIDE      = 5
+	ovfResourceSCSI     = 6
+	ovfResourceEthernet = 10
+	ovfResourceDisk     = 17
+	ovfResourceSATA     = 20
+)
+
+// ParseOVF parses an OVF descriptor. Disk sources are file names relative to the descriptor.
+func ParseOVF(data []byte) (*Plan, error) {
+	var env ovfEnvelope
+	if err := xml.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("failed to parse OVF descriptor: %w", err)
+	}
+
+	vs := env.VirtualSystem
+	plan := &Plan{Name: vs.Name, OSType: vmwareOSType(vs.OperatingSystem.OSType)}
+	if plan.Name == "" {
+		plan.Name = vs.ID
+	}
+	if plan.OSType == "other" && strings.Contains(strings.ToLower(vs.OperatingSystem.Description), "windows") {
+		plan.OSType = "windows"
+	}
+
+	files := make(map[string]string)
+	for _, f := range env.References.Files {
+		files[f.ID] = f.Href
+	}
+	diskFiles := make(map[string]string)
+	for _, d := range env.DiskSection.Disks {
+		diskFiles[d.DiskID] = files[d.FileRef]
+	}
+
+	controllers := make(map[string]string)
+	for _, item := range vs.Hardware.Items {
+		switch item.ResourceType {
+		case ovfResourceIDE:
+			controllers[item.InstanceID] = "ide"
+		case ovfResourceSCSI:
+			controllers[item.InstanceID] = "scsi"
+		case ovfResourceSATA:
+			controllers[item.InstanceID] = "sata"
+		}
+	}
+
+	for _, item := range vs.Hardware.Items {
+		switch item.ResourceType {
+		case ovfResourceCPU:
+			plan.CPUs = item.VirtualQuantity
+		case ovfResourceMemory:
+			plan.Memory = ovfMemoryMB(item.VirtualQuantity, item.AllocationUnits)
+		case ovfResourceDisk:
+			id := path.Base(item.HostResource) // ovf:/disk/vmdisk1
+			file := diskFiles[id]
+			if file == "" {
+				continue
+			}
+			bus := controllers[item.Parent]
+			if bus == "" {
+				bus = "scsi"
+			}
+			plan.Disks = append(plan.Disks, Disk{Source: file, Format: diskFormatFromPath(file), Bus: bus})
+		case ovfResourceEthernet:
+			model := strings.ToLower(item.ResourceSubType)
+			if model == "" {
+				model = "e1000"
+			}
+			plan.NICs = append(plan.NICs, NIC{Model: model, Bridge: item.Connection})
+		}
+	}
+
+	if plan.Memory == 0 || plan.CPUs == 0 {
+		return nil, fmt.Errorf("could not determine memory and CPU count from OVF descriptor")
+	}
+
+	return plan, nil
+}
+
+// ovfMemoryMB converts an OVF memory quantity to megabytes
+func ovfMemoryMB(quantity int, units string) int {
+	units = strings.ReplaceAll(strings.ToLower(units), " ", "")
+	switch units {
+	case "byte*2^30", "gigabytes":
+		return quantity * 1024
+	case "byte*2^10", "kilobytes":
+		return quantity / 1024
+	default: // byte*2^20, megabytes
+		return quantity
+	}
+}
+
+// diskFormatFromPath guesses the image format from a file or volume name
+func diskFormatFromPath(p string) string {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".qcow2":
+		return "qcow2"
+	case ".vmdk":
+		return "vmdk"
+	default:
+		return "raw"
+	}
+}
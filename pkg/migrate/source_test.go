@@ -0,0 +1,205 @@
+package migrate
+
+import (
+	"testing"
+)
+
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		kind     string
+		host     string
+		port     int
+		username string
+		vm       string
+		wantErr  bool
+	}{
+		{"proxmox://pve.local/100", KindProxmox, "pve.local", 22, "root", "100", false},
+		{"proxmox://admin@pve.local:2222/101", KindProxmox, "pve.local", 2222, "admin", "101", false},
+		{"esxi://esxi.local/web-server", KindESXi, "esxi.local", 22, "root", "web-server", false},
+		{"ovf:///exports/vm/vm.ovf", KindOVF, "", 22, "root", "/exports/vm/vm.ovf", false},
+		{"proxmox://pve.local", "", "", 0, "", "", true},
+		{"hyperv://host/vm", "", "", 0, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			src, err := ParseSource(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSource(%s) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if src.Kind != tt.kind || src.Host != tt.host || src.Port != tt.port || src.Username != tt.username || src.VM != tt.vm {
+				t.Errorf("ParseSource(%s) = %+v", tt.input, src)
+			}
+		})
+	}
+}
+
+func TestParseProxmoxConfig(t *testing.T) {
+	sampleOutput := `boot: order=scsi0;ide2;net0
+cores: 2
+ide2: local:iso/debian-12.iso,media=cdrom,size=628M
+memory: 4096
+name: home-assistant
+net0: virtio=BC:24:11:AA:BB:CC,bridge=vmbr0,firewall=1
+ostype: l26
+scsi0: local-lvm:vm-100-disk-0,iothread=1,size=32G
+scsi1: local:100/vm-100-disk-1.qcow2,size=10G
+scsihw: virtio-scsi-single
+sockets: 2
+`
+
+	plan, err := ParseProxmoxConfig(sampleOutput)
+	if err != nil {
+		t.Fatalf("ParseProxmoxConfig failed: %v", err)
+	}
+
+	if plan.Name != "home-assistant" {
+		t.Errorf("Expected name 'home-assistant', got '%s'", plan.Name)
+	}
+	if plan.Memory != 4096 {
+		t.Errorf("Expected memory 4096, got %d", plan.Memory)
+	}
+	if plan.CPUs != 4 {
+		t.Errorf("Expected 4 CPUs (2 sockets x 2 cores), got %d", plan.CPUs)
+	}
+	if plan.OSType != "linux" {
+		t.Errorf("Expected OS type 'linux', got '%s'", plan.OSType)
+	}
+	if len(plan.Disks) != 2 {
+		t.Fatalf("Expected 2 disks (CD-ROM skipped), got %d", len(plan.Disks))
+	}
+	if plan.Disks[1].Format != "qcow2" || plan.Disks[1].Bus != "scsi" {
+		t.Errorf("Unexpected second disk: %+v", plan.Disks[1])
+	}
+	if len(plan.NICs) != 1 || plan.NICs[0].MAC != "BC:24:11:AA:BB:CC" || plan.NICs[0].Bridge != "vmbr0" {
+		t.Errorf("Unexpected NICs: %+v", plan.NICs)
+	}
+	if plan.TargetBus() != "virtio" {
+		t.Errorf("Expected virtio target bus for Linux guest, got %s", plan.TargetBus())
+	}
+}
+
+func TestParseVMX(t *testing.T) {
+	sampleVMX := `.encoding = "UTF-8"
+displayName = "win-server"
+memSize = "8192"
+numvcpus = "4"
+guestOS = "windows9srv-64"
+scsi0:0.fileName = "win-server.vmdk"
+scsi0:1.fileName = "win-server_1.vmdk"
+sata0:0.deviceType = "cdrom-image"
+sata0:0.fileName = "/vmfs/volumes/datastore1/iso/install.iso"
+ethernet0.present = "TRUE"
+ethernet0.virtualDev = "vmxnet3"
+ethernet0.networkName = "VM Network"
+ethernet0.generatedAddress = "00:0c:29:12:34:56"
+`
+
+	plan, err := ParseVMX(sampleVMX)
+	if err != nil {
+		t.Fatalf("ParseVMX failed: %v", err)
+	}
+
+	if plan.Name != "win-server" || plan.Memory != 8192 || plan.CPUs != 4 {
+		t.Errorf("Unexpected plan: %+v", plan)
+	}
+	if plan.OSType != "windows" {
+		t.Errorf("Expected OS type 'windows', got '%s'", plan.OSType)
+	}
+	if len(plan.Disks) != 2 {
+		t.Fatalf("Expected 2 disks, got %d", len(plan.Disks))
+	}
+	if plan.Disks[0].Source != "win-server-flat.vmdk" || plan.Disks[0].Format != "raw" {
+		t.Errorf("Unexpected first disk: %+v", plan.Disks[0])
+	}
+	if len(plan.NICs) != 1 || plan.NICs[0].Model != "vmxnet3" {
+		t.Errorf("Unexpected NICs: %+v", plan.NICs)
+	}
+	if plan.TargetBus() != "sata" {
+		t.Errorf("Expected sata target bus for Windows guest, got %s", plan.TargetBus())
+	}
+}
+
+func TestParseOVF(t *testing.T) {
+	sampleOVF := `<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="http://schemas.dmtf.org/ovf/envelope/1" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1" xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData" xmlns:vmw="http://www.vmware.com/schema/ovf">
+  <References>
+    <File ovf:href="db-disk1.vmdk" ovf:id="file1"/>
+  </References>
+  <DiskSection>
+    <Disk ovf:capacity="40" ovf:diskId="vmdisk1" ovf:fileRef="file1" ovf:format="http://www.vmware.com/interfaces/specifications/vmdk.html#streamOptimized"/>
+  </DiskSection>
+  <VirtualSystem ovf:id="db">
+    <Name>db</Name>
+    <OperatingSystemSection ovf:id="96" vmw:osType="ubuntu64Guest"/>
+    <VirtualHardwareSection>
+      <Item>
+        <rasd:AllocationUnits>hertz * 10^6</rasd:AllocationUnits>
+        <rasd:InstanceID>1</rasd:InstanceID>
+        <rasd:ResourceType>3</rasd:ResourceType>
+        <rasd:VirtualQuantity>2</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:AllocationUnits>byte * 2^20</rasd:AllocationUnits>
+        <rasd:InstanceID>2</rasd:InstanceID>
+        <rasd:ResourceType>4</rasd:ResourceType>
+        <rasd:VirtualQuantity>2048</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:InstanceID>3</rasd:InstanceID>
+        <rasd:ResourceType>6</rasd:ResourceType>
+      </Item>
+      <Item>
+        <rasd:HostResource>ovf:/disk/vmdisk1</rasd:HostResource>
+        <rasd:InstanceID>4</rasd:InstanceID>
+        <rasd:Parent>3</rasd:Parent>
+        <rasd:ResourceType>17</rasd:ResourceType>
+      </Item>
+      <Item>
+        <rasd:Connection>VM Network</rasd:Connection>
+        <rasd:InstanceID>5</rasd:InstanceID>
+        <rasd:ResourceSubType>E1000</rasd:ResourceSubType>
+        <rasd:ResourceType>10</rasd:ResourceType>
+      </Item>
+    </VirtualHardwareSection>
+  </VirtualSystem>
+</Envelope>`
+
+	plan, err := ParseOVF([]byte(sampleOVF))
+	if err != nil {
+		t.Fatalf("ParseOVF failed: %v", err)
+	}
+
+	if plan.Name != "db" || plan.Memory != 2048 || plan.CPUs != 2 || plan.OSType != "linux" {
+		t.Errorf("Unexpected plan: %+v", plan)
+	}
+	if len(plan.Disks) != 1 || plan.Disks[0].Source != "db-disk1.vmdk" || plan.Disks[0].Format != "vmdk" || plan.Disks[0].Bus != "scsi" {
+		t.Errorf("Unexpected disks: %+v", plan.Disks)
+	}
+	if len(plan.NICs) != 1 || plan.NICs[0].Model != "e1000" || plan.NICs[0].Bridge != "VM Network" {
+		t.Errorf("Unexpected NICs: %+v", plan.NICs)
+	}
+}
+
+func TestFindESXiVMX(t *testing.T) {
+	sampleOutput := `Vmid       Name                  File                          Guest OS       Version   Annotation
+1      web server   [datastore1] web server/web server.vmx   ubuntu64Guest   vmx-13
+2      db           [ssd] db/db.vmx                         centos7_64Guest vmx-14
+`
+
+	vmxPath, err := findESXiVMX(sampleOutput, "web server")
+	if err != nil {
+		t.Fatalf("findESXiVMX failed: %v", err)
+	}
+	if vmxPath != "/vmfs/volumes/datastore1/web server/web server.vmx" {
+		t.Errorf("Unexpected VMX path: %s", vmxPath)
+	}
+
+	if _, err := findESXiVMX(sampleOutput, "missing"); err == nil {
+		t.Error("Expected error for missing VM")
+	}
+}
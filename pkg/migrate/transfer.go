@@ -0,0 +1,217 @@
+package migrate
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+	"github.com/scttfrdmn/qnap-vm/pkg/storage"
+)
+
+// Migrator inspects a source VM and transfers its disks to the QNAP device
+type Migrator struct {
+	source    *Source
+	srcClient *ssh.Client // nil for local OVF sources
+	dstClient *ssh.Client
+	storage   *storage.Manager
+	vmxDir    string // Directory of the .vmx file (esxi only)
+}
+
+// NewMigrator creates a new migrator. srcClient must be connected for proxmox and esxi sources.
+func NewMigrator(source *Source, srcClient, dstClient *ssh.Client) *Migrator {
+	return &Migrator{
+		source:    source,
+		srcClient: srcClient,
+		dstClient: dstClient,
+		storage:   storage.NewManager(dstClient),
+	}
+}
+
+// Inspect reads the source VM definition and resolves disk locations
+func (m *Migrator) Inspect() (*Plan, error) {
+	switch m.source.Kind {
+	case KindProxmox:
+		return m.inspectProxmox()
+	case KindESXi:
+		return m.inspectESXi()
+	case KindOVF:
+		return m.inspectOVF()
+	default:
+		return nil, fmt.Errorf("unsupported source type '%s'", m.source.Kind)
+	}
+}
+
+// inspectProxmox reads 'qm config' and resolves volume IDs to paths with 'pvesm path'
+func (m *Migrator) inspectProxmox() (*Plan, error) {
+	output, err := m.srcClient.Execute(fmt.Sprintf("qm config %s", ssh.Quote(m.source.VM)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Proxmox VM %s: %w\nOutput: %s", m.source.VM, err, output)
+	}
+
+	plan, err := ParseProxmoxConfig(output)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range plan.Disks {
+		diskPath, err := m.srcClient.Execute(fmt.Sprintf("pvesm path %s", ssh.Quote(plan.Disks[i].Source)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve Proxmox volume %s: %w", plan.Disks[i].Source, err)
+		}
+		plan.Disks[i].Source = strings.TrimSpace(diskPath)
+		plan.Disks[i].Format = diskFormatFromPath(plan.Disks[i].Source)
+	}
+
+	return plan, nil
+}
+
+// inspectESXi locates the VM's .vmx through vim-cmd and parses it
+func (m *Migrator) inspectESXi() (*Plan, error) {
+	output, err := m.srcClient.Execute("vim-cmd vmsvc/getallvms")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ESXi VMs: %w\nOutput: %s", err, output)
+	}
+
+	vmxPath, err := findESXiVMX(output, m.source.VM)
+	if err != nil {
+		return nil, err
+	}
+	m.vmxDir = path.Dir(vmxPath)
+
+	content, err := m.srcClient.Execute(fmt.Sprintf("cat %s", ssh.Quote(vmxPath)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", vmxPath, err)
+	}
+
+	plan, err := ParseVMX(content)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range plan.Disks {
+		if !path.IsAbs(plan.Disks[i].Source) {
+			plan.Disks[i].Source = path.Join(m.vmxDir, plan.Disks[i].Source)
+		}
+	}
+
+	return plan, nil
+}
+
+// inspectOVF parses a local OVF descriptor
+func (m *Migrator) inspectOVF() (*Plan, error) {
+	data, err := os.ReadFile(m.source.VM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OVF descriptor: %w", err)
+	}
+
+	plan, err := ParseOVF(data)
+	if err != nil {
+		return nil, err
+	}
+
+	baseDir := filepath.Dir(m.source.VM)
+	for i := range plan.Disks {
+		plan.Disks[i].Source = filepath.Join(baseDir, plan.Disks[i].Source)
+	}
+
+	return plan, nil
+}
+
+// findESXiVMX finds the datastore path of a VM's .vmx in 'vim-cmd vmsvc/getallvms' output
+func findESXiVMX(output, vmName string) (string, error) {
+	for _, line := range strings.Split(output, "\n") {
+		// Format: "1      myvm   [datastore1] myvm/myvm.vmx   ubuntu64Guest   vmx-13"
+		start := strings.Index(line, "[")
+		end := strings.Index(line, "]")
+		if start < 0 || end < start {
+			continue
+		}
+		fields := strings.Fields(line[:start])
+		if len(fields) < 2 || strings.Join(fields[1:], " ") != vmName {
+			continue
+		}
+
+		datastore := line[start+1 : end]
+		rest := strings.TrimSpace(line[end+1:])
+		vmxEnd := strings.Index(rest, ".vmx")
+		if vmxEnd < 0 {
+			continue
+		}
+		return path.Join("/vmfs/volumes", datastore, rest[:vmxEnd+4]), nil
+	}
+
+	return "", fmt.Errorf("VM '%s' not found on ESXi host", vmName)
+}
+
+// TransferDisk copies a source disk to the QNAP device and converts it to qcow2 at dstPath
+func (m *Migrator) TransferDisk(disk Disk, dstPath string) error {
+	tmpPath := dstPath + ".import"
+	receiveCmd := fmt.Sprintf("cat > %s", ssh.Quote(tmpPath))
+
+	var err error
+	if m.srcClient == nil {
+		err = m.uploadLocal(disk.Source, receiveCmd)
+	} else {
+		err = m.pipeRemote(disk.Source, receiveCmd)
+	}
+	if err != nil {
+		m.removeRemote(tmpPath)
+		return fmt.Errorf("failed to transfer %s: %w", disk.Source, err)
+	}
+
+	if err := m.storage.ConvertDisk(tmpPath, disk.Format, dstPath); err != nil {
+		m.removeRemote(tmpPath)
+		return err
+	}
+
+	m.removeRemote(tmpPath)
+	return nil
+}
+
+// uploadLocal streams a local file into a command on the QNAP device
+func (m *Migrator) uploadLocal(localPath, receiveCmd string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			// Read-only file; close errors do not affect the transfer
+		}
+	}()
+
+	return m.dstClient.ExecuteStream(receiveCmd, f, io.Discard)
+}
+
+// pipeRemote streams a file from the source host to the QNAP device through this workstation
+func (m *Migrator) pipeRemote(srcPath, receiveCmd string) error {
+	pr, pw := io.Pipe()
+
+	sendErr := make(chan error, 1)
+	go func() {
+		err := m.srcClient.ExecuteStream(fmt.Sprintf("cat %s", ssh.Quote(srcPath)), nil, pw)
+		pw.CloseWithError(err)
+		sendErr <- err
+	}()
+
+	recvErr := m.dstClient.ExecuteStream(receiveCmd, pr, io.Discard)
+	if err := pr.Close(); err != nil {
+		// Closing the read side only unblocks the sender
+	}
+
+	if err := <-sendErr; err != nil {
+		return err
+	}
+	return recvErr
+}
+
+// removeRemote deletes a temporary file on the QNAP device
+func (m *Migrator) removeRemote(p string) {
+	if _, err := m.dstClient.Execute(fmt.Sprintf("rm -f %s", ssh.Quote(p))); err != nil {
+		// Leftover temporary files are harmless and overwritten on retry
+	}
+}
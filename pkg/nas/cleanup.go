@@ -0,0 +1,157 @@
+package nas
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// Kinds of leftovers found by FindLeftovers
+const (
+	LeftoverPartial  = "partial"
+	LeftoverTempXML  = "tempxml"
+	LeftoverHook     = "hook"
+	LeftoverDump     = "dump"
+	LeftoverEmptyDir = "emptydir"
+)
+
+// CleanupItem is a file or directory qnap-vm left on the NAS
+type CleanupItem struct {
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+}
+
+// Reason describes why an item is safe to remove
+func (i CleanupItem) Reason() string {
+	switch i.Kind {
+	case LeftoverPartial:
+		return "interrupted transfer or hook run"
+	case LeftoverTempXML:
+		return "temporary domain XML"
+	case LeftoverHook:
+		return "hook script not scheduled in cron"
+	case LeftoverDump:
+		return "staged crash dump"
+	case LeftoverEmptyDir:
+		return "empty qnap-vm directory"
+	default:
+		return i.Kind
+	}
+}
+
+// leftoverScript lists "<kind>\t<path>" for everything qnap-vm may have left behind.
+// Temporary domain XML is written as /tmp/qnap-vm-<name>.xml; older releases used /tmp/<name>.xml.
+const leftoverScript = `for p in %s; do
+	d="$p/.qnap-vm"
+	[ -d "$d" ] || continue
+	find "$d" -type f \( -name '*.part' -o -name '*.tmp' \) 2>/dev/null | while read -r f; do printf 'partial\t%%s\n' "$f"; done
+	[ -f "$d/hooks/pre-update.sh" ] && printf 'hook\t%%s\n' "$d/hooks/pre-update.sh"
+	[ -d "$d/dumps" ] && find "$d/dumps" -type f -name '*.core' 2>/dev/null | while read -r f; do printf 'dump\t%%s\n' "$f"; done
+	find "$d" -depth -type d 2>/dev/null | while read -r x; do
+		[ -z "$(ls -A "$x" 2>/dev/null)" ] && printf 'emptydir\t%%s\n' "$x"
+	done
+done
+ls /tmp/qnap-vm-*.xml 2>/dev/null | while read -r f; do printf 'tempxml\t%%s\n' "$f"; done
+for n in %s; do
+	grep -q '<domain' "/tmp/$n.xml" 2>/dev/null && printf 'tempxml\t%%s\n' "/tmp/$n.xml"
+done
+true`
+
+// removeEmptyDirsScript removes empty directories under each pool's .qnap-vm tree, deepest first
+const removeEmptyDirsScript = `for p in %s; do
+	[ -d "$p/.qnap-vm" ] || continue
+	find "$p/.qnap-vm" -depth -type d 2>/dev/null | while read -r x; do
+		rmdir "$x" 2>/dev/null && echo "$x"
+	done
+done
+true`
+
+// FindLeftovers lists files and directories qnap-vm placed on the NAS that are no longer needed.
+// vmNames are the defined VMs, used to recognise temporary XML from older releases.
+// Staged crash dumps are only included when includeDumps is set.
+func (m *Manager) FindLeftovers(poolPaths, vmNames []string, includeDumps bool) ([]CleanupItem, error) {
+	hook, err := m.PreUpdateHookStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := m.sshClient.Execute(fmt.Sprintf(leftoverScript, quoteAll(poolPaths), quoteAll(vmNames)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan for leftovers: %w\nOutput: %s", err, output)
+	}
+
+	activeHook := ""
+	if hook.Installed {
+		activeHook = hook.ScriptPath
+	}
+	return parseLeftovers(output, activeHook, includeDumps), nil
+}
+
+// RemoveLeftovers deletes the given files and then any empty qnap-vm directories,
+// returning everything that was removed
+func (m *Manager) RemoveLeftovers(poolPaths []string, items []CleanupItem) ([]CleanupItem, error) {
+	var removed, files []CleanupItem
+	for _, item := range items {
+		if item.Kind != LeftoverEmptyDir {
+			files = append(files, item)
+		}
+	}
+
+	for _, item := range files {
+		if output, err := m.sshClient.Execute(fmt.Sprintf("rm -f %s", ssh.Quote(item.Path))); err != nil {
+			return removed, fmt.Errorf("failed to remove %s: %w\nOutput: %s", item.Path, err, output)
+		}
+		removed = append(removed, item)
+	}
+
+	output, err := m.sshClient.Execute(fmt.Sprintf(removeEmptyDirsScript, quoteAll(poolPaths)))
+	if err != nil {
+		return removed, fmt.Errorf("failed to remove empty directories: %w\nOutput: %s", err, output)
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if dir := strings.TrimSpace(line); dir != "" {
+			removed = append(removed, CleanupItem{Kind: LeftoverEmptyDir, Path: dir})
+		}
+	}
+
+	return removed, nil
+}
+
+// parseLeftovers parses the leftover scan output, skipping the active hook script and,
+// unless includeDumps is set, staged dumps
+func parseLeftovers(output, activeHook string, includeDumps bool) []CleanupItem {
+	var items []CleanupItem
+	seen := make(map[string]bool)
+
+	for _, line := range strings.Split(output, "\n") {
+		kind, itemPath, found := strings.Cut(strings.TrimSpace(line), "\t")
+		if !found || itemPath == "" || seen[itemPath] {
+			continue
+		}
+		if kind == LeftoverHook && itemPath == activeHook {
+			continue
+		}
+		if kind == LeftoverDump && !includeDumps {
+			continue
+		}
+		// The active hook's state file directory is still in use
+		if kind == LeftoverPartial && activeHook != "" && strings.HasPrefix(itemPath, stateFileForScript(activeHook)) {
+			continue
+		}
+
+		seen[itemPath] = true
+		items = append(items, CleanupItem{Kind: kind, Path: itemPath})
+	}
+
+	return items
+}
+
+// quoteAll shell-quotes each value and joins them with spaces
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = ssh.Quote(v)
+	}
+	return strings.Join(quoted, " ")
+}
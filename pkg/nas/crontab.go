@@ -0,0 +1,82 @@
+// Package nas provides host-side operations on the QNAP device such as hooks and scheduled jobs.
+package nas
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// crontabPath is the persistent crontab on QTS; /var/spool/cron is rebuilt from it at boot
+const crontabPath = "/etc/config/crontab"
+
+// Manager handles host-side artifacts installed by qnap-vm on the QNAP device
+type Manager struct {
+	sshClient *ssh.Client
+}
+
+// NewManager creates a new NAS manager
+func NewManager(sshClient *ssh.Client) *Manager {
+	return &Manager{
+		sshClient: sshClient,
+	}
+}
+
+// CrontabEntries returns the crontab lines tagged with marker, without the marker comment
+func (m *Manager) CrontabEntries(marker string) ([]string, error) {
+	content, err := m.sshClient.Execute(fmt.Sprintf("cat %s", crontabPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read crontab: %w", err)
+	}
+
+	var entries []string
+	suffix := " # " + marker
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasSuffix(line, suffix) {
+			entries = append(entries, strings.TrimSuffix(line, suffix))
+		}
+	}
+
+	return entries, nil
+}
+
+// SetCrontabEntries replaces all crontab lines tagged with marker and reloads cron
+func (m *Manager) SetCrontabEntries(marker string, entries []string) error {
+	content, err := m.sshClient.Execute(fmt.Sprintf("cat %s", crontabPath))
+	if err != nil {
+		return fmt.Errorf("failed to read crontab: %w", err)
+	}
+
+	updated := updateCrontab(content, marker, entries)
+	writeCmd := fmt.Sprintf("cat > %s << 'EOF'\n%sEOF\ncrontab %s && /etc/init.d/crond.sh restart >/dev/null 2>&1",
+		crontabPath, updated, crontabPath)
+
+	if output, err := m.sshClient.Execute(writeCmd); err != nil {
+		return fmt.Errorf("failed to update crontab: %w\nOutput: %s", err, output)
+	}
+
+	return nil
+}
+
+// updateCrontab replaces the lines tagged with marker in content with entries
+func updateCrontab(content, marker string, entries []string) string {
+	suffix := " # " + marker
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+		if line == "" || strings.HasSuffix(line, suffix) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	for _, entry := range entries {
+		lines = append(lines, entry+suffix)
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
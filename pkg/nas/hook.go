@@ -0,0 +1,210 @@
+package nas
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// PreUpdateMarker tags the crontab entry of the pre-update hook
+const PreUpdateMarker = "qnap-vm pre-update hook"
+
+// Protection modes for the pre-update hook
+const (
+	ModeSnapshot    = "snapshot"
+	ModeManagedSave = "managedsave"
+)
+
+// preUpdateSchedule checks for pending updates every five minutes
+const preUpdateSchedule = "*/5 * * * *"
+
+// VMState records a VM's state captured by the pre-update hook
+type VMState struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Action   string `json:"action"`
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// HookStatus describes the installed pre-update hook and its last recorded state
+type HookStatus struct {
+	Installed  bool      `json:"installed"`
+	ScriptPath string    `json:"script_path,omitempty"`
+	StateFile  string    `json:"state_file,omitempty"`
+	RecordedAt time.Time `json:"recorded_at,omitempty"`
+	States     []VMState `json:"states,omitempty"`
+}
+
+// preUpdateScript is the hook run from cron on the QNAP device
+const preUpdateScript = `#!/bin/sh
+# qnap-vm pre-update hook: protects running VMs before firmware or Virtualization Station upgrades
+export LD_LIBRARY_PATH={{QVS}}/usr/lib:{{QVS}}/usr/lib64/
+export PATH=$PATH:{{QVS}}/usr/bin/:{{QVS}}/usr/sbin/
+STATE_FILE='{{STATE}}'
+MODE='{{MODE}}'
+
+update_pending() {
+	# Firmware images staged by the QTS updater
+	for d in /mnt/HDA_ROOT/update /mnt/update; do
+		ls "$d"/*.img >/dev/null 2>&1 && return 0
+	done
+	# Firmware flash or Virtualization Station package upgrade in progress
+	ps | grep -v grep | grep -qE 'update_img|fw_update|qpkg_cli.*QKVM' && return 0
+	return 1
+}
+
+update_pending || exit 0
+
+# Only protect once per update window
+if [ -f "$STATE_FILE" ] && [ -n "$(find "$STATE_FILE" -mmin -720 2>/dev/null)" ]; then
+	exit 0
+fi
+
+mkdir -p "$(dirname "$STATE_FILE")"
+TS=$(date +%Y%m%d-%H%M%S)
+TMP="$STATE_FILE.tmp"
+echo "# recorded $(date -u +%Y-%m-%dT%H:%M:%SZ)" > "$TMP"
+
+virsh list --name | while read -r vm; do
+	[ -z "$vm" ] && continue
+	if [ "$MODE" = "managedsave" ]; then
+		virsh managedsave "$vm" >/dev/null 2>&1 && printf '%s\trunning\tmanagedsave\t\n' "$vm" >> "$TMP"
+	else
+		snap="qnap-vm-preupdate-$TS"
+		virsh snapshot-create-as "$vm" "$snap" --description "Automatic snapshot before firmware update" >/dev/null 2>&1 &&
+			printf '%s\trunning\tsnapshot\t%s\n' "$vm" "$snap" >> "$TMP"
+	fi
+done
+
+mv "$TMP" "$STATE_FILE"
+`
+
+// HookDir returns the directory holding qnap-vm hooks under a pool path
+func HookDir(poolPath string) string {
+	return fmt.Sprintf("%s/.qnap-vm/hooks", poolPath)
+}
+
+// stateFileForScript derives the state file location from the hook script path
+func stateFileForScript(scriptPath string) string {
+	return path.Join(path.Dir(path.Dir(scriptPath)), "state", "pre-update.tsv")
+}
+
+// RenderPreUpdateScript renders the hook script for the given QVS path, state file and mode
+func RenderPreUpdateScript(qvsPath, stateFile, mode string) string {
+	return strings.NewReplacer(
+		"{{QVS}}", qvsPath,
+		"{{STATE}}", stateFile,
+		"{{MODE}}", mode,
+	).Replace(preUpdateScript)
+}
+
+// InstallPreUpdateHook installs the pre-update hook under poolPath and schedules it in cron
+func (m *Manager) InstallPreUpdateHook(qvsPath, poolPath, mode string) (*HookStatus, error) {
+	if mode != ModeSnapshot && mode != ModeManagedSave {
+		return nil, fmt.Errorf("invalid hook mode: %s (use %s or %s)", mode, ModeSnapshot, ModeManagedSave)
+	}
+
+	scriptPath := path.Join(HookDir(poolPath), "pre-update.sh")
+	stateFile := stateFileForScript(scriptPath)
+	script := RenderPreUpdateScript(qvsPath, stateFile, mode)
+
+	installCmd := fmt.Sprintf("mkdir -p %s && cat > %s << 'EOF'\n%sEOF\nchmod 755 %s",
+		ssh.Quote(HookDir(poolPath)), ssh.Quote(scriptPath), script, ssh.Quote(scriptPath))
+	if output, err := m.sshClient.Execute(installCmd); err != nil {
+		return nil, fmt.Errorf("failed to install hook script: %w\nOutput: %s", err, output)
+	}
+
+	entry := fmt.Sprintf("%s %s >/dev/null 2>&1", preUpdateSchedule, scriptPath)
+	if err := m.SetCrontabEntries(PreUpdateMarker, []string{entry}); err != nil {
+		return nil, err
+	}
+
+	return &HookStatus{Installed: true, ScriptPath: scriptPath, StateFile: stateFile}, nil
+}
+
+// UninstallPreUpdateHook removes the cron entry and hook script
+func (m *Manager) UninstallPreUpdateHook() error {
+	status, err := m.PreUpdateHookStatus()
+	if err != nil {
+		return err
+	}
+
+	if err := m.SetCrontabEntries(PreUpdateMarker, nil); err != nil {
+		return err
+	}
+
+	if status.ScriptPath != "" {
+		if _, err := m.sshClient.Execute(fmt.Sprintf("rm -f %s", ssh.Quote(status.ScriptPath))); err != nil {
+			return fmt.Errorf("failed to remove hook script: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// PreUpdateHookStatus reports whether the hook is installed and the last state it recorded
+func (m *Manager) PreUpdateHookStatus() (*HookStatus, error) {
+	entries, err := m.CrontabEntries(PreUpdateMarker)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &HookStatus{}
+	if len(entries) == 0 {
+		return status, nil
+	}
+
+	fields := strings.Fields(entries[0])
+	if len(fields) < 6 {
+		return nil, fmt.Errorf("malformed pre-update hook crontab entry: %s", entries[0])
+	}
+	status.Installed = true
+	status.ScriptPath = fields[5]
+	status.StateFile = stateFileForScript(status.ScriptPath)
+
+	content, err := m.sshClient.Execute(fmt.Sprintf("cat %s 2>/dev/null", ssh.Quote(status.StateFile)))
+	if err == nil {
+		status.States, status.RecordedAt = ParseState(content)
+	}
+
+	return status, nil
+}
+
+// ClearPreUpdateState removes the recorded state so the hook can protect the next update
+func (m *Manager) ClearPreUpdateState(stateFile string) error {
+	if _, err := m.sshClient.Execute(fmt.Sprintf("rm -f %s", ssh.Quote(stateFile))); err != nil {
+		return fmt.Errorf("failed to clear pre-update state: %w", err)
+	}
+	return nil
+}
+
+// ParseState parses the tab-separated state file written by the pre-update hook
+func ParseState(content string) ([]VMState, time.Time) {
+	var states []VMState
+	var recordedAt time.Time
+
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, "# recorded ") {
+			if t, err := time.Parse(time.RFC3339, strings.TrimPrefix(line, "# recorded ")); err == nil {
+				recordedAt = t
+			}
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 || fields[0] == "" {
+			continue
+		}
+
+		state := VMState{Name: fields[0], State: fields[1], Action: fields[2]}
+		if len(fields) > 3 {
+			state.Snapshot = fields[3]
+		}
+		states = append(states, state)
+	}
+
+	return states, recordedAt
+}
@@ -0,0 +1,160 @@
+package nas
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Operating systems reported in HostInfo
+const (
+	OSQTS      = "QTS"
+	OSQuTSHero = "QuTS hero"
+)
+
+// infoScript prints "key value" lines describing the NAS, its CPU and memory, the KVM
+// support of its kernel and the Virtualization Station installation
+const infoScript = `echo "model $(getsysinfo model 2>/dev/null)"
+echo "hostname $(hostname 2>/dev/null)"
+echo "version $(getcfg System Version -f /etc/config/uLinux.conf 2>/dev/null)"
+echo "build $(getcfg System 'Build Number' -f /etc/config/uLinux.conf 2>/dev/null)"
+command -v zpool >/dev/null 2>&1 && echo "zfs yes"
+echo "kernel $(uname -r)"
+echo "arch $(uname -m)"
+echo "cpu_model $(grep -m 1 'model name' /proc/cpuinfo | cut -d: -f2-)"
+echo "cpus $(grep -c ^processor /proc/cpuinfo)"
+echo "cores $(awk -F': ' '/^physical id/{p=$2} /^core id/{print p "-" $2}' /proc/cpuinfo | sort -u | wc -l)"
+echo "cpu_virt $(grep -o -m 1 -w 'vmx\|svm' /proc/cpuinfo | head -n 1)"
+awk '/^MemTotal:/{print "mem_total", $2} /^MemAvailable:/{print "mem_available", $2}' /proc/meminfo
+[ -c /dev/kvm ] && echo "dev_kvm yes"
+[ -c /dev/vhost-net ] && echo "vhost_net yes"
+for m in kvm_intel kvm_amd; do
+	[ -d /sys/module/$m ] || continue
+	echo "kvm_module $m"
+	[ -r /sys/module/$m/parameters/nested ] && echo "nested $(cat /sys/module/$m/parameters/nested)"
+done
+[ -n "$(ls /sys/kernel/iommu_groups 2>/dev/null)" ] && echo "iommu yes"
+echo "qvs_version $(getcfg QKVM Version -f /etc/config/qpkg.conf 2>/dev/null)"
+echo "qvs_enabled $(getcfg QKVM Enable -f /etc/config/qpkg.conf 2>/dev/null)"
+for d in /QVS /KVM; do
+	[ -d $d ] || continue
+	echo "qvs_path $d"
+	echo "qemu $($d/usr/bin/qemu-system-x86_64 --version 2>/dev/null | head -n 1)"
+	echo "libvirt $(LD_LIBRARY_PATH=$d/usr/lib:$d/usr/lib64 $d/usr/bin/virsh version 2>/dev/null | awk '/Using library/{print $NF}')"
+	break
+done
+true`
+
+// HostInfo describes the NAS as far as sizing and running VMs is concerned
+type HostInfo struct {
+	Model    string `json:"model,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	OS       string `json:"os"` // OSQTS or OSQuTSHero
+	Version  string `json:"version,omitempty"`
+	Build    string `json:"build,omitempty"`
+	Kernel   string `json:"kernel"`
+	Arch     string `json:"arch"`
+
+	CPUModel string `json:"cpu_model,omitempty"`
+	CPUs     int    `json:"cpus"`  // Logical processors
+	Cores    int    `json:"cores"` // Physical cores; equals CPUs when the kernel does not say
+	// CPUVirt is the CPU's virtualization extension, vmx (Intel VT-x) or svm (AMD-V);
+	// empty when the CPU has none or the BIOS disables it
+	CPUVirt string `json:"cpu_virt,omitempty"`
+
+	MemTotalKB     int64 `json:"mem_total_kb"`
+	MemAvailableKB int64 `json:"mem_available_kb"`
+
+	DevKVM     bool   `json:"dev_kvm"`              // /dev/kvm exists
+	KVMModule  string `json:"kvm_module,omitempty"` // kvm_intel or kvm_amd, when loaded
+	Nested     bool   `json:"nested"`               // The KVM module allows nested virtualization
+	VhostNet   bool   `json:"vhost_net"`            // /dev/vhost-net exists, for in-kernel virtio networking
+	IOMMU      bool   `json:"iommu"`                // IOMMU groups exist, for PCI passthrough
+	QVSVersion string `json:"qvs_version,omitempty"`
+	QVSEnabled bool   `json:"qvs_enabled"`
+	QVSPath    string `json:"qvs_path,omitempty"`
+	QEMU       string `json:"qemu,omitempty"`
+	Libvirt    string `json:"libvirt,omitempty"`
+}
+
+// KVMAvailable reports whether the NAS can run hardware-accelerated VMs
+func (h *HostInfo) KVMAvailable() bool {
+	return h.DevKVM && h.KVMModule != ""
+}
+
+// HostInfo reads the NAS's model, firmware, CPU, memory and virtualization support.
+// It needs no working Virtualization Station, so it also explains why one is missing.
+func (m *Manager) HostInfo() (*HostInfo, error) {
+	output, err := m.sshClient.Execute(infoScript)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host information: %w\nOutput: %s", err, output)
+	}
+	return parseHostInfo(output), nil
+}
+
+// parseHostInfo parses the output of infoScript
+func parseHostInfo(output string) *HostInfo {
+	info := &HostInfo{OS: OSQTS}
+	zfs := false
+	for _, line := range strings.Split(output, "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), " ")
+		value = strings.TrimSpace(value)
+		switch key {
+		case "model":
+			info.Model = value
+		case "hostname":
+			info.Hostname = value
+		case "version":
+			info.Version = value
+		case "build":
+			info.Build = value
+		case "zfs":
+			zfs = true
+		case "kernel":
+			info.Kernel = value
+		case "arch":
+			info.Arch = value
+		case "cpu_model":
+			info.CPUModel = strings.Join(strings.Fields(value), " ")
+		case "cpus":
+			info.CPUs, _ = strconv.Atoi(value)
+		case "cores":
+			info.Cores, _ = strconv.Atoi(value)
+		case "cpu_virt":
+			info.CPUVirt = value
+		case "mem_total":
+			info.MemTotalKB, _ = strconv.ParseInt(value, 10, 64)
+		case "mem_available":
+			info.MemAvailableKB, _ = strconv.ParseInt(value, 10, 64)
+		case "dev_kvm":
+			info.DevKVM = true
+		case "vhost_net":
+			info.VhostNet = true
+		case "kvm_module":
+			info.KVMModule = value
+		case "nested":
+			info.Nested = value == "1" || value == "Y"
+		case "iommu":
+			info.IOMMU = true
+		case "qvs_version":
+			info.QVSVersion = value
+		case "qvs_enabled":
+			info.QVSEnabled = strings.EqualFold(value, "TRUE")
+		case "qvs_path":
+			info.QVSPath = value
+		case "qemu":
+			info.QEMU = value
+		case "libvirt":
+			info.Libvirt = value
+		}
+	}
+
+	// QuTS hero versions carry an "h" prefix, e.g. h5.1.2; ZFS tools confirm older ones
+	if strings.HasPrefix(info.Version, "h") || zfs {
+		info.OS = OSQuTSHero
+	}
+	if info.Cores == 0 {
+		info.Cores = info.CPUs
+	}
+	return info
+}
@@ -0,0 +1,49 @@
+package nas
+
+import "testing"
+
+func TestParseHostInfo(t *testing.T) {
+	output := `model TS-873A
+hostname office
+version h5.1.2
+build 20230926
+kernel 5.10.60-qnap
+arch x86_64
+cpu_model  AMD Ryzen  Embedded V1500B
+cpus 8
+cores 4
+cpu_virt svm
+mem_total 32768000
+mem_available 20480000
+dev_kvm yes
+vhost_net yes
+kvm_module kvm_amd
+nested 1
+qvs_version 4.1.0.3161
+qvs_enabled TRUE
+qvs_path /QVS
+qemu QEMU emulator version 6.2.0
+libvirt 8.0.0
+`
+	info := parseHostInfo(output)
+	if info.Model != "TS-873A" || info.OS != OSQuTSHero || info.Version != "h5.1.2" || info.Kernel != "5.10.60-qnap" {
+		t.Errorf("system = %+v", info)
+	}
+	if info.CPUModel != "AMD Ryzen Embedded V1500B" || info.CPUs != 8 || info.Cores != 4 || info.CPUVirt != "svm" {
+		t.Errorf("CPU = %q %d/%d %q", info.CPUModel, info.CPUs, info.Cores, info.CPUVirt)
+	}
+	if info.MemTotalKB != 32768000 || info.MemAvailableKB != 20480000 {
+		t.Errorf("memory = %d/%d", info.MemTotalKB, info.MemAvailableKB)
+	}
+	if !info.KVMAvailable() || !info.Nested || !info.VhostNet || info.IOMMU {
+		t.Errorf("KVM = %+v", info)
+	}
+	if !info.QVSEnabled || info.QVSPath != "/QVS" || info.QEMU != "QEMU emulator version 6.2.0" || info.Libvirt != "8.0.0" {
+		t.Errorf("QVS = %+v", info)
+	}
+
+	info = parseHostInfo("version 5.1.0\ncpus 4\ncores 0\nkvm_module kvm_intel\nnested N\nqvs_enabled FALSE\n")
+	if info.OS != OSQTS || info.Cores != 4 || info.KVMAvailable() || info.Nested || info.QVSEnabled {
+		t.Errorf("QTS host without /dev/kvm = %+v", info)
+	}
+}
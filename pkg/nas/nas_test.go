@@ -0,0 +1,164 @@
+package nas
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateCrontab(t *testing.T) {
+	existing := `0 4 * * * /sbin/hwclock -s
+*/5 * * * * /old/hook.sh # qnap-vm pre-update hook
+30 2 * * * /usr/bin/power_clean -c 2>/dev/null
+`
+
+	updated := updateCrontab(existing, PreUpdateMarker, []string{"*/5 * * * * /new/hook.sh"})
+	if strings.Contains(updated, "/old/hook.sh") {
+		t.Error("Expected old hook entry to be replaced")
+	}
+	if !strings.Contains(updated, "*/5 * * * * /new/hook.sh # qnap-vm pre-update hook\n") {
+		t.Errorf("Expected new hook entry, got:\n%s", updated)
+	}
+	if !strings.Contains(updated, "/sbin/hwclock -s") || !strings.Contains(updated, "power_clean") {
+		t.Error("Expected unrelated entries to be preserved")
+	}
+
+	removed := updateCrontab(updated, PreUpdateMarker, nil)
+	if strings.Contains(removed, PreUpdateMarker) {
+		t.Errorf("Expected hook entry to be removed, got:\n%s", removed)
+	}
+}
+
+func TestParseState(t *testing.T) {
+	content := "# recorded 2026-10-10T03:15:00Z\n" +
+		"web\trunning\tsnapshot\tpre-update-20261010031500\n" +
+		"db\tshut off\tnone\t\n" +
+		"\n"
+
+	states, recordedAt := ParseState(content)
+	if recordedAt.IsZero() || recordedAt.Year() != 2026 {
+		t.Errorf("Unexpected recorded time: %v", recordedAt)
+	}
+	if len(states) != 2 {
+		t.Fatalf("Expected 2 states, got %d", len(states))
+	}
+	if states[0].Name != "web" || states[0].State != "running" || states[0].Snapshot != "pre-update-20261010031500" {
+		t.Errorf("Unexpected first state: %+v", states[0])
+	}
+	if states[1].Name != "db" || states[1].Action != "none" || states[1].Snapshot != "" {
+		t.Errorf("Unexpected second state: %+v", states[1])
+	}
+}
+
+func TestRenderPreUpdateScript(t *testing.T) {
+	script := RenderPreUpdateScript("/QVS/usr", "/share/Pool/.qnap-vm/state/pre-update.tsv", ModeManagedSave)
+	if strings.Contains(script, "{{") {
+		t.Error("Expected all placeholders to be replaced")
+	}
+	for _, want := range []string{"/QVS/usr", "/share/Pool/.qnap-vm/state/pre-update.tsv", ModeManagedSave} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected script to contain %q", want)
+		}
+	}
+}
+
+func TestStateFileForScript(t *testing.T) {
+	got := stateFileForScript("/share/Pool/.qnap-vm/hooks/pre-update.sh")
+	if got != "/share/Pool/.qnap-vm/state/pre-update.tsv" {
+		t.Errorf("Unexpected state file: %s", got)
+	}
+}
+
+func TestParseLeftovers(t *testing.T) {
+	output := "partial\t/share/CACHEDEV1_DATA/.qnap-vm/isos/debian.iso.part\n" +
+		"partial\t/share/CACHEDEV1_DATA/.qnap-vm/state/pre-update.tsv.tmp\n" +
+		"hook\t/share/CACHEDEV1_DATA/.qnap-vm/hooks/pre-update.sh\n" +
+		"hook\t/share/CACHEDEV2_DATA/.qnap-vm/hooks/pre-update.sh\n" +
+		"dump\t/share/CACHEDEV1_DATA/.qnap-vm/dumps/web.core\n" +
+		"emptydir\t/share/CACHEDEV2_DATA/.qnap-vm/isos\n" +
+		"tempxml\t/tmp/qnap-vm-web.xml\n" +
+		"tempxml\t/tmp/qnap-vm-web.xml\n"
+
+	items := parseLeftovers(output, "/share/CACHEDEV1_DATA/.qnap-vm/hooks/pre-update.sh", false)
+
+	var paths []string
+	for _, item := range items {
+		paths = append(paths, item.Path)
+	}
+	expected := []string{
+		"/share/CACHEDEV1_DATA/.qnap-vm/isos/debian.iso.part",
+		"/share/CACHEDEV2_DATA/.qnap-vm/hooks/pre-update.sh",
+		"/share/CACHEDEV2_DATA/.qnap-vm/isos",
+		"/tmp/qnap-vm-web.xml",
+	}
+	if strings.Join(paths, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected leftovers:\n%s", strings.Join(paths, "\n"))
+	}
+
+	withDumps := parseLeftovers(output, "", true)
+	foundDump := false
+	for _, item := range withDumps {
+		if item.Kind == LeftoverDump {
+			foundDump = true
+		}
+	}
+	if !foundDump {
+		t.Error("Expected dumps to be included")
+	}
+}
+
+func TestParsePowerSample(t *testing.T) {
+	output := `stat 1500 10000
+rapl 2000000
+rapl 3000000
+hwmon 12500000
+cputemp 45 C/113 F
+systemp 38 C/100 F
+`
+	sample := parsePowerSample(output)
+	if sample.BusyTicks != 1500 || sample.TotalTicks != 10000 {
+		t.Errorf("ticks = %d/%d, want 1500/10000", sample.BusyTicks, sample.TotalTicks)
+	}
+	if sample.EnergyUJ != 5000000 {
+		t.Errorf("EnergyUJ = %d, want 5000000", sample.EnergyUJ)
+	}
+	if sample.HwmonWatts != 12.5 {
+		t.Errorf("HwmonWatts = %v, want 12.5", sample.HwmonWatts)
+	}
+	if sample.CPUTemp != 45 || sample.SysTemp != 38 {
+		t.Errorf("temperatures = %v/%v, want 45/38", sample.CPUTemp, sample.SysTemp)
+	}
+
+	if empty := parsePowerSample("stat 1 2\n"); empty.EnergyUJ != -1 {
+		t.Errorf("EnergyUJ without RAPL = %d, want -1", empty.EnergyUJ)
+	}
+}
+
+func TestComparePowerSamples(t *testing.T) {
+	start := time.Now()
+	before := &PowerSample{Time: start, BusyTicks: 1000, TotalTicks: 10000, EnergyUJ: 1000000}
+	after := &PowerSample{Time: start.Add(10 * time.Second), BusyTicks: 1500, TotalTicks: 14000, EnergyUJ: 251000000, HwmonWatts: 99}
+
+	reading := ComparePowerSamples(before, after)
+	if reading.Source != PowerSourceRAPL || math.Abs(reading.Watts-25) > 0.001 {
+		t.Errorf("reading = %.3f W from %q, want 25 W from rapl", reading.Watts, reading.Source)
+	}
+	if math.Abs(reading.CPUBusy-12.5) > 0.001 {
+		t.Errorf("CPUBusy = %v, want 12.5", reading.CPUBusy)
+	}
+	if reading.BusyNs != 500*clockTicksNs {
+		t.Errorf("BusyNs = %d, want %d", reading.BusyNs, 500*clockTicksNs)
+	}
+
+	// A VM using half of the busy CPU time is charged half the power
+	if watts := reading.AttributeWatts(250 * clockTicksNs); math.Abs(watts-12.5) > 0.001 {
+		t.Errorf("AttributeWatts() = %v, want 12.5", watts)
+	}
+
+	// Wrapped RAPL counters fall back to hwmon
+	after.EnergyUJ = 10
+	if reading := ComparePowerSamples(before, after); reading.Source != PowerSourceHwmon || reading.Watts != 99 {
+		t.Errorf("reading = %v W from %q, want 99 W from hwmon", reading.Watts, reading.Source)
+	}
+}
@@ -0,0 +1,111 @@
+package nas
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// bridgeScript prints "bridge NAME|STATE" per Linux bridge, "port BRIDGE NAME KIND" per
+// bridge member (KIND is physical for NICs, virtual for taps and bonds) and the NAS's
+// IPv4 addresses as 'ip -o -4 addr' lines prefixed with "addr"
+const bridgeScript = `for b in /sys/class/net/*; do
+	[ -d "$b/bridge" ] || continue
+	name=$(basename "$b")
+	echo "bridge $name|$(cat "$b/operstate" 2>/dev/null)"
+	for p in "$b"/brif/*; do
+		[ -e "$p" ] || continue
+		port=$(basename "$p")
+		kind=virtual; [ -e "/sys/class/net/$port/device" ] && kind=physical
+		echo "port $name $port $kind"
+	done
+done
+ip -o -4 addr show 2>/dev/null | sed 's/^/addr /'
+true`
+
+// VirtualSwitchPrefix starts the names of the bridges QNAP's Network & Virtual Switch
+// app creates
+const VirtualSwitchPrefix = "qvs"
+
+// Bridge is a Linux bridge on the NAS that VMs can be connected to
+type Bridge struct {
+	Name      string   `json:"name"`
+	State     string   `json:"state"`
+	Uplinks   []string `json:"uplinks,omitempty"`   // Physical NICs in the bridge
+	Ports     []string `json:"ports,omitempty"`     // Other members, such as VM tap devices
+	Addresses []string `json:"addresses,omitempty"` // The NAS's addresses on the bridge, in CIDR form
+	Subnets   []string `json:"subnets,omitempty"`
+}
+
+// VirtualSwitch reports whether the bridge is a QNAP virtual switch
+func (b Bridge) VirtualSwitch() bool {
+	return strings.HasPrefix(b.Name, VirtualSwitchPrefix)
+}
+
+// Bridges returns the NAS's bridges, ordered by name
+func (m *Manager) Bridges() ([]Bridge, error) {
+	output, err := m.sshClient.Execute(bridgeScript)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bridges: %w\nOutput: %s", err, output)
+	}
+	return parseBridges(output), nil
+}
+
+// parseBridges parses the output of bridgeScript
+func parseBridges(output string) []Bridge {
+	var bridges []Bridge
+	index := make(map[string]int)
+	var addrLines []string
+	for _, line := range strings.Split(output, "\n") {
+		kind, rest, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		switch kind {
+		case "bridge":
+			name, state, _ := strings.Cut(rest, "|")
+			index[name] = len(bridges)
+			bridges = append(bridges, Bridge{Name: name, State: state})
+		case "port":
+			fields := strings.Fields(rest)
+			if len(fields) != 3 {
+				continue
+			}
+			i, ok := index[fields[0]]
+			if !ok {
+				continue
+			}
+			if fields[2] == "physical" {
+				bridges[i].Uplinks = append(bridges[i].Uplinks, fields[1])
+			} else {
+				bridges[i].Ports = append(bridges[i].Ports, fields[1])
+			}
+		case "addr":
+			addrLines = append(addrLines, rest)
+		}
+	}
+
+	// 'ip -o -4 addr' lines: "5: qvs0    inet 192.168.1.10/24 brd ... scope global qvs0..."
+	for _, line := range addrLines {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[2] != "inet" {
+			continue
+		}
+		i, ok := index[strings.TrimSuffix(fields[1], ":")]
+		if !ok {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(fields[3])
+		if err != nil {
+			continue
+		}
+		bridges[i].Addresses = append(bridges[i].Addresses, fields[3])
+		bridges[i].Subnets = append(bridges[i].Subnets, subnet.String())
+	}
+
+	sort.Slice(bridges, func(i, j int) bool {
+		return bridges[i].Name < bridges[j].Name
+	})
+	return bridges
+}
@@ -0,0 +1,39 @@
+package nas
+
+import (
+	"reflect"
+	"testing"
+)
+
+const bridgeOutput = `bridge qvs0|up
+port qvs0 eth0 physical
+port qvs0 vnet0 virtual
+bridge lxcbr0|down
+bridge docker0|up
+port docker0 veth1a2b virtual
+addr 1: lo    inet 127.0.0.1/8 scope host lo\       valid_lft forever preferred_lft forever
+addr 5: qvs0    inet 192.168.1.10/24 brd 192.168.1.255 scope global qvs0\       valid_lft forever preferred_lft forever
+addr 7: docker0    inet 172.17.0.1/16 brd 172.17.255.255 scope global docker0\       valid_lft forever preferred_lft forever
+`
+
+func TestParseBridges(t *testing.T) {
+	bridges := parseBridges(bridgeOutput)
+	if len(bridges) != 3 {
+		t.Fatalf("parseBridges = %+v", bridges)
+	}
+	if bridges[0].Name != "docker0" || bridges[1].Name != "lxcbr0" || bridges[2].Name != "qvs0" {
+		t.Errorf("bridges not ordered by name: %+v", bridges)
+	}
+
+	want := Bridge{Name: "qvs0", State: "up", Uplinks: []string{"eth0"}, Ports: []string{"vnet0"},
+		Addresses: []string{"192.168.1.10/24"}, Subnets: []string{"192.168.1.0/24"}}
+	if !reflect.DeepEqual(bridges[2], want) {
+		t.Errorf("qvs0 = %+v, want %+v", bridges[2], want)
+	}
+	if !bridges[2].VirtualSwitch() || bridges[0].VirtualSwitch() {
+		t.Error("only qvs0 should be a virtual switch")
+	}
+	if bridges[1].Uplinks != nil || bridges[1].Subnets != nil {
+		t.Errorf("lxcbr0 = %+v", bridges[1])
+	}
+}
@@ -0,0 +1,210 @@
+package nas
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VFIODriver is the host driver a PCI device must be bound to before a VM can use it;
+// libvirt binds it when the VM starts
+const VFIODriver = "vfio-pci"
+
+// pciScript prints "dev ADDRESS|CLASS|VENDOR|DEVICE|IOMMU GROUP|DRIVER" per PCI device,
+// "name ADDRESS DESCRIPTION" lines when lspci is installed and "vfio yes" when the
+// vfio-pci driver is loaded or can be
+const pciScript = `for d in /sys/bus/pci/devices/*; do
+	[ -e "$d/class" ] || continue
+	group=""; [ -e "$d/iommu_group" ] && group=$(basename "$(readlink "$d/iommu_group")")
+	driver=""; [ -e "$d/driver" ] && driver=$(basename "$(readlink "$d/driver")")
+	echo "dev $(basename "$d")|$(cat "$d/class")|$(cat "$d/vendor")|$(cat "$d/device")|$group|$driver"
+done
+if command -v lspci >/dev/null 2>&1; then
+	lspci -D 2>/dev/null | sed 's/^/name /'
+fi
+if [ -d /sys/bus/pci/drivers/vfio-pci ] || modinfo vfio-pci >/dev/null 2>&1; then
+	echo "vfio yes"
+fi
+true`
+
+// PCIDevice is a PCI device of the NAS
+type PCIDevice struct {
+	Address     string `json:"address"` // Domain:bus:slot.function, e.g. 0000:01:00.0
+	Class       uint32 `json:"class"`
+	Vendor      string `json:"vendor"`
+	Device      string `json:"device"`
+	IOMMUGroup  int    `json:"iommu_group"` // -1 when the IOMMU is off
+	Driver      string `json:"driver,omitempty"`
+	Description string `json:"description,omitempty"` // From lspci, when installed
+}
+
+// ClassName returns a short name for the device's PCI class
+func (d PCIDevice) ClassName() string {
+	switch d.Class >> 16 {
+	case 0x01:
+		return "storage"
+	case 0x02:
+		return "network"
+	case 0x03:
+		return "display"
+	case 0x04:
+		return "multimedia"
+	case 0x06:
+		return "bridge"
+	case 0x0c:
+		if d.Class>>8 == 0x0c03 {
+			return "usb"
+		}
+		return "serial-bus"
+	case 0x12:
+		return "accelerator"
+	default:
+		return fmt.Sprintf("class-%02x", d.Class>>16)
+	}
+}
+
+// isBridge reports whether the device is a PCI bridge, which stays with the host when
+// the devices behind it are passed through
+func (d PCIDevice) isBridge() bool {
+	return d.Class>>16 == 0x06
+}
+
+// PCIInventory is the NAS's PCI devices and passthrough support
+type PCIInventory struct {
+	Devices []PCIDevice
+	VFIO    bool // The vfio-pci driver is available
+}
+
+// IOMMU reports whether the IOMMU is enabled, i.e. devices have IOMMU groups
+func (p *PCIInventory) IOMMU() bool {
+	for _, device := range p.Devices {
+		if device.IOMMUGroup >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Device returns the device at a full address such as 0000:01:00.0
+func (p *PCIInventory) Device(address string) *PCIDevice {
+	for i := range p.Devices {
+		if p.Devices[i].Address == address {
+			return &p.Devices[i]
+		}
+	}
+	return nil
+}
+
+// Group returns the devices in an IOMMU group
+func (p *PCIInventory) Group(group int) []PCIDevice {
+	var devices []PCIDevice
+	for _, device := range p.Devices {
+		if device.IOMMUGroup == group {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// CheckPassthrough checks the devices at addresses can be passed through to one VM
+// together. Every non-bridge device sharing an IOMMU group with one of them must be
+// passed through too or already be bound to vfio-pci, or the VM will not start.
+func (p *PCIInventory) CheckPassthrough(addresses []string) error {
+	if len(addresses) == 0 {
+		return nil
+	}
+	if !p.IOMMU() {
+		return fmt.Errorf("the IOMMU is disabled on the NAS (no IOMMU groups); enable VT-d/AMD-Vi in the BIOS")
+	}
+	if !p.VFIO {
+		return fmt.Errorf("the NAS kernel has no %s driver for PCI passthrough", VFIODriver)
+	}
+
+	passed := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		device := p.Device(address)
+		if device == nil {
+			return fmt.Errorf("no PCI device %s on the NAS (see 'qnap-vm pci list')", address)
+		}
+		if device.isBridge() {
+			return fmt.Errorf("PCI device %s is a bridge and cannot be passed through", device.Address)
+		}
+		passed[device.Address] = true
+	}
+
+	for _, address := range addresses {
+		device := p.Device(address)
+		for _, member := range p.Group(device.IOMMUGroup) {
+			if passed[member.Address] || member.isBridge() || member.Driver == VFIODriver {
+				continue
+			}
+			return fmt.Errorf("PCI device %s shares IOMMU group %d with %s (driver %s); pass both through or bind %s to %s", device.Address, device.IOMMUGroup, member.Address, driverName(member.Driver), member.Address, VFIODriver)
+		}
+	}
+	return nil
+}
+
+// driverName formats a device's host driver
+func driverName(driver string) string {
+	if driver == "" {
+		return "none"
+	}
+	return driver
+}
+
+// PCIDevices returns the NAS's PCI devices, ordered by address
+func (m *Manager) PCIDevices() (*PCIInventory, error) {
+	output, err := m.sshClient.Execute(pciScript)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PCI devices: %w\nOutput: %s", err, output)
+	}
+	return parsePCIDevices(output), nil
+}
+
+// parsePCIDevices parses the output of pciScript
+func parsePCIDevices(output string) *PCIInventory {
+	inventory := &PCIInventory{}
+	names := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		kind, rest, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		switch kind {
+		case "dev":
+			fields := strings.Split(rest, "|")
+			if len(fields) != 6 {
+				continue
+			}
+			class, _ := strconv.ParseUint(strings.TrimPrefix(fields[1], "0x"), 16, 32)
+			group, err := strconv.Atoi(fields[4])
+			if err != nil {
+				group = -1
+			}
+			inventory.Devices = append(inventory.Devices, PCIDevice{
+				Address:    fields[0],
+				Class:      uint32(class),
+				Vendor:     strings.TrimPrefix(fields[2], "0x"),
+				Device:     strings.TrimPrefix(fields[3], "0x"),
+				IOMMUGroup: group,
+				Driver:     fields[5],
+			})
+		case "name":
+			address, description, ok := strings.Cut(rest, " ")
+			if ok {
+				names[address] = description
+			}
+		case "vfio":
+			inventory.VFIO = rest == "yes"
+		}
+	}
+
+	for i := range inventory.Devices {
+		inventory.Devices[i].Description = names[inventory.Devices[i].Address]
+	}
+	sort.Slice(inventory.Devices, func(i, j int) bool {
+		return inventory.Devices[i].Address < inventory.Devices[j].Address
+	})
+	return inventory
+}
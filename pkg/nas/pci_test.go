@@ -0,0 +1,66 @@
+package nas
+
+import "testing"
+
+const pciOutput = `dev 0000:00:02.0|0x030000|0x8086|0x3e92|1|i915
+dev 0000:00:00.0|0x060000|0x8086|0x3ec2|0|
+dev 0000:01:00.0|0x030000|0x10de|0x1c82|2|vfio-pci
+dev 0000:01:00.1|0x040300|0x10de|0x0fb9|2|snd_hda_intel
+dev 0000:00:01.0|0x060400|0x8086|0x1901|2|pcieport
+name 0000:01:00.0 VGA compatible controller: NVIDIA Corporation GP107 [GeForce GTX 1050 Ti]
+vfio yes
+`
+
+func TestParsePCIDevices(t *testing.T) {
+	inventory := parsePCIDevices(pciOutput)
+	if len(inventory.Devices) != 5 || !inventory.VFIO || !inventory.IOMMU() {
+		t.Fatalf("parsePCIDevices = %+v", inventory)
+	}
+	if inventory.Devices[0].Address != "0000:00:00.0" {
+		t.Errorf("devices not ordered by address: %+v", inventory.Devices)
+	}
+
+	gpu := inventory.Device("0000:01:00.0")
+	want := PCIDevice{Address: "0000:01:00.0", Class: 0x030000, Vendor: "10de", Device: "1c82", IOMMUGroup: 2, Driver: "vfio-pci",
+		Description: "VGA compatible controller: NVIDIA Corporation GP107 [GeForce GTX 1050 Ti]"}
+	if gpu == nil || *gpu != want {
+		t.Errorf("Device(0000:01:00.0) = %+v", gpu)
+	}
+	if gpu.ClassName() != "display" || inventory.Device("0000:01:00.1").ClassName() != "multimedia" {
+		t.Errorf("unexpected class names")
+	}
+
+	noIOMMU := parsePCIDevices("dev 0000:00:02.0|0x030000|0x8086|0x3e92||i915\n")
+	if noIOMMU.IOMMU() || noIOMMU.Devices[0].IOMMUGroup != -1 {
+		t.Errorf("parsePCIDevices without IOMMU = %+v", noIOMMU)
+	}
+	if err := noIOMMU.CheckPassthrough([]string{"0000:00:02.0"}); err == nil {
+		t.Error("CheckPassthrough succeeded with the IOMMU off")
+	}
+}
+
+func TestCheckPassthrough(t *testing.T) {
+	inventory := parsePCIDevices(pciOutput)
+	tests := []struct {
+		addresses []string
+		wantErr   bool
+	}{
+		{nil, false},
+		{[]string{"0000:00:02.0"}, false},                 // Alone in its group
+		{[]string{"0000:01:00.0", "0000:01:00.1"}, false}, // Whole group; the bridge stays
+		{[]string{"0000:01:00.0"}, true},                  // Audio function still with the host
+		{[]string{"0000:01:00.1"}, false},                 // The GPU is already bound to vfio-pci
+		{[]string{"0000:00:01.0"}, true},                  // Bridge
+		{[]string{"0000:05:00.0"}, true},                  // No such device
+	}
+	for _, tt := range tests {
+		if err := inventory.CheckPassthrough(tt.addresses); (err != nil) != tt.wantErr {
+			t.Errorf("CheckPassthrough(%v) error = %v, wantErr %v", tt.addresses, err, tt.wantErr)
+		}
+	}
+
+	inventory.VFIO = false
+	if err := inventory.CheckPassthrough([]string{"0000:00:02.0"}); err == nil {
+		t.Error("CheckPassthrough succeeded without vfio-pci")
+	}
+}
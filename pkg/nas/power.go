@@ -0,0 +1,161 @@
+package nas
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Power sources reported in PowerReading
+const (
+	PowerSourceRAPL  = "rapl"  // CPU package energy counters; excludes disks, fans and PSU losses
+	PowerSourceHwmon = "hwmon" // Power sensor exposed by a hardware monitor driver
+)
+
+// clockTicksNs is the length of a /proc/stat tick (USER_HZ=100) in nanoseconds
+const clockTicksNs = 10_000_000
+
+// powerSampleScript prints the counters needed to derive host power and load:
+// "stat <busy ticks> <total ticks>", "rapl <µJ>" per CPU package, "hwmon <µW>" per
+// power sensor and "cputemp"/"systemp" lines from the QTS getsysinfo tool
+const powerSampleScript = `awk '/^cpu /{t=0; for(i=2;i<=NF;i++) t+=$i; print "stat", t-$5-$6, t}' /proc/stat
+for f in /sys/class/powercap/intel-rapl:[0-9]*/energy_uj; do
+	case "$f" in *intel-rapl:*:*) continue ;; esac
+	[ -r "$f" ] && echo "rapl $(cat "$f")"
+done
+for f in /sys/class/hwmon/hwmon*/power*_input /sys/class/hwmon/hwmon*/device/power*_input; do
+	[ -r "$f" ] && echo "hwmon $(cat "$f")"
+done
+if command -v getsysinfo >/dev/null 2>&1; then
+	echo "cputemp $(getsysinfo cputmp 2>/dev/null)"
+	echo "systemp $(getsysinfo systmp 2>/dev/null)"
+fi
+true`
+
+// PowerSample is a snapshot of the host's power and CPU counters
+type PowerSample struct {
+	Time       time.Time
+	BusyTicks  int64   // Non-idle CPU ticks across all CPUs
+	TotalTicks int64   // All CPU ticks across all CPUs
+	EnergyUJ   int64   // Sum of RAPL package energy counters in µJ; -1 if unavailable
+	HwmonWatts float64 // Sum of hwmon power sensors; 0 if unavailable
+	CPUTemp    float64 // °C; 0 if unavailable
+	SysTemp    float64 // °C; 0 if unavailable
+}
+
+// PowerReading is host power and load measured between two samples
+type PowerReading struct {
+	Watts    float64       `json:"watts"`
+	Source   string        `json:"source,omitempty"` // Empty when the NAS exposes no power data
+	CPUBusy  float64       `json:"cpu_busy_percent"`
+	BusyNs   int64         `json:"cpu_busy_ns"` // Host CPU time spent busy during the interval
+	CPUTemp  float64       `json:"cpu_temp_c,omitempty"`
+	SysTemp  float64       `json:"sys_temp_c,omitempty"`
+	Interval time.Duration `json:"interval_ns"`
+}
+
+// SamplePower reads the host's power, temperature and CPU counters
+func (m *Manager) SamplePower() (*PowerSample, error) {
+	output, err := m.sshClient.Execute(powerSampleScript)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read power counters: %w\nOutput: %s", err, output)
+	}
+
+	sample := parsePowerSample(output)
+	sample.Time = time.Now()
+	return sample, nil
+}
+
+// parsePowerSample parses the output of powerSampleScript
+func parsePowerSample(output string) *PowerSample {
+	sample := &PowerSample{EnergyUJ: -1}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "stat":
+			if len(fields) >= 3 {
+				sample.BusyTicks, _ = strconv.ParseInt(fields[1], 10, 64)
+				sample.TotalTicks, _ = strconv.ParseInt(fields[2], 10, 64)
+			}
+		case "rapl":
+			if uj, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				if sample.EnergyUJ < 0 {
+					sample.EnergyUJ = 0
+				}
+				sample.EnergyUJ += uj
+			}
+		case "hwmon":
+			if uw, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				sample.HwmonWatts += uw / 1e6
+			}
+		case "cputemp":
+			sample.CPUTemp = parseTemperature(fields[1])
+		case "systemp":
+			sample.SysTemp = parseTemperature(fields[1])
+		}
+	}
+	return sample
+}
+
+// parseTemperature parses getsysinfo temperatures such as "45 C/113 F" or "45"
+func parseTemperature(value string) float64 {
+	value = strings.TrimRight(value, "CcFf/")
+	temp, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return temp
+}
+
+// ComparePowerSamples derives power and load over the interval between two samples.
+// Energy counters give the average power; hwmon sensors give the latest reading.
+func ComparePowerSamples(before, after *PowerSample) PowerReading {
+	reading := PowerReading{
+		Interval: after.Time.Sub(before.Time),
+		CPUTemp:  after.CPUTemp,
+		SysTemp:  after.SysTemp,
+	}
+
+	busy := after.BusyTicks - before.BusyTicks
+	total := after.TotalTicks - before.TotalTicks
+	if busy >= 0 && total > 0 {
+		reading.CPUBusy = float64(busy) / float64(total) * 100
+		reading.BusyNs = busy * clockTicksNs
+	}
+
+	// RAPL counters wrap around; a decrease leaves the reading to hwmon
+	seconds := reading.Interval.Seconds()
+	if before.EnergyUJ >= 0 && after.EnergyUJ > before.EnergyUJ && seconds > 0 {
+		reading.Watts = float64(after.EnergyUJ-before.EnergyUJ) / 1e6 / seconds
+		reading.Source = PowerSourceRAPL
+	} else if after.HwmonWatts > 0 {
+		reading.Watts = after.HwmonWatts
+		reading.Source = PowerSourceHwmon
+	}
+
+	return reading
+}
+
+// AttributeWatts returns the share of the reading's power attributable to a VM that
+// used cpuNs of CPU time during the interval, in proportion to host CPU busy time
+func (r PowerReading) AttributeWatts(cpuNs int64) float64 {
+	if r.BusyNs <= 0 || cpuNs <= 0 {
+		return 0
+	}
+	share := float64(cpuNs) / float64(r.BusyNs)
+	if share > 1 {
+		share = 1
+	}
+	return r.Watts * share
+}
+
+// MonthlyKWh converts a constant power draw to energy used over an average month
+func MonthlyKWh(watts float64) float64 {
+	const hoursPerMonth = 730
+	return watts * hoursPerMonth / 1000
+}
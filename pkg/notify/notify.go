@@ -0,0 +1,207 @@
+// Package notify sends alerts about a host's VMs to QTS Notification Center (through
+// the NAS's system event log), a webhook and email.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// Events, as listed in config.NotifyEvents
+const (
+	EventVMCrashed      = "vm-crashed"
+	EventBackupFailed   = "backup-failed"
+	EventBackupPruned   = "backup-pruned"
+	EventSnapshotPruned = "snapshot-pruned"
+	// EventTest is sent by 'qnap-vm notify test' and never filtered out
+	EventTest = "test"
+)
+
+// Severities of events
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// sendTimeout bounds delivering one event to the webhook or mail server
+const sendTimeout = 15 * time.Second
+
+// Event is something a NAS owner should hear about. It is the body of webhook requests.
+type Event struct {
+	Type     string    `json:"event"`
+	Severity string    `json:"severity"`
+	Host     string    `json:"host"`
+	VM       string    `json:"vm,omitempty"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+// Config holds where events are sent
+type Config struct {
+	QTS          bool
+	Webhook      string
+	Email        []string
+	SMTP         string // host:port
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+	// Events limits sending to these event types; all are sent when empty
+	Events []string
+}
+
+// Notifier sends a host's events to the configured destinations
+type Notifier struct {
+	cfg  Config
+	host string
+	nas  *ssh.Client
+	http *http.Client
+}
+
+// New creates a notifier for the host labelled host. Events for QTS need UseNAS.
+func New(cfg Config, host string) *Notifier {
+	return &Notifier{cfg: cfg, host: host, http: &http.Client{Timeout: sendTimeout}}
+}
+
+// UseNAS sets the SSH connection used to write events to the NAS's system event log
+func (n *Notifier) UseNAS(client *ssh.Client) {
+	n.nas = client
+}
+
+// Wants reports whether events of the given type are sent anywhere
+func (n *Notifier) Wants(eventType string) bool {
+	if !n.cfg.QTS && n.cfg.Webhook == "" && len(n.cfg.Email) == 0 {
+		return false
+	}
+	return eventType == EventTest || len(n.cfg.Events) == 0 || slices.Contains(n.cfg.Events, eventType)
+}
+
+// Send delivers event to every destination, trying all of them before reporting the
+// ones that failed. Events the config filters out are dropped.
+func (n *Notifier) Send(event Event) error {
+	if !n.Wants(event.Type) {
+		return nil
+	}
+	if event.Host == "" {
+		event.Host = n.host
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	var failed []error
+	if n.cfg.QTS {
+		if err := n.sendQTS(event); err != nil {
+			failed = append(failed, fmt.Errorf("QTS event log: %w", err))
+		}
+	}
+	if n.cfg.Webhook != "" {
+		if err := n.sendWebhook(event); err != nil {
+			failed = append(failed, fmt.Errorf("webhook: %w", err))
+		}
+	}
+	if len(n.cfg.Email) > 0 {
+		if err := n.sendEmail(event); err != nil {
+			failed = append(failed, fmt.Errorf("email: %w", err))
+		}
+	}
+	return errors.Join(failed...)
+}
+
+// qtsCommand returns the command writing event to the QTS system event log. Notification
+// Center's alert rules pick entries up from there by severity and keyword.
+func qtsCommand(event Event) string {
+	level := 0
+	switch event.Severity {
+	case SeverityWarning:
+		level = 1
+	case SeverityError:
+		level = 2
+	}
+	return fmt.Sprintf("log_tool -t %d -a %s", level, ssh.Quote("[qnap-vm] "+event.Message))
+}
+
+func (n *Notifier) sendQTS(event Event) error {
+	if n.nas == nil {
+		return errors.New("no SSH connection to the NAS")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	if output, err := n.nas.ExecuteContext(ctx, qtsCommand(event)); err != nil {
+		return fmt.Errorf("%w\nOutput: %s", err, strings.TrimSpace(output))
+	}
+	return nil
+}
+
+func (n *Notifier) sendWebhook(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := n.http.Post(n.cfg.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if err := resp.Body.Close(); err != nil {
+		// The response body is not used
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s answered %s", n.cfg.Webhook, resp.Status)
+	}
+	return nil
+}
+
+// emailMessage returns the mail for event, with headers
+func emailMessage(from string, to []string, event Event) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: [qnap-vm] %s: %s\r\n", event.Host, event.Message)
+	fmt.Fprintf(&b, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "%s\r\n\r\n", event.Message)
+	fmt.Fprintf(&b, "Host:     %s\r\n", event.Host)
+	if event.VM != "" {
+		fmt.Fprintf(&b, "VM:       %s\r\n", event.VM)
+	}
+	fmt.Fprintf(&b, "Event:    %s (%s)\r\n", event.Type, event.Severity)
+	fmt.Fprintf(&b, "Time:     %s\r\n", event.Time.Format("2006-01-02 15:04:05 MST"))
+	return []byte(b.String())
+}
+
+func (n *Notifier) sendEmail(event Event) error {
+	from := n.cfg.From
+	if from == "" {
+		from = n.cfg.Email[0]
+	}
+	var auth smtp.Auth
+	if n.cfg.SMTPUsername != "" {
+		host, _, err := net.SplitHostPort(n.cfg.SMTP)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", n.cfg.SMTPUsername, n.cfg.SMTPPassword, host)
+	}
+	// smtp.SendMail has no timeout of its own; a dead server must not stall a watch loop
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(n.cfg.SMTP, auth, from, n.cfg.Email, emailMessage(from, n.cfg.Email, event))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(sendTimeout):
+		return fmt.Errorf("no answer from %s within %s", n.cfg.SMTP, sendTimeout)
+	}
+}
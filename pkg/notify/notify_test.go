@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+)
+
+func TestEventsMatchConfig(t *testing.T) {
+	events := []string{EventVMCrashed, EventBackupFailed, EventBackupPruned, EventSnapshotPruned}
+	if !slices.Equal(events, config.NotifyEvents) {
+		t.Errorf("events = %v, config.NotifyEvents = %v", events, config.NotifyEvents)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var received []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		received = append(received, event)
+		if event.VM == "broken" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	notifier := New(Config{Webhook: server.URL, Events: []string{EventBackupFailed}}, "office")
+	if err := notifier.Send(Event{Type: EventBackupFailed, Severity: SeverityError, VM: "web", Message: "backup failed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Send(Event{Type: EventBackupPruned, Severity: SeverityInfo, VM: "web", Message: "pruned"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Send(Event{Type: EventTest, Message: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Send(Event{Type: EventBackupFailed, VM: "broken", Message: "backup failed"}); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("Send to a failing webhook = %v", err)
+	}
+
+	if len(received) != 3 || received[0].Host != "office" || received[0].VM != "web" || received[0].Time.IsZero() {
+		t.Fatalf("received = %+v", received)
+	}
+	if received[1].Type != EventTest {
+		t.Errorf("filtered event was sent: %+v", received[1])
+	}
+}
+
+func TestWants(t *testing.T) {
+	if New(Config{}, "office").Wants(EventTest) {
+		t.Error("a notifier without destinations wants events")
+	}
+	notifier := New(Config{QTS: true}, "office")
+	for _, event := range config.NotifyEvents {
+		if !notifier.Wants(event) {
+			t.Errorf("notifier without an event filter does not want %s", event)
+		}
+	}
+}
+
+func TestQTSCommand(t *testing.T) {
+	got := qtsCommand(Event{Severity: SeverityError, Message: "VM 'bob's' crashed"})
+	want := `log_tool -t 2 -a '[qnap-vm] VM '\''bob'\''s'\'' crashed'`
+	if got != want {
+		t.Errorf("qtsCommand = %s, want %s", got, want)
+	}
+	if got := qtsCommand(Event{Severity: SeverityInfo, Message: "ok"}); !strings.HasPrefix(got, "log_tool -t 0 ") {
+		t.Errorf("qtsCommand for info = %s", got)
+	}
+}
+
+func TestEmailMessage(t *testing.T) {
+	event := Event{Type: EventVMCrashed, Severity: SeverityError, Host: "office", VM: "web",
+		Message: "VM 'web' crashed", Time: time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)}
+	message := string(emailMessage("nas@example.com", []string{"ops@example.com", "me@example.com"}, event))
+	for _, want := range []string{
+		"From: nas@example.com\r\n",
+		"To: ops@example.com, me@example.com\r\n",
+		"Subject: [qnap-vm] office: VM 'web' crashed\r\n",
+		"\r\n\r\nVM 'web' crashed\r\n",
+		"Event:    vm-crashed (error)\r\n",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("message lacks %q:\n%s", want, message)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Package qvs manages VMs through the Virtualization Station HTTP API, for NAS units
+// where SSH is disabled. It covers the VM lifecycle and snapshots; everything else
+// qnap-vm does needs a shell on the NAS.
+package qvs
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// API paths, relative to the NAS's web address. They are the calls the Virtualization
+// Station web UI makes (Virtualization Station 3 and later).
+const (
+	loginPath  = "/qvs/auth/login"
+	logoutPath = "/qvs/auth/logout"
+	vmsPath    = "/qvs/vms"
+)
+
+// csrfCookie holds the token that requests other than GET must echo in csrfHeader
+const (
+	csrfCookie = "csrftoken"
+	csrfHeader = "X-CSRFToken"
+)
+
+// maxResponseSize bounds API responses so a bad one cannot exhaust memory
+const maxResponseSize = 16 << 20
+
+// ErrUnsupported is returned for operations the API does not offer
+var ErrUnsupported = errors.New("not available through the Virtualization Station API; use the ssh backend")
+
+// Config holds the settings for connecting to the API
+type Config struct {
+	// URL is the NAS's web address, e.g. https://192.168.1.100
+	URL      string
+	Username string
+	Password string
+	// Fingerprint pins the TLS certificate (SHA256:...), accepted instead of one signed
+	// by a trusted CA; QTS ships a self-signed certificate
+	Fingerprint string
+	Timeout     time.Duration
+}
+
+// CertificateError reports a TLS certificate that is neither trusted nor pinned. It
+// carries the certificate's fingerprint for the user to compare and pin.
+type CertificateError struct {
+	Fingerprint string
+	Err         error
+}
+
+func (e *CertificateError) Error() string {
+	return fmt.Sprintf("TLS certificate not trusted (fingerprint %s): %v", e.Fingerprint, e.Err)
+}
+
+func (e *CertificateError) Unwrap() error {
+	return e.Err
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a DER certificate in the form
+// ssh-keygen prints for host keys
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// Client is a logged-in session with the Virtualization Station API
+type Client struct {
+	base     *url.URL
+	http     *http.Client
+	username string
+	password string
+	ctx      context.Context
+}
+
+// NewClient creates a client for the API at cfg.URL. Call Login before using it.
+func NewClient(cfg Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(cfg.URL, "/"))
+	if err != nil || (base.Scheme != "https" && base.Scheme != "http") || base.Host == "" {
+		return nil, fmt.Errorf("invalid API URL '%s'", cfg.URL)
+	}
+	if cfg.Username == "" || cfg.Password == "" {
+		return nil, fmt.Errorf("the Virtualization Station API needs a username and password")
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig(base.Hostname(), cfg.Fingerprint)
+
+	return &Client{
+		base:     base,
+		http:     &http.Client{Jar: jar, Timeout: timeout, Transport: transport},
+		username: cfg.Username,
+		password: cfg.Password,
+		ctx:      context.Background(),
+	}, nil
+}
+
+// tlsConfig verifies the server's certificate against the system roots, or only its
+// fingerprint when one is pinned. Both paths report the fingerprint when they fail.
+func tlsConfig(serverName, fingerprint string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// VerifyConnection checks the certificate instead, so a failure can report its
+		// fingerprint
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("server sent no TLS certificate")
+			}
+			leaf := state.PeerCertificates[0]
+			actual := Fingerprint(leaf.Raw)
+			if fingerprint != "" {
+				if actual != fingerprint {
+					return &CertificateError{Fingerprint: actual, Err: fmt.Errorf("certificate does not match the pinned fingerprint %s", fingerprint)}
+				}
+				return nil
+			}
+
+			intermediates := x509.NewCertPool()
+			for _, cert := range state.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			if _, err := leaf.Verify(x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates}); err != nil {
+				return &CertificateError{Fingerprint: actual, Err: err}
+			}
+			return nil
+		},
+	}
+}
+
+// SetContext sets the context of the client's requests. Cancelling it aborts them.
+func (c *Client) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// Login opens a session with the client's credentials
+func (c *Client) Login() error {
+	form := url.Values{"username": {c.username}, "password": {c.password}}
+	if err := c.do(http.MethodPost, loginPath, form, nil); err != nil {
+		return fmt.Errorf("failed to log in to Virtualization Station as %s: %w", c.username, err)
+	}
+	return nil
+}
+
+// Logout ends the session
+func (c *Client) Logout() error {
+	return c.do(http.MethodPost, logoutPath, nil, nil)
+}
+
+// response is the envelope of every API answer. Status 0 is success.
+type response struct {
+	Status  int             `json:"status"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// do sends a request with form as its body, if not nil, and decodes the data of the
+// answer into out, if not nil
+func (c *Client) do(method, path string, form url.Values, out any) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(c.ctx, method, c.base.String()+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if method != http.MethodGet {
+		if token := c.cookie(csrfCookie); token != "" {
+			req.Header.Set(csrfHeader, token)
+		}
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			// Body was fully read; close errors do not affect the result
+		}
+	}()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var answer response
+	if err := json.Unmarshal(data, &answer); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+		return fmt.Errorf("%s %s: unexpected response (is Virtualization Station installed?)", method, path)
+	}
+	if resp.StatusCode != http.StatusOK || answer.Status != 0 {
+		message := answer.Message
+		if message == "" {
+			message = resp.Status
+		}
+		return fmt.Errorf("%s %s: %s", method, path, message)
+	}
+	if out != nil && len(answer.Data) > 0 {
+		if err := json.Unmarshal(answer.Data, out); err != nil {
+			return fmt.Errorf("%s %s: failed to decode response: %w", method, path, err)
+		}
+	}
+	return nil
+}
+
+// cookie returns the value of the session cookie named name, or ""
+func (c *Client) cookie(name string) string {
+	for _, cookie := range c.http.Jar.Cookies(c.base) {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,212 @@
+package qvs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeQVS serves the API calls the client makes, requiring a login and the CSRF
+// token on changes
+type fakeQVS struct {
+	mu      sync.Mutex
+	actions []string
+	snaps   []snapshot
+}
+
+func (f *fakeQVS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reply := func(status int, data any) {
+		if err := json.NewEncoder(w).Encode(map[string]any{"status": status, "data": data}); err != nil {
+			panic(err)
+		}
+	}
+	if r.URL.Path == loginPath {
+		if r.FormValue("username") != "admin" || r.FormValue("password") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			if err := json.NewEncoder(w).Encode(map[string]any{"status": 1, "message": "invalid credentials"}); err != nil {
+				panic(err)
+			}
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "sessionid", Value: "s1", Path: "/"})
+		http.SetCookie(w, &http.Cookie{Name: csrfCookie, Value: "t1", Path: "/"})
+		reply(0, nil)
+		return
+	}
+	if cookie, err := r.Cookie("sessionid"); err != nil || cookie.Value != "s1" {
+		w.WriteHeader(http.StatusUnauthorized)
+		reply(1, nil)
+		return
+	}
+	if r.Method != http.MethodGet && r.Header.Get(csrfHeader) != "t1" {
+		w.WriteHeader(http.StatusForbidden)
+		reply(1, nil)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == vmsPath:
+		reply(0, []vm{
+			{ID: 3, UUID: "u-3", Name: "homeassistant", PowerState: "running", Cores: 2, Memory: 2048},
+			{ID: 7, UUID: "u-7", Name: "win11", PowerState: "shutoff", Cores: 4, Memory: 8192, Description: "Desktop"},
+		})
+	case r.Method == http.MethodGet && r.URL.Path == "/qvs/vms/7/snapshots":
+		reply(0, f.snaps)
+	case r.Method == http.MethodPost && r.URL.Path == "/qvs/vms/7/snapshots":
+		f.snaps = append(f.snaps, snapshot{ID: 11, Name: r.FormValue("name"), Description: r.FormValue("description")})
+		reply(0, nil)
+	default:
+		f.actions = append(f.actions, r.Method+" "+r.URL.Path)
+		reply(0, nil)
+	}
+}
+
+func newTestClient(t *testing.T, f *fakeQVS) *Client {
+	t.Helper()
+	server := httptest.NewTLSServer(f)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(Config{URL: server.URL, Username: "admin", Password: "secret",
+		Fingerprint: Fingerprint(server.Certificate().Raw)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Login(); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestListVMs(t *testing.T) {
+	client := newTestClient(t, &fakeQVS{})
+	vms, err := client.ListVMs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vms) != 2 {
+		t.Fatalf("ListVMs = %+v", vms)
+	}
+	if vms[0].Name != "homeassistant" || vms[0].State != "running" || vms[0].Memory != 2048 || vms[0].CPUs != 2 {
+		t.Errorf("vms[0] = %+v", vms[0])
+	}
+	if vms[1].State != "shut off" || vms[1].Title != "Desktop" {
+		t.Errorf("vms[1] = %+v", vms[1])
+	}
+
+	if _, err := client.GetVM("missing"); err == nil {
+		t.Error("GetVM found a VM that does not exist")
+	}
+}
+
+func TestVMActions(t *testing.T) {
+	f := &fakeQVS{}
+	client := newTestClient(t, f)
+	for _, run := range []func() error{
+		func() error { return client.StartVM("win11") },
+		func() error { return client.StopVM("win11", false) },
+		func() error { return client.StopVM("homeassistant", true) },
+		func() error { return client.DeleteVM("win11") },
+		client.Logout,
+	} {
+		if err := run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"POST /qvs/vms/7/start",
+		"POST /qvs/vms/7/shutdown",
+		"POST /qvs/vms/3/forceshutdown",
+		"DELETE /qvs/vms/7",
+		"POST " + logoutPath,
+	}
+	if !reflect.DeepEqual(f.actions, want) {
+		t.Errorf("actions = %v, want %v", f.actions, want)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	f := &fakeQVS{}
+	client := newTestClient(t, f)
+	if err := client.CreateSnapshot("win11", "before-update", "pre KB5034441"); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := client.ListSnapshots("win11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Name != "before-update" || snapshots[0].Description != "pre KB5034441" {
+		t.Errorf("ListSnapshots = %+v", snapshots)
+	}
+
+	if err := client.RestoreSnapshot("win11", "before-update"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DeleteSnapshot("win11", "before-update"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"POST /qvs/vms/7/snapshots/11/revert", "DELETE /qvs/vms/7/snapshots/11"}
+	if !reflect.DeepEqual(f.actions, want) {
+		t.Errorf("actions = %v, want %v", f.actions, want)
+	}
+
+	if err := client.RestoreSnapshot("win11", "missing"); err == nil {
+		t.Error("RestoreSnapshot accepted a missing snapshot")
+	}
+}
+
+func TestLoginFailure(t *testing.T) {
+	server := httptest.NewTLSServer(&fakeQVS{})
+	defer server.Close()
+
+	client, err := NewClient(Config{URL: server.URL, Username: "admin", Password: "wrong",
+		Fingerprint: Fingerprint(server.Certificate().Raw)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Login(); err == nil || !strings.Contains(err.Error(), "invalid credentials") {
+		t.Errorf("Login = %v, want the server's message", err)
+	}
+}
+
+func TestCertificateVerification(t *testing.T) {
+	server := httptest.NewTLSServer(&fakeQVS{})
+	defer server.Close()
+	actual := Fingerprint(server.Certificate().Raw)
+
+	// The test certificate is self-signed, like the one QTS ships
+	for _, pinned := range []string{"", "SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s"} {
+		client, err := NewClient(Config{URL: server.URL, Username: "admin", Password: "secret", Fingerprint: pinned})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = client.Login()
+		var certErr *CertificateError
+		if !errors.As(err, &certErr) {
+			t.Fatalf("Login with pin %q = %v, want a certificate error", pinned, err)
+		}
+		if certErr.Fingerprint != actual {
+			t.Errorf("certificate error fingerprint = %s, want %s", certErr.Fingerprint, actual)
+		}
+	}
+}
+
+func TestNewClientValidation(t *testing.T) {
+	for _, cfg := range []Config{
+		{URL: "ftp://nas", Username: "admin", Password: "secret"},
+		{URL: "https://", Username: "admin", Password: "secret"},
+		{URL: "https://nas", Username: "admin"},
+	} {
+		if _, err := NewClient(cfg); err == nil {
+			t.Errorf("NewClient(%+v) succeeded", cfg)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package qvs
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+)
+
+// vm is a VM as the API describes it
+type vm struct {
+	ID          int    `json:"id"`
+	UUID        string `json:"uuid"`
+	Name        string `json:"name"`
+	PowerState  string `json:"power_state"`
+	Cores       int    `json:"cores"`
+	Memory      int    `json:"memory"` // MiB
+	Description string `json:"description"`
+}
+
+// info converts v to the form the virsh backend reports, with libvirt's state names
+func (v vm) info() virsh.VMInfo {
+	return virsh.VMInfo{
+		Name:   v.Name,
+		State:  stateName(v.PowerState),
+		UUID:   v.UUID,
+		Memory: v.Memory,
+		CPUs:   v.Cores,
+		Title:  v.Description,
+	}
+}
+
+// stateName maps the API's power states to the names virsh list uses
+func stateName(state string) string {
+	switch strings.ToLower(state) {
+	case "running", "on", "started":
+		return "running"
+	case "shutoff", "shut off", "stopped", "off":
+		return "shut off"
+	case "paused", "suspended":
+		return "paused"
+	case "":
+		return "unknown"
+	default:
+		return strings.ToLower(state)
+	}
+}
+
+// snapshot is a snapshot as the API describes it
+type snapshot struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	CreateTime  string `json:"create_time"`
+	Current     bool   `json:"is_current"`
+}
+
+// ListVMs returns every VM Virtualization Station manages
+func (c *Client) ListVMs() ([]virsh.VMInfo, error) {
+	vms, err := c.listVMs()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]virsh.VMInfo, len(vms))
+	for i, v := range vms {
+		infos[i] = v.info()
+	}
+	return infos, nil
+}
+
+// listVMs returns the VMs as the API describes them
+func (c *Client) listVMs() ([]vm, error) {
+	var vms []vm
+	if err := c.do(http.MethodGet, vmsPath, nil, &vms); err != nil {
+		return nil, fmt.Errorf("failed to list VMs: %w", err)
+	}
+	return vms, nil
+}
+
+// findVM returns the VM named name
+func (c *Client) findVM(name string) (*vm, error) {
+	vms, err := c.listVMs()
+	if err != nil {
+		return nil, err
+	}
+	for i := range vms {
+		if vms[i].Name == name {
+			return &vms[i], nil
+		}
+	}
+	return nil, messages.Errorf(messages.VMNotFound, name)
+}
+
+// GetVM returns the VM named name
+func (c *Client) GetVM(name string) (*virsh.VMInfo, error) {
+	v, err := c.findVM(name)
+	if err != nil {
+		return nil, err
+	}
+	info := v.info()
+	return &info, nil
+}
+
+// GetVMDetails returns the VM named name; the API's VM list already has its details
+func (c *Client) GetVMDetails(name string) (*virsh.VMInfo, error) {
+	return c.GetVM(name)
+}
+
+// vmAction runs action, such as start or shutdown, on the VM named name
+func (c *Client) vmAction(name, action string) error {
+	v, err := c.findVM(name)
+	if err != nil {
+		return err
+	}
+	if err := c.do(http.MethodPost, vmPath(v.ID)+"/"+action, url.Values{}, nil); err != nil {
+		return fmt.Errorf("failed to %s VM '%s': %w", strings.TrimPrefix(action, "force"), name, err)
+	}
+	return nil
+}
+
+// vmPath returns the API path of the VM with the given ID
+func vmPath(id int) string {
+	return vmsPath + "/" + strconv.Itoa(id)
+}
+
+// StartVM starts a VM
+func (c *Client) StartVM(name string) error {
+	return c.vmAction(name, "start")
+}
+
+// StopVM shuts a VM down through ACPI, or powers it off when force is set
+func (c *Client) StopVM(name string, force bool) error {
+	if force {
+		return c.vmAction(name, "forceshutdown")
+	}
+	return c.vmAction(name, "shutdown")
+}
+
+// SuspendVM pauses a running VM
+func (c *Client) SuspendVM(name string) error {
+	return c.vmAction(name, "suspend")
+}
+
+// ResumeVM resumes a paused VM
+func (c *Client) ResumeVM(name string) error {
+	return c.vmAction(name, "resume")
+}
+
+// RebootVM resets a VM
+func (c *Client) RebootVM(name string) error {
+	return c.vmAction(name, "reset")
+}
+
+// DeleteVM removes a VM from Virtualization Station
+func (c *Client) DeleteVM(name string) error {
+	v, err := c.findVM(name)
+	if err != nil {
+		return err
+	}
+	if err := c.do(http.MethodDelete, vmPath(v.ID), nil, nil); err != nil {
+		return fmt.Errorf("failed to delete VM '%s': %w", name, err)
+	}
+	return nil
+}
+
+// GetVMStats is not offered by the API
+func (c *Client) GetVMStats(name string) (*virsh.VMStats, error) {
+	return nil, fmt.Errorf("statistics for VM '%s' are %w", name, ErrUnsupported)
+}
+
+// GetConsoleInfo is not offered by the API
+func (c *Client) GetConsoleInfo(name string) (*virsh.ConsoleInfo, error) {
+	return nil, fmt.Errorf("console details for VM '%s' are %w", name, ErrUnsupported)
+}
+
+// ListSnapshots returns a VM's snapshots
+func (c *Client) ListSnapshots(vmName string) ([]virsh.SnapshotInfo, error) {
+	_, snapshots, err := c.listSnapshots(vmName)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]virsh.SnapshotInfo, len(snapshots))
+	for i, s := range snapshots {
+		infos[i] = virsh.SnapshotInfo{Name: s.Name, CreationTime: s.CreateTime, Description: s.Description, Current: s.Current}
+	}
+	return infos, nil
+}
+
+// listSnapshots returns the VM named vmName and its snapshots
+func (c *Client) listSnapshots(vmName string) (*vm, []snapshot, error) {
+	v, err := c.findVM(vmName)
+	if err != nil {
+		return nil, nil, err
+	}
+	var snapshots []snapshot
+	if err := c.do(http.MethodGet, vmPath(v.ID)+"/snapshots", nil, &snapshots); err != nil {
+		return nil, nil, fmt.Errorf("failed to list snapshots of VM '%s': %w", vmName, err)
+	}
+	return v, snapshots, nil
+}
+
+// findSnapshot returns the API path of a VM's snapshot named name
+func (c *Client) findSnapshot(vmName, name string) (string, error) {
+	v, snapshots, err := c.listSnapshots(vmName)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range snapshots {
+		if s.Name == name {
+			return vmPath(v.ID) + "/snapshots/" + strconv.Itoa(s.ID), nil
+		}
+	}
+	return "", fmt.Errorf("snapshot '%s' not found for VM '%s'", name, vmName)
+}
+
+// CreateSnapshot takes a snapshot of a VM
+func (c *Client) CreateSnapshot(vmName, snapshotName, description string) error {
+	v, err := c.findVM(vmName)
+	if err != nil {
+		return err
+	}
+	form := url.Values{"name": {snapshotName}, "description": {description}}
+	if err := c.do(http.MethodPost, vmPath(v.ID)+"/snapshots", form, nil); err != nil {
+		return fmt.Errorf("failed to create snapshot '%s' of VM '%s': %w", snapshotName, vmName, err)
+	}
+	return nil
+}
+
+// RestoreSnapshot reverts a VM to a snapshot
+func (c *Client) RestoreSnapshot(vmName, snapshotName string) error {
+	path, err := c.findSnapshot(vmName, snapshotName)
+	if err != nil {
+		return err
+	}
+	if err := c.do(http.MethodPost, path+"/revert", url.Values{}, nil); err != nil {
+		return fmt.Errorf("failed to restore snapshot '%s' of VM '%s': %w", snapshotName, vmName, err)
+	}
+	return nil
+}
+
+// DeleteSnapshot deletes a VM's snapshot
+func (c *Client) DeleteSnapshot(vmName, snapshotName string) error {
+	path, err := c.findSnapshot(vmName, snapshotName)
+	if err != nil {
+		return err
+	}
+	if err := c.do(http.MethodDelete, path, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete snapshot '%s' of VM '%s': %w", snapshotName, vmName, err)
+	}
+	return nil
+}
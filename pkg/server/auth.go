@@ -0,0 +1,109 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+)
+
+// Role is what an API token may do. Each role includes the ones below it.
+type Role int
+
+// Roles, from least to most privileged
+const (
+	RoleViewer   Role = iota + 1 // Read VM lists and status
+	RoleOperator                 // Also start, stop, suspend and resume VMs
+	RoleAdmin                    // Also delete VMs
+)
+
+// roleNames maps roles to their names in the config file and API
+var roleNames = map[Role]string{
+	RoleViewer:   "viewer",
+	RoleOperator: "operator",
+	RoleAdmin:    "admin",
+}
+
+// String returns the role's name
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("role(%d)", int(r))
+}
+
+// ParseRole parses a role name: viewer, operator or admin
+func ParseRole(name string) (Role, error) {
+	for role, roleName := range roleNames {
+		if strings.EqualFold(name, roleName) {
+			return role, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid role '%s' (use viewer, operator or admin)", name)
+}
+
+// tokenPrefix marks qnap-vm API tokens, so leaked ones are easy to recognize
+const tokenPrefix = "qvm_"
+
+// NewToken generates a random API token and returns it with the hash to store
+func NewToken() (token, hash string, err error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token = tokenPrefix + hex.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex SHA-256 hash a token is stored as
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// identity is the client behind a request's token
+type identity struct {
+	name string
+	role Role
+	hash []byte
+}
+
+// parseTokens checks the configured tokens and decodes their hashes
+func parseTokens(tokens []config.APIToken) ([]identity, error) {
+	var identities []identity
+	for _, token := range tokens {
+		role, err := ParseRole(token.Role)
+		if err != nil {
+			return nil, fmt.Errorf("token '%s': %w", token.Name, err)
+		}
+		hash, err := hex.DecodeString(token.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("token '%s' has an invalid hash", token.Name)
+		}
+		identities = append(identities, identity{name: token.Name, role: role, hash: hash})
+	}
+	return identities, nil
+}
+
+// authenticate returns the identity whose token is presented as a bearer token in the
+// Authorization header value, or nil
+func authenticate(identities []identity, authorization string) *identity {
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+
+	var match *identity
+	for i := range identities {
+		// Compare every token in constant time so timing reveals nothing about them
+		if subtle.ConstantTimeCompare(sum[:], identities[i].hash) == 1 {
+			match = &identities[i]
+		}
+	}
+	return match
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+)
+
+// openAPIDoc is the part of the OpenAPI document the tests check
+type openAPIDoc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func parseSpec(t *testing.T) *openAPIDoc {
+	t.Helper()
+	var doc openAPIDoc
+	if err := json.Unmarshal(OpenAPISpec(), &doc); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	return &doc
+}
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	s, err := New(&fakeBackend{}, []config.APIToken{{Name: "x", Role: "viewer", Hash: HashToken("x")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := parseSpec(t)
+
+	registered := make(map[string]bool)
+	for _, r := range s.routes {
+		key := r.method + " " + r.path
+		registered[key] = true
+
+		raw, ok := doc.Paths[r.path][strings.ToLower(r.method)]
+		if !ok {
+			t.Errorf("%s is not in openapi.json", key)
+			continue
+		}
+		var op struct {
+			Role string `json:"x-qnap-vm-role"`
+		}
+		if err := json.Unmarshal(raw, &op); err != nil {
+			t.Fatal(err)
+		}
+		if op.Role != r.role.String() {
+			t.Errorf("openapi.json gives %s role %q, the server requires %s", key, op.Role, r.role)
+		}
+	}
+
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			if method == "parameters" || path == "/openapi.json" {
+				continue
+			}
+			if key := strings.ToUpper(method) + " " + path; !registered[key] {
+				t.Errorf("openapi.json documents %s, which the server does not handle", key)
+			}
+		}
+	}
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	doc := parseSpec(t)
+
+	// Response schemas list exactly the JSON fields of the types the server returns
+	for schema, value := range map[string]any{"VM": virsh.VMInfo{}, "Stats": virsh.VMStats{}, "Console": console{}} {
+		fields := jsonFields(reflect.TypeOf(value))
+		var properties []string
+		for name := range doc.Components.Schemas[schema].Properties {
+			properties = append(properties, name)
+		}
+		sort.Strings(fields)
+		sort.Strings(properties)
+		if !reflect.DeepEqual(fields, properties) {
+			t.Errorf("%s schema properties = %v, %T fields = %v", schema, properties, value, fields)
+		}
+	}
+
+	// Every reference resolves
+	var all map[string]any
+	if err := json.Unmarshal(OpenAPISpec(), &all); err != nil {
+		t.Fatal(err)
+	}
+	for _, match := range regexp.MustCompile(`"\$ref":\s*"#/([^"]+)"`).FindAllSubmatch(OpenAPISpec(), -1) {
+		var node any = all
+		for _, part := range strings.Split(string(match[1]), "/") {
+			m, ok := node.(map[string]any)
+			if !ok {
+				node = nil
+				break
+			}
+			node = m[part]
+		}
+		if node == nil {
+			t.Errorf("unresolved reference #/%s", match[1])
+		}
+	}
+}
+
+// jsonFields returns the JSON names of a struct's fields, including those of embedded structs
+func jsonFields(structType reflect.Type) []string {
+	var fields []string
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.Anonymous {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			fields = append(fields, jsonFields(embedded)...)
+			continue
+		}
+		if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	s, err := New(&fakeBackend{}, []config.APIToken{{Name: "x", Role: "viewer", Hash: HashToken("x")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Clients fetch the document before they have a token
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), OpenAPISpec()) {
+		t.Errorf("GET /openapi.json = %d", rec.Code)
+	}
+}
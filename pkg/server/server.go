@@ -0,0 +1,291 @@
+// Package server implements the REST API of 'qnap-vm serve', gating each endpoint by
+// the role of the caller's API token.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+)
+
+// Backend runs VM operations on the NAS. *virsh.Client implements it.
+type Backend interface {
+	ListVMs() ([]virsh.VMInfo, error)
+	GetVM(name string) (*virsh.VMInfo, error)
+	GetVMDetails(name string) (*virsh.VMInfo, error)
+	StartVM(name string) error
+	StopVM(name string, force bool) error
+	SuspendVM(name string) error
+	ResumeVM(name string) error
+	RebootVM(name string) error
+	DeleteVM(name string) error
+	GetVMStats(name string) (*virsh.VMStats, error)
+	GetConsoleInfo(name string) (*virsh.ConsoleInfo, error)
+}
+
+// Server serves the API over one NAS connection
+type Server struct {
+	backend    Backend
+	identities []identity
+	mux        *http.ServeMux
+	routes     []route
+
+	// Calls share one SSH connection, so they run one at a time
+	mu sync.Mutex
+
+	// Log receives one line per request; nil disables logging
+	Log io.Writer
+
+	// Host is the NAS address used in console links for displays listening on all
+	// of the NAS's interfaces
+	Host string
+}
+
+// New creates a server for backend that accepts the given tokens
+func New(backend Backend, tokens []config.APIToken) (*Server, error) {
+	identities, err := parseTokens(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no API tokens are configured")
+	}
+
+	s := &Server{backend: backend, identities: identities, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /openapi.json", s.serveOpenAPI)
+	s.handle("GET /api/v1/whoami", RoleViewer, s.whoami)
+	s.handle("GET /api/v1/vms", RoleViewer, s.listVMs)
+	s.handle("GET /api/v1/vms/{name}", RoleViewer, s.getVM)
+	s.handle("GET /api/v1/vms/{name}/stats", RoleViewer, s.getStats)
+	s.handle("GET /api/v1/vms/{name}/console", RoleViewer, s.getConsole)
+	s.handle("POST /api/v1/vms/{name}/start", RoleOperator, s.vmAction(func(name string, r *http.Request) error {
+		return s.backend.StartVM(name)
+	}))
+	s.handle("POST /api/v1/vms/{name}/stop", RoleOperator, s.vmAction(func(name string, r *http.Request) error {
+		return s.backend.StopVM(name, r.URL.Query().Get("force") == "true")
+	}))
+	s.handle("POST /api/v1/vms/{name}/suspend", RoleOperator, s.vmAction(func(name string, r *http.Request) error {
+		return s.backend.SuspendVM(name)
+	}))
+	s.handle("POST /api/v1/vms/{name}/resume", RoleOperator, s.vmAction(func(name string, r *http.Request) error {
+		return s.backend.ResumeVM(name)
+	}))
+	s.handle("POST /api/v1/vms/{name}/reboot", RoleOperator, s.vmAction(func(name string, r *http.Request) error {
+		return s.backend.RebootVM(name)
+	}))
+	s.handle("DELETE /api/v1/vms/{name}", RoleAdmin, s.deleteVM)
+	return s, nil
+}
+
+// route is a registered endpoint and the role it needs
+type route struct {
+	method string
+	path   string
+	role   Role
+}
+
+// handlerFunc handles a request from an authenticated client
+type handlerFunc func(w http.ResponseWriter, r *http.Request, caller *identity) (int, any)
+
+// handle registers an endpoint that needs at least role
+func (s *Server) handle(pattern string, role Role, handler handlerFunc) {
+	method, path, _ := strings.Cut(pattern, " ")
+	s.routes = append(s.routes, route{method: method, path: path, role: role})
+
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		caller := authenticate(s.identities, r.Header.Get("Authorization"))
+
+		var status int
+		var body any
+		switch {
+		case caller == nil:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="qnap-vm"`)
+			status, body = http.StatusUnauthorized, errorBody("missing or invalid API token")
+		case caller.role < role:
+			status, body = http.StatusForbidden, errorBody(fmt.Sprintf("token '%s' has role %s; this needs %s", caller.name, caller.role, role))
+		default:
+			s.mu.Lock()
+			status, body = handler(w, r, caller)
+			s.mu.Unlock()
+		}
+
+		writeJSON(w, status, body)
+		s.logRequest(r, caller, status)
+	})
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// logRequest writes a request's log line
+func (s *Server) logRequest(r *http.Request, caller *identity, status int) {
+	if s.Log == nil {
+		return
+	}
+	name := "-"
+	if caller != nil {
+		name = caller.name
+	}
+	fmt.Fprintf(s.Log, "%s %s %s %s %d\n", time.Now().Format(time.RFC3339), name, r.Method, r.URL.Path, status)
+}
+
+// errorBody is the JSON body of an error response
+func errorBody(message string) map[string]string {
+	return map[string]string{"error": message}
+}
+
+// writeJSON writes body as the JSON response
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		// The client went away; there is no one left to tell
+	}
+}
+
+// backendError maps a backend error to a response
+func backendError(err error) (int, any) {
+	if messages.IDOf(err) == messages.VMNotFound {
+		return http.StatusNotFound, errorBody(err.Error())
+	}
+	return http.StatusInternalServerError, errorBody(err.Error())
+}
+
+func (s *Server) whoami(w http.ResponseWriter, r *http.Request, caller *identity) (int, any) {
+	return http.StatusOK, map[string]string{"name": caller.name, "role": caller.role.String()}
+}
+
+func (s *Server) listVMs(w http.ResponseWriter, r *http.Request, caller *identity) (int, any) {
+	vms, err := s.backend.ListVMs()
+	if err != nil {
+		return backendError(err)
+	}
+	if vms == nil {
+		vms = []virsh.VMInfo{}
+	}
+	return http.StatusOK, vms
+}
+
+func (s *Server) getVM(w http.ResponseWriter, r *http.Request, caller *identity) (int, any) {
+	name := r.PathValue("name")
+	if _, err := s.backend.GetVM(name); err != nil {
+		return backendError(err)
+	}
+	vm, err := s.backend.GetVMDetails(name)
+	if err != nil {
+		return backendError(err)
+	}
+	return http.StatusOK, vm
+}
+
+func (s *Server) getStats(w http.ResponseWriter, r *http.Request, caller *identity) (int, any) {
+	name := r.PathValue("name")
+	if _, err := s.backend.GetVM(name); err != nil {
+		return backendError(err)
+	}
+	stats, err := s.backend.GetVMStats(name)
+	if err != nil {
+		return backendError(err)
+	}
+	return http.StatusOK, stats
+}
+
+// console is a VM's console information with a link clients can open
+type console struct {
+	*virsh.ConsoleInfo
+
+	// URL is a vnc:// or spice:// link, when the display is reachable over the network
+	URL string `json:"url,omitempty"`
+	// Tunnel is the command that reaches a display listening only on the NAS itself
+	Tunnel string `json:"tunnel,omitempty"`
+}
+
+func (s *Server) getConsole(w http.ResponseWriter, r *http.Request, caller *identity) (int, any) {
+	name := r.PathValue("name")
+	if _, err := s.backend.GetVM(name); err != nil {
+		return backendError(err)
+	}
+	info, err := s.backend.GetConsoleInfo(name)
+	if err != nil {
+		return backendError(err)
+	}
+	return http.StatusOK, consoleLink(info, name, s.Host)
+}
+
+// consoleLink adds a link to a VM's console information. Displays listening on all
+// interfaces are reached through nasHost; those on loopback only through an SSH tunnel.
+func consoleLink(info *virsh.ConsoleInfo, vmName, nasHost string) console {
+	result := console{ConsoleInfo: info}
+
+	scheme, host, port := "vnc", info.VNCHost, info.VNCPort
+	if info.Protocol == "SPICE" {
+		scheme, host, port = "spice", info.SPICEHost, info.SPICEPort
+	}
+	if port <= 0 {
+		return result
+	}
+
+	switch host {
+	case "", "0.0.0.0", "::":
+		host = nasHost
+	case "127.0.0.1", "localhost", "::1":
+		host = ""
+	}
+	if host == "" {
+		result.Tunnel = fmt.Sprintf("qnap-vm console %s --tunnel", vmName)
+		return result
+	}
+	result.URL = fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(port)))
+	return result
+}
+
+// vmAction returns a handler that runs action on an existing VM and responds with its
+// new state
+func (s *Server) vmAction(action func(name string, r *http.Request) error) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, caller *identity) (int, any) {
+		name := r.PathValue("name")
+		if _, err := s.backend.GetVM(name); err != nil {
+			return backendError(err)
+		}
+		if err := action(name, r); err != nil {
+			return backendError(err)
+		}
+
+		vm, err := s.backend.GetVM(name)
+		if err != nil {
+			// Transient VMs disappear when stopped
+			return http.StatusOK, map[string]string{"name": name, "state": "gone"}
+		}
+		return http.StatusOK, vm
+	}
+}
+
+func (s *Server) deleteVM(w http.ResponseWriter, r *http.Request, caller *identity) (int, any) {
+	name := r.PathValue("name")
+	if _, err := s.backend.GetVM(name); err != nil {
+		return backendError(err)
+	}
+	result := map[string]string{"name": name, "state": "deleted"}
+	if err := s.backend.DeleteVM(name); err != nil {
+		// The VM is gone even when Virtualization Station's list could not be updated
+		var regErr *virsh.QVSRegistrationError
+		if !errors.As(err, &regErr) {
+			return backendError(err)
+		}
+		result["warning"] = regErr.Error()
+	}
+	return http.StatusOK, result
+}
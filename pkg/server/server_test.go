@@ -0,0 +1,214 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/config"
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/virsh"
+)
+
+// fakeBackend records the operations run on it
+type fakeBackend struct {
+	vms   map[string]string // Name -> state
+	calls []string
+}
+
+func (f *fakeBackend) ListVMs() ([]virsh.VMInfo, error) {
+	var vms []virsh.VMInfo
+	for name, state := range f.vms {
+		vms = append(vms, virsh.VMInfo{Name: name, State: state})
+	}
+	return vms, nil
+}
+
+func (f *fakeBackend) GetVM(name string) (*virsh.VMInfo, error) {
+	state, ok := f.vms[name]
+	if !ok {
+		return nil, messages.Errorf(messages.VMNotFound, name)
+	}
+	return &virsh.VMInfo{Name: name, State: state}, nil
+}
+
+func (f *fakeBackend) GetVMDetails(name string) (*virsh.VMInfo, error) {
+	return f.GetVM(name)
+}
+
+func (f *fakeBackend) StartVM(name string) error {
+	f.calls = append(f.calls, "start "+name)
+	f.vms[name] = "running"
+	return nil
+}
+
+func (f *fakeBackend) StopVM(name string, force bool) error {
+	if force {
+		f.calls = append(f.calls, "destroy "+name)
+	} else {
+		f.calls = append(f.calls, "shutdown "+name)
+	}
+	f.vms[name] = "shut off"
+	return nil
+}
+
+func (f *fakeBackend) SuspendVM(name string) error {
+	f.calls = append(f.calls, "suspend "+name)
+	return nil
+}
+
+func (f *fakeBackend) ResumeVM(name string) error {
+	f.calls = append(f.calls, "resume "+name)
+	return nil
+}
+
+func (f *fakeBackend) RebootVM(name string) error {
+	f.calls = append(f.calls, "reboot "+name)
+	return nil
+}
+
+func (f *fakeBackend) GetVMStats(name string) (*virsh.VMStats, error) {
+	return &virsh.VMStats{CPUTime: 42}, nil
+}
+
+func (f *fakeBackend) GetConsoleInfo(name string) (*virsh.ConsoleInfo, error) {
+	return &virsh.ConsoleInfo{Protocol: "VNC", VNCHost: "0.0.0.0", VNCPort: 5900, VNCDisplay: ":0"}, nil
+}
+
+func (f *fakeBackend) DeleteVM(name string) error {
+	f.calls = append(f.calls, "delete "+name)
+	delete(f.vms, name)
+	return nil
+}
+
+func TestRoles(t *testing.T) {
+	backend := &fakeBackend{vms: map[string]string{"homeassistant": "running"}}
+	tokens := []config.APIToken{
+		{Name: "dashboard", Role: "viewer", Hash: HashToken("view-token")},
+		{Name: "automation", Role: "operator", Hash: HashToken("op-token")},
+		{Name: "me", Role: "admin", Hash: HashToken("admin-token")},
+	}
+	s, err := New(backend, tokens)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	tests := []struct {
+		token  string
+		method string
+		path   string
+		want   int
+	}{
+		{"", "GET", "/api/v1/vms", http.StatusUnauthorized},
+		{"wrong", "GET", "/api/v1/vms", http.StatusUnauthorized},
+		{"view-token", "GET", "/api/v1/vms", http.StatusOK},
+		{"view-token", "GET", "/api/v1/vms/homeassistant", http.StatusOK},
+		{"view-token", "GET", "/api/v1/vms/missing", http.StatusNotFound},
+		{"view-token", "GET", "/api/v1/vms/homeassistant/stats", http.StatusOK},
+		{"view-token", "GET", "/api/v1/vms/homeassistant/console", http.StatusOK},
+		{"view-token", "POST", "/api/v1/vms/homeassistant/reboot", http.StatusForbidden},
+		{"op-token", "POST", "/api/v1/vms/homeassistant/reboot", http.StatusOK},
+		{"view-token", "POST", "/api/v1/vms/homeassistant/stop", http.StatusForbidden},
+		{"view-token", "DELETE", "/api/v1/vms/homeassistant", http.StatusForbidden},
+		{"op-token", "POST", "/api/v1/vms/homeassistant/stop?force=true", http.StatusOK},
+		{"op-token", "POST", "/api/v1/vms/homeassistant/start", http.StatusOK},
+		{"op-token", "POST", "/api/v1/vms/missing/start", http.StatusNotFound},
+		{"op-token", "DELETE", "/api/v1/vms/homeassistant", http.StatusForbidden},
+		{"admin-token", "DELETE", "/api/v1/vms/homeassistant", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s with %q = %d, want %d: %s", tt.method, tt.path, tt.token, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+
+	if got := strings.Join(backend.calls, ","); got != "reboot homeassistant,destroy homeassistant,start homeassistant,delete homeassistant" {
+		t.Errorf("backend calls = %s", got)
+	}
+}
+
+func TestWhoami(t *testing.T) {
+	token, hash, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, tokenPrefix) || hash != HashToken(token) {
+		t.Errorf("NewToken() = %q, %q", token, hash)
+	}
+
+	s, err := New(&fakeBackend{}, []config.APIToken{{Name: "dashboard", Role: "Viewer", Hash: hash}})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	req := httptest.NewRequest("GET", "/api/v1/whoami", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["name"] != "dashboard" || body["role"] != "viewer" {
+		t.Errorf("whoami = %s, %v", rec.Body.String(), err)
+	}
+}
+
+func TestNewRejectsBadTokens(t *testing.T) {
+	if _, err := New(&fakeBackend{}, nil); err == nil {
+		t.Error("New() without tokens should fail")
+	}
+	if _, err := New(&fakeBackend{}, []config.APIToken{{Name: "x", Role: "root", Hash: HashToken("x")}}); err == nil {
+		t.Error("New() with an unknown role should fail")
+	}
+	if _, err := New(&fakeBackend{}, []config.APIToken{{Name: "x", Role: "admin", Hash: "plaintext"}}); err == nil {
+		t.Error("New() with an invalid hash should fail")
+	}
+}
+
+func TestConsoleLink(t *testing.T) {
+	tests := []struct {
+		info        virsh.ConsoleInfo
+		url, tunnel string
+	}{
+		{virsh.ConsoleInfo{Protocol: "VNC", VNCHost: "0.0.0.0", VNCPort: 5901}, "vnc://nas.local:5901", ""},
+		{virsh.ConsoleInfo{Protocol: "VNC", VNCHost: "192.168.1.10", VNCPort: 5900}, "vnc://192.168.1.10:5900", ""},
+		{virsh.ConsoleInfo{Protocol: "SPICE", SPICEHost: "127.0.0.1", SPICEPort: 5930}, "", "qnap-vm console web --tunnel"},
+		{virsh.ConsoleInfo{SerialPort: "available"}, "", ""},
+	}
+	for _, tt := range tests {
+		got := consoleLink(&tt.info, "web", "nas.local")
+		if got.URL != tt.url || got.Tunnel != tt.tunnel {
+			t.Errorf("consoleLink(%+v) = %q, %q; want %q, %q", tt.info, got.URL, got.Tunnel, tt.url, tt.tunnel)
+		}
+	}
+}
+
+func TestUI(t *testing.T) {
+	s, err := New(&fakeBackend{}, []config.APIToken{{Name: "x", Role: "viewer", Hash: HashToken("x")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET / without the UI = %d, want 404", rec.Code)
+	}
+
+	// The page loads without a token and asks for one
+	s.EnableUI()
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/api/v1/vms") {
+		t.Errorf("GET / = %d", rec.Code)
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+}
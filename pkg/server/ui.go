@@ -0,0 +1,29 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// dashboardHTML is the web dashboard, a single page that calls the API with a token
+// the user enters once and the browser remembers
+//
+//go:embed ui/index.html
+var dashboardHTML []byte
+
+// EnableUI serves the web dashboard at /. The page itself needs no token; every API
+// call it makes does.
+func (s *Server) EnableUI() {
+	s.mux.HandleFunc("GET /{$}", s.serveUI)
+}
+
+// serveUI serves the dashboard page
+func (s *Server) serveUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; img-src 'self' data:")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Cache-Control", "no-cache")
+	if _, err := w.Write(dashboardHTML); err != nil {
+		// The client went away; there is no one left to tell
+	}
+}
@@ -2,8 +2,10 @@
 package ssh
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"os"
 	"path/filepath"
@@ -12,15 +14,22 @@ import (
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
-	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 // Client represents an SSH client connection to a QNAP device
 type Client struct {
-	config *ssh.ClientConfig
-	client *ssh.Client
-	host   string
-	port   int
+	config     *ssh.ClientConfig
+	client     *ssh.Client
+	host       string
+	port       int
+	transcript *Transcript
+	dryRun     io.Writer    // Where mutating commands are printed instead of run; nil runs them
+	logger     *slog.Logger // Log of connections, commands and their output; nil logs nothing
+
+	pool sessionPool     // Sessions and persistent shells shared by concurrent commands
+	ctx  context.Context // Context of commands run without one; nil never cancels
+
+	elevation *elevation // Runs commands as an administrator; nil runs them as the SSH user
 }
 
 // Config represents SSH connection configuration
@@ -31,6 +40,31 @@ type Config struct {
 	KeyFile  string
 	Password string
 	Timeout  time.Duration
+
+	// Elevate runs commands through sudo or su (ElevateSudo, ElevateSu) as ElevateUser,
+	// answering the password prompt with ElevatePassword
+	Elevate         string
+	ElevateUser     string
+	ElevatePassword string
+
+	// MaxSessions bounds the sessions open at once, including idle persistent shells;
+	// 8 when zero, below OpenSSH's default MaxSessions of 10
+	MaxSessions int
+
+	// PasswordPrompt asks for the password when no other method is accepted; nil never asks
+	PasswordPrompt func() (string, error)
+
+	// KnownHostsFile records trusted host keys; ~/.ssh/known_hosts when empty
+	KnownHostsFile string
+	// HostKeyPrompt asks whether to trust a host whose key, with the given SHA256
+	// fingerprint, is not in KnownHostsFile yet.
+	// Unknown keys are refused when it is nil or StrictHostKey is set.
+	HostKeyPrompt func(host, fingerprint string) (bool, error)
+	// StrictHostKey refuses hosts whose key is not already known
+	StrictHostKey bool
+	// HostKeyFingerprint pins the host's key to this SHA256 fingerprint; known_hosts is
+	// not consulted when it is set
+	HostKeyFingerprint string
 }
 
 // NewClient creates a new SSH client
@@ -44,7 +78,7 @@ func NewClient(cfg Config) (*Client, error) {
 		return nil, fmt.Errorf("failed to get authentication methods: %w", err)
 	}
 
-	hostKeyCallback, err := getHostKeyCallback()
+	hostKeyCallback, err := getHostKeyCallback(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get host key callback: %w", err)
 	}
@@ -56,19 +90,33 @@ func NewClient(cfg Config) (*Client, error) {
 		Timeout:         cfg.Timeout,
 	}
 
-	return &Client{
-		config: sshConfig,
-		host:   cfg.Host,
-		port:   cfg.Port,
-	}, nil
+	elevation, err := newElevation(cfg.Elevate, cfg.ElevateUser, cfg.ElevatePassword)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxSessions <= 0 {
+		cfg.MaxSessions = 8
+	}
+
+	c := &Client{
+		config:    sshConfig,
+		host:      cfg.Host,
+		port:      cfg.Port,
+		elevation: elevation,
+	}
+	c.pool.init(cfg.MaxSessions)
+	return c, nil
 }
 
 // Connect establishes the SSH connection
 func (c *Client) Connect() error {
 	address := fmt.Sprintf("%s:%d", c.host, c.port)
 
+	c.log().Info("connecting", "address", address, "user", c.config.User)
 	client, err := ssh.Dial("tcp", address, c.config)
 	if err != nil {
+		c.log().Warn("connection failed", "address", address, "error", err)
 		return fmt.Errorf("failed to connect to %s: %w", address, err)
 	}
 
@@ -78,18 +126,117 @@ func (c *Client) Connect() error {
 
 // Close closes the SSH connection
 func (c *Client) Close() error {
+	c.pool.closeIdle()
 	if c.client != nil {
 		return c.client.Close()
 	}
 	return nil
 }
 
+// SetTranscript records every command the client runs in t
+func (c *Client) SetTranscript(t *Transcript) {
+	c.transcript = t
+}
+
+// SetLogger logs connections at info level, every command with its output at debug
+// level, and failed commands at warn level to l
+func (c *Client) SetLogger(l *slog.Logger) {
+	c.logger = l
+}
+
+// log returns the client's logger, or one that discards everything
+func (c *Client) log() *slog.Logger {
+	if c.logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return c.logger
+}
+
+// record adds a finished command to the transcript and the log, if they are set
+func (c *Client) record(start time.Time, command, output string, err error) {
+	if c.transcript != nil {
+		c.transcript.Record(start, command, output, err)
+	}
+
+	attrs := []any{"command", strings.TrimSpace(command), "duration", time.Since(start).Round(time.Millisecond), "output", output}
+	if err != nil {
+		c.log().Warn("command failed", append(attrs, "error", err)...)
+		return
+	}
+	c.log().Debug("command", attrs...)
+}
+
+// SetContext sets the context of commands run without one of their own, such as those
+// of Execute. Cancelling it stops the commands running on the NAS.
+func (c *Client) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// Context returns the context set with SetContext, or context.Background()
+func (c *Client) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
 // Execute runs a command on the remote host and returns the output
 func (c *Client) Execute(command string) (string, error) {
+	return c.ExecuteContext(c.Context(), command)
+}
+
+// ExecuteContext runs a command on the remote host and returns the output. Cancelling
+// ctx terminates the command on the NAS.
+func (c *Client) ExecuteContext(ctx context.Context, command string) (string, error) {
+	if c.skipCommand(command) {
+		return "", nil
+	}
+	start := time.Now()
+	output, err := c.execute(ctx, command, nil)
+	c.record(start, command, output, err)
+	return output, err
+}
+
+// ExecuteWithInput runs a command with input and returns the output
+func (c *Client) ExecuteWithInput(command string, input io.Reader) (string, error) {
+	return c.ExecuteWithInputContext(c.Context(), command, input)
+}
+
+// ExecuteWithInputContext runs a command with input and returns the output, until ctx
+// is cancelled
+func (c *Client) ExecuteWithInputContext(ctx context.Context, command string, input io.Reader) (string, error) {
+	if c.skipCommand(command) {
+		return "", nil
+	}
+	start := time.Now()
+	output, err := c.execute(ctx, command, input)
+	c.record(start, command, output, err)
+	return output, err
+}
+
+// execute runs a command with optional input and returns its combined output
+func (c *Client) execute(ctx context.Context, command string, input io.Reader) (string, error) {
 	if c.client == nil {
 		return "", fmt.Errorf("not connected")
 	}
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("command cancelled: %w", err)
+	}
+	if input == nil {
+		shellCommand := command
+		if c.elevation != nil {
+			shellCommand = c.elevation.shellCommand(command)
+		}
+		if output, ran, err := c.runInShell(ctx, shellCommand); ran {
+			return output, err
+		}
+	}
+	if c.elevation != nil {
+		command, input = c.elevation.wrap(command), c.elevation.input(input)
+	}
 
+	c.pool.acquire()
+	defer c.pool.release()
 	session, err := c.client.NewSession()
 	if err != nil {
 		return "", fmt.Errorf("failed to create session: %w", err)
@@ -101,23 +248,86 @@ func (c *Client) Execute(command string) (string, error) {
 		}
 	}()
 
-	output, err := session.CombinedOutput(command)
-	if err != nil {
+	if input != nil {
+		session.Stdin = input
+	}
+	var output []byte
+	err = runSession(ctx, session, func() (err error) {
+		output, err = session.CombinedOutput(command)
+		return err
+	})
+	if err != nil && ctx.Err() == nil {
 		return string(output), fmt.Errorf("command failed: %w", err)
 	}
+	if err != nil {
+		return string(output), err
+	}
 
 	return string(output), nil
 }
 
-// ExecuteWithInput runs a command with input and returns the output
-func (c *Client) ExecuteWithInput(command string, input io.Reader) (string, error) {
+// runSession runs a session's command with run. If ctx is cancelled first, the remote
+// process is sent SIGTERM and the session closed.
+func runSession(ctx context.Context, session *ssh.Session, run func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- run() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if err := session.Signal(ssh.SIGTERM); err != nil {
+			// Servers before OpenSSH 7.9 ignore signals; closing the session still
+			// ends the command once it next writes output
+		}
+		if err := session.Close(); err != nil {
+			// The command may have finished meanwhile
+		}
+		<-done
+		return fmt.Errorf("command cancelled: %w", ctx.Err())
+	}
+}
+
+// runInShell runs a command in an idle persistent shell, starting one if the pool has
+// room. It reports ran = false, leaving the command to a session of its own, when no
+// shell can be started on this connection.
+func (c *Client) runInShell(ctx context.Context, command string) (output string, ran bool, err error) {
+	shell := c.pool.getShell(func() (*remoteShell, error) {
+		shell, err := startShell(c.client)
+		if err != nil {
+			c.log().Debug("persistent shell unavailable", "error", err)
+		}
+		return shell, err
+	})
+	if shell == nil {
+		return "", false, nil
+	}
+
+	output, err = shell.run(ctx, command)
+	c.pool.putShell(shell)
+	return output, true, err
+}
+
+// ExecuteStream runs a command, wiring stdin and stdout to the given reader and writer.
+// It is used for bulk data transfers where buffering the output in memory is not practical.
+func (c *Client) ExecuteStream(command string, stdin io.Reader, stdout io.Writer) error {
+	return c.ExecuteStreamContext(c.Context(), command, stdin, stdout)
+}
+
+// ExecuteStreamContext is ExecuteStream until ctx is cancelled
+func (c *Client) ExecuteStreamContext(ctx context.Context, command string, stdin io.Reader, stdout io.Writer) error {
+	if c.skipCommand(command) {
+		return nil
+	}
 	if c.client == nil {
-		return "", fmt.Errorf("not connected")
+		return fmt.Errorf("not connected")
 	}
 
+	c.pool.acquire()
+	defer c.pool.release()
 	session, err := c.client.NewSession()
 	if err != nil {
-		return "", fmt.Errorf("failed to create session: %w", err)
+		return fmt.Errorf("failed to create session: %w", err)
 	}
 	defer func() {
 		if err := session.Close(); err != nil {
@@ -126,13 +336,73 @@ func (c *Client) ExecuteWithInput(command string, input io.Reader) (string, erro
 		}
 	}()
 
-	session.Stdin = input
-	output, err := session.CombinedOutput(command)
+	var stderr strings.Builder
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = &stderr
+	run := command
+	if c.elevation != nil {
+		run, session.Stdin = c.elevation.wrap(command), c.elevation.input(stdin)
+	}
+
+	start := time.Now()
+	err = runSession(ctx, session, func() error { return session.Run(run) })
+	c.record(start, command, stderr.String(), err)
 	if err != nil {
-		return string(output), fmt.Errorf("command failed: %w", err)
+		return fmt.Errorf("command failed: %w\nOutput: %s", err, strings.TrimSpace(stderr.String()))
 	}
 
-	return string(output), nil
+	return nil
+}
+
+// Forward accepts connections on listener and forwards each one to remoteAddr
+// through the SSH connection. It blocks until the listener is closed.
+func (c *Client) Forward(listener net.Listener, remoteAddr string) error {
+	if c.client == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go c.forwardConn(local, remoteAddr)
+	}
+}
+
+// forwardConn copies data between a local connection and remoteAddr on the remote host
+func (c *Client) forwardConn(local net.Conn, remoteAddr string) {
+	defer func() {
+		if err := local.Close(); err != nil {
+			// The peer may already have closed the connection
+		}
+	}()
+
+	remote, err := c.client.Dial("tcp", remoteAddr)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err := remote.Close(); err != nil {
+			// The peer may already have closed the connection
+		}
+	}()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		if _, err := io.Copy(remote, local); err != nil {
+			// Copy ends when either side disconnects
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		if _, err := io.Copy(local, remote); err != nil {
+			// Copy ends when either side disconnects
+		}
+		done <- struct{}{}
+	}()
+	<-done
 }
 
 // IsConnected returns whether the client is connected
@@ -178,9 +448,9 @@ func getAuthMethods(cfg Config) ([]ssh.AuthMethod, error) {
 		}
 	}
 
-	// Try password authentication
-	if cfg.Password != "" {
-		authMethods = append(authMethods, ssh.Password(cfg.Password))
+	// Try the stored password, then ask
+	if auth := passwordAuth(cfg.Password, cfg.PasswordPrompt); auth != nil {
+		authMethods = append(authMethods, auth)
 	}
 
 	if len(authMethods) == 0 {
@@ -190,6 +460,31 @@ func getAuthMethods(cfg Config) ([]ssh.AuthMethod, error) {
 	return authMethods, nil
 }
 
+// passwordAuth returns the password authentication method: the stored password first, then
+// up to three prompts allowing for typos. It is a single method because x/crypto/ssh skips a
+// method whose name has already been tried, so a separate prompt after a rejected stored
+// password would never be asked.
+func passwordAuth(password string, prompt func() (string, error)) ssh.AuthMethod {
+	switch {
+	case prompt == nil && password == "":
+		return nil
+	case prompt == nil:
+		return ssh.Password(password)
+	case password == "":
+		return ssh.RetryableAuthMethod(ssh.PasswordCallback(prompt), 3)
+	}
+
+	tried := false
+	callback := func() (string, error) {
+		if !tried {
+			tried = true
+			return password, nil
+		}
+		return prompt()
+	}
+	return ssh.RetryableAuthMethod(ssh.PasswordCallback(callback), 4)
+}
+
 // trySSHAgent attempts to use SSH agent for authentication
 func trySSHAgent() ssh.AuthMethod {
 	if sshAgent, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK")); err == nil {
@@ -231,29 +526,7 @@ func tryKeyFile(keyPath string) (ssh.AuthMethod, error) {
 	return ssh.PublicKeys(signer), nil
 }
 
-// getHostKeyCallback returns the host key callback for SSH
-func getHostKeyCallback() (ssh.HostKeyCallback, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		// Fallback to insecure if we can't get home directory
-		return ssh.InsecureIgnoreHostKey(), nil
-	}
-
-	knownHostsFile := filepath.Join(homeDir, ".ssh", "known_hosts")
-
-	// Check if known_hosts file exists
-	if _, err := os.Stat(knownHostsFile); os.IsNotExist(err) {
-		// If known_hosts doesn't exist, use insecure callback
-		// In a production environment, you might want to create the file
-		// or prompt the user to verify the host key
-		return ssh.InsecureIgnoreHostKey(), nil
-	}
-
-	callback, err := knownhosts.New(knownHostsFile)
-	if err != nil {
-		// Fallback to insecure if we can't parse known_hosts
-		return ssh.InsecureIgnoreHostKey(), nil
-	}
-
-	return callback, nil
+// Quote quotes a string for safe use as a single argument in a remote shell command
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
@@ -0,0 +1,166 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRecordLogsCommands(t *testing.T) {
+	var buf bytes.Buffer
+	c := &Client{}
+	c.SetLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	c.record(time.Now(), "\n\tvirsh list --all\n", " Id   Name\n", nil)
+	c.record(time.Now(), "virsh start --domain 'web'", "error: domain not found\n", errors.New("exit status 1"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2:\n%s", len(lines), buf.String())
+	}
+	for _, want := range []string{"level=DEBUG", `msg=command`, `command="virsh list --all"`, `output=" Id   Name\n"`} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("command log %q missing %q", lines[0], want)
+		}
+	}
+	for _, want := range []string{"level=WARN", `msg="command failed"`, `error="exit status 1"`} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("failure log %q missing %q", lines[1], want)
+		}
+	}
+}
+
+func TestRecordWithoutLogger(t *testing.T) {
+	// A client without a logger must not panic
+	c := &Client{}
+	c.record(time.Now(), "true", "", nil)
+}
+
+func TestAuthMethodsPasswordPrompt(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	if _, err := getAuthMethods(Config{}); err == nil {
+		t.Error("getAuthMethods() without keys, password or prompt should fail")
+	}
+
+	prompt := func() (string, error) { return "secret", nil }
+	methods, err := getAuthMethods(Config{PasswordPrompt: prompt})
+	if err != nil || len(methods) != 1 {
+		t.Errorf("getAuthMethods() with a prompt = %d methods, %v, want 1", len(methods), err)
+	}
+}
+
+// passwordServer accepts one SSH connection on a local port, rejecting every password but
+// want, and returns the address and the passwords it was offered
+func passwordServer(t *testing.T, want string) (string, <-chan []string) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var offered []string
+	server := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			offered = append(offered, string(password))
+			if string(password) != want {
+				return nil, fmt.Errorf("password rejected")
+			}
+			return nil, nil
+		},
+	}
+	server.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := listener.Close(); err != nil {
+			t.Logf("closing listener: %v", err)
+		}
+	})
+
+	done := make(chan []string, 1)
+	go func() {
+		defer func() { done <- offered }()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		sshConn, _, _, err := ssh.NewServerConn(conn, server)
+		if err != nil {
+			return
+		}
+		if err := sshConn.Close(); err != nil {
+			t.Logf("closing server connection: %v", err)
+		}
+	}()
+	return listener.Addr().String(), done
+}
+
+func TestPasswordAuthPromptsAfterStoredPasswordRejected(t *testing.T) {
+	address, offered := passwordServer(t, "right")
+
+	prompts := 0
+	auth := passwordAuth("stale", func() (string, error) {
+		prompts++
+		return "right", nil
+	})
+	client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            "admin",
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v, want the prompted password accepted", err)
+	}
+	if err := client.Close(); err != nil {
+		t.Logf("closing client: %v", err)
+	}
+
+	if prompts != 1 {
+		t.Errorf("prompted %d times, want 1", prompts)
+	}
+	if got := <-offered; strings.Join(got, ",") != "stale,right" {
+		t.Errorf("server was offered %q, want the stored password then the prompted one", got)
+	}
+}
+
+func TestPasswordAuthStoredPasswordOnly(t *testing.T) {
+	if passwordAuth("", nil) != nil {
+		t.Error("passwordAuth() without a password or prompt should return nil")
+	}
+
+	address, offered := passwordServer(t, "right")
+	client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            "admin",
+		Auth:            []ssh.AuthMethod{passwordAuth("right", nil)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	if err := client.Close(); err != nil {
+		t.Logf("closing client: %v", err)
+	}
+	if got := <-offered; len(got) != 1 {
+		t.Errorf("server was offered %q, want only the stored password", got)
+	}
+}
@@ -0,0 +1,220 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// SetDryRun makes the client print commands that change the NAS to w instead of
+// running them. Commands that only read state still run, so an operation can be
+// planned as far as possible; nil turns dry-run mode off.
+func (c *Client) SetDryRun(w io.Writer) {
+	c.dryRun = w
+}
+
+// DryRun reports whether the client is in dry-run mode
+func (c *Client) DryRun() bool {
+	return c.dryRun != nil
+}
+
+// Skip prints an operation that would change the NAS, such as an SFTP write, and
+// reports whether to skip it because the client is in dry-run mode
+func (c *Client) Skip(operation string) bool {
+	if c.dryRun == nil {
+		return false
+	}
+	fmt.Fprintf(c.dryRun, "[dry-run] %s\n", strings.TrimSpace(operation))
+	c.log().Info("dry-run skipped", "operation", strings.TrimSpace(operation))
+	return true
+}
+
+// skipCommand prints a mutating command in dry-run mode and reports whether to skip it
+func (c *Client) skipCommand(command string) bool {
+	if c.dryRun == nil || ReadOnly(command) {
+		return false
+	}
+	return c.Skip(dedent(command))
+}
+
+// dedent strips the indentation of multi-line commands built from Go raw strings
+func dedent(command string) string {
+	lines := strings.Split(strings.TrimSpace(command), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimLeft(lines[i], " \t")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// readOnlyPrograms only read state, whatever their arguments; output redirection to
+// a file is checked separately
+var readOnlyPrograms = map[string]bool{
+	"[": true, "awk": true, "basename": true, "cat": true, "cd": true, "command": true,
+	"cut": true, "date": true, "df": true, "dirname": true, "du": true, "echo": true,
+	"false": true, "free": true, "getcfg": true, "getsysinfo": true, "grep": true, "head": true, "hostname": true,
+	"id": true, "ls": true, "lsblk": true, "lspci": true, "lsusb": true, "md5sum": true,
+	"nproc": true, "pgrep": true, "printf": true, "ps": true, "read": true, "readlink": true,
+	"realpath": true, "seq": true, "sha256sum": true, "sleep": true, "sort": true, "stat": true,
+	"tail": true, "test": true, "tr": true, "true": true, "uname": true, "uniq": true,
+	"uptime": true, "wc": true, "which": true, "whoami": true,
+}
+
+// readOnlySubcommands are the subcommands of programs whose first argument says
+// whether they change anything
+var readOnlySubcommands = map[string]map[string]bool{
+	"zpool":    {"list": true, "status": true, "get": true, "iostat": true},
+	"zfs":      {"list": true, "get": true},
+	"qemu-img": {"info": true, "measure": true, "compare": true, "map": true, "--version": true},
+	"brctl":    {"show": true, "showmacs": true, "showstp": true},
+	"virsh": {
+		"capabilities": true, "checkpoint-dumpxml": true, "checkpoint-list": true,
+		"domblkinfo": true, "domblklist": true, "domblkstat": true, "domcapabilities": true,
+		"domdisplay": true, "domfsinfo": true, "domid": true, "domif-getlink": true,
+		"domifaddr": true, "domiflist": true, "domifstat": true, "dominfo": true,
+		"domjobinfo": true, "dommemstat": true, "domname": true, "domstate": true,
+		"domstats": true, "domuuid": true, "dumpxml": true, "freecell": true, "list": true,
+		"net-dhcp-leases": true, "net-dumpxml": true, "net-info": true, "net-list": true,
+		"nodedev-dumpxml": true, "nodedev-list": true, "nodeinfo": true, "pool-list": true,
+		"snapshot-dumpxml": true, "snapshot-info": true, "snapshot-list": true,
+		"snapshot-parent": true, "sysinfo": true, "vcpuinfo": true, "version": true,
+		"vncdisplay": true, "vol-list": true,
+	},
+}
+
+// virshQueries are virsh subcommands that only report settings when given no more
+// than this many positional arguments and no options beyond virshQueryOptions
+var virshQueries = map[string]int{
+	"blkdeviotune": 1, "domiftune": 1, "emulatorpin": 0, "memtune": 0,
+	"schedinfo": 0, "snapshot-current": 0, "vcpupin": 0,
+}
+
+// virshQueryOptions select what a virsh query reports without changing it
+var virshQueryOptions = map[string]bool{
+	"--domain": true, "--config": true, "--live": true, "--current": true, "--name": true,
+}
+
+// agentReadPattern matches guest agent commands that only read guest state
+var agentReadPattern = regexp.MustCompile(`"execute":\s*"(guest-ping|guest-info|guest-get-[a-z-]+|guest-network-get-interfaces|guest-exec-status|guest-fsfreeze-status)"`)
+
+// shellKeywords start or continue compound commands; the words after them are
+// checked as a command, except after shellListKeywords
+var shellKeywords = map[string]bool{
+	"!": true, "{": true, "}": true, "do": true, "done": true, "elif": true, "else": true,
+	"esac": true, "fi": true, "if": true, "then": true, "time": true, "until": true, "while": true,
+}
+
+// shellListKeywords are followed by words rather than a command
+var shellListKeywords = map[string]bool{
+	"break": true, "case": true, "continue": true, "exit": true, "export": true, "for": true, "local": true, "return": true,
+	"set": true, "unset": true,
+}
+
+// ReadOnly reports whether a shell command only reads state on the NAS. It knows the
+// commands this tool runs; anything it doesn't recognize is treated as a change.
+func ReadOnly(command string) bool {
+	script := parseShell(command)
+	if script.writes {
+		return false
+	}
+	for _, words := range script.commands {
+		if !readOnlySimpleCommand(words, script.functions) {
+			return false
+		}
+	}
+	return true
+}
+
+// readOnlySimpleCommand reports whether the words of one simple command only read state
+func readOnlySimpleCommand(words []string, functions map[string]bool) bool {
+	for len(words) > 0 {
+		word := words[0]
+		switch {
+		case shellListKeywords[word]:
+			return true
+		case shellKeywords[word], strings.Contains(word, "=") && !strings.HasPrefix(word, "-"):
+			words = words[1:] // Keyword or variable assignment before the command
+		default:
+			return readOnlyProgram(path.Base(word), words[1:], functions)
+		}
+	}
+	return true
+}
+
+// readOnlyProgram reports whether running program with args only reads state
+func readOnlyProgram(program string, args []string, functions map[string]bool) bool {
+	if readOnlyPrograms[program] || functions[program] {
+		return true
+	}
+
+	switch program {
+	case "sed":
+		return !containsPrefix(args, "-i")
+	case "find":
+		for _, arg := range args {
+			if arg == "-delete" || strings.HasPrefix(arg, "-exec") || strings.HasPrefix(arg, "-ok") {
+				return false
+			}
+		}
+		return true
+	case "mount":
+		return len(args) == 0
+	case "ip":
+		return len(args) > 0 && (args[len(args)-1] == "show" || containsPrefix(args, "show"))
+	case "qemu-system-x86_64":
+		return containsPrefix(args, "help") || containsPrefix(args, "-version") || containsPrefix(args, "--version")
+	case "qemu-img":
+		if len(args) > 0 && args[0] == "check" {
+			return !containsPrefix(args, "-r")
+		}
+	case "virsh":
+		return readOnlyVirsh(args)
+	}
+
+	subcommands, ok := readOnlySubcommands[program]
+	return ok && len(args) > 0 && subcommands[args[0]]
+}
+
+// readOnlyVirsh reports whether a virsh command line only reads state
+func readOnlyVirsh(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	subcommand, args := args[0], args[1:]
+	if readOnlySubcommands["virsh"][subcommand] {
+		return true
+	}
+	if subcommand == "qemu-agent-command" {
+		return agentReadPattern.MatchString(strings.Join(args, " "))
+	}
+	maxPositional, ok := virshQueries[subcommand]
+	if !ok {
+		return false
+	}
+
+	positional := 0
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--domain":
+			i++ // The domain name follows
+		case strings.HasPrefix(args[i], "-"):
+			if !virshQueryOptions[args[i]] {
+				return false
+			}
+		default:
+			positional++
+		}
+	}
+	return positional <= maxPositional
+}
+
+// containsPrefix reports whether any argument starts with prefix
+func containsPrefix(args []string, prefix string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, prefix) {
+			return true
+		}
+	}
+	return false
+}
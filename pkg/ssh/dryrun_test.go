@@ -0,0 +1,92 @@
+package ssh
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		command string
+		want    bool
+	}{
+		{"list shares", "ls -la /share/ | grep CACHEDEV", true},
+		{"disk usage", "df -BG '/share/CACHEDEV1_DATA' | tail -n 1", true},
+		{"virsh list", "\n\t\texport LD_LIBRARY_PATH=/qvs/usr/lib:/qvs/usr/lib64/\n\t\texport PATH=$PATH:/qvs/usr/bin/\n\t\tvirsh list --all\n\t", true},
+		{"virsh dumpxml", "virsh dumpxml --domain 'web server' --inactive", true},
+		{"virsh start", "virsh start --domain 'web'", false},
+		{"virsh define", "virsh define /tmp/qnap-vm-web.xml", false},
+		{"vcpupin query", "virsh vcpupin --domain 'web' --config", true},
+		{"vcpupin set", "virsh vcpupin --domain 'web' --vcpu 0 --cpulist '2' --config", false},
+		{"blkdeviotune query", "virsh blkdeviotune --domain 'web' 'vda' --live", true},
+		{"blkdeviotune set", "virsh blkdeviotune --domain 'web' 'vda' --live --total-iops-sec 100", false},
+		{"snapshot-current name", "virsh snapshot-current --domain 'web' --name", true},
+		{"agent ping", `virsh qemu-agent-command --domain 'web' '{"execute":"guest-ping"}'`, true},
+		{"agent fstrim", `virsh qemu-agent-command --block --domain 'web' '{"execute":"guest-fstrim"}'`, false},
+		{"write xml", "cat > '/tmp/qnap-vm-web.xml' << 'EOF'\n<domain type='kvm'/>\nEOF", false},
+		{"redirect to file", "echo 1 > /sys/module/kvm_intel/parameters/nested", false},
+		{"append to file", "echo x >> /etc/config/crontab", false},
+		{"redirect to null", "which zpool >/dev/null 2>&1 && zpool list -H", true},
+		{"stderr to null", "cat /proc/cpuinfo 2>/dev/null | grep -c processor", true},
+		{"mkdir", "mkdir -p '/share/CACHEDEV1_DATA/.qnap-vm/isos'", false},
+		{"rm", "rm -f '/tmp/qnap-vm-web.xml'", false},
+		{"qemu-img info", "/qvs/usr/bin/qemu-img info --output=json '/share/x.qcow2'", true},
+		{"qemu-img create", "/qvs/usr/bin/qemu-img create -f qcow2 '/share/x.qcow2' 20G", false},
+		{"qemu-img check", "qemu-img check '/share/x.qcow2'", true},
+		{"qemu-img repair", "qemu-img check -r all '/share/x.qcow2'", false},
+		{"zfs list", "zfs list -H -o name", true},
+		{"zfs create", "zfs create -p 'zpool1/qnap-vm/web'", false},
+		{"mount list", "mount | grep usb", true},
+		{"mount device", "mount /dev/sdb1 /mnt", false},
+		{"sed in place", "sed -i 's/a/b/' /etc/config/qpkg.conf", false},
+		{"find delete", "find /tmp -name 'qnap-vm-*' -delete", false},
+		{"substitution", `dev=$(df -P '/share' 2>/dev/null | awk 'NR==2 {print $1}')`, true},
+		{"mutating substitution", `x=$(rm -rf /share/x)`, false},
+		{"sysinfo", `echo "model $(getsysinfo model 2>/dev/null)"`, true},
+		{"unknown program", "vendor-tool --reset", false},
+		{"rotational script", strings.ReplaceAll(`dev=$(df -P %s 2>/dev/null | awk 'NR==2 {print $1}')
+case "$dev" in /dev/*) ;; *) exit 0 ;; esac
+walk() {
+	b=/sys/class/block/$1
+	if [ -n "$(ls "$b/slaves" 2>/dev/null)" ]; then
+		for s in "$b"/slaves/*; do walk "$(basename "$s")"; done
+		return
+	fi
+	[ -e "$b/queue/rotational" ] || b=$b/..
+	cat "$b/queue/rotational" 2>/dev/null
+}
+walk "$(basename "$(readlink -f "$dev")")"`, "%s", "'/share/CACHEDEV1_DATA'"), true},
+		{"loop control", "for d in /QVS /KVM; do\n\t[ -d $d ] || continue\n\techo $d\n\tbreak\ndone", true},
+		{"case with change", "case \"$x\" in a) rm -f /tmp/a ;; esac", false},
+		{"subshell", "(cd /share && ls)", true},
+		{"mutating subshell", "(cd /share && touch x)", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ReadOnly(tt.command); got != tt.want {
+				t.Errorf("ReadOnly(%q) = %v, want %v", tt.command, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDryRunSkipsMutatingCommands(t *testing.T) {
+	var out bytes.Buffer
+	c := &Client{}
+	c.SetDryRun(&out)
+
+	// Mutating commands are printed and succeed without a connection
+	output, err := c.Execute("\n\t\tvirsh undefine --domain 'web'\n\t")
+	if err != nil || output != "" {
+		t.Fatalf("Execute() in dry-run = %q, %v", output, err)
+	}
+	if got := out.String(); got != "[dry-run] virsh undefine --domain 'web'\n" {
+		t.Errorf("dry-run output = %q", got)
+	}
+
+	// Read-only commands still run, and fail here because there is no connection
+	if _, err := c.Execute("virsh list --all"); err == nil {
+		t.Error("Execute() should run read-only commands in dry-run mode")
+	}
+}
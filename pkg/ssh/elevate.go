@@ -0,0 +1,85 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Elevation methods, for hosts where direct admin SSH is disabled and commands run
+// from an unprivileged account
+const (
+	ElevateSudo = "sudo"
+	ElevateSu   = "su"
+)
+
+// elevation wraps commands so they run with administrator rights
+type elevation struct {
+	method   string
+	user     string // Account to become; sudo's default (root) or admin for su when empty
+	password string // Answer to the password prompt; empty for passwordless sudo
+}
+
+// newElevation checks an elevation method and returns nil when there is none
+func newElevation(method, user, password string) (*elevation, error) {
+	switch method {
+	case "":
+		return nil, nil
+	case ElevateSudo:
+	case ElevateSu:
+		if password == "" {
+			return nil, fmt.Errorf("elevating with su needs the password of %s", suUser(user))
+		}
+	default:
+		return nil, fmt.Errorf("unknown elevation method '%s' (use sudo or su)", method)
+	}
+	return &elevation{method: method, user: user, password: password}, nil
+}
+
+// suUser is the account su switches to
+func suUser(user string) string {
+	if user == "" {
+		return "admin"
+	}
+	return user
+}
+
+// wrap returns the command line that runs command elevated. With a password, the
+// elevating tool reads it from the first line of stdin.
+func (e *elevation) wrap(command string) string {
+	if e.method == ElevateSu {
+		return fmt.Sprintf("su %s -c %s", Quote(suUser(e.user)), Quote(command))
+	}
+
+	args := []string{"sudo"}
+	if e.password == "" {
+		args = append(args, "-n")
+	} else {
+		args = append(args, "-S", "-p", "''")
+	}
+	if e.user != "" {
+		args = append(args, "-u", Quote(e.user))
+	}
+	return strings.Join(args, " ") + " sh -c " + Quote(command)
+}
+
+// shellCommand is wrap for the persistent shell, whose commands have no stdin: the
+// password comes from the printf builtin, so it never appears in a process listing
+func (e *elevation) shellCommand(command string) string {
+	if e.password == "" {
+		return e.wrap(command)
+	}
+	return fmt.Sprintf("printf '%%s\\n' %s | %s", Quote(e.password), e.wrap(command))
+}
+
+// input prefixes a session command's stdin with the password line
+func (e *elevation) input(stdin io.Reader) io.Reader {
+	if e.password == "" {
+		return stdin
+	}
+	password := strings.NewReader(e.password + "\n")
+	if stdin == nil {
+		return password
+	}
+	return io.MultiReader(password, stdin)
+}
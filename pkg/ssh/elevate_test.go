@@ -0,0 +1,69 @@
+package ssh
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestElevationWrap(t *testing.T) {
+	tests := []struct {
+		method, user, password string
+		wrap, shell            string
+	}{
+		{
+			method: ElevateSudo,
+			wrap:   `sudo -n sh -c 'virsh list --all'`,
+			shell:  `sudo -n sh -c 'virsh list --all'`,
+		},
+		{
+			method: ElevateSudo, password: "it's",
+			wrap:  `sudo -S -p '' sh -c 'virsh list --all'`,
+			shell: `printf '%s\n' 'it'\''s' | sudo -S -p '' sh -c 'virsh list --all'`,
+		},
+		{
+			method: ElevateSudo, user: "qvs", password: "secret",
+			wrap: `sudo -S -p '' -u 'qvs' sh -c 'virsh list --all'`,
+		},
+		{
+			method: ElevateSu, password: "secret",
+			wrap:  `su 'admin' -c 'virsh list --all'`,
+			shell: `printf '%s\n' 'secret' | su 'admin' -c 'virsh list --all'`,
+		},
+	}
+	for _, tt := range tests {
+		e, err := newElevation(tt.method, tt.user, tt.password)
+		if err != nil {
+			t.Fatalf("newElevation(%q) error = %v", tt.method, err)
+		}
+		if got := e.wrap("virsh list --all"); got != tt.wrap {
+			t.Errorf("wrap() = %s, want %s", got, tt.wrap)
+		}
+		if got := e.shellCommand("virsh list --all"); tt.shell != "" && got != tt.shell {
+			t.Errorf("shellCommand() = %s, want %s", got, tt.shell)
+		}
+	}
+}
+
+func TestElevationInput(t *testing.T) {
+	e, err := newElevation(ElevateSudo, "", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(e.input(strings.NewReader("disk data")))
+	if err != nil || string(data) != "secret\ndisk data" {
+		t.Errorf("input() = %q, %v", data, err)
+	}
+}
+
+func TestNewElevationErrors(t *testing.T) {
+	if e, err := newElevation("", "", ""); e != nil || err != nil {
+		t.Errorf("no method = %v, %v", e, err)
+	}
+	if _, err := newElevation(ElevateSu, "", ""); err == nil {
+		t.Error("su without a password should fail")
+	}
+	if _, err := newElevation("doas", "", ""); err == nil {
+		t.Error("unknown method should fail")
+	}
+}
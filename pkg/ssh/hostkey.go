@@ -0,0 +1,103 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ErrHostKeyRejected is returned when a host's key is unknown and was not trusted
+var ErrHostKeyRejected = errors.New("host key not trusted")
+
+// getHostKeyCallback verifies host keys against the known_hosts file. Unknown hosts are
+// trusted on first use if cfg.HostKeyPrompt agrees, and then recorded; changed keys are
+// always refused, as they may mean someone is intercepting the connection.
+func getHostKeyCallback(cfg Config) (ssh.HostKeyCallback, error) {
+	if cfg.HostKeyFingerprint != "" {
+		return pinnedHostKey(cfg.HostKeyFingerprint), nil
+	}
+
+	path := cfg.KnownHostsFile
+	if path == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate known_hosts: %w", err)
+		}
+		path = filepath.Join(homeDir, ".ssh", "known_hosts")
+	}
+
+	known, err := knownhosts.New(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		if known != nil {
+			err := known(host, remote, key)
+			var keyErr *knownhosts.KeyError
+			if !errors.As(err, &keyErr) {
+				// Known, revoked, or an unusable address
+				return err
+			}
+			if len(keyErr.Want) > 0 {
+				want := keyErr.Want[0]
+				return fmt.Errorf("host key for %s has changed: it is now %s, but %s:%d expects %s; someone may be intercepting the connection, or the NAS was reinstalled (remove the old line to trust the new key)",
+					host, ssh.FingerprintSHA256(key), want.Filename, want.Line, ssh.FingerprintSHA256(want.Key))
+			}
+		}
+
+		if cfg.StrictHostKey || cfg.HostKeyPrompt == nil {
+			return fmt.Errorf("%w: %s is not in %s (fingerprint %s)", ErrHostKeyRejected, host, path, ssh.FingerprintSHA256(key))
+		}
+		trusted, err := cfg.HostKeyPrompt(host, ssh.FingerprintSHA256(key))
+		if err != nil {
+			return err
+		}
+		if !trusted {
+			return fmt.Errorf("%w: %s (fingerprint %s)", ErrHostKeyRejected, host, ssh.FingerprintSHA256(key))
+		}
+		return addKnownHost(path, host, remote, key)
+	}, nil
+}
+
+// pinnedHostKey accepts only the host key with the given fingerprint, so a changed key
+// is caught even on machines without a known_hosts entry for the NAS
+func pinnedHostKey(fingerprint string) ssh.HostKeyCallback {
+	return func(host string, _ net.Addr, key ssh.PublicKey) error {
+		if actual := ssh.FingerprintSHA256(key); actual != fingerprint {
+			return fmt.Errorf("host key for %s does not match the pinned fingerprint: it is %s, but the configuration expects %s; someone may be intercepting the connection, or the NAS was reinstalled", host, actual, fingerprint)
+		}
+		return nil
+	}
+}
+
+// addKnownHost appends a host's key to the known_hosts file, creating it if needed
+func addKnownHost(path, host string, remote net.Addr, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to record host key: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to record host key: %w", err)
+	}
+
+	addresses := []string{knownhosts.Normalize(host)}
+	if remote != nil {
+		if ip := knownhosts.Normalize(remote.String()); ip != addresses[0] {
+			addresses = append(addresses, ip)
+		}
+	}
+	_, err = fmt.Fprintln(file, knownhosts.Line(addresses, key))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record host key: %w", err)
+	}
+	return nil
+}
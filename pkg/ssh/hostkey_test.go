@@ -0,0 +1,102 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyTrustOnFirstUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.1.100"), Port: 22}
+	key := newHostKey(t)
+
+	prompts := 0
+	cfg := Config{KnownHostsFile: path, HostKeyPrompt: func(host, fingerprint string) (bool, error) {
+		prompts++
+		return true, nil
+	}}
+	callback, err := getHostKeyCallback(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := callback("nas.local:22", remote, key); err != nil {
+		t.Fatalf("trusting a new key failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.HasPrefix(string(data), "nas.local,192.168.1.100 ssh-ed25519 ") {
+		t.Errorf("known_hosts = %q, %v", data, err)
+	}
+
+	// Once recorded, the key is trusted without asking and a different key is refused
+	callback, err = getHostKeyCallback(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := callback("nas.local:22", remote, key); err != nil || prompts != 1 {
+		t.Errorf("known key: error %v after %d prompts", err, prompts)
+	}
+	if err := callback("nas.local:22", remote, newHostKey(t)); err == nil || !strings.Contains(err.Error(), "has changed") {
+		t.Errorf("changed key error = %v", err)
+	}
+}
+
+func TestHostKeyRejected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.1.100"), Port: 22}
+	accept := func(string, string) (bool, error) { return true, nil }
+	decline := func(string, string) (bool, error) { return false, nil }
+
+	for name, cfg := range map[string]Config{
+		"strict":    {KnownHostsFile: path, HostKeyPrompt: accept, StrictHostKey: true},
+		"no prompt": {KnownHostsFile: path},
+		"declined":  {KnownHostsFile: path, HostKeyPrompt: decline},
+	} {
+		callback, err := getHostKeyCallback(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := callback("nas.local:22", remote, newHostKey(t)); !errors.Is(err, ErrHostKeyRejected) {
+			t.Errorf("%s: error = %v, want ErrHostKeyRejected", name, err)
+		}
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("rejected keys must not be recorded: %v", err)
+	}
+}
+
+func TestHostKeyPinned(t *testing.T) {
+	key := newHostKey(t)
+	cfg := Config{KnownHostsFile: filepath.Join(t.TempDir(), "known_hosts"), HostKeyFingerprint: ssh.FingerprintSHA256(key), StrictHostKey: true}
+
+	callback, err := getHostKeyCallback(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := callback("nas.local:22", nil, key); err != nil {
+		t.Errorf("pinned key refused: %v", err)
+	}
+	if err := callback("nas.local:22", nil, newHostKey(t)); err == nil || !strings.Contains(err.Error(), "pinned fingerprint") {
+		t.Errorf("other key error = %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// interruptGrace is how long a cancelled command gets to stop before its shell is
+// abandoned
+var interruptGrace = 5 * time.Second
+
+// remoteShell is a long-lived sh on the NAS that runs commands one after another. Each
+// Execute otherwise opens a session, which makes sshd start a login shell; on slow NAS
+// CPUs that start-up dominates commands such as 'list' that run one virsh call per VM.
+type remoteShell struct {
+	stdin     io.WriteCloser
+	stdout    *bufio.Reader
+	marker    string // Printed with the process ID and exit status of each command
+	close     func() error
+	interrupt func(pid int) error // Stops a command's process tree from outside the shell
+	broken    bool                // The shell ended or its output could not be read
+}
+
+// startShell starts a remote shell in a new session on client
+func startShell(client *ssh.Client) (*remoteShell, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, closeAfter(session, err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, closeAfter(session, err)
+	}
+	if err := session.Start("exec sh"); err != nil {
+		return nil, closeAfter(session, err)
+	}
+
+	// The shell is busy waiting for the command, so the kill runs in a session of its
+	// own. It is not counted in the pool: it is short and only needed when cancelling.
+	interrupt := func(pid int) error {
+		killer, err := client.NewSession()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := killer.Close(); err != nil {
+				// The session has usually ended with the command
+			}
+		}()
+		return killer.Run(killTreeScript(pid))
+	}
+	return newRemoteShell(stdin, stdout, session.Close, interrupt)
+}
+
+// closeAfter closes a session that failed to become a shell and returns err
+func closeAfter(session *ssh.Session, err error) error {
+	if closeErr := session.Close(); closeErr != nil {
+		return fmt.Errorf("%w (close error: %v)", err, closeErr)
+	}
+	return err
+}
+
+// killTreeScript terminates a process and its descendants, found through /proc since
+// the NAS's busybox may lack pkill -P
+func killTreeScript(pid int) string {
+	return fmt.Sprintf(`kill_tree() {
+	for stat in /proc/[0-9]*/stat; do
+		read -r child _ _ parent _ < "$stat" 2>/dev/null || continue
+		[ "$parent" = "$1" ] && kill_tree "$child"
+	done
+	kill -TERM "$1" 2>/dev/null || true
+}
+kill_tree %d`, pid)
+}
+
+// newRemoteShell wraps the input and output of a running shell
+func newRemoteShell(stdin io.WriteCloser, stdout io.Reader, close func() error, interrupt func(pid int) error) (*remoteShell, error) {
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	return &remoteShell{
+		stdin:     stdin,
+		stdout:    bufio.NewReader(stdout),
+		marker:    "__qnap_vm_done_" + hex.EncodeToString(token),
+		close:     close,
+		interrupt: interrupt,
+	}, nil
+}
+
+// shellResult is a command's output and error, and whether the shell survived it
+type shellResult struct {
+	output string
+	err    error
+	broken bool
+}
+
+// run runs command and returns its combined output, as a session's CombinedOutput would.
+// The command is evaluated in a background subshell with stdin closed, so exit, cd,
+// variables and syntax errors stay contained and it cannot read the following commands.
+// When ctx is cancelled the command's processes are terminated and the shell is kept.
+func (s *remoteShell) run(ctx context.Context, command string) (string, error) {
+	script := fmt.Sprintf("(eval %s) </dev/null 2>&1 &\nprintf '\\n%s-pid %%d\\n' $!\nwait $!; printf '\\n%s %%d\\n' $?\n",
+		Quote(command), s.marker, s.marker)
+	if _, err := io.WriteString(s.stdin, script); err != nil {
+		s.broken = true
+		return "", fmt.Errorf("remote shell ended: %w", err)
+	}
+
+	pids := make(chan int, 1)
+	done := make(chan shellResult, 1)
+	go func() { done <- s.read(pids) }()
+
+	select {
+	case result := <-done:
+		s.broken = result.broken
+		return result.output, result.err
+	case <-ctx.Done():
+	}
+
+	// Stop the command; the shell then reports its exit status and stays usable
+	cancelled := fmt.Errorf("command cancelled: %w", ctx.Err())
+	grace := time.After(interruptGrace)
+	select {
+	case pid := <-pids:
+		if err := s.interrupt(pid); err != nil {
+			s.broken = true
+			return "", fmt.Errorf("%w (failed to stop it: %v)", cancelled, err)
+		}
+	case result := <-done:
+		s.broken = result.broken
+		return result.output, result.err
+	case <-grace:
+		s.broken = true
+		return "", cancelled
+	}
+	select {
+	case result := <-done:
+		s.broken = result.broken
+		return result.output, cancelled
+	case <-grace:
+		// Closing the abandoned shell ends the read
+		s.broken = true
+		return "", cancelled
+	}
+}
+
+// read collects a command's output up to the marker with its exit status, sending the
+// process ID of its subshell to pids when the shell reports it
+func (s *remoteShell) read(pids chan<- int) shellResult {
+	var output strings.Builder
+	for {
+		line, err := s.stdout.ReadString('\n')
+		if err != nil {
+			output.WriteString(line)
+			return shellResult{output: output.String(), err: fmt.Errorf("remote shell ended: %w", err), broken: true}
+		}
+
+		// Each marker line follows a newline the shell printed; drop it from the output
+		if rest, ok := strings.CutPrefix(line, s.marker+"-pid "); ok {
+			trimmed := strings.TrimSuffix(output.String(), "\n")
+			output.Reset()
+			output.WriteString(trimmed)
+			if pid, err := strconv.Atoi(strings.TrimSpace(rest)); err == nil {
+				pids <- pid
+			}
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, s.marker+" "); ok {
+			result := strings.TrimSuffix(output.String(), "\n")
+			status, err := strconv.Atoi(strings.TrimSpace(rest))
+			if err != nil {
+				return shellResult{output: result, err: fmt.Errorf("remote shell returned an invalid exit status %q", strings.TrimSpace(rest)), broken: true}
+			}
+			if status != 0 {
+				return shellResult{output: result, err: fmt.Errorf("command failed: Process exited with status %d", status)}
+			}
+			return shellResult{output: result}
+		}
+		output.WriteString(line)
+	}
+}
+
+// Close ends the shell
+func (s *remoteShell) Close() error {
+	if err := s.stdin.Close(); err != nil {
+		// The shell may already have exited
+	}
+	return s.close()
+}
@@ -0,0 +1,112 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startLocalShell starts a remote shell that is a local sh, for tests
+func startLocalShell() (*remoteShell, error) {
+	cmd := exec.Command("sh")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	interrupt := func(pid int) error {
+		return exec.Command("sh", "-c", killTreeScript(pid)).Run()
+	}
+	return newRemoteShell(stdin, stdout, cmd.Wait, interrupt)
+}
+
+// localShell starts a local shell that is closed when the test ends
+func localShell(t *testing.T) *remoteShell {
+	t.Helper()
+	shell, err := startLocalShell()
+	if err != nil {
+		t.Skipf("no local sh: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := shell.Close(); err != nil && !shell.broken {
+			t.Errorf("Close() error = %v", err)
+		}
+	})
+	return shell
+}
+
+func TestRemoteShellRun(t *testing.T) {
+	shell := localShell(t)
+
+	tests := []struct {
+		command string
+		output  string
+		failed  bool
+	}{
+		{command: "echo hello", output: "hello\n"},
+		{command: "printf 'no newline'", output: "no newline"},
+		{command: "echo out; echo err >&2", output: "out\nerr\n"},
+		{command: "echo partial; exit 3", output: "partial\n", failed: true},
+		{command: "export LD_LIBRARY_PATH=/QVS/usr/lib\n\t\tcd /\n\t\techo \"$LD_LIBRARY_PATH\"", output: "/QVS/usr/lib\n"},
+		{command: "echo \"${LD_LIBRARY_PATH:-unset}\"", output: "unset\n"},
+		{command: "echo 'unterminated", failed: true},
+		{command: "cat", output: ""},
+		{command: "echo still running", output: "still running\n"},
+	}
+	for _, tt := range tests {
+		output, err := shell.run(context.Background(), tt.command)
+		if tt.failed != (err != nil) {
+			t.Errorf("run(%q) error = %v, want failed = %v", tt.command, err, tt.failed)
+		}
+		if tt.command != "echo 'unterminated" && output != tt.output {
+			t.Errorf("run(%q) = %q, want %q", tt.command, output, tt.output)
+		}
+	}
+	if shell.broken {
+		t.Error("shell marked broken after commands that failed on their own")
+	}
+}
+
+func TestRemoteShellEnded(t *testing.T) {
+	shell := localShell(t)
+
+	// $$ is the shell itself, not the subshell running the command
+	if _, err := shell.run(context.Background(), "kill -9 $$"); err == nil || !strings.Contains(err.Error(), "remote shell ended") {
+		t.Errorf("run() after the shell died error = %v", err)
+	}
+	if !shell.broken {
+		t.Error("shell not marked broken after it ended")
+	}
+}
+
+func TestRemoteShellCancel(t *testing.T) {
+	shell := localShell(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := shell.run(ctx, "echo started; sleep 30 | cat; echo never")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("run() error = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("cancelled command took %s", elapsed)
+	}
+
+	// The command's processes are gone and the shell runs the next command
+	if shell.broken {
+		t.Fatal("shell marked broken after cancelling a command")
+	}
+	if output, err := shell.run(context.Background(), "echo after"); err != nil || output != "after\n" {
+		t.Errorf("run() after cancel = %q, %v", output, err)
+	}
+}
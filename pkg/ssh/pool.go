@@ -0,0 +1,112 @@
+package ssh
+
+import "sync"
+
+// sessionPool bounds the sessions a client has open, so concurrent operations such as
+// bulk snapshots or exporter scrapes run side by side without tripping the server's
+// MaxSessions limit, and keeps idle persistent shells for reuse. Idle shells count as
+// open sessions and are closed when a plain session needs their slot.
+type sessionPool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	max     int
+	open    int            // Sessions open, including idle shells
+	idle    []*remoteShell // Shells waiting for a command
+	noShell bool           // Shells cannot be started; commands use a session each
+}
+
+// init sets the pool's limit
+func (p *sessionPool) init(max int) {
+	p.cond = sync.NewCond(&p.mu)
+	p.max = max
+}
+
+// getShell returns an idle shell, or one started with start if there is room. It waits
+// while every slot is busy, and returns nil once a shell has failed to start.
+func (p *sessionPool) getShell(start func() (*remoteShell, error)) *remoteShell {
+	p.mu.Lock()
+	for {
+		if n := len(p.idle); n > 0 {
+			shell := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			p.mu.Unlock()
+			return shell
+		}
+		if p.noShell {
+			p.mu.Unlock()
+			return nil
+		}
+		if p.open < p.max {
+			break
+		}
+		p.cond.Wait()
+	}
+	p.open++
+	p.mu.Unlock()
+
+	shell, err := start()
+	if err != nil {
+		p.mu.Lock()
+		p.noShell = true
+		p.open--
+		p.cond.Broadcast()
+		p.mu.Unlock()
+		return nil
+	}
+	return shell
+}
+
+// putShell returns a shell after a command. A shell that ended is closed and frees its slot.
+func (p *sessionPool) putShell(shell *remoteShell) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if shell.broken {
+		if err := shell.Close(); err != nil {
+			// The shell has already ended
+		}
+		p.open--
+	} else {
+		p.idle = append(p.idle, shell)
+	}
+	p.cond.Broadcast()
+}
+
+// acquire waits for a slot for a plain session, closing the oldest idle shell if that
+// is the only way to get one
+func (p *sessionPool) acquire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.open >= p.max {
+		if len(p.idle) > 0 {
+			if err := p.idle[0].Close(); err != nil {
+				// Closing only frees the slot; the shell's state does not matter
+			}
+			p.idle = p.idle[1:]
+			p.open--
+			continue
+		}
+		p.cond.Wait()
+	}
+	p.open++
+}
+
+// release frees a plain session's slot
+func (p *sessionPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.open--
+	p.cond.Broadcast()
+}
+
+// closeIdle closes the idle shells, before the connection closes
+func (p *sessionPool) closeIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, shell := range p.idle {
+		if err := shell.Close(); err != nil {
+			// The connection is closed next, which ends the shell anyway
+		}
+	}
+	p.open -= len(p.idle)
+	p.idle = nil
+}
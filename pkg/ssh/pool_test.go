@@ -0,0 +1,73 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSessionPoolBoundsShells(t *testing.T) {
+	var pool sessionPool
+	pool.init(2)
+	defer pool.closeIdle()
+
+	var started atomic.Int32
+	start := func() (*remoteShell, error) {
+		started.Add(1)
+		return startLocalShell()
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shell := pool.getShell(start)
+			if shell == nil {
+				errs <- fmt.Errorf("no shell for command %d", i)
+				return
+			}
+			output, err := shell.run(context.Background(), fmt.Sprintf("echo %d", i))
+			pool.putShell(shell)
+			if err != nil || output != fmt.Sprintf("%d\n", i) {
+				errs <- fmt.Errorf("command %d = %q, %v", i, output, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if n := started.Load(); n > 2 {
+		t.Errorf("started %d shells, want at most 2", n)
+	}
+	if pool.open != len(pool.idle) || pool.open > 2 {
+		t.Errorf("open = %d with %d idle shells", pool.open, len(pool.idle))
+	}
+
+	// A plain session takes the slot of an idle shell when the pool is full
+	first, second := pool.getShell(start), pool.getShell(start)
+	pool.putShell(first)
+	pool.putShell(second)
+	pool.acquire()
+	if pool.open != 2 || len(pool.idle) != 1 {
+		t.Errorf("after acquire: open = %d with %d idle shells", pool.open, len(pool.idle))
+	}
+	pool.release()
+}
+
+func TestSessionPoolWithoutShells(t *testing.T) {
+	var pool sessionPool
+	pool.init(2)
+
+	start := func() (*remoteShell, error) { return nil, errors.New("shell requests refused") }
+	if shell := pool.getShell(start); shell != nil || !pool.noShell || pool.open != 0 {
+		t.Errorf("getShell() = %v, noShell = %v, open = %d", shell, pool.noShell, pool.open)
+	}
+}
@@ -0,0 +1,79 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IsUnreachable reports whether a Connect error means nothing answered on the port:
+// the connection was refused, timed out or had no route. Authentication and host key
+// failures are not, since an SSH server did answer.
+func IsUnreachable(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// ProbePorts returns the ports of host, in the order given, on which an SSH server
+// answers within timeout. The ports are tried concurrently.
+func ProbePorts(ctx context.Context, host string, ports []int, timeout time.Duration) []int {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	answered := make([]bool, len(ports))
+	var wg sync.WaitGroup
+	for i, port := range ports {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			answered[i] = speaksSSH(ctx, net.JoinHostPort(host, strconv.Itoa(port)))
+		}()
+	}
+	wg.Wait()
+
+	var open []int
+	for i, port := range ports {
+		if answered[i] {
+			open = append(open, port)
+		}
+	}
+	return open
+}
+
+// speaksSSH reports whether the server at address sends an SSH identification string,
+// as every SSH server does before the client says anything (RFC 4253 section 4.2)
+func speaksSSH(ctx context.Context, address string) bool {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return false
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			// Only the banner was read; close errors do not affect the result
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return false
+		}
+	}
+
+	// Servers may send other lines before the identification string
+	reader := bufio.NewReader(conn)
+	for range 10 {
+		line, err := reader.ReadString('\n')
+		if strings.HasPrefix(line, "SSH-") {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+	return false
+}
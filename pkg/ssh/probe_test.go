@@ -0,0 +1,87 @@
+package ssh
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// listen serves banner to every connection on a local port and returns the port
+func listen(t *testing.T, banner string) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := listener.Close(); err != nil {
+			t.Log(err)
+		}
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := conn.Write([]byte(banner)); err != nil {
+				t.Log(err)
+			}
+			if err := conn.Close(); err != nil {
+				t.Log(err)
+			}
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// closedPort returns a local port with nothing listening on it
+func closedPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	if err := listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+func TestProbePorts(t *testing.T) {
+	ssh := listen(t, "SSH-2.0-OpenSSH_8.9\r\n")
+	preamble := listen(t, "Authorized use only\r\nSSH-2.0-dropbear\r\n")
+	web := listen(t, "HTTP/1.1 400 Bad Request\r\n\r\n")
+	closed := closedPort(t)
+
+	ports := []int{closed, web, preamble, ssh}
+	got := ProbePorts(context.Background(), "127.0.0.1", ports, 2*time.Second)
+	if want := []int{preamble, ssh}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProbePorts = %v, want %v", got, want)
+	}
+}
+
+func TestIsUnreachable(t *testing.T) {
+	client, err := NewClient(Config{Host: "127.0.0.1", Port: closedPort(t), Username: "admin",
+		Password: "x", KnownHostsFile: t.TempDir() + "/known_hosts", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Connect()
+	if !IsUnreachable(err) {
+		t.Errorf("IsUnreachable(%v) = false for a closed port", err)
+	}
+
+	// A server answered, so this is not a port problem
+	client, err = NewClient(Config{Host: "127.0.0.1", Port: listen(t, "SSH-2.0-broken\r\n"), Username: "admin",
+		Password: "x", KnownHostsFile: t.TempDir() + "/known_hosts", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Connect(); err == nil || IsUnreachable(err) {
+		t.Errorf("IsUnreachable(%v) = true after a handshake", err)
+	}
+}
@@ -0,0 +1,99 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// progressWidth is the number of cells in the rendered progress bar
+const progressWidth = 30
+
+// progressInterval limits how often the progress bar is redrawn
+const progressInterval = 200 * time.Millisecond
+
+// progressBar renders transfer progress as a single self-overwriting line
+type progressBar struct {
+	out      io.Writer
+	total    int64
+	done     int64
+	start    time.Time
+	lastDraw time.Time
+}
+
+// newProgressBar creates a progress bar writing to out; a nil out disables rendering
+func newProgressBar(out io.Writer, total int64) *progressBar {
+	return &progressBar{out: out, total: total, start: time.Now()}
+}
+
+// Write counts bytes passing through the bar and redraws it periodically
+func (p *progressBar) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if p.out != nil && time.Since(p.lastDraw) >= progressInterval {
+		p.draw()
+	}
+	return len(b), nil
+}
+
+// Finish draws the final state and ends the progress line
+func (p *progressBar) Finish() {
+	if p.out == nil {
+		return
+	}
+	p.draw()
+	fmt.Fprintln(p.out)
+}
+
+// draw renders the current progress line
+func (p *progressBar) draw() {
+	p.lastDraw = time.Now()
+	fmt.Fprintf(p.out, "\r%s", renderProgress(p.done, p.total, time.Since(p.start)))
+}
+
+// renderProgress formats a progress line with a bar, percentage, byte counts, rate and ETA
+func renderProgress(done, total int64, elapsed time.Duration) string {
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(done) / elapsed.Seconds()
+	}
+
+	if total <= 0 {
+		return fmt.Sprintf("%s  %s/s", humanBytes(done), humanBytes(int64(rate)))
+	}
+
+	fraction := float64(done) / float64(total)
+	if fraction > 1 {
+		fraction = 1
+	}
+	filled := int(fraction * progressWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressWidth-filled)
+
+	eta := "--:--"
+	if rate > 0 && done < total {
+		remaining := time.Duration(float64(total-done) / rate * float64(time.Second))
+		eta = fmt.Sprintf("%02d:%02d", int(remaining.Minutes()), int(remaining.Seconds())%60)
+	} else if done >= total {
+		eta = "00:00"
+	}
+
+	return fmt.Sprintf("[%s] %5.1f%%  %s / %s  %s/s  ETA %s",
+		bar, fraction*100, humanBytes(done), humanBytes(total), humanBytes(int64(rate)), eta)
+}
+
+// humanBytes formats a byte count using binary units
+func humanBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	units := []string{"KB", "MB", "GB", "TB", "PB"}
+	return fmt.Sprintf("%.1f %s", float64(bytes)/float64(div), units[exp])
+}
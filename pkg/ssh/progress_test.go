@@ -0,0 +1,56 @@
+package ssh
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderProgress(t *testing.T) {
+	line := renderProgress(512*1024*1024, 1024*1024*1024, 4*time.Second)
+
+	if !strings.Contains(line, " 50.0%") {
+		t.Errorf("Expected 50%% progress, got %q", line)
+	}
+	if !strings.Contains(line, "512.0 MB / 1.0 GB") {
+		t.Errorf("Expected byte counts, got %q", line)
+	}
+	if !strings.Contains(line, "128.0 MB/s") {
+		t.Errorf("Expected rate, got %q", line)
+	}
+	if !strings.Contains(line, "ETA 00:04") {
+		t.Errorf("Expected ETA, got %q", line)
+	}
+}
+
+func TestProgressBarCounts(t *testing.T) {
+	var out bytes.Buffer
+	bar := newProgressBar(&out, 10)
+
+	if _, err := bar.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bar.Write([]byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	bar.Finish()
+
+	if bar.done != 10 {
+		t.Errorf("Expected 10 bytes counted, got %d", bar.done)
+	}
+	if !strings.Contains(out.String(), "100.0%") {
+		t.Errorf("Expected final 100%% line, got %q", out.String())
+	}
+}
+
+func TestTransferResultRate(t *testing.T) {
+	result := &TransferResult{Bytes: 2048, Duration: 2 * time.Second}
+	if rate := result.Rate(); rate != 1024 {
+		t.Errorf("Rate() = %f, expected 1024", rate)
+	}
+
+	if rate := (&TransferResult{Bytes: 10}).Rate(); rate != 0 {
+		t.Errorf("Rate() with zero duration = %f, expected 0", rate)
+	}
+}
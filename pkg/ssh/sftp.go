@@ -0,0 +1,22 @@
+package ssh
+
+import (
+	"fmt"
+
+	"github.com/pkg/sftp"
+)
+
+// SFTP opens an SFTP channel on the existing SSH connection.
+// The caller is responsible for closing the returned client.
+func (c *Client) SFTP() (*sftp.Client, error) {
+	if c.client == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	sftpClient, err := sftp.NewClient(c.client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start SFTP session: %w", err)
+	}
+
+	return sftpClient, nil
+}
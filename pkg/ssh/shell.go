@@ -0,0 +1,169 @@
+package ssh
+
+import "strings"
+
+// shellScript is the outline of a shell script: its simple commands as words, the
+// functions it defines, and whether it writes files through redirection
+type shellScript struct {
+	commands  [][]string
+	functions map[string]bool
+	writes    bool
+}
+
+// shellFrame is a command being read: the top level, a subshell or a $(...) substitution
+type shellFrame struct {
+	words        []string
+	word         strings.Builder
+	inWord       bool
+	doubleQuoted bool
+	substitution bool
+}
+
+// endWord finishes the word being read
+func (f *shellFrame) endWord() {
+	if f.inWord {
+		f.words = append(f.words, f.word.String())
+		f.word.Reset()
+		f.inWord = false
+	}
+}
+
+// parseShell splits a POSIX shell script into simple commands. It understands the
+// quoting, substitutions, redirections and compound commands this tool generates,
+// not the full shell grammar. Quotes are removed from words, and a substitution
+// becomes "$()" in the word it appears in, its own commands listed separately.
+func parseShell(script string) shellScript {
+	result := shellScript{functions: map[string]bool{}}
+	stack := []*shellFrame{{}}
+	top := func() *shellFrame { return stack[len(stack)-1] }
+	endCommand := func() {
+		f := top()
+		f.endWord()
+		if len(f.words) > 0 {
+			result.commands = append(result.commands, f.words)
+		}
+		f.words = nil
+	}
+
+	for i := 0; i < len(script); i++ {
+		f := top()
+		c := script[i]
+		next := byte(0)
+		if i+1 < len(script) {
+			next = script[i+1]
+		}
+
+		switch {
+		case c == '\\' && next != 0:
+			f.word.WriteByte(next)
+			f.inWord = true
+			i++
+		case c == '$' && next == '(':
+			stack = append(stack, &shellFrame{substitution: true})
+			i++
+		case f.doubleQuoted:
+			if c == '"' {
+				f.doubleQuoted = false
+			} else {
+				f.word.WriteByte(c)
+			}
+		case c == '"':
+			f.doubleQuoted, f.inWord = true, true
+		case c == '\'':
+			end := strings.IndexByte(script[i+1:], '\'')
+			if end < 0 {
+				end = len(script) - i - 1
+			}
+			f.word.WriteString(script[i+1 : i+1+end])
+			f.inWord = true
+			i += end + 1
+		case c == '#' && !f.inWord:
+			for i+1 < len(script) && script[i+1] != '\n' {
+				i++
+			}
+		case c == ' ' || c == '\t':
+			f.endWord()
+		case c == '\n' || c == ';' || c == '&' || c == '|':
+			endCommand()
+			if (c == '&' || c == '|') && next == c {
+				i++
+			}
+		case c == '(' && next == ')':
+			// Function definition: name() { ... }
+			f.endWord()
+			if len(f.words) > 0 {
+				result.functions[f.words[len(f.words)-1]] = true
+			}
+			f.words = nil
+			i++
+		case c == '(':
+			stack = append(stack, &shellFrame{})
+		case c == ')':
+			if len(stack) == 1 {
+				// End of a case pattern; the pattern itself isn't a command
+				f.endWord()
+				f.words = nil
+				continue
+			}
+			endCommand()
+			stack = stack[:len(stack)-1]
+			if f.substitution {
+				outer := top()
+				outer.word.WriteString("$()")
+				outer.inWord = true
+			}
+		case c == '<' && next == '<':
+			// Here-documents feed data to a command, which this tool only does to write files
+			result.writes = true
+			i++
+		case c == '>' || c == '<':
+			i = skipRedirect(script, i, f, &result)
+		default:
+			f.word.WriteByte(c)
+			f.inWord = true
+		}
+	}
+
+	for len(stack) > 0 {
+		endCommand()
+		stack = stack[:len(stack)-1]
+	}
+	return result
+}
+
+// skipRedirect reads the redirection starting at script[i], dropping a file
+// descriptor number before it, notes output to anything but /dev/null or another
+// descriptor as a write, and returns the index of its last character
+func skipRedirect(script string, i int, f *shellFrame, result *shellScript) int {
+	if f.inWord && strings.Trim(f.word.String(), "0123456789") == "" {
+		f.word.Reset()
+		f.inWord = false
+	}
+	f.endWord()
+
+	output := script[i] == '>'
+	i++
+	if i < len(script) && (script[i] == '>' || script[i] == '&') {
+		if script[i] == '&' {
+			// Duplicating a descriptor, e.g. 2>&1
+			for i+1 < len(script) && script[i+1] >= '0' && script[i+1] <= '9' {
+				i++
+			}
+			return i
+		}
+		i++
+	}
+	for i < len(script) && (script[i] == ' ' || script[i] == '\t') {
+		i++
+	}
+
+	start := i
+	for i < len(script) && !strings.ContainsRune(" \t\n;|&()", rune(script[i])) {
+		i++
+	}
+	target := strings.Trim(script[start:i], `"'`)
+	if output && target != "/dev/null" {
+		result.writes = true
+	}
+	return i - 1
+}
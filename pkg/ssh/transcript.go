@@ -0,0 +1,112 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transcript limits keep a long session's record small enough to attach to a bug report
+const (
+	maxTranscriptEntries = 500
+	maxTranscriptOutput  = 16 << 10
+)
+
+// TranscriptEntry is one remote command and its result
+type TranscriptEntry struct {
+	Time     time.Time
+	Duration time.Duration
+	Command  string
+	Output   string
+	Err      string
+}
+
+// Transcript records the remote commands a client runs, for support bundles
+type Transcript struct {
+	mu      sync.Mutex
+	entries []TranscriptEntry
+	dropped int
+	secrets []string
+}
+
+// NewTranscript creates an empty transcript
+func NewTranscript() *Transcript {
+	return &Transcript{}
+}
+
+// Redact masks secret wherever it appears in recorded commands and output
+func (t *Transcript) Redact(secret string) {
+	if secret == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.secrets = append(t.secrets, secret)
+}
+
+// Record adds a command to the transcript
+func (t *Transcript) Record(start time.Time, command, output string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(output) > maxTranscriptOutput {
+		output = output[:maxTranscriptOutput] + fmt.Sprintf("\n... (%d bytes truncated)", len(output)-maxTranscriptOutput)
+	}
+	entry := TranscriptEntry{
+		Time:     start,
+		Duration: time.Since(start),
+		Command:  t.mask(command),
+		Output:   t.mask(output),
+	}
+	if err != nil {
+		entry.Err = t.mask(err.Error())
+	}
+
+	// Keep the most recent commands; the failure is usually at the end
+	if len(t.entries) >= maxTranscriptEntries {
+		t.entries = t.entries[1:]
+		t.dropped++
+	}
+	t.entries = append(t.entries, entry)
+}
+
+// mask replaces redacted secrets in s
+func (t *Transcript) mask(s string) string {
+	for _, secret := range t.secrets {
+		s = strings.ReplaceAll(s, secret, "********")
+	}
+	return s
+}
+
+// Entries returns a copy of the recorded commands
+func (t *Transcript) Entries() []TranscriptEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TranscriptEntry(nil), t.entries...)
+}
+
+// WriteTo writes the transcript as readable text
+func (t *Transcript) WriteTo(w io.Writer) (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var b strings.Builder
+	if t.dropped > 0 {
+		fmt.Fprintf(&b, "... %d earlier command(s) omitted\n\n", t.dropped)
+	}
+	for _, entry := range t.entries {
+		fmt.Fprintf(&b, "[%s %s] $ %s\n", entry.Time.Format("15:04:05.000"), entry.Duration.Round(time.Millisecond), strings.TrimSpace(entry.Command))
+		if output := strings.TrimRight(entry.Output, "\n"); output != "" {
+			b.WriteString(output + "\n")
+		}
+		if entry.Err != "" {
+			fmt.Fprintf(&b, "(error: %s)\n", entry.Err)
+		}
+		b.WriteString("\n")
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
@@ -0,0 +1,48 @@
+package ssh
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTranscriptRedactsSecrets(t *testing.T) {
+	transcript := NewTranscript()
+	transcript.Redact("hunter2")
+	transcript.Record(time.Now(), "echo hunter2 | sudo -S true", "ok", errors.New("exit hunter2"))
+
+	var b strings.Builder
+	if _, err := transcript.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	if strings.Contains(b.String(), "hunter2") {
+		t.Errorf("transcript contains the secret:\n%s", b.String())
+	}
+	if !strings.Contains(b.String(), "$ echo ******** | sudo -S true") || !strings.Contains(b.String(), "(error: exit ********)") {
+		t.Errorf("unexpected transcript:\n%s", b.String())
+	}
+}
+
+func TestTranscriptKeepsRecentEntries(t *testing.T) {
+	transcript := NewTranscript()
+	for i := 0; i < maxTranscriptEntries+5; i++ {
+		transcript.Record(time.Now(), "true", strings.Repeat("x", maxTranscriptOutput+1), nil)
+	}
+
+	entries := transcript.Entries()
+	if len(entries) != maxTranscriptEntries {
+		t.Fatalf("Entries() returned %d entries, want %d", len(entries), maxTranscriptEntries)
+	}
+	if !strings.HasSuffix(entries[0].Output, "(1 bytes truncated)") {
+		t.Errorf("long output was not truncated: ...%s", entries[0].Output[len(entries[0].Output)-30:])
+	}
+
+	var b strings.Builder
+	if _, err := transcript.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	if !strings.HasPrefix(b.String(), "... 5 earlier command(s) omitted") {
+		t.Errorf("transcript does not note dropped commands: %.60s", b.String())
+	}
+}
@@ -0,0 +1,231 @@
+package ssh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// TransferOptions controls how files are moved by Upload and Download
+type TransferOptions struct {
+	// Progress receives a live progress bar; nil disables progress output
+	Progress io.Writer
+	// Verify compares SHA-256 checksums of the source and destination after the copy
+	Verify bool
+}
+
+// TransferResult describes a completed file transfer
+type TransferResult struct {
+	Bytes    int64
+	Duration time.Duration
+	SHA256   string
+}
+
+// Rate returns the average transfer rate in bytes per second
+func (r *TransferResult) Rate() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Duration.Seconds()
+}
+
+// Upload copies a local file to remotePath over SFTP.
+// Data is written to a temporary .part file and renamed into place once complete.
+func (c *Client) Upload(localPath, remotePath string, opts TransferOptions) (*TransferResult, error) {
+	local, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer func() {
+		if err := local.Close(); err != nil {
+			// Read-only file; close errors do not affect the upload
+		}
+	}()
+
+	info, err := local.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", localPath, err)
+	}
+	if c.Skip(fmt.Sprintf("upload %s -> %s", localPath, remotePath)) {
+		return &TransferResult{Bytes: info.Size()}, nil
+	}
+
+	sftpClient, err := c.SFTP()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sftpClient.Close(); err != nil {
+			// SFTP close errors are reported through the write path
+		}
+	}()
+
+	if err := sftpClient.MkdirAll(path.Dir(remotePath)); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", path.Dir(remotePath), err)
+	}
+
+	tmpPath := remotePath + ".part"
+	remote, err := sftpClient.Create(tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+
+	hasher := sha256.New()
+	result, err := CopyWithProgress(remote, local, info.Size(), hasher, opts.Progress)
+	if closeErr := remote.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if rmErr := sftpClient.Remove(tmpPath); rmErr != nil {
+			// Leftover .part files are overwritten by the next upload
+		}
+		return nil, fmt.Errorf("failed to upload to %s: %w", remotePath, err)
+	}
+
+	if opts.Verify {
+		remoteSum, err := c.remoteSHA256(sftpClient, tmpPath)
+		if err != nil {
+			return nil, err
+		}
+		if remoteSum != result.SHA256 {
+			if rmErr := sftpClient.Remove(tmpPath); rmErr != nil {
+				// The corrupt .part file is overwritten by the next upload
+			}
+			return nil, fmt.Errorf("checksum mismatch after upload to %s: local %s, remote %s", remotePath, result.SHA256, remoteSum)
+		}
+	}
+
+	if err := sftpClient.PosixRename(tmpPath, remotePath); err != nil {
+		return nil, fmt.Errorf("failed to move upload into place: %w", err)
+	}
+	c.log().Info("uploaded", "local", localPath, "remote", remotePath, "bytes", result.Bytes, "duration", result.Duration)
+
+	return result, nil
+}
+
+// Download copies remotePath from the QNAP device to a local file over SFTP
+func (c *Client) Download(remotePath, localPath string, opts TransferOptions) (*TransferResult, error) {
+	sftpClient, err := c.SFTP()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sftpClient.Close(); err != nil {
+			// SFTP close errors do not affect the local copy
+		}
+	}()
+
+	remote, err := sftpClient.Open(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", remotePath, err)
+	}
+	defer func() {
+		if err := remote.Close(); err != nil {
+			// Read-only file; close errors do not affect the download
+		}
+	}()
+
+	info, err := remote.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", remotePath, err)
+	}
+
+	if dir := filepath.Dir(localPath); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+
+	tmpPath := localPath + ".part"
+	local, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+
+	hasher := sha256.New()
+	result, err := CopyWithProgress(local, remote, info.Size(), hasher, opts.Progress)
+	if closeErr := local.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if rmErr := os.Remove(tmpPath); rmErr != nil {
+			// Leftover .part files are overwritten by the next download
+		}
+		return nil, fmt.Errorf("failed to download %s: %w", remotePath, err)
+	}
+
+	if opts.Verify {
+		remoteSum, err := c.remoteSHA256(sftpClient, remotePath)
+		if err != nil {
+			return nil, err
+		}
+		if remoteSum != result.SHA256 {
+			if rmErr := os.Remove(tmpPath); rmErr != nil {
+				// The corrupt .part file is overwritten by the next download
+			}
+			return nil, fmt.Errorf("checksum mismatch after download of %s: remote %s, local %s", remotePath, remoteSum, result.SHA256)
+		}
+	}
+
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		return nil, fmt.Errorf("failed to move download into place: %w", err)
+	}
+	c.log().Info("downloaded", "remote", remotePath, "local", localPath, "bytes", result.Bytes, "duration", result.Duration)
+
+	return result, nil
+}
+
+// remoteSHA256 computes the SHA-256 of a remote file, preferring sha256sum on the device
+// and falling back to reading the file back over SFTP when it is unavailable
+func (c *Client) remoteSHA256(sftpClient *sftp.Client, remotePath string) (string, error) {
+	output, err := c.Execute(fmt.Sprintf("sha256sum %s", Quote(remotePath)))
+	if err == nil {
+		if fields := strings.Fields(output); len(fields) > 0 && len(fields[0]) == sha256.Size*2 {
+			return fields[0], nil
+		}
+	}
+
+	remote, err := sftpClient.Open(remotePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s for verification: %w", remotePath, err)
+	}
+	defer func() {
+		if err := remote.Close(); err != nil {
+			// Read-only file; close errors do not affect the checksum
+		}
+	}()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, remote); err != nil {
+		return "", fmt.Errorf("failed to read %s for verification: %w", remotePath, err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// CopyWithProgress copies src to dst while hashing the data and reporting progress to a
+// progress bar on progress (nil for none). A total of 0 shows bytes and rate only.
+func CopyWithProgress(dst io.Writer, src io.Reader, total int64, hasher hash.Hash, progress io.Writer) (*TransferResult, error) {
+	bar := newProgressBar(progress, total)
+	start := time.Now()
+
+	written, err := io.Copy(io.MultiWriter(dst, hasher, bar), src)
+	bar.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransferResult{
+		Bytes:    written,
+		Duration: time.Since(start),
+		SHA256:   hex.EncodeToString(hasher.Sum(nil)),
+	}, nil
+}
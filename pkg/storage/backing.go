@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// backingScanScript prints "<image>\t<backing file>" for every qcow2 image in the
+// given directories that has a backing file
+const backingScanScript = `for f in %s; do
+	[ -f "$f" ] || continue
+	b=$(%s info "$f" 2>/dev/null | sed -n 's/^backing file: \([^ ]*\).*/\1/p')
+	[ -n "$b" ] && printf '%%s\t%%s\n' "$f" "$b"
+done`
+
+// FindBackingDependents returns, for each of paths, the qcow2 images in the
+// qnap-vm disk directories of all pools that use it as their backing file
+func (m *Manager) FindBackingDependents(paths []string) (map[string][]string, error) {
+	pools, err := m.DetectPools()
+	if err != nil {
+		return nil, err
+	}
+
+	backing, err := m.scanBackingFiles(pools)
+	if err != nil {
+		return nil, err
+	}
+
+	dependents := make(map[string][]string)
+	for _, path := range paths {
+		if images := backing[path]; len(images) > 0 {
+			dependents[path] = images
+		}
+	}
+	return dependents, nil
+}
+
+// scanBackingFiles maps backing files to the images in the pools' disk directories that use them
+func (m *Manager) scanBackingFiles(pools []Pool) (map[string][]string, error) {
+	var globs []string
+	for i := range pools {
+		globs = append(globs, ssh.Quote(DisksDir(&pools[i]))+"/*.qcow2")
+	}
+	if len(globs) == 0 {
+		return map[string][]string{}, nil
+	}
+
+	qemuImgPath, libPath, err := m.findQemuImg()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := fmt.Sprintf("export LD_LIBRARY_PATH=%s:$LD_LIBRARY_PATH\n", libPath) +
+		fmt.Sprintf(backingScanScript, strings.Join(globs, " "), qemuImgPath)
+	output, err := m.sshClient.ExecuteContext(m.commandContext(), cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan disk backing files: %w\nOutput: %s", err, output)
+	}
+
+	return parseBackingList(output), nil
+}
+
+// parseBackingList maps backing files to the images that depend on them
+func parseBackingList(output string) map[string][]string {
+	backing := make(map[string][]string)
+	for _, line := range strings.Split(output, "\n") {
+		image, backingFile, found := strings.Cut(strings.TrimSpace(line), "\t")
+		if !found || backingFile == "" {
+			continue
+		}
+		backing[backingFile] = append(backing[backingFile], image)
+	}
+	return backing
+}
@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBackingList(t *testing.T) {
+	sampleOutput := "/share/CACHEDEV1_DATA/.qnap-vm/disks/web-clone.qcow2\t/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2\n" +
+		"/share/CACHEDEV1_DATA/.qnap-vm/disks/web-clone2.qcow2\t/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2\n" +
+		"/share/CACHEDEV1_DATA/.qnap-vm/disks/db-test.qcow2\t/share/CACHEDEV1_DATA/.qnap-vm/disks/db.qcow2\n" +
+		"\n"
+
+	backing := parseBackingList(sampleOutput)
+
+	expected := []string{
+		"/share/CACHEDEV1_DATA/.qnap-vm/disks/web-clone.qcow2",
+		"/share/CACHEDEV1_DATA/.qnap-vm/disks/web-clone2.qcow2",
+	}
+	if got := backing["/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2"]; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected dependents for web.qcow2: %v", got)
+	}
+	if len(backing) != 2 {
+		t.Errorf("Expected 2 backing files, got %d", len(backing))
+	}
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// sizePattern matches qemu-img sizes such as 20G, 512M or 1.5T
+var sizePattern = regexp.MustCompile(`^\d+(\.\d+)?[KMGT]?$`)
+
+// validDiskBuses lists the disk buses supported for VM disks
+var validDiskBuses = map[string]bool{
+	"virtio": true,
+	"sata":   true,
+	"scsi":   true,
+	"ide":    true,
+}
+
+// DiskSpec describes a disk requested on the command line
+type DiskSpec struct {
+	Size string // Disk size (e.g., "20G")
+	Pool string // Storage pool name; empty selects the best pool
+	Bus  string // Disk bus; empty selects virtio
+	LUN  string // Existing iSCSI LUN to attach instead of creating an image
+
+	Cache string // Cache mode (none, writeback, writethrough); empty keeps QEMU's default
+	IO    string // I/O mode (native, threads); empty keeps QEMU's default
+
+	Discard      string // Discard mode (unmap, ignore); empty selects unmap on SSD pools
+	DetectZeroes string // Zero detection (off, on, unmap); empty selects unmap with discard=unmap on SSD pools
+}
+
+// ParseDiskSpec parses a disk specification of the form
+// "size=20G[,pool=NAME,bus=virtio,cache=none,io=native,discard=unmap,detect_zeroes=unmap]"
+// or "lun=NAME[,bus=virtio,...]". A bare size such as "20G" is also accepted. Driver
+// modes are checked when the disk is defined.
+func ParseDiskSpec(spec string) (DiskSpec, error) {
+	var disk DiskSpec
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			if disk.Size != "" {
+				return DiskSpec{}, fmt.Errorf("invalid disk option '%s' in '%s'", part, spec)
+			}
+			disk.Size = part
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "size":
+			disk.Size = value
+		case "pool":
+			disk.Pool = value
+		case "bus":
+			disk.Bus = strings.ToLower(value)
+		case "lun":
+			disk.LUN = value
+		case "cache":
+			disk.Cache = strings.ToLower(value)
+		case "io":
+			disk.IO = strings.ToLower(value)
+		case "discard":
+			disk.Discard = strings.ToLower(value)
+		case "detect_zeroes", "detect-zeroes":
+			disk.DetectZeroes = strings.ToLower(value)
+		default:
+			return DiskSpec{}, fmt.Errorf("unknown disk option '%s' in '%s' (use size, pool, bus, lun, cache, io, discard, detect_zeroes)", key, spec)
+		}
+	}
+
+	if disk.Bus != "" && !validDiskBuses[disk.Bus] {
+		return DiskSpec{}, fmt.Errorf("invalid disk bus '%s' (use virtio, sata, scsi, ide)", disk.Bus)
+	}
+
+	// LUNs are attached as they are, so they have no size or pool
+	if disk.LUN != "" {
+		if disk.Size != "" || disk.Pool != "" {
+			return DiskSpec{}, fmt.Errorf("disk '%s': lun= cannot be combined with size or pool", spec)
+		}
+		return disk, nil
+	}
+
+	disk.Size = strings.ToUpper(disk.Size)
+	if disk.Size == "" {
+		return DiskSpec{}, fmt.Errorf("disk '%s' has no size", spec)
+	}
+	if !sizePattern.MatchString(disk.Size) {
+		return DiskSpec{}, fmt.Errorf("invalid disk size '%s' (e.g. 20G, 512M)", disk.Size)
+	}
+
+	return disk, nil
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestParseDiskSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    DiskSpec
+		wantErr bool
+	}{
+		{"20G", DiskSpec{Size: "20G"}, false},
+		{"size=100g", DiskSpec{Size: "100G"}, false},
+		{"size=50G,pool=CACHEDEV2_DATA,bus=sata", DiskSpec{Size: "50G", Pool: "CACHEDEV2_DATA", Bus: "sata"}, false},
+		{"bus=scsi,size=1.5T", DiskSpec{Size: "1.5T", Bus: "scsi"}, false},
+		{"pool=CACHEDEV1_DATA", DiskSpec{}, true},
+		{"size=big", DiskSpec{}, true},
+		{"size=20G,bus=floppy", DiskSpec{}, true},
+		{"size=20G,format=raw", DiskSpec{}, true},
+		{"20G,30G", DiskSpec{}, true},
+		{"lun=LUN_0", DiskSpec{LUN: "LUN_0"}, false},
+		{"lun=LUN_0,bus=scsi", DiskSpec{LUN: "LUN_0", Bus: "scsi"}, false},
+		{"lun=LUN_0,size=20G", DiskSpec{}, true},
+		{"lun=LUN_0,pool=CACHEDEV1_DATA", DiskSpec{}, true},
+		{"size=20G,cache=none,io=Native", DiskSpec{Size: "20G", Cache: "none", IO: "native"}, false},
+		{"lun=LUN_0,cache=writeback", DiskSpec{LUN: "LUN_0", Cache: "writeback"}, false},
+		{"size=20G,discard=unmap,detect_zeroes=Unmap", DiskSpec{Size: "20G", Discard: "unmap", DetectZeroes: "unmap"}, false},
+		{"size=20G,discard=ignore", DiskSpec{Size: "20G", Discard: "ignore"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseDiskSpec(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDiskSpec(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseDiskSpec(%q) = %+v, expected %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
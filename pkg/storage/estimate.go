@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// estimateWriteRate is a conservative sustained write rate for HDD-backed pools, in bytes per second
+const estimateWriteRate = 100 << 20
+
+// defaultClusterSize is the qcow2 cluster size used when qemu-img does not report one
+const defaultClusterSize = 64 << 10
+
+// ImageInfo is the subset of 'qemu-img info' output used for estimates
+type ImageInfo struct {
+	Path        string `json:"filename"`
+	Format      string `json:"format"`
+	VirtualSize int64  `json:"virtual-size"`
+	ActualSize  int64  `json:"actual-size"`
+	ClusterSize int64  `json:"cluster-size"`
+	BackingFile string `json:"backing-filename,omitempty"`
+}
+
+// GetImageInfo reads a disk image's metadata with qemu-img. The image may be in use by a running VM.
+func (m *Manager) GetImageInfo(diskPath string) (*ImageInfo, error) {
+	// -U reads images locked by a running VM; older qemu-img builds lack it
+	output, err := m.execQemuImg(fmt.Sprintf("info -U --output=json %s", ssh.Quote(diskPath)))
+	if err != nil {
+		output, err = m.execQemuImg(fmt.Sprintf("info --output=json %s", ssh.Quote(diskPath)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect %s: %w\nOutput: %s", diskPath, err, output)
+	}
+
+	return parseImageInfo(output)
+}
+
+// parseImageInfo parses 'qemu-img info --output=json' output
+func parseImageInfo(output string) (*ImageInfo, error) {
+	var info ImageInfo
+	if err := json.Unmarshal([]byte(output), &info); err != nil {
+		return nil, fmt.Errorf("failed to parse qemu-img info output: %w", err)
+	}
+	return &info, nil
+}
+
+// SnapshotEstimate is the approximate cost of an internal snapshot
+type SnapshotEstimate struct {
+	Metadata int64         // Snapshot and L1 table copies written immediately
+	VMState  int64         // Memory state saved for a running VM
+	Growth   int64         // Worst-case growth as already-allocated clusters are rewritten after the snapshot
+	Duration time.Duration // Approximate time to write the snapshot
+}
+
+// Immediate returns the space the snapshot needs when it is taken
+func (e SnapshotEstimate) Immediate() int64 {
+	return e.Metadata + e.VMState
+}
+
+// EstimateSnapshot estimates an internal snapshot of images, saving memoryBytes of
+// VM state when the VM is running. The memory state is stored in the first image.
+func EstimateSnapshot(images []ImageInfo, memoryBytes int64) SnapshotEstimate {
+	var estimate SnapshotEstimate
+	for _, image := range images {
+		estimate.Metadata += l1TableBytes(image)
+		// Every cluster allocated now is shared with the snapshot and copied on its next write
+		estimate.Growth += image.ActualSize
+	}
+
+	estimate.VMState = memoryBytes
+	estimate.Duration = time.Duration(float64(estimate.Immediate()) / estimateWriteRate * float64(time.Second))
+	return estimate
+}
+
+// BackupEstimate is the approximate cost of copying a VM's disk images into a backup set
+type BackupEstimate struct {
+	Bytes    int64         // Allocated data copied
+	Duration time.Duration // Approximate time to copy it
+}
+
+// EstimateBackup estimates a backup of images. Copies are sparse, so only allocated data counts.
+func EstimateBackup(images []ImageInfo) BackupEstimate {
+	var estimate BackupEstimate
+	for _, image := range images {
+		estimate.Bytes += image.ActualSize
+	}
+	estimate.Duration = time.Duration(float64(estimate.Bytes) / estimateWriteRate * float64(time.Second))
+	return estimate
+}
+
+// l1TableBytes returns the size of the L1 table an internal snapshot copies, rounded up to whole clusters
+func l1TableBytes(image ImageInfo) int64 {
+	cluster := image.ClusterSize
+	if cluster <= 0 {
+		cluster = defaultClusterSize
+	}
+
+	// Each L1 entry points at an L2 table mapping cluster/8 data clusters
+	coveredPerEntry := cluster * (cluster / 8)
+	entries := (image.VirtualSize + coveredPerEntry - 1) / coveredPerEntry
+	bytes := entries * 8
+
+	return (bytes + cluster - 1) / cluster * cluster
+}
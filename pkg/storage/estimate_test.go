@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseImageInfo(t *testing.T) {
+	output := `{
+    "virtual-size": 21474836480,
+    "filename": "/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2",
+    "cluster-size": 65536,
+    "format": "qcow2",
+    "actual-size": 3221225472,
+    "dirty-flag": false
+}`
+
+	info, err := parseImageInfo(output)
+	if err != nil {
+		t.Fatalf("parseImageInfo() error: %v", err)
+	}
+	if info.VirtualSize != 20<<30 || info.ActualSize != 3<<30 || info.ClusterSize != 64<<10 || info.Format != "qcow2" {
+		t.Errorf("parseImageInfo() = %+v", info)
+	}
+
+	if _, err := parseImageInfo("qemu-img: Could not open"); err == nil {
+		t.Error("parseImageInfo() should fail on non-JSON output")
+	}
+}
+
+func TestEstimateSnapshot(t *testing.T) {
+	images := []ImageInfo{
+		{VirtualSize: 20 << 30, ActualSize: 3 << 30, ClusterSize: 64 << 10},
+		{VirtualSize: 1 << 40, ActualSize: 1 << 30},
+	}
+
+	est := EstimateSnapshot(images, 2<<30)
+
+	// A 20G image needs 40 L1 entries and a 1T image 2048, each rounded up to one 64K cluster
+	if est.Metadata != 2*(64<<10) {
+		t.Errorf("Metadata = %d, expected %d", est.Metadata, 2*(64<<10))
+	}
+	if est.VMState != 2<<30 {
+		t.Errorf("VMState = %d, expected %d", est.VMState, int64(2<<30))
+	}
+	if est.Growth != 4<<30 {
+		t.Errorf("Growth = %d, expected %d", est.Growth, int64(4<<30))
+	}
+	if est.Immediate() != est.Metadata+est.VMState {
+		t.Errorf("Immediate() = %d", est.Immediate())
+	}
+	if est.Duration < 20*time.Second || est.Duration > 21*time.Second {
+		t.Errorf("Duration = %v, expected about 20s", est.Duration)
+	}
+}
+
+func TestEstimateBackup(t *testing.T) {
+	images := []ImageInfo{
+		{VirtualSize: 20 << 30, ActualSize: 3 << 30},
+		{VirtualSize: 100 << 30, ActualSize: 1 << 30},
+	}
+
+	estimate := EstimateBackup(images)
+	if estimate.Bytes != 4<<30 {
+		t.Errorf("EstimateBackup() Bytes = %d, want %d", estimate.Bytes, int64(4<<30))
+	}
+	if want := time.Duration(float64(4<<30) / estimateWriteRate * float64(time.Second)); estimate.Duration != want {
+		t.Errorf("EstimateBackup() Duration = %v, want %v", estimate.Duration, want)
+	}
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DiskImage is a qcow2 image in a pool's qnap-vm disk directory
+type DiskImage struct {
+	Path     string    `json:"path"`
+	Pool     string    `json:"pool"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// DisksDir returns the directory holding qnap-vm disk images in a pool
+func DisksDir(pool *Pool) string {
+	return pool.Path + "/.qnap-vm/disks"
+}
+
+// ListDiskImages lists the qcow2 images in the disk directories of all detected pools
+func (m *Manager) ListDiskImages() ([]DiskImage, error) {
+	pools, err := m.DetectPools()
+	if err != nil {
+		return nil, err
+	}
+
+	sftpClient, err := m.sshClient.SFTP()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sftpClient.Close(); err != nil {
+			// SFTP close errors do not affect the listing
+		}
+	}()
+
+	var images []DiskImage
+	for i := range pools {
+		dir := DisksDir(&pools[i])
+		entries, err := sftpClient.ReadDir(dir)
+		if err != nil {
+			continue // No disks created on this pool yet
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.EqualFold(path.Ext(entry.Name()), ".qcow2") {
+				continue
+			}
+			images = append(images, DiskImage{
+				Path:     path.Join(dir, entry.Name()),
+				Pool:     pools[i].Name,
+				Size:     entry.Size(),
+				Modified: entry.ModTime(),
+			})
+		}
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Path < images[j].Path
+	})
+
+	return images, nil
+}
+
+// FindOrphanedDisks returns the disk images not referenced by any VM.
+// inUse holds the disk sources of all defined VMs; images backing an in-use
+// image (linked clone bases) are treated as in use as well.
+func (m *Manager) FindOrphanedDisks(inUse map[string]bool) ([]DiskImage, error) {
+	images, err := m.ListDiskImages()
+	if err != nil {
+		return nil, err
+	}
+
+	pools, err := m.DetectPools()
+	if err != nil {
+		return nil, err
+	}
+
+	backing, err := m.scanBackingFiles(pools)
+	if err != nil {
+		return nil, err
+	}
+
+	return findOrphans(images, inUse, backing), nil
+}
+
+// findOrphans filters images down to those neither in use nor backing an in-use image.
+// backing maps backing files to the images that depend on them.
+func findOrphans(images []DiskImage, inUse map[string]bool, backing map[string][]string) []DiskImage {
+	// Invert the backing map so chains can be followed from each image to its base
+	backingOf := make(map[string]string)
+	for base, dependents := range backing {
+		for _, dependent := range dependents {
+			backingOf[dependent] = base
+		}
+	}
+
+	used := make(map[string]bool)
+	for image := range inUse {
+		for current := image; current != "" && !used[current]; current = backingOf[current] {
+			used[current] = true
+		}
+	}
+
+	var orphans []DiskImage
+	for _, image := range images {
+		if !used[image.Path] {
+			orphans = append(orphans, image)
+		}
+	}
+	return orphans
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestFindOrphans(t *testing.T) {
+	dir := "/share/CACHEDEV1_DATA/.qnap-vm/disks/"
+	images := []DiskImage{
+		{Path: dir + "web.qcow2"},
+		{Path: dir + "web-clone.qcow2"},
+		{Path: dir + "base.qcow2"},
+		{Path: dir + "deleted-vm.qcow2"},
+		{Path: dir + "deleted-vm-disk1.qcow2"},
+	}
+	inUse := map[string]bool{
+		dir + "web.qcow2":       true,
+		dir + "web-clone.qcow2": true,
+	}
+	// web-clone.qcow2 is a linked clone of base.qcow2
+	backing := map[string][]string{
+		dir + "base.qcow2": {dir + "web-clone.qcow2"},
+	}
+
+	orphans := findOrphans(images, inUse, backing)
+	if len(orphans) != 2 {
+		t.Fatalf("Expected 2 orphans, got %d: %+v", len(orphans), orphans)
+	}
+	if orphans[0].Path != dir+"deleted-vm.qcow2" || orphans[1].Path != dir+"deleted-vm-disk1.qcow2" {
+		t.Errorf("Unexpected orphans: %+v", orphans)
+	}
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// ISOImage represents an installation image in a pool's ISO library
+type ISOImage struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Pool     string    `json:"pool"`
+	Size     int64     `json:"size_bytes"`
+	Modified time.Time `json:"modified"`
+}
+
+// ISODir returns the ISO library directory for a pool
+func ISODir(pool *Pool) string {
+	return fmt.Sprintf("%s/.qnap-vm/isos", pool.Path)
+}
+
+// ListISOs lists ISO images in the libraries of all detected pools
+func (m *Manager) ListISOs() ([]ISOImage, error) {
+	pools, err := m.DetectPools()
+	if err != nil {
+		return nil, err
+	}
+
+	sftpClient, err := m.sshClient.SFTP()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sftpClient.Close(); err != nil {
+			// SFTP close errors do not affect the listing
+		}
+	}()
+
+	var images []ISOImage
+	for i := range pools {
+		entries, err := sftpClient.ReadDir(ISODir(&pools[i]))
+		if err != nil {
+			continue // Library not created on this pool yet
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.EqualFold(path.Ext(entry.Name()), ".iso") {
+				continue
+			}
+			images = append(images, ISOImage{
+				Name:     entry.Name(),
+				Path:     path.Join(ISODir(&pools[i]), entry.Name()),
+				Pool:     pools[i].Name,
+				Size:     entry.Size(),
+				Modified: entry.ModTime(),
+			})
+		}
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Name < images[j].Name
+	})
+
+	return images, nil
+}
+
+// FindISO looks up an ISO in the library by short name, with or without the .iso extension
+func (m *Manager) FindISO(name string) (*ISOImage, error) {
+	images, err := m.ListISOs()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range images {
+		if images[i].Name == name || images[i].Name == name+".iso" {
+			return &images[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("ISO '%s' not found in library (use 'qnap-vm iso list')", name)
+}
+
+// ResolveISOPath turns an ISO reference into a path on the QNAP device.
+// Absolute paths are used as-is; anything else is looked up in the ISO library.
+func (m *Manager) ResolveISOPath(ref string) (string, error) {
+	if ref == "" || strings.HasPrefix(ref, "/") {
+		return ref, nil
+	}
+
+	image, err := m.FindISO(ref)
+	if err != nil {
+		return "", err
+	}
+	return image.Path, nil
+}
+
+// UploadISO copies a local ISO file into the pool's ISO library
+func (m *Manager) UploadISO(pool *Pool, localPath string, opts ssh.TransferOptions) (*ISOImage, error) {
+	remotePath := path.Join(ISODir(pool), filepath.Base(localPath))
+
+	result, err := m.sshClient.Upload(localPath, remotePath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ISOImage{
+		Name:     filepath.Base(localPath),
+		Path:     remotePath,
+		Pool:     pool.Name,
+		Size:     result.Bytes,
+		Modified: time.Now(),
+	}, nil
+}
+
+// isoDownloadScript downloads a URL into the ISO library on the NAS itself, with curl
+// or BusyBox wget, through a partial file so an interrupted download isn't listed
+const isoDownloadScript = `mkdir -p %[1]s || exit 1
+if command -v curl >/dev/null 2>&1; then
+	curl -fsSL -o %[2]s %[4]s
+else
+	wget -q -O %[2]s %[4]s
+fi || { rm -f %[2]s; exit 1; }
+mv %[2]s %[3]s && wc -c < %[3]s`
+
+// ISONameFromURL returns the library name for an ISO downloaded from rawURL: the last
+// element of its path, which must end in .iso
+func ISONameFromURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("invalid ISO URL '%s' (use http:// or https://)", rawURL)
+	}
+	name := path.Base(u.Path)
+	if !strings.EqualFold(path.Ext(name), ".iso") {
+		return "", fmt.Errorf("'%s' does not look like an ISO file; give the library name with --name", rawURL)
+	}
+	return name, nil
+}
+
+// DownloadISO downloads an ISO from rawURL straight into the pool's ISO library,
+// without passing it through this machine
+func (m *Manager) DownloadISO(pool *Pool, rawURL, name string) (*ISOImage, error) {
+	dir := ISODir(pool)
+	remotePath := path.Join(dir, name)
+	output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf(isoDownloadScript,
+		ssh.Quote(dir), ssh.Quote(remotePath+".part"), ssh.Quote(remotePath), ssh.Quote(rawURL)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s on the NAS: %w\nOutput: %s", rawURL, err, strings.TrimSpace(output))
+	}
+
+	size, _ := strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+	return &ISOImage{
+		Name:     name,
+		Path:     remotePath,
+		Pool:     pool.Name,
+		Size:     size,
+		Modified: time.Now(),
+	}, nil
+}
+
+// DeleteISO removes an ISO from the library
+func (m *Manager) DeleteISO(name string) (*ISOImage, error) {
+	image, err := m.FindISO(name)
+	if err != nil {
+		return nil, err
+	}
+	if m.sshClient.Skip("rm " + image.Path) {
+		return image, nil
+	}
+
+	sftpClient, err := m.sshClient.SFTP()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sftpClient.Close(); err != nil {
+			// SFTP close errors do not affect the removal
+		}
+	}()
+
+	if err := sftpClient.Remove(image.Path); err != nil {
+		return nil, fmt.Errorf("failed to delete %s: %w", image.Path, err)
+	}
+
+	return image, nil
+}
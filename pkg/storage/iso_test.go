@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestISODir(t *testing.T) {
+	pool := &Pool{Name: "CACHEDEV1_DATA", Path: "/share/CACHEDEV1_DATA"}
+
+	if dir := ISODir(pool); dir != "/share/CACHEDEV1_DATA/.qnap-vm/isos" {
+		t.Errorf("ISODir() = %s, expected /share/CACHEDEV1_DATA/.qnap-vm/isos", dir)
+	}
+}
+
+func TestResolveISOPathAbsolute(t *testing.T) {
+	// Absolute paths and empty references are returned without touching the device
+	m := &Manager{}
+
+	for _, ref := range []string{"", "/share/Public/debian.iso"} {
+		resolved, err := m.ResolveISOPath(ref)
+		if err != nil {
+			t.Fatalf("ResolveISOPath(%q) failed: %v", ref, err)
+		}
+		if resolved != ref {
+			t.Errorf("ResolveISOPath(%q) = %q, expected unchanged", ref, resolved)
+		}
+	}
+}
+
+func TestISONameFromURL(t *testing.T) {
+	for _, tt := range []struct {
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{"https://fedorapeople.org/groups/virt/virtio-win/direct-downloads/stable-virtio/virtio-win.iso", "virtio-win.iso", false},
+		{"http://mirror.example.com/debian-12.5.0-amd64-netinst.ISO?mirror=1", "debian-12.5.0-amd64-netinst.ISO", false},
+		{"https://example.com/download?file=ubuntu.iso", "", true},
+		{"ftp://example.com/ubuntu.iso", "", true},
+		{"/share/Public/ubuntu.iso", "", true},
+	} {
+		got, err := ISONameFromURL(tt.url)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ISONameFromURL(%q) = %q, %v, want %q (error %v)", tt.url, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
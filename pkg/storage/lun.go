@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// LUN is an iSCSI LUN configured on the QNAP device
+type LUN struct {
+	Name    string   `json:"name"`
+	Device  string   `json:"device"`            // Block device, or backing file for file-based LUNs
+	Block   bool     `json:"block"`             // Block-based LUN (true) or file-based LUN
+	Targets []string `json:"targets,omitempty"` // iSCSI targets the LUN is mapped to
+}
+
+// lunScanScript lists LUNs from the kernel target's configfs, which QTS uses for its iSCSI service.
+// It prints "lun\t<configfs dir>\t<device>" for each LUN and "map\t<link target>\t<link>" for
+// each target mapping.
+const lunScanScript = `cd /sys/kernel/config/target/core 2>/dev/null || exit 0
+for d in iblock_*/* fileio_*/*; do
+	[ -f "$d/udev_path" ] || continue
+	printf 'lun\t%s\t%s\n' "$d" "$(cat "$d/udev_path")"
+done
+for l in /sys/kernel/config/target/iscsi/*/tpgt_*/lun/*/*; do
+	[ -L "$l" ] || continue
+	printf 'map\t%s\t%s\n' "$(readlink "$l")" "$l"
+done
+true`
+
+// ListLUNs returns the iSCSI LUNs configured on the QNAP device
+func (m *Manager) ListLUNs() ([]LUN, error) {
+	output, err := m.sshClient.ExecuteContext(m.commandContext(), lunScanScript)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list iSCSI LUNs: %w\nOutput: %s", err, output)
+	}
+	return parseLUNs(output), nil
+}
+
+// FindLUN returns the LUN with the given name or device path
+func (m *Manager) FindLUN(name string) (*LUN, error) {
+	luns, err := m.ListLUNs()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for i := range luns {
+		lun := &luns[i]
+		if lun.Name == name || lun.Device == name || path.Base(lun.Device) == name {
+			return lun, nil
+		}
+		names = append(names, lun.Name)
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("iSCSI LUN '%s' not found (no LUNs are configured)", name)
+	}
+	return nil, fmt.Errorf("iSCSI LUN '%s' not found; available LUNs: %s", name, strings.Join(names, ", "))
+}
+
+// parseLUNs parses the output of lunScanScript
+func parseLUNs(output string) []LUN {
+	var luns []LUN
+	index := make(map[string]int) // "iblock_0/name" -> position in luns
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+
+		switch fields[0] {
+		case "lun":
+			dir := fields[1]
+			index[dir] = len(luns)
+			luns = append(luns, LUN{
+				Name:   path.Base(dir),
+				Device: strings.TrimSpace(fields[2]),
+				Block:  strings.HasPrefix(dir, "iblock_"),
+			})
+		case "map":
+			// The link points at ../../../../../../target/core/<hba>/<name>
+			hba, name := path.Base(path.Dir(fields[1])), path.Base(fields[1])
+			i, ok := index[hba+"/"+name]
+			if !ok {
+				continue
+			}
+			if target := mappedTarget(fields[2]); target != "" && !containsString(luns[i].Targets, target) {
+				luns[i].Targets = append(luns[i].Targets, target)
+			}
+		}
+	}
+
+	for i := range luns {
+		sort.Strings(luns[i].Targets)
+	}
+	return luns
+}
+
+// mappedTarget extracts the target IQN from a configfs LUN mapping path
+func mappedTarget(link string) string {
+	_, rest, found := strings.Cut(link, "/target/iscsi/")
+	if !found {
+		return ""
+	}
+	iqn, _, _ := strings.Cut(rest, "/")
+	return iqn
+}
+
+// containsString reports whether values contains s
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
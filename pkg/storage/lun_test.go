@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLUNs(t *testing.T) {
+	output := "lun\tiblock_0/LUN_0\t/dev/mapper/cachedev1_lun0\n" +
+		"lun\tfileio_1/LUN_1\t/share/CACHEDEV1_DATA/.@iscsi.img/iSCSI-LUN_1-6a2b.img\n" +
+		"map\t../../../../../../target/core/iblock_0/LUN_0\t/sys/kernel/config/target/iscsi/iqn.2004-04.com.qnap:ts-453d:iscsi.vmstore.1b2c3d/tpgt_1/lun/lun_0/a1b2c3\n" +
+		"map\t../../../../../../target/core/iblock_0/LUN_0\t/sys/kernel/config/target/iscsi/iqn.2004-04.com.qnap:ts-453d:iscsi.vmstore.1b2c3d/tpgt_2/lun/lun_0/d4e5f6\n" +
+		"map\t../../../../../../target/core/iblock_9/GONE\t/sys/kernel/config/target/iscsi/iqn.x/tpgt_1/lun/lun_3/f00\n"
+
+	expected := []LUN{
+		{
+			Name:    "LUN_0",
+			Device:  "/dev/mapper/cachedev1_lun0",
+			Block:   true,
+			Targets: []string{"iqn.2004-04.com.qnap:ts-453d:iscsi.vmstore.1b2c3d"},
+		},
+		{
+			Name:   "LUN_1",
+			Device: "/share/CACHEDEV1_DATA/.@iscsi.img/iSCSI-LUN_1-6a2b.img",
+		},
+	}
+
+	if got := parseLUNs(output); !reflect.DeepEqual(got, expected) {
+		t.Errorf("parseLUNs() = %+v, expected %+v", got, expected)
+	}
+
+	if got := parseLUNs(""); len(got) != 0 {
+		t.Errorf("parseLUNs(\"\") = %+v, expected none", got)
+	}
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Placement strategies choosing the pool for a new VM's disks
+const (
+	PlacementBest     = "best"      // GetBestPool's choice: preferred pool type, then most free space
+	PlacementMostFree = "most-free" // The pool with the most free space, whatever its type
+	PlacementSpread   = "spread"    // The pool holding the fewest VMs
+	PlacementAffinity = "affinity"  // The pool holding the most VMs sharing a tag with the new one
+)
+
+// Placements lists the placement strategies
+var Placements = []string{PlacementBest, PlacementMostFree, PlacementSpread, PlacementAffinity}
+
+// ValidatePlacement checks a placement strategy name
+func ValidatePlacement(strategy string) error {
+	if !slices.Contains(Placements, strategy) {
+		return fmt.Errorf("invalid placement '%s' (use %s)", strategy, strings.Join(Placements, ", "))
+	}
+	return nil
+}
+
+// PlacedVM is an existing VM as placement sees it
+type PlacedVM struct {
+	Tags  []string
+	Disks []string // Disk image paths
+}
+
+// PlacePool returns the pool a new VM with tags should use under strategy, given the
+// VMs already on the NAS
+func (m *Manager) PlacePool(strategy string, vms []PlacedVM, tags []string) (*Pool, error) {
+	if err := ValidatePlacement(strategy); err != nil {
+		return nil, err
+	}
+	pools, err := m.DetectPools()
+	if err != nil {
+		return nil, err
+	}
+
+	pool := SelectPlacementPool(strategy, pools, vms, tags)
+	if pool == nil {
+		return nil, fmt.Errorf("no available storage pools found")
+	}
+	return pool, nil
+}
+
+// SelectPlacementPool returns the pool PlacePool would choose from pools, or nil if none
+// is available. Like SelectBestPool, network shares are only used when no local pool is
+// available. Affinity without VMs sharing a tag falls back to spread, and ties go to the
+// pool with more free space.
+func SelectPlacementPool(strategy string, pools []Pool, vms []PlacedVM, tags []string) *Pool {
+	if strategy == PlacementBest {
+		return SelectBestPool(pools)
+	}
+
+	var candidates []*Pool
+	for i := range pools {
+		if pools[i].Available && !isNetworkPool(&pools[i]) {
+			candidates = append(candidates, &pools[i])
+		}
+	}
+	if len(candidates) == 0 {
+		for i := range pools {
+			if pools[i].Available {
+				candidates = append(candidates, &pools[i])
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// Count the VMs, and those sharing a tag, with a disk in each pool
+	vmCount := make(map[string]int)
+	tagCount := make(map[string]int)
+	for _, vm := range vms {
+		shared := slices.ContainsFunc(tags, func(tag string) bool { return slices.Contains(vm.Tags, tag) })
+		seen := make(map[string]bool)
+		for _, disk := range vm.Disks {
+			pool := PoolForPath(pools, disk)
+			if pool == nil || seen[pool.Path] {
+				continue
+			}
+			seen[pool.Path] = true
+			vmCount[pool.Path]++
+			if shared {
+				tagCount[pool.Path]++
+			}
+		}
+	}
+
+	// better reports whether a should be chosen over b
+	var better func(a, b *Pool) bool
+	switch strategy {
+	case PlacementMostFree:
+		better = func(a, b *Pool) bool { return a.FreeSpace > b.FreeSpace }
+	case PlacementAffinity:
+		if candidateMax(candidates, tagCount) > 0 {
+			better = func(a, b *Pool) bool {
+				if tagCount[a.Path] != tagCount[b.Path] {
+					return tagCount[a.Path] > tagCount[b.Path]
+				}
+				return a.FreeSpace > b.FreeSpace
+			}
+			break
+		}
+		fallthrough
+	default:
+		better = func(a, b *Pool) bool {
+			if vmCount[a.Path] != vmCount[b.Path] {
+				return vmCount[a.Path] < vmCount[b.Path]
+			}
+			return a.FreeSpace > b.FreeSpace
+		}
+	}
+
+	chosen := candidates[0]
+	for _, pool := range candidates[1:] {
+		if better(pool, chosen) {
+			chosen = pool
+		}
+	}
+	return chosen
+}
+
+// candidateMax returns the highest count of any candidate pool
+func candidateMax(candidates []*Pool, counts map[string]int) int {
+	highest := 0
+	for _, pool := range candidates {
+		highest = max(highest, counts[pool.Path])
+	}
+	return highest
+}
+
+// isNetworkPool reports whether a pool is an NFS or SMB share
+func isNetworkPool(pool *Pool) bool {
+	return pool.Type == PoolTypeNFS || pool.Type == PoolTypeSMB
+}
@@ -0,0 +1,60 @@
+package storage
+
+import "testing"
+
+func TestSelectPlacementPool(t *testing.T) {
+	pools := []Pool{
+		{Name: "CACHEDEV1_DATA", Path: "/share/CACHEDEV1_DATA", Type: "CACHEDEV", FreeSpace: 500, Available: true},
+		{Name: "CACHEDEV2_DATA", Path: "/share/CACHEDEV2_DATA", Type: "CACHEDEV", FreeSpace: 200, Available: true},
+		{Name: "usb", Path: "/share/external/DEV3301_1", Type: "USB", FreeSpace: 900, Available: true},
+		{Name: "nfs-vms", Path: "/share/NFSv=4/vms", Type: PoolTypeNFS, FreeSpace: 5000, Available: true},
+	}
+	vms := []PlacedVM{
+		{Tags: []string{"web"}, Disks: []string{"/share/CACHEDEV1_DATA/qnap-vm/a/a.qcow2"}},
+		{Tags: []string{"db"}, Disks: []string{"/share/CACHEDEV1_DATA/qnap-vm/b/b.qcow2", "/share/CACHEDEV1_DATA/qnap-vm/b/b-1.qcow2"}},
+		{Tags: []string{"db"}, Disks: []string{"/share/CACHEDEV2_DATA/qnap-vm/c/c.qcow2"}},
+		{Disks: []string{"/share/external/DEV3301_1/qnap-vm/d/d.qcow2"}},
+		{Disks: []string{"/share/external/DEV3301_1/qnap-vm/e/e.qcow2"}},
+	}
+
+	tests := []struct {
+		strategy string
+		tags     []string
+		want     string
+	}{
+		{PlacementBest, nil, "CACHEDEV1_DATA"},
+		{PlacementMostFree, nil, "usb"},
+		{PlacementSpread, nil, "CACHEDEV2_DATA"},
+		{PlacementAffinity, []string{"web"}, "CACHEDEV1_DATA"},
+		{PlacementAffinity, []string{"new"}, "CACHEDEV2_DATA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			pool := SelectPlacementPool(tt.strategy, pools, vms, tt.tags)
+			if pool == nil || pool.Name != tt.want {
+				t.Errorf("SelectPlacementPool(%s, %v) = %+v, want %s", tt.strategy, tt.tags, pool, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectPlacementPoolNetworkOnly(t *testing.T) {
+	pools := []Pool{
+		{Name: "nfs-vms", Path: "/share/NFSv=4/vms", Type: PoolTypeNFS, FreeSpace: 50, Available: true},
+		{Name: "smb-vms", Path: "/share/smb-vms", Type: PoolTypeSMB, FreeSpace: 80, Available: true},
+		{Name: "CACHEDEV1_DATA", Path: "/share/CACHEDEV1_DATA", Type: "CACHEDEV", FreeSpace: 500},
+	}
+
+	pool := SelectPlacementPool(PlacementSpread, pools, nil, nil)
+	if pool == nil || pool.Name != "smb-vms" {
+		t.Errorf("SelectPlacementPool() = %+v, want smb-vms", pool)
+	}
+
+	if pool := SelectPlacementPool(PlacementSpread, nil, nil, nil); pool != nil {
+		t.Errorf("SelectPlacementPool() without pools = %+v, want nil", pool)
+	}
+	if err := ValidatePlacement("random"); err == nil {
+		t.Error("ValidatePlacement accepted an unknown strategy")
+	}
+}
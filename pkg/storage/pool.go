@@ -2,7 +2,9 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
@@ -20,11 +22,14 @@ type Pool struct {
 	FreeSpace   int64  `json:"free_space_gb"`
 	Available   bool   `json:"available"`
 	Description string `json:"description"`
+	Dataset     string `json:"dataset,omitempty"` // Root dataset of a ZFS pool
 }
 
 // Manager handles storage pool detection and management
 type Manager struct {
-	sshClient *ssh.Client
+	sshClient   *ssh.Client
+	remotePools []RemotePool
+	ctx         context.Context // Context of the manager's commands; the SSH client's when nil
 }
 
 // NewManager creates a new storage manager
@@ -34,6 +39,25 @@ func NewManager(sshClient *ssh.Client) *Manager {
 	}
 }
 
+// WithContext returns a copy of the manager whose commands are terminated on the NAS
+// when ctx is cancelled
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	scoped := *m
+	scoped.ctx = ctx
+	return &scoped
+}
+
+// commandContext returns the context the manager's commands run with
+func (m *Manager) commandContext() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	if m.sshClient != nil {
+		return m.sshClient.Context()
+	}
+	return context.Background()
+}
+
 // DetectPools detects available storage pools on the QNAP device
 func (m *Manager) DetectPools() ([]Pool, error) {
 	pools := []Pool{}
@@ -54,6 +78,11 @@ func (m *Manager) DetectPools() ([]Pool, error) {
 		pools = append(pools, usbPools...)
 	}
 
+	remotePools, err := m.detectRemotePools()
+	if err == nil {
+		pools = append(pools, remotePools...)
+	}
+
 	// Get disk usage for each pool
 	for i := range pools {
 		if usage, err := m.getDiskUsage(pools[i].Path); err == nil {
@@ -78,7 +107,7 @@ func (m *Manager) detectCacheDevPools() ([]Pool, error) {
 	var pools []Pool
 
 	// Look for CACHEDEV directories
-	output, err := m.sshClient.Execute("ls -la /share/ | grep CACHEDEV")
+	output, err := m.sshClient.ExecuteContext(m.commandContext(), "ls -la /share/ | grep CACHEDEV")
 	if err != nil {
 		return pools, nil // Not an error if no CACHEDEV found
 	}
@@ -111,13 +140,13 @@ func (m *Manager) detectZFSPools() ([]Pool, error) {
 	var pools []Pool
 
 	// Check if ZFS is available
-	_, err := m.sshClient.Execute("which zpool")
+	_, err := m.sshClient.ExecuteContext(m.commandContext(), "which zpool")
 	if err != nil {
 		return pools, nil // ZFS not available
 	}
 
 	// List ZFS pools
-	output, err := m.sshClient.Execute("zpool list -H")
+	output, err := m.sshClient.ExecuteContext(m.commandContext(), "zpool list -H")
 	if err != nil {
 		return pools, nil
 	}
@@ -138,6 +167,7 @@ func (m *Manager) detectZFSPools() ([]Pool, error) {
 				Type:        "ZFS",
 				Available:   true,
 				Description: fmt.Sprintf("ZFS Storage Pool - %s", poolName),
+				Dataset:     poolName,
 			}
 
 			// Parse size if available
@@ -157,7 +187,7 @@ func (m *Manager) detectUSBPools() ([]Pool, error) {
 	var pools []Pool
 
 	// Look for USB mount points
-	output, err := m.sshClient.Execute("mount | grep usb")
+	output, err := m.sshClient.ExecuteContext(m.commandContext(), "mount | grep usb")
 	if err != nil {
 		return pools, nil
 	}
@@ -195,8 +225,8 @@ func (m *Manager) getDiskUsage(path string) (DiskUsage, error) {
 	var usage DiskUsage
 
 	// Use df command to get disk usage
-	cmd := fmt.Sprintf("df -BG %s | tail -n 1", path)
-	output, err := m.sshClient.Execute(cmd)
+	cmd := fmt.Sprintf("df -BG %s | tail -n 1", ssh.Quote(path))
+	output, err := m.sshClient.ExecuteContext(m.commandContext(), cmd)
 	if err != nil {
 		return usage, err
 	}
@@ -229,6 +259,26 @@ func (m *Manager) GetBestPool() (*Pool, error) {
 		return nil, fmt.Errorf("no storage pools found")
 	}
 
+	bestPool := SelectBestPool(pools)
+	if bestPool == nil {
+		return nil, fmt.Errorf("no available storage pools found")
+	}
+
+	return bestPool, nil
+}
+
+// poolPriority ranks pool types for automatic selection: CACHEDEV, then ZFS, then USB,
+// then network shares, which are only chosen when no local pool is available
+var poolPriority = map[string]int{
+	"CACHEDEV":  4,
+	"ZFS":       3,
+	"USB":       2,
+	PoolTypeNFS: 1,
+	PoolTypeSMB: 1,
+}
+
+// SelectBestPool returns the pool GetBestPool would choose from pools, or nil if none is available
+func SelectBestPool(pools []Pool) *Pool {
 	// Prioritize pools by type and free space
 	var bestPool *Pool
 	for i := range pools {
@@ -242,68 +292,201 @@ func (m *Manager) GetBestPool() (*Pool, error) {
 			continue
 		}
 
-		// Prefer CACHEDEV over USB, ZFS over USB
-		if pool.Type == "CACHEDEV" && bestPool.Type != "CACHEDEV" {
-			bestPool = pool
-		} else if pool.Type == "ZFS" && bestPool.Type == "USB" {
+		// Prefer local pools over network shares, then more free space
+		if poolPriority[pool.Type] > poolPriority[bestPool.Type] {
 			bestPool = pool
 		} else if pool.Type == bestPool.Type && pool.FreeSpace > bestPool.FreeSpace {
-			// Same type, prefer more free space
 			bestPool = pool
 		}
 	}
 
-	if bestPool == nil {
-		return nil, fmt.Errorf("no available storage pools found")
+	return bestPool
+}
+
+// GetPool returns the available pool with the given name or mount path
+func (m *Manager) GetPool(name string) (*Pool, error) {
+	pools, err := m.DetectPools()
+	if err != nil {
+		return nil, err
 	}
 
-	return bestPool, nil
+	var available []string
+	for i := range pools {
+		pool := &pools[i]
+		if pool.Available {
+			available = append(available, pool.Name)
+		}
+		if pool.Name != name && pool.Path != name {
+			continue
+		}
+		if !pool.Available {
+			return nil, fmt.Errorf("storage pool '%s' is not available", name)
+		}
+		return pool, nil
+	}
+
+	return nil, poolNotFoundError(name, available)
+}
+
+// poolNotFoundError describes an unknown pool name along with the pools that can be used instead
+func poolNotFoundError(name string, available []string) error {
+	if len(available) == 0 {
+		return fmt.Errorf("storage pool '%s' not found (no storage pools are available)", name)
+	}
+	return fmt.Errorf("storage pool '%s' not found; available pools: %s", name, strings.Join(available, ", "))
+}
+
+// SelectPool returns the named pool, or the best available pool when name is empty
+func (m *Manager) SelectPool(name string) (*Pool, error) {
+	if name == "" {
+		return m.GetBestPool()
+	}
+	return m.GetPool(name)
+}
+
+// NextVMDiskPath returns the first unused indexed disk path for a VM in pool, starting at index 1
+func (m *Manager) NextVMDiskPath(pool *Pool, vmName string) string {
+	for i := 1; ; i++ {
+		diskPath := m.CreateVMDiskPathIndexed(pool, vmName, i)
+		if _, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("test -e %s", ssh.Quote(diskPath))); err != nil {
+			return diskPath
+		}
+	}
 }
 
 // CreateVMDiskPath creates a disk path for a VM in the specified pool
 func (m *Manager) CreateVMDiskPath(pool *Pool, vmName string) string {
-	// Create a subdirectory for VM disks if it doesn't exist
+	return path.Join(m.vmDiskDir(pool, vmName), vmName+".qcow2")
+}
+
+// vmDiskDir returns the directory for a VM's disks, creating it if needed. ZFS pools get a
+// dataset per VM so the VM can be snapshotted and cloned with ZFS; other pools share
+// the pool's .qnap-vm/disks directory.
+func (m *Manager) vmDiskDir(pool *Pool, vmName string) string {
+	if pool.Type == "ZFS" && pool.Dataset != "" {
+		if mountpoint, err := m.EnsureVMDataset(pool, vmName); err == nil {
+			return mountpoint
+		}
+		// Fall back to the shared directory when the dataset cannot be created
+	}
+
 	vmDir := fmt.Sprintf("%s/.qnap-vm/disks", pool.Path)
-	diskPath := fmt.Sprintf("%s/%s.qcow2", vmDir, vmName)
 
 	// Create the directory (ignore errors if it already exists)
-	if _, err := m.sshClient.Execute(fmt.Sprintf("mkdir -p %s", vmDir)); err != nil {
+	if _, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("mkdir -p %s", ssh.Quote(vmDir))); err != nil {
 		// Directory creation failure is not critical for path generation
 		// The actual mkdir will be attempted during VM creation
 	}
 
-	return diskPath
+	return vmDir
 }
 
-// CreateVMDisk creates a disk image for a VM
-func (m *Manager) CreateVMDisk(diskPath, size string) error {
-	// Use qemu-img to create the disk image with proper library path
-	possibleBasePaths := []string{"/QVS", "/KVM"}
+// DiskPathInPool returns the path for a disk image file name in pool's disk directory,
+// creating the directory if needed
+func (m *Manager) DiskPathInPool(pool *Pool, fileName string) string {
+	vmDir := fmt.Sprintf("%s/.qnap-vm/disks", pool.Path)
+	if _, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("mkdir -p %s", ssh.Quote(vmDir))); err != nil {
+		// Directory creation failure surfaces when the disk is written
+	}
+	return path.Join(vmDir, fileName)
+}
+
+// CopyDisk copies a disk image file, keeping it sparse where the device's cp supports it
+func (m *Manager) CopyDisk(srcPath, dstPath string) error {
+	src, dst := ssh.Quote(srcPath), ssh.Quote(dstPath)
+	cmd := fmt.Sprintf("cp --sparse=always %s %s 2>/dev/null || cp %s %s", src, dst, src, dst)
+	if output, err := m.sshClient.ExecuteContext(m.commandContext(), cmd); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w\nOutput: %s", srcPath, dstPath, err, output)
+	}
+	return nil
+}
 
-	var qemuImgPath string
-	var libPath string
+// RemoveDisk deletes a disk image file
+func (m *Manager) RemoveDisk(diskPath string) error {
+	if output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("rm -f %s", ssh.Quote(diskPath))); err != nil {
+		return fmt.Errorf("failed to remove %s: %w\nOutput: %s", diskPath, err, output)
+	}
+	return nil
+}
+
+// CreateVMDiskPathIndexed returns the path for a VM's disk at the given index.
+// Index 0 is the primary disk and keeps the name used by CreateVMDiskPath.
+func (m *Manager) CreateVMDiskPathIndexed(pool *Pool, vmName string, index int) string {
+	if index == 0 {
+		return m.CreateVMDiskPath(pool, vmName)
+	}
+	return path.Join(m.vmDiskDir(pool, vmName), fmt.Sprintf("%s-disk%d.qcow2", vmName, index))
+}
+
+// findQemuImg locates qemu-img and its library path on the QNAP device
+func (m *Manager) findQemuImg() (qemuImgPath, libPath string, err error) {
+	possibleBasePaths := []string{"/QVS", "/KVM"}
 
 	for _, basePath := range possibleBasePaths {
 		binPath := fmt.Sprintf("%s/usr/bin", basePath)
 		testCmd := fmt.Sprintf("test -x %s/qemu-img && echo 'found'", binPath)
-		if output, err := m.sshClient.Execute(testCmd); err == nil && strings.Contains(output, "found") {
+		if output, err := m.sshClient.ExecuteContext(m.commandContext(), testCmd); err == nil && strings.Contains(output, "found") {
 			qemuImgPath = fmt.Sprintf("%s/qemu-img", binPath)
 			libPath = fmt.Sprintf("%s/usr/lib:%s/usr/lib64", basePath, basePath)
-			break
+			return qemuImgPath, libPath, nil
 		}
 	}
 
-	if qemuImgPath == "" {
-		return fmt.Errorf("qemu-img not found in expected paths")
+	return "", "", fmt.Errorf("qemu-img not found in expected paths")
+}
+
+// QemuImgVersion returns the version line of the qemu-img found on the device
+func (m *Manager) QemuImgVersion() (string, error) {
+	output, err := m.execQemuImg("--version")
+	if err != nil {
+		return "", fmt.Errorf("qemu-img does not run: %w\nOutput: %s", err, output)
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	return line, nil
+}
+
+// CheckWritable creates and removes a file in the pool to prove VMs' disks can be
+// written there
+func (m *Manager) CheckWritable(pool *Pool) error {
+	probe := ssh.Quote(path.Join(pool.Path, ".qnap-vm-write-test"))
+	if output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("touch %s && rm -f %s", probe, probe)); err != nil {
+		return fmt.Errorf("cannot write to %s: %w\nOutput: %s", pool.Path, err, strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// execQemuImg runs a qemu-img subcommand with the proper library path
+func (m *Manager) execQemuImg(args string) (string, error) {
+	qemuImgPath, libPath, err := m.findQemuImg()
+	if err != nil {
+		return "", err
 	}
 
-	// Create the disk image with proper library path
 	cmd := fmt.Sprintf(`
 		export LD_LIBRARY_PATH=%s:$LD_LIBRARY_PATH
-		%s create -f qcow2 %s %s
-	`, libPath, qemuImgPath, diskPath, size)
+		%s %s
+	`, libPath, qemuImgPath, args)
+
+	return m.sshClient.ExecuteContext(m.commandContext(), cmd)
+}
+
+// CreateVMDisk creates a disk image for a VM
+func (m *Manager) CreateVMDisk(diskPath, size string) error {
+	return m.CreateVMDiskWithOptions(diskPath, size, Qcow2Options{})
+}
 
-	output, err := m.sshClient.Execute(cmd)
+// CreateVMDiskWithOptions creates a disk image for a VM with qcow2 tuning options
+func (m *Manager) CreateVMDiskWithOptions(diskPath, size string, opts Qcow2Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	// Use qemu-img to create the disk image with proper library path
+	args := "create -f qcow2"
+	if o := opts.String(); o != "" {
+		args += " -o " + o
+	}
+	output, err := m.execQemuImg(fmt.Sprintf("%s %s %s", args, ssh.Quote(diskPath), size))
 	if err != nil {
 		return fmt.Errorf("failed to create disk image: %w\nOutput: %s", err, output)
 	}
@@ -311,6 +494,38 @@ func (m *Manager) CreateVMDisk(diskPath, size string) error {
 	return nil
 }
 
+// ConvertDisk converts a disk image in srcFormat (raw, vmdk, qcow2, ...) to a qcow2 image
+func (m *Manager) ConvertDisk(srcPath, srcFormat, dstPath string) error {
+	return m.ConvertDiskTo(srcPath, srcFormat, dstPath, "qcow2")
+}
+
+// ConvertDiskTo converts a disk image in srcFormat to an image in dstFormat. An image with
+// a backing chain is flattened into a standalone copy.
+func (m *Manager) ConvertDiskTo(srcPath, srcFormat, dstPath, dstFormat string) error {
+	args := fmt.Sprintf("convert -O %s %s %s", dstFormat, ssh.Quote(srcPath), ssh.Quote(dstPath))
+	if srcFormat != "" {
+		args = fmt.Sprintf("convert -f %s -O %s %s %s", srcFormat, dstFormat, ssh.Quote(srcPath), ssh.Quote(dstPath))
+	}
+
+	output, err := m.execQemuImg(args)
+	if err != nil {
+		return fmt.Errorf("failed to convert disk image: %w\nOutput: %s", err, output)
+	}
+
+	return nil
+}
+
+// RebaseDisk points a qcow2 image at backingPath without touching its data, as when
+// restoring an incremental backup layer onto the image it was taken against
+func (m *Manager) RebaseDisk(diskPath, backingPath, backingFormat string) error {
+	output, err := m.execQemuImg(fmt.Sprintf("rebase -u -f qcow2 -b %s -F %s %s",
+		ssh.Quote(backingPath), backingFormat, ssh.Quote(diskPath)))
+	if err != nil {
+		return fmt.Errorf("failed to rebase %s onto %s: %w\nOutput: %s", diskPath, backingPath, err, output)
+	}
+	return nil
+}
+
 // parseSize parses a size string like "123G", "456M", "789K" and returns size in GB
 func parseSize(sizeStr string) int64 {
 	if sizeStr == "" {
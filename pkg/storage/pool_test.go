@@ -2,6 +2,7 @@ package storage
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -96,27 +97,9 @@ func TestBestPoolSelection(t *testing.T) {
 		},
 	}
 
-	// Simulate the best pool selection logic
-	var bestPool *Pool
-	for i := range pools {
-		pool := &pools[i]
-		if !pool.Available {
-			continue
-		}
-
-		if bestPool == nil {
-			bestPool = pool
-			continue
-		}
-
-		// Prefer CACHEDEV over USB, ZFS over USB
-		if pool.Type == "CACHEDEV" && bestPool.Type != "CACHEDEV" {
-			bestPool = pool
-		} else if pool.Type == "ZFS" && bestPool.Type == "USB" {
-			bestPool = pool
-		} else if pool.Type == bestPool.Type && pool.FreeSpace > bestPool.FreeSpace {
-			bestPool = pool
-		}
+	bestPool := SelectBestPool(pools)
+	if bestPool == nil {
+		t.Fatal("Expected a pool to be selected")
 	}
 
 	// Should prefer CACHEDEV over others
@@ -128,3 +111,22 @@ func TestBestPoolSelection(t *testing.T) {
 		t.Errorf("Expected CACHEDEV1_DATA to be selected, got %s", bestPool.Name)
 	}
 }
+
+func TestSelectBestPoolNoneAvailable(t *testing.T) {
+	pools := []Pool{{Name: "usb-device", Type: "USB", Available: false}}
+	if pool := SelectBestPool(pools); pool != nil {
+		t.Errorf("Expected no pool, got %s", pool.Name)
+	}
+}
+
+func TestPoolNotFoundError(t *testing.T) {
+	err := poolNotFoundError("DATA2", []string{"CACHEDEV1_DATA", "zpool1"})
+	if err == nil || !strings.Contains(err.Error(), "available pools: CACHEDEV1_DATA, zpool1") {
+		t.Errorf("Expected error listing available pools, got %v", err)
+	}
+
+	err = poolNotFoundError("DATA2", nil)
+	if err == nil || !strings.Contains(err.Error(), "no storage pools are available") {
+		t.Errorf("Expected error for no available pools, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Qcow2CompressionTypes lists the compression types qemu-img accepts for qcow2 images
+var Qcow2CompressionTypes = []string{"zlib", "zstd"}
+
+// Qcow2Options are advanced 'qemu-img create -o' settings for new qcow2 images.
+// Empty fields keep the qemu-img defaults.
+type Qcow2Options struct {
+	ClusterSize     string // Cluster size, a power of two from 512 to 2M (e.g. "2M")
+	CompressionType string // Compression for compressed clusters: zlib or zstd
+	LazyRefcounts   bool   // Defer refcount updates; faster writes, needs a check after a crash
+}
+
+// Validate checks the options against the limits qemu-img enforces
+func (o Qcow2Options) Validate() error {
+	if o.ClusterSize != "" {
+		size, err := SizeBytes(o.ClusterSize)
+		if err != nil {
+			return fmt.Errorf("invalid cluster size: %w", err)
+		}
+		if size < 512 || size > 2<<20 || size&(size-1) != 0 {
+			return fmt.Errorf("invalid cluster size '%s' (use a power of two from 512 to 2M, e.g. 64K or 2M)", o.ClusterSize)
+		}
+	}
+
+	if o.CompressionType != "" {
+		valid := false
+		for _, t := range Qcow2CompressionTypes {
+			if o.CompressionType == t {
+				valid = true
+			}
+		}
+		if !valid {
+			return fmt.Errorf("invalid compression type '%s' (use %s)", o.CompressionType, strings.Join(Qcow2CompressionTypes, ", "))
+		}
+	}
+
+	return nil
+}
+
+// String renders the options as a qemu-img -o value, or "" when all defaults apply
+func (o Qcow2Options) String() string {
+	var opts []string
+	if o.ClusterSize != "" {
+		opts = append(opts, "cluster_size="+strings.ToUpper(o.ClusterSize))
+	}
+	if o.CompressionType != "" {
+		opts = append(opts, "compression_type="+o.CompressionType)
+	}
+	if o.LazyRefcounts {
+		opts = append(opts, "lazy_refcounts=on")
+	}
+	return strings.Join(opts, ",")
+}
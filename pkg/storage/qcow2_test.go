@@ -0,0 +1,31 @@
+package storage
+
+import "testing"
+
+func TestQcow2Options(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Qcow2Options
+		want    string
+		wantErr bool
+	}{
+		{"defaults", Qcow2Options{}, "", false},
+		{"all", Qcow2Options{ClusterSize: "2m", CompressionType: "zstd", LazyRefcounts: true}, "cluster_size=2M,compression_type=zstd,lazy_refcounts=on", false},
+		{"small cluster", Qcow2Options{ClusterSize: "512"}, "cluster_size=512", false},
+		{"cluster too large", Qcow2Options{ClusterSize: "4M"}, "", true},
+		{"cluster not power of two", Qcow2Options{ClusterSize: "96K"}, "", true},
+		{"unknown compression", Qcow2Options{CompressionType: "lz4"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && tt.opts.String() != tt.want {
+				t.Errorf("String() = %q, expected %q", tt.opts.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// Remote pool types, for pools on mounted network shares
+const (
+	PoolTypeNFS = "NFS"
+	PoolTypeSMB = "SMB"
+)
+
+// RemotePool declares a storage pool on a mounted NFS or SMB share
+type RemotePool struct {
+	Name string // Pool name used with --pool
+	Path string // Mount point (or a directory below it) on the QNAP device
+	Type string // PoolTypeNFS or PoolTypeSMB; empty detects it from the mount
+}
+
+// remoteFSTypes maps mount filesystem types to remote pool types
+var remoteFSTypes = map[string]string{
+	"nfs":  PoolTypeNFS,
+	"nfs4": PoolTypeNFS,
+	"cifs": PoolTypeSMB,
+	"smb3": PoolTypeSMB,
+}
+
+// SetRemotePools sets the pools declared in the configuration, which DetectPools
+// reports alongside the detected ones
+func (m *Manager) SetRemotePools(pools []RemotePool) {
+	m.remotePools = pools
+}
+
+// ParseRemotePoolType normalizes a configured remote pool type ("nfs", "smb" or "cifs")
+func ParseRemotePoolType(poolType string) (string, error) {
+	switch strings.ToLower(poolType) {
+	case "":
+		return "", nil
+	case "nfs":
+		return PoolTypeNFS, nil
+	case "smb", "cifs":
+		return PoolTypeSMB, nil
+	}
+	return "", fmt.Errorf("invalid pool type '%s' (use nfs or smb)", poolType)
+}
+
+// detectRemotePools returns the configured remote pools and any other NFS/SMB shares mounted under /share
+func (m *Manager) detectRemotePools() ([]Pool, error) {
+	output, err := m.sshClient.ExecuteContext(m.commandContext(), "mount")
+	if err != nil {
+		return nil, err
+	}
+	mounts := parseRemoteMounts(output)
+
+	var pools []Pool
+	configured := make(map[string]bool)
+	for _, remote := range m.remotePools {
+		pool := Pool{
+			Name:        remote.Name,
+			Path:        strings.TrimRight(remote.Path, "/"),
+			Description: fmt.Sprintf("Configured network share - %s", remote.Path),
+		}
+		pool.Type, _ = ParseRemotePoolType(remote.Type)
+
+		// The pool is usable when its path is on a mounted share and writable
+		mount := remoteMountFor(mounts, pool.Path)
+		if mount != nil {
+			if pool.Type == "" {
+				pool.Type = mount.Type
+			}
+			_, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("test -d %s -a -w %s", ssh.Quote(pool.Path), ssh.Quote(pool.Path)))
+			pool.Available = err == nil
+		}
+		if pool.Type == "" {
+			pool.Type = PoolTypeNFS
+		}
+
+		configured[pool.Path] = true
+		pools = append(pools, pool)
+	}
+
+	for _, mount := range mounts {
+		if configured[mount.Path] || !strings.HasPrefix(mount.Path, "/share/") {
+			continue
+		}
+		name := strings.ToLower(mount.Type) + "-" + strings.ReplaceAll(strings.TrimPrefix(mount.Path, "/share/"), "/", "-")
+		pools = append(pools, Pool{
+			Name:        name,
+			Path:        mount.Path,
+			Type:        mount.Type,
+			Available:   true,
+			Description: fmt.Sprintf("%s Share - %s", mount.Type, mount.Source),
+		})
+	}
+
+	return pools, nil
+}
+
+// remoteMount is a mounted NFS or SMB share
+type remoteMount struct {
+	Source string
+	Path   string
+	Type   string
+}
+
+// parseRemoteMounts extracts NFS and SMB mounts from 'mount' output such as
+// "nas2:/export/vms on /share/NFSv=4/vms type nfs4 (rw,relatime)"
+func parseRemoteMounts(output string) []remoteMount {
+	var mounts []remoteMount
+	for _, line := range strings.Split(output, "\n") {
+		source, rest, found := strings.Cut(strings.TrimSpace(line), " on ")
+		if !found {
+			continue
+		}
+		mountPoint, rest, found := strings.Cut(rest, " type ")
+		if !found {
+			continue
+		}
+		fsType, _, _ := strings.Cut(rest, " ")
+
+		poolType, ok := remoteFSTypes[fsType]
+		if !ok {
+			continue
+		}
+		mounts = append(mounts, remoteMount{
+			Source: source,
+			Path:   strings.ReplaceAll(mountPoint, `\040`, " "),
+			Type:   poolType,
+		})
+	}
+	return mounts
+}
+
+// remoteMountFor returns the mount holding dir, preferring the deepest mount point
+func remoteMountFor(mounts []remoteMount, dir string) *remoteMount {
+	var best *remoteMount
+	for i := range mounts {
+		mount := &mounts[i]
+		if dir != mount.Path && !strings.HasPrefix(dir, mount.Path+"/") {
+			continue
+		}
+		if best == nil || len(mount.Path) > len(best.Path) {
+			best = mount
+		}
+	}
+	return best
+}
@@ -0,0 +1,78 @@
+package storage
+
+import "testing"
+
+func TestParseRemoteMounts(t *testing.T) {
+	output := `/dev/mapper/cachedev1 on /share/CACHEDEV1_DATA type ext4 (rw,usrjquota=aquota.user)
+nas2:/export/vms on /share/NFSv=4/vms type nfs4 (rw,relatime,vers=4.1)
+//fileserver/VM\040Store on /share/external/VM\040Store type cifs (rw,vers=3.0)
+/dev/sdb1 on /share/USB/SDisk type ext4 (rw,relatime)
+`
+
+	mounts := parseRemoteMounts(output)
+	if len(mounts) != 2 {
+		t.Fatalf("parseRemoteMounts() returned %d mounts, want 2: %+v", len(mounts), mounts)
+	}
+
+	if mounts[0].Path != "/share/NFSv=4/vms" || mounts[0].Type != PoolTypeNFS || mounts[0].Source != "nas2:/export/vms" {
+		t.Errorf("NFS mount = %+v", mounts[0])
+	}
+	if mounts[1].Path != "/share/external/VM Store" || mounts[1].Type != PoolTypeSMB {
+		t.Errorf("SMB mount = %+v", mounts[1])
+	}
+}
+
+func TestRemoteMountFor(t *testing.T) {
+	mounts := []remoteMount{
+		{Path: "/share/nfs", Type: PoolTypeNFS},
+		{Path: "/share/nfs/inner", Type: PoolTypeSMB},
+	}
+
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{"/share/nfs", "/share/nfs"},
+		{"/share/nfs/vms", "/share/nfs"},
+		{"/share/nfs/inner/vms", "/share/nfs/inner"},
+		{"/share/nfsother", ""},
+	}
+
+	for _, tt := range tests {
+		got := ""
+		if mount := remoteMountFor(mounts, tt.dir); mount != nil {
+			got = mount.Path
+		}
+		if got != tt.want {
+			t.Errorf("remoteMountFor(%q) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestParseRemotePoolType(t *testing.T) {
+	for input, want := range map[string]string{"": "", "nfs": PoolTypeNFS, "SMB": PoolTypeSMB, "cifs": PoolTypeSMB} {
+		got, err := ParseRemotePoolType(input)
+		if err != nil || got != want {
+			t.Errorf("ParseRemotePoolType(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+
+	if _, err := ParseRemotePoolType("iscsi"); err == nil {
+		t.Error("ParseRemotePoolType(\"iscsi\") expected error")
+	}
+}
+
+func TestSelectBestPoolPrefersLocalOverRemote(t *testing.T) {
+	pools := []Pool{
+		{Name: "nfs-vms", Type: PoolTypeNFS, FreeSpace: 5000, Available: true},
+		{Name: "usb-disk", Type: "USB", FreeSpace: 10, Available: true},
+	}
+	if best := SelectBestPool(pools); best == nil || best.Name != "usb-disk" {
+		t.Errorf("SelectBestPool() = %v, want usb-disk", best)
+	}
+
+	pools[1].Available = false
+	if best := SelectBestPool(pools); best == nil || best.Name != "nfs-vms" {
+		t.Errorf("SelectBestPool() = %v, want nfs-vms when no local pool is available", best)
+	}
+}
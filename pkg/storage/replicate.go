@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// ReplicationPrefix names the snapshots 'replicate' takes; only these are pruned
+const ReplicationPrefix = "repl-"
+
+// DatasetExists reports whether a dataset exists
+func (m *Manager) DatasetExists(dataset string) bool {
+	_, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("zfs list -H -o name %s", ssh.Quote(dataset)))
+	return err == nil
+}
+
+// CreateParentDataset creates the parent of dataset, so a stream can be received into it
+func (m *Manager) CreateParentDataset(dataset string) error {
+	parent := path.Dir(dataset)
+	if output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("zfs create -p %s", ssh.Quote(parent))); err != nil {
+		return fmt.Errorf("failed to create dataset %s: %w\nOutput: %s", parent, err, output)
+	}
+	return nil
+}
+
+// MountDataset mounts a received dataset and returns its mount point
+func (m *Manager) MountDataset(dataset string) (string, error) {
+	if output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("zfs mount %s 2>&1 || zfs get -H -o value mounted %s | grep -q yes", ssh.Quote(dataset), ssh.Quote(dataset))); err != nil {
+		return "", fmt.Errorf("failed to mount %s: %w\nOutput: %s", dataset, err, output)
+	}
+	return m.DatasetMountpoint(dataset)
+}
+
+// LatestCommonSnapshot returns the newest replication snapshot present on both sides, or ""
+func LatestCommonSnapshot(source, target []DatasetSnapshot) string {
+	onTarget := make(map[string]bool)
+	for _, snapshot := range target {
+		onTarget[snapshot.Name] = true
+	}
+
+	// Snapshots are sorted oldest first
+	for i := len(source) - 1; i >= 0; i-- {
+		name := source[i].Name
+		if strings.HasPrefix(name, ReplicationPrefix) && onTarget[name] {
+			return name
+		}
+	}
+	return ""
+}
+
+// zfsSendCommand builds a full or incremental (from base) send of dataset@snapshot
+func zfsSendCommand(dataset, base, snapshot string) string {
+	if base == "" {
+		return fmt.Sprintf("zfs send %s", ssh.Quote(dataset+"@"+snapshot))
+	}
+	return fmt.Sprintf("zfs send -i %s %s", ssh.Quote("@"+base), ssh.Quote(dataset+"@"+snapshot))
+}
+
+// zfsReceiveCommand receives a stream into dataset without mounting it. -F rolls the
+// target back to the last received snapshot, discarding changes made on the target.
+func zfsReceiveCommand(dataset string) string {
+	return fmt.Sprintf("zfs receive -F -u %s", ssh.Quote(dataset))
+}
+
+// ReplacementDataset returns the dataset a full stream replacing the existing dataset is
+// received into. zfs receive refuses a full stream onto a dataset with snapshots, so the
+// copy is received next to it and only takes its place once complete.
+func ReplacementDataset(dataset, snapshot string) string {
+	return dataset + "-" + snapshot
+}
+
+// replaceDatasetCommands returns the commands giving replacement the place of dataset:
+// the old dataset is destroyed with its snapshots, then replacement is renamed
+func replaceDatasetCommands(dataset, replacement string) []string {
+	return []string{
+		fmt.Sprintf("zfs destroy -r %s", ssh.Quote(dataset)),
+		fmt.Sprintf("zfs rename %s %s", ssh.Quote(replacement), ssh.Quote(dataset)),
+	}
+}
+
+// ReplaceDataset destroys dataset and its snapshots and renames replacement to it
+func (m *Manager) ReplaceDataset(dataset, replacement string) error {
+	for _, cmd := range replaceDatasetCommands(dataset, replacement) {
+		if output, err := m.sshClient.ExecuteContext(m.commandContext(), cmd); err != nil {
+			return fmt.Errorf("failed to replace %s with %s: %w\nOutput: %s", dataset, replacement, err, output)
+		}
+	}
+	return nil
+}
+
+// countingWriter counts bytes passing through to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ReplicateDataset streams sourceDataset@snapshot from source to targetDataset on target
+// through this machine, incrementally from base when it is set. It returns the stream size.
+func ReplicateDataset(source *Manager, sourceDataset, base, snapshot string, target *Manager, targetDataset string) (int64, error) {
+	reader, writer := io.Pipe()
+	counter := &countingWriter{w: writer}
+
+	var wg sync.WaitGroup
+	var sendErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sendErr = source.sshClient.ExecuteStreamContext(source.commandContext(), zfsSendCommand(sourceDataset, base, snapshot), nil, counter)
+		// Closing the pipe ends the receive; an error makes it fail instead of committing a partial stream
+		writer.CloseWithError(sendErr)
+	}()
+
+	receiveErr := target.sshClient.ExecuteStreamContext(target.commandContext(), zfsReceiveCommand(targetDataset), reader, io.Discard)
+	// Unblock the sender if the receive stopped early
+	reader.CloseWithError(io.ErrClosedPipe)
+	wg.Wait()
+
+	// A failure on one side usually breaks the other, so report both
+	if sendErr != nil && receiveErr != nil {
+		return counter.n, fmt.Errorf("replication of %s@%s failed: send: %v; receive: %w", sourceDataset, snapshot, sendErr, receiveErr)
+	}
+	if sendErr != nil {
+		return counter.n, fmt.Errorf("zfs send of %s@%s failed: %w", sourceDataset, snapshot, sendErr)
+	}
+	if receiveErr != nil {
+		return counter.n, fmt.Errorf("zfs receive into %s failed: %w", targetDataset, receiveErr)
+	}
+	return counter.n, nil
+}
+
+// PruneReplicationSnapshots destroys all but the newest keep replication snapshots of a
+// dataset and returns the names destroyed
+func (m *Manager) PruneReplicationSnapshots(dataset string, keep int) ([]string, error) {
+	snapshots, err := m.ListDatasetSnapshots(dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	var replication []string
+	for _, snapshot := range snapshots {
+		if strings.HasPrefix(snapshot.Name, ReplicationPrefix) {
+			replication = append(replication, snapshot.Name)
+		}
+	}
+
+	var destroyed []string
+	for i := 0; i < len(replication)-keep; i++ {
+		if err := m.DestroyDatasetSnapshot(dataset, replication[i]); err != nil {
+			return destroyed, err
+		}
+		destroyed = append(destroyed, replication[i])
+	}
+	return destroyed, nil
+}
@@ -0,0 +1,63 @@
+package storage
+
+import "testing"
+
+func TestLatestCommonSnapshot(t *testing.T) {
+	source := []DatasetSnapshot{{Name: "repl-1"}, {Name: "repl-2"}, {Name: "manual"}, {Name: "repl-3"}}
+
+	tests := []struct {
+		name   string
+		target []DatasetSnapshot
+		want   string
+	}{
+		{"newest shared", []DatasetSnapshot{{Name: "repl-1"}, {Name: "repl-2"}}, "repl-2"},
+		{"ignores non-replication snapshots", []DatasetSnapshot{{Name: "manual"}}, ""},
+		{"empty target", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LatestCommonSnapshot(source, tt.target); got != tt.want {
+				t.Errorf("LatestCommonSnapshot() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZFSStreamCommands(t *testing.T) {
+	if got := zfsSendCommand("zpool1/qnap-vm/web", "", "repl-2"); got != "zfs send 'zpool1/qnap-vm/web@repl-2'" {
+		t.Errorf("full send = %s", got)
+	}
+	if got := zfsSendCommand("zpool1/qnap-vm/web", "repl-1", "repl-2"); got != "zfs send -i '@repl-1' 'zpool1/qnap-vm/web@repl-2'" {
+		t.Errorf("incremental send = %s", got)
+	}
+	if got := zfsReceiveCommand("zpool2/qnap-vm/web"); got != "zfs receive -F -u 'zpool2/qnap-vm/web'" {
+		t.Errorf("receive = %s", got)
+	}
+}
+
+func TestFullReplacementCommands(t *testing.T) {
+	// The full stream goes to a new dataset, which zfs receive accepts without -F ...
+	replacement := ReplacementDataset("zpool2/qnap-vm/web", "repl-20261018-090000")
+	if replacement != "zpool2/qnap-vm/web-repl-20261018-090000" {
+		t.Errorf("ReplacementDataset() = %s", replacement)
+	}
+	if got := zfsReceiveCommand(replacement); got != "zfs receive -F -u 'zpool2/qnap-vm/web-repl-20261018-090000'" {
+		t.Errorf("receive = %s", got)
+	}
+
+	// ... and only then takes the place of the old copy and its snapshots
+	want := []string{
+		"zfs destroy -r 'zpool2/qnap-vm/web'",
+		"zfs rename 'zpool2/qnap-vm/web-repl-20261018-090000' 'zpool2/qnap-vm/web'",
+	}
+	got := replaceDatasetCommands("zpool2/qnap-vm/web", replacement)
+	if len(got) != len(want) {
+		t.Fatalf("replaceDatasetCommands() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("command %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// SpaceRequirement is the space an operation needs in a pool
+type SpaceRequirement struct {
+	Pool  *Pool
+	Bytes int64
+}
+
+// InsufficientSpaceError reports a pool that cannot hold what an operation needs
+type InsufficientSpaceError struct {
+	Pool     string
+	Path     string
+	Required int64 // Bytes needed
+	Free     int64 // Bytes free
+}
+
+func (e *InsufficientSpaceError) Error() string {
+	return fmt.Sprintf("not enough free space in storage pool '%s' (%s): need %s, %s free",
+		e.Pool, e.Path, formatGiB(e.Required), formatGiB(e.Free))
+}
+
+// formatGiB formats a byte count in GiB, matching the units of Pool.FreeSpace
+func formatGiB(bytes int64) string {
+	return fmt.Sprintf("%.1f GB", float64(bytes)/(1<<30))
+}
+
+// SizeBytes converts a qemu-img size such as 20G, 512M or 1.5T to bytes.
+// A size without a unit is taken as bytes, as qemu-img does.
+func SizeBytes(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	if !sizePattern.MatchString(size) {
+		return 0, fmt.Errorf("invalid size '%s' (e.g. 20G, 512M)", size)
+	}
+
+	multiplier := float64(1)
+	switch size[len(size)-1] {
+	case 'K':
+		multiplier = 1 << 10
+	case 'M':
+		multiplier = 1 << 20
+	case 'G':
+		multiplier = 1 << 30
+	case 'T':
+		multiplier = 1 << 40
+	}
+	number := strings.TrimRight(size, "KMGT")
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s': %w", size, err)
+	}
+
+	return int64(value * multiplier), nil
+}
+
+// CheckSpace sums the requirements per pool and returns an InsufficientSpaceError
+// for the first pool whose free space cannot hold them
+func CheckSpace(reqs []SpaceRequirement) error {
+	required := make(map[string]int64)
+	var order []*Pool
+	for _, req := range reqs {
+		if req.Pool == nil {
+			continue
+		}
+		if _, seen := required[req.Pool.Path]; !seen {
+			order = append(order, req.Pool)
+		}
+		required[req.Pool.Path] += req.Bytes
+	}
+
+	for _, pool := range order {
+		free := pool.FreeSpace << 30
+		if required[pool.Path] > free {
+			return &InsufficientSpaceError{
+				Pool:     pool.Name,
+				Path:     pool.Path,
+				Required: required[pool.Path],
+				Free:     free,
+			}
+		}
+	}
+
+	return nil
+}
+
+// PoolForPath returns the pool whose mount path contains filePath, or nil if none does
+func PoolForPath(pools []Pool, filePath string) *Pool {
+	var match *Pool
+	for i := range pools {
+		pool := &pools[i]
+		if !strings.HasPrefix(filePath, strings.TrimSuffix(pool.Path, "/")+"/") {
+			continue
+		}
+		if match == nil || len(pool.Path) > len(match.Path) {
+			match = pool
+		}
+	}
+	return match
+}
+
+// AllocatedBytes returns the space the files at paths occupy on disk, which for
+// sparse qcow2 images is usually far less than their virtual size
+func (m *Manager) AllocatedBytes(paths []string) (int64, error) {
+	if len(paths) == 0 {
+		return 0, nil
+	}
+
+	var quoted []string
+	for _, p := range paths {
+		quoted = append(quoted, ssh.Quote(p))
+	}
+
+	output, err := m.sshClient.ExecuteContext(m.commandContext(), "du -k "+strings.Join(quoted, " "))
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure disk usage: %w\nOutput: %s", err, output)
+	}
+
+	var total int64
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if kb, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			total += kb << 10
+		}
+	}
+
+	return total, nil
+}
+
+// ResolvePath follows symlinks such as /share/Backups -> /share/CACHEDEV1_DATA/Backups so the
+// path can be matched to a pool. Paths that do not exist yet are returned unchanged.
+func (m *Manager) ResolvePath(p string) string {
+	output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("readlink -f %s", ssh.Quote(p)))
+	if resolved := strings.TrimSpace(output); err == nil && resolved != "" {
+		return resolved
+	}
+	return p
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSizeBytes(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{"20G", 20 << 30, false},
+		{"512m", 512 << 20, false},
+		{"1.5T", 3 << 39, false},
+		{"64K", 64 << 10, false},
+		{"4096", 4096, false},
+		{"big", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			got, err := SizeBytes(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SizeBytes(%q) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("SizeBytes(%q) = %d, expected %d", tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSpace(t *testing.T) {
+	fast := &Pool{Name: "CACHEDEV1_DATA", Path: "/share/CACHEDEV1_DATA", FreeSpace: 50}
+	slow := &Pool{Name: "CACHEDEV2_DATA", Path: "/share/CACHEDEV2_DATA", FreeSpace: 10}
+
+	if err := CheckSpace([]SpaceRequirement{
+		{Pool: fast, Bytes: 30 << 30},
+		{Pool: slow, Bytes: 10 << 30},
+	}); err != nil {
+		t.Errorf("CheckSpace() unexpected error: %v", err)
+	}
+
+	// Requirements in the same pool add up
+	err := CheckSpace([]SpaceRequirement{
+		{Pool: fast, Bytes: 30 << 30},
+		{Pool: slow, Bytes: 1 << 30},
+		{Pool: fast, Bytes: 30 << 30},
+	})
+	var spaceErr *InsufficientSpaceError
+	if !errors.As(err, &spaceErr) {
+		t.Fatalf("CheckSpace() error = %v, expected InsufficientSpaceError", err)
+	}
+	if spaceErr.Pool != fast.Name || spaceErr.Required != 60<<30 || spaceErr.Free != 50<<30 {
+		t.Errorf("CheckSpace() error = %+v", spaceErr)
+	}
+
+	// Unknown pools are not checked
+	if err := CheckSpace([]SpaceRequirement{{Pool: nil, Bytes: 1 << 40}}); err != nil {
+		t.Errorf("CheckSpace() with nil pool: %v", err)
+	}
+}
+
+func TestPoolForPath(t *testing.T) {
+	pools := []Pool{
+		{Name: "CACHEDEV1_DATA", Path: "/share/CACHEDEV1_DATA"},
+		{Name: "CACHEDEV10_DATA", Path: "/share/CACHEDEV10_DATA"},
+		{Name: "backup", Path: "/share/CACHEDEV1_DATA/backup"},
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2", "CACHEDEV1_DATA"},
+		{"/share/CACHEDEV10_DATA/.qnap-vm/disks/web.qcow2", "CACHEDEV10_DATA"},
+		{"/share/CACHEDEV1_DATA/backup/web.qcow2", "backup"},
+		{"/share/external/web.qcow2", ""},
+	}
+
+	for _, tt := range tests {
+		got := PoolForPath(pools, tt.path)
+		name := ""
+		if got != nil {
+			name = got.Name
+		}
+		if name != tt.want {
+			t.Errorf("PoolForPath(%q) = %q, expected %q", tt.path, name, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// rotationalScript prints the rotational flag (1 for spinning disks, 0 for SSDs) of
+// every physical device under the filesystem holding a path, following device-mapper
+// and md RAID members down to the disks. Paths on network shares or ZFS print nothing.
+const rotationalScript = `dev=$(df -P %s 2>/dev/null | awk 'NR==2 {print $1}')
+case "$dev" in /dev/*) ;; *) exit 0 ;; esac
+walk() {
+	b=/sys/class/block/$1
+	if [ -n "$(ls "$b/slaves" 2>/dev/null)" ]; then
+		for s in "$b"/slaves/*; do walk "$(basename "$s")"; done
+		return
+	fi
+	[ -e "$b/queue/rotational" ] || b=$b/..
+	cat "$b/queue/rotational" 2>/dev/null
+}
+walk "$(basename "$(readlink -f "$dev")")"`
+
+// IsSSD reports whether a pool is backed only by solid-state disks. Pools whose
+// disks can't be identified, such as network shares, are reported as not SSD.
+func (m *Manager) IsSSD(pool *Pool) (bool, error) {
+	if isNetworkPool(pool) {
+		return false, nil
+	}
+	output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf(rotationalScript, ssh.Quote(pool.Path)))
+	if err != nil {
+		return false, fmt.Errorf("failed to detect disk type of pool '%s': %w", pool.Name, err)
+	}
+	return parseRotational(output), nil
+}
+
+// parseRotational parses the output of rotationalScript: true when at least one
+// device was found and none of them rotate
+func parseRotational(output string) bool {
+	found := false
+	for _, line := range strings.Split(output, "\n") {
+		switch strings.TrimSpace(line) {
+		case "0":
+			found = true
+		case "":
+		default:
+			return false
+		}
+	}
+	return found
+}
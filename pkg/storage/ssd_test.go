@@ -0,0 +1,24 @@
+package storage
+
+import "testing"
+
+func TestParseRotational(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		output string
+		want   bool
+	}{
+		{"ssd mirror", "0\n0\n", true},
+		{"single nvme", "0", true},
+		{"hdd raid", "1\n1\n1\n1\n", false},
+		{"hdd with ssd cache", "0\n1\n1\n", false},
+		{"no devices", "", false},
+		{"unexpected output", "cat: can't open\n", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRotational(tt.output); got != tt.want {
+				t.Errorf("parseRotational(%q) = %v, want %v", tt.output, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// zfsVMParent is the dataset below a ZFS pool that holds one child dataset per VM
+const zfsVMParent = "qnap-vm"
+
+// zfsDescriptionProperty stores a snapshot description as a ZFS user property
+const zfsDescriptionProperty = "qnap-vm:description"
+
+// zfsOwnerProperty records which VM a per-VM dataset belongs to
+const zfsOwnerProperty = "qnap-vm:vm"
+
+// invalidDatasetChars matches characters not allowed in a ZFS dataset name component
+var invalidDatasetChars = regexp.MustCompile(`[^A-Za-z0-9_.:-]`)
+
+// DatasetSnapshot is a ZFS snapshot of a VM dataset
+type DatasetSnapshot struct {
+	Name        string    `json:"name"`
+	Created     time.Time `json:"created"`
+	Used        int64     `json:"used_bytes"`
+	Description string    `json:"description,omitempty"`
+}
+
+// DatasetComponent converts a VM name into a valid dataset or snapshot name component
+func DatasetComponent(name string) string {
+	return invalidDatasetChars.ReplaceAllString(name, "_")
+}
+
+// ValidDatasetSnapshotName reports whether name can be used as a ZFS snapshot name
+func ValidDatasetSnapshotName(name string) bool {
+	return name != "" && DatasetComponent(name) == name
+}
+
+// vmDatasetComponent returns the name of a VM's dataset below the qnap-vm parent. Names that
+// had to be sanitised get a short hash of the VM name, so "my vm" and "my_vm" do not share
+// a dataset.
+func vmDatasetComponent(vmName string) string {
+	component := DatasetComponent(vmName)
+	if component == vmName {
+		return component
+	}
+	sum := sha256.Sum256([]byte(vmName))
+	return component + "-" + hex.EncodeToString(sum[:4])
+}
+
+// VMDatasetName returns the dataset holding a VM's disks in a ZFS pool
+func VMDatasetName(pool *Pool, vmName string) string {
+	return path.Join(pool.Dataset, zfsVMParent, vmDatasetComponent(vmName))
+}
+
+// checkDatasetOwner returns an error when a per-VM dataset is recorded as belonging to
+// another VM. Datasets without an owner ("-") predate the property and are taken over.
+func checkDatasetOwner(dataset, owner, vmName string) error {
+	if owner == "" || owner == "-" || owner == vmName {
+		return nil
+	}
+	return fmt.Errorf("dataset %s belongs to VM '%s', not '%s'", dataset, owner, vmName)
+}
+
+// IsVMDataset reports whether dataset is a per-VM dataset created by qnap-vm
+func IsVMDataset(dataset string) bool {
+	return path.Base(path.Dir(dataset)) == zfsVMParent && strings.Count(dataset, "/") >= 2
+}
+
+// EnsureVMDataset creates the VM's dataset in a ZFS pool if needed and returns its mount
+// point. An existing dataset is only reused when no other VM owns it.
+func (m *Manager) EnsureVMDataset(pool *Pool, vmName string) (string, error) {
+	if pool.Type != "ZFS" || pool.Dataset == "" {
+		return "", fmt.Errorf("storage pool '%s' is not a ZFS pool", pool.Name)
+	}
+
+	dataset := VMDatasetName(pool, vmName)
+	owner := ssh.Quote(zfsOwnerProperty + "=" + vmName)
+	if output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("zfs get -H -o value %s %s", zfsOwnerProperty, ssh.Quote(dataset))); err != nil {
+		if output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("zfs create -p -o %s %s", owner, ssh.Quote(dataset))); err != nil {
+			return "", fmt.Errorf("failed to create dataset %s: %w\nOutput: %s", dataset, err, output)
+		}
+	} else if current := strings.TrimSpace(output); current != vmName {
+		if err := checkDatasetOwner(dataset, current, vmName); err != nil {
+			return "", err
+		}
+		if output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("zfs set %s %s", owner, ssh.Quote(dataset))); err != nil {
+			return "", fmt.Errorf("failed to record the owner of dataset %s: %w\nOutput: %s", dataset, err, output)
+		}
+	}
+
+	return m.DatasetMountpoint(dataset)
+}
+
+// DatasetMountpoint returns where a dataset is mounted
+func (m *Manager) DatasetMountpoint(dataset string) (string, error) {
+	output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("zfs get -H -o value mountpoint %s", ssh.Quote(dataset)))
+	if err != nil {
+		return "", fmt.Errorf("failed to get mount point of %s: %w\nOutput: %s", dataset, err, output)
+	}
+
+	mountpoint := strings.TrimSpace(output)
+	if !strings.HasPrefix(mountpoint, "/") {
+		return "", fmt.Errorf("dataset %s is not mounted (mountpoint: %s)", dataset, mountpoint)
+	}
+	return mountpoint, nil
+}
+
+// VMDatasetForDisks returns the per-VM dataset holding all of diskPaths, or "" when the
+// disks are not all in one qnap-vm dataset (or ZFS is not available)
+func (m *Manager) VMDatasetForDisks(diskPaths []string) (string, error) {
+	if len(diskPaths) == 0 {
+		return "", nil
+	}
+	if _, err := m.sshClient.ExecuteContext(m.commandContext(), "which zfs"); err != nil {
+		return "", nil
+	}
+
+	output, err := m.sshClient.ExecuteContext(m.commandContext(), "zfs list -H -o name,mountpoint -t filesystem")
+	if err != nil {
+		return "", fmt.Errorf("failed to list ZFS datasets: %w\nOutput: %s", err, output)
+	}
+	return vmDatasetForDisks(output, diskPaths), nil
+}
+
+// vmDatasetForDisks finds the per-VM dataset containing every path, given 'zfs list -o name,mountpoint' output
+func vmDatasetForDisks(listOutput string, diskPaths []string) string {
+	mounts := make(map[string]string) // mount point -> dataset
+	for _, line := range strings.Split(listOutput, "\n") {
+		name, mountpoint, found := strings.Cut(strings.TrimSpace(line), "\t")
+		if found && strings.HasPrefix(mountpoint, "/") {
+			mounts[mountpoint] = name
+		}
+	}
+
+	dataset := ""
+	for _, diskPath := range diskPaths {
+		// The innermost mounted dataset holds the file
+		owner := ""
+		for dir := path.Dir(diskPath); ; dir = path.Dir(dir) {
+			if name, ok := mounts[dir]; ok {
+				owner = name
+				break
+			}
+			if dir == "/" || dir == "." {
+				break
+			}
+		}
+
+		if owner == "" || !IsVMDataset(owner) || (dataset != "" && owner != dataset) {
+			return ""
+		}
+		dataset = owner
+	}
+	return dataset
+}
+
+// SnapshotDataset takes a ZFS snapshot of a VM dataset
+func (m *Manager) SnapshotDataset(dataset, name, description string) error {
+	cmd := "zfs snapshot"
+	if description != "" {
+		cmd += fmt.Sprintf(" -o %s", ssh.Quote(zfsDescriptionProperty+"="+description))
+	}
+	cmd += " " + ssh.Quote(dataset+"@"+name)
+
+	if output, err := m.sshClient.ExecuteContext(m.commandContext(), cmd); err != nil {
+		return fmt.Errorf("failed to snapshot %s: %w\nOutput: %s", dataset, err, output)
+	}
+	return nil
+}
+
+// ListDatasetSnapshots returns a dataset's snapshots, oldest first
+func (m *Manager) ListDatasetSnapshots(dataset string) ([]DatasetSnapshot, error) {
+	cmd := fmt.Sprintf("zfs list -H -p -d 1 -t snapshot -o name,creation,used,%s %s", zfsDescriptionProperty, ssh.Quote(dataset))
+	output, err := m.sshClient.ExecuteContext(m.commandContext(), cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots of %s: %w\nOutput: %s", dataset, err, output)
+	}
+	return parseDatasetSnapshots(output), nil
+}
+
+// parseDatasetSnapshots parses 'zfs list -H -p -t snapshot -o name,creation,used,<description>' output
+func parseDatasetSnapshots(output string) []DatasetSnapshot {
+	var snapshots []DatasetSnapshot
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) < 3 {
+			continue
+		}
+		_, name, found := strings.Cut(fields[0], "@")
+		if !found {
+			continue
+		}
+
+		snapshot := DatasetSnapshot{Name: name}
+		if created, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			snapshot.Created = time.Unix(created, 0)
+		}
+		snapshot.Used, _ = strconv.ParseInt(fields[2], 10, 64)
+		if len(fields) > 3 && fields[3] != "-" {
+			snapshot.Description = fields[3]
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].Created.Before(snapshots[j].Created) })
+	return snapshots
+}
+
+// FindDatasetSnapshot returns the named snapshot of a dataset, or nil if it does not exist
+func (m *Manager) FindDatasetSnapshot(dataset, name string) (*DatasetSnapshot, error) {
+	snapshots, err := m.ListDatasetSnapshots(dataset)
+	if err != nil {
+		return nil, err
+	}
+	for i := range snapshots {
+		if snapshots[i].Name == name {
+			return &snapshots[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// RollbackDataset rolls a dataset back to a snapshot, destroying any later snapshots
+func (m *Manager) RollbackDataset(dataset, name string) error {
+	if output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("zfs rollback -r %s", ssh.Quote(dataset+"@"+name))); err != nil {
+		return fmt.Errorf("failed to roll back %s to %s: %w\nOutput: %s", dataset, name, err, output)
+	}
+	return nil
+}
+
+// DestroyDatasetSnapshot destroys a dataset snapshot
+func (m *Manager) DestroyDatasetSnapshot(dataset, name string) error {
+	if output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("zfs destroy %s", ssh.Quote(dataset+"@"+name))); err != nil {
+		return fmt.Errorf("failed to destroy snapshot %s@%s: %w\nOutput: %s", dataset, name, err, output)
+	}
+	return nil
+}
+
+// DestroyDataset destroys a dataset that has no snapshots or children, such as a clone
+// that could not be set up
+func (m *Manager) DestroyDataset(dataset string) error {
+	if output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("zfs destroy %s", ssh.Quote(dataset))); err != nil {
+		return fmt.Errorf("failed to destroy dataset %s: %w\nOutput: %s", dataset, err, output)
+	}
+	return nil
+}
+
+// CloneDataset creates a writable clone of a dataset snapshot as the target VM's
+// dataset in the same pool and returns the clone's dataset name and mount point
+func (m *Manager) CloneDataset(dataset, snapshot, targetVM string) (string, string, error) {
+	target := path.Join(path.Dir(dataset), vmDatasetComponent(targetVM))
+	owner := ssh.Quote(zfsOwnerProperty + "=" + targetVM)
+	if output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("zfs clone -o %s %s %s", owner, ssh.Quote(dataset+"@"+snapshot), ssh.Quote(target))); err != nil {
+		return "", "", fmt.Errorf("failed to clone %s@%s: %w\nOutput: %s", dataset, snapshot, err, output)
+	}
+
+	mountpoint, err := m.DatasetMountpoint(target)
+	if err != nil {
+		return "", "", err
+	}
+	return target, mountpoint, nil
+}
+
+// MoveFile renames a file on the QNAP device
+func (m *Manager) MoveFile(srcPath, dstPath string) error {
+	if output, err := m.sshClient.ExecuteContext(m.commandContext(), fmt.Sprintf("mv %s %s", ssh.Quote(srcPath), ssh.Quote(dstPath))); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w\nOutput: %s", srcPath, dstPath, err, output)
+	}
+	return nil
+}
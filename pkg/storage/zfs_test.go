@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVMDatasetName(t *testing.T) {
+	pool := &Pool{Name: "zfs-zpool1", Type: "ZFS", Dataset: "zpool1"}
+	if got := VMDatasetName(pool, "web"); got != "zpool1/qnap-vm/web" {
+		t.Errorf("VMDatasetName() = %q, want zpool1/qnap-vm/web", got)
+	}
+	if got := VMDatasetName(pool, "web server/1"); !strings.HasPrefix(got, "zpool1/qnap-vm/web_server_1-") {
+		t.Errorf("VMDatasetName() = %q, want zpool1/qnap-vm/web_server_1-<hash>", got)
+	}
+}
+
+func TestVMDatasetNameCollisions(t *testing.T) {
+	pool := &Pool{Name: "zfs-zpool1", Type: "ZFS", Dataset: "zpool1"}
+	for _, names := range [][]string{{"my vm", "my_vm", "my/vm"}, {"a/b", "a_b", "a b"}} {
+		seen := make(map[string]string)
+		for _, name := range names {
+			dataset := VMDatasetName(pool, name)
+			if other, ok := seen[dataset]; ok {
+				t.Errorf("VMs '%s' and '%s' share dataset %s", other, name, dataset)
+			}
+			if !IsVMDataset(dataset) {
+				t.Errorf("VMDatasetName(%q) = %q is not a per-VM dataset", name, dataset)
+			}
+			seen[dataset] = name
+		}
+	}
+}
+
+func TestCheckDatasetOwner(t *testing.T) {
+	tests := []struct {
+		owner   string
+		wantErr bool
+	}{
+		{owner: "my vm"},
+		{owner: "-"},
+		{owner: ""},
+		{owner: "my_vm", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := checkDatasetOwner("zpool1/qnap-vm/my_vm", tt.owner, "my vm"); (err != nil) != tt.wantErr {
+			t.Errorf("checkDatasetOwner(owner %q) error = %v, wantErr %v", tt.owner, err, tt.wantErr)
+		}
+	}
+}
+
+func TestIsVMDataset(t *testing.T) {
+	tests := map[string]bool{
+		"zpool1/qnap-vm/web":     true,
+		"zpool1/qnap-vm":         false,
+		"zpool1/shares/web":      false,
+		"zpool1/qnap-vm/web/sub": false,
+	}
+	for dataset, want := range tests {
+		if got := IsVMDataset(dataset); got != want {
+			t.Errorf("IsVMDataset(%q) = %v, want %v", dataset, got, want)
+		}
+	}
+}
+
+func TestValidDatasetSnapshotName(t *testing.T) {
+	for name, want := range map[string]bool{"before-upgrade": true, "v1.2:3": true, "with space": false, "a@b": false, "": false} {
+		if got := ValidDatasetSnapshotName(name); got != want {
+			t.Errorf("ValidDatasetSnapshotName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestVMDatasetForDisks(t *testing.T) {
+	list := "zpool1\t/zpool1\n" +
+		"zpool1/qnap-vm\t/zpool1/qnap-vm\n" +
+		"zpool1/qnap-vm/web\t/zpool1/qnap-vm/web\n" +
+		"zpool1/qnap-vm/db\t/zpool1/qnap-vm/db\n" +
+		"zpool1/legacy\tlegacy\n"
+
+	tests := []struct {
+		name  string
+		paths []string
+		want  string
+	}{
+		{"single dataset", []string{"/zpool1/qnap-vm/web/web.qcow2", "/zpool1/qnap-vm/web/web-disk1.qcow2"}, "zpool1/qnap-vm/web"},
+		{"split across datasets", []string{"/zpool1/qnap-vm/web/web.qcow2", "/zpool1/qnap-vm/db/db.qcow2"}, ""},
+		{"shared pool directory", []string{"/zpool1/.qnap-vm/disks/web.qcow2"}, ""},
+		{"not on ZFS", []string{"/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := vmDatasetForDisks(list, tt.paths); got != tt.want {
+				t.Errorf("vmDatasetForDisks() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDatasetSnapshots(t *testing.T) {
+	output := "zpool1/qnap-vm/web@second\t1700000100\t4096\t-\n" +
+		"zpool1/qnap-vm/web@first\t1700000000\t1048576\tbefore upgrade\n"
+
+	snapshots := parseDatasetSnapshots(output)
+	if len(snapshots) != 2 {
+		t.Fatalf("parseDatasetSnapshots() returned %d snapshots, want 2", len(snapshots))
+	}
+	if snapshots[0].Name != "first" || snapshots[0].Used != 1048576 || snapshots[0].Description != "before upgrade" {
+		t.Errorf("first snapshot = %+v", snapshots[0])
+	}
+	if !snapshots[0].Created.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("first snapshot created = %v", snapshots[0].Created)
+	}
+	if snapshots[1].Name != "second" || snapshots[1].Description != "" {
+		t.Errorf("second snapshot = %+v", snapshots[1])
+	}
+}
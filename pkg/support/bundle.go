@@ -0,0 +1,127 @@
+// Package support builds support bundles: sanitized diagnostics users can attach to bug reports.
+package support
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Patterns for secrets that may appear in logs, configuration and command output
+var (
+	privateKeyPattern = regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`)
+	secretPattern     = regexp.MustCompile(`(?i)\b(password|passwd|secret|token|passphrase)(["']?\s*[:=]\s*["']?)[^\s"',]+`)
+)
+
+// Sanitizer removes secrets and, optionally, identifying details from bundle contents
+type Sanitizer struct {
+	replacements []string // old, new pairs for strings.NewReplacer
+}
+
+// NewSanitizer creates a sanitizer that masks each of secrets
+func NewSanitizer(secrets ...string) *Sanitizer {
+	s := &Sanitizer{}
+	for _, secret := range secrets {
+		s.Replace(secret, "********")
+	}
+	return s
+}
+
+// Replace substitutes value with placeholder, for example the NAS address with "<nas>"
+func (s *Sanitizer) Replace(value, placeholder string) {
+	if value != "" {
+		s.replacements = append(s.replacements, value, placeholder)
+	}
+}
+
+// Sanitize returns text with secrets and replaced values masked
+func (s *Sanitizer) Sanitize(text string) string {
+	text = privateKeyPattern.ReplaceAllString(text, "<private key removed>")
+	text = secretPattern.ReplaceAllString(text, "${1}${2}********")
+	if len(s.replacements) > 0 {
+		text = strings.NewReplacer(s.replacements...).Replace(text)
+	}
+	return text
+}
+
+// File is one file in a support bundle
+type File struct {
+	Name    string
+	Content string
+}
+
+// Bundle collects files for a support bundle archive
+type Bundle struct {
+	files []File
+	names map[string]bool
+}
+
+// NewBundle creates an empty bundle
+func NewBundle() *Bundle {
+	return &Bundle{names: make(map[string]bool)}
+}
+
+// Add adds a file, renaming it if the name is already used
+func (b *Bundle) Add(name, content string) {
+	unique := name
+	for i := 2; b.names[unique]; i++ {
+		ext := path.Ext(name)
+		unique = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	b.names[unique] = true
+	b.files = append(b.files, File{Name: unique, Content: content})
+}
+
+// Files returns the files added so far
+func (b *Bundle) Files() []File {
+	return b.files
+}
+
+// WriteArchive writes the bundle as a gzipped tar archive below a top-level directory
+// named after the archive, sanitizing every file
+func (b *Bundle) WriteArchive(archivePath string, sanitizer *Sanitizer) error {
+	out, err := os.OpenFile(archivePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", archivePath, err)
+	}
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	dir := strings.TrimSuffix(strings.TrimSuffix(path.Base(archivePath), ".gz"), ".tar")
+	now := time.Now()
+
+	writeErr := func() error {
+		for _, file := range b.files {
+			content := []byte(sanitizer.Sanitize(file.Content))
+			header := &tar.Header{
+				Name:    path.Join(dir, file.Name),
+				Mode:    0600,
+				Size:    int64(len(content)),
+				ModTime: now,
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			if _, err := tw.Write(content); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gz.Close()
+	}()
+
+	if err := out.Close(); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		return fmt.Errorf("failed to write %s: %w", archivePath, writeErr)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package support
+
+import (
+	"fmt"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// hostCommands gather QTS and Virtualization Station details; each runs separately so
+// one missing tool does not hide the rest
+var hostCommands = []struct {
+	name    string
+	command string
+}{
+	{"qts-version.txt", `echo "Model: $(getsysinfo model 2>/dev/null)"
+echo "Version: $(getcfg System Version -f /etc/config/uLinux.conf)"
+echo "Build: $(getcfg System 'Build Number' -f /etc/config/uLinux.conf)"`},
+	{"virtualization-station.txt", `for k in Version Build Install_Path Enable; do echo "$k: $(getcfg QKVM $k -f /etc/config/qpkg.conf)"; done`},
+	{"uname.txt", "uname -a"},
+	{"cpuinfo.txt", "grep -m 1 'model name' /proc/cpuinfo; grep -c ^processor /proc/cpuinfo; grep -o -m 1 -w 'vmx\\|svm' /proc/cpuinfo"},
+	{"meminfo.txt", "head -n 5 /proc/meminfo"},
+	{"df.txt", "df -h"},
+	{"mounts.txt", "mount"},
+	{"dmesg.txt", "dmesg | tail -n 300"},
+}
+
+// CollectHost gathers QTS, Virtualization Station and system details from the NAS.
+// Commands that fail are recorded in their output rather than returned as errors.
+func CollectHost(sshClient *ssh.Client) []File {
+	var files []File
+	for _, c := range hostCommands {
+		output, err := sshClient.Execute(c.command)
+		if err != nil {
+			output = fmt.Sprintf("%s\nerror: %v\n", output, err)
+		}
+		files = append(files, File{Name: c.name, Content: output})
+	}
+	return files
+}
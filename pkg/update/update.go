@@ -0,0 +1,344 @@
+// Package update finds, verifies and installs qnap-vm releases published on GitHub.
+package update
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Release asset names written by goreleaser
+const (
+	ChecksumsAsset = "checksums.txt"
+	SignatureAsset = "checksums.txt.sig"
+)
+
+// maxAssetSize bounds downloads so a bad response cannot exhaust memory
+const maxAssetSize = 200 << 20
+
+// Release is a published GitHub release
+type Release struct {
+	TagName    string  `json:"tag_name"`
+	Prerelease bool    `json:"prerelease"`
+	Assets     []Asset `json:"assets"`
+}
+
+// Asset is a file attached to a release
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+	Size int64  `json:"size"`
+}
+
+// Version returns the release version without its "v" prefix
+func (r *Release) Version() string {
+	return strings.TrimPrefix(r.TagName, "v")
+}
+
+// Asset returns the named asset, or nil if the release has none
+func (r *Release) Asset(name string) *Asset {
+	for i := range r.Assets {
+		if r.Assets[i].Name == name {
+			return &r.Assets[i]
+		}
+	}
+	return nil
+}
+
+// Updater talks to the GitHub releases API for one repository
+type Updater struct {
+	Repo       string // owner/name
+	APIURL     string // GitHub API base URL
+	HTTPClient *http.Client
+}
+
+// NewUpdater creates an updater for repo on github.com
+func NewUpdater(repo string) *Updater {
+	return &Updater{
+		Repo:       repo,
+		APIURL:     "https://api.github.com",
+		HTTPClient: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// LatestRelease returns the newest non-prerelease release
+func (u *Updater) LatestRelease() (*Release, error) {
+	data, err := u.get(fmt.Sprintf("%s/repos/%s/releases/latest", strings.TrimSuffix(u.APIURL, "/"), u.Repo))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for releases: %w", err)
+	}
+
+	var release Release
+	if err := json.Unmarshal(data, &release); err != nil {
+		return nil, fmt.Errorf("failed to parse release information: %w", err)
+	}
+	if release.TagName == "" {
+		return nil, fmt.Errorf("release information has no tag")
+	}
+	return &release, nil
+}
+
+// Download fetches a release asset
+func (u *Updater) Download(asset *Asset) ([]byte, error) {
+	data, err := u.get(asset.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", asset.Name, err)
+	}
+	return data, nil
+}
+
+// get performs a GET request and returns the body of a 200 response
+func (u *Updater) get(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json, application/octet-stream")
+	req.Header.Set("User-Agent", "qnap-vm-self-update")
+
+	resp, err := u.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			// Body was fully read; close errors do not affect the result
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAssetSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxAssetSize {
+		return nil, fmt.Errorf("%s is larger than %d MB", url, maxAssetSize>>20)
+	}
+	return data, nil
+}
+
+// ArchiveName returns the goreleaser archive name for a version and platform
+func ArchiveName(version, goos, goarch string) string {
+	ext := "tar.gz"
+	if goos == "windows" {
+		ext = "zip"
+	}
+	return fmt.Sprintf("qnap-vm_%s_%s_%s.%s", version, goos, goarch, ext)
+}
+
+// CurrentArchiveName returns the archive name for this platform
+func CurrentArchiveName(version string) string {
+	return ArchiveName(version, runtime.GOOS, runtime.GOARCH)
+}
+
+// CompareVersions compares two semantic versions, ignoring a "v" prefix. A release
+// sorts after its pre-releases. It returns -1, 0 or 1.
+func CompareVersions(a, b string) int {
+	aCore, aPre, _ := strings.Cut(strings.TrimPrefix(a, "v"), "-")
+	bCore, bPre, _ := strings.Cut(strings.TrimPrefix(b, "v"), "-")
+
+	aParts, bParts := strings.Split(aCore, "."), strings.Split(bCore, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var x, y int
+		if i < len(aParts) {
+			x, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			y, _ = strconv.Atoi(bParts[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	case aPre < bPre:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// IsRelease reports whether version looks like a released version rather than a development build
+func IsRelease(version string) bool {
+	core, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), "-")
+	for _, part := range strings.Split(core, ".") {
+		if _, err := strconv.Atoi(part); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseChecksums reads a sha256sum-style checksums file into a map of file name to hex digest
+func ParseChecksums(data []byte) map[string]string {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+		}
+	}
+	return sums
+}
+
+// VerifyChecksum checks data against the digest listed for name
+func VerifyChecksum(checksums []byte, name string, data []byte) error {
+	want, ok := ParseChecksums(checksums)[name]
+	if !ok {
+		return fmt.Errorf("%s is not listed in %s", name, ChecksumsAsset)
+	}
+
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, want, got)
+	}
+	return nil
+}
+
+// VerifySignature checks an Ed25519 signature of data. The public key is base64
+// encoded; the signature may be raw or base64 encoded.
+func VerifySignature(data, signature []byte, publicKey string) error {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid update public key")
+	}
+
+	if len(signature) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+		if err != nil {
+			return fmt.Errorf("invalid signature encoding")
+		}
+		signature = decoded
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(key), data, signature) {
+		return fmt.Errorf("signature verification failed")
+	}
+	return nil
+}
+
+// ExtractBinary returns the qnap-vm executable from a release archive
+func ExtractBinary(archiveName string, archive []byte) ([]byte, error) {
+	if strings.HasSuffix(archiveName, ".zip") {
+		return extractZip(archive, "qnap-vm.exe")
+	}
+	return extractTarGz(archive, "qnap-vm")
+}
+
+// extractTarGz returns the file named binary from a .tar.gz archive
+func extractTarGz(archive []byte, binary string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Typeflag == tar.TypeReg && path.Base(header.Name) == binary {
+			return io.ReadAll(io.LimitReader(tr, maxAssetSize))
+		}
+	}
+	return nil, fmt.Errorf("archive does not contain %s", binary)
+}
+
+// extractZip returns the file named binary from a .zip archive
+func extractZip(archive []byte, binary string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	for _, file := range zr.File {
+		if path.Base(file.Name) != binary {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from archive: %w", binary, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxAssetSize))
+		if closeErr := rc.Close(); err == nil {
+			err = closeErr
+		}
+		return data, err
+	}
+	return nil, fmt.Errorf("archive does not contain %s", binary)
+}
+
+// ReplaceExecutable atomically replaces the executable at exePath with data. The old
+// binary is moved aside first because Windows cannot overwrite a running executable;
+// a leftover .old file is removed on the next update.
+func ReplaceExecutable(exePath string, data []byte) error {
+	info, err := os.Stat(exePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", exePath, err)
+	}
+
+	dir := filepath.Dir(exePath)
+	newPath := exePath + ".new"
+	oldPath := exePath + ".old"
+	if err := os.Remove(oldPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", oldPath, err)
+	}
+
+	if err := os.WriteFile(newPath, data, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write new binary to %s (is %s writable?): %w", newPath, dir, err)
+	}
+
+	if err := os.Rename(exePath, oldPath); err != nil {
+		if rmErr := os.Remove(newPath); rmErr != nil {
+			// The .new file is overwritten by the next update
+		}
+		return fmt.Errorf("failed to move the current binary aside: %w", err)
+	}
+	if err := os.Rename(newPath, exePath); err != nil {
+		// Put the old binary back so the installation keeps working
+		if restoreErr := os.Rename(oldPath, exePath); restoreErr != nil {
+			return fmt.Errorf("failed to install new binary: %w (restore also failed: %v)", err, restoreErr)
+		}
+		return fmt.Errorf("failed to install new binary: %w", err)
+	}
+
+	if err := os.Remove(oldPath); err != nil {
+		// Expected on Windows while the old binary is still running
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package update
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"0.3.0", "0.3.0", 0},
+		{"v0.3.0", "0.3.0", 0},
+		{"0.3.0", "0.10.0", -1},
+		{"1.0.0", "0.9.9", 1},
+		{"1.0.0-rc1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc1", 1},
+		{"1.0.0-rc1", "1.0.0-rc2", -1},
+		{"1.0", "1.0.1", -1},
+	}
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestIsRelease(t *testing.T) {
+	for version, want := range map[string]bool{
+		"0.3.0":         true,
+		"v1.2.3-rc1":    true,
+		"dev":           false,
+		"v0.2.0-5-gabc": true,
+		"abc1234":       false,
+	} {
+		if got := IsRelease(version); got != want {
+			t.Errorf("IsRelease(%q) = %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestArchiveName(t *testing.T) {
+	if got := ArchiveName("0.3.0", "linux", "arm64"); got != "qnap-vm_0.3.0_linux_arm64.tar.gz" {
+		t.Errorf("ArchiveName() = %q", got)
+	}
+	if got := ArchiveName("0.3.0", "windows", "amd64"); got != "qnap-vm_0.3.0_windows_amd64.zip" {
+		t.Errorf("ArchiveName() = %q", got)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	data := []byte("archive contents")
+	sum := sha256.Sum256(data)
+	checksums := []byte(fmt.Sprintf("%s  qnap-vm_0.3.0_linux_amd64.tar.gz\n%s  other.zip\n", hex.EncodeToString(sum[:]), hex.EncodeToString(make([]byte, 32))))
+
+	if err := VerifyChecksum(checksums, "qnap-vm_0.3.0_linux_amd64.tar.gz", data); err != nil {
+		t.Errorf("VerifyChecksum() error: %v", err)
+	}
+	if err := VerifyChecksum(checksums, "other.zip", data); err == nil {
+		t.Error("VerifyChecksum() should fail on a mismatch")
+	}
+	if err := VerifyChecksum(checksums, "missing.tar.gz", data); err == nil {
+		t.Error("VerifyChecksum() should fail for unlisted files")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base64.StdEncoding.EncodeToString(public)
+	data := []byte("checksums")
+	signature := ed25519.Sign(private, data)
+
+	if err := VerifySignature(data, signature, key); err != nil {
+		t.Errorf("VerifySignature() raw error: %v", err)
+	}
+	encoded := []byte(base64.StdEncoding.EncodeToString(signature) + "\n")
+	if err := VerifySignature(data, encoded, key); err != nil {
+		t.Errorf("VerifySignature() base64 error: %v", err)
+	}
+	if err := VerifySignature([]byte("tampered"), signature, key); err == nil {
+		t.Error("VerifySignature() should fail for modified data")
+	}
+	if err := VerifySignature(data, signature, "not-a-key"); err == nil {
+		t.Error("VerifySignature() should fail for an invalid key")
+	}
+}
+
+func TestExtractBinary(t *testing.T) {
+	binary := []byte("#!binary")
+
+	var tgz bytes.Buffer
+	gz := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gz)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{"README.md", []byte("readme")}, {"qnap-vm", binary}} {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0755, Size: int64(len(f.data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ExtractBinary("qnap-vm_0.3.0_linux_amd64.tar.gz", tgz.Bytes())
+	if err != nil || !bytes.Equal(got, binary) {
+		t.Errorf("ExtractBinary(tar.gz) = %q, %v", got, err)
+	}
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	w, err := zw.Create("qnap-vm.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(binary); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = ExtractBinary("qnap-vm_0.3.0_windows_amd64.zip", zipped.Bytes())
+	if err != nil || !bytes.Equal(got, binary) {
+		t.Errorf("ExtractBinary(zip) = %q, %v", got, err)
+	}
+
+	if _, err := ExtractBinary("qnap-vm_0.3.0_windows_amd64.zip", tgz.Bytes()); err == nil {
+		t.Error("ExtractBinary() should fail on a corrupt archive")
+	}
+}
+
+func TestLatestRelease(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/scttfrdmn/qnap-vm/releases/latest":
+			fmt.Fprint(w, `{"tag_name": "v0.4.0", "assets": [{"name": "checksums.txt", "browser_download_url": "http://`+r.Host+`/download/checksums.txt", "size": 5}]}`)
+		case "/download/checksums.txt":
+			fmt.Fprint(w, "sums\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	updater := NewUpdater("scttfrdmn/qnap-vm")
+	updater.APIURL = server.URL
+
+	release, err := updater.LatestRelease()
+	if err != nil {
+		t.Fatalf("LatestRelease() error: %v", err)
+	}
+	if release.Version() != "0.4.0" {
+		t.Errorf("Version() = %q, want 0.4.0", release.Version())
+	}
+
+	asset := release.Asset(ChecksumsAsset)
+	if asset == nil {
+		t.Fatal("Asset(checksums.txt) = nil")
+	}
+	data, err := updater.Download(asset)
+	if err != nil || string(data) != "sums\n" {
+		t.Errorf("Download() = %q, %v", data, err)
+	}
+
+	if _, err := updater.Download(&Asset{Name: "missing", URL: server.URL + "/missing"}); err == nil {
+		t.Error("Download() should fail on a 404")
+	}
+}
+
+func TestReplaceExecutable(t *testing.T) {
+	exePath := filepath.Join(t.TempDir(), "qnap-vm")
+	if err := os.WriteFile(exePath, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReplaceExecutable(exePath, []byte("new")); err != nil {
+		t.Fatalf("ReplaceExecutable() error: %v", err)
+	}
+
+	data, err := os.ReadFile(exePath)
+	if err != nil || string(data) != "new" {
+		t.Errorf("binary after update = %q, %v", data, err)
+	}
+	info, err := os.Stat(exePath)
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("binary mode after update = %v, %v", info.Mode(), err)
+	}
+	if _, err := os.Stat(exePath + ".old"); !os.IsNotExist(err) {
+		t.Error("ReplaceExecutable() should remove the old binary")
+	}
+}
@@ -0,0 +1,241 @@
+package virsh
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// netDevicesScript lists the network devices of the NAS, bridges and virtual switches included
+const netDevicesScript = `ls /sys/class/net`
+
+// AdoptedDisk is a disk of a VM that qnap-vm did not create
+type AdoptedDisk struct {
+	Target string `json:"target"`
+	Bus    string `json:"bus"`
+	Path   string `json:"path"`
+	// Block is set for disks backed by a block device, such as an iSCSI LUN
+	Block bool `json:"block,omitempty"`
+	// Declared is the format in the VM definition, Actual the format of the image
+	// itself ("" when it could not be read)
+	Declared string `json:"declared_format"`
+	Actual   string `json:"actual_format,omitempty"`
+	Problem  string `json:"problem,omitempty"`
+}
+
+// FormatMismatch reports whether the definition does not state the image's real format.
+// libvirt reads images without a declared format as raw.
+func (d AdoptedDisk) FormatMismatch() bool {
+	return d.Actual != "" && d.Actual != d.Declared
+}
+
+// AdoptedNIC is a network interface of a VM that qnap-vm did not create
+type AdoptedNIC struct {
+	MAC    string `json:"mac"`
+	Type   string `json:"type"`
+	Source string `json:"source"`
+	Model  string `json:"model"`
+	// Missing is set when the bridge, virtual switch or libvirt network is not on the NAS
+	Missing bool `json:"missing,omitempty"`
+}
+
+// Adoption describes a VM created outside qnap-vm, such as in the Virtualization
+// Station web UI, and what has to change for qnap-vm to manage it like its own VMs
+type Adoption struct {
+	VM         string        `json:"vm"`
+	Disks      []AdoptedDisk `json:"disks"`
+	NICs       []AdoptedNIC  `json:"nics"`
+	GuestAgent bool          `json:"guest_agent"`
+}
+
+// Changes describes what Adopt changes in the VM's definition
+func (a *Adoption) Changes() []string {
+	var changes []string
+	for _, disk := range a.Disks {
+		if !disk.FormatMismatch() {
+			continue
+		}
+		if disk.Declared == "" {
+			changes = append(changes, fmt.Sprintf("Declare %s as %s (the definition names no format)", disk.Target, disk.Actual))
+		} else {
+			changes = append(changes, fmt.Sprintf("Declare %s as %s instead of %s, the image's real format", disk.Target, disk.Actual, disk.Declared))
+		}
+	}
+	if !a.GuestAgent {
+		changes = append(changes, "Add a guest agent channel, used for shutdown, snapshots and 'qnap-vm file'")
+	}
+	return changes
+}
+
+// Notes describes what qnap-vm cannot change but limits how it manages the VM
+func (a *Adoption) Notes() []string {
+	var notes []string
+	for _, disk := range a.Disks {
+		switch {
+		case disk.Problem != "":
+			notes = append(notes, fmt.Sprintf("%s: %s", disk.Target, disk.Problem))
+		case disk.Block:
+			notes = append(notes, fmt.Sprintf("%s is a block device (%s); backups and disk moves skip it", disk.Target, disk.Path))
+		case disk.Actual == "raw" || (disk.Actual == "" && disk.Declared == "raw"):
+			notes = append(notes, fmt.Sprintf("%s is a raw image; internal snapshots need qcow2, so snapshot it on a ZFS pool or back it up instead", disk.Target))
+		}
+	}
+	for _, nic := range a.NICs {
+		if nic.Missing {
+			notes = append(notes, fmt.Sprintf("Interface %s uses %s '%s', which does not exist on the NAS; the VM cannot start until it does", nic.MAC, nic.Type, nic.Source))
+		}
+	}
+	return notes
+}
+
+// InspectForAdoption reads a VM's disks, interfaces and guest agent channel. imageFormat
+// returns the format of an image file, as qemu-img reports it.
+func (c *Client) InspectForAdoption(vmName string, imageFormat func(path string) (string, error)) (*Adoption, error) {
+	domain, err := c.GetDomain(vmName)
+	if err != nil {
+		return nil, err
+	}
+
+	adoption := &Adoption{VM: vmName}
+	for _, disk := range domain.Devices.Disk {
+		if disk.Device != "disk" {
+			continue
+		}
+		adopted := AdoptedDisk{Target: disk.Target.Dev, Bus: disk.Target.Bus, Declared: disk.Driver.Type}
+		switch {
+		case disk.Source.File != "":
+			adopted.Path = disk.Source.File
+			if adopted.Actual, err = imageFormat(disk.Source.File); err != nil {
+				adopted.Problem = fmt.Sprintf("image format not read: %v", err)
+			}
+		case disk.Source.Dev != "":
+			adopted.Path = disk.Source.Dev
+			adopted.Block = true
+		default:
+			adopted.Problem = fmt.Sprintf("%s disks are not managed by qnap-vm", disk.Type)
+		}
+		adoption.Disks = append(adoption.Disks, adopted)
+	}
+
+	devices, networks := c.netSources()
+	for _, iface := range domain.Devices.Interface {
+		nic := AdoptedNIC{Type: iface.Type, Source: iface.SourceName(), Model: iface.Model.Type}
+		if iface.MAC != nil {
+			nic.MAC = iface.MAC.Address
+		}
+		switch {
+		case iface.Source.Network != "" && networks != nil:
+			nic.Missing = !networks[iface.Source.Network]
+		case iface.Source.Bridge != "" && devices != nil:
+			nic.Missing = !devices[iface.Source.Bridge]
+		case iface.Source.Dev != "" && devices != nil:
+			nic.Missing = !devices[iface.Source.Dev]
+		}
+		adoption.NICs = append(adoption.NICs, nic)
+	}
+
+	for _, channel := range domain.Devices.Channel {
+		if channel.Target.Name == GuestAgentChannel {
+			adoption.GuestAgent = true
+		}
+	}
+	return adoption, nil
+}
+
+// netSources returns the network devices and libvirt networks of the NAS. Either is nil
+// when it could not be read, so that no interface is reported missing by mistake.
+func (c *Client) netSources() (devices, networks map[string]bool) {
+	if output, err := c.sshClient.ExecuteContext(c.commandContext(), netDevicesScript); err == nil {
+		devices = map[string]bool{}
+		for _, name := range strings.Fields(output) {
+			devices[name] = true
+		}
+	}
+	if list, err := c.ListNetworks(); err == nil {
+		networks = map[string]bool{}
+		for _, network := range list {
+			networks[network.Name] = true
+		}
+	}
+	return devices, networks
+}
+
+// Adopt makes the changes the adoption lists to the VM's persistent definition. They
+// take effect the next time the VM starts.
+func (c *Client) Adopt(adoption *Adoption) error {
+	var fixes []AdoptedDisk
+	for _, disk := range adoption.Disks {
+		if disk.FormatMismatch() {
+			fixes = append(fixes, disk)
+		}
+	}
+	if len(fixes) > 0 {
+		domainXML, err := c.dumpInactiveXML(adoption.VM)
+		if err != nil {
+			return err
+		}
+		for _, disk := range fixes {
+			if domainXML, err = setDiskFormat(domainXML, disk.Target, disk.Actual); err != nil {
+				return fmt.Errorf("failed to update VM '%s': %w", adoption.VM, err)
+			}
+		}
+		if err := c.defineXML(adoption.VM, domainXML); err != nil {
+			return err
+		}
+	}
+
+	if !adoption.GuestAgent {
+		channel := DomainChannel{Type: "unix"}
+		channel.Target.Type = "virtio"
+		channel.Target.Name = GuestAgentChannel
+		data, err := xml.Marshal(struct {
+			XMLName xml.Name `xml:"channel"`
+			DomainChannel
+		}{DomainChannel: channel})
+		if err != nil {
+			return fmt.Errorf("failed to encode guest agent channel: %w", err)
+		}
+		if output, err := c.attachDevice(adoption.VM, "channel", data, "--config"); err != nil {
+			return fmt.Errorf("failed to add a guest agent channel to VM '%s': %w\nOutput: %s", adoption.VM, err, output)
+		}
+	}
+	return nil
+}
+
+// Patterns for the driver element of a disk
+var (
+	driverElementPattern = regexp.MustCompile(`<driver\b[^>]*>`)
+	typeAttrPattern      = regexp.MustCompile(`\stype=(?:'[^']*'|"[^"]*")`)
+)
+
+// setDiskFormat sets the driver format of the disk with the given target in domain XML,
+// leaving the rest of the definition as it is
+func setDiskFormat(domainXML, target, format string) (string, error) {
+	found := false
+	formatAttr := " type='" + xmlEscape(format) + "'"
+	domainXML = diskElementPattern.ReplaceAllStringFunc(domainXML, func(disk string) string {
+		match := diskTargetPattern.FindStringSubmatch(disk)
+		if found || match == nil || match[1] != target {
+			return disk
+		}
+		found = true
+
+		loc := driverElementPattern.FindStringIndex(disk)
+		if loc == nil {
+			open := strings.Index(disk, ">") + 1
+			return disk[:open] + "\n      <driver name='qemu'" + formatAttr + "/>" + disk[open:]
+		}
+		driver := disk[loc[0]:loc[1]]
+		if typeAttrPattern.MatchString(driver) {
+			driver = typeAttrPattern.ReplaceAllLiteralString(driver, formatAttr)
+		} else {
+			driver = strings.Replace(driver, "<driver", "<driver"+formatAttr, 1)
+		}
+		return disk[:loc[0]] + driver + disk[loc[1]:]
+	})
+	if !found {
+		return "", fmt.Errorf("disk %s not found in domain definition", target)
+	}
+	return domainXML, nil
+}
@@ -0,0 +1,90 @@
+package virsh
+
+import (
+	"strings"
+	"testing"
+)
+
+const qvsDomainXML = `<domain type='kvm'>
+  <name>win11</name>
+  <devices>
+    <disk type='file' device='disk'>
+      <driver name='qemu' type='raw' cache='writeback'/>
+      <source file='/share/CACHEDEV1_DATA/VM/win11/win11.img'/>
+      <target dev='hda' bus='sata'/>
+    </disk>
+    <disk type='file' device='disk'>
+      <source file='/share/CACHEDEV1_DATA/VM/win11/data.img'/>
+      <target dev='hdb' bus='sata'/>
+    </disk>
+    <disk type='file' device='cdrom'>
+      <driver name='qemu' type='raw'/>
+      <target dev='hdc' bus='sata'/>
+    </disk>
+    <interface type='bridge'>
+      <mac address='00:50:56:aa:bb:cc'/>
+      <source bridge='qvs0'/>
+      <model type='e1000'/>
+    </interface>
+  </devices>
+</domain>`
+
+func TestSetDiskFormat(t *testing.T) {
+	updated, err := setDiskFormat(qvsDomainXML, "hda", "qcow2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(updated, "<driver name='qemu' type='qcow2' cache='writeback'/>") {
+		t.Errorf("driver type not replaced:\n%s", updated)
+	}
+
+	updated, err = setDiskFormat(updated, "hdb", "qcow2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<disk type='file' device='disk'>\n      <driver name='qemu' type='qcow2'/>\n      <source file='/share/CACHEDEV1_DATA/VM/win11/data.img'/>"
+	if !strings.Contains(updated, want) {
+		t.Errorf("driver not added:\n%s", updated)
+	}
+	if !strings.Contains(updated, "<driver name='qemu' type='raw'/>\n      <target dev='hdc'") {
+		t.Errorf("CD-ROM changed:\n%s", updated)
+	}
+
+	if _, err := setDiskFormat(qvsDomainXML, "vdz", "qcow2"); err == nil {
+		t.Error("setDiskFormat accepted a missing disk")
+	}
+}
+
+func TestAdoptionChangesAndNotes(t *testing.T) {
+	adoption := &Adoption{
+		VM: "win11",
+		Disks: []AdoptedDisk{
+			{Target: "hda", Declared: "raw", Actual: "qcow2"},
+			{Target: "hdb", Declared: "", Actual: "raw"},
+			{Target: "sdb", Declared: "raw", Path: "/dev/sdc", Block: true},
+			{Target: "vda", Declared: "qcow2", Actual: "qcow2"},
+		},
+		NICs: []AdoptedNIC{
+			{MAC: "00:50:56:aa:bb:cc", Type: "bridge", Source: "qvs0"},
+			{MAC: "00:50:56:aa:bb:cd", Type: "bridge", Source: "qvs9", Missing: true},
+		},
+	}
+
+	changes := adoption.Changes()
+	if len(changes) != 3 || !strings.Contains(changes[0], "hda as qcow2 instead of raw") ||
+		!strings.Contains(changes[1], "hdb as raw") || !strings.Contains(changes[2], "guest agent") {
+		t.Errorf("Changes = %q", changes)
+	}
+
+	notes := adoption.Notes()
+	if len(notes) != 3 || !strings.HasPrefix(notes[0], "hdb is a raw image") ||
+		!strings.HasPrefix(notes[1], "sdb is a block device") || !strings.Contains(notes[2], "qvs9") {
+		t.Errorf("Notes = %q", notes)
+	}
+
+	adoption.GuestAgent = true
+	adoption.Disks = adoption.Disks[3:]
+	if changes := adoption.Changes(); len(changes) != 0 {
+		t.Errorf("Changes of an adopted VM = %q", changes)
+	}
+}
@@ -0,0 +1,227 @@
+package virsh
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// GuestAgentChannel is the virtio-serial channel name used by qemu-guest-agent
+const GuestAgentChannel = "org.qemu.guest_agent.0"
+
+// MaxGuestFileSize is the largest file accepted by GuestWriteFile
+const MaxGuestFileSize = 10 * 1024 * 1024
+
+// guestExecPollInterval is how often GuestExec checks whether the guest command has finished
+const guestExecPollInterval = time.Second
+
+// guestFileChunkSize keeps each base64 payload well below the remote shell's argument limit
+const guestFileChunkSize = 32 * 1024
+
+// agentCommand is a QEMU guest agent request
+type agentCommand struct {
+	Execute   string      `json:"execute"`
+	Arguments interface{} `json:"arguments,omitempty"`
+}
+
+// GuestAgentCommand sends a command to the QEMU guest agent and returns the "return" payload
+func (c *Client) GuestAgentCommand(vmName, execute string, arguments interface{}) (json.RawMessage, error) {
+	return c.guestAgentCommand(vmName, execute, arguments, "")
+}
+
+// guestAgentCommand sends a guest agent command with extra qemu-agent-command flags
+func (c *Client) guestAgentCommand(vmName, execute string, arguments interface{}, flags string) (json.RawMessage, error) {
+	payload, err := json.Marshal(agentCommand{Execute: execute, Arguments: arguments})
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := c.execVirsh(fmt.Sprintf("qemu-agent-command %s%s %s", flags, domainArg(vmName), ssh.Quote(string(payload))))
+	if err != nil {
+		return nil, fmt.Errorf("guest agent command '%s' failed for VM '%s': %w\nOutput: %s", execute, vmName, err, strings.TrimSpace(output))
+	}
+
+	var response struct {
+		Return json.RawMessage `json:"return"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &response); err != nil {
+		return nil, fmt.Errorf("failed to parse guest agent response: %w", err)
+	}
+
+	return response.Return, nil
+}
+
+// GuestPing checks that the guest agent is running inside the VM
+func (c *Client) GuestPing(vmName string) error {
+	if _, err := c.GuestAgentCommand(vmName, "guest-ping", nil); err != nil {
+		return fmt.Errorf("guest agent is not responding in VM '%s' (is qemu-guest-agent installed and running?): %w", vmName, err)
+	}
+	return nil
+}
+
+// GuestFSFreeze flushes and freezes the guest's filesystems so storage-level snapshots are consistent
+func (c *Client) GuestFSFreeze(vmName string) error {
+	_, err := c.GuestAgentCommand(vmName, "guest-fsfreeze-freeze", nil)
+	return err
+}
+
+// GuestFSThaw thaws filesystems frozen by GuestFSFreeze
+func (c *Client) GuestFSThaw(vmName string) error {
+	_, err := c.GuestAgentCommand(vmName, "guest-fsfreeze-thaw", nil)
+	return err
+}
+
+// FSTrimResult is the outcome of trimming one guest filesystem
+type FSTrimResult struct {
+	Path    string `json:"path"`
+	Trimmed int64  `json:"trimmed"` // Bytes discarded; 0 when the guest doesn't report it
+	Error   string `json:"error,omitempty"`
+}
+
+// GuestFSTrim discards unused blocks in every guest filesystem. It waits for the
+// guest to finish, since trimming a large filesystem can take minutes.
+func (c *Client) GuestFSTrim(vmName string) ([]FSTrimResult, error) {
+	result, err := c.guestAgentCommand(vmName, "guest-fstrim", nil, "--block ")
+	if err != nil {
+		return nil, err
+	}
+	return parseFSTrim(result)
+}
+
+// parseFSTrim decodes a guest-fstrim response
+func parseFSTrim(raw json.RawMessage) ([]FSTrimResult, error) {
+	var response struct {
+		Paths []FSTrimResult `json:"paths"`
+	}
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return nil, fmt.Errorf("unexpected guest-fstrim response: %s", string(raw))
+	}
+	return response.Paths, nil
+}
+
+// GuestWriteFile writes data to a file inside the guest using the guest agent
+func (c *Client) GuestWriteFile(vmName, guestPath string, data []byte) error {
+	if len(data) > MaxGuestFileSize {
+		return fmt.Errorf("file is too large for guest agent transfer (%d bytes, max %d)", len(data), MaxGuestFileSize)
+	}
+
+	result, err := c.GuestAgentCommand(vmName, "guest-file-open", map[string]string{"path": guestPath, "mode": "w"})
+	if err != nil {
+		return err
+	}
+
+	var handle int
+	if err := json.Unmarshal(result, &handle); err != nil {
+		return fmt.Errorf("unexpected guest-file-open response: %s", string(result))
+	}
+
+	writeErr := c.guestWriteChunks(vmName, handle, data)
+
+	if _, err := c.GuestAgentCommand(vmName, "guest-file-close", map[string]int{"handle": handle}); err != nil && writeErr == nil {
+		return err
+	}
+
+	return writeErr
+}
+
+// guestWriteChunks writes data to an open guest file handle in base64 chunks
+func (c *Client) guestWriteChunks(vmName string, handle int, data []byte) error {
+	for offset := 0; offset < len(data); offset += guestFileChunkSize {
+		end := offset + guestFileChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		args := map[string]interface{}{
+			"handle":  handle,
+			"buf-b64": base64.StdEncoding.EncodeToString(data[offset:end]),
+		}
+		if _, err := c.GuestAgentCommand(vmName, "guest-file-write", args); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GuestExecResult is the outcome of a program run inside the guest
+type GuestExecResult struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+// guestExecStatus is the guest-exec-status response
+type guestExecStatus struct {
+	Exited   bool   `json:"exited"`
+	ExitCode int    `json:"exitcode"`
+	OutData  string `json:"out-data"`
+	ErrData  string `json:"err-data"`
+}
+
+// GuestExec runs a program inside the guest with the guest agent and waits up to timeout for it to exit
+func (c *Client) GuestExec(vmName, path string, args []string, timeout time.Duration) (*GuestExecResult, error) {
+	request := map[string]interface{}{
+		"path":           path,
+		"arg":            args,
+		"capture-output": true,
+	}
+	result, err := c.GuestAgentCommand(vmName, "guest-exec", request)
+	if err != nil {
+		return nil, err
+	}
+
+	var started struct {
+		PID int `json:"pid"`
+	}
+	if err := json.Unmarshal(result, &started); err != nil {
+		return nil, fmt.Errorf("unexpected guest-exec response: %s", string(result))
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := c.GuestAgentCommand(vmName, "guest-exec-status", map[string]int{"pid": started.PID})
+		if err != nil {
+			return nil, err
+		}
+
+		execResult, exited, err := parseGuestExecStatus(status)
+		if err != nil {
+			return nil, err
+		}
+		if exited {
+			return execResult, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("guest command '%s' in VM '%s' did not finish within %s", path, vmName, timeout)
+		}
+		time.Sleep(guestExecPollInterval)
+	}
+}
+
+// parseGuestExecStatus decodes a guest-exec-status response and reports whether the process has exited
+func parseGuestExecStatus(raw json.RawMessage) (*GuestExecResult, bool, error) {
+	var status guestExecStatus
+	if err := json.Unmarshal(raw, &status); err != nil {
+		return nil, false, fmt.Errorf("unexpected guest-exec-status response: %s", string(raw))
+	}
+	if !status.Exited {
+		return nil, false, nil
+	}
+
+	stdout, err := base64.StdEncoding.DecodeString(status.OutData)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decode guest command output: %w", err)
+	}
+	stderr, err := base64.StdEncoding.DecodeString(status.ErrData)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decode guest command error output: %w", err)
+	}
+
+	return &GuestExecResult{ExitCode: status.ExitCode, Stdout: string(stdout), Stderr: string(stderr)}, true, nil
+}
@@ -0,0 +1,54 @@
+package virsh
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseGuestExecStatus(t *testing.T) {
+	running := json.RawMessage(`{"exited": false}`)
+	if result, exited, err := parseGuestExecStatus(running); err != nil || exited || result != nil {
+		t.Errorf("parseGuestExecStatus(running) = %+v, %v, %v", result, exited, err)
+	}
+
+	// "hello\n" and "oops\n" base64-encoded
+	done := json.RawMessage(`{"exited": true, "exitcode": 3, "out-data": "aGVsbG8K", "err-data": "b29wcwo="}`)
+	result, exited, err := parseGuestExecStatus(done)
+	if err != nil || !exited {
+		t.Fatalf("parseGuestExecStatus(done) exited = %v, err = %v", exited, err)
+	}
+	if result.ExitCode != 3 || result.Stdout != "hello\n" || result.Stderr != "oops\n" {
+		t.Errorf("parseGuestExecStatus(done) = %+v", result)
+	}
+
+	if _, _, err := parseGuestExecStatus(json.RawMessage(`{"exited": true, "out-data": "!!"}`)); err == nil {
+		t.Error("parseGuestExecStatus() should reject invalid base64")
+	}
+}
+
+func TestParseFSTrim(t *testing.T) {
+	raw := json.RawMessage(`{"paths": [
+		{"path": "/", "trimmed": 1073741824, "minimum": 0},
+		{"path": "/boot", "minimum": 0, "error": "Operation not supported"}
+	]}`)
+	results, err := parseFSTrim(raw)
+	if err != nil {
+		t.Fatalf("parseFSTrim() error = %v", err)
+	}
+	want := []FSTrimResult{
+		{Path: "/", Trimmed: 1 << 30},
+		{Path: "/boot", Error: "Operation not supported"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("parseFSTrim() = %+v, want %+v", results, want)
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("parseFSTrim()[%d] = %+v, want %+v", i, results[i], want[i])
+		}
+	}
+
+	if _, err := parseFSTrim(json.RawMessage(`[]`)); err == nil {
+		t.Error("parseFSTrim() should reject a non-object response")
+	}
+}
@@ -0,0 +1,149 @@
+package virsh
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Bandwidth is the average rate limit of a VM interface in each direction, in KiB/s
+// as libvirt counts it; 0 means unlimited. Inbound is traffic to the guest.
+type Bandwidth struct {
+	InboundKiB  int
+	OutboundKiB int
+}
+
+// rateUnits are the bytes per second of each rate unit; bit units are decimal as in tc
+var rateUnits = map[string]float64{
+	"bit": 1.0 / 8, "kbit": 1e3 / 8, "mbit": 1e6 / 8, "gbit": 1e9 / 8,
+	"b": 1, "kb": 1024, "mb": 1024 * 1024, "gb": 1024 * 1024 * 1024,
+}
+
+var ratePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([a-z]*)(?:/s)?$`)
+
+// ParseRate parses a rate limit such as 50mbit, 1gbit or 10MB (per second) into KiB/s.
+// A bare number is KiB/s; 0, "none" and "unlimited" remove the limit.
+func ParseRate(spec string) (int, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if spec == "none" || spec == "unlimited" {
+		return 0, nil
+	}
+	match := ratePattern.FindStringSubmatch(spec)
+	if match == nil {
+		return 0, fmt.Errorf("invalid rate %q (e.g. 50mbit, 1gbit, 10MB or unlimited)", spec)
+	}
+	value, _ := strconv.ParseFloat(match[1], 64)
+	unit := match[2]
+	if unit == "" {
+		return int(value), nil
+	}
+	bytesPerUnit, ok := rateUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid rate unit %q (use bit, kbit, mbit, gbit, B, KB, MB or GB)", unit)
+	}
+	kib := int(value * bytesPerUnit / 1024)
+	if kib == 0 && value > 0 {
+		return 0, fmt.Errorf("rate %s is below libvirt's 1 KiB/s minimum", spec)
+	}
+	return kib, nil
+}
+
+// FormatRate formats a rate limit in KiB/s with its equivalent in Mbit/s
+func FormatRate(kib int) string {
+	if kib == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d KiB/s (%.1f Mbit/s)", kib, float64(kib)*1024*8/1e6)
+}
+
+// InterfaceBandwidth returns the rate limits of a VM interface, identified by MAC
+func (c *Client) InterfaceBandwidth(vmName, mac string, live bool) (*Bandwidth, error) {
+	cmd := fmt.Sprintf("domiftune %s %s", domainArg(vmName), mac)
+	if !live {
+		cmd += " --config"
+	}
+	output, err := c.execVirsh(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bandwidth of interface %s of VM '%s': %w\nOutput: %s", mac, vmName, err, output)
+	}
+	return parseBandwidth(output), nil
+}
+
+// parseBandwidth parses 'virsh domiftune' output
+func parseBandwidth(output string) *Bandwidth {
+	bandwidth := &Bandwidth{}
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		kib, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "inbound.average":
+			bandwidth.InboundKiB = kib
+		case "outbound.average":
+			bandwidth.OutboundKiB = kib
+		}
+	}
+	return bandwidth
+}
+
+// SetInterfaceBandwidth sets the rate limits of a VM interface in its saved
+// configuration and, with live, on the running VM. A nil limit is left as is.
+func (c *Client) SetInterfaceBandwidth(vmName, mac string, inboundKiB, outboundKiB *int, live bool) error {
+	cmd := fmt.Sprintf("domiftune %s %s --config", domainArg(vmName), mac)
+	if inboundKiB != nil {
+		cmd += fmt.Sprintf(" --inbound %d", *inboundKiB)
+	}
+	if outboundKiB != nil {
+		cmd += fmt.Sprintf(" --outbound %d", *outboundKiB)
+	}
+	if live {
+		cmd += " --live"
+	}
+	output, err := c.execVirsh(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to set bandwidth of interface %s of VM '%s': %w\nOutput: %s", mac, vmName, err, output)
+	}
+	return nil
+}
+
+// ResolveInterface returns the MAC address of a VM interface given by MAC address,
+// host-side device name such as vnet0 (running VMs only) or index in 'nic list'
+func (c *Client) ResolveInterface(vmName, ref string) (string, error) {
+	domain, err := c.GetDomain(vmName)
+	if err != nil {
+		return "", err
+	}
+	macs := domain.MACs()
+
+	if mac, err := ParseMAC(ref); err == nil {
+		for _, known := range macs {
+			if strings.EqualFold(known, mac) {
+				return known, nil
+			}
+		}
+		return "", fmt.Errorf("VM '%s' has no interface with MAC %s", vmName, mac)
+	}
+	if index, err := strconv.Atoi(ref); err == nil {
+		if index < 0 || index >= len(macs) {
+			return "", fmt.Errorf("VM '%s' has no interface %d (see 'qnap-vm nic list %s')", vmName, index, vmName)
+		}
+		return macs[index], nil
+	}
+
+	interfaces, err := c.LiveInterfaces(vmName)
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range interfaces {
+		if iface.Target == ref {
+			return iface.MAC, nil
+		}
+	}
+	return "", fmt.Errorf("VM '%s' has no interface %s (give a MAC address, an index from 'qnap-vm nic list' or a vnet device of the running VM)", vmName, ref)
+}
@@ -0,0 +1,47 @@
+package virsh
+
+import "testing"
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    int
+		wantErr bool
+	}{
+		{"50mbit", 6103, false},
+		{"1gbit", 122070, false},
+		{"10MB", 10240, false},
+		{"512kb/s", 512, false},
+		{"2048", 2048, false},
+		{"0", 0, false},
+		{"unlimited", 0, false},
+		{"1.5mbit", 183, false},
+		{"1bit", 0, true},
+		{"50mbps", 0, true},
+		{"fast", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.spec)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, %v; want %d, error %v", tt.spec, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseBandwidth(t *testing.T) {
+	output := `inbound.average: 6103
+inbound.peak   : 0
+inbound.burst  : 0
+inbound.floor  : 0
+outbound.average: 12207
+outbound.peak  : 0
+outbound.burst : 0
+`
+	bandwidth := parseBandwidth(output)
+	if bandwidth.InboundKiB != 6103 || bandwidth.OutboundKiB != 12207 {
+		t.Errorf("parseBandwidth = %+v", bandwidth)
+	}
+	if FormatRate(6103) != "6103 KiB/s (50.0 Mbit/s)" || FormatRate(0) != "unlimited" {
+		t.Errorf("FormatRate(6103) = %q", FormatRate(6103))
+	}
+}
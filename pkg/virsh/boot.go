@@ -0,0 +1,108 @@
+package virsh
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Boot devices accepted in a boot order
+const (
+	BootCDROM   = "cdrom"
+	BootHD      = "hd"
+	BootNetwork = "network"
+	BootFloppy  = "fd"
+)
+
+// BootDevices lists the boot devices in the order they are documented
+var BootDevices = []string{BootCDROM, BootHD, BootNetwork, BootFloppy}
+
+// Patterns for the boot order parts of domain XML
+var (
+	osBootPattern     = regexp.MustCompile(`\n?[ \t]*<boot\s+dev=['"]([^'"]*)['"]\s*/>`)
+	deviceBootPattern = regexp.MustCompile(`<boot\s+order=`)
+	osEndPattern      = regexp.MustCompile(`\n?([ \t]*)</os>`)
+)
+
+// ParseBootOrder parses a comma-separated boot order such as "cdrom,hd,network"
+func ParseBootOrder(spec string) ([]string, error) {
+	var devices []string
+	for _, device := range strings.Split(spec, ",") {
+		device = strings.TrimSpace(device)
+		if !slices.Contains(BootDevices, device) {
+			return nil, fmt.Errorf("invalid boot device %q (use %s)", device, strings.Join(BootDevices, ", "))
+		}
+		if slices.Contains(devices, device) {
+			return nil, fmt.Errorf("boot device %s is listed twice", device)
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// newBootOrder returns the <os><boot> entries for devices, or the boot disk alone
+func newBootOrder(devices []string) []DomainBoot {
+	if len(devices) == 0 {
+		return []DomainBoot{{Dev: BootHD}}
+	}
+	boot := make([]DomainBoot, len(devices))
+	for i, device := range devices {
+		boot[i] = DomainBoot{Dev: device}
+	}
+	return boot
+}
+
+// BootOrder returns the boot devices in a VM's saved configuration
+func (c *Client) BootOrder(vmName string) ([]string, error) {
+	domainXML, err := c.dumpInactiveXML(vmName)
+	if err != nil {
+		return nil, err
+	}
+	var devices []string
+	for _, match := range osBootPattern.FindAllStringSubmatch(domainXML, -1) {
+		devices = append(devices, match[1])
+	}
+	return devices, nil
+}
+
+// SetBootOrder replaces the boot order in a VM's saved configuration; it applies from
+// the VM's next start
+func (c *Client) SetBootOrder(vmName string, devices []string) error {
+	domainXML, err := c.dumpInactiveXML(vmName)
+	if err != nil {
+		return err
+	}
+
+	updated, err := setBootOrder(domainXML, devices)
+	if err != nil {
+		return fmt.Errorf("failed to update VM '%s': %w", vmName, err)
+	}
+	return c.defineXML(vmName, updated)
+}
+
+// setBootOrder replaces the <os><boot> entries of domain XML with devices
+func setBootOrder(domainXML string, devices []string) (string, error) {
+	// libvirt rejects <os><boot> alongside per-device boot order
+	if deviceBootPattern.MatchString(domainXML) {
+		return "", fmt.Errorf("the VM sets a boot order on its devices; change it with 'virsh edit'")
+	}
+	match := osEndPattern.FindStringSubmatchIndex(domainXML)
+	if match == nil {
+		return "", fmt.Errorf("domain definition has no <os> element")
+	}
+
+	// Entries go at the end of <os>, one level deeper than its closing tag
+	indent := domainXML[match[2]:match[3]]
+	var entries strings.Builder
+	for _, device := range devices {
+		fmt.Fprintf(&entries, "\n%s  <boot dev='%s'/>", indent, device)
+	}
+	domainXML = domainXML[:match[0]] + entries.String() + domainXML[match[0]:]
+	// Drop the old entries, which all come before the new ones
+	old := osBootPattern.FindAllStringIndex(domainXML, -1)
+	for i := len(old) - len(devices) - 1; i >= 0; i-- {
+		domainXML = domainXML[:old[i][0]] + domainXML[old[i][1]:]
+	}
+	return domainXML, nil
+}
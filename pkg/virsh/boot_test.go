@@ -0,0 +1,63 @@
+package virsh
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBootOrder(t *testing.T) {
+	devices, err := ParseBootOrder("cdrom, hd,network")
+	if err != nil || !reflect.DeepEqual(devices, []string{"cdrom", "hd", "network"}) {
+		t.Errorf("ParseBootOrder = %v, %v", devices, err)
+	}
+	for _, spec := range []string{"usb", "hd,hd", ""} {
+		if _, err := ParseBootOrder(spec); err == nil {
+			t.Errorf("ParseBootOrder(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestSetBootOrder(t *testing.T) {
+	domainXML := `<domain type='kvm'>
+  <os>
+    <type arch='x86_64' machine='pc-i440fx-6.2'>hvm</type>
+    <boot dev='hd'/>
+  </os>
+</domain>`
+	updated, err := setBootOrder(domainXML, []string{"cdrom", "hd"})
+	if err != nil {
+		t.Fatalf("setBootOrder failed: %v", err)
+	}
+	want := `<domain type='kvm'>
+  <os>
+    <type arch='x86_64' machine='pc-i440fx-6.2'>hvm</type>
+    <boot dev='cdrom'/>
+    <boot dev='hd'/>
+  </os>
+</domain>`
+	if updated != want {
+		t.Errorf("setBootOrder =\n%s\nwant\n%s", updated, want)
+	}
+
+	restored, err := setBootOrder(updated, []string{"hd"})
+	if err != nil || restored != domainXML {
+		t.Errorf("restoring the boot order =\n%s\n%v", restored, err)
+	}
+
+	perDevice := strings.Replace(domainXML, "<boot dev='hd'/>", "", 1) + "<disk><boot order='1'/></disk>"
+	if _, err := setBootOrder(perDevice, []string{"cdrom"}); err == nil {
+		t.Error("setBootOrder succeeded with per-device boot order")
+	}
+}
+
+func TestGenerateDomainXMLBootOrder(t *testing.T) {
+	client := &Client{}
+	xml, err := client.generateDomainXML("boot", VMConfig{Memory: 2048, CPUs: 2, Boot: []string{"cdrom", "hd"}})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	if !strings.Contains(xml, `<boot dev="cdrom"></boot>`+"\n    "+`<boot dev="hd"></boot>`) {
+		t.Errorf("Generated XML missing boot order:\n%s", xml)
+	}
+}
@@ -0,0 +1,117 @@
+package virsh
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// CDROMs returns the domain's CD-ROM drives
+func (d *VMDomain) CDROMs() []DomainDisk {
+	var drives []DomainDisk
+	for _, disk := range d.Devices.Disk {
+		if disk.Device == "cdrom" {
+			drives = append(drives, disk)
+		}
+	}
+	return drives
+}
+
+// CDROM returns the CD-ROM drive with the given target. With no target it returns the
+// first drive for which match is true (any drive if match is nil), or nil if none is.
+func (d *VMDomain) CDROM(target string, match func(DomainDisk) bool) (*DomainDisk, error) {
+	drives := d.CDROMs()
+	if target != "" {
+		var targets []string
+		for i := range drives {
+			if drives[i].Target.Dev == target {
+				return &drives[i], nil
+			}
+			targets = append(targets, drives[i].Target.Dev)
+		}
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("VM '%s' has no CD-ROM drive", d.Name)
+		}
+		return nil, fmt.Errorf("VM '%s' has no CD-ROM drive '%s' (drives: %s)", d.Name, target, strings.Join(targets, ", "))
+	}
+
+	for i := range drives {
+		if match == nil || match(drives[i]) {
+			return &drives[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// HasMedia reports whether a drive holds an image
+func HasMedia(drive DomainDisk) bool {
+	return drive.Source.File != "" || drive.Source.Dev != ""
+}
+
+// cdromBus returns the bus for a new CD-ROM drive: the bus of the domain's existing
+// drives, or the bus generateDomainXML would choose for its machine type
+func (d *VMDomain) cdromBus() string {
+	for _, drive := range d.CDROMs() {
+		if drive.Target.Bus != "" {
+			return drive.Target.Bus
+		}
+	}
+	if IsQ35(d.OS.Type.Machine) {
+		return "sata"
+	}
+	return "ide"
+}
+
+// ChangeMedia inserts isoPath into a VM's CD-ROM drive, replacing any image already
+// there, or ejects the drive when isoPath is empty. The change is saved in the VM's
+// configuration and, when live is set, also made in the running VM.
+func (c *Client) ChangeMedia(vmName, target, isoPath string, live bool) error {
+	command := fmt.Sprintf("change-media %s %s", domainArg(vmName), target)
+	if isoPath == "" {
+		command += " --eject"
+	} else {
+		command += fmt.Sprintf(" %s --update", ssh.Quote(isoPath))
+	}
+	command += " --config"
+	if live {
+		command += " --live"
+	}
+
+	output, err := c.execVirsh(command)
+	if err != nil {
+		return fmt.Errorf("failed to change media in drive '%s' of VM '%s': %w\nOutput: %s", target, vmName, err, output)
+	}
+	return nil
+}
+
+// AddCDROM adds a CD-ROM drive holding isoPath to a VM's persistent configuration and
+// returns its target. Drives can't be hot-plugged, so it appears at the next start.
+func (c *Client) AddCDROM(vmName, isoPath string) (string, error) {
+	domain, err := c.GetDomain(vmName)
+	if err != nil {
+		return "", err
+	}
+
+	targets := targetAllocator{}
+	for _, disk := range domain.Devices.Disk {
+		targets[disk.Target.Dev] = true
+	}
+	bus := domain.cdromBus()
+	target := targets.next(bus, 2)
+
+	data, err := xml.MarshalIndent(struct {
+		XMLName xml.Name `xml:"disk"`
+		DomainDisk
+	}{DomainDisk: newCDROM(isoPath, bus, target)}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode CD-ROM drive: %w", err)
+	}
+
+	output, err := c.attachDevice(vmName, "cdrom", data, "--config")
+	if err != nil {
+		return "", fmt.Errorf("failed to add CD-ROM drive to VM '%s': %w\nOutput: %s", vmName, err, output)
+	}
+	return target, nil
+}
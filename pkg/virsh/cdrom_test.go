@@ -0,0 +1,64 @@
+package virsh
+
+import "testing"
+
+func testCDROMDomain(machine string, drives ...[2]string) *VMDomain {
+	domain := &VMDomain{Name: "web"}
+	domain.OS.Type.Machine = machine
+	domain.Devices.Disk = append(domain.Devices.Disk, newQcow2Disk("/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2", "virtio", "vda"))
+	for _, drive := range drives {
+		domain.Devices.Disk = append(domain.Devices.Disk, newCDROM(drive[1], "ide", drive[0]))
+	}
+	return domain
+}
+
+func TestCDROM(t *testing.T) {
+	domain := testCDROMDomain("pc", [2]string{"hdc", ""}, [2]string{"hdd", "/share/iso/seed.iso"})
+
+	if got := len(domain.CDROMs()); got != 2 {
+		t.Fatalf("CDROMs() returned %d drives, want 2", got)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		target string
+		match  func(DomainDisk) bool
+		want   string
+	}{
+		{"first drive", "", nil, "hdc"},
+		{"first loaded", "", HasMedia, "hdd"},
+		{"by target", "hdd", func(DomainDisk) bool { return false }, "hdd"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			drive, err := domain.CDROM(tt.target, tt.match)
+			if err != nil || drive == nil || drive.Target.Dev != tt.want {
+				t.Errorf("CDROM(%q) = %+v, %v, want %s", tt.target, drive, err, tt.want)
+			}
+		})
+	}
+
+	if drive, err := domain.CDROM("", func(d DomainDisk) bool { return d.Target.Dev == "hdx" }); drive != nil || err != nil {
+		t.Errorf("CDROM() with no match = %+v, %v, want nil, nil", drive, err)
+	}
+	if _, err := domain.CDROM("vda", nil); err == nil {
+		t.Error("CDROM(vda) should reject a disk that isn't a CD-ROM drive")
+	}
+	if _, err := testCDROMDomain("pc").CDROM("hdc", nil); err == nil {
+		t.Error("CDROM() should fail for a VM without CD-ROM drives")
+	}
+}
+
+func TestCDROMBus(t *testing.T) {
+	for _, tt := range []struct {
+		domain *VMDomain
+		want   string
+	}{
+		{testCDROMDomain("pc"), "ide"},
+		{testCDROMDomain("q35"), "sata"},
+		{testCDROMDomain("q35", [2]string{"hdc", ""}), "ide"},
+	} {
+		if got := tt.domain.cdromBus(); got != tt.want {
+			t.Errorf("cdromBus() for %s = %s, want %s", tt.domain.OS.Type.Machine, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package virsh
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// LiveInterface is a network interface of a running VM
+type LiveInterface struct {
+	Type   string // bridge, network, ...
+	MAC    string
+	Target string // Host-side device, e.g. vnet0
+	XML    string // The <interface> element, for attaching it again
+}
+
+var (
+	interfaceElementPattern = regexp.MustCompile(`(?s)<interface\b.*?</interface>`)
+	interfaceTypePattern    = regexp.MustCompile(`^<interface\s+type=['"]([^'"]+)['"]`)
+	interfaceMACPattern     = regexp.MustCompile(`<mac address=['"]([^'"]+)['"]`)
+	interfaceTargetPattern  = regexp.MustCompile(`<target dev=['"]([^'"]+)['"]`)
+
+	// Elements libvirt assigns to a running device, which it rejects or reassigns on attach
+	liveOnlyPattern = regexp.MustCompile(`\n?[ \t]*<(target|alias)\s[^>]*/>`)
+)
+
+// LiveInterfaces returns the network interfaces of a running VM
+func (c *Client) LiveInterfaces(vmName string) ([]LiveInterface, error) {
+	output, err := c.execVirsh(fmt.Sprintf("dumpxml %s", domainArg(vmName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration for VM '%s': %w\nOutput: %s", vmName, err, output)
+	}
+	return parseLiveInterfaces(output), nil
+}
+
+// parseLiveInterfaces extracts the interfaces from live domain XML
+func parseLiveInterfaces(domainXML string) []LiveInterface {
+	var interfaces []LiveInterface
+	for _, element := range interfaceElementPattern.FindAllString(domainXML, -1) {
+		iface := LiveInterface{XML: liveOnlyPattern.ReplaceAllString(element, "")}
+		if m := interfaceTypePattern.FindStringSubmatch(element); m != nil {
+			iface.Type = m[1]
+		}
+		if m := interfaceMACPattern.FindStringSubmatch(element); m != nil {
+			iface.MAC = m[1]
+		}
+		if m := interfaceTargetPattern.FindStringSubmatch(element); m != nil {
+			iface.Target = m[1]
+		}
+		interfaces = append(interfaces, iface)
+	}
+	return interfaces
+}
+
+// DetachInterface unplugs a network interface from a running VM, leaving its persistent
+// definition alone. The guest must support PCI hot unplug.
+func (c *Client) DetachInterface(vmName string, iface LiveInterface) error {
+	cmd := fmt.Sprintf("detach-interface %s --type %s --mac %s --live", domainArg(vmName), ssh.Quote(iface.Type), ssh.Quote(iface.MAC))
+	output, err := c.execVirsh(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to detach interface %s from VM '%s': %w\nOutput: %s", iface.MAC, vmName, err, output)
+	}
+	return nil
+}
+
+// AttachInterface plugs an interface returned by LiveInterfaces back into a running VM
+func (c *Client) AttachInterface(vmName string, iface LiveInterface) error {
+	xmlFile := ssh.Quote(fmt.Sprintf("/tmp/qnap-vm-%s-interface.xml", fileSafeName(vmName)))
+	if _, err := c.sshClient.ExecuteContext(c.commandContext(), fmt.Sprintf("cat > %s << 'EOF'\n%s\nEOF", xmlFile, iface.XML)); err != nil {
+		return fmt.Errorf("failed to create interface XML file: %w", err)
+	}
+	defer func() {
+		if _, err := c.sshClient.ExecuteContext(c.commandContext(), fmt.Sprintf("rm -f %s", xmlFile)); err != nil {
+			// Leftovers use the /tmp/qnap-vm- prefix removed by 'host cleanup'
+		}
+	}()
+
+	output, err := c.execVirsh(fmt.Sprintf("attach-device %s %s --live", domainArg(vmName), xmlFile))
+	if err != nil {
+		return fmt.Errorf("failed to attach interface %s to VM '%s': %w\nOutput: %s", iface.MAC, vmName, err, output)
+	}
+	return nil
+}
+
+// SetLinkState sets the link of a running VM's interface up or down, as if its cable
+// were plugged in or pulled
+func (c *Client) SetLinkState(vmName string, iface LiveInterface, up bool) error {
+	state := "down"
+	if up {
+		state = "up"
+	}
+	output, err := c.execVirsh(fmt.Sprintf("domif-setlink %s %s %s", domainArg(vmName), ssh.Quote(iface.MAC), state))
+	if err != nil {
+		return fmt.Errorf("failed to set link %s on interface %s of VM '%s': %w\nOutput: %s", state, iface.MAC, vmName, err, output)
+	}
+	return nil
+}
+
+// IOTune holds a disk's I/O limits; zero means unlimited
+type IOTune struct {
+	TotalBytesSec int64
+	ReadBytesSec  int64
+	WriteBytesSec int64
+	TotalIOPSSec  int64
+	ReadIOPSSec   int64
+	WriteIOPSSec  int64
+}
+
+// ioLimit is one of a disk's limits and its blkdeviotune name
+type ioLimit struct {
+	name  string
+	value *int64
+}
+
+// fields returns each of the limits
+func (t *IOTune) fields() []ioLimit {
+	return []ioLimit{
+		{"total_bytes_sec", &t.TotalBytesSec},
+		{"read_bytes_sec", &t.ReadBytesSec},
+		{"write_bytes_sec", &t.WriteBytesSec},
+		{"total_iops_sec", &t.TotalIOPSSec},
+		{"read_iops_sec", &t.ReadIOPSSec},
+		{"write_iops_sec", &t.WriteIOPSSec},
+	}
+}
+
+// GetIOTune returns the current I/O limits of a running VM's disk
+func (c *Client) GetIOTune(vmName, target string) (*IOTune, error) {
+	output, err := c.execVirsh(fmt.Sprintf("blkdeviotune %s %s --live", domainArg(vmName), ssh.Quote(target)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read I/O limits of disk %s of VM '%s': %w\nOutput: %s", target, vmName, err, output)
+	}
+	return parseIOTune(output), nil
+}
+
+// parseIOTune parses 'blkdeviotune' output of "name: value" lines
+func parseIOTune(output string) *IOTune {
+	values := make(map[string]int64)
+	for _, line := range strings.Split(output, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+			values[strings.TrimSpace(name)] = n
+		}
+	}
+
+	tune := &IOTune{}
+	for _, field := range tune.fields() {
+		*field.value = values[field.name]
+	}
+	return tune
+}
+
+// SetIOTune replaces the I/O limits of a running VM's disk. Total limits cannot be
+// combined with read or write limits of the same kind.
+func (c *Client) SetIOTune(vmName, target string, tune IOTune) error {
+	cmd := fmt.Sprintf("blkdeviotune %s %s --live", domainArg(vmName), ssh.Quote(target))
+	for _, field := range tune.fields() {
+		cmd += fmt.Sprintf(" --%s %d", strings.ReplaceAll(field.name, "_", "-"), *field.value)
+	}
+
+	output, err := c.execVirsh(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to set I/O limits of disk %s of VM '%s': %w\nOutput: %s", target, vmName, err, output)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package virsh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLiveInterfaces(t *testing.T) {
+	domainXML := `<domain type='kvm' id='3'>
+  <name>web</name>
+  <devices>
+    <interface type='bridge'>
+      <mac address='52:54:00:12:34:56'/>
+      <source bridge='qvs0'/>
+      <target dev='vnet2'/>
+      <model type='virtio'/>
+      <alias name='net0'/>
+      <address type='pci' domain='0x0000' bus='0x00' slot='0x03' function='0x0'/>
+    </interface>
+    <interface type='network'>
+      <mac address='52:54:00:ab:cd:ef'/>
+      <source network='default'/>
+      <model type='e1000'/>
+    </interface>
+  </devices>
+</domain>`
+
+	interfaces := parseLiveInterfaces(domainXML)
+	if len(interfaces) != 2 {
+		t.Fatalf("parseLiveInterfaces() returned %d interfaces, want 2", len(interfaces))
+	}
+
+	first := interfaces[0]
+	if first.Type != "bridge" || first.MAC != "52:54:00:12:34:56" || first.Target != "vnet2" {
+		t.Errorf("first interface = %+v", first)
+	}
+	// The runtime target and alias are dropped so the interface can be attached again,
+	// but the PCI address is kept so the guest sees the same NIC
+	for _, removed := range []string{"vnet2", "alias"} {
+		if strings.Contains(first.XML, removed) {
+			t.Errorf("interface XML still contains %q:\n%s", removed, first.XML)
+		}
+	}
+	if !strings.Contains(first.XML, "slot='0x03'") || !strings.Contains(first.XML, "<source bridge='qvs0'/>") {
+		t.Errorf("interface XML lost its configuration:\n%s", first.XML)
+	}
+
+	if second := interfaces[1]; second.Type != "network" || second.MAC != "52:54:00:ab:cd:ef" || second.Target != "" {
+		t.Errorf("second interface = %+v", second)
+	}
+}
+
+func TestParseIOTune(t *testing.T) {
+	output := `total_bytes_sec: 0
+read_bytes_sec : 10485760
+write_bytes_sec: 0
+total_iops_sec : 0
+read_iops_sec  : 0
+write_iops_sec : 200
+total_bytes_sec_max: 0
+group_name     : drive-virtio-disk0
+`
+	got := parseIOTune(output)
+	want := IOTune{ReadBytesSec: 10485760, WriteIOPSSec: 200}
+	if *got != want {
+		t.Errorf("parseIOTune() = %+v, want %+v", *got, want)
+	}
+}
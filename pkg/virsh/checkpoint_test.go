@@ -0,0 +1,70 @@
+package virsh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBackupXML(t *testing.T) {
+	disks := []BackupDisk{
+		{Target: "vda", File: "/share/Backups/web-1/vda.qcow2"},
+		{Target: "vdb"},
+	}
+
+	full := backupXML("", disks)
+	if strings.Contains(full, "<incremental>") {
+		t.Errorf("full backup XML should not be incremental:\n%s", full)
+	}
+	for _, want := range []string{
+		"<domainbackup mode='push'>",
+		"<disk name='vda' backup='yes' type='file'>",
+		"<target file='/share/Backups/web-1/vda.qcow2'/>",
+		"<disk name='vdb' backup='no'/>",
+	} {
+		if !strings.Contains(full, want) {
+			t.Errorf("backupXML() missing %q:\n%s", want, full)
+		}
+	}
+
+	incremental := backupXML("qnap-vm-20260101-000000", disks)
+	if !strings.Contains(incremental, "<incremental>qnap-vm-20260101-000000</incremental>") {
+		t.Errorf("backupXML() missing incremental checkpoint:\n%s", incremental)
+	}
+}
+
+func TestCheckpointXML(t *testing.T) {
+	disks := []BackupDisk{
+		{Target: "vda", File: "/share/Backups/web-1/vda.qcow2"},
+		{Target: "vdb"},
+	}
+
+	got := checkpointXML("qnap-vm-20260102-000000", disks)
+	for _, want := range []string{
+		"<name>qnap-vm-20260102-000000</name>",
+		"<disk name='vda' checkpoint='bitmap'/>",
+		"<disk name='vdb' checkpoint='no'/>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("checkpointXML() missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestJobType(t *testing.T) {
+	running := `Job type:         Unbounded
+Operation:        Backup
+Time elapsed:     1203         ms
+`
+	if got := jobType(running); got != "Unbounded" {
+		t.Errorf("jobType() = %q, want Unbounded", got)
+	}
+	if got := jobType("Job type:         None\n"); got != "None" {
+		t.Errorf("jobType() = %q, want None", got)
+	}
+	if got := jobType("Job type:         Completed\nOperation:        Backup\n"); got != "Completed" {
+		t.Errorf("jobType() = %q, want Completed", got)
+	}
+	if got := jobType("error: no such domain"); got != "" {
+		t.Errorf("jobType() = %q, want empty", got)
+	}
+}
@@ -2,12 +2,16 @@
 package virsh
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
 	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
 )
 
@@ -15,6 +19,9 @@ import (
 type Client struct {
 	sshClient *ssh.Client
 	qvsPath   string
+	ctx       context.Context // Context of the client's commands; the SSH client's when nil
+
+	registerQVS bool // Keep Virtualization Station's VM database in step with libvirt
 }
 
 // VMInfo represents information about a virtual machine
@@ -25,6 +32,30 @@ type VMInfo struct {
 	UUID   string `json:"uuid"`
 	Memory int    `json:"memory_mb"`
 	CPUs   int    `json:"cpus"`
+
+	Title string   `json:"title,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+
+	// Transient domains are running but have no persistent definition
+	Transient bool `json:"transient,omitempty"`
+	// ManagedSave is set when the VM has a saved memory image restored on next start
+	ManagedSave bool `json:"managed_save,omitempty"`
+	// Recovery is the VM's watchdog and crash/reboot policies, when they were read
+	Recovery *RecoveryPolicy `json:"recovery,omitempty"`
+	// MACs are the VM's interface MAC addresses, when they were read
+	MACs []string `json:"macs,omitempty"`
+}
+
+// StateLabel returns the VM state annotated with transient and managed-save markers
+func (vm *VMInfo) StateLabel() string {
+	label := vm.State
+	if vm.Transient {
+		label += " (transient)"
+	}
+	if vm.ManagedSave {
+		label += " (saved)"
+	}
+	return label
 }
 
 // VMDomain represents a libvirt domain XML structure (simplified)
@@ -33,51 +64,126 @@ type VMDomain struct {
 	Type    string   `xml:"type,attr"`
 	Name    string   `xml:"name"`
 	UUID    string   `xml:"uuid,omitempty"`
+	Title   string   `xml:"title,omitempty"`
 	Memory  struct {
 		Unit  string `xml:"unit,attr"`
 		Value int    `xml:",chardata"`
 	} `xml:"memory"`
-	VCPU struct {
+	MemoryBacking *DomainMemoryBacking `xml:"memoryBacking,omitempty"`
+	VCPU          struct {
 		Placement string `xml:"placement,attr"`
 		Value     int    `xml:",chardata"`
 	} `xml:"vcpu"`
-	OS struct {
+	CPUTune  *DomainCPUTune  `xml:"cputune,omitempty"`
+	CPU      *DomainCPU      `xml:"cpu,omitempty"`
+	NUMATune *DomainNUMATune `xml:"numatune,omitempty"`
+	OS       struct {
 		Type struct {
 			Arch    string `xml:"arch,attr"`
 			Machine string `xml:"machine,attr"`
 			Value   string `xml:",chardata"`
 		} `xml:"type"`
-		Boot struct {
-			Dev string `xml:"dev,attr"`
-		} `xml:"boot"`
+		Loader *DomainLoader `xml:"loader,omitempty"`
+		NVRAM  *DomainNVRAM  `xml:"nvram,omitempty"`
+		Boot   []DomainBoot  `xml:"boot"`
 	} `xml:"os"`
-	Devices struct {
-		Emulator string `xml:"emulator,omitempty"`
-		Disk     []struct {
-			Type   string `xml:"type,attr"`
-			Device string `xml:"device,attr"`
-			Driver struct {
-				Name string `xml:"name,attr"`
-				Type string `xml:"type,attr"`
-			} `xml:"driver"`
-			Source struct {
-				File string `xml:"file,attr,omitempty"`
-			} `xml:"source"`
-			Target struct {
-				Dev string `xml:"dev,attr"`
-				Bus string `xml:"bus,attr"`
-			} `xml:"target"`
-		} `xml:"disk"`
-		Interface []struct {
-			Type   string `xml:"type,attr"`
-			Source struct {
-				Bridge string `xml:"bridge,attr,omitempty"`
-			} `xml:"source"`
-			Model struct {
-				Type string `xml:"type,attr"`
-			} `xml:"model"`
-		} `xml:"interface"`
+	Features *DomainFeatures `xml:"features,omitempty"`
+	Clock    *DomainClock    `xml:"clock,omitempty"`
+	OnReboot string          `xml:"on_reboot,omitempty"`
+	OnCrash  string          `xml:"on_crash,omitempty"`
+	Devices  struct {
+		Emulator  string            `xml:"emulator,omitempty"`
+		Disk      []DomainDisk      `xml:"disk"`
+		Interface []DomainInterface `xml:"interface"`
+		Graphics  []DomainGraphics  `xml:"graphics"`
+		Video     *DomainVideo      `xml:"video,omitempty"`
+		Input     []DomainInput     `xml:"input"`
+		Sound     *DomainSound      `xml:"sound,omitempty"`
+		Channel   []DomainChannel   `xml:"channel"`
+		TPM       *DomainTPM        `xml:"tpm,omitempty"`
+		RNG       *DomainRNG        `xml:"rng,omitempty"`
+		Watchdog  *DomainWatchdog   `xml:"watchdog,omitempty"`
+		Hostdev   []DomainHostdev   `xml:"hostdev"`
 	} `xml:"devices"`
+
+	// QEMU command-line passthrough (qemu:commandline namespace)
+	QemuNS          string           `xml:"xmlns:qemu,attr,omitempty"`
+	QemuCommandline *QemuCommandline `xml:"qemu:commandline,omitempty"`
+}
+
+// DomainClock represents the <clock> element
+type DomainClock struct {
+	Offset string `xml:"offset,attr"` // utc or localtime
+}
+
+// DomainBoot represents an <os><boot> entry
+type DomainBoot struct {
+	Dev string `xml:"dev,attr"`
+}
+
+// DomainDisk represents a <disk> device
+type DomainDisk struct {
+	Type   string `xml:"type,attr"`
+	Device string `xml:"device,attr"`
+	Driver struct {
+		Name  string `xml:"name,attr"`
+		Type  string `xml:"type,attr"`
+		Cache string `xml:"cache,attr,omitempty"`
+		IO    string `xml:"io,attr,omitempty"`
+
+		Discard      string `xml:"discard,attr,omitempty"`
+		DetectZeroes string `xml:"detect_zeroes,attr,omitempty"`
+	} `xml:"driver"`
+	Source struct {
+		File string `xml:"file,attr,omitempty"`
+		Dev  string `xml:"dev,attr,omitempty"`
+	} `xml:"source"`
+	Target struct {
+		Dev string `xml:"dev,attr"`
+		Bus string `xml:"bus,attr"`
+	} `xml:"target"`
+	ReadOnly *struct{} `xml:"readonly,omitempty"`
+}
+
+// DomainInterface represents an <interface> device
+type DomainInterface struct {
+	Type   string     `xml:"type,attr"`
+	MAC    *DomainMAC `xml:"mac,omitempty"`
+	Source struct {
+		Bridge  string `xml:"bridge,attr,omitempty"`
+		Network string `xml:"network,attr,omitempty"`
+		Dev     string `xml:"dev,attr,omitempty"` // Host device of a direct (macvtap) interface
+	} `xml:"source"`
+	Model struct {
+		Type string `xml:"type,attr"`
+	} `xml:"model"`
+	Driver *struct {
+		Queues int `xml:"queues,attr,omitempty"`
+	} `xml:"driver,omitempty"`
+	MTU *DomainMTU `xml:"mtu,omitempty"`
+}
+
+// DomainGraphics represents a <graphics> device (VNC or SPICE)
+type DomainGraphics struct {
+	Type      string `xml:"type,attr"`
+	Port      int    `xml:"port,attr"`
+	AutoPort  string `xml:"autoport,attr,omitempty"`
+	Listen    string `xml:"listen,attr,omitempty"`
+	Clipboard *struct {
+		CopyPaste string `xml:"copypaste,attr"`
+	} `xml:"clipboard,omitempty"`
+	FileTransfer *struct {
+		Enable string `xml:"enable,attr"`
+	} `xml:"filetransfer,omitempty"`
+}
+
+// DomainChannel represents a <channel> device such as the SPICE agent channel
+type DomainChannel struct {
+	Type   string `xml:"type,attr"`
+	Target struct {
+		Type string `xml:"type,attr"`
+		Name string `xml:"name,attr,omitempty"`
+	} `xml:"target"`
 }
 
 // NewClient creates a new virsh client
@@ -94,7 +200,7 @@ func (c *Client) Initialize() error {
 
 	for _, path := range possiblePaths {
 		testCmd := fmt.Sprintf("test -d %s && echo 'found'", path)
-		output, err := c.sshClient.Execute(testCmd)
+		output, err := c.sshClient.ExecuteContext(c.commandContext(), testCmd)
 		if err == nil && strings.TrimSpace(output) == "found" {
 			c.qvsPath = path
 			break
@@ -113,6 +219,30 @@ func (c *Client) Initialize() error {
 	return nil
 }
 
+// WithContext returns a copy of the client whose commands are terminated on the NAS
+// when ctx is cancelled
+func (c *Client) WithContext(ctx context.Context) *Client {
+	scoped := *c
+	scoped.ctx = ctx
+	return &scoped
+}
+
+// commandContext returns the context the client's commands run with
+func (c *Client) commandContext() context.Context {
+	if c.ctx != nil {
+		return c.ctx
+	}
+	if c.sshClient != nil {
+		return c.sshClient.Context()
+	}
+	return context.Background()
+}
+
+// QVSPath returns the detected QVS/KVM installation path
+func (c *Client) QVSPath() string {
+	return c.qvsPath
+}
+
 // setupEnvironment sets up the required environment variables for virsh
 func (c *Client) setupEnvironment() error {
 	envCmd := fmt.Sprintf(`
@@ -121,7 +251,7 @@ func (c *Client) setupEnvironment() error {
 		virsh version >/dev/null 2>&1 && echo 'virsh_ready'
 	`, c.qvsPath, c.qvsPath, c.qvsPath, c.qvsPath)
 
-	output, err := c.sshClient.Execute(envCmd)
+	output, err := c.sshClient.ExecuteContext(c.commandContext(), envCmd)
 	if err != nil || !strings.Contains(output, "virsh_ready") {
 		return fmt.Errorf("virsh is not accessible or not working properly")
 	}
@@ -137,7 +267,34 @@ func (c *Client) execVirsh(command string) (string, error) {
 		virsh %s
 	`, c.qvsPath, c.qvsPath, c.qvsPath, c.qvsPath, command)
 
-	return c.sshClient.Execute(fullCmd)
+	return c.sshClient.ExecuteContext(c.commandContext(), fullCmd)
+}
+
+// domainArg returns a virsh domain argument that survives the remote shell. The explicit
+// --domain option keeps names with spaces, unicode or a leading dash intact.
+func domainArg(name string) string {
+	return "--domain " + ssh.Quote(name)
+}
+
+// fileSafeName replaces characters that are awkward in file names on the device
+func fileSafeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == ' ' || r < 0x20 || r > 0x7e {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// execVirshScript executes a shell script with the virsh environment set up
+func (c *Client) execVirshScript(script string) (string, error) {
+	fullCmd := fmt.Sprintf(`
+		export LD_LIBRARY_PATH=%s/usr/lib:%s/usr/lib64/
+		export PATH=$PATH:%s/usr/bin/:%s/usr/sbin/
+		%s
+	`, c.qvsPath, c.qvsPath, c.qvsPath, c.qvsPath, script)
+
+	return c.sshClient.ExecuteContext(c.commandContext(), fullCmd)
 }
 
 // ListVMs lists all virtual machines
@@ -163,12 +320,12 @@ func (c *Client) GetVM(name string) (*VMInfo, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("VM '%s' not found", name)
+	return nil, messages.Errorf(messages.VMNotFound, name)
 }
 
 // StartVM starts a virtual machine
 func (c *Client) StartVM(name string) error {
-	cmd := fmt.Sprintf("start %s", name)
+	cmd := fmt.Sprintf("start %s", domainArg(name))
 	output, err := c.execVirsh(cmd)
 	if err != nil {
 		return fmt.Errorf("failed to start VM '%s': %w\nOutput: %s", name, err, output)
@@ -182,7 +339,7 @@ func (c *Client) StopVM(name string, force bool) error {
 	if force {
 		cmd = "destroy"
 	}
-	cmd = fmt.Sprintf("%s %s", cmd, name)
+	cmd = fmt.Sprintf("%s %s", cmd, domainArg(name))
 
 	output, err := c.execVirsh(cmd)
 	if err != nil {
@@ -191,35 +348,105 @@ func (c *Client) StopVM(name string, force bool) error {
 	return nil
 }
 
-// DeleteVM deletes a virtual machine
+// SuspendVM pauses a running virtual machine's vCPUs, keeping it in memory
+func (c *Client) SuspendVM(name string) error {
+	output, err := c.execVirsh(fmt.Sprintf("suspend %s", domainArg(name)))
+	if err != nil {
+		return fmt.Errorf("failed to suspend VM '%s': %w\nOutput: %s", name, err, output)
+	}
+	return nil
+}
+
+// ResumeVM resumes a virtual machine paused by SuspendVM
+func (c *Client) ResumeVM(name string) error {
+	output, err := c.execVirsh(fmt.Sprintf("resume %s", domainArg(name)))
+	if err != nil {
+		return fmt.Errorf("failed to resume VM '%s': %w\nOutput: %s", name, err, output)
+	}
+	return nil
+}
+
+// RebootVM asks a running virtual machine's guest to reboot
+func (c *Client) RebootVM(name string) error {
+	output, err := c.execVirsh(fmt.Sprintf("reboot %s", domainArg(name)))
+	if err != nil {
+		return fmt.Errorf("failed to reboot VM '%s': %w\nOutput: %s", name, err, output)
+	}
+	return nil
+}
+
+// DeleteVM deletes a virtual machine and removes it from Virtualization Station. A
+// *QVSRegistrationError means the VM was deleted but the web UI may still list it.
 func (c *Client) DeleteVM(name string) error {
+	vm, err := c.GetVMDetails(name)
+	if err != nil {
+		return err
+	}
+
 	// First, make sure the VM is stopped
 	if err := c.StopVM(name, true); err != nil {
 		// Continue even if stop fails, the VM might already be stopped
 		// This is expected behavior for VMs that are already stopped
 	}
 
-	// Undefine the domain
-	cmd := fmt.Sprintf("undefine %s", name)
-	output, err := c.execVirsh(cmd)
+	// Transient domains disappear once destroyed and cannot be undefined
+	if vm.Transient {
+		if _, err := c.GetVM(name); err != nil {
+			return nil
+		}
+		return fmt.Errorf("failed to delete transient VM '%s': domain is still present after destroy", name)
+	}
+
+	// Undefine the domain along with its managed save image, NVRAM and snapshot metadata
+	output, err := c.execVirsh(undefineCommand(name, true))
+	if err != nil && strings.Contains(output, "nvram") {
+		// Older libvirt releases do not know --nvram
+		output, err = c.execVirsh(undefineCommand(name, false))
+	}
 	if err != nil {
 		return fmt.Errorf("failed to delete VM '%s': %w\nOutput: %s", name, err, output)
 	}
+	if vm.UUID != "" {
+		if err := c.unregisterFromQVS(vm.UUID); err != nil {
+			return &QVSRegistrationError{VM: name, Err: err}
+		}
+	}
 	return nil
 }
 
-// CreateVM creates a new virtual machine
+// undefineCommand builds the virsh undefine command for a persistent domain
+func undefineCommand(name string, nvram bool) string {
+	cmd := fmt.Sprintf("undefine %s --managed-save --snapshots-metadata", domainArg(name))
+	if nvram {
+		cmd += " --nvram"
+	}
+	return cmd
+}
+
+// CreateVM creates a new virtual machine and registers it with Virtualization Station.
+// A *QVSRegistrationError means the VM was created but the web UI will not list it.
 func (c *Client) CreateVM(name string, config VMConfig) error {
 	domain, err := c.generateDomainXML(name, config)
 	if err != nil {
 		return fmt.Errorf("failed to generate domain XML: %w", err)
 	}
 
-	// Create temporary XML file on remote system
-	xmlFile := fmt.Sprintf("/tmp/%s.xml", name)
-	createFileCmd := fmt.Sprintf("cat > %s << 'EOF'\n%s\nEOF", xmlFile, domain)
+	if err := c.defineXML(name, domain); err != nil {
+		return err
+	}
+	if err := c.registerWithQVS(name); err != nil {
+		return &QVSRegistrationError{VM: name, Err: err}
+	}
+	return nil
+}
+
+// defineXML defines (or redefines) a domain from XML
+func (c *Client) defineXML(name, domainXML string) error {
+	// Create temporary XML file on remote system ('host cleanup' removes leftovers by prefix)
+	xmlFile := ssh.Quote(fmt.Sprintf("/tmp/qnap-vm-%s.xml", fileSafeName(name)))
+	createFileCmd := fmt.Sprintf("cat > %s << 'EOF'\n%s\nEOF", xmlFile, domainXML)
 
-	if _, err := c.sshClient.Execute(createFileCmd); err != nil {
+	if _, err := c.sshClient.ExecuteContext(c.commandContext(), createFileCmd); err != nil {
 		return fmt.Errorf("failed to create XML file: %w", err)
 	}
 
@@ -231,20 +458,249 @@ func (c *Client) CreateVM(name string, config VMConfig) error {
 	}
 
 	// Clean up temporary XML file
-	if _, err := c.sshClient.Execute(fmt.Sprintf("rm -f %s", xmlFile)); err != nil {
+	if _, err := c.sshClient.ExecuteContext(c.commandContext(), fmt.Sprintf("rm -f %s", xmlFile)); err != nil {
 		// Cleanup failure is not critical, file will be overwritten next time
 	}
 
 	return nil
 }
 
+// AttachDisk attaches an existing qcow2 disk image to a VM's persistent configuration
+func (c *Client) AttachDisk(vmName, diskPath, target, bus string, opts DiskOptions) error {
+	disk := newQcow2Disk(diskPath, bus, target)
+	disk.applyOptions(opts)
+	data, err := xml.MarshalIndent(struct {
+		XMLName xml.Name `xml:"disk"`
+		DomainDisk
+	}{DomainDisk: disk}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode disk: %w", err)
+	}
+
+	output, err := c.attachDevice(vmName, "disk", data, "--config")
+	if err != nil {
+		return fmt.Errorf("failed to attach disk '%s' to VM '%s': %w\nOutput: %s", diskPath, vmName, err, output)
+	}
+	return nil
+}
+
+// attachDevice attaches a device element to a VM with 'virsh attach-device' and the
+// given flags, such as --config and --live
+func (c *Client) attachDevice(vmName, kind string, deviceXML []byte, flags string) (string, error) {
+	xmlFile := ssh.Quote(fmt.Sprintf("/tmp/qnap-vm-%s-%s.xml", fileSafeName(vmName), kind))
+	if _, err := c.sshClient.ExecuteContext(c.commandContext(), fmt.Sprintf("cat > %s << 'EOF'\n%s\nEOF", xmlFile, deviceXML)); err != nil {
+		return "", fmt.Errorf("failed to create %s XML file: %w", kind, err)
+	}
+	defer func() {
+		if _, err := c.sshClient.ExecuteContext(c.commandContext(), fmt.Sprintf("rm -f %s", xmlFile)); err != nil {
+			// Leftovers use the /tmp/qnap-vm- prefix removed by 'host cleanup'
+		}
+	}()
+
+	return c.execVirsh(fmt.Sprintf("attach-device %s %s %s", domainArg(vmName), xmlFile, flags))
+}
+
 // VMConfig represents the configuration for creating a VM
 type VMConfig struct {
 	Memory   int    // Memory in MB
 	CPUs     int    // Number of CPU cores
 	DiskSize string // Disk size (e.g., "20G")
 	DiskPath string // Path to disk image
-	ISOPath  string // Path to ISO file for installation
+	DiskBus  string // Disk bus (virtio, sata, scsi, ide); defaults to virtio
+	// DiskOptions tune the primary disk's cache and I/O mode
+	DiskOptions DiskOptions
+	ISOPath     string // Path to ISO file for installation
+	SeedISO     string // Path to a cloud-init seed ISO, attached as a second CD-ROM
+	DriverISO   string // Path to a driver ISO such as virtio-win, attached as another CD-ROM
+	Graphics    string // Graphics protocol (vnc or spice); defaults to vnc
+
+	// Disks are additional data disks attached after the primary disk
+	Disks []VMDisk
+
+	Title string // Human-friendly VM title shown by list
+
+	// CPUPins pin vCPUs to host CPUs
+	CPUPins []CPUPin
+	// CPUTopology lays the vCPUs out in sockets, cores and threads; nil leaves it to QEMU
+	CPUTopology *CPUTopology
+	// CPUModel is host-passthrough, host-model or a named QEMU model; "" leaves it to QEMU
+	CPUModel string
+	// CPUFeatures are CPU features the guest requires, e.g. vmx for nested virtualization
+	CPUFeatures []string
+	// NUMA splits the VM into guest NUMA cells and binds its memory to host nodes
+	NUMA *NUMAConfig
+	// HugePages backs the VM's memory with the NAS's default-size huge pages
+	HugePages bool
+	// OVMF boots the VM with UEFI firmware instead of BIOS
+	OVMF *OVMF
+	// TPM adds an emulated TPM 2.0 device
+	TPM bool
+	// Machine is the exact QEMU machine type; "" uses LegacyMachine (q35 for Secure Boot)
+	Machine string
+	// Boot is the firmware boot order, e.g. cdrom, hd; nil boots from the first disk
+	Boot []string
+
+	Network   VMNetwork // What the NIC connects to; user networking by default
+	MAC       string    // NIC MAC address; defaults to StableMAC for the VM's name
+	NetModel  string    // NIC model (virtio, e1000, rtl8139); defaults to virtio
+	NetQueues int       // virtio multiqueue count; 0 or 1 disables multiqueue
+	MTU       int       // NIC MTU, e.g. 9000 for jumbo frames; 0 keeps the network's
+
+	// LocalTime keeps the guest's hardware clock in local time, as Windows expects
+	LocalTime bool
+
+	Video  string // Video model (virtio, qxl, vga, ...); "" keeps libvirt's default
+	Tablet bool   // Add a USB tablet for accurate mouse tracking over VNC
+	Sound  string // Sound model (ich9, ich6, ac97); "" adds no sound device
+
+	// DisableClipboard turns off SPICE clipboard sharing and file transfer
+	DisableClipboard bool
+	// DisableRNG leaves out the virtio-rng entropy device
+	DisableRNG bool
+
+	// HostDevs are full PCI addresses of host devices passed through to the VM
+	HostDevs []string
+
+	// Watchdog resets (or otherwise recovers) a hung guest
+	Watchdog *DomainWatchdog
+	// OnCrash and OnReboot are libvirt lifecycle policies; "" keeps libvirt's defaults
+	OnCrash  string
+	OnReboot string
+
+	// QemuArgs are passed verbatim to QEMU via qemu:commandline
+	QemuArgs []string
+}
+
+// Disk backends for VMDisk.Type
+const (
+	DiskTypeImage   = ""      // qcow2 image file
+	DiskTypeBlock   = "block" // Raw block device, such as a block-based iSCSI LUN
+	DiskTypeRawFile = "raw"   // Raw file, such as a file-based iSCSI LUN
+)
+
+// VMDisk describes an additional disk attached to a VM
+type VMDisk struct {
+	Path    string // Path to disk image or block device
+	Bus     string // Disk bus; defaults to virtio
+	Type    string // Disk backend; defaults to a qcow2 image
+	Options DiskOptions
+}
+
+// NetModels lists the supported NIC models
+var NetModels = []string{"virtio", "e1000", "rtl8139"}
+
+// ValidateNetConfig checks a NIC model and multiqueue count against the VM's vCPUs
+func ValidateNetConfig(model string, queues, cpus int) error {
+	valid := false
+	for _, m := range NetModels {
+		if model == m {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("invalid network model: %s (use %s)", model, strings.Join(NetModels, ", "))
+	}
+
+	if queues < 0 {
+		return fmt.Errorf("invalid network queue count: %d", queues)
+	}
+	if queues > 1 && model != "virtio" {
+		return fmt.Errorf("multiqueue requires the virtio network model (got %s)", model)
+	}
+	if queues > cpus {
+		return fmt.Errorf("network queue count (%d) cannot exceed the number of CPUs (%d)", queues, cpus)
+	}
+
+	return nil
+}
+
+// diskTargetPrefix returns the guest device name prefix for a disk bus
+func diskTargetPrefix(bus string) string {
+	switch bus {
+	case "sata", "scsi", "usb":
+		return "sd"
+	case "ide":
+		return "hd"
+	default:
+		return "vd"
+	}
+}
+
+// DiskTarget returns the guest device name (vda, sdb, ...) for the disk at index on bus
+func DiskTarget(bus string, index int) string {
+	return fmt.Sprintf("%s%c", diskTargetPrefix(bus), 'a'+index)
+}
+
+// targetAllocator hands out unused guest device names per bus prefix
+type targetAllocator map[string]bool
+
+// next returns the first free target for bus, preferring the given index
+func (a targetAllocator) next(bus string, preferred int) string {
+	for i := preferred; i < 26; i++ {
+		if target := DiskTarget(bus, i); !a[target] {
+			a[target] = true
+			return target
+		}
+	}
+	for i := 0; i < preferred; i++ {
+		if target := DiskTarget(bus, i); !a[target] {
+			a[target] = true
+			return target
+		}
+	}
+	return DiskTarget(bus, preferred)
+}
+
+// newCDROM returns a read-only CD-ROM drive holding an ISO image
+func newCDROM(isoPath, bus, target string) DomainDisk {
+	cdrom := DomainDisk{
+		Type:     "file",
+		Device:   "cdrom",
+		ReadOnly: &struct{}{},
+	}
+	cdrom.Driver.Name = "qemu"
+	cdrom.Driver.Type = "raw"
+	cdrom.Source.File = isoPath
+	cdrom.Target.Dev = target
+	cdrom.Target.Bus = bus
+	return cdrom
+}
+
+// newQcow2Disk builds a qcow2 file-backed <disk> element
+func newQcow2Disk(path, bus, target string) DomainDisk {
+	disk := DomainDisk{
+		Type:   "file",
+		Device: "disk",
+	}
+	disk.Driver.Name = "qemu"
+	disk.Driver.Type = "qcow2"
+	disk.Source.File = path
+	disk.Target.Bus = bus
+	disk.Target.Dev = target
+	return disk
+}
+
+// newDisk builds the <disk> element for a VMDisk
+func newDisk(d VMDisk, bus, target string) DomainDisk {
+	switch d.Type {
+	case DiskTypeBlock:
+		disk := newQcow2Disk("", bus, target)
+		disk.Type = "block"
+		disk.Driver.Type = "raw"
+		disk.Source.Dev = d.Path
+		disk.applyOptions(d.Options)
+		return disk
+	case DiskTypeRawFile:
+		disk := newQcow2Disk(d.Path, bus, target)
+		disk.Driver.Type = "raw"
+		disk.applyOptions(d.Options)
+		return disk
+	default:
+		disk := newQcow2Disk(d.Path, bus, target)
+		disk.applyOptions(d.Options)
+		return disk
+	}
 }
 
 // generateDomainXML generates libvirt domain XML for a VM
@@ -252,66 +708,135 @@ func (c *Client) generateDomainXML(name string, config VMConfig) (string, error)
 	domain := VMDomain{}
 	domain.Type = "qemu"
 	domain.Name = name
+	domain.Title = config.Title
 
 	// Set memory (convert MB to KB for libvirt)
 	domain.Memory.Unit = "KiB"
 	domain.Memory.Value = config.Memory * 1024
+	domain.MemoryBacking = newMemoryBacking(config)
 
 	// Set CPU
 	domain.VCPU.Placement = "static"
 	domain.VCPU.Value = config.CPUs
+	domain.CPUTune = newCPUTune(config.CPUPins)
+	domain.CPU = newDomainCPU(config)
+	domain.NUMATune = newNUMATune(config.NUMA)
+	if HostCPUModel(config.CPUModel) || len(config.HostDevs) > 0 {
+		// The NAS's CPU and PCI devices can only be passed through to a KVM guest
+		domain.Type = "kvm"
+	}
 
 	// Set OS type
 	domain.OS.Type.Arch = "x86_64"
-	domain.OS.Type.Machine = "pc-i440fx-2.3"
+	domain.OS.Type.Machine = config.Machine
+	if domain.OS.Type.Machine == "" {
+		domain.OS.Type.Machine = LegacyMachine
+		if config.OVMF != nil && config.OVMF.Secure {
+			// SMM is only emulated on the q35 chipset
+			domain.OS.Type.Machine = MachineQ35
+		}
+	}
 	domain.OS.Type.Value = "hvm"
-	domain.OS.Boot.Dev = "hd"
+	domain.OS.Loader, domain.OS.NVRAM, domain.Features = newFirmware(config.OVMF)
+	domain.OS.Boot = newBootOrder(config.Boot)
+	if config.LocalTime {
+		domain.Clock = &DomainClock{Offset: "localtime"}
+	}
 
 	// Set emulator path for QNAP
 	domain.Devices.Emulator = fmt.Sprintf("%s/usr/bin/qemu-system-x86_64", c.qvsPath)
 
-	// Add disk
+	// Add disks, numbering targets per bus (vda, vdb, sda, ...)
+	targets := targetAllocator{}
 	if config.DiskPath != "" {
-		disk := struct {
-			Type   string `xml:"type,attr"`
-			Device string `xml:"device,attr"`
-			Driver struct {
-				Name string `xml:"name,attr"`
-				Type string `xml:"type,attr"`
-			} `xml:"driver"`
-			Source struct {
-				File string `xml:"file,attr,omitempty"`
-			} `xml:"source"`
-			Target struct {
-				Dev string `xml:"dev,attr"`
-				Bus string `xml:"bus,attr"`
-			} `xml:"target"`
-		}{
-			Type:   "file",
-			Device: "disk",
-		}
-		disk.Driver.Name = "qemu"
-		disk.Driver.Type = "qcow2"
-		disk.Source.File = config.DiskPath
-		disk.Target.Dev = "vda"
-		disk.Target.Bus = "virtio"
+		bus := config.DiskBus
+		if bus == "" {
+			bus = "virtio"
+		}
+		disk := newQcow2Disk(config.DiskPath, bus, targets.next(bus, 0))
+		disk.applyOptions(config.DiskOptions)
 		domain.Devices.Disk = append(domain.Devices.Disk, disk)
 	}
+	for _, extra := range config.Disks {
+		bus := extra.Bus
+		if bus == "" {
+			bus = "virtio"
+		}
+		domain.Devices.Disk = append(domain.Devices.Disk, newDisk(extra, bus, targets.next(bus, 0)))
+	}
 
-	// Add network interface (use user network to avoid bridge issues)
-	netInterface := struct {
-		Type   string `xml:"type,attr"`
-		Source struct {
-			Bridge string `xml:"bridge,attr,omitempty"`
-		} `xml:"source"`
-		Model struct {
-			Type string `xml:"type,attr"`
-		} `xml:"model"`
-	}{
-		Type: "user", // Use user networking instead of bridge for QNAP compatibility
+	// Add installation media; q35 has no IDE controller, so its CD-ROMs go on SATA
+	cdromBus := "ide"
+	if IsQ35(domain.OS.Type.Machine) {
+		cdromBus = "sata"
+	}
+	if config.ISOPath != "" {
+		domain.Devices.Disk = append(domain.Devices.Disk, newCDROM(config.ISOPath, cdromBus, targets.next(cdromBus, 2)))
+
+		// Fall through to the installer while the disk is still empty
+		domain.OS.Boot = append(domain.OS.Boot, DomainBoot{Dev: "cdrom"})
+	}
+	if config.SeedISO != "" {
+		domain.Devices.Disk = append(domain.Devices.Disk, newCDROM(config.SeedISO, cdromBus, targets.next(cdromBus, 2)))
+	}
+	if config.DriverISO != "" {
+		domain.Devices.Disk = append(domain.Devices.Disk, newCDROM(config.DriverISO, cdromBus, targets.next(cdromBus, 2)))
+	}
+
+	// Add network interface; user networking unless a bridge or network was chosen
+	domain.Devices.Interface = append(domain.Devices.Interface, newInterface(config.nic(name)))
+
+	// Add graphics console
+	graphicsType := config.Graphics
+	if graphicsType == "" {
+		graphicsType = "vnc"
+	}
+	graphics := DomainGraphics{
+		Type:     graphicsType,
+		Port:     -1,
+		AutoPort: "yes",
+	}
+
+	// SPICE needs the agent channel for clipboard sharing and display resizing
+	if graphicsType == "spice" {
+		enabled := "yes"
+		if config.DisableClipboard {
+			enabled = "no"
+		}
+		graphics.Clipboard = &struct {
+			CopyPaste string `xml:"copypaste,attr"`
+		}{CopyPaste: enabled}
+		graphics.FileTransfer = &struct {
+			Enable string `xml:"enable,attr"`
+		}{Enable: enabled}
+
+		channel := DomainChannel{Type: "spicevmc"}
+		channel.Target.Type = "virtio"
+		channel.Target.Name = "com.redhat.spice.0"
+		domain.Devices.Channel = append(domain.Devices.Channel, channel)
+	}
+	domain.Devices.Graphics = append(domain.Devices.Graphics, graphics)
+	domain.Devices.Video = newVideo(config)
+	domain.Devices.Input = newInputs(config)
+	domain.Devices.Sound = newSound(config)
+
+	// Add QEMU guest agent channel
+	agentChannel := DomainChannel{Type: "unix"}
+	agentChannel.Target.Type = "virtio"
+	agentChannel.Target.Name = GuestAgentChannel
+	domain.Devices.Channel = append(domain.Devices.Channel, agentChannel)
+	domain.Devices.TPM = newTPM(config)
+	domain.Devices.RNG = newRNG(config)
+	domain.Devices.Watchdog = config.Watchdog
+	domain.Devices.Hostdev = newHostdevs(config.HostDevs)
+	domain.OnCrash = config.OnCrash
+	domain.OnReboot = config.OnReboot
+
+	// Add QEMU command-line passthrough
+	if len(config.QemuArgs) > 0 {
+		domain.QemuNS = QemuNamespace
+		domain.QemuCommandline = newQemuCommandline(config.QemuArgs)
 	}
-	netInterface.Model.Type = "virtio"
-	domain.Devices.Interface = append(domain.Devices.Interface, netInterface)
 
 	xmlData, err := xml.MarshalIndent(domain, "", "  ")
 	if err != nil {
@@ -342,32 +867,54 @@ func (c *Client) parseVMList(output string) ([]VMInfo, error) {
 
 	// Parse each VM entry
 	for _, line := range dataLines {
-		if line == "" {
+		vm, ok := parseVMListLine(line)
+		if !ok {
 			continue
 		}
+		vms = append(vms, vm)
+	}
 
-		// Parse line format: "  1    vm-name    running"
-		fields := strings.Fields(line)
-		if len(fields) < 3 {
-			continue
-		}
+	return vms, nil
+}
+
+// domainStates are the states 'virsh list' prints, longest first so "in shutdown" wins over "shutdown"
+var domainStates = []string{"in shutdown", "pmsuspended", "shut off", "running", "crashed", "blocked", "paused", "idle", "dying"}
+
+// parseVMListLine parses one 'virsh list --all' row such as "  1    vm-name    running".
+// The state is matched from the end so names containing spaces stay intact.
+func parseVMListLine(line string) (VMInfo, bool) {
+	id, rest, found := strings.Cut(strings.TrimSpace(line), " ")
+	if !found {
+		return VMInfo{}, false
+	}
+	rest = strings.TrimSpace(rest)
 
-		vm := VMInfo{
-			Name:  fields[1],
-			State: strings.Join(fields[2:], " "),
+	var vm VMInfo
+	for _, state := range domainStates {
+		if name, ok := strings.CutSuffix(rest, " "+state); ok {
+			vm.Name, vm.State = strings.TrimSpace(name), state
+			break
 		}
+	}
 
-		// Parse ID
-		if fields[0] != "-" {
-			if id, err := strconv.Atoi(fields[0]); err == nil {
-				vm.ID = id
-			}
+	// Unknown state: fall back to the last column
+	if vm.Name == "" {
+		fields := strings.Fields(rest)
+		if len(fields) < 2 {
+			return VMInfo{}, false
 		}
+		vm.Name = strings.TrimSpace(strings.TrimSuffix(rest, fields[len(fields)-1]))
+		vm.State = fields[len(fields)-1]
+	}
 
-		vms = append(vms, vm)
+	// Parse ID
+	if id != "-" {
+		if n, err := strconv.Atoi(id); err == nil {
+			vm.ID = n
+		}
 	}
 
-	return vms, nil
+	return vm, true
 }
 
 // GetVMDetails gets detailed information about a VM including UUID and resource usage
@@ -379,15 +926,16 @@ func (c *Client) GetVMDetails(name string) (*VMInfo, error) {
 	}
 
 	// Get UUID
-	uuidOutput, err := c.execVirsh(fmt.Sprintf("domuuid %s", name))
+	uuidOutput, err := c.execVirsh(fmt.Sprintf("domuuid %s", domainArg(name)))
 	if err == nil {
 		vm.UUID = strings.TrimSpace(uuidOutput)
 	}
 
 	// Get detailed info
-	domInfoOutput, err := c.execVirsh(fmt.Sprintf("dominfo %s", name))
+	domInfoOutput, err := c.execVirsh(fmt.Sprintf("dominfo %s", domainArg(name)))
 	if err == nil {
 		vm.Memory, vm.CPUs = c.parseDomainInfo(domInfoOutput)
+		vm.Transient, vm.ManagedSave = parseDomainFlags(domInfoOutput)
 	}
 
 	return vm, nil
@@ -419,6 +967,25 @@ func (c *Client) parseDomainInfo(output string) (memory, cpus int) {
 	return memory, cpus
 }
 
+// parseDomainFlags extracts the persistence and managed-save flags from 'virsh dominfo' output
+func parseDomainFlags(output string) (transient, managedSave bool) {
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		value = strings.ToLower(strings.TrimSpace(value))
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "persistent":
+			transient = value == "no"
+		case "managed save":
+			managedSave = value == "yes"
+		}
+	}
+	return transient, managedSave
+}
+
 // IsVirshAvailable checks if virsh is available and working
 func (c *Client) IsVirshAvailable() bool {
 	err := c.setupEnvironment()
@@ -437,9 +1004,9 @@ type SnapshotInfo struct {
 
 // CreateSnapshot creates a snapshot of a VM
 func (c *Client) CreateSnapshot(vmName, snapshotName, description string) error {
-	cmd := fmt.Sprintf("snapshot-create-as %s %s", vmName, snapshotName)
+	cmd := fmt.Sprintf("snapshot-create-as %s --name %s", domainArg(vmName), ssh.Quote(snapshotName))
 	if description != "" {
-		cmd += fmt.Sprintf(" --description \"%s\"", description)
+		cmd += fmt.Sprintf(" --description %s", ssh.Quote(description))
 	}
 
 	output, err := c.execVirsh(cmd)
@@ -452,7 +1019,7 @@ func (c *Client) CreateSnapshot(vmName, snapshotName, description string) error
 
 // ListSnapshots lists all snapshots for a VM
 func (c *Client) ListSnapshots(vmName string) ([]SnapshotInfo, error) {
-	output, err := c.execVirsh(fmt.Sprintf("snapshot-list %s", vmName))
+	output, err := c.execVirsh(fmt.Sprintf("snapshot-list %s", domainArg(vmName)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to list snapshots for VM '%s': %w", vmName, err)
 	}
@@ -462,7 +1029,7 @@ func (c *Client) ListSnapshots(vmName string) ([]SnapshotInfo, error) {
 
 // RestoreSnapshot restores a VM to a specific snapshot
 func (c *Client) RestoreSnapshot(vmName, snapshotName string) error {
-	cmd := fmt.Sprintf("snapshot-revert %s %s", vmName, snapshotName)
+	cmd := fmt.Sprintf("snapshot-revert %s --snapshotname %s", domainArg(vmName), ssh.Quote(snapshotName))
 	output, err := c.execVirsh(cmd)
 	if err != nil {
 		return fmt.Errorf("failed to restore VM '%s' to snapshot '%s': %w\nOutput: %s", vmName, snapshotName, err, output)
@@ -473,7 +1040,34 @@ func (c *Client) RestoreSnapshot(vmName, snapshotName string) error {
 
 // DeleteSnapshot deletes a specific snapshot
 func (c *Client) DeleteSnapshot(vmName, snapshotName string) error {
-	cmd := fmt.Sprintf("snapshot-delete %s %s", vmName, snapshotName)
+	cmd := fmt.Sprintf("snapshot-delete %s --snapshotname %s", domainArg(vmName), ssh.Quote(snapshotName))
+	output, err := c.execVirsh(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to delete snapshot '%s' for VM '%s': %w\nOutput: %s", snapshotName, vmName, err, output)
+	}
+
+	return nil
+}
+
+// SnapshotDeleteOptions controls how snapshot children are handled on delete
+type SnapshotDeleteOptions struct {
+	Children     bool // Delete the snapshot and all of its descendants
+	ChildrenOnly bool // Delete only the descendants, keeping the snapshot
+}
+
+// DeleteSnapshotTree deletes a snapshot and/or its descendants
+func (c *Client) DeleteSnapshotTree(vmName, snapshotName string, opts SnapshotDeleteOptions) error {
+	if opts.Children && opts.ChildrenOnly {
+		return fmt.Errorf("--children and --children-only cannot be used together")
+	}
+
+	cmd := fmt.Sprintf("snapshot-delete %s --snapshotname %s", domainArg(vmName), ssh.Quote(snapshotName))
+	if opts.Children {
+		cmd += " --children"
+	} else if opts.ChildrenOnly {
+		cmd += " --children-only"
+	}
+
 	output, err := c.execVirsh(cmd)
 	if err != nil {
 		return fmt.Errorf("failed to delete snapshot '%s' for VM '%s': %w\nOutput: %s", snapshotName, vmName, err, output)
@@ -482,9 +1076,25 @@ func (c *Client) DeleteSnapshot(vmName, snapshotName string) error {
 	return nil
 }
 
+// SnapshotDescendants returns the names of all snapshots descending from snapshotName
+func (c *Client) SnapshotDescendants(vmName, snapshotName string) ([]string, error) {
+	output, err := c.execVirsh(fmt.Sprintf("snapshot-list %s --from %s --descendants --name", domainArg(vmName), ssh.Quote(snapshotName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list children of snapshot '%s': %w\nOutput: %s", snapshotName, err, output)
+	}
+
+	var names []string
+	for _, line := range strings.Split(output, "\n") {
+		if name := strings.TrimSpace(line); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
 // GetCurrentSnapshot gets the current snapshot name for a VM
 func (c *Client) GetCurrentSnapshot(vmName string) (string, error) {
-	output, err := c.execVirsh(fmt.Sprintf("snapshot-current %s --name", vmName))
+	output, err := c.execVirsh(fmt.Sprintf("snapshot-current %s --name", domainArg(vmName)))
 	if err != nil {
 		// No current snapshot is not an error
 		if strings.Contains(strings.ToLower(output), "no current snapshot") {
@@ -549,7 +1159,7 @@ func (c *Client) GetSnapshotInfo(vmName, snapshotName string) (*SnapshotInfo, er
 	for _, snapshot := range snapshots {
 		if snapshot.Name == snapshotName {
 			// Get additional details
-			descOutput, err := c.execVirsh(fmt.Sprintf("snapshot-info %s %s", vmName, snapshotName))
+			descOutput, err := c.execVirsh(fmt.Sprintf("snapshot-info %s --snapshotname %s", domainArg(vmName), ssh.Quote(snapshotName)))
 			if err == nil {
 				snapshot.Description = c.parseSnapshotDescription(descOutput)
 			}
@@ -610,25 +1220,25 @@ func (c *Client) GetVMStats(vmName string) (*VMStats, error) {
 	stats := &VMStats{}
 
 	// Get CPU stats
-	cpuOutput, err := c.execVirsh(fmt.Sprintf("domstats %s --cpu", vmName))
+	cpuOutput, err := c.execVirsh(fmt.Sprintf("domstats --cpu %s", ssh.Quote(vmName)))
 	if err == nil {
 		stats.CPUTime = c.parseCPUStats(cpuOutput)
 	}
 
 	// Get memory stats
-	memOutput, err := c.execVirsh(fmt.Sprintf("domstats %s --balloon", vmName))
+	memOutput, err := c.execVirsh(fmt.Sprintf("domstats --balloon %s", ssh.Quote(vmName)))
 	if err == nil {
 		c.parseMemoryStats(memOutput, stats)
 	}
 
 	// Get block I/O stats
-	blockOutput, err := c.execVirsh(fmt.Sprintf("domstats %s --block", vmName))
+	blockOutput, err := c.execVirsh(fmt.Sprintf("domstats --block %s", ssh.Quote(vmName)))
 	if err == nil {
 		c.parseBlockStats(blockOutput, stats)
 	}
 
 	// Get network stats
-	netOutput, err := c.execVirsh(fmt.Sprintf("domstats %s --interface", vmName))
+	netOutput, err := c.execVirsh(fmt.Sprintf("domstats --interface %s", ssh.Quote(vmName)))
 	if err == nil {
 		c.parseNetworkStats(netOutput, stats)
 	}
@@ -636,6 +1246,34 @@ func (c *Client) GetVMStats(vmName string) (*VMStats, error) {
 	return stats, nil
 }
 
+// ActiveCPUTimes returns the cumulative CPU time in nanoseconds of each running VM
+func (c *Client) ActiveCPUTimes() (map[string]int64, error) {
+	output, err := c.execVirsh("domstats --cpu-total --list-active")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VM CPU statistics: %w\nOutput: %s", err, output)
+	}
+	return parseCPUTimes(output), nil
+}
+
+// parseCPUTimes parses multi-domain 'domstats --cpu-total' output
+func parseCPUTimes(output string) map[string]int64 {
+	times := make(map[string]int64)
+	domain := ""
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if name, ok := strings.CutPrefix(line, "Domain: "); ok {
+			domain = strings.Trim(name, "'")
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "cpu.time="); ok && domain != "" {
+			if cpuTime, err := strconv.ParseInt(value, 10, 64); err == nil {
+				times[domain] = cpuTime
+			}
+		}
+	}
+	return times
+}
+
 // parseCPUStats extracts CPU statistics from domstats output
 func (c *Client) parseCPUStats(output string) int64 {
 	re := regexp.MustCompile(`cpu\.time=(\d+)`)
@@ -757,7 +1395,8 @@ func (c *Client) CloneVM(sourceVMName, targetVMName string, linkedClone bool) er
 	}
 
 	// Build clone command
-	cmd := fmt.Sprintf("virt-clone --original %s --name %s --auto-clone", sourceVMName, targetVMName)
+	cmd := fmt.Sprintf("virt-clone --original=%s --name=%s --auto-clone", ssh.Quote(sourceVMName), ssh.Quote(targetVMName))
+	cmd += c.cloneMACArgs(sourceVMName, targetVMName)
 
 	// For linked clones, we'd use snapshots, but virt-clone doesn't support this directly
 	// So we'll implement this through snapshot-based approach if requested
@@ -765,8 +1404,8 @@ func (c *Client) CloneVM(sourceVMName, targetVMName string, linkedClone bool) er
 		return c.createLinkedClone(sourceVMName, targetVMName)
 	}
 
-	// Execute clone command (this may require virt-clone to be available)
-	output, err := c.execVirsh(cmd)
+	// Execute clone command (virt-clone is a separate program, not a virsh command)
+	output, err := c.execVirshScript(cmd)
 	if err != nil {
 		// Fallback to manual cloning if virt-clone is not available
 		return c.manualCloneVM(sourceVMName, targetVMName)
@@ -776,9 +1415,96 @@ func (c *Client) CloneVM(sourceVMName, targetVMName string, linkedClone bool) er
 		return fmt.Errorf("clone operation failed: %s", output)
 	}
 
+	if err := c.registerWithQVS(targetVMName); err != nil {
+		return &QVSRegistrationError{VM: targetVMName, Err: err}
+	}
 	return nil
 }
 
+// CloneVMToPaths performs a full clone, writing the cloned disks to diskPaths
+// (one path per source disk, in order) instead of next to the source disks
+func (c *Client) CloneVMToPaths(sourceVMName, targetVMName string, diskPaths []string) error {
+	return c.cloneVMWithFiles(sourceVMName, targetVMName, diskPaths, false)
+}
+
+// CloneVMWithDisks clones a VM's definition onto disk images that already hold a copy of
+// the source disks (one path per source disk, in order), such as storage-level clones
+func (c *Client) CloneVMWithDisks(sourceVMName, targetVMName string, diskPaths []string) error {
+	return c.cloneVMWithFiles(sourceVMName, targetVMName, diskPaths, true)
+}
+
+// cloneVMWithFiles defines a copy of a VM whose disks are at diskPaths, copying the source
+// disks there first unless preserveData is set. virt-clone is not part of QVS, so the copy
+// is defined from the source's XML like a restore.
+func (c *Client) cloneVMWithFiles(sourceVMName, targetVMName string, diskPaths []string, preserveData bool) error {
+	// Check if target VM already exists
+	if _, err := c.GetVM(targetVMName); err == nil {
+		return fmt.Errorf("target VM '%s' already exists", targetVMName)
+	}
+
+	sourceXML, err := c.dumpInactiveXML(sourceVMName)
+	if err != nil {
+		return err
+	}
+	disks, err := c.ListDisks(sourceVMName)
+	if err != nil {
+		return err
+	}
+	if len(disks) != len(diskPaths) {
+		return fmt.Errorf("source VM '%s' has %d disk(s) but %d target path(s) were given", sourceVMName, len(disks), len(diskPaths))
+	}
+
+	moved := make(map[string]string, len(disks))
+	for i, disk := range disks {
+		moved[disk.Source.File] = diskPaths[i]
+	}
+	cloneXML, err := cloneDomainXML(sourceXML, targetVMName, moved)
+	if err != nil {
+		return fmt.Errorf("failed to prepare clone of VM '%s': %w", sourceVMName, err)
+	}
+
+	// Copies of a running VM's disks would be inconsistent
+	var copied []string
+	if !preserveData {
+		if source, err := c.GetVM(sourceVMName); err == nil && strings.Contains(source.State, "running") {
+			return fmt.Errorf("source VM '%s' is running; shut it down before cloning its disks to another pool", sourceVMName)
+		}
+		for i, disk := range disks {
+			if output, err := c.sshClient.ExecuteContext(c.commandContext(), copyDiskCommand(disk.Source.File, diskPaths[i])); err != nil {
+				c.removeFiles(append(copied, diskPaths[i]))
+				return fmt.Errorf("failed to copy %s to %s: %w\nOutput: %s", disk.Source.File, diskPaths[i], err, output)
+			}
+			copied = append(copied, diskPaths[i])
+		}
+	}
+
+	if err := c.defineXML(targetVMName, cloneXML); err != nil {
+		c.removeFiles(copied)
+		return err
+	}
+
+	if err := c.registerWithQVS(targetVMName); err != nil {
+		return &QVSRegistrationError{VM: targetVMName, Err: err}
+	}
+	return nil
+}
+
+// copyDiskCommand returns the shell command copying a disk image to dstPath, creating
+// its directory and keeping the image sparse where cp supports it
+func copyDiskCommand(srcPath, dstPath string) string {
+	src, dst := ssh.Quote(srcPath), ssh.Quote(dstPath)
+	return fmt.Sprintf("mkdir -p %s && { cp --sparse=always %s %s 2>/dev/null || cp %s %s; }", ssh.Quote(path.Dir(dstPath)), src, dst, src, dst)
+}
+
+// removeFiles deletes files left behind by a failed operation
+func (c *Client) removeFiles(paths []string) {
+	for _, file := range paths {
+		if _, err := c.sshClient.ExecuteContext(c.commandContext(), fmt.Sprintf("rm -f %s", ssh.Quote(file))); err != nil {
+			// Best effort; the error that caused the cleanup is what gets reported
+		}
+	}
+}
+
 // createLinkedClone creates a linked clone using snapshots
 func (c *Client) createLinkedClone(sourceVMName, targetVMName string) error {
 	// Get source VM configuration
@@ -824,9 +1550,11 @@ func (c *Client) manualCloneVM(sourceVMName, targetVMName string) error {
 		DiskPath: "",    // Will be determined by storage manager
 	}
 
-	// Create the cloned VM
-	if err := c.CreateVM(targetVMName, vmConfig); err != nil {
-		return fmt.Errorf("failed to create cloned VM: %w", err)
+	// Create the cloned VM; a registration failure is reported once the source is back
+	created := c.CreateVM(targetVMName, vmConfig)
+	var regErr *QVSRegistrationError
+	if created != nil && !errors.As(created, &regErr) {
+		return fmt.Errorf("failed to create cloned VM: %w", created)
 	}
 
 	// Restart source VM if it was running
@@ -836,7 +1564,7 @@ func (c *Client) manualCloneVM(sourceVMName, targetVMName string) error {
 		}
 	}
 
-	return nil
+	return created
 }
 
 // ConsoleInfo represents console connection information
@@ -844,6 +1572,8 @@ type ConsoleInfo struct {
 	VNCDisplay string `json:"vnc_display"`
 	VNCPort    int    `json:"vnc_port"`
 	VNCHost    string `json:"vnc_host"`
+	SPICEPort  int    `json:"spice_port,omitempty"`
+	SPICEHost  string `json:"spice_host,omitempty"`
 	SerialPort string `json:"serial_port"`
 	Protocol   string `json:"protocol"`
 }
@@ -852,27 +1582,15 @@ type ConsoleInfo struct {
 func (c *Client) GetConsoleInfo(vmName string) (*ConsoleInfo, error) {
 	info := &ConsoleInfo{}
 
-	// Get VNC display information using domdisplay (modern approach)
-	domDisplayOutput, err := c.execVirsh(fmt.Sprintf("domdisplay %s", vmName))
+	// Get display information using domdisplay (modern approach)
+	domDisplayOutput, err := c.execVirsh(fmt.Sprintf("domdisplay %s", domainArg(vmName)))
 	if err == nil && strings.TrimSpace(domDisplayOutput) != "" {
-		uri := strings.TrimSpace(domDisplayOutput)
-		if strings.HasPrefix(uri, "vnc://") {
-			info.Protocol = "VNC"
-			// Parse VNC URI: vnc://127.0.0.1:0 or vnc://host:port
-			parts := strings.Split(strings.TrimPrefix(uri, "vnc://"), ":")
-			if len(parts) >= 2 {
-				info.VNCHost = parts[0]
-				if port, err := strconv.Atoi(parts[1]); err == nil {
-					info.VNCPort = 5900 + port // VNC display 0 = port 5900
-					info.VNCDisplay = fmt.Sprintf(":%d", port)
-				}
-			}
-		}
+		parseDisplayURI(strings.TrimSpace(domDisplayOutput), info)
 	}
 
 	// Fallback to vncdisplay if domdisplay doesn't work
 	if info.Protocol == "" {
-		vncOutput, err := c.execVirsh(fmt.Sprintf("vncdisplay %s", vmName))
+		vncOutput, err := c.execVirsh(fmt.Sprintf("vncdisplay %s", domainArg(vmName)))
 		if err == nil && strings.TrimSpace(vncOutput) != "" {
 			display := strings.TrimSpace(vncOutput)
 			info.VNCDisplay = display
@@ -889,7 +1607,7 @@ func (c *Client) GetConsoleInfo(vmName string) (*ConsoleInfo, error) {
 	}
 
 	// Check for serial console availability
-	serialOutput, err := c.execVirsh(fmt.Sprintf("dominfo %s", vmName))
+	serialOutput, err := c.execVirsh(fmt.Sprintf("dominfo %s", domainArg(vmName)))
 	if err == nil && strings.Contains(strings.ToLower(serialOutput), "console") {
 		info.SerialPort = "available"
 	}
@@ -901,9 +1619,49 @@ func (c *Client) GetConsoleInfo(vmName string) (*ConsoleInfo, error) {
 	return info, nil
 }
 
+// parseDisplayURI fills console info from a domdisplay URI such as
+// vnc://127.0.0.1:0 (display number) or spice://127.0.0.1:5901 (port)
+func parseDisplayURI(uri string, info *ConsoleInfo) {
+	switch {
+	case strings.HasPrefix(uri, "vnc://"):
+		info.Protocol = "VNC"
+		parts := strings.Split(strings.TrimPrefix(uri, "vnc://"), ":")
+		if len(parts) >= 2 {
+			info.VNCHost = parts[0]
+			if port, err := strconv.Atoi(parts[1]); err == nil {
+				info.VNCPort = 5900 + port // VNC display 0 = port 5900
+				info.VNCDisplay = fmt.Sprintf(":%d", port)
+			}
+		}
+	case strings.HasPrefix(uri, "spice://"):
+		info.Protocol = "SPICE"
+		parts := strings.Split(strings.TrimPrefix(uri, "spice://"), ":")
+		if len(parts) >= 2 {
+			info.SPICEHost = parts[0]
+			if port, err := strconv.Atoi(strings.SplitN(parts[1], "?", 2)[0]); err == nil {
+				info.SPICEPort = port
+			}
+		}
+	}
+}
+
+// GetSPICEConnectionString returns a spice:// URI suitable for remote-viewer
+func (c *Client) GetSPICEConnectionString(vmName string) (string, error) {
+	consoleInfo, err := c.GetConsoleInfo(vmName)
+	if err != nil {
+		return "", err
+	}
+
+	if consoleInfo.Protocol != "SPICE" || consoleInfo.SPICEPort == 0 {
+		return "", fmt.Errorf("SPICE not available for VM '%s'", vmName)
+	}
+
+	return fmt.Sprintf("spice://%s:%d", consoleInfo.SPICEHost, consoleInfo.SPICEPort), nil
+}
+
 // ConnectSerial connects to the VM's serial console
 func (c *Client) ConnectSerial(vmName string, force bool) error {
-	cmd := fmt.Sprintf("console %s", vmName)
+	cmd := fmt.Sprintf("console %s", domainArg(vmName))
 	if force {
 		cmd += " --force"
 	}
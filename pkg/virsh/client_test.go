@@ -153,3 +153,354 @@ func TestVMConfig(t *testing.T) {
 		t.Errorf("Expected ISO path /path/to/installer.iso, got %s", config.ISOPath)
 	}
 }
+
+func TestGenerateDomainXMLSeedISO(t *testing.T) {
+	client := &Client{}
+
+	config := VMConfig{
+		Memory:   2048,
+		CPUs:     2,
+		DiskPath: "/share/CACHEDEV1_DATA/.qnap-vm/disks/test-vm.qcow2",
+		ISOPath:  "/share/ISOs/ubuntu.iso",
+		SeedISO:  "/share/CACHEDEV1_DATA/.qnap-vm/disks/test-vm-seed.iso",
+	}
+
+	xml, err := client.generateDomainXML("test-vm", config)
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+
+	expectedElements := []string{
+		"<source file=\"/share/ISOs/ubuntu.iso\"></source>\n      <target dev=\"hdc\" bus=\"ide\"></target>",
+		"<source file=\"/share/CACHEDEV1_DATA/.qnap-vm/disks/test-vm-seed.iso\"></source>\n      <target dev=\"hdd\" bus=\"ide\"></target>",
+	}
+	for _, expected := range expectedElements {
+		if !strings.Contains(xml, expected) {
+			t.Errorf("Generated XML missing expected element: %s\nGenerated XML:\n%s", expected, xml)
+		}
+	}
+	if strings.Count(xml, "<boot dev=\"cdrom\">") != 1 {
+		t.Errorf("only the installer CD-ROM should be in the boot order:\n%s", xml)
+	}
+}
+
+func TestGenerateDomainXMLSpice(t *testing.T) {
+	client := &Client{}
+
+	config := VMConfig{
+		Memory:   2048,
+		CPUs:     2,
+		DiskPath: "/share/CACHEDEV1_DATA/.qnap-vm/disks/test-vm.qcow2",
+		Graphics: "spice",
+	}
+
+	xml, err := client.generateDomainXML("test-vm", config)
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+
+	expectedElements := []string{
+		"<graphics type=\"spice\" port=\"-1\" autoport=\"yes\">",
+		"<channel type=\"spicevmc\">",
+		"<target type=\"virtio\" name=\"com.redhat.spice.0\">",
+		"<clipboard copypaste=\"yes\">",
+		"<filetransfer enable=\"yes\">",
+		"<target type=\"virtio\" name=\"org.qemu.guest_agent.0\">",
+	}
+
+	for _, expected := range expectedElements {
+		if !strings.Contains(xml, expected) {
+			t.Errorf("Generated XML missing expected element: %s\nGenerated XML:\n%s", expected, xml)
+		}
+	}
+}
+
+func TestGenerateDomainXMLMultipleDisks(t *testing.T) {
+	client := &Client{}
+
+	config := VMConfig{
+		Memory:   2048,
+		CPUs:     2,
+		DiskPath: "/share/CACHEDEV1_DATA/.qnap-vm/disks/test-vm.qcow2",
+		Disks: []VMDisk{
+			{Path: "/share/CACHEDEV1_DATA/.qnap-vm/disks/test-vm-disk1.qcow2"},
+			{Path: "/share/CACHEDEV2_DATA/.qnap-vm/disks/test-vm-disk2.qcow2", Bus: "sata"},
+			{Path: "/share/CACHEDEV2_DATA/.qnap-vm/disks/test-vm-disk3.qcow2", Bus: "ide"},
+			{Path: "/share/CACHEDEV2_DATA/.qnap-vm/disks/test-vm-disk4.qcow2", Bus: "ide"},
+			{Path: "/share/CACHEDEV2_DATA/.qnap-vm/disks/test-vm-disk5.qcow2", Bus: "ide"},
+		},
+		ISOPath: "/share/Public/install.iso",
+	}
+
+	xml, err := client.generateDomainXML("test-vm", config)
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+
+	expectedElements := []string{
+		"<source file=\"/share/CACHEDEV1_DATA/.qnap-vm/disks/test-vm.qcow2\"></source>\n      <target dev=\"vda\" bus=\"virtio\">",
+		"<source file=\"/share/CACHEDEV1_DATA/.qnap-vm/disks/test-vm-disk1.qcow2\"></source>\n      <target dev=\"vdb\" bus=\"virtio\">",
+		"<source file=\"/share/CACHEDEV2_DATA/.qnap-vm/disks/test-vm-disk2.qcow2\"></source>\n      <target dev=\"sda\" bus=\"sata\">",
+		"<target dev=\"hda\" bus=\"ide\">",
+		"<target dev=\"hdb\" bus=\"ide\">",
+		"<target dev=\"hdc\" bus=\"ide\">",
+		// The CD-ROM moves off hdc when data disks already use it
+		"<source file=\"/share/Public/install.iso\"></source>\n      <target dev=\"hdd\" bus=\"ide\">",
+	}
+
+	for _, expected := range expectedElements {
+		if !strings.Contains(xml, expected) {
+			t.Errorf("Generated XML missing expected element: %s\nGenerated XML:\n%s", expected, xml)
+		}
+	}
+}
+
+func TestGenerateDomainXMLLUNDisks(t *testing.T) {
+	client := &Client{}
+
+	// A LUN boot disk is passed in Disks with no DiskPath
+	config := VMConfig{
+		Memory: 2048,
+		CPUs:   2,
+		Disks: []VMDisk{
+			{Path: "/dev/mapper/cachedev1_lun0", Type: DiskTypeBlock},
+			{Path: "/share/CACHEDEV1_DATA/.@iscsi.img/iSCSI-LUN_1.img", Type: DiskTypeRawFile, Bus: "scsi"},
+		},
+	}
+
+	xml, err := client.generateDomainXML("lun-vm", config)
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+
+	expectedElements := []string{
+		"<disk type=\"block\" device=\"disk\">\n      <driver name=\"qemu\" type=\"raw\"></driver>\n      <source dev=\"/dev/mapper/cachedev1_lun0\"></source>\n      <target dev=\"vda\" bus=\"virtio\">",
+		"<disk type=\"file\" device=\"disk\">\n      <driver name=\"qemu\" type=\"raw\"></driver>\n      <source file=\"/share/CACHEDEV1_DATA/.@iscsi.img/iSCSI-LUN_1.img\"></source>\n      <target dev=\"sda\" bus=\"scsi\">",
+	}
+
+	for _, expected := range expectedElements {
+		if !strings.Contains(xml, expected) {
+			t.Errorf("Generated XML missing expected element: %s\nGenerated XML:\n%s", expected, xml)
+		}
+	}
+}
+
+func TestGenerateDomainXMLNetModel(t *testing.T) {
+	client := &Client{}
+
+	xml, err := client.generateDomainXML("test-vm", VMConfig{Memory: 2048, CPUs: 4, NetQueues: 4})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	if !strings.Contains(xml, "<model type=\"virtio\"></model>\n      <driver queues=\"4\"></driver>") {
+		t.Errorf("Expected virtio NIC with 4 queues\nGenerated XML:\n%s", xml)
+	}
+
+	xml, err = client.generateDomainXML("test-vm", VMConfig{Memory: 2048, CPUs: 2, NetModel: "e1000"})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	if !strings.Contains(xml, "<model type=\"e1000\"></model>") || strings.Contains(xml, "queues=") {
+		t.Errorf("Expected e1000 NIC without multiqueue\nGenerated XML:\n%s", xml)
+	}
+}
+
+func TestValidateNetConfig(t *testing.T) {
+	tests := []struct {
+		model   string
+		queues  int
+		cpus    int
+		wantErr bool
+	}{
+		{"virtio", 0, 2, false},
+		{"virtio", 4, 4, false},
+		{"e1000", 0, 2, false},
+		{"rtl8139", 1, 2, false},
+		{"e1000", 2, 2, true},
+		{"virtio", 8, 4, true},
+		{"vmxnet3", 0, 2, true},
+		{"virtio", -1, 2, true},
+	}
+
+	for _, tt := range tests {
+		err := ValidateNetConfig(tt.model, tt.queues, tt.cpus)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateNetConfig(%s, %d, %d) error = %v, wantErr %v", tt.model, tt.queues, tt.cpus, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseDisplayURI(t *testing.T) {
+	tests := []struct {
+		uri      string
+		protocol string
+		host     string
+		port     int
+	}{
+		{"vnc://127.0.0.1:0", "VNC", "127.0.0.1", 5900},
+		{"vnc://0.0.0.0:3", "VNC", "0.0.0.0", 5903},
+		{"spice://127.0.0.1:5901", "SPICE", "127.0.0.1", 5901},
+		{"spice://localhost:5930?tls-port=5931", "SPICE", "localhost", 5930},
+		{"rdp://host:3389", "", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			info := &ConsoleInfo{}
+			parseDisplayURI(tt.uri, info)
+
+			host, port := info.VNCHost, info.VNCPort
+			if info.Protocol == "SPICE" {
+				host, port = info.SPICEHost, info.SPICEPort
+			}
+
+			if info.Protocol != tt.protocol || host != tt.host || port != tt.port {
+				t.Errorf("parseDisplayURI(%s) = %s %s:%d, expected %s %s:%d", tt.uri, info.Protocol, host, port, tt.protocol, tt.host, tt.port)
+			}
+		})
+	}
+}
+
+func TestParseDomainFlags(t *testing.T) {
+	persistentOutput := `Id:             3
+Name:           web
+State:          running
+Persistent:     yes
+Autostart:      disable
+Managed save:   no
+`
+	transient, managedSave := parseDomainFlags(persistentOutput)
+	if transient || managedSave {
+		t.Errorf("Expected persistent VM without managed save, got transient=%v managedSave=%v", transient, managedSave)
+	}
+
+	transientOutput := `Id:             7
+Name:           scratch
+State:          running
+Persistent:     no
+Managed save:   no
+`
+	if transient, _ := parseDomainFlags(transientOutput); !transient {
+		t.Error("Expected transient VM")
+	}
+
+	savedOutput := `Id:             -
+Name:           db
+State:          shut off
+Persistent:     yes
+Managed save:   yes
+`
+	if _, managedSave := parseDomainFlags(savedOutput); !managedSave {
+		t.Error("Expected managed save image")
+	}
+}
+
+func TestUndefineCommand(t *testing.T) {
+	if cmd := undefineCommand("web", true); cmd != "undefine --domain 'web' --managed-save --snapshots-metadata --nvram" {
+		t.Errorf("Unexpected undefine command: %s", cmd)
+	}
+	if cmd := undefineCommand("web", false); cmd != "undefine --domain 'web' --managed-save --snapshots-metadata" {
+		t.Errorf("Unexpected undefine command without NVRAM: %s", cmd)
+	}
+}
+
+func TestStateLabel(t *testing.T) {
+	vm := VMInfo{State: "running", Transient: true}
+	if label := vm.StateLabel(); label != "running (transient)" {
+		t.Errorf("Unexpected label: %s", label)
+	}
+
+	vm = VMInfo{State: "shut off", ManagedSave: true}
+	if label := vm.StateLabel(); label != "shut off (saved)" {
+		t.Errorf("Unexpected label: %s", label)
+	}
+}
+
+func TestDeleteSnapshotTreeConflictingOptions(t *testing.T) {
+	client := &Client{}
+
+	err := client.DeleteSnapshotTree("web", "before-upgrade", SnapshotDeleteOptions{Children: true, ChildrenOnly: true})
+	if err == nil {
+		t.Error("Expected error when combining --children and --children-only")
+	}
+}
+
+func TestParseVMListUnusualNames(t *testing.T) {
+	sampleOutput := ` Id   Name                 State
+-------------------------------------------
+ 3    Windows 11 Pro       running
+ -    -dash-first          shut off
+ -    Ubuntu Server 日本語   in shutdown
+ -    odd                  mystery`
+
+	client := &Client{}
+	vms, err := client.parseVMList(sampleOutput)
+	if err != nil {
+		t.Fatalf("parseVMList failed: %v", err)
+	}
+
+	expected := []VMInfo{
+		{ID: 3, Name: "Windows 11 Pro", State: "running"},
+		{Name: "-dash-first", State: "shut off"},
+		{Name: "Ubuntu Server 日本語", State: "in shutdown"},
+		{Name: "odd", State: "mystery"},
+	}
+	if len(vms) != len(expected) {
+		t.Fatalf("Expected %d VMs, got %d: %+v", len(expected), len(vms), vms)
+	}
+	for i, want := range expected {
+		if vms[i].ID != want.ID || vms[i].Name != want.Name || vms[i].State != want.State {
+			t.Errorf("VM %d = %+v, expected %+v", i, vms[i], want)
+		}
+	}
+}
+
+func TestDomainArg(t *testing.T) {
+	if got := domainArg("Windows 11 Pro"); got != "--domain 'Windows 11 Pro'" {
+		t.Errorf("domainArg() = %q", got)
+	}
+	if got := domainArg("it's"); got != `--domain 'it'\''s'` {
+		t.Errorf("domainArg() = %q", got)
+	}
+	if got := fileSafeName("Win 11/日本"); got != "Win_11___" {
+		t.Errorf("fileSafeName() = %q", got)
+	}
+}
+
+func TestParseCPUTimes(t *testing.T) {
+	output := `Domain: 'web server'
+  cpu.time=123456789
+  cpu.user=100000000
+  cpu.system=20000000
+
+Domain: 'db'
+  cpu.time=42
+`
+	times := parseCPUTimes(output)
+	if len(times) != 2 {
+		t.Fatalf("parseCPUTimes() returned %d entries, want 2: %v", len(times), times)
+	}
+	if times["web server"] != 123456789 {
+		t.Errorf("web server cpu time = %d, want 123456789", times["web server"])
+	}
+	if times["db"] != 42 {
+		t.Errorf("db cpu time = %d, want 42", times["db"])
+	}
+}
+
+func TestCopyDiskCommand(t *testing.T) {
+	cmd := copyDiskCommand("/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2", "/share/CACHEDEV2_DATA/.qnap-vm/web clone/web clone.qcow2")
+
+	// The command runs in the plain shell; virsh has no command to copy disks
+	if strings.HasPrefix(cmd, "virsh") {
+		t.Errorf("copyDiskCommand() = %q, should not run through virsh", cmd)
+	}
+	for _, want := range []string{
+		"mkdir -p '/share/CACHEDEV2_DATA/.qnap-vm/web clone'",
+		"cp --sparse=always '/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2' '/share/CACHEDEV2_DATA/.qnap-vm/web clone/web clone.qcow2'",
+	} {
+		if !strings.Contains(cmd, want) {
+			t.Errorf("copyDiskCommand() = %q, missing %q", cmd, want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package virsh
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// DomainTPM represents a <tpm> device
+type DomainTPM struct {
+	Model   string `xml:"model,attr"`
+	Backend struct {
+		Type    string `xml:"type,attr"`
+		Version string `xml:"version,attr"`
+	} `xml:"backend"`
+}
+
+// newTPM returns an emulated TPM 2.0 backed by swtpm. UEFI guests such as Windows 11
+// get the CRB interface; BIOS guests the TIS one SeaBIOS understands.
+func newTPM(config VMConfig) *DomainTPM {
+	if !config.TPM {
+		return nil
+	}
+	tpm := &DomainTPM{Model: "tpm-tis"}
+	if config.OVMF != nil {
+		tpm.Model = "tpm-crb"
+	}
+	tpm.Backend.Type = "emulator"
+	tpm.Backend.Version = "2.0"
+	return tpm
+}
+
+// FindSWTPM returns the path of the swtpm TPM emulator libvirt runs for emulated TPMs,
+// looking in the QVS installation first
+func (c *Client) FindSWTPM() (string, error) {
+	candidates := []string{
+		ssh.Quote(c.qvsPath + "/usr/bin/swtpm"),
+		ssh.Quote(c.qvsPath + "/usr/sbin/swtpm"),
+		"/usr/bin/swtpm",
+		"/usr/local/bin/swtpm",
+	}
+	output, err := c.sshClient.ExecuteContext(c.commandContext(), fmt.Sprintf("for f in %s; do [ -x \"$f\" ] && echo \"$f\" && break; done; true", strings.Join(candidates, " ")))
+	if err != nil {
+		return "", fmt.Errorf("failed to look for swtpm: %w\nOutput: %s", err, output)
+	}
+	if path := strings.TrimSpace(output); path != "" {
+		return path, nil
+	}
+	return "", fmt.Errorf("this Virtualization Station installation does not provide swtpm, which emulated TPMs need; update Virtualization Station")
+}
+
+// DomainRNG represents an <rng> device
+type DomainRNG struct {
+	Model   string `xml:"model,attr"`
+	Backend struct {
+		Model string `xml:"model,attr"`
+		Path  string `xml:",chardata"`
+	} `xml:"backend"`
+}
+
+// newRNG returns a virtio-rng device fed from the NAS's /dev/urandom, so fresh guests
+// don't stall at boot waiting for entropy (e.g. generating SSH host keys)
+func newRNG(config VMConfig) *DomainRNG {
+	if config.DisableRNG {
+		return nil
+	}
+	rng := &DomainRNG{Model: "virtio"}
+	rng.Backend.Model = "random"
+	rng.Backend.Path = "/dev/urandom"
+	return rng
+}
+
+// Watchdog models and actions accepted by ParseWatchdog
+var (
+	WatchdogModels  = []string{"i6300esb", "ib700", "itco"}
+	WatchdogActions = []string{"reset", "shutdown", "poweroff", "pause", "none", "dump", "inject-nmi"}
+)
+
+// Policies accepted for <on_crash> and <on_reboot>
+var (
+	CrashPolicies  = []string{"destroy", "restart", "preserve", "rename-restart", "coredump-destroy", "coredump-restart"}
+	RebootPolicies = []string{"restart", "destroy"}
+)
+
+// DomainWatchdog represents a <watchdog> device
+type DomainWatchdog struct {
+	Model  string `xml:"model,attr" json:"model"`
+	Action string `xml:"action,attr,omitempty" json:"action"`
+}
+
+// ParseWatchdog parses a watchdog specification of the form "i6300esb[,action=reset]"
+func ParseWatchdog(spec string) (*DomainWatchdog, error) {
+	parts := strings.Split(spec, ",")
+	watchdog := &DomainWatchdog{Model: parts[0], Action: "reset"}
+	if !slices.Contains(WatchdogModels, watchdog.Model) {
+		return nil, fmt.Errorf("invalid watchdog model %q (use %s)", watchdog.Model, strings.Join(WatchdogModels, ", "))
+	}
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || key != "action" {
+			return nil, fmt.Errorf("invalid watchdog option %q (expected action=ACTION)", part)
+		}
+		if !slices.Contains(WatchdogActions, value) {
+			return nil, fmt.Errorf("invalid watchdog action %q (use %s)", value, strings.Join(WatchdogActions, ", "))
+		}
+		watchdog.Action = value
+	}
+	return watchdog, nil
+}
+
+// ValidateLifecyclePolicies checks on_crash and on_reboot policies; "" keeps libvirt's default
+func ValidateLifecyclePolicies(onCrash, onReboot string) error {
+	if onCrash != "" && !slices.Contains(CrashPolicies, onCrash) {
+		return fmt.Errorf("invalid crash policy %q (use %s)", onCrash, strings.Join(CrashPolicies, ", "))
+	}
+	if onReboot != "" && !slices.Contains(RebootPolicies, onReboot) {
+		return fmt.Errorf("invalid reboot policy %q (use %s)", onReboot, strings.Join(RebootPolicies, ", "))
+	}
+	return nil
+}
+
+// RecoveryPolicy is how a VM recovers when its guest hangs, crashes or reboots
+type RecoveryPolicy struct {
+	Watchdog *DomainWatchdog `json:"watchdog,omitempty"`
+	OnCrash  string          `json:"on_crash"`
+	OnReboot string          `json:"on_reboot"`
+}
+
+// RecoveryPolicy returns the domain's watchdog and lifecycle policies, filling in
+// libvirt's defaults
+func (d *VMDomain) RecoveryPolicy() *RecoveryPolicy {
+	policy := &RecoveryPolicy{Watchdog: d.Devices.Watchdog, OnCrash: d.OnCrash, OnReboot: d.OnReboot}
+	if policy.OnCrash == "" {
+		policy.OnCrash = "destroy"
+	}
+	if policy.OnReboot == "" {
+		policy.OnReboot = "restart"
+	}
+	if policy.Watchdog != nil && policy.Watchdog.Action == "" {
+		policy.Watchdog.Action = "reset"
+	}
+	return policy
+}
+
+// pciAddressPattern matches a full PCI address: domain:bus:slot.function
+var pciAddressPattern = regexp.MustCompile(`^([0-9a-f]{4}):([0-9a-f]{2}):([0-1][0-9a-f])\.([0-7])$`)
+
+// DomainHostdev represents a PCI <hostdev> device passed through from the host
+type DomainHostdev struct {
+	Mode    string `xml:"mode,attr"`
+	Type    string `xml:"type,attr"`
+	Managed string `xml:"managed,attr,omitempty"`
+	Source  struct {
+		Address *DomainPCIAddress `xml:"address"`
+	} `xml:"source"`
+}
+
+// DomainPCIAddress represents a PCI <address> element
+type DomainPCIAddress struct {
+	Domain   string `xml:"domain,attr"`
+	Bus      string `xml:"bus,attr"`
+	Slot     string `xml:"slot,attr"`
+	Function string `xml:"function,attr"`
+}
+
+// ParseHostdev parses a host device specification such as "pci=0000:01:00.0" (or a
+// bare address, with or without the 0000 domain) and returns the full PCI address
+func ParseHostdev(spec string) (string, error) {
+	address := strings.ToLower(strings.TrimSpace(spec))
+	if kind, value, ok := strings.Cut(address, "="); ok {
+		if kind != "pci" {
+			return "", fmt.Errorf("invalid host device %q (only pci=ADDRESS is supported)", spec)
+		}
+		address = value
+	}
+	if strings.Count(address, ":") == 1 {
+		address = "0000:" + address
+	}
+	if !pciAddressPattern.MatchString(address) {
+		return "", fmt.Errorf("invalid PCI address in %q (expected e.g. 0000:01:00.0)", spec)
+	}
+	return address, nil
+}
+
+// newHostdevs returns <hostdev> devices for full PCI addresses. libvirt detaches managed
+// devices from their host drivers when the VM starts and gives them back when it stops.
+func newHostdevs(addresses []string) []DomainHostdev {
+	var hostdevs []DomainHostdev
+	for _, address := range addresses {
+		match := pciAddressPattern.FindStringSubmatch(address)
+		if match == nil {
+			continue
+		}
+		hostdev := DomainHostdev{Mode: "subsystem", Type: "pci", Managed: "yes"}
+		hostdev.Source.Address = &DomainPCIAddress{Domain: "0x" + match[1], Bus: "0x" + match[2], Slot: "0x" + match[3], Function: "0x" + match[4]}
+		hostdevs = append(hostdevs, hostdev)
+	}
+	return hostdevs
+}
+
+// Video and sound models accepted by ValidateDeviceModels
+var (
+	VideoModels = []string{"virtio", "qxl", "vga", "cirrus", "bochs", "none"}
+	SoundModels = []string{"ich9", "ich6", "ac97"}
+)
+
+// DomainVideo represents a <video> device
+type DomainVideo struct {
+	Model struct {
+		Type string `xml:"type,attr"`
+	} `xml:"model"`
+}
+
+// DomainInput represents an <input> device
+type DomainInput struct {
+	Type string `xml:"type,attr"`
+	Bus  string `xml:"bus,attr"`
+}
+
+// DomainSound represents a <sound> device
+type DomainSound struct {
+	Model string `xml:"model,attr"`
+}
+
+// ValidateDeviceModels checks video and sound models; "" keeps libvirt's default video
+// and adds no sound device
+func ValidateDeviceModels(video, sound string) error {
+	if video != "" && !slices.Contains(VideoModels, video) {
+		return fmt.Errorf("invalid video model %q (use %s)", video, strings.Join(VideoModels, ", "))
+	}
+	if sound != "" && !slices.Contains(SoundModels, sound) {
+		return fmt.Errorf("invalid sound model %q (use %s)", sound, strings.Join(SoundModels, ", "))
+	}
+	return nil
+}
+
+// newVideo returns the <video> device for a VM, or nil for libvirt's default
+func newVideo(config VMConfig) *DomainVideo {
+	if config.Video == "" {
+		return nil
+	}
+	video := &DomainVideo{}
+	video.Model.Type = config.Video
+	return video
+}
+
+// newInputs returns a USB tablet, which reports absolute pointer positions so the mouse
+// tracks exactly over VNC instead of drifting like the default relative PS/2 mouse
+func newInputs(config VMConfig) []DomainInput {
+	if !config.Tablet {
+		return nil
+	}
+	return []DomainInput{{Type: "tablet", Bus: "usb"}}
+}
+
+// newSound returns the <sound> device for a VM, or nil for none
+func newSound(config VMConfig) *DomainSound {
+	if config.Sound == "" {
+		return nil
+	}
+	return &DomainSound{Model: config.Sound}
+}
@@ -0,0 +1,183 @@
+package virsh
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestGenerateDomainXMLTPM(t *testing.T) {
+	client := &Client{}
+	tests := []struct {
+		config VMConfig
+		want   string
+	}{
+		{VMConfig{Memory: 4096, CPUs: 2, TPM: true}, `<tpm model="tpm-tis">`},
+		{VMConfig{Memory: 4096, CPUs: 2, TPM: true, OVMF: &OVMF{Code: "code.fd", Vars: "vars.fd"}}, `<tpm model="tpm-crb">`},
+	}
+	for _, tt := range tests {
+		xml, err := client.generateDomainXML("tpm", tt.config)
+		if err != nil {
+			t.Fatalf("generateDomainXML failed: %v", err)
+		}
+		if !strings.Contains(xml, tt.want) || !strings.Contains(xml, `<backend type="emulator" version="2.0"></backend>`) {
+			t.Errorf("Generated XML missing %s:\n%s", tt.want, xml)
+		}
+	}
+
+	xml, err := client.generateDomainXML("plain", VMConfig{Memory: 4096, CPUs: 2})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	if strings.Contains(xml, "<tpm") {
+		t.Errorf("Generated XML has a TPM without --tpm:\n%s", xml)
+	}
+}
+
+func TestGenerateDomainXMLRNG(t *testing.T) {
+	client := &Client{}
+	xml, err := client.generateDomainXML("rng", VMConfig{Memory: 2048, CPUs: 2})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	if !strings.Contains(xml, `<rng model="virtio">`) || !strings.Contains(xml, `<backend model="random">/dev/urandom</backend>`) {
+		t.Errorf("Generated XML missing virtio-rng device:\n%s", xml)
+	}
+
+	xml, err = client.generateDomainXML("norng", VMConfig{Memory: 2048, CPUs: 2, DisableRNG: true})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	if strings.Contains(xml, "<rng") {
+		t.Errorf("Generated XML has virtio-rng with DisableRNG:\n%s", xml)
+	}
+}
+
+func TestParseWatchdog(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    DomainWatchdog
+		wantErr bool
+	}{
+		{"i6300esb", DomainWatchdog{Model: "i6300esb", Action: "reset"}, false},
+		{"ib700,action=poweroff", DomainWatchdog{Model: "ib700", Action: "poweroff"}, false},
+		{"i6300esb,action=explode", DomainWatchdog{}, true},
+		{"i6300esb,reset", DomainWatchdog{}, true},
+		{"softdog", DomainWatchdog{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseWatchdog(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseWatchdog(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if err == nil && *got != tt.want {
+			t.Errorf("ParseWatchdog(%q) = %+v, want %+v", tt.spec, *got, tt.want)
+		}
+	}
+
+	if err := ValidateLifecyclePolicies("coredump-restart", "destroy"); err != nil {
+		t.Errorf("ValidateLifecyclePolicies failed: %v", err)
+	}
+	if err := ValidateLifecyclePolicies("reboot", ""); err == nil {
+		t.Error("ValidateLifecyclePolicies accepted an invalid crash policy")
+	}
+}
+
+func TestRecoveryPolicy(t *testing.T) {
+	client := &Client{}
+	domainXML, err := client.generateDomainXML("wd", VMConfig{
+		Memory:   2048,
+		CPUs:     2,
+		Watchdog: &DomainWatchdog{Model: "i6300esb", Action: "reset"},
+		OnCrash:  "restart",
+	})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	for _, want := range []string{`<watchdog model="i6300esb" action="reset"></watchdog>`, `<on_crash>restart</on_crash>`} {
+		if !strings.Contains(domainXML, want) {
+			t.Errorf("Generated XML missing %s:\n%s", want, domainXML)
+		}
+	}
+
+	var domain VMDomain
+	if err := xml.Unmarshal([]byte(domainXML), &domain); err != nil {
+		t.Fatalf("failed to parse generated XML: %v", err)
+	}
+	policy := domain.RecoveryPolicy()
+	if policy.Watchdog == nil || *policy.Watchdog != (DomainWatchdog{Model: "i6300esb", Action: "reset"}) || policy.OnCrash != "restart" || policy.OnReboot != "restart" {
+		t.Errorf("RecoveryPolicy = %+v", policy)
+	}
+}
+
+func TestParseHostdev(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"pci=0000:01:00.0", "0000:01:00.0", false},
+		{"01:00.1", "0000:01:00.1", false},
+		{"PCI=0000:0A:1F.7", "0000:0a:1f.7", false},
+		{"usb=1234:5678", "", true},
+		{"pci=01:00", "", true},
+		{"pci=0000:01:20.0", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseHostdev(tt.spec)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseHostdev(%q) = %q, %v; want %q", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestGenerateDomainXMLHostdev(t *testing.T) {
+	client := &Client{}
+	xml, err := client.generateDomainXML("gpu", VMConfig{Memory: 4096, CPUs: 2, HostDevs: []string{"0000:01:00.0"}})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	for _, want := range []string{
+		`<domain type="kvm">`,
+		`<hostdev mode="subsystem" type="pci" managed="yes">`,
+		`<address domain="0x0000" bus="0x01" slot="0x00" function="0x0"></address>`,
+	} {
+		if !strings.Contains(xml, want) {
+			t.Errorf("Generated XML missing %s:\n%s", want, xml)
+		}
+	}
+}
+
+func TestGenerateDomainXMLVideoTabletSound(t *testing.T) {
+	client := &Client{}
+	xml, err := client.generateDomainXML("desktop", VMConfig{Memory: 4096, CPUs: 2, Video: "virtio", Tablet: true, Sound: "ich9"})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	for _, want := range []string{`<model type="virtio"></model>`, `<input type="tablet" bus="usb"></input>`, `<sound model="ich9"></sound>`} {
+		if !strings.Contains(xml, want) {
+			t.Errorf("Generated XML missing %s:\n%s", want, xml)
+		}
+	}
+
+	xml, err = client.generateDomainXML("plain", VMConfig{Memory: 4096, CPUs: 2})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	for _, unwanted := range []string{"<video", "<input", "<sound"} {
+		if strings.Contains(xml, unwanted) {
+			t.Errorf("Generated XML has %s without options:\n%s", unwanted, xml)
+		}
+	}
+
+	if err := ValidateDeviceModels("qxl", "ac97"); err != nil {
+		t.Errorf("ValidateDeviceModels failed: %v", err)
+	}
+	if err := ValidateDeviceModels("matrox", ""); err == nil {
+		t.Error("ValidateDeviceModels accepted an invalid video model")
+	}
+	if err := ValidateDeviceModels("", "sb16"); err == nil {
+		t.Error("ValidateDeviceModels accepted an invalid sound model")
+	}
+}
@@ -0,0 +1,78 @@
+package virsh
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Diagnostic is one piece of libvirt or QEMU output collected for a support bundle
+type Diagnostic struct {
+	Name   string // File name within the bundle
+	Output string
+}
+
+// qemuLogScript prints the tail of the most recently written VM logs, each preceded by
+// a "=== <path>" line. %[1]s is the QVS path and %[2]d the number of lines per log.
+const qemuLogScript = `for d in /var/log/libvirt %[1]s/var/log/libvirt; do
+	[ -f "$d/libvirtd.log" ] && { echo "=== $d/libvirtd.log"; tail -n %[2]d "$d/libvirtd.log"; }
+	ls -t "$d"/qemu/*.log 2>/dev/null | head -n 20 | while read -r f; do
+		echo "=== $f"
+		tail -n %[2]d "$f"
+	done
+done
+true`
+
+// Diagnostics collects libvirt and QEMU versions, host capabilities and the VM list.
+// Commands that fail are recorded in their output rather than returned as errors.
+func (c *Client) Diagnostics() []Diagnostic {
+	commands := []struct {
+		name   string
+		script string
+	}{
+		{"libvirt-version.txt", "virsh version"},
+		{"qemu-version.txt", fmt.Sprintf("%s/usr/bin/qemu-system-x86_64 --version", c.qvsPath)},
+		{"capabilities.xml", "virsh capabilities"},
+		{"domcapabilities.xml", "virsh domcapabilities"},
+		{"vms.txt", "virsh list --all"},
+	}
+
+	diagnostics := []Diagnostic{{Name: "qvs-path.txt", Output: c.qvsPath + "\n"}}
+	for _, command := range commands {
+		output, err := c.execVirshScript(command.script)
+		if err != nil {
+			output = fmt.Sprintf("%s\nerror: %v\n", output, err)
+		}
+		diagnostics = append(diagnostics, Diagnostic{Name: command.name, Output: output})
+	}
+	return diagnostics
+}
+
+// Logs returns the last lines of the libvirt daemon log and the most recent VM logs
+func (c *Client) Logs(lines int) ([]Diagnostic, error) {
+	output, err := c.sshClient.ExecuteContext(c.commandContext(), fmt.Sprintf(qemuLogScript, c.qvsPath, lines))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read libvirt logs: %w\nOutput: %s", err, output)
+	}
+	return splitLogs(output), nil
+}
+
+// splitLogs splits qemuLogScript output into one diagnostic per log file
+func splitLogs(output string) []Diagnostic {
+	var logs []Diagnostic
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if logPath, ok := strings.CutPrefix(line, "=== "); ok {
+			logPath = strings.TrimSpace(logPath)
+			name := path.Base(logPath)
+			if path.Base(path.Dir(logPath)) == "qemu" {
+				name = "qemu/" + name
+			}
+			logs = append(logs, Diagnostic{Name: name})
+			continue
+		}
+		if len(logs) > 0 {
+			logs[len(logs)-1].Output += line
+		}
+	}
+	return logs
+}
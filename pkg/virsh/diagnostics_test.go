@@ -0,0 +1,22 @@
+package virsh
+
+import "testing"
+
+func TestSplitLogs(t *testing.T) {
+	output := "=== /var/log/libvirt/libvirtd.log\n" +
+		"2024-01-01 error: something\n" +
+		"=== /QVS/var/log/libvirt/qemu/web server.log\n" +
+		"qemu line 1\n" +
+		"qemu line 2\n"
+
+	logs := splitLogs(output)
+	if len(logs) != 2 {
+		t.Fatalf("splitLogs() returned %d logs, want 2", len(logs))
+	}
+	if logs[0].Name != "libvirtd.log" || logs[0].Output != "2024-01-01 error: something\n" {
+		t.Errorf("first log = %+v", logs[0])
+	}
+	if logs[1].Name != "qemu/web server.log" || logs[1].Output != "qemu line 1\nqemu line 2\n" {
+		t.Errorf("second log = %+v", logs[1])
+	}
+}
@@ -0,0 +1,79 @@
+package virsh
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Disk cache, I/O, discard and zero detection modes accepted by ValidateDiskOptions
+var (
+	DiskCacheModes        = []string{"none", "writeback", "writethrough"}
+	DiskIOModes           = []string{"native", "threads"}
+	DiskDiscardModes      = []string{"unmap", "ignore"}
+	DiskDetectZeroesModes = []string{"off", "on", "unmap"}
+)
+
+// DiskOptions are a disk's <driver> tuning attributes; "" keeps QEMU's default
+type DiskOptions struct {
+	Cache string // none bypasses the NAS's page cache; writeback and writethrough use it
+	IO    string // native (Linux AIO) or threads
+
+	Discard      string // unmap passes guest TRIM through to the image; ignore drops it
+	DetectZeroes string // on turns zero writes into zero clusters; unmap also frees them
+}
+
+// WithTrim returns opts with discard=unmap and detect_zeroes=unmap unless they are
+// already set, so deleted guest data and zeroed blocks free space on the NAS
+func (opts DiskOptions) WithTrim() DiskOptions {
+	if opts.Discard == "" {
+		opts.Discard = "unmap"
+	}
+	if opts.DetectZeroes == "" && opts.Discard == "unmap" {
+		opts.DetectZeroes = "unmap"
+	}
+	return opts
+}
+
+// ValidateDiskOptions checks a disk's driver options. QEMU only allows native I/O
+// with the page cache bypassed, and only unmaps detected zeroes when discard is on.
+func ValidateDiskOptions(opts DiskOptions) error {
+	if opts.Cache != "" && !slices.Contains(DiskCacheModes, opts.Cache) {
+		return fmt.Errorf("invalid disk cache mode %q (use %s)", opts.Cache, strings.Join(DiskCacheModes, ", "))
+	}
+	if opts.IO != "" && !slices.Contains(DiskIOModes, opts.IO) {
+		return fmt.Errorf("invalid disk I/O mode %q (use %s)", opts.IO, strings.Join(DiskIOModes, ", "))
+	}
+	if opts.IO == "native" && opts.Cache != "none" {
+		return fmt.Errorf("io=native requires cache=none")
+	}
+	if opts.Discard != "" && !slices.Contains(DiskDiscardModes, opts.Discard) {
+		return fmt.Errorf("invalid disk discard mode %q (use %s)", opts.Discard, strings.Join(DiskDiscardModes, ", "))
+	}
+	if opts.DetectZeroes != "" && !slices.Contains(DiskDetectZeroesModes, opts.DetectZeroes) {
+		return fmt.Errorf("invalid disk detect_zeroes mode %q (use %s)", opts.DetectZeroes, strings.Join(DiskDetectZeroesModes, ", "))
+	}
+	if opts.DetectZeroes == "unmap" && opts.Discard != "unmap" {
+		return fmt.Errorf("detect_zeroes=unmap requires discard=unmap")
+	}
+	return nil
+}
+
+// applyOptions sets a disk's driver attributes
+func (d *DomainDisk) applyOptions(opts DiskOptions) {
+	d.Driver.Cache = opts.Cache
+	d.Driver.IO = opts.IO
+	d.Driver.Discard = opts.Discard
+	d.Driver.DetectZeroes = opts.DetectZeroes
+}
+
+// DiscardDisks returns the targets of the domain's disks that pass guest TRIM through
+func (d *VMDomain) DiscardDisks() []string {
+	var targets []string
+	for _, disk := range d.Devices.Disk {
+		if disk.Device == "disk" && disk.Driver.Discard == "unmap" {
+			targets = append(targets, disk.Target.Dev)
+		}
+	}
+	return targets
+}
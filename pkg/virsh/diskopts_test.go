@@ -0,0 +1,97 @@
+package virsh
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateDiskOptions(t *testing.T) {
+	for _, tt := range []struct {
+		opts    DiskOptions
+		wantErr bool
+	}{
+		{DiskOptions{}, false},
+		{DiskOptions{Cache: "none", IO: "native"}, false},
+		{DiskOptions{Cache: "writeback", IO: "threads"}, false},
+		{DiskOptions{Cache: "writethrough"}, false},
+		{DiskOptions{IO: "native"}, true},
+		{DiskOptions{Cache: "writeback", IO: "native"}, true},
+		{DiskOptions{Cache: "unsafe"}, true},
+		{DiskOptions{IO: "io_uring"}, true},
+		{DiskOptions{Discard: "unmap", DetectZeroes: "unmap"}, false},
+		{DiskOptions{Discard: "ignore", DetectZeroes: "on"}, false},
+		{DiskOptions{DetectZeroes: "unmap"}, true},
+		{DiskOptions{Discard: "trim"}, true},
+		{DiskOptions{DetectZeroes: "yes"}, true},
+	} {
+		if err := ValidateDiskOptions(tt.opts); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateDiskOptions(%+v) error = %v, want error %v", tt.opts, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDiskOptionsWithTrim(t *testing.T) {
+	for _, tt := range []struct {
+		opts, want DiskOptions
+	}{
+		{DiskOptions{}, DiskOptions{Discard: "unmap", DetectZeroes: "unmap"}},
+		{DiskOptions{Cache: "none"}, DiskOptions{Cache: "none", Discard: "unmap", DetectZeroes: "unmap"}},
+		{DiskOptions{Discard: "ignore"}, DiskOptions{Discard: "ignore"}},
+		{DiskOptions{DetectZeroes: "on"}, DiskOptions{Discard: "unmap", DetectZeroes: "on"}},
+	} {
+		if got := tt.opts.WithTrim(); got != tt.want {
+			t.Errorf("%+v.WithTrim() = %+v, want %+v", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateDomainXMLDiskOptions(t *testing.T) {
+	client := &Client{}
+	config := VMConfig{
+		Memory:      2048,
+		CPUs:        2,
+		DiskPath:    "/share/CACHEDEV1_DATA/.qnap-vm/disks/test-vm.qcow2",
+		DiskOptions: DiskOptions{Cache: "none", IO: "native"},
+		Disks: []VMDisk{
+			{Path: "/share/CACHEDEV2_DATA/.qnap-vm/disks/test-vm-disk1.qcow2", Options: DiskOptions{Cache: "writeback"}},
+			{Path: "/share/CACHEDEV2_DATA/.qnap-vm/disks/test-vm-disk2.qcow2"},
+			{Path: "/share/CACHEDEV3_DATA/.qnap-vm/disks/test-vm-disk3.qcow2", Options: DiskOptions{Discard: "unmap", DetectZeroes: "unmap"}},
+		},
+	}
+
+	xml, err := client.generateDomainXML("test-vm", config)
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	for _, expected := range []string{
+		`<driver name="qemu" type="qcow2" cache="none" io="native"></driver>`,
+		`<driver name="qemu" type="qcow2" cache="writeback"></driver>`,
+		`<driver name="qemu" type="qcow2"></driver>`,
+		`<driver name="qemu" type="qcow2" discard="unmap" detect_zeroes="unmap"></driver>`,
+	} {
+		if !strings.Contains(xml, expected) {
+			t.Errorf("Generated XML missing expected element: %s\nGenerated XML:\n%s", expected, xml)
+		}
+	}
+}
+
+func TestDiscardDisks(t *testing.T) {
+	domain := &VMDomain{}
+	for _, d := range []struct{ device, target, discard string }{
+		{"disk", "vda", "unmap"},
+		{"disk", "vdb", ""},
+		{"disk", "vdc", "ignore"},
+		{"cdrom", "sda", "unmap"},
+		{"disk", "vdd", "unmap"},
+	} {
+		disk := DomainDisk{Device: d.device}
+		disk.Target.Dev = d.target
+		disk.Driver.Discard = d.discard
+		domain.Devices.Disk = append(domain.Devices.Disk, disk)
+	}
+
+	if got := domain.DiscardDisks(); !slices.Equal(got, []string{"vda", "vdd"}) {
+		t.Errorf("DiscardDisks() = %v, want [vda vdd]", got)
+	}
+}
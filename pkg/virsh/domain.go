@@ -0,0 +1,209 @@
+package virsh
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/messages"
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// dumpInactiveXML returns the persistent domain XML for a VM
+func (c *Client) dumpInactiveXML(vmName string) (string, error) {
+	output, err := c.execVirsh(fmt.Sprintf("dumpxml %s --inactive", domainArg(vmName)))
+	if err != nil && domainMissing(output) {
+		return "", messages.Errorf(messages.VMNotFound, vmName)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read configuration for VM '%s': %w\nOutput: %s", vmName, err, output)
+	}
+	return output, nil
+}
+
+// domainMissing reports whether virsh output says the domain does not exist, as opposed
+// to virsh or the connection failing
+func domainMissing(output string) bool {
+	return strings.Contains(output, "failed to get domain") || strings.Contains(output, "Domain not found")
+}
+
+// GetDomain returns the parsed persistent definition of a VM
+func (c *Client) GetDomain(vmName string) (*VMDomain, error) {
+	domainXML, err := c.dumpInactiveXML(vmName)
+	if err != nil {
+		return nil, err
+	}
+
+	var domain VMDomain
+	if err := xml.Unmarshal([]byte(domainXML), &domain); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration for VM '%s': %w", vmName, err)
+	}
+
+	return &domain, nil
+}
+
+// ListDisks returns the disk devices (excluding CD-ROMs) of a VM
+func (c *Client) ListDisks(vmName string) ([]DomainDisk, error) {
+	domain, err := c.GetDomain(vmName)
+	if err != nil {
+		return nil, err
+	}
+
+	var disks []DomainDisk
+	for _, disk := range domain.Devices.Disk {
+		if disk.Device == "disk" {
+			disks = append(disks, disk)
+		}
+	}
+	return disks, nil
+}
+
+// NextDiskTarget returns the first unused guest device name on bus for a VM
+func (c *Client) NextDiskTarget(vmName, bus string) (string, error) {
+	domain, err := c.GetDomain(vmName)
+	if err != nil {
+		return "", err
+	}
+	return nextDiskTarget(domain, bus), nil
+}
+
+// nextDiskTarget returns the first guest device name on bus not used by domain
+func nextDiskTarget(domain *VMDomain, bus string) string {
+	targets := targetAllocator{}
+	for _, disk := range domain.Devices.Disk {
+		targets[disk.Target.Dev] = true
+	}
+	return targets.next(bus, 0)
+}
+
+// SetDiskSource points a disk in a VM's persistent definition at a new image path
+func (c *Client) SetDiskSource(vmName, oldPath, newPath string) error {
+	domainXML, err := c.dumpInactiveXML(vmName)
+	if err != nil {
+		return err
+	}
+
+	updated, err := replaceDiskSource(domainXML, oldPath, newPath)
+	if err != nil {
+		return fmt.Errorf("failed to update VM '%s': %w", vmName, err)
+	}
+
+	return c.defineXML(vmName, updated)
+}
+
+// BlockCopy copies a running VM's disk to destPath and pivots the VM onto the copy
+func (c *Client) BlockCopy(vmName, target, destPath string) error {
+	cmd := fmt.Sprintf("blockcopy %s %s --dest %s --format qcow2 --wait --pivot --transient-job", domainArg(vmName), ssh.Quote(target), ssh.Quote(destPath))
+	output, err := c.execVirsh(cmd)
+	if err != nil {
+		return fmt.Errorf("block copy of '%s' on VM '%s' failed (stop the VM to move it offline): %w\nOutput: %s", target, vmName, err, output)
+	}
+	return nil
+}
+
+// ExportDomainXML returns a VM's persistent definition with disk sources rewritten by
+// diskPaths (old path -> new path), for defining a copy of the VM on another host
+func (c *Client) ExportDomainXML(vmName string, diskPaths map[string]string) (string, error) {
+	domainXML, err := c.dumpInactiveXML(vmName)
+	if err != nil {
+		return "", err
+	}
+
+	for oldPath, newPath := range diskPaths {
+		if domainXML, err = replaceDiskSource(domainXML, oldPath, newPath); err != nil {
+			return "", err
+		}
+	}
+	return domainXML, nil
+}
+
+// DefineDomainXML defines a VM from XML exported from another host, pointing it at
+// this host's QEMU emulator
+func (c *Client) DefineDomainXML(name, domainXML string) error {
+	emulator := fmt.Sprintf("<emulator>%s/usr/bin/qemu-system-x86_64</emulator>", c.qvsPath)
+	domainXML = emulatorPattern.ReplaceAllLiteralString(domainXML, emulator)
+	return c.defineXML(name, domainXML)
+}
+
+// emulatorPattern matches the emulator element of domain XML
+var emulatorPattern = regexp.MustCompile(`<emulator>[^<]*</emulator>`)
+
+// Patterns for the parts of domain XML that identify a VM
+var (
+	namePattern  = regexp.MustCompile(`<name>[^<]*</name>`)
+	uuidPattern  = regexp.MustCompile(`\n?[ \t]*<uuid>[^<]*</uuid>`)
+	macPattern   = regexp.MustCompile(`\n?[ \t]*<mac address=['"][^'"]*['"]\s*/>`)
+	nvramPattern = regexp.MustCompile(`\n?[ \t]*<nvram(\s[^>]*)?(/>|>[^<]*</nvram>)`)
+)
+
+// RestoreDomainXML prepares domain XML from a backup to be defined as name, with disks
+// moved per diskPaths (old path -> new path). With fresh, the UUID, MAC addresses and
+// UEFI variable store are dropped so libvirt generates new ones, letting the copy run
+// alongside the original VM.
+func RestoreDomainXML(domainXML, name string, diskPaths map[string]string, fresh bool) (string, error) {
+	// The domain's own name comes first; later name elements belong to devices
+	loc := namePattern.FindStringIndex(domainXML)
+	if loc == nil {
+		return "", fmt.Errorf("domain definition has no name")
+	}
+	domainXML = domainXML[:loc[0]] + "<name>" + xmlEscape(name) + "</name>" + domainXML[loc[1]:]
+
+	var err error
+	for oldPath, newPath := range diskPaths {
+		if domainXML, err = replaceDiskSource(domainXML, oldPath, newPath); err != nil {
+			return "", err
+		}
+	}
+
+	if fresh {
+		domainXML = uuidPattern.ReplaceAllLiteralString(domainXML, "")
+		domainXML = macPattern.ReplaceAllLiteralString(domainXML, "")
+		domainXML = nvramPattern.ReplaceAllLiteralString(domainXML, "")
+	}
+	return domainXML, nil
+}
+
+// macAddressPattern matches the address of a mac element, up to its closing quote
+var macAddressPattern = regexp.MustCompile(`<mac address=['"][^'"]*['"]`)
+
+// cloneDomainXML prepares a VM's domain XML to be defined as its clone name, with disks
+// moved per diskPaths (old path -> new path). The UUID and UEFI variable store are
+// dropped so libvirt generates new ones, and each interface gets the stable MAC for name.
+func cloneDomainXML(domainXML, name string, diskPaths map[string]string) (string, error) {
+	domainXML, err := RestoreDomainXML(domainXML, name, diskPaths, false)
+	if err != nil {
+		return "", err
+	}
+	domainXML = uuidPattern.ReplaceAllLiteralString(domainXML, "")
+	domainXML = nvramPattern.ReplaceAllLiteralString(domainXML, "")
+
+	nic := 0
+	domainXML = macAddressPattern.ReplaceAllStringFunc(domainXML, func(string) string {
+		mac := fmt.Sprintf("<mac address='%s'", StableMAC(name, nic))
+		nic++
+		return mac
+	})
+	return domainXML, nil
+}
+
+// replaceDiskSource swaps the source file of a disk in domain XML
+func replaceDiskSource(domainXML, oldPath, newPath string) (string, error) {
+	for _, quote := range []string{"'", "\""} {
+		oldAttr := "file=" + quote + xmlEscape(oldPath) + quote
+		if strings.Contains(domainXML, oldAttr) {
+			newAttr := "file=" + quote + xmlEscape(newPath) + quote
+			return strings.Replace(domainXML, oldAttr, newAttr, 1), nil
+		}
+	}
+	return "", fmt.Errorf("disk source %s not found in domain definition", oldPath)
+}
+
+// xmlEscape escapes a string for use in an XML attribute
+func xmlEscape(s string) string {
+	var b strings.Builder
+	if err := xml.EscapeText(&b, []byte(s)); err != nil {
+		return s
+	}
+	return b.String()
+}
@@ -0,0 +1,179 @@
+package virsh
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestNextDiskTarget(t *testing.T) {
+	sampleXML := `<domain type='kvm'>
+  <name>web</name>
+  <devices>
+    <disk type='file' device='disk'>
+      <driver name='qemu' type='qcow2'/>
+      <source file='/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2'/>
+      <target dev='vda' bus='virtio'/>
+    </disk>
+    <disk type='file' device='disk'>
+      <driver name='qemu' type='qcow2'/>
+      <source file='/share/CACHEDEV1_DATA/.qnap-vm/disks/web-disk1.qcow2'/>
+      <target dev='vdb' bus='virtio'/>
+    </disk>
+    <disk type='file' device='cdrom'>
+      <driver name='qemu' type='raw'/>
+      <source file='/share/Public/install.iso'/>
+      <target dev='hdc' bus='ide'/>
+      <readonly/>
+    </disk>
+  </devices>
+</domain>`
+
+	var domain VMDomain
+	if err := xml.Unmarshal([]byte(sampleXML), &domain); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	tests := map[string]string{
+		"virtio": "vdc",
+		"sata":   "sda",
+		"ide":    "hda",
+	}
+	for bus, expected := range tests {
+		if target := nextDiskTarget(&domain, bus); target != expected {
+			t.Errorf("nextDiskTarget(%s) = %s, expected %s", bus, target, expected)
+		}
+	}
+}
+
+func TestReplaceDiskSource(t *testing.T) {
+	domainXML := `<domain type='kvm'>
+  <devices>
+    <disk type='file' device='disk'>
+      <source file='/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2'/>
+      <target dev='vda' bus='virtio'/>
+    </disk>
+  </devices>
+</domain>`
+
+	updated, err := replaceDiskSource(domainXML, "/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2", "/share/CACHEDEV2_DATA/.qnap-vm/disks/web.qcow2")
+	if err != nil {
+		t.Fatalf("replaceDiskSource failed: %v", err)
+	}
+	if !strings.Contains(updated, "<source file='/share/CACHEDEV2_DATA/.qnap-vm/disks/web.qcow2'/>") {
+		t.Errorf("Expected updated source path, got:\n%s", updated)
+	}
+
+	if _, err := replaceDiskSource(domainXML, "/missing.qcow2", "/new.qcow2"); err == nil {
+		t.Error("Expected error for unknown disk source")
+	}
+}
+
+func TestRestoreDomainXML(t *testing.T) {
+	backupXML := `<domain type='kvm'>
+  <name>web</name>
+  <uuid>4dea22b3-1d52-d8f3-2516-782e98ab3fa0</uuid>
+  <os>
+    <loader readonly='yes' type='pflash'>/usr/share/OVMF/OVMF_CODE.fd</loader>
+    <nvram>/var/lib/libvirt/qemu/nvram/web_VARS.fd</nvram>
+  </os>
+  <devices>
+    <disk type='file' device='disk'>
+      <source file='/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2'/>
+      <target dev='vda' bus='virtio'/>
+    </disk>
+    <interface type='bridge'>
+      <mac address='52:54:00:12:34:56'/>
+      <source bridge='br0'/>
+    </interface>
+    <channel type='unix'>
+      <target type='virtio' name='org.qemu.guest_agent.0'/>
+    </channel>
+  </devices>
+</domain>`
+	diskPaths := map[string]string{"/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2": "/share/CACHEDEV2_DATA/.qnap-vm/disks/web-test.qcow2"}
+
+	got, err := RestoreDomainXML(backupXML, "web-test", diskPaths, true)
+	if err != nil {
+		t.Fatalf("RestoreDomainXML() error: %v", err)
+	}
+	for _, want := range []string{"<name>web-test</name>", "file='/share/CACHEDEV2_DATA/.qnap-vm/disks/web-test.qcow2'", "<source bridge='br0'/>", "org.qemu.guest_agent.0", "<loader"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("restored XML is missing %q:\n%s", want, got)
+		}
+	}
+	for _, gone := range []string{"<uuid>", "<mac ", "<nvram>", "<name>web</name>"} {
+		if strings.Contains(got, gone) {
+			t.Errorf("restored XML still contains %q:\n%s", gone, got)
+		}
+	}
+
+	// In place of the original VM, its identity is kept
+	same, err := RestoreDomainXML(backupXML, "web", diskPaths, false)
+	if err != nil {
+		t.Fatalf("RestoreDomainXML() error: %v", err)
+	}
+	if !strings.Contains(same, "<uuid>4dea22b3") || !strings.Contains(same, "52:54:00:12:34:56") {
+		t.Errorf("RestoreDomainXML() without fresh lost the VM's identity:\n%s", same)
+	}
+
+	if _, err := RestoreDomainXML(backupXML, "web", map[string]string{"/missing.qcow2": "/x.qcow2"}, false); err == nil {
+		t.Error("RestoreDomainXML() should fail for a disk not in the definition")
+	}
+}
+
+func TestCloneDomainXML(t *testing.T) {
+	sourceXML := `<domain type='kvm'>
+  <name>web</name>
+  <uuid>4dea22b3-1d52-d8f3-2516-782e98ab3fa0</uuid>
+  <os>
+    <loader readonly='yes' type='pflash'>/usr/share/OVMF/OVMF_CODE.fd</loader>
+    <nvram>/var/lib/libvirt/qemu/nvram/web_VARS.fd</nvram>
+  </os>
+  <devices>
+    <disk type='file' device='disk'>
+      <source file='/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2'/>
+      <target dev='vda' bus='virtio'/>
+    </disk>
+    <interface type='bridge'>
+      <mac address='52:54:00:12:34:56'/>
+      <source bridge='br0'/>
+    </interface>
+    <interface type='bridge'>
+      <mac address="52:54:00:12:34:57"/>
+      <source bridge='br1'/>
+    </interface>
+  </devices>
+</domain>`
+	diskPaths := map[string]string{"/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2": "/share/CACHEDEV2_DATA/.qnap-vm/disks/web-clone.qcow2"}
+
+	got, err := cloneDomainXML(sourceXML, "web-clone", diskPaths)
+	if err != nil {
+		t.Fatalf("cloneDomainXML() error: %v", err)
+	}
+	for _, want := range []string{
+		"<name>web-clone</name>",
+		"file='/share/CACHEDEV2_DATA/.qnap-vm/disks/web-clone.qcow2'",
+		"<mac address='" + StableMAC("web-clone", 0) + "'/>",
+		"<mac address='" + StableMAC("web-clone", 1) + "'/>",
+		"<loader",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("cloned XML is missing %q:\n%s", want, got)
+		}
+	}
+	for _, gone := range []string{"<uuid>", "<nvram>", "52:54:00:12:34:5", "<name>web</name>"} {
+		if strings.Contains(got, gone) {
+			t.Errorf("cloned XML still contains %q:\n%s", gone, got)
+		}
+	}
+}
+
+func TestDomainMissing(t *testing.T) {
+	if !domainMissing("error: failed to get domain 'web'\nerror: Domain not found: no domain with matching name 'web'") {
+		t.Error("domainMissing() should recognise virsh's missing domain error")
+	}
+	if domainMissing("error: failed to connect to the hypervisor") {
+		t.Error("domainMissing() should not treat a connection failure as a missing domain")
+	}
+}
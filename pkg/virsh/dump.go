@@ -0,0 +1,66 @@
+package virsh
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// DumpFormats lists the core formats accepted for memory-only dumps
+var DumpFormats = []string{"elf", "kdump-zlib", "kdump-lzo", "kdump-snappy", "win-dmp"}
+
+// DumpOptions controls how a guest core dump is taken
+type DumpOptions struct {
+	MemoryOnly bool   // Dump guest memory only (usable by crash/WinDbg)
+	Format     string // Core format for memory-only dumps; defaults to elf
+	Live       bool   // Keep the guest running while dumping
+}
+
+// dumpCommand builds the virsh dump command line
+func dumpCommand(vmName, path string, opts DumpOptions) (string, error) {
+	args := []string{"dump", domainArg(vmName), "--file", ssh.Quote(path)}
+
+	if opts.Live {
+		args = append(args, "--live")
+	}
+
+	if opts.MemoryOnly {
+		args = append(args, "--memory-only")
+
+		format := opts.Format
+		if format == "" {
+			format = "elf"
+		}
+		valid := false
+		for _, f := range DumpFormats {
+			if format == f {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return "", fmt.Errorf("invalid dump format: %s (use %s)", format, strings.Join(DumpFormats, ", "))
+		}
+		args = append(args, "--format", format)
+	} else if opts.Format != "" {
+		return "", fmt.Errorf("--format requires --memory-only")
+	}
+
+	return strings.Join(args, " "), nil
+}
+
+// DumpVM writes a core dump of a VM to path on the QNAP device
+func (c *Client) DumpVM(vmName, path string, opts DumpOptions) error {
+	cmd, err := dumpCommand(vmName, path, opts)
+	if err != nil {
+		return err
+	}
+
+	output, err := c.execVirsh(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to dump VM '%s': %w\nOutput: %s", vmName, err, output)
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package virsh
+
+import (
+	"testing"
+)
+
+func TestDumpCommand(t *testing.T) {
+	tests := []struct {
+		opts    DumpOptions
+		want    string
+		wantErr bool
+	}{
+		{DumpOptions{MemoryOnly: true}, "dump --domain 'web' --file '/share/dumps/web.core' --memory-only --format elf", false},
+		{DumpOptions{MemoryOnly: true, Format: "win-dmp", Live: true}, "dump --domain 'web' --file '/share/dumps/web.core' --live --memory-only --format win-dmp", false},
+		{DumpOptions{}, "dump --domain 'web' --file '/share/dumps/web.core'", false},
+		{DumpOptions{MemoryOnly: true, Format: "vmcore"}, "", true},
+		{DumpOptions{Format: "elf"}, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := dumpCommand("web", "/share/dumps/web.core", tt.opts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("dumpCommand(%+v) error = %v, wantErr %v", tt.opts, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("dumpCommand(%+v) = %q, expected %q", tt.opts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package virsh
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// OVMF is a UEFI firmware build: its read-only code and the template each VM's
+// variable store is copied from
+type OVMF struct {
+	Code   string
+	Vars   string
+	Secure bool // Code is a Secure Boot build, which needs SMM
+}
+
+// DomainLoader represents an <os><loader> element
+type DomainLoader struct {
+	Readonly string `xml:"readonly,attr,omitempty"`
+	Secure   string `xml:"secure,attr,omitempty"`
+	Type     string `xml:"type,attr,omitempty"`
+	Path     string `xml:",chardata"`
+}
+
+// DomainNVRAM represents an <os><nvram> element; libvirt fills in the path when empty
+type DomainNVRAM struct {
+	Template string `xml:"template,attr,omitempty"`
+	Path     string `xml:",chardata"`
+}
+
+// DomainFeatures represents the <features> element
+type DomainFeatures struct {
+	ACPI *struct{}       `xml:"acpi,omitempty"`
+	APIC *struct{}       `xml:"apic,omitempty"`
+	SMM  *DomainSMMState `xml:"smm,omitempty"`
+}
+
+// DomainSMMState represents a <features><smm> element
+type DomainSMMState struct {
+	State string `xml:"state,attr"`
+}
+
+// ovmfDirs are where Virtualization Station and distributions install OVMF, relative
+// to the QVS installation for the first two
+var ovmfDirs = []string{"usr/share/OVMF", "usr/share/qemu", "/usr/share/OVMF", "/usr/share/edk2/ovmf", "/usr/share/qemu"}
+
+// OVMF file names in order of preference. The Secure Boot variable stores come with
+// Microsoft's keys enrolled, which Windows and shim-signed Linux need.
+var (
+	ovmfCode       = []string{"OVMF_CODE_4M.fd", "OVMF_CODE.fd", "edk2-x86_64-code.fd"}
+	ovmfVars       = []string{"OVMF_VARS_4M.fd", "OVMF_VARS.fd", "edk2-i386-vars.fd"}
+	ovmfSecureCode = []string{"OVMF_CODE_4M.secboot.fd", "OVMF_CODE.secboot.fd", "OVMF_CODE_4M.ms.fd", "OVMF_CODE.ms.fd", "edk2-x86_64-secure-code.fd"}
+	ovmfSecureVars = []string{"OVMF_VARS_4M.ms.fd", "OVMF_VARS.ms.fd", "OVMF_VARS_4M.secboot.fd", "OVMF_VARS.secboot.fd"}
+)
+
+// FindOVMF looks for UEFI firmware on the NAS, a Secure Boot build when secure is set
+func (c *Client) FindOVMF(secure bool) (*OVMF, error) {
+	var candidates []string
+	for _, dir := range c.ovmfDirs() {
+		for _, names := range [][]string{ovmfCode, ovmfVars, ovmfSecureCode, ovmfSecureVars} {
+			for _, name := range names {
+				candidates = append(candidates, ssh.Quote(path.Join(dir, name)))
+			}
+		}
+	}
+	output, err := c.sshClient.ExecuteContext(c.commandContext(), fmt.Sprintf("for f in %s; do [ -f \"$f\" ] && echo \"$f\"; done; true", strings.Join(candidates, " ")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look for UEFI firmware: %w\nOutput: %s", err, output)
+	}
+
+	ovmf := selectOVMF(strings.Fields(output), secure)
+	if ovmf == nil {
+		kind := "UEFI"
+		if secure {
+			kind = "Secure Boot UEFI"
+		}
+		return nil, fmt.Errorf("no %s firmware (OVMF) found on the NAS in %s", kind, strings.Join(c.ovmfDirs(), ", "))
+	}
+	return ovmf, nil
+}
+
+// ovmfDirs returns the directories searched for OVMF, with the QVS installation's first
+func (c *Client) ovmfDirs() []string {
+	dirs := make([]string, len(ovmfDirs))
+	for i, dir := range ovmfDirs {
+		if !path.IsAbs(dir) {
+			dir = path.Join("/", c.qvsPath, dir)
+		}
+		dirs[i] = dir
+	}
+	return dirs
+}
+
+// selectOVMF picks firmware from the OVMF files present, preferring the earliest
+// directory and then the preferred names. Code and variables must come from the same
+// directory, since builds of different flash sizes cannot be mixed.
+func selectOVMF(files []string, secure bool) *OVMF {
+	codeNames, varsNames := ovmfCode, ovmfVars
+	if secure {
+		codeNames, varsNames = ovmfSecureCode, ovmfSecureVars
+	}
+
+	present := make(map[string]bool, len(files))
+	var dirs []string
+	for _, file := range files {
+		present[file] = true
+		if dir := path.Dir(file); len(dirs) == 0 || dirs[len(dirs)-1] != dir {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	for _, dir := range dirs {
+		code := firstPresent(present, dir, codeNames)
+		vars := firstPresent(present, dir, varsNames)
+		if code != "" && vars != "" {
+			return &OVMF{Code: code, Vars: vars, Secure: secure}
+		}
+	}
+	return nil
+}
+
+// firstPresent returns the first of names present in dir, or ""
+func firstPresent(present map[string]bool, dir string, names []string) string {
+	for _, name := range names {
+		if file := path.Join(dir, name); present[file] {
+			return file
+		}
+	}
+	return ""
+}
+
+// newFirmware returns the loader, variable store and features for a VM booting ovmf,
+// or nils for BIOS. Secure Boot needs SMM so the guest cannot write the firmware's
+// variables directly.
+func newFirmware(ovmf *OVMF) (*DomainLoader, *DomainNVRAM, *DomainFeatures) {
+	if ovmf == nil {
+		return nil, nil, nil
+	}
+	loader := &DomainLoader{Readonly: "yes", Type: "pflash", Path: ovmf.Code}
+	features := &DomainFeatures{ACPI: &struct{}{}, APIC: &struct{}{}}
+	if ovmf.Secure {
+		loader.Secure = "yes"
+		features.SMM = &DomainSMMState{State: "on"}
+	}
+	return loader, &DomainNVRAM{Template: ovmf.Vars}, features
+}
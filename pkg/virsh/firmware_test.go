@@ -0,0 +1,59 @@
+package virsh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectOVMF(t *testing.T) {
+	files := []string{
+		"/QVS/usr/share/OVMF/OVMF_CODE.fd",
+		"/usr/share/OVMF/OVMF_CODE_4M.fd",
+		"/usr/share/OVMF/OVMF_VARS_4M.fd",
+		"/usr/share/OVMF/OVMF_CODE_4M.secboot.fd",
+		"/usr/share/OVMF/OVMF_VARS_4M.ms.fd",
+	}
+
+	// The QVS directory has code but no variables, so the next directory is used
+	ovmf := selectOVMF(files, false)
+	if ovmf == nil || *ovmf != (OVMF{Code: "/usr/share/OVMF/OVMF_CODE_4M.fd", Vars: "/usr/share/OVMF/OVMF_VARS_4M.fd"}) {
+		t.Errorf("selectOVMF = %+v", ovmf)
+	}
+
+	ovmf = selectOVMF(files, true)
+	if ovmf == nil || *ovmf != (OVMF{Code: "/usr/share/OVMF/OVMF_CODE_4M.secboot.fd", Vars: "/usr/share/OVMF/OVMF_VARS_4M.ms.fd", Secure: true}) {
+		t.Errorf("selectOVMF(secure) = %+v", ovmf)
+	}
+
+	if ovmf := selectOVMF(files[:3], true); ovmf != nil {
+		t.Errorf("selectOVMF(secure) without Secure Boot firmware = %+v", ovmf)
+	}
+}
+
+func TestGenerateDomainXMLSecureBoot(t *testing.T) {
+	client := &Client{}
+	ovmf := &OVMF{Code: "/usr/share/OVMF/OVMF_CODE.secboot.fd", Vars: "/usr/share/OVMF/OVMF_VARS.ms.fd", Secure: true}
+	xml, err := client.generateDomainXML("secure", VMConfig{Memory: 4096, CPUs: 2, ISOPath: "/iso/win.iso", OVMF: ovmf})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	for _, want := range []string{
+		`machine="q35"`,
+		`<loader readonly="yes" secure="yes" type="pflash">/usr/share/OVMF/OVMF_CODE.secboot.fd</loader>`,
+		`<nvram template="/usr/share/OVMF/OVMF_VARS.ms.fd"></nvram>`,
+		`<smm state="on"></smm>`,
+		`bus="sata"`,
+	} {
+		if !strings.Contains(xml, want) {
+			t.Errorf("Generated XML missing %s:\n%s", want, xml)
+		}
+	}
+
+	xml, err = client.generateDomainXML("bios", VMConfig{Memory: 4096, CPUs: 2})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	if strings.Contains(xml, "<loader") || strings.Contains(xml, "<features>") {
+		t.Errorf("Generated BIOS XML has UEFI firmware:\n%s", xml)
+	}
+}
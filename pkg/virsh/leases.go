@@ -0,0 +1,103 @@
+package virsh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// Lease is a DHCP lease handed out by a libvirt network
+type Lease struct {
+	Network  string    `json:"network"`
+	MAC      string    `json:"mac"`
+	IP       string    `json:"ip"` // CIDR form when libvirt reports the prefix
+	Hostname string    `json:"hostname,omitempty"`
+	Expiry   time.Time `json:"expiry"`
+	VM       string    `json:"vm,omitempty"` // VM with the MAC, filled in by the caller
+}
+
+// Leases returns the DHCP leases of a libvirt network. Older libvirt without
+// net-dhcp-leases has its dnsmasq lease file read instead.
+func (c *Client) Leases(network *Network) ([]Lease, error) {
+	output, err := c.execVirsh(fmt.Sprintf("net-dhcp-leases %s", ssh.Quote(network.Name)))
+	if err == nil {
+		return parseLeases(output, network.Name), nil
+	}
+	if network.Bridge == "" {
+		return nil, fmt.Errorf("failed to list leases of network '%s': %w\nOutput: %s", network.Name, err, output)
+	}
+
+	leaseFile := fmt.Sprintf("lib/libvirt/dnsmasq/%s.leases", network.Bridge)
+	script := fmt.Sprintf("cat %s %s 2>/dev/null; true", ssh.Quote(c.qvsPath+"/var/"+leaseFile), ssh.Quote("/var/"+leaseFile))
+	fileOutput, fileErr := c.execVirshScript(script)
+	if fileErr != nil {
+		return nil, fmt.Errorf("failed to list leases of network '%s': %w\nOutput: %s", network.Name, err, output)
+	}
+	return parseDnsmasqLeases(fileOutput, network.Name), nil
+}
+
+// parseLeases parses 'virsh net-dhcp-leases' output
+func parseLeases(output, network string) []Lease {
+	var leases []Lease
+	for _, line := range strings.Split(output, "\n") {
+		// Expiry date, expiry time, MAC, protocol, IP, hostname, client ID
+		fields := strings.Fields(line)
+		if len(fields) < 6 || !strings.Contains(fields[2], ":") {
+			continue
+		}
+		expiry, err := time.ParseInLocation("2006-01-02 15:04:05", fields[0]+" "+fields[1], time.Local)
+		if err != nil {
+			continue
+		}
+		lease := Lease{Network: network, MAC: strings.ToLower(fields[2]), IP: fields[4], Expiry: expiry}
+		if fields[5] != "-" {
+			lease.Hostname = fields[5]
+		}
+		leases = append(leases, lease)
+	}
+	return leases
+}
+
+// parseDnsmasqLeases parses a dnsmasq lease file: expiry (Unix time), MAC, IP,
+// hostname and client ID per line
+func parseDnsmasqLeases(output, network string) []Lease {
+	var leases []Lease
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] == "duid" {
+			continue
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		lease := Lease{Network: network, MAC: strings.ToLower(fields[1]), IP: fields[2], Expiry: time.Unix(expiry, 0)}
+		if fields[3] != "*" {
+			lease.Hostname = fields[3]
+		}
+		leases = append(leases, lease)
+	}
+	return leases
+}
+
+// VMsByMAC maps the MAC addresses of all VMs' interfaces to the VMs' names
+func (c *Client) VMsByMAC() (map[string]string, error) {
+	vms, err := c.ListVMs()
+	if err != nil {
+		return nil, err
+	}
+	byMAC := make(map[string]string)
+	for _, vm := range vms {
+		domain, err := c.GetDomain(vm.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, mac := range domain.MACs() {
+			byMAC[strings.ToLower(mac)] = vm.Name
+		}
+	}
+	return byMAC, nil
+}
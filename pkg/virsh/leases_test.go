@@ -0,0 +1,44 @@
+package virsh
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLeases(t *testing.T) {
+	output := ` Expiry Time           MAC address         Protocol   IP address           Hostname   Client ID or DUID
+-------------------------------------------------------------------------------------------------------------
+ 2026-10-17 14:03:11   52:54:00:AA:bb:cc   ipv4       192.168.100.57/24    web        01:52:54:00:aa:bb:cc
+ 2026-10-17 14:10:40   52:54:00:11:22:33   ipv4       192.168.100.12/24    -          -
+`
+	leases := parseLeases(output, "lab")
+	if len(leases) != 2 {
+		t.Fatalf("parseLeases = %+v", leases)
+	}
+	want := Lease{Network: "lab", MAC: "52:54:00:aa:bb:cc", IP: "192.168.100.57/24", Hostname: "web",
+		Expiry: time.Date(2026, 10, 17, 14, 3, 11, 0, time.Local)}
+	if leases[0] != want {
+		t.Errorf("lease = %+v, want %+v", leases[0], want)
+	}
+	if leases[1].Hostname != "" {
+		t.Errorf("hostname of a lease without one = %q", leases[1].Hostname)
+	}
+}
+
+func TestParseDnsmasqLeases(t *testing.T) {
+	output := `1792245791 52:54:00:aa:bb:cc 192.168.100.57 web 01:52:54:00:aa:bb:cc
+1792246240 52:54:00:11:22:33 192.168.100.12 * *
+duid 00:01:00:01:2c:11:22:33:52:54:00:aa:bb:cc
+`
+	leases := parseDnsmasqLeases(output, "lab")
+	if len(leases) != 2 {
+		t.Fatalf("parseDnsmasqLeases = %+v", leases)
+	}
+	want := Lease{Network: "lab", MAC: "52:54:00:aa:bb:cc", IP: "192.168.100.57", Hostname: "web", Expiry: time.Unix(1792245791, 0)}
+	if leases[0] != want {
+		t.Errorf("lease = %+v, want %+v", leases[0], want)
+	}
+	if leases[1].Hostname != "" {
+		t.Errorf("hostname of a lease without one = %q", leases[1].Hostname)
+	}
+}
@@ -0,0 +1,61 @@
+package virsh
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// DomainMAC represents an interface's <mac> element
+type DomainMAC struct {
+	Address string `xml:"address,attr"`
+}
+
+// StableMAC returns the MAC address qnap-vm gives a VM's nic-th interface: the QEMU
+// 52:54:00 prefix followed by a hash of the VM name. Re-creating a VM under the same
+// name gives it the same address, so DHCP reservations keep working.
+func StableMAC(vmName string, nic int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", vmName, nic)))
+	return fmt.Sprintf("52:54:00:%02x:%02x:%02x", sum[0], sum[1], sum[2])
+}
+
+// ParseMAC validates a unicast Ethernet MAC address and returns it in lowercase
+// colon form
+func ParseMAC(mac string) (string, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return "", fmt.Errorf("invalid MAC address %q (expected e.g. 52:54:00:12:34:56)", mac)
+	}
+	if hw[0]&1 != 0 {
+		return "", fmt.Errorf("MAC address %s is a multicast address", mac)
+	}
+	return hw.String(), nil
+}
+
+// MACs returns the MAC addresses of a domain's interfaces, in order
+func (d *VMDomain) MACs() []string {
+	var macs []string
+	for _, iface := range d.Devices.Interface {
+		if iface.MAC != nil {
+			macs = append(macs, iface.MAC.Address)
+		}
+	}
+	return macs
+}
+
+// cloneMACArgs returns virt-clone --mac options giving each interface of a clone the
+// stable MAC for its new name, instead of virt-clone's random ones
+func (c *Client) cloneMACArgs(sourceVMName, targetVMName string) string {
+	domain, err := c.GetDomain(sourceVMName)
+	if err != nil {
+		return ""
+	}
+	var args strings.Builder
+	for i := range domain.Devices.Interface {
+		fmt.Fprintf(&args, " --mac=%s", ssh.Quote(StableMAC(targetVMName, i)))
+	}
+	return args.String()
+}
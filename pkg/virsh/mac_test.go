@@ -0,0 +1,66 @@
+package virsh
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestStableMAC(t *testing.T) {
+	mac := StableMAC("web", 0)
+	if mac != StableMAC("web", 0) {
+		t.Fatal("StableMAC is not deterministic")
+	}
+	if !strings.HasPrefix(mac, "52:54:00:") {
+		t.Errorf("StableMAC = %s, want the 52:54:00 prefix", mac)
+	}
+	if _, err := ParseMAC(mac); err != nil {
+		t.Errorf("StableMAC = %s: %v", mac, err)
+	}
+	if mac == StableMAC("web-clone", 0) || mac == StableMAC("web", 1) {
+		t.Error("StableMAC should differ between VMs and interfaces")
+	}
+}
+
+func TestParseMAC(t *testing.T) {
+	tests := []struct {
+		mac     string
+		want    string
+		wantErr bool
+	}{
+		{"52:54:00:AB:cd:01", "52:54:00:ab:cd:01", false},
+		{"52-54-00-ab-cd-01", "52:54:00:ab:cd:01", false},
+		{"01:00:5e:00:00:01", "", true}, // multicast
+		{"52:54:00:ab:cd", "", true},
+		{"not-a-mac", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMAC(tt.mac)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseMAC(%q) = %q, %v; want %q, error %v", tt.mac, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGenerateDomainXMLMAC(t *testing.T) {
+	c := &Client{}
+	for _, tt := range []struct {
+		config VMConfig
+		want   string
+	}{
+		{VMConfig{Memory: 1024, CPUs: 1}, StableMAC("web", 0)},
+		{VMConfig{Memory: 1024, CPUs: 1, MAC: "52:54:00:12:34:56"}, "52:54:00:12:34:56"},
+	} {
+		domainXML, err := c.generateDomainXML("web", tt.config)
+		if err != nil {
+			t.Fatalf("generateDomainXML failed: %v", err)
+		}
+		var domain VMDomain
+		if err := xml.Unmarshal([]byte(domainXML), &domain); err != nil {
+			t.Fatalf("failed to parse domain XML: %v", err)
+		}
+		if macs := domain.MACs(); len(macs) != 1 || macs[0] != tt.want {
+			t.Errorf("MACs = %v, want [%s]", macs, tt.want)
+		}
+	}
+}
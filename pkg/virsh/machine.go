@@ -0,0 +1,120 @@
+package virsh
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Machine type families accepted by --machine besides exact machine types
+const (
+	MachineQ35 = "q35" // Q35 + ICH9: PCIe, SATA, SMM; no IDE
+	MachinePC  = "pc"  // i440FX + PIIX: legacy PCI and IDE
+)
+
+// LegacyMachine is the machine type VMs were created with before machine types were
+// detected, used when the NAS's QEMU cannot be asked
+const LegacyMachine = "pc-i440fx-2.3"
+
+// MachineType is a machine type the NAS's QEMU supports
+type MachineType struct {
+	Name    string
+	AliasOf string // Versioned type an alias such as q35 stands for
+	Default bool
+}
+
+// machineLinePattern matches a '-machine help' line: name, then description
+var machineLinePattern = regexp.MustCompile(`^(\S+)\s+(.*)$`)
+
+// aliasPattern matches the "(alias of pc-q35-6.2)" part of a description
+var aliasPattern = regexp.MustCompile(`\(alias of ([^)]+)\)`)
+
+// machineVersionPattern matches versioned i440FX and Q35 machine types
+var machineVersionPattern = regexp.MustCompile(`^pc-(i440fx|q35)-(\d+)\.(\d+)$`)
+
+// MachineTypes returns the machine types the NAS's QEMU supports
+func (c *Client) MachineTypes() ([]MachineType, error) {
+	output, err := c.execVirshScript(fmt.Sprintf("%s/usr/bin/qemu-system-x86_64 -machine help", c.qvsPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list QEMU machine types: %w\nOutput: %s", err, output)
+	}
+	machines := parseMachineTypes(output)
+	if len(machines) == 0 {
+		return nil, fmt.Errorf("no machine types in QEMU output: %s", strings.TrimSpace(output))
+	}
+	return machines, nil
+}
+
+// parseMachineTypes parses 'qemu-system-x86_64 -machine help' output
+func parseMachineTypes(output string) []MachineType {
+	var machines []MachineType
+	for _, line := range strings.Split(output, "\n") {
+		match := machineLinePattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil || strings.HasSuffix(match[1], ":") || match[1] == "Supported" {
+			continue
+		}
+		machine := MachineType{Name: match[1], Default: strings.Contains(match[2], "(default)")}
+		if alias := aliasPattern.FindStringSubmatch(match[2]); alias != nil {
+			machine.AliasOf = alias[1]
+		}
+		machines = append(machines, machine)
+	}
+	return machines
+}
+
+// ResolveMachine returns the exact machine type for want: q35 or pc give the newest
+// versioned type of that family, "" the newest i440FX type, and anything else must be
+// a machine type the NAS supports. Versioned types keep a VM's hardware stable when
+// Virtualization Station upgrades QEMU.
+func ResolveMachine(machines []MachineType, want string) (string, error) {
+	family := want
+	if want == "" {
+		family = MachinePC
+	}
+	if family == MachinePC || family == MachineQ35 {
+		if newest := newestMachine(machines, family); newest != "" {
+			return newest, nil
+		}
+		return "", fmt.Errorf("the NAS's QEMU has no %s machine types", family)
+	}
+
+	var names []string
+	for _, machine := range machines {
+		if machine.Name == want {
+			if machine.AliasOf != "" {
+				return machine.AliasOf, nil
+			}
+			return want, nil
+		}
+		names = append(names, machine.Name)
+	}
+	return "", fmt.Errorf("machine type %q is not supported by the NAS's QEMU (supported: %s)", want, strings.Join(names, ", "))
+}
+
+// newestMachine returns the newest versioned machine type of family (pc or q35)
+func newestMachine(machines []MachineType, family string) string {
+	chipset := "i440fx"
+	if family == MachineQ35 {
+		chipset = "q35"
+	}
+
+	newest, newestMajor, newestMinor := "", -1, -1
+	for _, machine := range machines {
+		match := machineVersionPattern.FindStringSubmatch(machine.Name)
+		if match == nil || match[1] != chipset {
+			continue
+		}
+		major, _ := strconv.Atoi(match[2])
+		minor, _ := strconv.Atoi(match[3])
+		if major > newestMajor || (major == newestMajor && minor > newestMinor) {
+			newest, newestMajor, newestMinor = machine.Name, major, minor
+		}
+	}
+	return newest
+}
+
+// IsQ35 reports whether a machine type uses the Q35 chipset
+func IsQ35(machine string) bool {
+	return machine == MachineQ35 || strings.HasPrefix(machine, "pc-q35-")
+}
@@ -0,0 +1,73 @@
+package virsh
+
+import (
+	"strings"
+	"testing"
+)
+
+const machineHelp = `Supported machines are:
+microvm              microvm (i386)
+pc                   Standard PC (i440FX + PIIX, 1996) (alias of pc-i440fx-6.2)
+pc-i440fx-6.2        Standard PC (i440FX + PIIX, 1996) (default)
+pc-i440fx-2.3        Standard PC (i440FX + PIIX, 1996) (deprecated)
+pc-i440fx-2.12       Standard PC (i440FX + PIIX, 1996)
+q35                  Standard PC (Q35 + ICH9, 2009) (alias of pc-q35-6.2)
+pc-q35-6.2           Standard PC (Q35 + ICH9, 2009)
+pc-q35-2.10          Standard PC (Q35 + ICH9, 2009)
+none                 empty machine
+`
+
+func TestParseMachineTypes(t *testing.T) {
+	machines := parseMachineTypes(machineHelp)
+	if len(machines) != 9 {
+		t.Fatalf("parseMachineTypes returned %d machines, want 9: %+v", len(machines), machines)
+	}
+	if machines[1] != (MachineType{Name: "pc", AliasOf: "pc-i440fx-6.2"}) {
+		t.Errorf("machines[1] = %+v", machines[1])
+	}
+	if machines[2] != (MachineType{Name: "pc-i440fx-6.2", Default: true}) {
+		t.Errorf("machines[2] = %+v", machines[2])
+	}
+}
+
+func TestResolveMachine(t *testing.T) {
+	machines := parseMachineTypes(machineHelp)
+	tests := []struct {
+		want     string
+		expected string
+		wantErr  bool
+	}{
+		{"", "pc-i440fx-6.2", false},
+		{"pc", "pc-i440fx-6.2", false},
+		{"q35", "pc-q35-6.2", false},
+		{"pc-q35-2.10", "pc-q35-2.10", false},
+		{"microvm", "microvm", false},
+		{"pc-q35-9.0", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ResolveMachine(machines, tt.want)
+		if (err != nil) != tt.wantErr || got != tt.expected {
+			t.Errorf("ResolveMachine(%q) = %q, %v; want %q", tt.want, got, err, tt.expected)
+		}
+	}
+
+	// 2.12 is newer than 2.3 even though it sorts before it as a string
+	old := parseMachineTypes("pc-i440fx-2.3  Standard PC\npc-i440fx-2.12  Standard PC\n")
+	if got, _ := ResolveMachine(old, ""); got != "pc-i440fx-2.12" {
+		t.Errorf("ResolveMachine without aliases = %q, want pc-i440fx-2.12", got)
+	}
+	if _, err := ResolveMachine(old, "q35"); err == nil {
+		t.Error("ResolveMachine(q35) succeeded without q35 machine types")
+	}
+}
+
+func TestGenerateDomainXMLMachine(t *testing.T) {
+	client := &Client{}
+	xml, err := client.generateDomainXML("modern", VMConfig{Memory: 2048, CPUs: 2, ISOPath: "/iso/a.iso", Machine: "pc-q35-6.2"})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	if !strings.Contains(xml, `machine="pc-q35-6.2"`) || !strings.Contains(xml, `bus="sata"`) {
+		t.Errorf("Generated XML missing q35 machine or SATA CD-ROM:\n%s", xml)
+	}
+}
@@ -0,0 +1,272 @@
+package virsh
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// DomainMemoryBacking represents the <memoryBacking> element
+type DomainMemoryBacking struct {
+	HugePages *DomainHugePages `xml:"hugepages,omitempty"`
+}
+
+// DomainHugePages represents a <memoryBacking><hugepages> element; empty uses the
+// kernel's default page size
+type DomainHugePages struct{}
+
+// newMemoryBacking returns the <memoryBacking> element for a VM, or nil for ordinary pages
+func newMemoryBacking(config VMConfig) *DomainMemoryBacking {
+	if !config.HugePages {
+		return nil
+	}
+	return &DomainMemoryBacking{HugePages: &DomainHugePages{}}
+}
+
+// HugePages describes the NAS kernel's default-size huge pages
+type HugePages struct {
+	SizeKiB int // Page size; 0 when the kernel has no hugepage support
+	Total   int // Pages reserved
+	Free    int // Reserved pages not in use
+	Mounted bool
+}
+
+// PagesFor returns the number of pages a VM with memoryMB of memory needs
+func (h *HugePages) PagesFor(memoryMB int) int {
+	if h.SizeKiB == 0 {
+		return 0
+	}
+	return (memoryMB*1024 + h.SizeKiB - 1) / h.SizeKiB
+}
+
+// HostHugePages returns the NAS's huge page pool and whether hugetlbfs is mounted for QEMU
+func (c *Client) HostHugePages() (*HugePages, error) {
+	output, err := c.sshClient.ExecuteContext(c.commandContext(), "cat /proc/meminfo; grep -q ' hugetlbfs ' /proc/mounts && echo 'hugetlbfs: mounted'; true")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host memory information: %w\nOutput: %s", err, output)
+	}
+	return parseHugePages(output), nil
+}
+
+// parseHugePages parses /proc/meminfo followed by an optional "hugetlbfs: mounted" line
+func parseHugePages(output string) *HugePages {
+	pages := &HugePages{}
+	for _, line := range strings.Split(output, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		n, _ := strconv.Atoi(strings.TrimSuffix(value, " kB"))
+		switch name {
+		case "HugePages_Total":
+			pages.Total = n
+		case "HugePages_Free":
+			pages.Free = n
+		case "Hugepagesize":
+			pages.SizeKiB = n
+		case "hugetlbfs":
+			pages.Mounted = value == "mounted"
+		}
+	}
+	return pages
+}
+
+// ReserveHugePages sets the size of the NAS's huge page pool to total pages. The kernel
+// may reserve fewer when memory is fragmented, and the pool resets when the NAS restarts.
+func (c *Client) ReserveHugePages(total int) error {
+	output, err := c.sshClient.ExecuteContext(c.commandContext(), fmt.Sprintf("echo %d > /proc/sys/vm/nr_hugepages", total))
+	if err != nil {
+		return fmt.Errorf("failed to reserve huge pages: %w\nOutput: %s", err, output)
+	}
+	return nil
+}
+
+// noBalloonPattern matches a disabled balloon device in domain XML
+var noBalloonPattern = regexp.MustCompile(`<memballoon\s+model=['"]none['"]`)
+
+// Balloon is a running VM's balloon size as reported by domstats
+type Balloon struct {
+	CurrentMB int
+	MaximumMB int
+}
+
+// GetBalloon returns a running VM's balloon size. It fails when the VM has no balloon device.
+func (c *Client) GetBalloon(vmName string) (*Balloon, error) {
+	domainXML, err := c.execVirsh(fmt.Sprintf("dumpxml %s", domainArg(vmName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration for VM '%s': %w\nOutput: %s", vmName, err, domainXML)
+	}
+	if noBalloonPattern.MatchString(domainXML) {
+		return nil, fmt.Errorf("VM '%s' has no balloon device, so its memory cannot be changed while it runs", vmName)
+	}
+
+	output, err := c.execVirsh(fmt.Sprintf("domstats --balloon %s", ssh.Quote(vmName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read balloon statistics of VM '%s': %w\nOutput: %s", vmName, err, output)
+	}
+	stats := &VMStats{}
+	c.parseMemoryStats(output, stats)
+	return &Balloon{CurrentMB: int(stats.Memory.Used / 1024), MaximumMB: int(stats.Memory.Total / 1024)}, nil
+}
+
+// SetMemory sets a VM's memory in its saved configuration and, with live, in the running
+// VM through its balloon device. memoryMB cannot exceed the VM's maximum memory.
+func (c *Client) SetMemory(vmName string, memoryMB int, live bool) error {
+	cmd := fmt.Sprintf("setmem %s --size %d --config", domainArg(vmName), memoryMB*1024)
+	if live {
+		cmd += " --live"
+	}
+	output, err := c.execVirsh(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to set memory of VM '%s' to %d MB: %w\nOutput: %s", vmName, memoryMB, err, output)
+	}
+	return nil
+}
+
+// MemTune holds a VM's memtune limits in MB; 0 means unlimited
+type MemTune struct {
+	HardLimitMB int // The host kills the VM rather than let it use more
+	SoftLimitMB int // The host reclaims memory down to this when it runs short
+}
+
+// unlimitedKiB is the value virsh reports (and older versions print) for no limit
+const unlimitedKiB = 9007199254740991
+
+// ParseMemoryLimit parses a memtune limit given in MB, or "unlimited" (returned as 0)
+func ParseMemoryLimit(value string) (int, error) {
+	if value == "unlimited" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid memory limit %q (expected MB or unlimited)", value)
+	}
+	return n, nil
+}
+
+// ValidateMemTune checks limits for a VM with memoryMB of memory. The hard limit must
+// leave room above the guest's memory for QEMU's own overhead, or the VM is killed.
+func ValidateMemTune(tune MemTune, memoryMB int) error {
+	if tune.HardLimitMB != 0 && tune.HardLimitMB <= memoryMB {
+		return fmt.Errorf("hard limit %d MB must be above the VM's memory (%d MB) to leave room for QEMU's overhead", tune.HardLimitMB, memoryMB)
+	}
+	if tune.HardLimitMB != 0 && tune.SoftLimitMB > tune.HardLimitMB {
+		return fmt.Errorf("soft limit %d MB is above the hard limit %d MB", tune.SoftLimitMB, tune.HardLimitMB)
+	}
+	return nil
+}
+
+// MemoryTune returns a VM's memtune limits. live reads the running VM's limits;
+// otherwise its saved configuration's.
+func (c *Client) MemoryTune(vmName string, live bool) (*MemTune, error) {
+	scope := "--config"
+	if live {
+		scope = "--live"
+	}
+	output, err := c.execVirsh(fmt.Sprintf("memtune %s %s", domainArg(vmName), scope))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read memory limits of VM '%s': %w\nOutput: %s", vmName, err, output)
+	}
+	return parseMemTune(output), nil
+}
+
+// parseMemTune parses 'virsh memtune' output of "hard_limit     : 4194304" lines in KiB
+func parseMemTune(output string) *MemTune {
+	tune := &MemTune{}
+	for _, line := range strings.Split(output, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		kib, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || kib >= unlimitedKiB {
+			continue
+		}
+		switch strings.TrimSpace(name) {
+		case "hard_limit":
+			tune.HardLimitMB = int(kib / 1024)
+		case "soft_limit":
+			tune.SoftLimitMB = int(kib / 1024)
+		}
+	}
+	return tune
+}
+
+// SetMemoryTune sets a VM's memtune limits in its saved configuration and, with live, in
+// the running VM
+func (c *Client) SetMemoryTune(vmName string, tune MemTune, live bool) error {
+	cmd := fmt.Sprintf("memtune %s --hard-limit %d --soft-limit %d --config", domainArg(vmName), limitKiB(tune.HardLimitMB), limitKiB(tune.SoftLimitMB))
+	if live {
+		cmd += " --live"
+	}
+	output, err := c.execVirsh(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to set memory limits of VM '%s': %w\nOutput: %s", vmName, err, output)
+	}
+	return nil
+}
+
+// limitKiB converts a limit in MB to the KiB virsh memtune takes, with -1 for unlimited
+func limitKiB(mb int) int {
+	if mb == 0 {
+		return -1
+	}
+	return mb * 1024
+}
+
+// balloonPattern matches the opening tag of a balloon device in domain XML
+var balloonPattern = regexp.MustCompile(`<memballoon\b[^>]*?/?>`)
+
+// autodeflatePattern matches a balloon device's autodeflate attribute
+var autodeflatePattern = regexp.MustCompile(`\s+autodeflate=['"](on|off)['"]`)
+
+// BalloonAutodeflate reports whether a VM's balloon device gives memory back to the
+// guest before it runs out, from its saved configuration
+func (c *Client) BalloonAutodeflate(vmName string) (bool, error) {
+	domainXML, err := c.dumpInactiveXML(vmName)
+	if err != nil {
+		return false, err
+	}
+	tag := balloonPattern.FindString(domainXML)
+	match := autodeflatePattern.FindStringSubmatch(tag)
+	return match != nil && match[1] == "on", nil
+}
+
+// SetBalloonAutodeflate turns balloon autodeflate on or off in a VM's saved
+// configuration; it applies from the VM's next start
+func (c *Client) SetBalloonAutodeflate(vmName string, on bool) error {
+	domainXML, err := c.dumpInactiveXML(vmName)
+	if err != nil {
+		return err
+	}
+
+	updated, err := setBalloonAutodeflate(domainXML, on)
+	if err != nil {
+		return fmt.Errorf("failed to update VM '%s': %w", vmName, err)
+	}
+	return c.defineXML(vmName, updated)
+}
+
+// setBalloonAutodeflate sets the autodeflate attribute of the balloon device in domain XML
+func setBalloonAutodeflate(domainXML string, on bool) (string, error) {
+	loc := balloonPattern.FindStringIndex(domainXML)
+	if loc == nil {
+		return "", fmt.Errorf("domain definition has no balloon device")
+	}
+	tag := domainXML[loc[0]:loc[1]]
+	if noBalloonPattern.MatchString(tag) {
+		return "", fmt.Errorf("the balloon device is disabled")
+	}
+
+	value := "off"
+	if on {
+		value = "on"
+	}
+	tag = autodeflatePattern.ReplaceAllLiteralString(tag, "")
+	tag = strings.Replace(tag, "<memballoon", "<memballoon autodeflate='"+value+"'", 1)
+	return domainXML[:loc[0]] + tag + domainXML[loc[1]:], nil
+}
@@ -0,0 +1,120 @@
+package virsh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseHugePages(t *testing.T) {
+	output := `MemTotal:       16287004 kB
+HugePages_Total:     512
+HugePages_Free:      384
+HugePages_Rsvd:        0
+Hugepagesize:       2048 kB
+hugetlbfs: mounted
+`
+	pages := parseHugePages(output)
+	if *pages != (HugePages{SizeKiB: 2048, Total: 512, Free: 384, Mounted: true}) {
+		t.Errorf("parseHugePages = %+v", pages)
+	}
+	if got := pages.PagesFor(1025); got != 513 {
+		t.Errorf("PagesFor(1025) = %d, want 513", got)
+	}
+
+	if pages := parseHugePages("MemTotal: 16287004 kB\n"); pages.SizeKiB != 0 || pages.PagesFor(1024) != 0 {
+		t.Errorf("parseHugePages without hugepage support = %+v", pages)
+	}
+}
+
+func TestGenerateDomainXMLHugePages(t *testing.T) {
+	client := &Client{}
+	xml, err := client.generateDomainXML("huge", VMConfig{Memory: 4096, CPUs: 2, HugePages: true})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	if !strings.Contains(xml, "<memoryBacking>") || !strings.Contains(xml, "<hugepages></hugepages>") {
+		t.Errorf("Generated XML missing huge page backing:\n%s", xml)
+	}
+
+	xml, err = client.generateDomainXML("plain", VMConfig{Memory: 4096, CPUs: 2})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	if strings.Contains(xml, "memoryBacking") {
+		t.Errorf("Generated XML has memory backing without huge pages:\n%s", xml)
+	}
+}
+
+func TestNoBalloonPattern(t *testing.T) {
+	if !noBalloonPattern.MatchString(`<devices><memballoon model='none'/></devices>`) {
+		t.Error("noBalloonPattern missed a disabled balloon")
+	}
+	if noBalloonPattern.MatchString(`<devices><memballoon model='virtio'><alias name='balloon0'/></memballoon></devices>`) {
+		t.Error("noBalloonPattern matched a virtio balloon")
+	}
+}
+
+func TestParseMemTune(t *testing.T) {
+	output := `hard_limit     : 6291456
+soft_limit     : unlimited
+swap_hard_limit: 9007199254740991
+`
+	if tune := parseMemTune(output); *tune != (MemTune{HardLimitMB: 6144}) {
+		t.Errorf("parseMemTune = %+v", tune)
+	}
+}
+
+func TestValidateMemTune(t *testing.T) {
+	tests := []struct {
+		tune    MemTune
+		wantErr bool
+	}{
+		{MemTune{}, false},
+		{MemTune{HardLimitMB: 5120, SoftLimitMB: 4096}, false},
+		{MemTune{SoftLimitMB: 8192}, false},
+		{MemTune{HardLimitMB: 4096}, true},
+		{MemTune{HardLimitMB: 5120, SoftLimitMB: 6144}, true},
+	}
+	for _, tt := range tests {
+		if err := ValidateMemTune(tt.tune, 4096); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateMemTune(%+v) error = %v, wantErr %v", tt.tune, err, tt.wantErr)
+		}
+	}
+
+	if n, err := ParseMemoryLimit("unlimited"); err != nil || n != 0 {
+		t.Errorf("ParseMemoryLimit(unlimited) = %d, %v", n, err)
+	}
+	if _, err := ParseMemoryLimit("-5"); err == nil {
+		t.Error("ParseMemoryLimit(-5) succeeded")
+	}
+}
+
+func TestSetBalloonAutodeflate(t *testing.T) {
+	domainXML := `<devices>
+    <memballoon model='virtio'>
+      <address type='pci'/>
+    </memballoon>
+  </devices>`
+	updated, err := setBalloonAutodeflate(domainXML, true)
+	if err != nil {
+		t.Fatalf("setBalloonAutodeflate failed: %v", err)
+	}
+	if !strings.Contains(updated, `<memballoon autodeflate='on' model='virtio'>`) {
+		t.Errorf("autodeflate not turned on:\n%s", updated)
+	}
+
+	updated, err = setBalloonAutodeflate(updated, false)
+	if err != nil {
+		t.Fatalf("setBalloonAutodeflate failed: %v", err)
+	}
+	if !strings.Contains(updated, `<memballoon autodeflate='off' model='virtio'>`) || strings.Contains(updated, "'on'") {
+		t.Errorf("autodeflate not turned off:\n%s", updated)
+	}
+
+	if _, err := setBalloonAutodeflate(`<memballoon model='none'/>`, true); err == nil {
+		t.Error("setBalloonAutodeflate succeeded on a disabled balloon")
+	}
+	if _, err := setBalloonAutodeflate(`<devices></devices>`, true); err == nil {
+		t.Error("setBalloonAutodeflate succeeded without a balloon")
+	}
+}
@@ -0,0 +1,268 @@
+package virsh
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// MetadataNamespace is the XML namespace for qnap-vm's domain <metadata> element
+const MetadataNamespace = "https://github.com/scttfrdmn/qnap-vm/xmlns/vm/1.0"
+
+// metadataKey is the namespace prefix libvirt uses when writing qnap-vm metadata
+const metadataKey = "qnapvm"
+
+// bulkDumpScript dumps the persistent XML of every domain in a single SSH round trip
+const bulkDumpScript = `virsh list --all --name | while IFS= read -r name; do
+	[ -n "$name" ] || continue
+	virsh dumpxml --inactive --domain "$name" 2>/dev/null || virsh dumpxml --domain "$name" 2>/dev/null
+done`
+
+// VMMetadata is the qnap-vm metadata stored in a domain definition
+type VMMetadata struct {
+	XMLName xml.Name `xml:"vm"`
+	Tags    []string `xml:"tag"`
+}
+
+// domainSummary holds the fields of a domain definition shown in inventory listings
+type domainSummary struct {
+	Name   string `xml:"name"`
+	UUID   string `xml:"uuid"`
+	Title  string `xml:"title"`
+	Memory struct {
+		Unit  string `xml:"unit,attr"`
+		Value int64  `xml:",chardata"`
+	} `xml:"memory"`
+	VCPU  int `xml:"vcpu"`
+	Disks []struct {
+		Device string `xml:"device,attr"`
+		Source struct {
+			File string `xml:"file,attr"`
+		} `xml:"source"`
+	} `xml:"devices>disk"`
+	Metadata struct {
+		VM struct {
+			Tags []string `xml:"tag"`
+		} `xml:"https://github.com/scttfrdmn/qnap-vm/xmlns/vm/1.0 vm"`
+	} `xml:"metadata"`
+}
+
+// memoryMB converts the domain memory to MiB
+func (d *domainSummary) memoryMB() int {
+	switch strings.ToLower(d.Memory.Unit) {
+	case "b", "bytes":
+		return int(d.Memory.Value / (1024 * 1024))
+	case "m", "mib":
+		return int(d.Memory.Value)
+	case "g", "gib":
+		return int(d.Memory.Value * 1024)
+	default: // KiB is libvirt's default unit
+		return int(d.Memory.Value / 1024)
+	}
+}
+
+// ListVMsWithMetadata lists all VMs with memory, CPUs, title and tags read from
+// their definitions in one bulk pass instead of querying each VM separately
+func (c *Client) ListVMsWithMetadata() ([]VMInfo, error) {
+	vms, err := c.ListVMs()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries, err := c.bulkDomainSummaries()
+	if err != nil {
+		return nil, err
+	}
+
+	transient, err := c.listNames("--transient")
+	if err != nil {
+		return nil, err
+	}
+	managedSave, err := c.listNames("--with-managed-save")
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range vms {
+		vms[i].Transient = transient[vms[i].Name]
+		vms[i].ManagedSave = managedSave[vms[i].Name]
+
+		summary, ok := summaries[vms[i].Name]
+		if !ok {
+			continue
+		}
+		vms[i].UUID = summary.UUID
+		vms[i].Memory = summary.memoryMB()
+		vms[i].CPUs = summary.VCPU
+		vms[i].Title = summary.Title
+		vms[i].Tags = summary.Metadata.VM.Tags
+	}
+
+	return vms, nil
+}
+
+// ListDiskSources maps the image path of every disk and CD-ROM of every VM to the VM using it.
+// It fails rather than return a partial result if any VM definition cannot be read.
+func (c *Client) ListDiskSources() (map[string]string, error) {
+	vms, err := c.ListVMs()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries, err := c.bulkDomainSummaries()
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make(map[string]string)
+	for _, vm := range vms {
+		summary, ok := summaries[vm.Name]
+		if !ok {
+			return nil, fmt.Errorf("failed to read definition of VM '%s'", vm.Name)
+		}
+		for _, disk := range summary.Disks {
+			if disk.Source.File != "" {
+				sources[disk.Source.File] = vm.Name
+			}
+		}
+	}
+
+	return sources, nil
+}
+
+// ListVMDiskFiles returns the image files of every VM's disks, leaving out CD-ROMs,
+// floppies and block devices, in one bulk pass
+func (c *Client) ListVMDiskFiles() (map[string][]string, error) {
+	summaries, err := c.bulkDomainSummaries()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]string)
+	for name, summary := range summaries {
+		for _, disk := range summary.Disks {
+			if disk.Source.File != "" && (disk.Device == "" || disk.Device == "disk") {
+				files[name] = append(files[name], disk.Source.File)
+			}
+		}
+	}
+	return files, nil
+}
+
+// bulkDomainSummaries reads the definitions of all VMs in one SSH round trip
+func (c *Client) bulkDomainSummaries() (map[string]domainSummary, error) {
+	output, err := c.execVirshScript(bulkDumpScript)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read VM definitions: %w", err)
+	}
+	return parseDomainSummaries(output)
+}
+
+// listNames returns the set of domain names matched by a 'virsh list --all' filter flag
+func (c *Client) listNames(filter string) (map[string]bool, error) {
+	output, err := c.execVirsh(fmt.Sprintf("list --all --name %s", filter))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VMs: %w\nOutput: %s", err, output)
+	}
+
+	names := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		if name := strings.TrimSpace(line); name != "" {
+			names[name] = true
+		}
+	}
+	return names, nil
+}
+
+// parseDomainSummaries parses a stream of concatenated domain XML documents
+func parseDomainSummaries(output string) (map[string]domainSummary, error) {
+	summaries := make(map[string]domainSummary)
+
+	decoder := xml.NewDecoder(strings.NewReader(output))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse VM definitions: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "domain" {
+			continue
+		}
+
+		var summary domainSummary
+		if err := decoder.DecodeElement(&summary, &start); err != nil {
+			return nil, fmt.Errorf("failed to parse VM definitions: %w", err)
+		}
+		summaries[summary.Name] = summary
+	}
+
+	return summaries, nil
+}
+
+// SetTitle sets the title of a VM's persistent definition; an empty title removes it
+func (c *Client) SetTitle(vmName, title string) error {
+	cmd := fmt.Sprintf("desc %s --config --title --new-desc %s", domainArg(vmName), ssh.Quote(title))
+	output, err := c.execVirsh(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to set title for VM '%s': %w\nOutput: %s", vmName, err, output)
+	}
+	return nil
+}
+
+// GetTags returns the qnap-vm tags of a VM
+func (c *Client) GetTags(vmName string) ([]string, error) {
+	domainXML, err := c.dumpInactiveXML(vmName)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries, err := parseDomainSummaries(domainXML)
+	if err != nil {
+		return nil, err
+	}
+	return summaries[vmName].Metadata.VM.Tags, nil
+}
+
+// SetTags replaces the qnap-vm tags of a VM; an empty list removes the metadata
+func (c *Client) SetTags(vmName string, tags []string) error {
+	var cmd string
+	if len(tags) == 0 {
+		cmd = fmt.Sprintf("metadata %s %s --config --remove", domainArg(vmName), MetadataNamespace)
+	} else {
+		metadataXML, err := xml.Marshal(VMMetadata{Tags: NormalizeTags(tags)})
+		if err != nil {
+			return err
+		}
+		cmd = fmt.Sprintf("metadata %s %s --config --key %s --set %s", domainArg(vmName), MetadataNamespace, metadataKey, ssh.Quote(string(metadataXML)))
+	}
+
+	output, err := c.execVirsh(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to set tags for VM '%s': %w\nOutput: %s", vmName, err, output)
+	}
+	return nil
+}
+
+// NormalizeTags trims, de-duplicates and sorts tags, dropping empty ones
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	var normalized []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
@@ -0,0 +1,67 @@
+package virsh
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDomainSummaries(t *testing.T) {
+	sampleOutput := `<domain type='kvm'>
+  <name>web</name>
+  <uuid>2b1a7a0e-6f0c-4a43-9d8e-0c1f1b2c3d4e</uuid>
+  <title>Public web server</title>
+  <metadata>
+    <qnapvm:vm xmlns:qnapvm="https://github.com/scttfrdmn/qnap-vm/xmlns/vm/1.0">
+      <qnapvm:tag>prod</qnapvm:tag>
+      <qnapvm:tag>web</qnapvm:tag>
+    </qnapvm:vm>
+  </metadata>
+  <memory unit='KiB'>4194304</memory>
+  <vcpu placement='static'>2</vcpu>
+  <devices>
+    <disk type='file' device='disk'>
+      <source file='/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2'/>
+      <target dev='vda' bus='virtio'/>
+    </disk>
+  </devices>
+</domain>
+<domain type='kvm'>
+  <name>scratch vm</name>
+  <memory unit='GiB'>1</memory>
+  <vcpu placement='static'>1</vcpu>
+</domain>
+`
+
+	summaries, err := parseDomainSummaries(sampleOutput)
+	if err != nil {
+		t.Fatalf("parseDomainSummaries failed: %v", err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("Expected 2 summaries, got %d", len(summaries))
+	}
+
+	web := summaries["web"]
+	if web.Title != "Public web server" || web.VCPU != 2 || web.memoryMB() != 4096 {
+		t.Errorf("Unexpected summary for web: %+v", web)
+	}
+	if !reflect.DeepEqual(web.Metadata.VM.Tags, []string{"prod", "web"}) {
+		t.Errorf("Unexpected tags for web: %v", web.Metadata.VM.Tags)
+	}
+
+	if len(web.Disks) != 1 || web.Disks[0].Source.File != "/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2" {
+		t.Errorf("Unexpected disks for web: %+v", web.Disks)
+	}
+
+	scratch := summaries["scratch vm"]
+	if scratch.Title != "" || len(scratch.Metadata.VM.Tags) != 0 || scratch.memoryMB() != 1024 {
+		t.Errorf("Unexpected summary for scratch vm: %+v", scratch)
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got := NormalizeTags([]string{" web", "prod", "", "web", "db "})
+	expected := []string{"db", "prod", "web"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("NormalizeTags() = %v, expected %v", got, expected)
+	}
+}
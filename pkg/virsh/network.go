@@ -0,0 +1,439 @@
+package virsh
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// Network modes accepted by ParseNetwork
+const (
+	NetworkUser   = "user"    // QEMU user-mode networking: outbound only, no setup needed
+	NetworkBridge = "bridge"  // A bridge on the NAS, such as a QNAP virtual switch
+	NetworkNAT    = "network" // A libvirt network, such as one from 'qnap-vm network create'
+)
+
+// VMNetwork is what a VM's network card is connected to
+type VMNetwork struct {
+	Mode   string // NetworkUser, NetworkBridge or NetworkNAT; "" is NetworkUser
+	Source string // Bridge or libvirt network name
+}
+
+// ParseNetwork parses a network specification: "user", "bridge=NAME" or "network=NAME"
+func ParseNetwork(spec string) (VMNetwork, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == NetworkUser {
+		return VMNetwork{Mode: NetworkUser}, nil
+	}
+	mode, source, ok := strings.Cut(spec, "=")
+	if !ok || (mode != NetworkBridge && mode != NetworkNAT) || source == "" {
+		return VMNetwork{}, fmt.Errorf("invalid network %q (use user, bridge=NAME or network=NAME; see 'qnap-vm network list')", spec)
+	}
+	return VMNetwork{Mode: mode, Source: source}, nil
+}
+
+// DomainMTU represents an interface's <mtu> element
+type DomainMTU struct {
+	Size int `xml:"size,attr"`
+}
+
+// NICConfig describes a VM network card
+type NICConfig struct {
+	Network VMNetwork
+	MAC     string // Required; see StableMAC
+	Model   string // virtio, e1000 or rtl8139; defaults to virtio
+	Queues  int    // virtio multiqueue count; 0 or 1 disables multiqueue
+	MTU     int    // 0 keeps the network's MTU
+}
+
+// ValidateMTU checks a NIC MTU; libvirt only sets it on virtio NICs
+func ValidateMTU(mtu int, model string) error {
+	if mtu == 0 {
+		return nil
+	}
+	if mtu < 68 || mtu > 65535 {
+		return fmt.Errorf("invalid MTU %d (use 68 to 65535, e.g. 9000 for jumbo frames)", mtu)
+	}
+	if model != "" && model != "virtio" {
+		return fmt.Errorf("an MTU requires the virtio network model (got %s)", model)
+	}
+	return nil
+}
+
+// nic returns the configuration of a new VM's network card
+func (config VMConfig) nic(name string) NICConfig {
+	mac := config.MAC
+	if mac == "" {
+		mac = StableMAC(name, 0)
+	}
+	return NICConfig{Network: config.Network, MAC: mac, Model: config.NetModel, Queues: config.NetQueues, MTU: config.MTU}
+}
+
+// newInterface returns the <interface> device for a network card
+func newInterface(nic NICConfig) DomainInterface {
+	netInterface := DomainInterface{Type: "user"}
+	switch nic.Network.Mode {
+	case NetworkBridge:
+		netInterface.Type = "bridge"
+		netInterface.Source.Bridge = nic.Network.Source
+	case NetworkNAT:
+		netInterface.Type = "network"
+		netInterface.Source.Network = nic.Network.Source
+	}
+
+	netInterface.MAC = &DomainMAC{Address: nic.MAC}
+	netInterface.Model.Type = nic.Model
+	if netInterface.Model.Type == "" {
+		netInterface.Model.Type = "virtio"
+	}
+	if nic.Queues > 1 {
+		netInterface.Driver = &struct {
+			Queues int `xml:"queues,attr,omitempty"`
+		}{Queues: nic.Queues}
+	}
+	if nic.MTU > 0 {
+		netInterface.MTU = &DomainMTU{Size: nic.MTU}
+	}
+	return netInterface
+}
+
+// interfaceXML returns the <interface> element for a network card
+func interfaceXML(nic NICConfig) ([]byte, error) {
+	data, err := xml.MarshalIndent(struct {
+		XMLName xml.Name `xml:"interface"`
+		DomainInterface
+	}{DomainInterface: newInterface(nic)}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode interface: %w", err)
+	}
+	return data, nil
+}
+
+// AddInterface adds a network card to a VM's saved configuration and, with live, to
+// the running VM
+func (c *Client) AddInterface(vmName string, nic NICConfig, live bool) error {
+	data, err := interfaceXML(nic)
+	if err != nil {
+		return err
+	}
+
+	flags := "--config"
+	if live {
+		flags += " --live"
+	}
+	output, err := c.attachDevice(vmName, "interface", data, flags)
+	if err != nil {
+		return fmt.Errorf("failed to add interface %s to VM '%s': %w\nOutput: %s", nic.MAC, vmName, err, output)
+	}
+	return nil
+}
+
+// SourceName returns what an interface is connected to: a bridge, network or host
+// device name, or its type
+func (iface DomainInterface) SourceName() string {
+	switch {
+	case iface.Source.Bridge != "":
+		return iface.Source.Bridge
+	case iface.Source.Network != "":
+		return iface.Source.Network
+	case iface.Source.Dev != "":
+		return iface.Source.Dev
+	}
+	return iface.Type
+}
+
+// NetworkDef is a libvirt network definition
+type NetworkDef struct {
+	XMLName xml.Name `xml:"network"`
+	Name    string   `xml:"name"`
+	Forward *struct {
+		Mode string `xml:"mode,attr"`
+	} `xml:"forward,omitempty"`
+	Bridge struct {
+		Name  string `xml:"name,attr,omitempty"`
+		STP   string `xml:"stp,attr,omitempty"`
+		Delay string `xml:"delay,attr,omitempty"`
+	} `xml:"bridge"`
+	IP []NetworkIP `xml:"ip"`
+}
+
+// NetworkIP is a network's <ip> element: the NAS's address on it and its DHCP range
+type NetworkIP struct {
+	Address string `xml:"address,attr"`
+	Netmask string `xml:"netmask,attr,omitempty"`
+	Prefix  string `xml:"prefix,attr,omitempty"`
+	DHCP    *struct {
+		Range []NetworkDHCPRange `xml:"range"`
+	} `xml:"dhcp,omitempty"`
+}
+
+// NetworkDHCPRange is a <dhcp><range> element
+type NetworkDHCPRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+// Network is a libvirt network on the NAS
+type Network struct {
+	Name       string `json:"name"`
+	Active     bool   `json:"active"`
+	Autostart  bool   `json:"autostart"`
+	Persistent bool   `json:"persistent"`
+	Forward    string `json:"forward,omitempty"` // nat, route, ...; "" for isolated networks
+	Bridge     string `json:"bridge,omitempty"`
+	Subnet     string `json:"subnet,omitempty"`
+	Gateway    string `json:"gateway,omitempty"`
+	DHCPStart  string `json:"dhcp_start,omitempty"`
+	DHCPEnd    string `json:"dhcp_end,omitempty"`
+}
+
+// networkNamePattern matches the network names qnap-vm creates
+var networkNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// NewNATNetwork returns the definition of a NAT network. gateway is the NAS's address
+// on it in CIDR form, such as 192.168.100.1/24; dhcpRange is "START,END" or "" for the
+// addresses from the gateway up.
+func NewNATNetwork(name, gateway, dhcpRange string) (*NetworkDef, error) {
+	if !networkNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid network name %q (use letters, digits, '.', '_' and '-')", name)
+	}
+	ip, subnet, err := net.ParseCIDR(gateway)
+	if err != nil || ip.To4() == nil {
+		return nil, fmt.Errorf("invalid subnet %q (expected the gateway address in CIDR form, e.g. 192.168.100.1/24)", gateway)
+	}
+	ones, _ := subnet.Mask.Size()
+	if ones < 8 || ones > 29 {
+		return nil, fmt.Errorf("subnet %s is too large or too small for a VM network (use /8 to /29)", subnet)
+	}
+	// Host addresses run from after the network address to before the broadcast address
+	base, mask := ipToUint(subnet.IP), ipToUint(net.IP(subnet.Mask))
+	first, last := base+1, (base|^mask)-1
+	gw := ipToUint(ip)
+	if gw < first || gw > last {
+		return nil, fmt.Errorf("%s is not a host address in %s", ip, subnet)
+	}
+
+	var start, end uint32
+	if dhcpRange == "" {
+		start, end = gw+1, last
+		if gw == last {
+			start, end = first, gw-1
+		}
+	} else {
+		startSpec, endSpec, ok := strings.Cut(dhcpRange, ",")
+		startIP, endIP := net.ParseIP(strings.TrimSpace(startSpec)).To4(), net.ParseIP(strings.TrimSpace(endSpec)).To4()
+		if !ok || startIP == nil || endIP == nil {
+			return nil, fmt.Errorf("invalid DHCP range %q (expected START,END, e.g. 192.168.100.10,192.168.100.99)", dhcpRange)
+		}
+		start, end = ipToUint(startIP), ipToUint(endIP)
+		if start < first || end > last || start > end {
+			return nil, fmt.Errorf("DHCP range %s is not an ascending range of host addresses in %s", dhcpRange, subnet)
+		}
+		if gw >= start && gw <= end {
+			return nil, fmt.Errorf("DHCP range %s includes the gateway %s", dhcpRange, ip)
+		}
+	}
+
+	def := &NetworkDef{Name: name}
+	def.Forward = &struct {
+		Mode string `xml:"mode,attr"`
+	}{Mode: "nat"}
+	// libvirt names the bridge virbrN itself
+	def.Bridge.STP, def.Bridge.Delay = "on", "0"
+	def.IP = []NetworkIP{{Address: ip.String(), Netmask: net.IP(subnet.Mask).String()}}
+	def.IP[0].DHCP = &struct {
+		Range []NetworkDHCPRange `xml:"range"`
+	}{Range: []NetworkDHCPRange{{Start: uintToIP(start).String(), End: uintToIP(end).String()}}}
+	return def, nil
+}
+
+// ipToUint returns an IPv4 address as a number
+func ipToUint(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+// uintToIP returns the IPv4 address for a number
+func uintToIP(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+// ListNetworks returns the libvirt networks on the NAS
+func (c *Client) ListNetworks() ([]Network, error) {
+	output, err := c.execVirsh("net-list --all")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w\nOutput: %s", err, output)
+	}
+	networks := parseNetworkList(output)
+	for i := range networks {
+		def, err := c.networkDef(networks[i].Name)
+		if err != nil {
+			return nil, err
+		}
+		describeNetwork(&networks[i], def)
+	}
+	return networks, nil
+}
+
+// GetNetwork returns a libvirt network by name
+func (c *Client) GetNetwork(name string) (*Network, error) {
+	networks, err := c.ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+	for i := range networks {
+		if networks[i].Name == name {
+			return &networks[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no network '%s' (see 'qnap-vm network list')", name)
+}
+
+// parseNetworkList parses 'virsh net-list --all' output
+func parseNetworkList(output string) []Network {
+	var networks []Network
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 4 || fields[0] == "Name" {
+			continue
+		}
+		networks = append(networks, Network{
+			Name:       fields[0],
+			Active:     fields[1] == "active",
+			Autostart:  fields[2] == "yes",
+			Persistent: fields[3] == "yes",
+		})
+	}
+	return networks
+}
+
+// networkDef reads a network's definition
+func (c *Client) networkDef(name string) (*NetworkDef, error) {
+	output, err := c.execVirsh(fmt.Sprintf("net-dumpxml %s", ssh.Quote(name)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read network '%s': %w\nOutput: %s", name, err, output)
+	}
+	var def NetworkDef
+	if err := xml.Unmarshal([]byte(output), &def); err != nil {
+		return nil, fmt.Errorf("failed to parse network '%s': %w", name, err)
+	}
+	return &def, nil
+}
+
+// describeNetwork fills in a network's bridge, subnet and DHCP range from its definition
+func describeNetwork(network *Network, def *NetworkDef) {
+	if def.Forward != nil {
+		network.Forward = def.Forward.Mode
+	}
+	network.Bridge = def.Bridge.Name
+	for _, ip := range def.IP {
+		addr := net.ParseIP(ip.Address).To4()
+		if addr == nil {
+			continue // IPv6
+		}
+		mask := net.IPMask(net.ParseIP(ip.Netmask).To4())
+		if ip.Prefix != "" {
+			prefix, _ := strconv.Atoi(ip.Prefix)
+			mask = net.CIDRMask(prefix, 32)
+		}
+		if mask == nil {
+			continue
+		}
+		network.Gateway = addr.String()
+		network.Subnet = (&net.IPNet{IP: addr.Mask(mask), Mask: mask}).String()
+		if ip.DHCP != nil && len(ip.DHCP.Range) > 0 {
+			network.DHCPStart, network.DHCPEnd = ip.DHCP.Range[0].Start, ip.DHCP.Range[0].End
+		}
+		return
+	}
+}
+
+// DefineNetwork defines a persistent libvirt network
+func (c *Client) DefineNetwork(def *NetworkDef) error {
+	data, err := xml.MarshalIndent(def, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode network '%s': %w", def.Name, err)
+	}
+
+	// 'host cleanup' removes leftover files by prefix
+	xmlFile := ssh.Quote(fmt.Sprintf("/tmp/qnap-vm-net-%s.xml", fileSafeName(def.Name)))
+	if _, err := c.sshClient.ExecuteContext(c.commandContext(), fmt.Sprintf("cat > %s << 'EOF'\n%s\nEOF", xmlFile, data)); err != nil {
+		return fmt.Errorf("failed to create XML file: %w", err)
+	}
+	output, err := c.execVirsh(fmt.Sprintf("net-define %s", xmlFile))
+	_, _ = c.sshClient.ExecuteContext(c.commandContext(), fmt.Sprintf("rm -f %s", xmlFile))
+	if err != nil {
+		return fmt.Errorf("failed to define network '%s': %w\nOutput: %s", def.Name, err, output)
+	}
+	return nil
+}
+
+// StartNetwork starts a libvirt network
+func (c *Client) StartNetwork(name string) error {
+	output, err := c.execVirsh(fmt.Sprintf("net-start %s", ssh.Quote(name)))
+	if err != nil {
+		return fmt.Errorf("failed to start network '%s': %w\nOutput: %s", name, err, output)
+	}
+	return nil
+}
+
+// SetNetworkAutostart sets whether a network starts with libvirt
+func (c *Client) SetNetworkAutostart(name string, autostart bool) error {
+	cmd := fmt.Sprintf("net-autostart %s", ssh.Quote(name))
+	if !autostart {
+		cmd += " --disable"
+	}
+	output, err := c.execVirsh(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to set autostart of network '%s': %w\nOutput: %s", name, err, output)
+	}
+	return nil
+}
+
+// DeleteNetwork stops a libvirt network if it is active and removes its definition
+func (c *Client) DeleteNetwork(name string) error {
+	network, err := c.GetNetwork(name)
+	if err != nil {
+		return err
+	}
+	if network.Active {
+		if output, err := c.execVirsh(fmt.Sprintf("net-destroy %s", ssh.Quote(name))); err != nil {
+			return fmt.Errorf("failed to stop network '%s': %w\nOutput: %s", name, err, output)
+		}
+	}
+	if network.Persistent {
+		if output, err := c.execVirsh(fmt.Sprintf("net-undefine %s", ssh.Quote(name))); err != nil {
+			return fmt.Errorf("failed to undefine network '%s': %w\nOutput: %s", name, err, output)
+		}
+	}
+	return nil
+}
+
+// NetworkUsers returns the VMs with an interface on a libvirt network
+func (c *Client) NetworkUsers(name string) ([]string, error) {
+	vms, err := c.ListVMs()
+	if err != nil {
+		return nil, err
+	}
+	var users []string
+	for _, vm := range vms {
+		domain, err := c.GetDomain(vm.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, iface := range domain.Devices.Interface {
+			if iface.Type == "network" && iface.Source.Network == name {
+				users = append(users, vm.Name)
+				break
+			}
+		}
+	}
+	return users, nil
+}
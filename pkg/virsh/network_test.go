@@ -0,0 +1,179 @@
+package virsh
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    VMNetwork
+		wantErr bool
+	}{
+		{"", VMNetwork{Mode: NetworkUser}, false},
+		{"user", VMNetwork{Mode: NetworkUser}, false},
+		{"bridge=qvs0", VMNetwork{Mode: NetworkBridge, Source: "qvs0"}, false},
+		{"network=lab", VMNetwork{Mode: NetworkNAT, Source: "lab"}, false},
+		{"bridge=", VMNetwork{}, true},
+		{"qvs0", VMNetwork{}, true},
+		{"macvtap=eth0", VMNetwork{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseNetwork(tt.spec)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseNetwork(%q) = %+v, %v; want %+v, error %v", tt.spec, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGenerateDomainXMLBridge(t *testing.T) {
+	c := &Client{}
+	domainXML, err := c.generateDomainXML("web", VMConfig{Memory: 1024, CPUs: 1, Network: VMNetwork{Mode: NetworkBridge, Source: "qvs0"}})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	var domain VMDomain
+	if err := xml.Unmarshal([]byte(domainXML), &domain); err != nil {
+		t.Fatalf("failed to parse domain XML: %v", err)
+	}
+	if len(domain.Devices.Interface) != 1 {
+		t.Fatalf("interfaces = %+v", domain.Devices.Interface)
+	}
+	iface := domain.Devices.Interface[0]
+	if iface.Type != "bridge" || iface.Source.Bridge != "qvs0" || iface.Model.Type != "virtio" {
+		t.Errorf("interface = %+v, want a virtio NIC on bridge qvs0", iface)
+	}
+}
+
+func TestNewNATNetwork(t *testing.T) {
+	def, err := NewNATNetwork("lab", "192.168.100.1/24", "")
+	if err != nil {
+		t.Fatalf("NewNATNetwork failed: %v", err)
+	}
+	data, err := xml.Marshal(def)
+	if err != nil {
+		t.Fatalf("failed to encode network: %v", err)
+	}
+	var parsed NetworkDef
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("failed to parse network XML: %v", err)
+	}
+	var network Network
+	describeNetwork(&network, &parsed)
+	want := Network{Forward: "nat", Subnet: "192.168.100.0/24", Gateway: "192.168.100.1", DHCPStart: "192.168.100.2", DHCPEnd: "192.168.100.254"}
+	if network != want {
+		t.Errorf("network = %+v, want %+v", network, want)
+	}
+
+	// A gateway at the top of the subnet gets the addresses below it
+	def, err = NewNATNetwork("top", "10.0.0.254/24", "")
+	if err != nil {
+		t.Fatalf("NewNATNetwork failed: %v", err)
+	}
+	if r := def.IP[0].DHCP.Range[0]; r.Start != "10.0.0.1" || r.End != "10.0.0.253" {
+		t.Errorf("DHCP range = %+v", r)
+	}
+
+	def, err = NewNATNetwork("ci", "10.10.0.1/24", "10.10.0.100,10.10.0.199")
+	if err != nil {
+		t.Fatalf("NewNATNetwork failed: %v", err)
+	}
+	if r := def.IP[0].DHCP.Range[0]; r.Start != "10.10.0.100" || r.End != "10.10.0.199" {
+		t.Errorf("DHCP range = %+v", r)
+	}
+
+	for _, tt := range []struct{ name, gateway, dhcpRange string }{
+		{"bad name", "192.168.100.1/24", ""},
+		{"lab", "192.168.100.1", ""},
+		{"lab", "192.168.100.0/24", ""},
+		{"lab", "192.168.100.255/24", ""},
+		{"lab", "fd00::1/64", ""},
+		{"lab", "192.168.100.1/30", ""},
+		{"lab", "192.168.100.1/24", "192.168.100.50"},
+		{"lab", "192.168.100.1/24", "192.168.100.99,192.168.100.50"},
+		{"lab", "192.168.100.1/24", "192.168.100.1,192.168.100.50"},
+		{"lab", "192.168.100.1/24", "192.168.100.10,192.168.101.10"},
+	} {
+		if _, err := NewNATNetwork(tt.name, tt.gateway, tt.dhcpRange); err == nil {
+			t.Errorf("NewNATNetwork(%q, %q, %q) succeeded", tt.name, tt.gateway, tt.dhcpRange)
+		}
+	}
+}
+
+func TestParseNetworkList(t *testing.T) {
+	output := ` Name      State      Autostart   Persistent
+----------------------------------------------
+ default   active     yes         yes
+ lab       inactive   no          yes
+`
+	networks := parseNetworkList(output)
+	want := []Network{
+		{Name: "default", Active: true, Autostart: true, Persistent: true},
+		{Name: "lab", Persistent: true},
+	}
+	if len(networks) != len(want) || networks[0] != want[0] || networks[1] != want[1] {
+		t.Errorf("parseNetworkList = %+v, want %+v", networks, want)
+	}
+}
+
+func TestDescribeNetworkPrefix(t *testing.T) {
+	var def NetworkDef
+	networkXML := `<network><name>default</name><forward mode='nat'/><bridge name='virbr0'/>
+  <ip family='ipv6' address='fd00::1' prefix='64'/>
+  <ip address='192.168.122.1' prefix='24'><dhcp><range start='192.168.122.2' end='192.168.122.254'/></dhcp></ip>
+</network>`
+	if err := xml.Unmarshal([]byte(networkXML), &def); err != nil {
+		t.Fatalf("failed to parse network XML: %v", err)
+	}
+	var network Network
+	describeNetwork(&network, &def)
+	if network.Bridge != "virbr0" || network.Subnet != "192.168.122.0/24" || network.DHCPEnd != "192.168.122.254" {
+		t.Errorf("network = %+v", network)
+	}
+}
+
+func TestValidateMTU(t *testing.T) {
+	for _, tt := range []struct {
+		mtu     int
+		model   string
+		wantErr bool
+	}{
+		{0, "e1000", false},
+		{9000, "virtio", false},
+		{1500, "", false},
+		{9000, "e1000", true},
+		{40, "virtio", true},
+		{70000, "virtio", true},
+	} {
+		if err := ValidateMTU(tt.mtu, tt.model); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateMTU(%d, %q) error = %v, want error %v", tt.mtu, tt.model, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNewInterface(t *testing.T) {
+	nic := NICConfig{Network: VMNetwork{Mode: NetworkNAT, Source: "lab"}, MAC: "52:54:00:12:34:56", MTU: 9000}
+	data, err := interfaceXML(nic)
+	if err != nil {
+		t.Fatalf("interfaceXML failed: %v", err)
+	}
+	want := `<interface type="network">
+  <mac address="52:54:00:12:34:56"></mac>
+  <source network="lab"></source>
+  <model type="virtio"></model>
+  <mtu size="9000"></mtu>
+</interface>`
+	if string(data) != want {
+		t.Errorf("interface = %s, want %s", data, want)
+	}
+	iface := newInterface(nic)
+	if iface.SourceName() != "lab" {
+		t.Errorf("SourceName = %q", iface.SourceName())
+	}
+
+	legacy := newInterface(NICConfig{MAC: "52:54:00:12:34:57", Model: "e1000"})
+	if legacy.Type != "user" || legacy.Model.Type != "e1000" || legacy.MTU != nil || legacy.SourceName() != "user" {
+		t.Errorf("interface = %+v", legacy)
+	}
+}
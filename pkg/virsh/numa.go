@@ -0,0 +1,157 @@
+package virsh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NUMA memory binding modes for NUMAConfig.Mode
+const (
+	NUMAModeStrict     = "strict"     // Only allocate from the nodeset
+	NUMAModePreferred  = "preferred"  // Prefer the nodeset, fall back to other nodes
+	NUMAModeInterleave = "interleave" // Spread allocations across the nodeset
+)
+
+// NUMAConfig lays a VM out in guest NUMA cells and binds its memory to host NUMA nodes
+type NUMAConfig struct {
+	Cells   int    // Guest NUMA cells; vCPUs and memory are split evenly between them
+	Nodeset string // Host NUMA nodes for the VM's memory, e.g. 0 or 0-1; "" leaves it unbound
+	Mode    string // Binding mode; defaults to strict
+}
+
+// DomainNUMA represents a <cpu><numa> element
+type DomainNUMA struct {
+	Cells []DomainNUMACell `xml:"cell"`
+}
+
+// DomainNUMACell represents a <cpu><numa><cell> element
+type DomainNUMACell struct {
+	ID     int    `xml:"id,attr"`
+	CPUs   string `xml:"cpus,attr"`
+	Memory int    `xml:"memory,attr"`
+	Unit   string `xml:"unit,attr"`
+}
+
+// DomainNUMATune represents the <numatune> element
+type DomainNUMATune struct {
+	Memory   DomainNUMAMemory    `xml:"memory"`
+	MemNodes []DomainNUMAMemNode `xml:"memnode"`
+}
+
+// DomainNUMAMemory represents a <numatune><memory> element
+type DomainNUMAMemory struct {
+	Mode    string `xml:"mode,attr"`
+	Nodeset string `xml:"nodeset,attr"`
+}
+
+// DomainNUMAMemNode represents a <numatune><memnode> element binding one guest cell
+type DomainNUMAMemNode struct {
+	CellID  int    `xml:"cellid,attr"`
+	Mode    string `xml:"mode,attr"`
+	Nodeset string `xml:"nodeset,attr"`
+}
+
+// ParseNUMAConfig parses a NUMA layout such as "cells=2,nodeset=0-1,mode=strict". Omitted
+// cells are 1 and the mode defaults to strict.
+func ParseNUMAConfig(spec string) (NUMAConfig, error) {
+	config := NUMAConfig{Cells: 1, Mode: NUMAModeStrict}
+	for _, part := range strings.Split(spec, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "cells":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return NUMAConfig{}, fmt.Errorf("invalid NUMA cell count '%s'", value)
+			}
+			config.Cells = n
+		case "nodeset":
+			if _, err := ParseCPUSet(value); err != nil {
+				return NUMAConfig{}, fmt.Errorf("invalid NUMA nodeset '%s' (use e.g. 0 or 0-1)", value)
+			}
+			config.Nodeset = value
+		case "mode":
+			if value != NUMAModeStrict && value != NUMAModePreferred && value != NUMAModeInterleave {
+				return NUMAConfig{}, fmt.Errorf("invalid NUMA mode '%s' (use strict, preferred or interleave)", value)
+			}
+			config.Mode = value
+		default:
+			return NUMAConfig{}, fmt.Errorf("invalid NUMA option '%s' (use cells=N, nodeset=NODES and mode=MODE)", part)
+		}
+	}
+	return config, nil
+}
+
+// ValidateNUMAConfig checks a NUMA layout against a VM's vCPUs and memory and, when
+// known (non-zero), the number of host NUMA nodes
+func ValidateNUMAConfig(numa NUMAConfig, vcpus, memoryMB, hostNodes int) error {
+	if vcpus%numa.Cells != 0 {
+		return fmt.Errorf("%d vCPUs cannot be split evenly into %d NUMA cells", vcpus, numa.Cells)
+	}
+	if memoryMB < numa.Cells {
+		return fmt.Errorf("%d MB of memory cannot be split into %d NUMA cells", memoryMB, numa.Cells)
+	}
+	if numa.Nodeset == "" {
+		return nil
+	}
+	nodes, err := ParseCPUSet(numa.Nodeset)
+	if err != nil {
+		return err
+	}
+	if hostNodes > 0 && nodes[len(nodes)-1] >= hostNodes {
+		return fmt.Errorf("host NUMA node %d does not exist; the NAS has %d node(s) (0-%d)", nodes[len(nodes)-1], hostNodes, hostNodes-1)
+	}
+	return nil
+}
+
+// newDomainNUMA returns the guest NUMA cells for a VM, or nil for a single cell
+func newDomainNUMA(numa *NUMAConfig, vcpus, memoryMB int) *DomainNUMA {
+	if numa == nil || numa.Cells < 2 {
+		return nil
+	}
+	perCell := vcpus / numa.Cells
+	domain := &DomainNUMA{}
+	for cell := 0; cell < numa.Cells; cell++ {
+		cpus := strconv.Itoa(cell * perCell)
+		if perCell > 1 {
+			cpus += "-" + strconv.Itoa((cell+1)*perCell-1)
+		}
+		domain.Cells = append(domain.Cells, DomainNUMACell{
+			ID:     cell,
+			CPUs:   cpus,
+			Memory: memoryMB * 1024 / numa.Cells,
+			Unit:   "KiB",
+		})
+	}
+	return domain
+}
+
+// newNUMATune returns the <numatune> element binding a VM's memory, or nil without a
+// nodeset. When there is one host node per guest cell, each cell gets its own node.
+func newNUMATune(numa *NUMAConfig) *DomainNUMATune {
+	if numa == nil || numa.Nodeset == "" {
+		return nil
+	}
+	mode := numa.Mode
+	if mode == "" {
+		mode = NUMAModeStrict
+	}
+
+	tune := &DomainNUMATune{Memory: DomainNUMAMemory{Mode: mode, Nodeset: numa.Nodeset}}
+	nodes, err := ParseCPUSet(numa.Nodeset)
+	if err == nil && numa.Cells > 1 && len(nodes) == numa.Cells {
+		for cell, node := range nodes {
+			tune.MemNodes = append(tune.MemNodes, DomainNUMAMemNode{CellID: cell, Mode: mode, Nodeset: strconv.Itoa(node)})
+		}
+	}
+	return tune
+}
+
+// HostNUMANodes returns the number of NUMA nodes of the NAS
+func (c *Client) HostNUMANodes() (int, error) {
+	output, err := c.execVirsh("nodeinfo")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read host CPU information: %w\nOutput: %s", err, output)
+	}
+	return nodeInfoCount(output, "NUMA cell(s):")
+}
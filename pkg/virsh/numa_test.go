@@ -0,0 +1,81 @@
+package virsh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseNUMAConfig(t *testing.T) {
+	config, err := ParseNUMAConfig("cells=2,nodeset=0-1")
+	if err != nil {
+		t.Fatalf("ParseNUMAConfig failed: %v", err)
+	}
+	if config != (NUMAConfig{Cells: 2, Nodeset: "0-1", Mode: NUMAModeStrict}) {
+		t.Errorf("ParseNUMAConfig = %+v", config)
+	}
+
+	for _, spec := range []string{"cells=0", "nodeset=x", "mode=spread", "sockets=2"} {
+		if _, err := ParseNUMAConfig(spec); err == nil {
+			t.Errorf("ParseNUMAConfig(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestValidateNUMAConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    NUMAConfig
+		hostNodes int
+		wantErr   bool
+	}{
+		{"even split", NUMAConfig{Cells: 2, Nodeset: "0-1"}, 2, false},
+		{"uneven vCPUs", NUMAConfig{Cells: 3}, 0, true},
+		{"missing host node", NUMAConfig{Cells: 2, Nodeset: "0-2"}, 2, true},
+		{"unknown host nodes", NUMAConfig{Cells: 1, Nodeset: "3"}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateNUMAConfig(tt.config, 8, 8192, tt.hostNodes)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateNUMAConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateDomainXMLNUMA(t *testing.T) {
+	client := &Client{}
+	config := VMConfig{Memory: 8192, CPUs: 8, NUMA: &NUMAConfig{Cells: 2, Nodeset: "0-1", Mode: NUMAModeStrict}}
+
+	xml, err := client.generateDomainXML("numa", config)
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	for _, want := range []string{
+		`<cell id="0" cpus="0-3" memory="4194304" unit="KiB"></cell>`,
+		`<cell id="1" cpus="4-7" memory="4194304" unit="KiB"></cell>`,
+		`<memory mode="strict" nodeset="0-1"></memory>`,
+		`<memnode cellid="1" mode="strict" nodeset="1"></memnode>`,
+	} {
+		if !strings.Contains(xml, want) {
+			t.Errorf("Generated XML missing %s:\n%s", want, xml)
+		}
+	}
+}
+
+func TestGenerateDomainXMLNUMABindingOnly(t *testing.T) {
+	client := &Client{}
+	config := VMConfig{Memory: 2048, CPUs: 2, NUMA: &NUMAConfig{Cells: 1, Nodeset: "1", Mode: NUMAModePreferred}}
+
+	xml, err := client.generateDomainXML("numa", config)
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	if strings.Contains(xml, "<cpu") || strings.Contains(xml, "<memnode") {
+		t.Errorf("Generated XML has guest NUMA cells for a single cell:\n%s", xml)
+	}
+	if !strings.Contains(xml, `<memory mode="preferred" nodeset="1"></memory>`) {
+		t.Errorf("Generated XML missing NUMA memory binding:\n%s", xml)
+	}
+}
@@ -0,0 +1,183 @@
+package virsh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Guest OS families accepted by create --os
+const (
+	OSLinux   = "linux"
+	OSWindows = "windows"
+	OSFreeBSD = "freebsd"
+)
+
+// VirtioWinISO is the ISO library name of the virtio-win driver disc, downloaded from
+// VirtioWinURL when the library doesn't have it yet
+const (
+	VirtioWinISO = "virtio-win.iso"
+	VirtioWinURL = "https://fedorapeople.org/groups/virt/virtio-win/direct-downloads/stable-virtio/virtio-win.iso"
+)
+
+// OSPreset holds the defaults for a guest OS family or release. They apply only to
+// settings not given on the command line.
+type OSPreset struct {
+	Name   string
+	Family string // OSLinux, OSWindows or OSFreeBSD
+
+	Machine    string // Machine type family, e.g. q35; "" keeps create's default
+	UEFI       bool   // Boot with OVMF
+	SecureBoot bool   // Boot with Secure Boot OVMF (implies UEFI)
+	TPM        bool   // Add an emulated TPM 2.0
+
+	DiskBus       string // Boot disk bus
+	NICModel      string // Network card model
+	Video         string // Video model; "" keeps libvirt's default
+	Tablet        bool   // USB tablet for mouse tracking over VNC
+	LocalTime     bool   // Hardware clock in local time
+	VirtioDrivers bool   // Attach the virtio-win driver disc as an extra CD-ROM
+
+	// GuestAgent is how to install qemu-guest-agent in the guest, printed after create
+	GuestAgent string
+}
+
+// osPresets are the presets by family. Windows has no virtio drivers until they are
+// installed from the virtio-win disc, so it starts on SATA and e1000.
+var osPresets = map[string]OSPreset{
+	OSLinux: {
+		Name:       OSLinux,
+		Family:     OSLinux,
+		DiskBus:    "virtio",
+		NICModel:   "virtio",
+		GuestAgent: "install the qemu-guest-agent package",
+	},
+	OSWindows: {
+		Name:          OSWindows,
+		Family:        OSWindows,
+		DiskBus:       "sata",
+		NICModel:      "e1000",
+		Tablet:        true,
+		LocalTime:     true,
+		VirtioDrivers: true,
+		GuestAgent:    "run virtio-win-guest-tools.exe from the virtio-win CD-ROM",
+	},
+	OSFreeBSD: {
+		Name:       OSFreeBSD,
+		Family:     OSFreeBSD,
+		DiskBus:    "virtio",
+		NICModel:   "virtio",
+		GuestAgent: "pkg install qemu-guest-agent, then sysrc qemu_guest_agent_enable=YES",
+	},
+}
+
+// osVariants are presets for OS releases, like virt-install's --os-variant. Current
+// releases get the q35 chipset and UEFI; Windows 11 also needs Secure Boot and a TPM.
+var osVariants = map[string]OSPreset{
+	"ubuntu20.04": variant(OSLinux, "ubuntu20.04", func(p *OSPreset) {
+		p.Machine, p.GuestAgent = MachineQ35, "sudo apt install qemu-guest-agent"
+	}),
+	"ubuntu22.04": variant(OSLinux, "ubuntu22.04", func(p *OSPreset) {
+		p.Machine, p.UEFI, p.Video, p.GuestAgent = MachineQ35, true, "virtio", "sudo apt install qemu-guest-agent"
+	}),
+	"ubuntu24.04": variant(OSLinux, "ubuntu24.04", func(p *OSPreset) {
+		p.Machine, p.UEFI, p.Video, p.GuestAgent = MachineQ35, true, "virtio", "sudo apt install qemu-guest-agent"
+	}),
+	"debian11": variant(OSLinux, "debian11", func(p *OSPreset) {
+		p.Machine, p.GuestAgent = MachineQ35, "apt install qemu-guest-agent"
+	}),
+	"debian12": variant(OSLinux, "debian12", func(p *OSPreset) {
+		p.Machine, p.UEFI, p.Video, p.GuestAgent = MachineQ35, true, "virtio", "apt install qemu-guest-agent"
+	}),
+	"win10": variant(OSWindows, "win10", func(p *OSPreset) {
+		p.Machine, p.UEFI = MachineQ35, true
+	}),
+	"win11": variant(OSWindows, "win11", func(p *OSPreset) {
+		p.Machine, p.UEFI, p.SecureBoot, p.TPM = MachineQ35, true, true, true
+	}),
+	"win2022": variant(OSWindows, "win2022", func(p *OSPreset) {
+		p.Machine, p.UEFI = MachineQ35, true
+	}),
+	"freebsd13": variant(OSFreeBSD, "freebsd13", nil),
+	"freebsd14": variant(OSFreeBSD, "freebsd14", nil),
+}
+
+// osVariantAliases name the current release of a family
+var osVariantAliases = map[string]string{
+	"ubuntu":  "ubuntu24.04",
+	"debian":  "debian12",
+	"freebsd": "freebsd14",
+}
+
+// variant returns a family's preset renamed and adjusted for one release
+func variant(family, name string, adjust func(*OSPreset)) OSPreset {
+	preset := osPresets[family]
+	preset.Name = name
+	if adjust != nil {
+		adjust(&preset)
+	}
+	return preset
+}
+
+// LookupOSPreset returns the preset for an OS family; "" returns an empty preset
+// that changes nothing
+func LookupOSPreset(name string) (OSPreset, error) {
+	if name == "" {
+		return OSPreset{}, nil
+	}
+	preset, ok := osPresets[strings.ToLower(name)]
+	if !ok {
+		return OSPreset{}, fmt.Errorf("unknown OS '%s' (use %s)", name, strings.Join(OSNames(), ", "))
+	}
+	return preset, nil
+}
+
+// LookupOSVariant returns the preset for an OS release such as ubuntu22.04 or win11,
+// or for a family's current release (ubuntu, debian, freebsd)
+func LookupOSVariant(name string) (OSPreset, error) {
+	name = strings.ToLower(name)
+	if alias, ok := osVariantAliases[name]; ok {
+		name = alias
+	}
+	preset, ok := osVariants[name]
+	if !ok {
+		return OSPreset{}, fmt.Errorf("unknown OS variant '%s' (use %s)", name, strings.Join(OSVariantNames(), ", "))
+	}
+	return preset, nil
+}
+
+// ResolveOSPreset returns the preset for create's --os and --os-variant flags. A
+// variant is more specific, so it is used when given; --os must then match its family.
+func ResolveOSPreset(family, variant string) (OSPreset, error) {
+	if variant == "" {
+		return LookupOSPreset(family)
+	}
+	preset, err := LookupOSVariant(variant)
+	if err != nil {
+		return OSPreset{}, err
+	}
+	if family != "" && !strings.EqualFold(family, preset.Family) {
+		return OSPreset{}, fmt.Errorf("OS variant '%s' is %s, not %s", preset.Name, preset.Family, family)
+	}
+	return preset, nil
+}
+
+// OSNames returns the OS families with presets, sorted
+func OSNames() []string {
+	return sortedKeys(osPresets)
+}
+
+// OSVariantNames returns the OS releases with presets, sorted
+func OSVariantNames() []string {
+	return sortedKeys(osVariants)
+}
+
+// sortedKeys returns the names of presets, sorted
+func sortedKeys(presets map[string]OSPreset) []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
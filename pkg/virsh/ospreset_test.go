@@ -0,0 +1,62 @@
+package virsh
+
+import "testing"
+
+func TestLookupOSPreset(t *testing.T) {
+	preset, err := LookupOSPreset("Windows")
+	if err != nil {
+		t.Fatalf("LookupOSPreset(Windows) error = %v", err)
+	}
+	if preset.DiskBus != "sata" || preset.NICModel != "e1000" || !preset.VirtioDrivers || !preset.LocalTime {
+		t.Errorf("LookupOSPreset(Windows) = %+v", preset)
+	}
+
+	if preset, err := LookupOSPreset(""); err != nil || preset != (OSPreset{}) {
+		t.Errorf("LookupOSPreset(\"\") = %+v, %v, want empty preset", preset, err)
+	}
+	if _, err := LookupOSPreset("plan9"); err == nil {
+		t.Error("LookupOSPreset(plan9) should fail")
+	}
+}
+
+func TestResolveOSPreset(t *testing.T) {
+	for _, tt := range []struct {
+		family, variant string
+		wantName        string
+		wantErr         bool
+	}{
+		{"", "", "", false},
+		{"windows", "", "windows", false},
+		{"", "win11", "win11", false},
+		{"windows", "WIN11", "win11", false},
+		{"", "ubuntu", "ubuntu24.04", false},
+		{"", "freebsd", "freebsd14", false},
+		{"linux", "win11", "", true},
+		{"", "ubuntu18.04", "", true},
+	} {
+		preset, err := ResolveOSPreset(tt.family, tt.variant)
+		if (err != nil) != tt.wantErr || preset.Name != tt.wantName {
+			t.Errorf("ResolveOSPreset(%q, %q) = %q, %v, want %q (error %v)", tt.family, tt.variant, preset.Name, err, tt.wantName, tt.wantErr)
+		}
+	}
+}
+
+func TestOSVariants(t *testing.T) {
+	win11, err := LookupOSVariant("win11")
+	if err != nil {
+		t.Fatalf("LookupOSVariant(win11) error = %v", err)
+	}
+	if win11.Machine != MachineQ35 || !win11.SecureBoot || !win11.TPM || win11.DiskBus != "sata" || !win11.VirtioDrivers {
+		t.Errorf("LookupOSVariant(win11) = %+v", win11)
+	}
+
+	for _, name := range OSVariantNames() {
+		preset, _ := LookupOSVariant(name)
+		if preset.Name != name || osPresets[preset.Family].Family != preset.Family {
+			t.Errorf("OS variant %s has name %q and family %q", name, preset.Name, preset.Family)
+		}
+		if preset.SecureBoot && !IsQ35(preset.Machine) {
+			t.Errorf("OS variant %s uses Secure Boot without the q35 chipset", name)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package virsh
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// DiskOverlay is a temporary external snapshot layer that takes a running VM's writes
+// while its base image is read, e.g. by a backup
+type DiskOverlay struct {
+	Target string // Guest device name, e.g. vda
+	Base   string // Image frozen underneath the overlay
+	Path   string // Overlay file receiving writes
+}
+
+// overlayDiskspecs builds the --diskspec arguments placing an overlay named after
+// name next to each file-backed disk; other disks are left out of the snapshot
+func overlayDiskspecs(disks []DomainDisk, name string) ([]string, []DiskOverlay) {
+	var specs []string
+	var overlays []DiskOverlay
+	for _, disk := range disks {
+		if disk.Source.File == "" {
+			specs = append(specs, fmt.Sprintf("--diskspec %s", ssh.Quote(disk.Target.Dev+",snapshot=no")))
+			continue
+		}
+
+		overlay := DiskOverlay{
+			Target: disk.Target.Dev,
+			Base:   disk.Source.File,
+			Path:   disk.Source.File + "." + name,
+		}
+		specs = append(specs, fmt.Sprintf("--diskspec %s", ssh.Quote(fmt.Sprintf("%s,snapshot=external,file=%s", overlay.Target, overlay.Path))))
+		overlays = append(overlays, overlay)
+	}
+	return specs, overlays
+}
+
+// CreateDiskOverlays atomically redirects a running VM's writes for each file-backed disk
+// into a new overlay, leaving the base images unchanged until CommitDiskOverlay.
+// With quiesce the guest agent freezes filesystems so the base images are consistent.
+func (c *Client) CreateDiskOverlays(vmName, name string, disks []DomainDisk, quiesce bool) ([]DiskOverlay, error) {
+	specs, overlays := overlayDiskspecs(disks, name)
+	if len(overlays) == 0 {
+		return nil, fmt.Errorf("VM '%s' has no file-backed disks", vmName)
+	}
+
+	cmd := fmt.Sprintf("snapshot-create-as %s --name %s --disk-only --atomic --no-metadata %s",
+		domainArg(vmName), ssh.Quote(name), strings.Join(specs, " "))
+	if quiesce {
+		cmd += " --quiesce"
+	}
+
+	output, err := c.execVirsh(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create disk overlays for VM '%s': %w\nOutput: %s", vmName, err, output)
+	}
+	return overlays, nil
+}
+
+// CommitDiskOverlay merges an overlay's writes back into its base image, pivots the VM
+// onto the base image again and removes the overlay file
+func (c *Client) CommitDiskOverlay(vmName string, overlay DiskOverlay) error {
+	cmd := fmt.Sprintf("blockcommit %s %s --active --pivot --wait", domainArg(vmName), ssh.Quote(overlay.Target))
+	output, err := c.execVirsh(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to merge overlay %s into %s for VM '%s': %w\nOutput: %s", overlay.Path, overlay.Base, vmName, err, output)
+	}
+
+	// Older libvirt only pivots the live definition; keep the persistent one on the base image
+	disks, err := c.ListDisks(vmName)
+	if err != nil {
+		return err
+	}
+	for _, disk := range disks {
+		if disk.Source.File == overlay.Path {
+			if err := c.SetDiskSource(vmName, overlay.Path, overlay.Base); err != nil {
+				return err
+			}
+		}
+	}
+
+	if output, err := c.sshClient.ExecuteContext(c.commandContext(), fmt.Sprintf("rm -f %s", ssh.Quote(overlay.Path))); err != nil {
+		return fmt.Errorf("failed to remove overlay %s: %w\nOutput: %s", overlay.Path, err, output)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package virsh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOverlayDiskspecs(t *testing.T) {
+	var fileDisk, lunDisk DomainDisk
+	fileDisk.Source.File = "/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2"
+	fileDisk.Target.Dev = "vda"
+	lunDisk.Source.Dev = "/dev/disk/by-id/lun0"
+	lunDisk.Target.Dev = "vdb"
+
+	specs, overlays := overlayDiskspecs([]DomainDisk{fileDisk, lunDisk}, "backup-1")
+
+	want := []string{
+		"--diskspec 'vda,snapshot=external,file=/share/CACHEDEV1_DATA/.qnap-vm/disks/web.qcow2.backup-1'",
+		"--diskspec 'vdb,snapshot=no'",
+	}
+	if strings.Join(specs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("overlayDiskspecs() specs = %q, want %q", specs, want)
+	}
+
+	if len(overlays) != 1 {
+		t.Fatalf("overlayDiskspecs() returned %d overlays, want 1", len(overlays))
+	}
+	if overlays[0].Target != "vda" || overlays[0].Base != fileDisk.Source.File || overlays[0].Path != fileDisk.Source.File+".backup-1" {
+		t.Errorf("overlayDiskspecs() overlay = %+v", overlays[0])
+	}
+}
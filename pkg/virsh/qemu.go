@@ -0,0 +1,158 @@
+package virsh
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// QemuNamespace is the libvirt XML namespace for QEMU command-line passthrough
+const QemuNamespace = "http://libvirt.org/schemas/domain/qemu/1.0"
+
+// managedQemuOptions are QEMU options generated from the domain definition.
+// Passing them again through qemu:commandline conflicts with libvirt.
+var managedQemuOptions = map[string]string{
+	"-name":       "the VM name",
+	"-uuid":       "the VM UUID",
+	"-m":          "--memory",
+	"-smp":        "--cpus",
+	"-machine":    "the machine type",
+	"-M":          "the machine type",
+	"-enable-kvm": "the domain type",
+	"-monitor":    "the libvirt monitor",
+	"-qmp":        "the libvirt monitor",
+	"-daemonize":  "libvirt process management",
+}
+
+// QemuCommandline represents the <qemu:commandline> element
+type QemuCommandline struct {
+	XMLName xml.Name  `xml:"qemu:commandline"`
+	Args    []QemuArg `xml:"qemu:arg"`
+}
+
+// QemuArg represents a single <qemu:arg> element
+type QemuArg struct {
+	Value string `xml:"value,attr"`
+}
+
+// newQemuCommandline builds a qemu:commandline element from raw arguments
+func newQemuCommandline(args []string) *QemuCommandline {
+	cmdline := &QemuCommandline{}
+	for _, arg := range args {
+		cmdline.Args = append(cmdline.Args, QemuArg{Value: arg})
+	}
+	return cmdline
+}
+
+// ValidateQemuArgs rejects passthrough arguments that would override options libvirt already manages
+func ValidateQemuArgs(args []string) error {
+	for _, arg := range args {
+		if arg == "" {
+			return fmt.Errorf("empty QEMU argument")
+		}
+		if managed, ok := managedQemuOptions[arg]; ok {
+			return fmt.Errorf("QEMU option '%s' is managed by libvirt (set via %s) and cannot be passed through", arg, managed)
+		}
+	}
+	return nil
+}
+
+// GetQemuArgs returns the QEMU passthrough arguments in a VM's persistent definition
+func (c *Client) GetQemuArgs(vmName string) ([]string, error) {
+	domainXML, err := c.dumpInactiveXML(vmName)
+	if err != nil {
+		return nil, err
+	}
+	return parseQemuArgs(domainXML)
+}
+
+// SetQemuArgs replaces the QEMU passthrough arguments in a VM's persistent definition.
+// The rest of the domain XML is left untouched. Changes apply on the next VM start.
+func (c *Client) SetQemuArgs(vmName string, args []string) error {
+	if err := ValidateQemuArgs(args); err != nil {
+		return err
+	}
+
+	domainXML, err := c.dumpInactiveXML(vmName)
+	if err != nil {
+		return err
+	}
+
+	updated, err := replaceQemuCommandline(domainXML, args)
+	if err != nil {
+		return err
+	}
+
+	return c.defineXML(vmName, updated)
+}
+
+// parseQemuArgs extracts qemu:arg values from domain XML
+func parseQemuArgs(domainXML string) ([]string, error) {
+	var args []string
+
+	decoder := xml.NewDecoder(strings.NewReader(domainXML))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse domain XML: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Space != QemuNamespace || start.Name.Local != "arg" {
+			continue
+		}
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "value" {
+				args = append(args, attr.Value)
+			}
+		}
+	}
+
+	return args, nil
+}
+
+// replaceQemuCommandline swaps the qemu:commandline block in domain XML for one built from args,
+// declaring the qemu namespace on the root element when needed
+func replaceQemuCommandline(domainXML string, args []string) (string, error) {
+	// Remove any existing block
+	if start := strings.Index(domainXML, "<qemu:commandline"); start >= 0 {
+		end := strings.Index(domainXML[start:], "</qemu:commandline>")
+		if end < 0 {
+			return "", fmt.Errorf("malformed qemu:commandline element in domain XML")
+		}
+		end += start + len("</qemu:commandline>")
+		domainXML = strings.TrimRight(domainXML[:start], " \t\n") + "\n" + strings.TrimLeft(domainXML[end:], " \t\n")
+	}
+
+	if len(args) == 0 {
+		return domainXML, nil
+	}
+
+	closing := strings.LastIndex(domainXML, "</domain>")
+	if closing < 0 {
+		return "", fmt.Errorf("domain XML has no closing </domain> element")
+	}
+
+	block, err := xml.MarshalIndent(newQemuCommandline(args), "  ", "  ")
+	if err != nil {
+		return "", err
+	}
+	domainXML = strings.TrimRight(domainXML[:closing], " \t\n") + "\n" +
+		string(block) + "\n" + domainXML[closing:]
+
+	// Declare the namespace on the root element
+	if !strings.Contains(domainXML, "xmlns:qemu=") {
+		rootEnd := strings.Index(domainXML, "<domain")
+		if rootEnd < 0 {
+			return "", fmt.Errorf("domain XML has no <domain> element")
+		}
+		rootEnd += len("<domain")
+		domainXML = domainXML[:rootEnd] + fmt.Sprintf(" xmlns:qemu='%s'", QemuNamespace) + domainXML[rootEnd:]
+	}
+
+	return domainXML, nil
+}
@@ -0,0 +1,90 @@
+package virsh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateDomainXMLQemuArgs(t *testing.T) {
+	client := &Client{qvsPath: "/QVS"}
+
+	xmlStr, err := client.generateDomainXML("test-vm", VMConfig{
+		Memory:   1024,
+		CPUs:     1,
+		QemuArgs: []string{"-device", "virtio-balloon-pci"},
+	})
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+
+	if !strings.Contains(xmlStr, `xmlns:qemu="`+QemuNamespace+`"`) {
+		t.Errorf("Expected qemu namespace declaration, got:\n%s", xmlStr)
+	}
+
+	args, err := parseQemuArgs(xmlStr)
+	if err != nil {
+		t.Fatalf("parseQemuArgs failed: %v", err)
+	}
+	if len(args) != 2 || args[0] != "-device" || args[1] != "virtio-balloon-pci" {
+		t.Errorf("Unexpected QEMU args: %v", args)
+	}
+}
+
+func TestReplaceQemuCommandline(t *testing.T) {
+	original := `<domain type='kvm'>
+  <name>test-vm</name>
+  <devices>
+    <emulator>/QVS/usr/bin/qemu-system-x86_64</emulator>
+  </devices>
+</domain>
+`
+
+	updated, err := replaceQemuCommandline(original, []string{"-global", "kvm-pit.lost_tick_policy=discard"})
+	if err != nil {
+		t.Fatalf("replaceQemuCommandline failed: %v", err)
+	}
+	if !strings.Contains(updated, "<domain xmlns:qemu='"+QemuNamespace+"' type='kvm'>") {
+		t.Errorf("Expected namespace on root element, got:\n%s", updated)
+	}
+	if !strings.Contains(updated, "<emulator>/QVS/usr/bin/qemu-system-x86_64</emulator>") {
+		t.Error("Expected the rest of the domain to be preserved")
+	}
+
+	args, err := parseQemuArgs(updated)
+	if err != nil {
+		t.Fatalf("parseQemuArgs failed: %v", err)
+	}
+	if len(args) != 2 || args[1] != "kvm-pit.lost_tick_policy=discard" {
+		t.Errorf("Unexpected QEMU args: %v", args)
+	}
+
+	// Replacing again keeps a single block
+	replaced, err := replaceQemuCommandline(updated, []string{"-no-hpet"})
+	if err != nil {
+		t.Fatalf("replaceQemuCommandline failed: %v", err)
+	}
+	if strings.Count(replaced, "<qemu:commandline>") != 1 {
+		t.Errorf("Expected exactly one qemu:commandline block, got:\n%s", replaced)
+	}
+
+	// Clearing removes the block
+	cleared, err := replaceQemuCommandline(replaced, nil)
+	if err != nil {
+		t.Fatalf("replaceQemuCommandline failed: %v", err)
+	}
+	if args, _ := parseQemuArgs(cleared); len(args) != 0 {
+		t.Errorf("Expected no QEMU args after clearing, got %v", args)
+	}
+}
+
+func TestValidateQemuArgs(t *testing.T) {
+	if err := ValidateQemuArgs([]string{"-device", "virtio-balloon-pci"}); err != nil {
+		t.Errorf("Expected valid args, got %v", err)
+	}
+	if err := ValidateQemuArgs([]string{"-m", "4096"}); err == nil {
+		t.Error("Expected error for managed -m option")
+	}
+	if err := ValidateQemuArgs([]string{""}); err == nil {
+		t.Error("Expected error for empty argument")
+	}
+}
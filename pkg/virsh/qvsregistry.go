@@ -0,0 +1,275 @@
+package virsh
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// Virtualization Station lists VMs from its own SQLite database rather than from libvirt,
+// so domains qnap-vm defines are missing from its web UI until they have a row there. The
+// database layout is not documented and has moved between releases, so it is found by
+// shape: a table named like "vm" or "*_vms" with uuid and name columns, in a database
+// under the QVS installation path. Because of that it is only written to when enabled,
+// after a copy of the database is saved next to it.
+
+// qvsStoreScript lists every table of the SQLite databases under the QVS path with its
+// columns, as "db", "table" and "column" lines holding PRAGMA table_info output
+const qvsStoreScript = `command -v sqlite3 >/dev/null 2>&1 || { echo nosqlite; exit 0; }
+find {{QVS}} -maxdepth 4 -type f \( -name '*.db' -o -name '*.sqlite3' \) 2>/dev/null | while IFS= read -r db; do
+	printf 'db\t%s\n' "$db"
+	sqlite3 "$db" "SELECT name FROM sqlite_master WHERE type='table'" 2>/dev/null | while IFS= read -r table; do
+		printf 'table\t%s\n' "$table"
+		sqlite3 "$db" "PRAGMA table_info(\"$table\")" 2>/dev/null | while IFS= read -r column; do
+			printf 'column\t%s\n' "$column"
+		done
+	done
+done`
+
+// ErrNoQVSStore is returned when Virtualization Station's VM database cannot be found
+var ErrNoQVSStore = errors.New("no Virtualization Station VM database found")
+
+// QVSRegistrationError reports that a VM was defined or undefined in libvirt, but
+// Virtualization Station's record of it could not be updated. The libvirt change stands.
+type QVSRegistrationError struct {
+	VM  string
+	Err error
+}
+
+func (e *QVSRegistrationError) Error() string {
+	return fmt.Sprintf("Virtualization Station's record of VM '%s' was not updated: %v", e.VM, e.Err)
+}
+
+func (e *QVSRegistrationError) Unwrap() error {
+	return e.Err
+}
+
+// qvsColumn is a column of a table in Virtualization Station's database
+type qvsColumn struct {
+	Name       string
+	Type       string
+	NotNull    bool
+	HasDefault bool
+	PrimaryKey bool
+}
+
+// qvsTable is a table of Virtualization Station's database
+type qvsTable struct {
+	DB      string
+	Name    string
+	Columns []qvsColumn
+}
+
+// column returns the table's column named name, ignoring case
+func (t *qvsTable) column(name string) *qvsColumn {
+	for i := range t.Columns {
+		if strings.EqualFold(t.Columns[i].Name, name) {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+// holdsVMs reports whether the table looks like Virtualization Station's VM list
+func (t *qvsTable) holdsVMs() bool {
+	name := strings.ToLower(t.Name)
+	named := name == "vm" || name == "vms" || strings.HasSuffix(name, "_vm") || strings.HasSuffix(name, "_vms")
+	return named && t.column("uuid") != nil && t.column("name") != nil
+}
+
+// parseQVSStores parses the output of qvsStoreScript into the tables it lists
+func parseQVSStores(output string) (tables []qvsTable, sqlite bool) {
+	sqlite = true
+	var db string
+	for _, line := range strings.Split(output, "\n") {
+		kind, value, _ := strings.Cut(strings.TrimRight(line, "\r"), "\t")
+		switch kind {
+		case "nosqlite":
+			sqlite = false
+		case "db":
+			db = value
+		case "table":
+			tables = append(tables, qvsTable{DB: db, Name: value})
+		case "column":
+			if len(tables) == 0 {
+				continue
+			}
+			if column, ok := parseQVSColumn(value); ok {
+				table := &tables[len(tables)-1]
+				table.Columns = append(table.Columns, column)
+			}
+		}
+	}
+	return tables, sqlite
+}
+
+// parseQVSColumn parses a PRAGMA table_info row: cid|name|type|notnull|dflt_value|pk.
+// The default value may itself contain '|'.
+func parseQVSColumn(row string) (qvsColumn, bool) {
+	fields := strings.Split(row, "|")
+	if len(fields) < 6 {
+		return qvsColumn{}, false
+	}
+	last := len(fields) - 1
+	return qvsColumn{
+		Name:       fields[1],
+		Type:       strings.ToUpper(fields[2]),
+		NotNull:    fields[3] == "1",
+		HasDefault: strings.Join(fields[4:last], "|") != "",
+		PrimaryKey: fields[last] != "0",
+	}, true
+}
+
+// sqlString quotes s as an SQL string literal
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// sqlIdent quotes s as an SQL identifier
+func sqlIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// qvsValue returns the SQL value of column for vm, or "" to leave the column to its
+// default. Columns qnap-vm knows nothing about get an empty value of their type only
+// when the table requires one.
+func qvsValue(column qvsColumn, vm *VMInfo, now time.Time) string {
+	switch strings.ToLower(column.Name) {
+	case "uuid":
+		return sqlString(vm.UUID)
+	case "name":
+		return sqlString(vm.Name)
+	case "description", "title":
+		return sqlString(vm.Title)
+	case "memory", "mem":
+		return strconv.Itoa(vm.Memory)
+	case "cores", "vcpu", "vcpus", "cpus":
+		return strconv.Itoa(vm.CPUs)
+	}
+	if column.PrimaryKey && strings.Contains(column.Type, "INT") {
+		return "" // SQLite assigns the row ID
+	}
+	if !column.NotNull || column.HasDefault {
+		return ""
+	}
+	switch {
+	case strings.Contains(column.Type, "DATE") || strings.Contains(column.Type, "TIME"):
+		return sqlString(now.UTC().Format("2006-01-02 15:04:05"))
+	case strings.Contains(column.Type, "INT") || strings.Contains(column.Type, "BOOL") ||
+		strings.Contains(column.Type, "REAL") || strings.Contains(column.Type, "NUM") ||
+		strings.Contains(column.Type, "DEC"):
+		return "0"
+	default:
+		return "''"
+	}
+}
+
+// insertSQL returns the statement adding vm to the table, unless a row with its UUID
+// is already there
+func (t *qvsTable) insertSQL(vm *VMInfo, now time.Time) string {
+	var names, values []string
+	for _, column := range t.Columns {
+		if value := qvsValue(column, vm, now); value != "" {
+			names = append(names, sqlIdent(column.Name))
+			values = append(values, value)
+		}
+	}
+	table := sqlIdent(t.Name)
+	return fmt.Sprintf("INSERT INTO %s (%s) SELECT %s WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s = %s);",
+		table, strings.Join(names, ", "), strings.Join(values, ", "),
+		table, sqlIdent(t.column("uuid").Name), sqlString(vm.UUID))
+}
+
+// deleteSQL returns the statement removing the VM with the given UUID from the table
+func (t *qvsTable) deleteSQL(uuid string) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE %s = %s;", sqlIdent(t.Name), sqlIdent(t.column("uuid").Name), sqlString(uuid))
+}
+
+// findQVSTable locates the table Virtualization Station keeps its VMs in
+func (c *Client) findQVSTable() (*qvsTable, error) {
+	script := strings.ReplaceAll(qvsStoreScript, "{{QVS}}", ssh.Quote(c.qvsPath))
+	output, err := c.execVirshScript(script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look for Virtualization Station's VM database: %w", err)
+	}
+	tables, sqlite := parseQVSStores(output)
+	if !sqlite {
+		return nil, fmt.Errorf("%w: sqlite3 is not available on the NAS", ErrNoQVSStore)
+	}
+	for i := range tables {
+		if tables[i].holdsVMs() {
+			return &tables[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w under %s", ErrNoQVSStore, c.qvsPath)
+}
+
+// qvsBackupSuffix is appended to the name of the copy of Virtualization Station's
+// database saved before each change
+const qvsBackupSuffix = ".qnap-vm.bak"
+
+// qvsSQLCommand returns the shell command saving a copy of the database holding table
+// and then running statement against it
+func qvsSQLCommand(table *qvsTable, statement string) string {
+	db := ssh.Quote(table.DB)
+	return fmt.Sprintf("cp -p %s %s && sqlite3 %s %s", db, ssh.Quote(table.DB+qvsBackupSuffix), db, ssh.Quote(statement))
+}
+
+// SetQVSRegistration sets whether VMs the client creates, clones and deletes are added
+// to and removed from Virtualization Station's VM database
+func (c *Client) SetQVSRegistration(enabled bool) {
+	c.registerQVS = enabled
+}
+
+// execQVSSQL runs statement against the database holding table, once a copy of it is saved
+func (c *Client) execQVSSQL(table *qvsTable, statement string) error {
+	output, err := c.execVirshScript(qvsSQLCommand(table, statement))
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w\nOutput: %s", table.DB, err, strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// registerWithQVS adds the VM named name to Virtualization Station's VM database, when
+// enabled. A NAS without the database is skipped.
+func (c *Client) registerWithQVS(name string) error {
+	if !c.registerQVS {
+		return nil
+	}
+	vm, err := c.GetVMDetails(name)
+	if err != nil {
+		return err
+	}
+	if vm.UUID == "" {
+		return fmt.Errorf("failed to read the UUID of VM '%s'", name)
+	}
+	table, err := c.findQVSTable()
+	if errors.Is(err, ErrNoQVSStore) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.execQVSSQL(table, table.insertSQL(vm, time.Now()))
+}
+
+// unregisterFromQVS removes the VM with the given UUID from Virtualization Station's VM
+// database, when enabled. A VM it never listed, or a NAS without the database, is not
+// an error.
+func (c *Client) unregisterFromQVS(uuid string) error {
+	if !c.registerQVS {
+		return nil
+	}
+	table, err := c.findQVSTable()
+	if errors.Is(err, ErrNoQVSStore) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.execQVSSQL(table, table.deleteSQL(uuid))
+}
@@ -0,0 +1,95 @@
+package virsh
+
+import (
+	"testing"
+	"time"
+)
+
+const qvsStoreOutput = `db	/QVS/var/lib/qvs/audit.db
+table	events
+column	0|id|integer|1||1
+column	1|uuid|varchar(36)|1||0
+column	2|name|varchar(64)|1||0
+db	/QVS/var/lib/qvs/qvs.db
+table	qvs_snapshot
+column	0|id|integer|1||1
+column	1|uuid|char(32)|1||0
+column	2|name|varchar(255)|1||0
+table	qvs_vm
+column	0|id|integer|1||1
+column	1|uuid|char(32)|1||0
+column	2|name|varchar(255)|1||0
+column	3|description|text|0||0
+column	4|memory|integer|1||0
+column	5|cores|integer|1|1|0
+column	6|autostart|bool|1||0
+column	7|created|datetime|1||0
+column	8|os_type|varchar(32)|1||0
+column	9|note|text|0|'a|b'|0
+`
+
+func TestParseQVSStores(t *testing.T) {
+	tables, sqlite := parseQVSStores(qvsStoreOutput)
+	if !sqlite || len(tables) != 3 {
+		t.Fatalf("parseQVSStores = %+v, %v", tables, sqlite)
+	}
+	vms := tables[2]
+	if vms.DB != "/QVS/var/lib/qvs/qvs.db" || vms.Name != "qvs_vm" || len(vms.Columns) != 10 {
+		t.Fatalf("table = %+v", vms)
+	}
+	if note := vms.Columns[9]; note.Name != "note" || !note.HasDefault || note.PrimaryKey {
+		t.Errorf("column with '|' in its default = %+v", note)
+	}
+
+	var found []string
+	for _, table := range tables {
+		if table.holdsVMs() {
+			found = append(found, table.Name)
+		}
+	}
+	if len(found) != 1 || found[0] != "qvs_vm" {
+		t.Errorf("tables holding VMs = %v, want [qvs_vm]", found)
+	}
+
+	if _, sqlite := parseQVSStores("nosqlite\n"); sqlite {
+		t.Error("parseQVSStores missed that sqlite3 is not installed")
+	}
+}
+
+func TestQVSSQL(t *testing.T) {
+	tables, _ := parseQVSStores(qvsStoreOutput)
+	table := tables[2]
+	vm := &VMInfo{Name: "bob's vm", UUID: "4a1c", Memory: 2048, CPUs: 2, Title: "web"}
+	now := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+
+	want := `INSERT INTO "qvs_vm" ("uuid", "name", "description", "memory", "cores", "autostart", "created", "os_type") ` +
+		`SELECT '4a1c', 'bob''s vm', 'web', 2048, 2, 0, '2026-10-18 09:30:00', '' ` +
+		`WHERE NOT EXISTS (SELECT 1 FROM "qvs_vm" WHERE "uuid" = '4a1c');`
+	if got := table.insertSQL(vm, now); got != want {
+		t.Errorf("insertSQL =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := table.deleteSQL("4a1c"); got != `DELETE FROM "qvs_vm" WHERE "uuid" = '4a1c';` {
+		t.Errorf("deleteSQL = %s", got)
+	}
+}
+
+func TestQVSSQLCommand(t *testing.T) {
+	table := &qvsTable{DB: "/QVS/var/lib/qvs/qvs.db", Name: "qvs_vm"}
+	want := `cp -p '/QVS/var/lib/qvs/qvs.db' '/QVS/var/lib/qvs/qvs.db.qnap-vm.bak' && ` +
+		`sqlite3 '/QVS/var/lib/qvs/qvs.db' 'DELETE FROM x;'`
+	if got := qvsSQLCommand(table, "DELETE FROM x;"); got != want {
+		t.Errorf("qvsSQLCommand =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestQVSRegistrationDisabled(t *testing.T) {
+	// Without SetQVSRegistration nothing is looked up on the NAS
+	c := &Client{}
+	if err := c.registerWithQVS("web"); err != nil {
+		t.Errorf("registerWithQVS() = %v, want nil when disabled", err)
+	}
+	if err := c.unregisterFromQVS("4a1c"); err != nil {
+		t.Errorf("unregisterFromQVS() = %v, want nil when disabled", err)
+	}
+}
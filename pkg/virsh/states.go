@@ -0,0 +1,41 @@
+package virsh
+
+import (
+	"fmt"
+	"strings"
+)
+
+// statesScript prints every domain's state with the reason for it, one tab-separated
+// line per domain, in a single SSH round trip
+const statesScript = `virsh list --all --name | while IFS= read -r name; do
+	[ -n "$name" ] || continue
+	printf '%s\t%s\n' "$name" "$(virsh domstate --reason --domain "$name" 2>/dev/null)"
+done`
+
+// ListStates returns the state of every VM with its reason, such as "running (booted)"
+// or "shut off (crashed)", keyed by VM name
+func (c *Client) ListStates() (map[string]string, error) {
+	output, err := c.execVirshScript(statesScript)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read VM states: %w", err)
+	}
+	return parseStates(output), nil
+}
+
+// parseStates parses the output of statesScript
+func parseStates(output string) map[string]string {
+	states := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		name, state, ok := strings.Cut(strings.TrimRight(line, "\r"), "\t")
+		if ok && name != "" {
+			states[name] = strings.TrimSpace(state)
+		}
+	}
+	return states
+}
+
+// IsCrashState reports whether a state from ListStates means the guest crashed: QEMU
+// died, or the guest panicked and libvirt stopped or paused it
+func IsCrashState(state string) bool {
+	return strings.HasPrefix(state, "crashed") || strings.Contains(state, "(crashed)") || strings.Contains(state, "(panicked)")
+}
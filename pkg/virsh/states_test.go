@@ -0,0 +1,20 @@
+package virsh
+
+import "testing"
+
+func TestParseStates(t *testing.T) {
+	output := "web\trunning (booted)\ndb\tshut off (crashed)\nwin11\tpaused (panicked)\nnew vm\tshut off (unknown)\n\n"
+	states := parseStates(output)
+	if len(states) != 4 || states["new vm"] != "shut off (unknown)" {
+		t.Fatalf("parseStates = %v", states)
+	}
+
+	for name, crashed := range map[string]bool{"web": false, "db": true, "win11": true, "new vm": false} {
+		if got := IsCrashState(states[name]); got != crashed {
+			t.Errorf("IsCrashState(%q) = %v, want %v", states[name], got, crashed)
+		}
+	}
+	if !IsCrashState("crashed (panicked)") || IsCrashState("shut off (shutdown)") {
+		t.Error("IsCrashState misread a libvirt crash state")
+	}
+}
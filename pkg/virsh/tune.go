@@ -0,0 +1,347 @@
+package virsh
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/scttfrdmn/qnap-vm/pkg/ssh"
+)
+
+// CPUPin pins one vCPU of a VM to a set of host CPUs
+type CPUPin struct {
+	VCPU   int
+	CPUSet string // libvirt cpuset syntax, e.g. 2-3,6 or 0-7,^1
+}
+
+// DomainCPUTune represents the <cputune> element
+type DomainCPUTune struct {
+	VCPUPin []DomainVCPUPin `xml:"vcpupin"`
+}
+
+// DomainVCPUPin represents a <cputune><vcpupin> entry
+type DomainVCPUPin struct {
+	VCPU   int    `xml:"vcpu,attr"`
+	CPUSet string `xml:"cpuset,attr"`
+}
+
+// CPUTopology is the guest-visible layout of a VM's vCPUs
+type CPUTopology struct {
+	Sockets int
+	Cores   int // Per socket
+	Threads int // Per core
+}
+
+// VCPUs returns the number of vCPUs the topology describes
+func (t CPUTopology) VCPUs() int {
+	return t.Sockets * t.Cores * t.Threads
+}
+
+// CPU modes for VMConfig.CPUModel besides named QEMU models
+const (
+	CPUModeHostPassthrough = "host-passthrough" // Expose the NAS's CPU as is
+	CPUModeHostModel       = "host-model"       // Closest named model plus the NAS's extra features
+)
+
+var cpuModelPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// DomainCPU represents the <cpu> element
+type DomainCPU struct {
+	Mode     string             `xml:"mode,attr,omitempty"`
+	Match    string             `xml:"match,attr,omitempty"`
+	Model    *DomainCPUModel    `xml:"model,omitempty"`
+	Topology *DomainCPUTopology `xml:"topology,omitempty"`
+	Features []DomainCPUFeature `xml:"feature"`
+	NUMA     *DomainNUMA        `xml:"numa,omitempty"`
+}
+
+// DomainCPUModel represents a <cpu><model> element naming a QEMU CPU model
+type DomainCPUModel struct {
+	Fallback string `xml:"fallback,attr,omitempty"`
+	Value    string `xml:",chardata"`
+}
+
+// DomainCPUFeature represents a <cpu><feature> element
+type DomainCPUFeature struct {
+	Policy string `xml:"policy,attr"`
+	Name   string `xml:"name,attr"`
+}
+
+// DomainCPUTopology represents a <cpu><topology> element
+type DomainCPUTopology struct {
+	Sockets int `xml:"sockets,attr"`
+	Cores   int `xml:"cores,attr"`
+	Threads int `xml:"threads,attr"`
+}
+
+// ParseCPUTopology parses a topology such as "sockets=1,cores=4,threads=2". Omitted
+// counts are 1.
+func ParseCPUTopology(spec string) (CPUTopology, error) {
+	topology := CPUTopology{Sockets: 1, Cores: 1, Threads: 1}
+	for _, part := range strings.Split(spec, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return CPUTopology{}, fmt.Errorf("invalid CPU topology '%s' (use e.g. sockets=1,cores=4,threads=2)", spec)
+		}
+		switch key {
+		case "sockets":
+			topology.Sockets = n
+		case "cores":
+			topology.Cores = n
+		case "threads":
+			topology.Threads = n
+		default:
+			return CPUTopology{}, fmt.Errorf("unknown CPU topology field '%s' (use sockets, cores and threads)", key)
+		}
+	}
+	return topology, nil
+}
+
+// ValidateCPUModel checks a --cpu-model value: host-passthrough, host-model or the name
+// of a QEMU CPU model such as Skylake-Client
+func ValidateCPUModel(model string) error {
+	if !cpuModelPattern.MatchString(model) {
+		return fmt.Errorf("invalid CPU model '%s' (use host-passthrough, host-model or a QEMU model name such as Skylake-Client)", model)
+	}
+	return nil
+}
+
+// HostCPUModel reports whether model exposes the NAS's own CPU, which needs KVM
+func HostCPUModel(model string) bool {
+	return model == CPUModeHostPassthrough || model == CPUModeHostModel
+}
+
+// newDomainCPU returns the <cpu> element for a VM's CPU model, features, topology and
+// NUMA cells, or nil when all are left to QEMU
+func newDomainCPU(config VMConfig) *DomainCPU {
+	numa := newDomainNUMA(config.NUMA, config.CPUs, config.Memory)
+	if config.CPUModel == "" && len(config.CPUFeatures) == 0 && config.CPUTopology == nil && numa == nil {
+		return nil
+	}
+
+	cpu := &DomainCPU{}
+	switch config.CPUModel {
+	case "":
+	case CPUModeHostPassthrough, CPUModeHostModel:
+		cpu.Mode = config.CPUModel
+	default:
+		cpu.Mode = "custom"
+		cpu.Match = "exact"
+		cpu.Model = &DomainCPUModel{Fallback: "allow", Value: config.CPUModel}
+	}
+	if t := config.CPUTopology; t != nil {
+		cpu.Topology = &DomainCPUTopology{Sockets: t.Sockets, Cores: t.Cores, Threads: t.Threads}
+	}
+	for _, feature := range config.CPUFeatures {
+		cpu.Features = append(cpu.Features, DomainCPUFeature{Policy: "require", Name: feature})
+	}
+	cpu.NUMA = numa
+	return cpu
+}
+
+// NestedSupport describes nested virtualization on the NAS
+type NestedSupport struct {
+	Module  string // KVM module, kvm_intel or kvm_amd
+	Feature string // CPU feature guests need to run KVM themselves, vmx or svm
+	Enabled bool
+}
+
+// NestedVirtualization reports whether the NAS's KVM module lets guests run their own
+// hypervisors
+func (c *Client) NestedVirtualization() (*NestedSupport, error) {
+	output, err := c.sshClient.ExecuteContext(c.commandContext(), `for m in kvm_intel kvm_amd; do f=/sys/module/$m/parameters/nested; [ -r $f ] && echo "$m $(cat $f)"; done; true`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check nested virtualization: %w\nOutput: %s", err, output)
+	}
+	return parseNestedSupport(output)
+}
+
+// parseNestedSupport parses "module value" lines of the KVM modules' nested parameter
+func parseNestedSupport(output string) (*NestedSupport, error) {
+	for _, line := range strings.Split(output, "\n") {
+		module, value, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		support := &NestedSupport{Module: module, Enabled: value == "Y" || value == "1"}
+		switch module {
+		case "kvm_intel":
+			support.Feature = "vmx"
+		case "kvm_amd":
+			support.Feature = "svm"
+		default:
+			continue
+		}
+		return support, nil
+	}
+	return nil, fmt.Errorf("KVM is not loaded on the NAS; enable Virtualization Station first")
+}
+
+// EnableNestedVirtualization reloads the NAS's KVM module with nesting on, which affects
+// every VM on the NAS: callers check NestedVirtualization first and stop if any VM is
+// running. It lasts until the NAS restarts.
+func (c *Client) EnableNestedVirtualization(support *NestedSupport) error {
+	output, err := c.sshClient.ExecuteContext(c.commandContext(), fmt.Sprintf("modprobe -r %s && modprobe %s nested=1", support.Module, support.Module))
+	if err != nil {
+		return fmt.Errorf("failed to enable nested virtualization (stop all running VMs and try again): %w\nOutput: %s", err, output)
+	}
+	support.Enabled = true
+	return nil
+}
+
+// ParseCPUSet parses a libvirt cpuset such as "2-3,6" or "0-7,^1" and returns the host
+// CPUs it selects, in ascending order
+func ParseCPUSet(cpuset string) ([]int, error) {
+	selected := make(map[int]bool)
+	for _, part := range strings.Split(cpuset, ",") {
+		part = strings.TrimSpace(part)
+		exclude := strings.HasPrefix(part, "^")
+		part = strings.TrimPrefix(part, "^")
+
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid cpuset '%s' (use e.g. 2-3,6 or 0-7,^1)", cpuset)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil || end < start || exclude {
+				return nil, fmt.Errorf("invalid cpuset '%s' (use e.g. 2-3,6 or 0-7,^1)", cpuset)
+			}
+		}
+
+		for cpu := start; cpu <= end; cpu++ {
+			selected[cpu] = !exclude
+		}
+	}
+
+	var cpus []int
+	for cpu, ok := range selected {
+		if ok {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("cpuset '%s' selects no CPUs", cpuset)
+	}
+	slices.Sort(cpus)
+	return cpus, nil
+}
+
+// ParseCPUPin parses a VCPU:CPUSET pin such as "0:2" or "1:4-5"
+func ParseCPUPin(spec string) (CPUPin, error) {
+	vcpu, cpuset, ok := strings.Cut(spec, ":")
+	n, err := strconv.Atoi(vcpu)
+	if !ok || err != nil || n < 0 {
+		return CPUPin{}, fmt.Errorf("invalid CPU pin '%s' (use VCPU:CPUSET, e.g. 0:2 or 1:4-5)", spec)
+	}
+	if _, err := ParseCPUSet(cpuset); err != nil {
+		return CPUPin{}, err
+	}
+	return CPUPin{VCPU: n, CPUSet: cpuset}, nil
+}
+
+// ValidateCPUPins checks pins against a VM's vCPU count and, when known (non-zero), the
+// number of host CPUs
+func ValidateCPUPins(pins []CPUPin, vcpus, hostCPUs int) error {
+	seen := make(map[int]bool)
+	for _, pin := range pins {
+		if pin.VCPU >= vcpus {
+			return fmt.Errorf("vCPU %d does not exist; the VM has %d vCPUs (0-%d)", pin.VCPU, vcpus, vcpus-1)
+		}
+		if seen[pin.VCPU] {
+			return fmt.Errorf("vCPU %d is pinned more than once", pin.VCPU)
+		}
+		seen[pin.VCPU] = true
+
+		cpus, err := ParseCPUSet(pin.CPUSet)
+		if err != nil {
+			return err
+		}
+		if hostCPUs > 0 && cpus[len(cpus)-1] >= hostCPUs {
+			return fmt.Errorf("host CPU %d does not exist; the NAS has %d CPUs (0-%d)", cpus[len(cpus)-1], hostCPUs, hostCPUs-1)
+		}
+	}
+	return nil
+}
+
+// newCPUTune returns the <cputune> element for pins, or nil without pins
+func newCPUTune(pins []CPUPin) *DomainCPUTune {
+	if len(pins) == 0 {
+		return nil
+	}
+	tune := &DomainCPUTune{}
+	for _, pin := range pins {
+		tune.VCPUPin = append(tune.VCPUPin, DomainVCPUPin{VCPU: pin.VCPU, CPUSet: pin.CPUSet})
+	}
+	return tune
+}
+
+// HostCPUCount returns the number of logical CPUs of the NAS
+func (c *Client) HostCPUCount() (int, error) {
+	output, err := c.execVirsh("nodeinfo")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read host CPU information: %w\nOutput: %s", err, output)
+	}
+	return nodeInfoCount(output, "CPU(s):")
+}
+
+// nodeInfoCount returns the number on the 'virsh nodeinfo' line starting with label
+func nodeInfoCount(output, label string) (int, error) {
+	for _, line := range strings.Split(output, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), label); ok {
+			if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+				return n, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("%s not found in nodeinfo output", strings.TrimSuffix(label, ":"))
+}
+
+// VCPUPins returns the host CPUs each vCPU of a VM may run on. live reads the running
+// VM's pinning; otherwise its saved configuration's.
+func (c *Client) VCPUPins(vmName string, live bool) ([]CPUPin, error) {
+	scope := "--config"
+	if live {
+		scope = "--live"
+	}
+	output, err := c.execVirsh(fmt.Sprintf("vcpupin %s %s", domainArg(vmName), scope))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vCPU pinning of VM '%s': %w\nOutput: %s", vmName, err, output)
+	}
+	return parseVCPUPins(output), nil
+}
+
+// parseVCPUPins parses 'virsh vcpupin' output, either " 0      2-3" rows or the older
+// "   0: 2-3" form
+func parseVCPUPins(output string) []CPUPin {
+	var pins []CPUPin
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		vcpu, err := strconv.Atoi(strings.TrimSuffix(fields[0], ":"))
+		if err != nil {
+			continue
+		}
+		pins = append(pins, CPUPin{VCPU: vcpu, CPUSet: fields[1]})
+	}
+	return pins
+}
+
+// PinVCPU pins a vCPU of a VM in its saved configuration and, with live, in the running VM
+func (c *Client) PinVCPU(vmName string, pin CPUPin, live bool) error {
+	cmd := fmt.Sprintf("vcpupin %s --vcpu %d --cpulist %s --config", domainArg(vmName), pin.VCPU, ssh.Quote(pin.CPUSet))
+	if live {
+		cmd += " --live"
+	}
+	output, err := c.execVirsh(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to pin vCPU %d of VM '%s': %w\nOutput: %s", pin.VCPU, vmName, err, output)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package virsh
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCPUSet(t *testing.T) {
+	tests := []struct {
+		cpuset string
+		want   []int
+	}{
+		{"2", []int{2}},
+		{"2-3,6", []int{2, 3, 6}},
+		{"0-5,^1,^3", []int{0, 2, 4, 5}},
+	}
+	for _, tt := range tests {
+		got, err := ParseCPUSet(tt.cpuset)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseCPUSet(%q) = %v, %v; want %v", tt.cpuset, got, err, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{"", "a", "3-1", "-2", "^1", "0,^0", "1-^2"} {
+		if _, err := ParseCPUSet(invalid); err == nil {
+			t.Errorf("ParseCPUSet(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestCPUPins(t *testing.T) {
+	pin, err := ParseCPUPin("1:4-5")
+	if err != nil || pin != (CPUPin{VCPU: 1, CPUSet: "4-5"}) {
+		t.Errorf("ParseCPUPin() = %+v, %v", pin, err)
+	}
+	for _, invalid := range []string{"1", "x:2", "1:", "-1:2"} {
+		if _, err := ParseCPUPin(invalid); err == nil {
+			t.Errorf("ParseCPUPin(%q) should fail", invalid)
+		}
+	}
+
+	pins := []CPUPin{{VCPU: 0, CPUSet: "2"}, {VCPU: 1, CPUSet: "3"}}
+	if err := ValidateCPUPins(pins, 2, 4); err != nil {
+		t.Errorf("ValidateCPUPins() error: %v", err)
+	}
+	if err := ValidateCPUPins(pins, 1, 4); err == nil {
+		t.Error("ValidateCPUPins() should reject a vCPU the VM does not have")
+	}
+	if err := ValidateCPUPins(pins, 2, 3); err == nil {
+		t.Error("ValidateCPUPins() should reject a host CPU the NAS does not have")
+	}
+	if err := ValidateCPUPins(append(pins, CPUPin{VCPU: 0, CPUSet: "1"}), 2, 0); err == nil {
+		t.Error("ValidateCPUPins() should reject pinning a vCPU twice")
+	}
+}
+
+func TestGenerateDomainXMLCPUPins(t *testing.T) {
+	client := &Client{}
+	config := VMConfig{
+		Memory:  1024,
+		CPUs:    2,
+		CPUPins: []CPUPin{{VCPU: 0, CPUSet: "2"}, {VCPU: 1, CPUSet: "3-4"}},
+	}
+
+	xml, err := client.generateDomainXML("pinned", config)
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	want := `<cputune>
+    <vcpupin vcpu="0" cpuset="2"></vcpupin>
+    <vcpupin vcpu="1" cpuset="3-4"></vcpupin>
+  </cputune>`
+	if !strings.Contains(xml, want) {
+		t.Errorf("Generated XML missing CPU pinning:\n%s", xml)
+	}
+
+	// Without pins there is no <cputune>
+	xml, err = client.generateDomainXML("unpinned", VMConfig{Memory: 1024, CPUs: 2})
+	if err != nil || strings.Contains(xml, "cputune") {
+		t.Errorf("Generated XML has <cputune> without pins: %v\n%s", err, xml)
+	}
+}
+
+func TestParseVCPUPins(t *testing.T) {
+	current := ` VCPU   CPU Affinity
+----------------------
+ 0      2
+ 1      0-7
+`
+	older := `VCPU: CPU Affinity
+----------------------------------
+   0: 2
+   1: 0-7
+`
+	want := []CPUPin{{VCPU: 0, CPUSet: "2"}, {VCPU: 1, CPUSet: "0-7"}}
+	for _, output := range []string{current, older} {
+		if got := parseVCPUPins(output); !reflect.DeepEqual(got, want) {
+			t.Errorf("parseVCPUPins() = %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestParseCPUTopology(t *testing.T) {
+	got, err := ParseCPUTopology("sockets=1,cores=4,threads=2")
+	if err != nil || got != (CPUTopology{Sockets: 1, Cores: 4, Threads: 2}) || got.VCPUs() != 8 {
+		t.Errorf("ParseCPUTopology() = %+v, %v", got, err)
+	}
+	if got, err := ParseCPUTopology("sockets=2"); err != nil || got.VCPUs() != 2 {
+		t.Errorf("ParseCPUTopology(sockets=2) = %+v, %v", got, err)
+	}
+	for _, invalid := range []string{"", "cores", "cores=0", "dies=2", "cores=x"} {
+		if _, err := ParseCPUTopology(invalid); err == nil {
+			t.Errorf("ParseCPUTopology(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestGenerateDomainXMLCPUTopology(t *testing.T) {
+	client := &Client{}
+	config := VMConfig{Memory: 1024, CPUs: 8, CPUTopology: &CPUTopology{Sockets: 1, Cores: 4, Threads: 2}}
+
+	xml, err := client.generateDomainXML("topology", config)
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	if !strings.Contains(xml, `<topology sockets="1" cores="4" threads="2"></topology>`) {
+		t.Errorf("Generated XML missing CPU topology:\n%s", xml)
+	}
+}
+
+func TestGenerateDomainXMLCPUModel(t *testing.T) {
+	client := &Client{}
+	tests := []struct {
+		name     string
+		config   VMConfig
+		contains []string
+	}{
+		{
+			name:     "host-passthrough",
+			config:   VMConfig{Memory: 1024, CPUs: 2, CPUModel: CPUModeHostPassthrough},
+			contains: []string{`<domain type="kvm">`, `<cpu mode="host-passthrough">`},
+		},
+		{
+			name:     "named model with nested feature",
+			config:   VMConfig{Memory: 1024, CPUs: 2, CPUModel: "Skylake-Client", CPUFeatures: []string{"vmx"}},
+			contains: []string{`<domain type="qemu">`, `<cpu mode="custom" match="exact">`, `<model fallback="allow">Skylake-Client</model>`, `<feature policy="require" name="vmx"></feature>`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xml, err := client.generateDomainXML("cpu", tt.config)
+			if err != nil {
+				t.Fatalf("generateDomainXML failed: %v", err)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(xml, want) {
+					t.Errorf("Generated XML missing %s:\n%s", want, xml)
+				}
+			}
+		})
+	}
+
+	if err := ValidateCPUModel("Skylake-Client; rm -rf /"); err == nil {
+		t.Error("ValidateCPUModel accepted a model with shell characters")
+	}
+}
+
+func TestParseNestedSupport(t *testing.T) {
+	support, err := parseNestedSupport("kvm_intel N\n")
+	if err != nil {
+		t.Fatalf("parseNestedSupport failed: %v", err)
+	}
+	if support.Module != "kvm_intel" || support.Feature != "vmx" || support.Enabled {
+		t.Errorf("parseNestedSupport = %+v", support)
+	}
+
+	support, err = parseNestedSupport("kvm_amd 1\n")
+	if err != nil || support.Feature != "svm" || !support.Enabled {
+		t.Errorf("parseNestedSupport = %+v, %v", support, err)
+	}
+
+	if _, err := parseNestedSupport(""); err == nil {
+		t.Error("parseNestedSupport succeeded without KVM")
+	}
+}
@@ -0,0 +1,76 @@
+package virsh
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Patterns for the devices SwitchToVirtio converts
+var (
+	diskElementPattern  = regexp.MustCompile(`(?s)<disk\b[^>]*>.*?</disk>`)
+	diskTargetPattern   = regexp.MustCompile(`<target dev=['"]([^'"]+)['"]`)
+	diskDevicePattern   = regexp.MustCompile(`^<disk\b[^>]*device=['"]disk['"]`)
+	emulatedDiskPattern = regexp.MustCompile(`<target dev=['"]([^'"]+)['"] bus=['"](sata|ide)['"]\s*/>`)
+	emulatedNICPattern  = regexp.MustCompile(`<model type=['"](e1000e?|rtl8139)['"]\s*/>`)
+	driveAddressPattern = regexp.MustCompile(`\n?[ \t]*<address type=['"]drive['"][^>]*/>`)
+)
+
+// SwitchToVirtio moves a stopped VM's SATA and IDE disks and emulated network cards
+// to virtio, once the guest has virtio drivers, and returns the changes made
+func (c *Client) SwitchToVirtio(vmName string) ([]string, error) {
+	domainXML, err := c.dumpInactiveXML(vmName)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, changes := switchToVirtio(domainXML)
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("VM '%s' has no SATA or IDE disks or emulated network cards to switch", vmName)
+	}
+	if err := c.defineXML(vmName, updated); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// switchToVirtio rewrites the emulated disks and network cards of domain XML as
+// virtio devices. Disks get new vdX targets and lose their drive addresses, which
+// only apply to SATA and IDE controllers; CD-ROM drives are left as they are.
+func switchToVirtio(domainXML string) (string, []string) {
+	targets := targetAllocator{}
+	for _, disk := range diskElementPattern.FindAllString(domainXML, -1) {
+		if match := diskTargetPattern.FindStringSubmatch(disk); match != nil {
+			targets[match[1]] = true
+		}
+	}
+
+	var changes []string
+	domainXML = diskElementPattern.ReplaceAllStringFunc(domainXML, func(disk string) string {
+		if !diskDevicePattern.MatchString(disk) {
+			return disk
+		}
+		match := emulatedDiskPattern.FindStringSubmatch(disk)
+		if match == nil {
+			return disk
+		}
+		target := targets.next("virtio", 0)
+		changes = append(changes, fmt.Sprintf("disk %s (%s) -> %s (virtio)", match[1], match[2], target))
+		disk = emulatedDiskPattern.ReplaceAllLiteralString(disk, fmt.Sprintf("<target dev='%s' bus='virtio'/>", target))
+		return driveAddressPattern.ReplaceAllLiteralString(disk, "")
+	})
+
+	domainXML = interfaceElementPattern.ReplaceAllStringFunc(domainXML, func(iface string) string {
+		match := emulatedNICPattern.FindStringSubmatch(iface)
+		if match == nil {
+			return iface
+		}
+		name := "network card"
+		if mac := interfaceMACPattern.FindStringSubmatch(iface); mac != nil {
+			name += " " + mac[1]
+		}
+		changes = append(changes, fmt.Sprintf("%s (%s) -> virtio", name, match[1]))
+		return emulatedNICPattern.ReplaceAllLiteralString(iface, "<model type='virtio'/>")
+	})
+
+	return domainXML, changes
+}
@@ -0,0 +1,99 @@
+package virsh
+
+import (
+	"strings"
+	"testing"
+)
+
+const windowsDomainXML = `<domain type='kvm'>
+  <name>win11</name>
+  <devices>
+    <disk type='file' device='disk'>
+      <driver name='qemu' type='qcow2'/>
+      <source file='/share/CACHEDEV1_DATA/.qnap-vm/disks/win11.qcow2'/>
+      <target dev='sda' bus='sata'/>
+      <address type='drive' controller='0' bus='0' target='0' unit='0'/>
+    </disk>
+    <disk type='file' device='disk'>
+      <driver name='qemu' type='qcow2'/>
+      <source file='/share/CACHEDEV1_DATA/.qnap-vm/disks/win11-disk1.qcow2'/>
+      <target dev='vda' bus='virtio'/>
+    </disk>
+    <disk type='file' device='cdrom'>
+      <driver name='qemu' type='raw'/>
+      <source file='/share/CACHEDEV1_DATA/.qnap-vm/isos/virtio-win.iso'/>
+      <target dev='sdc' bus='sata'/>
+      <readonly/>
+      <address type='drive' controller='0' bus='0' target='0' unit='2'/>
+    </disk>
+    <interface type='bridge'>
+      <mac address='52:54:00:12:34:56'/>
+      <source bridge='qvs0'/>
+      <model type='e1000'/>
+      <address type='pci' domain='0x0000' bus='0x00' slot='0x03' function='0x0'/>
+    </interface>
+  </devices>
+</domain>`
+
+func TestSwitchToVirtio(t *testing.T) {
+	updated, changes := switchToVirtio(windowsDomainXML)
+
+	wantChanges := []string{
+		"disk sda (sata) -> vdb (virtio)",
+		"network card 52:54:00:12:34:56 (e1000) -> virtio",
+	}
+	if strings.Join(changes, "\n") != strings.Join(wantChanges, "\n") {
+		t.Errorf("switchToVirtio() changes = %q, want %q", changes, wantChanges)
+	}
+
+	for _, expected := range []string{
+		`<target dev='vdb' bus='virtio'/>`,
+		`<target dev='vda' bus='virtio'/>`,
+		`<target dev='sdc' bus='sata'/>`,
+		`<model type='virtio'/>`,
+		`<address type='drive' controller='0' bus='0' target='0' unit='2'/>`,
+		`<address type='pci' domain='0x0000' bus='0x00' slot='0x03' function='0x0'/>`,
+	} {
+		if !strings.Contains(updated, expected) {
+			t.Errorf("switched XML missing %s\n%s", expected, updated)
+		}
+	}
+	for _, unexpected := range []string{`bus='0' target='0' unit='0'`, `e1000`, `dev='sda'`} {
+		if strings.Contains(updated, unexpected) {
+			t.Errorf("switched XML still contains %s\n%s", unexpected, updated)
+		}
+	}
+
+	if again, changes := switchToVirtio(updated); len(changes) != 0 || again != updated {
+		t.Errorf("switchToVirtio() on a switched VM made changes: %q", changes)
+	}
+}
+
+func TestGenerateDomainXMLWindows(t *testing.T) {
+	client := &Client{}
+	config := VMConfig{
+		Memory:    4096,
+		CPUs:      2,
+		DiskPath:  "/share/CACHEDEV1_DATA/.qnap-vm/disks/win11.qcow2",
+		DiskBus:   "sata",
+		ISOPath:   "/share/CACHEDEV1_DATA/.qnap-vm/isos/Win11.iso",
+		DriverISO: "/share/CACHEDEV1_DATA/.qnap-vm/isos/virtio-win.iso",
+		NetModel:  "e1000",
+		LocalTime: true,
+	}
+
+	xml, err := client.generateDomainXML("win11", config)
+	if err != nil {
+		t.Fatalf("generateDomainXML failed: %v", err)
+	}
+	for _, expected := range []string{
+		`<clock offset="localtime"></clock>`,
+		`<source file="/share/CACHEDEV1_DATA/.qnap-vm/isos/virtio-win.iso"></source>`,
+		`<target dev="hdd" bus="ide"></target>`,
+		`<model type="e1000"></model>`,
+	} {
+		if !strings.Contains(xml, expected) {
+			t.Errorf("Generated XML missing expected element: %s\nGenerated XML:\n%s", expected, xml)
+		}
+	}
+}
@@ -7,6 +7,8 @@ replace github.com/scttfrdmn/qnap-vm => ../..
 require github.com/scttfrdmn/qnap-vm v0.0.0-00010101000000-000000000000
 
 require (
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/pkg/sftp v1.13.7 // indirect
 	golang.org/x/crypto v0.30.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect